@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"syscall"
 
 	"github.com/clash-version/remnawave-node-go/internal/config"
@@ -18,9 +20,13 @@ import (
 var (
 	Version   = "1.0.2"
 	BuildTime = "unknown"
+	GitCommit = ""
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML, TOML or JSON config file")
+	flag.Parse()
+
 	// Initialize logger
 	log := logger.New()
 	defer log.Sync()
@@ -28,14 +34,33 @@ func main() {
 	// Load .env file
 	_ = godotenv.Load()
 
+	// Load the config file; the environment (including .env) overrides it
+	if *configFile != "" {
+		if err := config.LoadFile(*configFile); err != nil {
+			log.Fatal("Failed to load config file", "path", *configFile, "error", err)
+		}
+		log.SetLevel(logger.LevelFromEnv())
+	}
+
 	// Set node version for API responses
 	services.SetNodeVersion(Version)
+	services.SetBuildDetails(GitCommit, BuildTime)
 
+	// The banner never includes configuration values: SECRET_KEY and the
+	// certificates it carries are redacted from logs at every level anyway
 	log.Info("Starting Remnawave Node",
 		"version", Version,
 		"buildTime", BuildTime,
+		"logLevel", log.Level().String(),
 	)
 
+	// Keep the heap (node and embedded core) within a container memory limit,
+	// leaving headroom for non-heap memory, unless GOMEMLIMIT is set explicitly
+	if limit := services.DetectContainerLimits().MemoryLimit; limit > 0 && os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(limit / 10 * 9)
+		log.Info("Applied container memory limit", "limitBytes", limit)
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -60,15 +85,24 @@ func main() {
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal; SIGHUP reloads the configuration
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	select {
-	case <-quit:
-		log.Info("Shutdown signal received")
-	case <-ctx.Done():
-		log.Info("Context cancelled")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case <-hup:
+			reload(srv, *configFile, log)
+		case <-quit:
+			log.Info("Shutdown signal received")
+			break wait
+		case <-ctx.Done():
+			log.Info("Context cancelled")
+			break wait
+		}
 	}
 
 	// Graceful shutdown
@@ -78,3 +112,39 @@ func main() {
 
 	log.Info("Server stopped")
 }
+
+// reload re-reads the config file, .env (overriding values loaded earlier) and
+// the environment, then applies what can change at runtime. An invalid
+// configuration is rejected and the running one kept.
+func reload(srv *server.Server, configFile string, log *logger.Logger) {
+	log.Info("Reload signal received")
+
+	if err := reloadEnvironment(configFile, log); err != nil {
+		log.Error("Reload rejected, invalid config file", "path", configFile, "error", err)
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Error("Reload rejected, invalid configuration", "error", err)
+		return
+	}
+	if err := srv.Reload(cfg); err != nil {
+		log.Error("Reload failed", "error", err)
+	}
+}
+
+// reloadEnvironment re-applies the config file and .env to the environment.
+// The file goes first: it replaces the values it set on the last load, which
+// .env must override again, as it does at startup.
+func reloadEnvironment(configFile string, log *logger.Logger) error {
+	if configFile != "" {
+		if err := config.LoadFile(configFile); err != nil {
+			return err
+		}
+	}
+	if err := godotenv.Overload(); err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed to re-read .env", "error", err)
+	}
+	return nil
+}
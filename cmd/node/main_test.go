@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clash-version/remnawave-node-go/internal/config"
+	"github.com/clash-version/remnawave-node-go/pkg/logger"
+)
+
+func TestReloadEnvironment_DotEnvOverridesFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, key := range []string{"NODE_NAME", "NODE_REGION"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte("node:\n  name: from-file\n  region: from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadFile(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// .env now sets a value the file set at startup
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("NODE_NAME=from-dotenv\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadEnvironment(configFile, logger.New()); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("NODE_NAME") != "from-dotenv" || os.Getenv("NODE_REGION") != "from-file" {
+		t.Errorf("Expected .env to override the file, got NODE_NAME=%q NODE_REGION=%q", os.Getenv("NODE_NAME"), os.Getenv("NODE_REGION"))
+	}
+
+	// Reloading again keeps that order
+	if err := reloadEnvironment(configFile, logger.New()); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("NODE_NAME") != "from-dotenv" {
+		t.Errorf("Expected .env to keep overriding the file, got NODE_NAME=%q", os.Getenv("NODE_NAME"))
+	}
+
+	if err := os.WriteFile(configFile, []byte("node: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadEnvironment(configFile, logger.New()); err == nil {
+		t.Error("Expected an invalid config file to be rejected")
+	}
+}
@@ -0,0 +1,358 @@
+// Command nodectl manages a running node through its API, from the node host
+// or an operator's machine.
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/clash-version/remnawave-node-go/internal/services"
+	"github.com/clash-version/remnawave-node-go/pkg/client"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const usage = `Usage: nodectl [flags] <command> [arguments]
+
+Commands:
+  status            Core status and node health
+  facts             Machine-readable node description (JSON)
+  users <tag>       List the users of an inbound
+  find <query>      Find the inbounds holding a user (username, UUID or password hash)
+  logs              Show recent node logs
+  block <ip>        Block an IP
+  unblock <ip>      Unblock an IP
+  blocked           List blocked IPs
+  restart           Restart the core with the config it last started with
+
+Flags:
+`
+
+// commands maps command names to their implementation
+var commands = map[string]func(ctx context.Context, c *client.Client, args []string) error{
+	"status":  cmdStatus,
+	"facts":   cmdFacts,
+	"users":   cmdUsers,
+	"find":    cmdFind,
+	"logs":    cmdLogs,
+	"block":   cmdBlock,
+	"unblock": cmdUnblock,
+	"blocked": cmdBlocked,
+	"restart": cmdRestart,
+}
+
+func main() {
+	port := os.Getenv("NODE_PORT")
+	if port == "" {
+		port = "3000"
+	}
+
+	flags := flag.NewFlagSet("nodectl", flag.ExitOnError)
+	url := flags.String("url", envOr("NODECTL_URL", "https://127.0.0.1:"+port), "node API URL")
+	caFile := flags.String("ca", os.Getenv("NODECTL_CA"), "CA certificate verifying the node (PEM file)")
+	certFile := flags.String("cert", os.Getenv("NODECTL_CERT"), "client certificate (PEM file)")
+	keyFile := flags.String("key", os.Getenv("NODECTL_KEY"), "client certificate key (PEM file)")
+	token := flags.String("token", os.Getenv("NODECTL_TOKEN"), "JWT sent to the node")
+	jwtKeyFile := flags.String("jwt-key", os.Getenv("NODECTL_JWT_KEY"), "panel JWT private key (PEM file), signs tokens instead of -token")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of each request")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "nodectl: unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := &client.Config{URL: *url, Token: *token, Timeout: *timeout}
+	var err error
+	if cfg.CACertPEM, err = readOptional(*caFile); err == nil {
+		if cfg.ClientCertPEM, err = readOptional(*certFile); err == nil {
+			cfg.ClientKeyPEM, err = readOptional(*keyFile)
+		}
+	}
+	if err == nil && *jwtKeyFile != "" {
+		var key *rsa.PrivateKey
+		if key, err = readJWTKey(*jwtKeyFile); err == nil {
+			cfg.TokenSource = client.SignedTokens(key, 5*time.Minute)
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	c, err := client.New(cfg)
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, c, flags.Args()[1:]); err != nil {
+		fail(err)
+	}
+}
+
+func cmdStatus(ctx context.Context, c *client.Client, args []string) error {
+	if err := noArgs("status", args); err != nil {
+		return err
+	}
+	status, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+	health, err := c.HealthCheck(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{"status": status, "health": health})
+}
+
+func cmdFacts(ctx context.Context, c *client.Client, args []string) error {
+	if err := noArgs("facts", args); err != nil {
+		return err
+	}
+	facts, err := c.Facts(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(facts)
+}
+
+func cmdUsers(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("users", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "only usernames starting with this")
+	limit := flags.Int("limit", 0, "list at most this many users (0 for all)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: nodectl users [-prefix p] [-limit n] <tag>")
+	}
+
+	resp, err := c.InboundUsers(ctx, &services.GetInboundUsersRequest{Tag: flags.Arg(0), Prefix: *prefix, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+func cmdFind(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: nodectl find <query>")
+	}
+	resp, err := c.FindUser(ctx, &services.FindUserRequest{Query: args[0]})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INBOUND\tUSERNAME\tPROTOCOL")
+	for _, match := range resp.Matches {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", match.Inbound, match.Username, match.Protocol)
+	}
+	return w.Flush()
+}
+
+func cmdLogs(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	level := flags.String("level", "", "minimum level (debug, info, warn, error)")
+	limit := flags.Int("limit", 100, "entries shown at first")
+	follow := flags.Bool("f", false, "keep showing new entries")
+	interval := flags.Duration("interval", 2*time.Second, "poll interval with -f")
+	flags.Parse(args)
+
+	entries, err := c.RecentLogs(ctx, *level, *limit)
+	if err != nil {
+		return err
+	}
+	var last time.Time
+	for _, entry := range entries {
+		printLogEntry(entry.Time, entry.Level, entry.Message, entry.Fields)
+		last = entry.Time
+	}
+
+	for *follow {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+		entries, err := c.RecentLogs(ctx, *level, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Time.After(last) {
+				printLogEntry(entry.Time, entry.Level, entry.Message, entry.Fields)
+				last = entry.Time
+			}
+		}
+	}
+	return nil
+}
+
+func cmdBlock(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("block", flag.ExitOnError)
+	reason := flags.String("reason", "", "why the IP is blocked, kept with the block")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: nodectl block [-reason r] <ip>")
+	}
+
+	resp, err := c.BlockIP(ctx, &services.BlockIPRequest{IP: flags.Arg(0), Reason: *reason, Admin: adminName()})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("block failed: %s", deref(resp.Error))
+	}
+	fmt.Printf("Blocked %s\n", flags.Arg(0))
+	return nil
+}
+
+func cmdUnblock(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: nodectl unblock <ip>")
+	}
+	resp, err := c.UnblockIP(ctx, &services.UnblockIPRequest{IP: args[0], Admin: adminName()})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("unblock failed: %s", deref(resp.Error))
+	}
+	fmt.Printf("Unblocked %s\n", args[0])
+	return nil
+}
+
+func cmdBlocked(ctx context.Context, c *client.Client, args []string) error {
+	if err := noArgs("blocked", args); err != nil {
+		return err
+	}
+	resp, err := c.BlockedIPs(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tSOURCE\tBLOCKED AT\tREASON")
+	for _, entry := range resp.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.IP, entry.Source, entry.BlockedAt.Format(time.RFC3339), entry.Reason)
+	}
+	return w.Flush()
+}
+
+// cmdRestart restarts the core with the config it last started with, keeping
+// the users added and removed since.
+func cmdRestart(ctx context.Context, c *client.Client, args []string) error {
+	if err := noArgs("restart", args); err != nil {
+		return err
+	}
+	resp, err := c.Restart(ctx)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("restart failed: %s", resp.Message)
+	}
+	fmt.Printf("Core restarted (version %s)\n", resp.Version)
+	return nil
+}
+
+// printLogEntry prints a log entry on one line
+func printLogEntry(t time.Time, level, message string, fields map[string]interface{}) {
+	line := fmt.Sprintf("%s %-5s %s", t.Local().Format("2006-01-02 15:04:05.000"), strings.ToUpper(level), message)
+	if len(fields) > 0 {
+		data, _ := json.Marshal(fields)
+		line += " " + string(data)
+	}
+	fmt.Println(line)
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// noArgs rejects arguments to a command that takes none
+func noArgs(command string, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: nodectl %s", command)
+	}
+	return nil
+}
+
+// adminName identifies the operator in block audit metadata
+func adminName() string {
+	if user := envOr("USER", os.Getenv("USERNAME")); user != "" {
+		return "nodectl:" + user
+	}
+	return "nodectl"
+}
+
+// readOptional reads a file, or returns "" for an empty path
+func readOptional(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// readJWTKey reads the panel's RSA private key
+func readJWTKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT key: %w", err)
+	}
+	return key, nil
+}
+
+// envOr returns an environment variable or a default
+func envOr(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// deref returns the string a pointer points to, or "" for nil
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// fail prints an error and exits
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "nodectl: %v\n", err)
+	os.Exit(1)
+}
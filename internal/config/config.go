@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/clash-version/remnawave-node-go/pkg/crypto"
 )
@@ -11,16 +14,158 @@ import (
 // Config holds all configuration values
 type Config struct {
 	// Server settings
-	NodePort int
+	NodePort  int
+	HTTP3     bool // Also serve the API over HTTP/3 (QUIC)
+	HTTP3Port int  // UDP port of the HTTP/3 listener; NodePort by default
+
+	// Node identity reported to the panel and in metrics
+	NodeName     string
+	NodeRegion   string
+	NodeProvider string
+	NodeLabels   map[string]string
 
 	// Secret key (contains TLS certs and JWT public key)
 	SecretKey string
 
-	// Parsed payload from SECRET_KEY
+	// Parsed payload from SECRET_KEY; nil in insecure dev mode without SECRET_KEY
 	NodePayload *crypto.NodePayload
 
+	// Plain HTTP on localhost without mTLS/JWT, for local development only
+	InsecureDev bool
+
+	// Directory for persistent state
+	StateDir string
+
 	// Feature flags
 	DisableHashedSetCheck bool
+	EncryptConfigAtRest   bool
+	HashAlgorithm         string        // "sha256" (default) or "blake3"
+	HashTTL               time.Duration // Stored change-detection hashes expire after this; 0 keeps them
+
+	// Username normalizations ("lowercase", "trim") applied everywhere usernames are matched
+	UsernameNormalization []string
+
+	// Embedded Xray API settings
+	XrayAPIListen       string // Empty disables the Xray gRPC API
+	XrayAPITag          string
+	XrayPolicyLevels    []int
+	XrayStatsUsers      bool
+	XrayStatsUserOnline bool
+	XrayStatsInbounds   bool
+	XrayStatsOutbounds  bool
+	XrayMergePolicy     bool // Preserve panel-provided stats/policy, only inject missing keys
+
+	// Stats settings
+	StatsCacheTTL  time.Duration
+	StatsDeltaMode bool // Report deltas on reset instead of zeroing core counters
+
+	// Sidecar cores (hysteria2, tuic, sing-box)
+	SidecarsConfig string // Path to sidecar definitions JSON
+
+	// Node-local inbound listen overrides
+	InboundOverrides string // Path to overrides JSON
+
+	// Lifecycle hooks
+	HooksConfig string // Path to hook definitions JSON
+
+	// Mutation and block policies
+	PolicyConfig string // Path to policy definitions JSON
+
+	// Prefix of environment variables expanded in ${VAR} config placeholders; empty disables
+	ConfigTemplatePrefix string
+
+	// Leave sidecar cores running when the agent stops
+	KeepCoresOnShutdown bool
+
+	// Management API source addresses allowed in addition to mTLS; empty allows all
+	APIAllowedIPs []netip.Prefix
+
+	// Reverse proxies whose header carries the real client address; empty trusts none
+	TrustedProxies []netip.Prefix
+	RealIPHeader   string
+
+	// Request deadlines
+	APITimeout      time.Duration
+	APIStatsTimeout time.Duration
+	APIStartTimeout time.Duration
+
+	// Requests slower than this are logged with a timing breakdown (0 disables)
+	SlowRequestThreshold time.Duration
+
+	// Concurrent request caps per endpoint class (0 disables)
+	APIMaxStatsRequests int
+	APIMaxBatchRequests int
+
+	// Largest accepted request body in MB, after decompression
+	APIMaxBodyMB int64
+
+	// Temporary bans after repeated authentication failures
+	AuthLockoutThreshold int
+	AuthLockoutWindow    time.Duration
+	AuthLockoutDuration  time.Duration
+
+	// Panic reporting
+	SentryDSN      string
+	ErrorReportURL string
+
+	// Traffic metrics push (InfluxDB line protocol)
+	MetricsPushURL      string
+	MetricsPushToken    string
+	MetricsPushInterval time.Duration
+
+	// Vision blocklist sharing between nodes
+	PeerSyncListen   string
+	PeerSyncPeers    []string
+	PeerSyncSecret   string
+	PeerSyncInterval time.Duration
+
+	// Status heartbeats posted to the panel
+	HeartbeatURL      string
+	HeartbeatToken    string
+	HeartbeatInterval time.Duration
+
+	// ACME certificates for TLS inbounds
+	AcmeEnabled      bool
+	AcmeEmail        string
+	AcmeDirectoryURL string
+	AcmeHTTPPort     int
+	AcmeDNSHook      string
+	AcmeRenewBefore  time.Duration
+
+	// Background inbound prober
+	SelfTestInterval time.Duration // 0 disables
+
+	// Background core health probe
+	HealthCheckInterval time.Duration // 0 disables
+	FDWarnPercent       float64       // Open FDs as % of the limit that degrade health; 0 disables
+
+	// Clock synchronization check
+	NTPServers       []string
+	NTPCheckInterval time.Duration // 0 disables
+	ClockSkewWarn    time.Duration
+
+	// Capacity-based admission
+	CapacityInterval         time.Duration // 0 disables
+	CapacityMaxCPUPercent    float64       // 0 leaves CPU out
+	CapacityMaxMemoryPercent float64       // 0 leaves memory out
+	CapacityMaxOnlineUsers   int           // 0 leaves online users out
+	CapacityMaxBandwidthMbps float64       // 0 leaves bandwidth out
+	CapacityHardCap          bool
+
+	// Node traffic budgets
+	TrafficBudgetDailyBytes      int64 // 0 disables
+	TrafficBudgetMonthlyBytes    int64 // 0 disables
+	TrafficBudgetResetDay        int
+	TrafficBudgetWarnPercent     float64
+	TrafficBudgetCriticalPercent float64
+	TrafficBudgetStopCore        bool
+	TrafficBudgetInterval        time.Duration
+
+	// Sanitized copies of recent panel requests kept for replay
+	RequestRecorderLimit int // 0 disables
+
+	// Feature flag values from FEATURE_FLAGS_<NAME>, keyed by lowercased name
+	FeatureFlagValues map[string]bool
 }
 
 // Load reads configuration from environment variables
@@ -35,25 +180,314 @@ func Load() (*Config, error) {
 	}
 	cfg.NodePort = port
 
-	// SECRET_KEY (required)
+	// Optional HTTP/3 listener on a UDP port, NODE_PORT by default
+	cfg.HTTP3 = getEnvBool("NODE_HTTP3", false)
+	cfg.HTTP3Port = port
+	if portStr := getEnv("NODE_HTTP3_PORT", ""); portStr != "" {
+		cfg.HTTP3Port, err = strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NODE_HTTP3_PORT: %w", err)
+		}
+	}
+
+	// Node identity
+	cfg.NodeName = getEnv("NODE_NAME", "")
+	cfg.NodeRegion = getEnv("NODE_REGION", "")
+	cfg.NodeProvider = getEnv("NODE_PROVIDER", "")
+	cfg.NodeLabels, err = getEnvMap("NODE_LABELS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid NODE_LABELS: %w", err)
+	}
+
+	// Persistent state (config.json, stats, certificates)
+	cfg.StateDir = getEnv("NODE_STATE_DIR", "/var/lib/remnawave-node")
+
+	// Insecure dev mode, refused in production
+	cfg.InsecureDev = getEnvBool("NODE_INSECURE_DEV", false)
+	if cfg.InsecureDev && os.Getenv("NODE_ENV") == "production" {
+		return nil, fmt.Errorf("NODE_INSECURE_DEV cannot be used with NODE_ENV=production")
+	}
+
+	// SECRET_KEY (required, except in insecure dev mode)
 	cfg.SecretKey = os.Getenv("SECRET_KEY")
-	if cfg.SecretKey == "" {
+	if cfg.SecretKey == "" && !cfg.InsecureDev {
 		return nil, fmt.Errorf("SECRET_KEY is required")
 	}
 
 	// Parse SECRET_KEY payload
-	payload, err := crypto.ParseNodePayload(cfg.SecretKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse SECRET_KEY: %w", err)
+	if cfg.SecretKey != "" {
+		payload, err := crypto.ParseNodePayload(cfg.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SECRET_KEY: %w", err)
+		}
+		cfg.NodePayload = payload
 	}
-	cfg.NodePayload = payload
 
 	// Feature flags
 	cfg.DisableHashedSetCheck = getEnvBool("DISABLE_HASHED_SET_CHECK", false)
+	cfg.EncryptConfigAtRest = getEnvBool("ENCRYPT_CONFIG_AT_REST", false)
+	if cfg.EncryptConfigAtRest && cfg.SecretKey == "" {
+		return nil, fmt.Errorf("ENCRYPT_CONFIG_AT_REST requires SECRET_KEY")
+	}
+	cfg.HashAlgorithm = getEnv("HASH_ALGORITHM", "sha256")
+	cfg.HashTTL, err = getEnvDuration("HASH_TTL", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HASH_TTL: %w", err)
+	}
+	cfg.UsernameNormalization = getEnvList("USERNAME_NORMALIZATION")
+
+	// Embedded Xray API settings
+	cfg.XrayAPIListen = getEnv("XRAY_API_LISTEN", "")
+	cfg.XrayAPITag = getEnv("XRAY_API_TAG", "REMNAWAVE_API")
+	cfg.XrayPolicyLevels, err = getEnvIntList("XRAY_POLICY_LEVELS", []int{0})
+	if err != nil {
+		return nil, fmt.Errorf("invalid XRAY_POLICY_LEVELS: %w", err)
+	}
+	cfg.XrayStatsUsers = getEnvBool("XRAY_STATS_USERS", true)
+	cfg.XrayStatsUserOnline = getEnvBool("XRAY_STATS_USER_ONLINE", true)
+	cfg.XrayStatsInbounds = getEnvBool("XRAY_STATS_INBOUNDS", true)
+	cfg.XrayStatsOutbounds = getEnvBool("XRAY_STATS_OUTBOUNDS", true)
+	cfg.XrayMergePolicy = getEnvBool("XRAY_MERGE_POLICY", false)
+
+	// Stats settings
+	cfg.StatsCacheTTL, err = getEnvDuration("STATS_CACHE_TTL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATS_CACHE_TTL: %w", err)
+	}
+	cfg.StatsDeltaMode = getEnvBool("STATS_DELTA_MODE", false)
+
+	// Sidecar settings
+	cfg.SidecarsConfig = getEnv("SIDECARS_CONFIG", "")
+	cfg.KeepCoresOnShutdown = getEnvBool("KEEP_CORES_ON_SHUTDOWN", false)
+	cfg.InboundOverrides = getEnv("INBOUND_OVERRIDES", "")
+	cfg.HooksConfig = getEnv("HOOKS_CONFIG", "")
+	cfg.PolicyConfig = getEnv("POLICY_CONFIG", "")
+	cfg.ConfigTemplatePrefix = getEnv("CONFIG_TEMPLATE_PREFIX", "")
+
+	// API access settings
+	cfg.APIAllowedIPs, err = getEnvPrefixList("API_ALLOWED_IPS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_ALLOWED_IPS: %w", err)
+	}
+	cfg.TrustedProxies, err = getEnvPrefixList("TRUSTED_PROXIES")
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	cfg.RealIPHeader = getEnv("REAL_IP_HEADER", "X-Forwarded-For")
+	cfg.APITimeout, err = getEnvDuration("API_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_TIMEOUT: %w", err)
+	}
+	cfg.APIStatsTimeout, err = getEnvDuration("API_STATS_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_STATS_TIMEOUT: %w", err)
+	}
+	cfg.APIStartTimeout, err = getEnvDuration("API_START_TIMEOUT", 55*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_START_TIMEOUT: %w", err)
+	}
+	cfg.SlowRequestThreshold, err = getEnvDuration("SLOW_REQUEST_THRESHOLD", 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SLOW_REQUEST_THRESHOLD: %w", err)
+	}
+	cfg.APIMaxStatsRequests, err = strconv.Atoi(getEnv("API_MAX_STATS_REQUESTS", "4"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_MAX_STATS_REQUESTS: %w", err)
+	}
+	cfg.APIMaxBatchRequests, err = strconv.Atoi(getEnv("API_MAX_BATCH_REQUESTS", "2"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_MAX_BATCH_REQUESTS: %w", err)
+	}
+	cfg.APIMaxBodyMB, err = strconv.ParseInt(getEnv("API_MAX_BODY_MB", "256"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_MAX_BODY_MB: %w", err)
+	}
+	if cfg.APIMaxBodyMB <= 0 {
+		return nil, fmt.Errorf("invalid API_MAX_BODY_MB: must be positive")
+	}
+	cfg.AuthLockoutThreshold, err = strconv.Atoi(getEnv("AUTH_LOCKOUT_THRESHOLD", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_LOCKOUT_THRESHOLD: %w", err)
+	}
+	cfg.AuthLockoutWindow, err = getEnvDuration("AUTH_LOCKOUT_WINDOW", time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_LOCKOUT_WINDOW: %w", err)
+	}
+	cfg.AuthLockoutDuration, err = getEnvDuration("AUTH_LOCKOUT_DURATION", 5*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_LOCKOUT_DURATION: %w", err)
+	}
+
+	// Panic reporting settings
+	cfg.SentryDSN = getEnv("SENTRY_DSN", "")
+	cfg.ErrorReportURL = getEnv("ERROR_REPORT_URL", "")
+
+	// Metrics push settings
+	cfg.MetricsPushURL = getEnv("METRICS_PUSH_URL", "")
+	cfg.MetricsPushToken = getEnv("METRICS_PUSH_TOKEN", "")
+	cfg.MetricsPushInterval, err = getEnvDuration("METRICS_PUSH_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid METRICS_PUSH_INTERVAL: %w", err)
+	}
+
+	// Peer sync settings
+	cfg.PeerSyncListen = getEnv("PEER_SYNC_LISTEN", "")
+	cfg.PeerSyncPeers = getEnvList("PEER_SYNC_PEERS")
+	cfg.PeerSyncSecret = getEnv("PEER_SYNC_SECRET", "")
+	cfg.PeerSyncInterval, err = getEnvDuration("PEER_SYNC_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PEER_SYNC_INTERVAL: %w", err)
+	}
+
+	// Heartbeat settings
+	cfg.HeartbeatURL = getEnv("HEARTBEAT_URL", "")
+	cfg.HeartbeatToken = getEnv("HEARTBEAT_TOKEN", "")
+	cfg.HeartbeatInterval, err = getEnvDuration("HEARTBEAT_INTERVAL", 15*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEARTBEAT_INTERVAL: %w", err)
+	}
+
+	// ACME settings
+	cfg.AcmeEnabled = getEnvBool("ACME_ENABLED", false)
+	cfg.AcmeEmail = getEnv("ACME_EMAIL", "")
+	cfg.AcmeDirectoryURL = getEnv("ACME_DIRECTORY_URL", "")
+	cfg.AcmeHTTPPort, err = strconv.Atoi(getEnv("ACME_HTTP_PORT", "80"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACME_HTTP_PORT: %w", err)
+	}
+	cfg.AcmeDNSHook = getEnv("ACME_DNS_HOOK", "")
+	cfg.AcmeRenewBefore, err = getEnvDuration("ACME_RENEW_BEFORE", 30*24*time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACME_RENEW_BEFORE: %w", err)
+	}
+
+	// Inbound prober settings
+	cfg.SelfTestInterval, err = getEnvDuration("SELF_TEST_INTERVAL", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SELF_TEST_INTERVAL: %w", err)
+	}
+	cfg.HealthCheckInterval, err = getEnvDuration("HEALTH_CHECK_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEALTH_CHECK_INTERVAL: %w", err)
+	}
+	cfg.FDWarnPercent, err = strconv.ParseFloat(getEnv("FD_WARN_PERCENT", "80"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FD_WARN_PERCENT: %w", err)
+	}
+
+	// Clock check settings
+	cfg.NTPServers = getEnvList("NTP_SERVERS")
+	if len(cfg.NTPServers) == 0 {
+		cfg.NTPServers = []string{"pool.ntp.org"}
+	}
+	cfg.NTPCheckInterval, err = getEnvDuration("NTP_CHECK_INTERVAL", 15*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NTP_CHECK_INTERVAL: %w", err)
+	}
+	cfg.ClockSkewWarn, err = getEnvDuration("CLOCK_SKEW_WARN", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLOCK_SKEW_WARN: %w", err)
+	}
+
+	// Capacity settings
+	cfg.CapacityInterval, err = getEnvDuration("CAPACITY_INTERVAL", 15*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPACITY_INTERVAL: %w", err)
+	}
+	cfg.CapacityMaxCPUPercent, err = strconv.ParseFloat(getEnv("CAPACITY_MAX_CPU_PERCENT", "90"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPACITY_MAX_CPU_PERCENT: %w", err)
+	}
+	cfg.CapacityMaxMemoryPercent, err = strconv.ParseFloat(getEnv("CAPACITY_MAX_MEMORY_PERCENT", "90"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPACITY_MAX_MEMORY_PERCENT: %w", err)
+	}
+	cfg.CapacityMaxOnlineUsers, err = strconv.Atoi(getEnv("CAPACITY_MAX_ONLINE_USERS", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPACITY_MAX_ONLINE_USERS: %w", err)
+	}
+	cfg.CapacityMaxBandwidthMbps, err = strconv.ParseFloat(getEnv("CAPACITY_MAX_BANDWIDTH_MBPS", "0"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPACITY_MAX_BANDWIDTH_MBPS: %w", err)
+	}
+	cfg.CapacityHardCap = getEnvBool("CAPACITY_HARD_CAP", false)
+
+	// Traffic budgets
+	cfg.TrafficBudgetDailyBytes, err = getEnvGigabytes("TRAFFIC_BUDGET_DAILY_GB")
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_DAILY_GB: %w", err)
+	}
+	cfg.TrafficBudgetMonthlyBytes, err = getEnvGigabytes("TRAFFIC_BUDGET_MONTHLY_GB")
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_MONTHLY_GB: %w", err)
+	}
+	cfg.TrafficBudgetResetDay, err = strconv.Atoi(getEnv("TRAFFIC_BUDGET_RESET_DAY", "1"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_RESET_DAY: %w", err)
+	}
+	if cfg.TrafficBudgetResetDay < 1 || cfg.TrafficBudgetResetDay > 28 {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_RESET_DAY: must be between 1 and 28")
+	}
+	cfg.TrafficBudgetWarnPercent, err = strconv.ParseFloat(getEnv("TRAFFIC_BUDGET_WARN_PERCENT", "80"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_WARN_PERCENT: %w", err)
+	}
+	cfg.TrafficBudgetCriticalPercent, err = strconv.ParseFloat(getEnv("TRAFFIC_BUDGET_CRITICAL_PERCENT", "95"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_CRITICAL_PERCENT: %w", err)
+	}
+	cfg.TrafficBudgetStopCore = getEnvBool("TRAFFIC_BUDGET_STOP_CORE", false)
+	cfg.TrafficBudgetInterval, err = getEnvDuration("TRAFFIC_BUDGET_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_BUDGET_INTERVAL: %w", err)
+	}
+
+	// Request recorder
+	cfg.RequestRecorderLimit, err = strconv.Atoi(getEnv("REQUEST_RECORDER_LIMIT", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REQUEST_RECORDER_LIMIT: %w", err)
+	}
+	if cfg.RequestRecorderLimit < 0 {
+		return nil, fmt.Errorf("invalid REQUEST_RECORDER_LIMIT: must not be negative")
+	}
+
+	// Feature flags
+	cfg.FeatureFlagValues, err = getEnvBoolsWithPrefix("FEATURE_FLAGS_")
+	if err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
+// FeatureFlags reports which optional features are enabled, for build-info
+func (c *Config) FeatureFlags() map[string]bool {
+	return map[string]bool{
+		"encryptConfigAtRest": c.EncryptConfigAtRest,
+		"xrayApi":             c.XrayAPIListen != "",
+		"xrayMergePolicy":     c.XrayMergePolicy,
+		"statsDeltaMode":      c.StatsDeltaMode,
+		"sidecars":            c.SidecarsConfig != "",
+		"inboundOverrides":    c.InboundOverrides != "",
+		"configTemplates":     c.ConfigTemplatePrefix != "",
+		"apiIpAllowlist":      len(c.APIAllowedIPs) > 0,
+		"trustedProxies":      len(c.TrustedProxies) > 0,
+		"authLockout":         c.AuthLockoutThreshold > 0,
+		"errorReporting":      c.SentryDSN != "" || c.ErrorReportURL != "",
+		"metricsPush":         c.MetricsPushURL != "",
+		"peerSync":            c.PeerSyncSecret != "",
+		"heartbeat":           c.HeartbeatURL != "",
+		"requestRecorder":     c.RequestRecorderLimit > 0,
+		"trafficBudget":       c.TrafficBudgetDailyBytes > 0 || c.TrafficBudgetMonthlyBytes > 0,
+		"acme":                c.AcmeEnabled,
+		"selfTest":            c.SelfTestInterval > 0,
+		"healthCheck":         c.HealthCheckInterval > 0,
+		"clockCheck":          c.NTPCheckInterval > 0,
+		"capacity":            c.CapacityInterval > 0,
+		"slowRequestLog":      c.SlowRequestThreshold > 0,
+	}
+}
+
 // getEnv returns environment variable value or default
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -69,3 +503,134 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvDuration returns environment variable as duration (e.g. "500ms", "2s") or default
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	if value := os.Getenv(key); value != "" {
+		return time.ParseDuration(value)
+	}
+	return defaultValue, nil
+}
+
+// getEnvList returns a comma-separated environment variable as a string slice
+func getEnvList(key string) []string {
+	var result []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// getEnvPrefixList returns a comma-separated list of CIDRs or bare IPs as prefixes
+func getEnvPrefixList(key string) ([]netip.Prefix, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	var result []netip.Prefix
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return result, nil
+}
+
+// getEnvMap returns a comma-separated list of key=value pairs as a map
+func getEnvMap(key string) (map[string]string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	result := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", part)
+		}
+		result[k] = strings.TrimSpace(v)
+	}
+	return result, nil
+}
+
+// getEnvBoolsWithPrefix returns the boolean variables starting with prefix,
+// keyed by the lowercased rest of the name
+func getEnvBoolsWithPrefix(prefix string) (map[string]bool, error) {
+	result := make(map[string]bool)
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok || name == "" || value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		result[strings.ToLower(name)] = enabled
+	}
+	return result, nil
+}
+
+// getEnvIntList returns a comma-separated environment variable as int slice or default
+func getEnvIntList(key string, defaultValue []int) ([]int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// getEnvGigabytes returns an environment variable in decimal GB (e.g. "1000"
+// or "0.5") as bytes, or 0 if unset
+func getEnvGigabytes(key string) (int64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	gb, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if gb < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return int64(gb * 1e9), nil
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// fileKeys are the variables last set from the config file, so a reload can
+// replace or clear them while values from the real environment keep priority
+var (
+	fileMu   sync.Mutex
+	fileKeys = make(map[string]bool)
+)
+
+// LoadFile applies a YAML, TOML or JSON config file to the environment.
+// Nested keys map to the environment variable names by joining them with "_"
+// and upper-casing, so
+//
+//	api:
+//	  timeout: 30s
+//
+// sets API_TIMEOUT. Lists are joined with commas. Variables already set in the
+// environment override the file. Calling it again (e.g. on SIGHUP) replaces
+// the values the previous call set.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("unsupported config file format %q (use .yaml, .yml, .toml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file: %w", err)
+	}
+
+	values := make(map[string]string)
+	if err := flattenConfig("", raw, values); err != nil {
+		return fmt.Errorf("invalid config file: %w", err)
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	// Drop values of the previous load that the file no longer sets
+	for key := range fileKeys {
+		if _, exists := values[key]; !exists {
+			os.Unsetenv(key)
+			delete(fileKeys, key)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, set := os.LookupEnv(key); set && !fileKeys[key] {
+			continue // The environment wins
+		}
+		if err := os.Setenv(key, values[key]); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", key, err)
+		}
+		fileKeys[key] = true
+	}
+	return nil
+}
+
+// flattenConfig converts nested config values into environment variables
+func flattenConfig(prefix string, value interface{}, out map[string]string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			if err := flattenConfig(name, child, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configScalar(prefix, item)
+			if err != nil {
+				return err
+			}
+			items = append(items, s)
+		}
+		out[prefix] = strings.Join(items, ",")
+		return nil
+	default:
+		s, err := configScalar(prefix, v)
+		if err != nil {
+			return err
+		}
+		out[prefix] = s
+		return nil
+	}
+}
+
+// configScalar formats a leaf value the way it would be written in the environment
+func configScalar(key string, value interface{}) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("top-level value must be a mapping")
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("%s: nested values are not allowed inside lists", key)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
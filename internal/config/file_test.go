@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// unsetForTest unsets the variables for the test and restores them afterwards,
+// together with what LoadFile remembers about them
+func unsetForTest(t *testing.T, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Cleanup(func() {
+		fileMu.Lock()
+		defer fileMu.Unlock()
+		for _, key := range keys {
+			delete(fileKeys, key)
+		}
+	})
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFlattenConfig(t *testing.T) {
+	values := make(map[string]string)
+	err := flattenConfig("", map[string]interface{}{
+		"log-level": "debug",
+		"node": map[string]interface{}{
+			"port":   int64(3000),
+			"labels": []interface{}{"tier=premium", "dc=fra2"},
+		},
+		"acme":   map[string]interface{}{"enabled": true, "renew_before": "720h"},
+		"ratio":  0.5,
+		"unset":  nil,
+		"budget": map[string]interface{}{"daily_bytes": uint64(1 << 40)},
+	}, values)
+	if err != nil {
+		t.Fatalf("flattenConfig returned error: %v", err)
+	}
+
+	want := map[string]string{
+		"LOG_LEVEL":          "debug",
+		"NODE_PORT":          "3000",
+		"NODE_LABELS":        "tier=premium,dc=fra2",
+		"ACME_ENABLED":       "true",
+		"ACME_RENEW_BEFORE":  "720h",
+		"RATIO":              "0.5",
+		"UNSET":              "",
+		"BUDGET_DAILY_BYTES": "1099511627776",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("Unexpected variables %v", values)
+	}
+
+	for _, raw := range []interface{}{
+		[]interface{}{"a"}, // Top-level list
+		map[string]interface{}{"nodes": []interface{}{map[string]interface{}{"port": 1}}},
+		map[string]interface{}{"nodes": []interface{}{[]interface{}{"a"}}},
+	} {
+		if err := flattenConfig("", raw, make(map[string]string)); err == nil {
+			t.Errorf("Expected %v to be rejected", raw)
+		}
+	}
+}
+
+func TestLoadFile_Formats(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"config.yaml": "node:\n  name: de-fra-1\n  labels: [a=1, b=2]\n",
+		"config.yml":  "node:\n  name: de-fra-1\n  labels: [a=1, b=2]\n",
+		"config.toml": "[node]\nname = \"de-fra-1\"\nlabels = [\"a=1\", \"b=2\"]\n",
+		"config.json": `{"node": {"name": "de-fra-1", "labels": ["a=1", "b=2"]}}`,
+	} {
+		unsetForTest(t, "NODE_NAME", "NODE_LABELS")
+		if err := LoadFile(writeConfigFile(t, dir, name, content)); err != nil {
+			t.Errorf("%s: LoadFile returned error: %v", name, err)
+			continue
+		}
+		if os.Getenv("NODE_NAME") != "de-fra-1" || os.Getenv("NODE_LABELS") != "a=1,b=2" {
+			t.Errorf("%s: unexpected NODE_NAME=%q NODE_LABELS=%q", name, os.Getenv("NODE_NAME"), os.Getenv("NODE_LABELS"))
+		}
+	}
+
+	for name, content := range map[string]string{
+		"config.ini":  "node_name = x\n",
+		"config.json": `{"node": `,
+		"list.yaml":   "- a\n- b\n",
+	} {
+		if err := LoadFile(writeConfigFile(t, dir, name, content)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+	if err := LoadFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("Expected a missing file to be rejected")
+	}
+}
+
+func TestLoadFile_EnvironmentWins(t *testing.T) {
+	unsetForTest(t, "NODE_NAME", "NODE_REGION", "NODE_PROVIDER")
+	t.Setenv("NODE_REGION", "from-env")
+	dir := t.TempDir()
+
+	path := writeConfigFile(t, dir, "config.yaml", "node:\n  name: first\n  region: from-file\n  provider: hetzner\n")
+	if err := LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("NODE_NAME") != "first" || os.Getenv("NODE_REGION") != "from-env" {
+		t.Fatalf("Expected the file to fill in what the environment leaves unset, got NODE_NAME=%q NODE_REGION=%q", os.Getenv("NODE_NAME"), os.Getenv("NODE_REGION"))
+	}
+
+	// A reload replaces the file's own values and clears those it dropped
+	writeConfigFile(t, dir, "config.yaml", "node:\n  name: second\n  region: from-file\n")
+	if err := LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("NODE_NAME") != "second" || os.Getenv("NODE_REGION") != "from-env" {
+		t.Errorf("Expected the reload to update the file's values only, got NODE_NAME=%q NODE_REGION=%q", os.Getenv("NODE_NAME"), os.Getenv("NODE_REGION"))
+	}
+	if _, set := os.LookupEnv("NODE_PROVIDER"); set {
+		t.Error("Expected a value removed from the file to be unset")
+	}
+}
@@ -4,38 +4,93 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/clash-version/remnawave-node-go/pkg/logger"
 )
 
-// JWTAuth creates a JWT authentication middleware
-func JWTAuth(publicKeyPEM string, log *logger.Logger) gin.HandlerFunc {
-	// Parse the RSA public key
+// JWTKey holds the JWT verification key. It can be replaced at runtime when
+// SECRET_KEY is reloaded.
+type JWTKey struct {
+	mu  sync.RWMutex
+	key interface{}
+}
+
+// NewJWTKey parses a PEM encoded RSA public key
+func NewJWTKey(publicKeyPEM string) (*JWTKey, error) {
+	k := &JWTKey{}
+	if err := k.Set(publicKeyPEM); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Set replaces the key; the current key is kept if the new one is invalid
+func (k *JWTKey) Set(publicKeyPEM string) error {
 	publicKey, err := parseRSAPublicKey(publicKeyPEM)
 	if err != nil {
-		log.Fatal("Failed to parse JWT public key", "error", err)
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.key = publicKey
+	return nil
+}
+
+// get returns the current key
+func (k *JWTKey) get() interface{} {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.key
+}
+
+// JWTAuth creates a JWT authentication middleware. Addresses that fail
+// authentication too often are temporarily banned (lockout may be nil).
+func JWTAuth(publicKey *JWTKey, lockout *LockoutConfig, log *logger.Logger) gin.HandlerFunc {
+	failures := newAuthFailures(lockout)
+
+	// unauthorized rejects the request and counts the failure against its address
+	unauthorized := func(c *gin.Context, message string) {
+		ip := ClientIP(c)
+		if failures.fail(ip) {
+			log.Warnw("Too many failed authentication attempts, banning address",
+				"ip", ip,
+				"duration", lockout.Duration.String(),
+			)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": message,
+		})
 	}
 
 	return func(c *gin.Context) {
+		// Reject banned addresses before doing any work
+		if remaining := failures.bannedFor(ClientIP(c)); remaining > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many failed authentication attempts",
+			})
+			return
+		}
+
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header is required",
-			})
+			unauthorized(c, "Authorization header is required")
 			return
 		}
 
 		// Check Bearer prefix
 		parts := strings.SplitN(authHeader, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization header format",
-			})
+			unauthorized(c, "Invalid authorization header format")
 			return
 		}
 
@@ -47,21 +102,17 @@ func JWTAuth(publicKeyPEM string, log *logger.Logger) gin.HandlerFunc {
 			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 				return nil, errors.New("unexpected signing method")
 			}
-			return publicKey, nil
+			return publicKey.get(), nil
 		})
 
 		if err != nil {
 			log.Debug("JWT validation failed", "error", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token",
-			})
+			unauthorized(c, "Invalid token")
 			return
 		}
 
 		if !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token",
-			})
+			unauthorized(c, "Invalid token")
 			return
 		}
 
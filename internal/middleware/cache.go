@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachedResponse is a marshaled response and the data version it was built for
+type cachedResponse struct {
+	version string
+	body    []byte
+	etag    string
+}
+
+// ResponseCache keeps the marshaled JSON of endpoints whose data rarely
+// changes, such as build info or the stored config. Each entry is tagged
+// with a version derived from the data (a config hash, say): a request for a
+// different version rebuilds the entry, so entries never go stale and never
+// need to expire.
+type ResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// NewResponseCache creates an empty ResponseCache
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{entries: make(map[string]*cachedResponse)}
+}
+
+// Serve writes the response cached under key if it was built for version,
+// otherwise calls build and caches its marshaled result. Responses carry an
+// ETag, and clients sending it back in If-None-Match get 304 Not Modified.
+// Build errors are answered with 500 and not cached.
+func (rc *ResponseCache) Serve(c *gin.Context, key, version string, build func() (interface{}, error)) {
+	rc.mu.Lock()
+	entry, exists := rc.entries[key]
+	rc.mu.Unlock()
+
+	if !exists || entry.version != version {
+		value, err := build()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		body, err := json.Marshal(value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sum := sha256.Sum256(body)
+		entry = &cachedResponse{
+			version: version,
+			body:    body,
+			etag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+		}
+
+		rc.mu.Lock()
+		rc.entries[key] = entry
+		rc.mu.Unlock()
+	}
+
+	c.Header("ETag", entry.etag)
+	c.Header("Cache-Control", "no-cache")
+	if c.GetHeader("If-None-Match") == entry.etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", entry.body)
+}
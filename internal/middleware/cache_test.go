@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cache := NewResponseCache()
+	version, builds := "v1", 0
+
+	router := gin.New()
+	router.GET("/config", func(c *gin.Context) {
+		cache.Serve(c, "config", version, func() (interface{}, error) {
+			builds++
+			return gin.H{"version": version}, nil
+		})
+	})
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/config", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("")
+	second := get("")
+	if builds != 1 {
+		t.Errorf("Expected one build for an unchanged version, got %d", builds)
+	}
+	if first.Body.String() != `{"version":"v1"}` || second.Body.String() != first.Body.String() {
+		t.Errorf("Unexpected cached bodies %q and %q", first.Body.String(), second.Body.String())
+	}
+
+	etag := first.Header().Get("ETag")
+	if w := get(etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+
+	version = "v2"
+	w := get(etag)
+	if w.Code != http.StatusOK || w.Body.String() != `{"version":"v2"}` {
+		t.Errorf("Expected a rebuilt response for a new version, got %d %q", w.Code, w.Body.String())
+	}
+	if builds != 2 || w.Header().Get("ETag") == etag {
+		t.Error("Expected a new version to rebuild the entry with a new ETag")
+	}
+}
@@ -3,8 +3,10 @@ package middleware
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/clash-version/remnawave-node-go/pkg/logger"
@@ -12,35 +14,47 @@ import (
 	"github.com/klauspost/compress/zstd"
 )
 
-// Decompress is a middleware that decompresses gzip or zstd-encoded request bodies
-func Decompress(log *logger.Logger) gin.HandlerFunc {
-	// Create zstd decoder
-	zstdDecoder, err := zstd.NewReader(nil)
+// Body encodings detected by decodeBody
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+)
+
+// errBodyTooLarge is returned when a body exceeds the size limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// Decompress is a middleware that decompresses gzip or zstd-encoded request
+// bodies, detected by their magic bytes. Bodies larger than
+// maxSize bytes, compressed or decompressed, are rejected with 413, and
+// corrupt compressed bodies with 400, so decompression bombs and truncated
+// uploads never reach the handlers.
+func Decompress(maxSize int64, log *logger.Logger) gin.HandlerFunc {
+	// Create zstd decoder; its memory limit bounds the decoded size
+	zstdDecoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxSize)))
 	if err != nil {
 		log.Errorw("Failed to create zstd decoder", "error", err)
 	}
 
 	return func(c *gin.Context) {
-		if c.Request.Body == nil {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
 			c.Next()
 			return
 		}
 
 		// Read the body to check for compression magic bytes
-		bodyBytes, err := io.ReadAll(c.Request.Body)
+		bodyBytes, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSize+1))
+		c.Request.Body.Close()
 		if err != nil {
-			c.Next()
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		if int64(len(bodyBytes)) > maxSize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": errBodyTooLarge.Error()})
 			return
 		}
-		c.Request.Body.Close()
-
-		// Restore body immediately in case we don't decompress
-		c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
-		// Check for compression
 		contentEncoding := strings.ToLower(c.GetHeader("Content-Encoding"))
-		isGzip := strings.Contains(contentEncoding, "gzip")
-		isZstd := strings.Contains(contentEncoding, "zstd")
 
 		// Debug logging
 		if len(bodyBytes) > 0 {
@@ -55,51 +69,81 @@ func Decompress(log *logger.Logger) gin.HandlerFunc {
 			)
 		}
 
-		// Magic bytes detection fallback
-		if !isGzip && !isZstd && len(bodyBytes) >= 4 {
-			// GZIP magic: 0x1f 0x8b
-			if bodyBytes[0] == 0x1f && bodyBytes[1] == 0x8b {
-				isGzip = true
-			}
-			// ZSTD magic: 0x28 0xb5 0x2f 0xfd
-			if bodyBytes[0] == 0x28 && bodyBytes[1] == 0xb5 && bodyBytes[2] == 0x2f && bodyBytes[3] == 0xfd {
-				isZstd = true
-			}
+		decoded, encoding, err := decodeBody(bodyBytes, zstdDecoder, maxSize)
+		if errors.Is(err, errBodyTooLarge) {
+			log.Warnw("Decompressed request body too large", "encoding", encoding, "limit", maxSize)
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": errBodyTooLarge.Error()})
+			return
 		}
-
-		var decompressedBody []byte
-
-		if isGzip {
-			log.Infow("Decompressing GZIP body")
-			gzipReader, err := gzip.NewReader(bytes.NewReader(bodyBytes))
-			if err == nil {
-				defer gzipReader.Close()
-				decompressedBody, err = io.ReadAll(gzipReader)
-				if err != nil {
-					log.Errorw("GZIP decompression failed", "error", err)
-					// Fallback to original body if decompression fails
-				}
-			}
-		} else if isZstd && zstdDecoder != nil {
-			log.Infow("Decompressing ZSTD body")
-			decompressedBody, err = zstdDecoder.DecodeAll(bodyBytes, nil)
-			if err != nil {
-				log.Errorw("ZSTD decompression failed", "error", err)
-				// Fallback to original body if decompression fails
-			}
+		if err != nil {
+			log.Errorw("Request body decompression failed", "encoding", encoding, "error", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s body: %v", encoding, err)})
+			return
 		}
 
-		if decompressedBody != nil {
+		if encoding != encodingIdentity {
 			log.Infow("Decompression successful",
+				"encoding", encoding,
 				"original_size", len(bodyBytes),
-				"decompressed_size", len(decompressedBody))
+				"decompressed_size", len(decoded))
 
 			// Replace request body with decompressed data
-			c.Request.Body = io.NopCloser(bytes.NewReader(decompressedBody))
-			c.Request.ContentLength = int64(len(decompressedBody))
 			c.Request.Header.Del("Content-Encoding")
 		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(decoded))
+		c.Request.ContentLength = int64(len(decoded))
 
 		c.Next()
 	}
 }
+
+// decodeBody decompresses a gzip or zstd body, detected by its magic bytes
+// (gzip 1f 8b, zstd 28 b5 2f fd), and returns the detected encoding. Both
+// formats always start with their magic, so Content-Encoding is only advisory:
+// a body labelled compressed without the magic is passed through as-is, as
+// are unencoded bodies. The decoded size is limited to maxSize.
+func decodeBody(body []byte, zstdDecoder *zstd.Decoder, maxSize int64) ([]byte, string, error) {
+	encoding := encodingIdentity
+	switch {
+	case bytes.HasPrefix(body, []byte{0x1f, 0x8b}):
+		encoding = encodingGzip
+	case bytes.HasPrefix(body, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		encoding = encodingZstd
+	}
+
+	switch encoding {
+	case encodingGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, encoding, err
+		}
+		defer gzipReader.Close()
+
+		decoded, err := io.ReadAll(io.LimitReader(gzipReader, maxSize+1))
+		if err != nil {
+			return nil, encoding, err
+		}
+		if int64(len(decoded)) > maxSize {
+			return nil, encoding, errBodyTooLarge
+		}
+		return decoded, encoding, nil
+
+	case encodingZstd:
+		if zstdDecoder == nil {
+			return nil, encoding, errors.New("zstd decoder unavailable")
+		}
+		decoded, err := zstdDecoder.DecodeAll(body, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, encoding, errBodyTooLarge
+		}
+		if err != nil {
+			return nil, encoding, err
+		}
+		if int64(len(decoded)) > maxSize {
+			return nil, encoding, errBodyTooLarge
+		}
+		return decoded, encoding, nil
+	}
+
+	return body, encoding, nil
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const testMaxSize = 1 << 16
+
+func gzipBytes(t testing.TB, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t testing.TB, data []byte) []byte {
+	t.Helper()
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(data, nil)
+}
+
+func newTestZstdDecoder(t testing.TB) *zstd.Decoder {
+	t.Helper()
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(testMaxSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(decoder.Close)
+	return decoder
+}
+
+func TestDecodeBody(t *testing.T) {
+	decoder := newTestZstdDecoder(t)
+	payload := []byte(`{"xrayConfig":{"inbounds":[]}}`)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		wantErr  error
+	}{
+		{"plain", payload, encodingIdentity, nil},
+		{"gzip", gzipBytes(t, payload), encodingGzip, nil},
+		{"zstd", zstdBytes(t, payload), encodingZstd, nil},
+		{"gzip bomb", gzipBytes(t, make([]byte, testMaxSize+1)), encodingGzip, errBodyTooLarge},
+		{"zstd bomb", zstdBytes(t, make([]byte, testMaxSize+1)), encodingZstd, errBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, encoding, err := decodeBody(tt.body, decoder, testMaxSize)
+			if encoding != tt.encoding {
+				t.Errorf("Expected encoding %s, got %s", tt.encoding, encoding)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeBody returned error: %v", err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Errorf("Expected %q, got %q", payload, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeBody_Truncated(t *testing.T) {
+	decoder := newTestZstdDecoder(t)
+	payload := bytes.Repeat([]byte(`{"username":"alice"}`), 64)
+
+	for _, body := range [][]byte{gzipBytes(t, payload), zstdBytes(t, payload)} {
+		if _, encoding, err := decodeBody(body[:len(body)/2], decoder, testMaxSize); err == nil {
+			t.Errorf("Expected a truncated %s body to be rejected", encoding)
+		}
+	}
+}
+
+func FuzzDecodeBody(f *testing.F) {
+	payload := []byte(`{"data":[{"type":"vless","tag":"VLESS","username":"alice"}]}`)
+	gz := gzipBytes(f, payload)
+	zs := zstdBytes(f, payload)
+
+	f.Add(payload)
+	f.Add(gz)
+	f.Add(zs)
+	f.Add(gz[:len(gz)/2])
+	f.Add(zs[:len(zs)/2])
+	f.Add([]byte{0x1f, 0x8b})
+	f.Add([]byte{0x28, 0xb5, 0x2f, 0xfd})
+	f.Add([]byte{})
+
+	decoder := newTestZstdDecoder(f)
+	f.Fuzz(func(t *testing.T, body []byte) {
+		decoded, encoding, err := decodeBody(body, decoder, testMaxSize)
+		if err != nil {
+			return
+		}
+		if len(decoded) > testMaxSize {
+			t.Fatalf("Decoded %d bytes, over the %d limit", len(decoded), testMaxSize)
+		}
+		if encoding == encodingIdentity && !bytes.Equal(decoded, body) {
+			t.Fatal("Expected an unencoded body to pass through unchanged")
+		}
+	})
+}
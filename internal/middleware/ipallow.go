@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/clash-version/remnawave-node-go/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// IPAllowlist creates a middleware rejecting requests whose source address is
+// outside the allowed prefixes. The address is the one resolved by RealIP, so
+// forwarding headers only count when set by a trusted proxy.
+func IPAllowlist(allowed []netip.Prefix, log *logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host := ClientIP(c)
+
+		addr, err := netip.ParseAddr(host)
+		if err == nil {
+			addr = addr.Unmap()
+			for _, prefix := range allowed {
+				if prefix.Contains(addr) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		log.Warnw("Rejected request from address outside API allowlist",
+			"ip", host,
+			"path", c.Request.URL.Path,
+		)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "Forbidden",
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit creates a middleware allowing at most limit requests of a
+// class to run at once. Requests beyond it get 503 with Retry-After instead of
+// queueing behind slow core calls. A limit of 0 disables the middleware.
+func ConcurrencyLimit(limit int, retryAfter time.Duration) gin.HandlerFunc {
+	if limit <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	slots := make(chan struct{}, limit)
+	retryAfterSeconds := strconv.Itoa(int(retryAfter.Seconds()))
+
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			c.Header("Retry-After", retryAfterSeconds)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Too many concurrent requests",
+			})
+		}
+	}
+}
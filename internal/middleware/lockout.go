@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LockoutConfig controls temporary bans of addresses failing authentication
+type LockoutConfig struct {
+	Threshold int           // Failures within Window that trigger a ban; 0 disables
+	Window    time.Duration // Period over which failures are counted
+	Duration  time.Duration // How long a ban lasts
+}
+
+// authFailures tracks failed authentication attempts per source address
+type authFailures struct {
+	mu      sync.Mutex
+	cfg     LockoutConfig
+	entries map[string]*authFailureEntry
+	swept   time.Time
+}
+
+// authFailureEntry is the failure state of one address
+type authFailureEntry struct {
+	count       int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+// newAuthFailures creates a tracker, or returns nil if lockout is disabled
+func newAuthFailures(cfg *LockoutConfig) *authFailures {
+	if cfg == nil || cfg.Threshold <= 0 {
+		return nil
+	}
+	return &authFailures{
+		cfg:     *cfg,
+		entries: make(map[string]*authFailureEntry),
+	}
+}
+
+// bannedFor returns how long the address remains banned, or 0
+func (f *authFailures) bannedFor(ip string) time.Duration {
+	if f == nil {
+		return 0
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, exists := f.entries[ip]
+	if !exists {
+		return 0
+	}
+	if remaining := time.Until(entry.bannedUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// fail records a failed attempt and reports whether it started a ban
+func (f *authFailures) fail(ip string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.sweep(now)
+
+	entry, exists := f.entries[ip]
+	if !exists || now.Sub(entry.windowStart) > f.cfg.Window {
+		entry = &authFailureEntry{windowStart: now}
+		f.entries[ip] = entry
+	}
+
+	entry.count++
+	if entry.count < f.cfg.Threshold {
+		return false
+	}
+
+	entry.bannedUntil = now.Add(f.cfg.Duration)
+	entry.count = 0
+	entry.windowStart = now
+	return true
+}
+
+// sweep drops entries whose window and ban have both expired (caller must hold the lock)
+func (f *authFailures) sweep(now time.Time) {
+	if now.Sub(f.swept) < f.cfg.Window {
+		return
+	}
+	f.swept = now
+
+	for ip, entry := range f.entries {
+		if now.Sub(entry.windowStart) > f.cfg.Window && now.After(entry.bannedUntil) {
+			delete(f.entries, ip)
+		}
+	}
+}
+
+// remoteIP returns the TCP peer address of a request, ignoring forwarding
+// headers (see ClientIP)
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
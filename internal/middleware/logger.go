@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/clash-version/remnawave-node-go/pkg/errreport"
 	"github.com/clash-version/remnawave-node-go/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
@@ -66,13 +67,14 @@ func Logger(log *logger.Logger) gin.HandlerFunc {
 		}
 
 		// Get client IP
-		clientIP := c.ClientIP()
+		clientIP := ClientIP(c)
 
 		// Log request
 		if isDev {
 			// Development mode: log with request/response bodies
-			reqBodyStr := string(requestBody)
-			respBodyStr := rw.body.String()
+			// Redact before truncating, so a cut-off credential still matches
+			reqBodyStr := logger.Redact(string(requestBody))
+			respBodyStr := logger.Redact(rw.body.String())
 
 			// Truncate long bodies to avoid log spam
 			const maxBodyLen = 2048
@@ -107,8 +109,9 @@ func Logger(log *logger.Logger) gin.HandlerFunc {
 	}
 }
 
-// Recovery creates a recovery middleware
-func Recovery(log *logger.Logger) gin.HandlerFunc {
+// Recovery creates a recovery middleware. Panics are also sent to the error
+// reporter, if one is configured (reporter may be nil).
+func Recovery(log *logger.Logger, reporter *errreport.Reporter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
@@ -116,6 +119,14 @@ func Recovery(log *logger.Logger) gin.HandlerFunc {
 					"error", err,
 					"path", c.Request.URL.Path,
 				)
+				event := errreport.Capture(err, map[string]string{
+					"method":   c.Request.Method,
+					"path":     c.Request.URL.Path,
+					"clientIp": ClientIP(c),
+				})
+				// Reports leave the node, so they follow the same policy as logs
+				event.Message = logger.Redact(event.Message)
+				reporter.Send(event)
 				c.AbortWithStatus(500)
 			}
 		}()
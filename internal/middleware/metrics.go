@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiLatencyBuckets are the latency histogram upper bounds, in seconds
+var apiLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// apiMetricsKey identifies one series: route template, method and status
+type apiMetricsKey struct {
+	Route  string
+	Method string
+	Status int
+}
+
+// apiMetricsSeries accumulates the requests of one series
+type apiMetricsSeries struct {
+	count         uint64
+	buckets       []uint64 // Non-cumulative counts per latency bucket, +Inf last
+	latencySum    float64  // Seconds
+	latencyMax    float64
+	requestBytes  uint64
+	responseBytes uint64
+}
+
+// APIMetrics records the outcome, latency and payload sizes of API requests
+type APIMetrics struct {
+	mu     sync.Mutex
+	series map[apiMetricsKey]*apiMetricsSeries
+	since  time.Time
+}
+
+// NewAPIMetrics creates an empty APIMetrics
+func NewAPIMetrics() *APIMetrics {
+	return &APIMetrics{
+		series: make(map[apiMetricsKey]*apiMetricsSeries),
+		since:  time.Now(),
+	}
+}
+
+// Middleware records every request passing through it. Requests are grouped
+// by route template (e.g. /node/handler/add-user), so unmatched paths share
+// the "unmatched" route and cannot grow the series without bound.
+func (m *APIMetrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		requestBytes := c.Request.ContentLength
+		if requestBytes < 0 {
+			requestBytes = 0
+		}
+		responseBytes := c.Writer.Size()
+		if responseBytes < 0 {
+			responseBytes = 0
+		}
+
+		m.observe(apiMetricsKey{Route: route, Method: c.Request.Method, Status: c.Writer.Status()},
+			time.Since(start).Seconds(), uint64(requestBytes), uint64(responseBytes))
+	}
+}
+
+// observe adds one request to its series
+func (m *APIMetrics) observe(key apiMetricsKey, latency float64, requestBytes, responseBytes uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, exists := m.series[key]
+	if !exists {
+		s = &apiMetricsSeries{buckets: make([]uint64, len(apiLatencyBuckets)+1)}
+		m.series[key] = s
+	}
+
+	bucket := sort.SearchFloat64s(apiLatencyBuckets, latency)
+	s.buckets[bucket]++
+	s.count++
+	s.latencySum += latency
+	if latency > s.latencyMax {
+		s.latencyMax = latency
+	}
+	s.requestBytes += requestBytes
+	s.responseBytes += responseBytes
+}
+
+// sortedKeys returns the series keys in a stable order; the caller holds mu
+func (m *APIMetrics) sortedKeys() []apiMetricsKey {
+	keys := make([]apiMetricsKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Route != keys[j].Route {
+			return keys[i].Route < keys[j].Route
+		}
+		if keys[i].Method != keys[j].Method {
+			return keys[i].Method < keys[j].Method
+		}
+		return keys[i].Status < keys[j].Status
+	})
+	return keys
+}
+
+// WritePrometheus writes all series in the Prometheus text exposition format
+func (m *APIMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.sortedKeys()
+	labels := func(key apiMetricsKey) string {
+		return fmt.Sprintf(`route="%s",method="%s",status="%d"`, escapeLabel(key.Route), key.Method, key.Status)
+	}
+
+	fmt.Fprintln(w, "# HELP remnawave_node_api_requests_total API requests by route, method and status code.")
+	fmt.Fprintln(w, "# TYPE remnawave_node_api_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "remnawave_node_api_requests_total{%s} %d\n", labels(key), m.series[key].count)
+	}
+
+	fmt.Fprintln(w, "# HELP remnawave_node_api_request_duration_seconds API request latency.")
+	fmt.Fprintln(w, "# TYPE remnawave_node_api_request_duration_seconds histogram")
+	for _, key := range keys {
+		s := m.series[key]
+		var cumulative uint64
+		for i, bound := range apiLatencyBuckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "remnawave_node_api_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels(key), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "remnawave_node_api_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(key), s.count)
+		fmt.Fprintf(w, "remnawave_node_api_request_duration_seconds_sum{%s} %g\n", labels(key), s.latencySum)
+		fmt.Fprintf(w, "remnawave_node_api_request_duration_seconds_count{%s} %d\n", labels(key), s.count)
+	}
+
+	fmt.Fprintln(w, "# HELP remnawave_node_api_request_bytes_total API request body bytes.")
+	fmt.Fprintln(w, "# TYPE remnawave_node_api_request_bytes_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "remnawave_node_api_request_bytes_total{%s} %d\n", labels(key), m.series[key].requestBytes)
+	}
+
+	fmt.Fprintln(w, "# HELP remnawave_node_api_response_bytes_total API response body bytes.")
+	fmt.Fprintln(w, "# TYPE remnawave_node_api_response_bytes_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "remnawave_node_api_response_bytes_total{%s} %d\n", labels(key), m.series[key].responseBytes)
+	}
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// APIRouteStats summarizes the requests of one route
+type APIRouteStats struct {
+	Route         string         `json:"route"`
+	Method        string         `json:"method"`
+	Requests      uint64         `json:"requests"`
+	Errors        uint64         `json:"errors"`   // Status 5xx
+	Rejected      uint64         `json:"rejected"` // Status 4xx
+	StatusCodes   map[int]uint64 `json:"statusCodes"`
+	AvgLatencyMs  float64        `json:"avgLatencyMs"`
+	MaxLatencyMs  float64        `json:"maxLatencyMs"`
+	RequestBytes  uint64         `json:"requestBytes"`
+	ResponseBytes uint64         `json:"responseBytes"`
+}
+
+// APIStatsSummary is the JSON view of the API metrics
+type APIStatsSummary struct {
+	Since    time.Time        `json:"since"`
+	Requests uint64           `json:"requests"`
+	Errors   uint64           `json:"errors"`
+	Rejected uint64           `json:"rejected"`
+	Routes   []*APIRouteStats `json:"routes"`
+}
+
+// Summary aggregates the series per route and method
+func (m *APIMetrics) Summary() *APIStatsSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	summary := &APIStatsSummary{Since: m.since, Routes: make([]*APIRouteStats, 0)}
+	var latencySum float64
+	var current *APIRouteStats
+	for _, key := range m.sortedKeys() {
+		s := m.series[key]
+		if current == nil || current.Route != key.Route || current.Method != key.Method {
+			if current != nil {
+				current.AvgLatencyMs = latencySum / float64(current.Requests) * 1000
+			}
+			current = &APIRouteStats{Route: key.Route, Method: key.Method, StatusCodes: make(map[int]uint64)}
+			latencySum = 0
+			summary.Routes = append(summary.Routes, current)
+		}
+
+		current.Requests += s.count
+		current.StatusCodes[key.Status] += s.count
+		latencySum += s.latencySum
+		if ms := s.latencyMax * 1000; ms > current.MaxLatencyMs {
+			current.MaxLatencyMs = ms
+		}
+		current.RequestBytes += s.requestBytes
+		current.ResponseBytes += s.responseBytes
+		switch {
+		case key.Status >= 500:
+			current.Errors += s.count
+		case key.Status >= 400:
+			current.Rejected += s.count
+		}
+
+		summary.Requests += s.count
+	}
+	if current != nil {
+		current.AvgLatencyMs = latencySum / float64(current.Requests) * 1000
+	}
+	for _, route := range summary.Routes {
+		summary.Errors += route.Errors
+		summary.Rejected += route.Rejected
+	}
+
+	return summary
+}
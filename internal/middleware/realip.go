@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPKey is the context key of the address resolved by RealIP
+const clientIPKey = "client_ip"
+
+// TrustedProxyConfig holds real client IP configuration
+type TrustedProxyConfig struct {
+	Proxies []netip.Prefix // Peers whose forwarding header is believed; empty trusts none
+	Header  string         // Header carrying the client address; X-Forwarded-For when empty
+}
+
+// RealIP creates a middleware resolving the client address of each request
+// once, for logging, lockouts and the IP allowlist (see ClientIP). The
+// forwarding header is only believed when the TCP peer is a trusted proxy,
+// since anyone else can set it.
+func RealIP(cfg *TrustedProxyConfig) gin.HandlerFunc {
+	header := http.CanonicalHeaderKey(cfg.Header)
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	trusted := func(addr netip.Addr) bool {
+		for _, prefix := range cfg.Proxies {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *gin.Context) {
+		c.Set(clientIPKey, resolveClientIP(c.Request, header, trusted))
+		c.Next()
+	}
+}
+
+// resolveClientIP returns the client address of a request. X-Forwarded-For is
+// read right to left, skipping trusted proxies, as only the entries appended
+// by trusted proxies are reliable; other headers hold a single address.
+func resolveClientIP(r *http.Request, header string, trusted func(netip.Addr) bool) string {
+	peer := remoteIP(r)
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !trusted(addr.Unmap()) {
+		return peer
+	}
+
+	values := r.Header.Values(header)
+	if header != "X-Forwarded-For" {
+		if len(values) == 1 {
+			if forwarded, err := netip.ParseAddr(strings.TrimSpace(values[0])); err == nil {
+				return forwarded.Unmap().String()
+			}
+		}
+		return peer
+	}
+
+	var hops []string
+	for _, value := range values {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap().String()
+		if !trusted(hop.Unmap()) {
+			break
+		}
+	}
+	return client
+}
+
+// ClientIP returns the client address of a request: the one resolved by
+// RealIP, or the TCP peer address when RealIP did not run
+func ClientIP(c *gin.Context) string {
+	if ip := c.GetString(clientIPKey); ip != "" {
+		return ip
+	}
+	return remoteIP(c.Request)
+}
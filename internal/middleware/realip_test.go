@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRealIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, tt := range []struct {
+		name    string
+		header  string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer", "", "203.0.113.5:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry", "", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"no header", "", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"garbage", "", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "nonsense"}, "10.0.0.2"},
+		{"single value header", "X-Real-IP", "10.0.0.2:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"other header ignored", "X-Real-IP", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "10.0.0.2"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			router := gin.New()
+			router.Use(RealIP(&TrustedProxyConfig{Proxies: proxies, Header: tt.header}))
+			router.GET("/", func(c *gin.Context) { got = ClientIP(c) })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/clash-version/remnawave-node-go/pkg/logger"
+	"github.com/clash-version/remnawave-node-go/pkg/reqtiming"
+	"github.com/gin-gonic/gin"
+)
+
+// timingWriter records when the handler starts writing its response
+type timingWriter struct {
+	gin.ResponseWriter
+	headerAt time.Time
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	if w.headerAt.IsZero() {
+		w.headerAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// SlowRequests creates a middleware logging a WARN with a timing breakdown
+// for requests taking longer than threshold. Services record phases (lock
+// waits, core calls) through reqtiming on the request context; serialization
+// is the time from the response status being set to the handler returning.
+// A threshold of 0 disables the middleware.
+func SlowRequests(threshold time.Duration, log *logger.Logger) gin.HandlerFunc {
+	if threshold <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx, timings := reqtiming.WithTimings(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		writer := &timingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		end := time.Now()
+		total := end.Sub(start)
+		if total < threshold {
+			return
+		}
+
+		if !writer.headerAt.IsZero() {
+			timings.Add(reqtiming.PhaseSerialization, end.Sub(writer.headerAt))
+		}
+
+		breakdown := make(map[string]interface{})
+		var accounted time.Duration
+		for _, phase := range timings.Phases() {
+			breakdown[phase.Name] = phase.Duration.String()
+			if phase.Calls > 1 {
+				breakdown[phase.Name+"Calls"] = phase.Calls
+			}
+			accounted += phase.Duration
+		}
+		// Phases may overlap when a handler fans out, so "other" can be negative
+		breakdown["other"] = (total - accounted).String()
+
+		log.Warnw("Slow request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"total", total.String(),
+			"breakdown", breakdown,
+		)
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimeoutConfig holds per-route request deadlines
+type TimeoutConfig struct {
+	Default time.Duration            // Applied when no route entry matches; 0 means no deadline
+	Routes  map[string]time.Duration // Route path or path prefix (e.g. "/node/stats") -> deadline
+}
+
+// Timeout creates a middleware that puts a deadline on the request context, so
+// core calls made with c.Request.Context() give up instead of piling up.
+// The longest matching route prefix wins.
+func Timeout(cfg *TimeoutConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := cfg.Default
+		path := c.FullPath()
+		matched := -1
+		for route, d := range cfg.Routes {
+			if (path == route || strings.HasPrefix(path, route+"/")) && len(route) > matched {
+				timeout = d
+				matched = len(route)
+			}
+		}
+
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
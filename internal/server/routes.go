@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/clash-version/remnawave-node-go/internal/middleware"
 	"github.com/clash-version/remnawave-node-go/internal/services"
+	"github.com/clash-version/remnawave-node-go/pkg/logger"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zapcore"
 )
 
 // Route constants
@@ -18,53 +22,99 @@ const (
 
 // Controller names
 const (
-	XrayController     = "xray"
-	StatsController    = "stats"
-	HandlerController  = "handler"
-	VisionController   = "vision"
-	InternalController = "internal"
+	XrayController      = "xray"
+	StatsController     = "stats"
+	HandlerController   = "handler"
+	VisionController    = "vision"
+	InternalController  = "internal"
+	WireGuardController = "wireguard"
+	SidecarController   = "sidecar"
+	RoutingController   = "routing"
+	WarpController      = "warp"
+	CertsController     = "certs"
 )
 
 // setupRoutes configures all API routes
 func (s *Server) setupRoutes() {
 	// Apply JWT auth middleware to main router
-	authMiddleware := middleware.JWTAuth(s.cfg.NodePayload.JWTPublicKey, s.log)
+	authMiddleware := middleware.JWTAuth(s.jwtKey, &middleware.LockoutConfig{
+		Threshold: s.cfg.AuthLockoutThreshold,
+		Window:    s.cfg.AuthLockoutWindow,
+		Duration:  s.cfg.AuthLockoutDuration,
+	}, s.log)
+
+	// Request deadlines: short for stats, long for start
+	timeoutMiddleware := middleware.Timeout(&middleware.TimeoutConfig{
+		Default: s.cfg.APITimeout,
+		Routes: map[string]time.Duration{
+			RootPath + "/" + StatsController:             s.cfg.APIStatsTimeout,
+			RootPath + "/" + XrayController + "/start":   s.cfg.APIStartTimeout,
+			RootPath + "/" + XrayController + "/restart": s.cfg.APIStartTimeout,
+		},
+	})
+
+	// Caps on simultaneous heavy stats queries and batch user operations
+	statsLimit := middleware.ConcurrencyLimit(s.cfg.APIMaxStatsRequests, time.Second)
+	batchLimit := middleware.ConcurrencyLimit(s.cfg.APIMaxBatchRequests, 2*time.Second)
 
-	// Main API routes (with auth)
+	// Main API routes (with auth, except in insecure dev mode)
 	node := s.router.Group(RootPath)
-	node.Use(authMiddleware)
+	if s.cfg.InsecureDev {
+		node.Use(timeoutMiddleware)
+	} else {
+		node.Use(authMiddleware, timeoutMiddleware)
+	}
 	{
 		// Xray routes
 		xray := node.Group("/" + XrayController)
 		{
-			xray.POST("/start", s.handleXrayStart)
+			xray.POST("/start", s.recordRequest, s.handleXrayStart)
 			xray.GET("/stop", s.handleXrayStop)
 			xray.GET("/status", s.handleXrayStatus)
 			xray.GET("/healthcheck", s.handleNodeHealthCheck)
+			xray.GET("/get-inbounds", s.handleGetInbounds)
+			xray.GET("/get-outbounds", s.handleGetOutbounds)
+			xray.POST("/get-user-links", s.handleGetUserLinks)
+			xray.GET("/self-test", s.handleSelfTest)
+			xray.POST("/why-restart", s.handleWhyRestart)
+			xray.GET("/restart-history", s.handleRestartHistory)
+			xray.POST("/restart", s.handleXrayRestart)
 		}
 
 		// Stats routes
 		stats := node.Group("/" + StatsController)
+		stats.Use(statsLimit)
 		{
 			stats.POST("/get-user-online-status", s.handleGetUserOnlineStatus)
 			stats.POST("/get-users-stats", s.handleGetUsersStats)
+			stats.POST("/get-users-stats-and-reset", s.handleGetUsersStatsAndReset)
 			stats.GET("/get-system-stats", s.handleGetSystemStats)
 			stats.POST("/get-inbound-stats", s.handleGetInboundStats)
 			stats.POST("/get-outbound-stats", s.handleGetOutboundStats)
 			stats.POST("/get-all-inbounds-stats", s.handleGetAllInboundsStats)
 			stats.POST("/get-all-outbounds-stats", s.handleGetAllOutboundsStats)
 			stats.POST("/get-combined-stats", s.handleGetCombinedStats)
+			stats.POST("/begin-collection", s.handleBeginCollection)
+			stats.POST("/commit-collection", s.handleCommitCollection)
+			stats.GET("/username-aliases", s.handleGetUsernameAliases)
+			stats.POST("/username-aliases", s.handleSetUsernameAliases)
+			stats.POST("/exports", s.handleStartExport)
+			stats.GET("/exports", s.handleListExports)
+			stats.GET("/exports/:id", s.handleGetExport)
+			stats.GET("/exports/:id/download", s.handleDownloadExport)
 		}
 
 		// Handler routes
 		handler := node.Group("/" + HandlerController)
 		{
-			handler.POST("/add-user", s.handleAddUser)
-			handler.POST("/add-users", s.handleAddUsers)
+			handler.POST("/add-user", s.recordRequest, s.handleAddUser)
+			handler.POST("/add-users", batchLimit, s.recordRequest, s.handleAddUsers)
 			handler.POST("/remove-user", s.handleRemoveUser)
-			handler.POST("/remove-users", s.handleRemoveUsers)
+			handler.POST("/remove-users", batchLimit, s.handleRemoveUsers)
 			handler.POST("/get-inbound-users-count", s.handleGetInboundUsersCount)
 			handler.POST("/get-inbound-users", s.handleGetInboundUsers)
+			handler.POST("/resync-from-config", batchLimit, s.handleResyncFromConfig)
+			handler.POST("/find-user", s.handleFindUser)
 		}
 
 		// Vision routes
@@ -72,16 +122,97 @@ func (s *Server) setupRoutes() {
 		{
 			vision.POST("/block-ip", s.handleBlockIP)
 			vision.POST("/unblock-ip", s.handleUnblockIP)
+			vision.GET("/blocked-ips", s.handleGetBlockedIPs)
+		}
+
+		// WireGuard routes
+		wireguard := node.Group("/" + WireGuardController)
+		{
+			wireguard.POST("/generate-keys", s.handleGenerateWireGuardKeys)
+			wireguard.GET("/get-peers", s.handleGetWireGuardPeers)
+		}
+
+		// Sidecar routes
+		sidecar := node.Group("/" + SidecarController)
+		{
+			sidecar.POST("/start", s.handleSidecarStart)
+			sidecar.POST("/stop", s.handleSidecarStop)
+			sidecar.GET("/status", s.handleSidecarStatus)
+		}
+
+		// Routing routes
+		routing := node.Group("/" + RoutingController)
+		{
+			routing.POST("/pin-user", s.handlePinUser)
+			routing.POST("/unpin-user", s.handleUnpinUser)
+			routing.GET("/get-pinned-users", s.handleGetPinnedUsers)
+			routing.GET("/rules", s.handleGetRoutingRules)
+		}
+
+		// WARP routes
+		warp := node.Group("/" + WarpController)
+		{
+			warp.POST("/enable", s.handleWarpEnable)
+			warp.GET("/disable", s.handleWarpDisable)
+			warp.GET("/status", s.handleWarpStatus)
+		}
+
+		// Certificate routes
+		certs := node.Group("/" + CertsController)
+		{
+			certs.GET("/list", s.handleListCerts)
+			certs.POST("/upload", s.handleUploadCert)
+			certs.POST("/delete", s.handleDeleteCert)
+			certs.GET("/acme", s.handleAcmeStatus)
+			certs.POST("/acme/renew", s.handleAcmeRenew)
 		}
 
 		// Internal routes
 		internal := node.Group("/" + InternalController)
 		{
 			internal.GET("/get-config", s.handleGetConfig)
+			internal.GET("/compat-report", s.handleCompatReport)
+			internal.GET("/recent-logs", s.handleRecentLogs)
+			internal.GET("/export-state", s.handleExportState)
+			internal.POST("/import-state", s.handleImportState)
+			internal.GET("/tuning", s.handleGetTuning)
+			internal.POST("/tuning", s.handleApplyTuning)
+			internal.GET("/api-stats", s.handleAPIStats)
+			internal.GET("/metrics", s.handleMetrics)
+			internal.GET("/build-info", s.handleBuildInfo)
+			internal.GET("/facts", s.handleFacts)
+			internal.GET("/feature-flags", s.handleGetFeatureFlags)
+			internal.POST("/feature-flags", s.handleToggleFeatureFlag)
+			internal.GET("/recorded-requests", s.handleListRecordedRequests)
+			internal.GET("/traffic-budget", s.handleTrafficBudget)
+			internal.GET("/mutation-journal", s.handleMutationJournal)
+			internal.GET("/sessions", s.handleGetSessions)
+			internal.GET("/ebpf-accounting", s.handleGetEbpfAccounting)
+			internal.GET("/hooks", s.handleGetHooks)
+			internal.GET("/policies", s.handleGetPolicies)
 		}
 	}
 }
 
+// recordRequest keeps a sanitized copy of the request body for replay when
+// the request recorder is enabled
+func (s *Server) recordRequest(c *gin.Context) {
+	if !s.recorderService.Enabled() {
+		c.Next()
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	go s.recorderService.Record(c.FullPath(), body)
+	c.Next()
+}
+
 // === Xray Handlers ===
 
 func (s *Server) handleXrayStart(c *gin.Context) {
@@ -89,22 +220,30 @@ func (s *Server) handleXrayStart(c *gin.Context) {
 	bodyBytes, _ := c.GetRawData()
 	s.log.Debugw("Received xray start request", "body", string(bodyBytes))
 
-	// Re-set body for binding
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-	var req services.StartRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	// Both the current and the legacy (bare Xray config) payloads are accepted
+	req, err := s.compatService.ParseStartRequest(bodyBytes)
+	if err != nil {
 		s.log.Errorw("Failed to bind JSON for xray start", "error", err, "body", string(bodyBytes))
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request format: %v", err)})
 		return
 	}
 
-	resp, err := s.xrayService.Start(c.Request.Context(), &req)
+	resp, err := s.xrayService.Start(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// A full config push also resyncs users on sidecar cores
+	if s.sidecarService.Enabled() && resp.Response.IsStarted {
+		users := services.SidecarUsersFromConfig(req.XrayConfig)
+		go func() {
+			if err := s.sidecarService.SetUsers(users); err != nil {
+				s.log.Warnw("Failed to sync sidecar users", "error", err)
+			}
+		}()
+	}
+
 	// StartResponse already has "response" wrapper, return directly
 	c.JSON(http.StatusOK, resp)
 }
@@ -135,11 +274,112 @@ func (s *Server) handleXrayStatus(c *gin.Context) {
 
 func (s *Server) handleNodeHealthCheck(c *gin.Context) {
 	resp := s.xrayService.GetNodeHealthCheck(c.Request.Context())
+	resp.Response.InboundsHealth = s.selfTestService.InboundHealth()
+	if load := s.capacityService.Load(); load != nil {
+		resp.Response.CapacityScore = &load.Score
+		resp.Response.Capacity = load
+	}
 	c.JSON(http.StatusOK, resp)
 }
 
+func (s *Server) handleGetInbounds(c *gin.Context) {
+	resp, err := s.xrayService.GetInbounds(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetOutbounds(c *gin.Context) {
+	resp, err := s.xrayService.GetOutbounds(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetUserLinks(c *gin.Context) {
+	var req services.GetUserLinksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Host == "" {
+		req.Host = services.DefaultLinkHost(c.Request.Host)
+	}
+
+	resp, err := s.linkService.GetUserLinks(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleSelfTest(c *gin.Context) {
+	resp, err := s.selfTestService.Run(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
 // === Stats Handlers ===
 
+// userStatsFilter parses the fields/minBytes query parameters of a user stats
+// request. Both are refused on resetting requests, since the traffic of the
+// users or directions left out would be reset without ever being reported.
+func userStatsFilter(c *gin.Context, reset bool) (*services.UserStatsFilter, error) {
+	filter, err := services.ParseUserStatsFilter(c.Query("fields"), c.Query("minBytes"))
+	if err != nil {
+		return nil, err
+	}
+	if reset && c.Query("fields") != "" {
+		return nil, fmt.Errorf("fields cannot be combined with reset")
+	}
+	if filter != nil && filter.MinBytes > 0 && reset {
+		return nil, fmt.Errorf("minBytes cannot be combined with reset")
+	}
+	return filter, nil
+}
+
+// statsCSV reports whether the format query parameter asks for CSV
+func statsCSV(c *gin.Context) (bool, error) {
+	switch format := c.Query("format"); format {
+	case "", "json":
+		return false, nil
+	case "csv":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown format %q (expected json or csv)", format)
+	}
+}
+
+// writeCSV responds with the CSV produced by write
+func writeCSV(c *gin.Context, write func(w io.Writer) error) {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
 func (s *Server) handleGetUserOnlineStatus(c *gin.Context) {
 	var req struct {
 		Username string `json:"username"`
@@ -171,6 +411,17 @@ func (s *Server) handleGetUsersStats(c *gin.Context) {
 		req.Reset = false
 	}
 
+	filter, err := userStatsFilter(c, req.Reset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	asCSV, err := statsCSV(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	resp, err := s.statsService.GetAllUsersStats(c.Request.Context(), &services.GetAllUsersStatsRequest{
 		Reset: req.Reset,
 	})
@@ -179,6 +430,55 @@ func (s *Server) handleGetUsersStats(c *gin.Context) {
 		return
 	}
 
+	if asCSV {
+		writeCSV(c, func(w io.Writer) error { return services.WriteUsersCSV(w, resp.Users, filter) })
+		return
+	}
+	if filter != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"response": gin.H{"users": filter.Apply(resp.Users)},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetUsersStatsAndReset(c *gin.Context) {
+	var req services.GetUsersStatsAndResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := userStatsFilter(c, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	asCSV, err := statsCSV(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.statsService.GetUsersStatsAndReset(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asCSV {
+		writeCSV(c, func(w io.Writer) error { return services.WriteUsersCSV(w, resp.Users, filter) })
+		return
+	}
+	if filter != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"response": gin.H{"users": filter.Apply(resp.Users)},
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"response": resp,
 	})
@@ -205,6 +505,11 @@ func (s *Server) handleGetInboundStats(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	asCSV, err := statsCSV(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	resp, err := s.statsService.GetInboundStats(c.Request.Context(), &services.GetInboundStatsRequest{
 		Tag:   req.Tag,
@@ -215,6 +520,12 @@ func (s *Server) handleGetInboundStats(c *gin.Context) {
 		return
 	}
 
+	if asCSV {
+		inbound := services.InboundStats(*resp)
+		writeCSV(c, func(w io.Writer) error { return services.WriteInboundsCSV(w, []*services.InboundStats{&inbound}) })
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"response": resp,
 	})
@@ -251,6 +562,11 @@ func (s *Server) handleGetAllInboundsStats(c *gin.Context) {
 	if err := c.ShouldBindJSON(&req); err != nil {
 		req.Reset = false
 	}
+	asCSV, err := statsCSV(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	resp, err := s.statsService.GetAllInboundsStats(c.Request.Context(), &services.GetAllInboundsStatsRequest{
 		Reset: req.Reset,
@@ -260,6 +576,11 @@ func (s *Server) handleGetAllInboundsStats(c *gin.Context) {
 		return
 	}
 
+	if asCSV {
+		writeCSV(c, func(w io.Writer) error { return services.WriteInboundsCSV(w, resp.Inbounds) })
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"response": resp,
 	})
@@ -307,16 +628,53 @@ func (s *Server) handleGetCombinedStats(c *gin.Context) {
 	})
 }
 
+func (s *Server) handleBeginCollection(c *gin.Context) {
+	resp, err := s.statsService.BeginCollection(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleCommitCollection(c *gin.Context) {
+	var req services.CommitCollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.statsService.CommitCollection(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
 // === Handler Handlers ===
 
 func (s *Server) handleAddUser(c *gin.Context) {
-	var req services.AddUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	bodyBytes, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Both the current and the legacy (single user, no hashData) payloads are accepted
+	req, err := s.compatService.ParseAddUserRequest(bodyBytes)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	resp, err := s.handlerService.AddUser(c.Request.Context(), &req)
+	resp, err := s.handlerService.AddUser(c.Request.Context(), req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -402,15 +760,62 @@ func (s *Server) handleGetInboundUsersCount(c *gin.Context) {
 }
 
 func (s *Server) handleGetInboundUsers(c *gin.Context) {
-	var req struct {
-		Tag string `json:"tag"`
+	var req services.GetInboundUsersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.handlerService.GetInboundUsers(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleResyncFromConfig(c *gin.Context) {
+	var req services.ResyncFromConfigRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	config, hashes, err := s.xrayService.LocalConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.handlerService.ResyncFromConfig(c.Request.Context(), config, hashes, req.DryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleFindUser(c *gin.Context) {
+	var req services.FindUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	resp, err := s.handlerService.GetInboundUsers(c.Request.Context(), req.Tag)
+	resp, err := s.handlerService.FindUser(c.Request.Context(), &req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -459,9 +864,584 @@ func (s *Server) handleUnblockIP(c *gin.Context) {
 	})
 }
 
-// === Internal Handlers ===
+// === WireGuard Handlers ===
 
-func (s *Server) handleGetConfig(c *gin.Context) {
-	resp := s.internalService.GetConfig()
-	c.JSON(http.StatusOK, resp)
+func (s *Server) handleGenerateWireGuardKeys(c *gin.Context) {
+	resp, err := s.wireGuardService.GenerateKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetWireGuardPeers(c *gin.Context) {
+	resp, err := s.wireGuardService.GetPeers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+// === Sidecar Handlers ===
+
+func (s *Server) handleSidecarStart(c *gin.Context) {
+	var req services.SidecarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.sidecarService.Start(req.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.sidecarService.GetStatus(c.Request.Context()),
+	})
+}
+
+func (s *Server) handleSidecarStop(c *gin.Context) {
+	var req services.SidecarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.sidecarService.Stop(req.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.sidecarService.GetStatus(c.Request.Context()),
+	})
+}
+
+func (s *Server) handleSidecarStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.sidecarService.GetStatus(c.Request.Context()),
+	})
+}
+
+// === Routing Handlers ===
+
+func (s *Server) handlePinUser(c *gin.Context) {
+	var req services.PinUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.routingService.PinUser(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleUnpinUser(c *gin.Context) {
+	var req services.UnpinUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.routingService.UnpinUser(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetPinnedUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.routingService.GetPinnedUsers(),
+	})
+}
+
+// === WARP Handlers ===
+
+func (s *Server) handleWarpEnable(c *gin.Context) {
+	var req services.EnableWarpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.warpService.Enable(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleWarpDisable(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.warpService.Disable(c.Request.Context()),
+	})
+}
+
+func (s *Server) handleWarpStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.warpService.GetStatus(),
+	})
+}
+
+// === Certificate Handlers ===
+
+func (s *Server) handleListCerts(c *gin.Context) {
+	resp, err := s.certService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleUploadCert(c *gin.Context) {
+	var req services.UploadCertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.certService.Upload(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleDeleteCert(c *gin.Context) {
+	var req services.DeleteCertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.certService.Delete(&req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": gin.H{"success": true},
+	})
+}
+
+func (s *Server) handleAcmeStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.acmeService.Status(),
+	})
+}
+
+func (s *Server) handleAcmeRenew(c *gin.Context) {
+	if s.acmeService == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ACME is not enabled"})
+		return
+	}
+
+	var req services.AcmeRenewRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.acmeService.Renew(c.Request.Context(), req.Force),
+	})
+}
+
+// === Internal Handlers ===
+
+func (s *Server) handleGetConfig(c *gin.Context) {
+	s.responseCache.Serve(c, "get-config", s.internalService.ConfigVersion(), func() (interface{}, error) {
+		return s.internalService.GetConfig(), nil
+	})
+}
+
+func (s *Server) handleRecentLogs(c *gin.Context) {
+	level := zapcore.DebugLevel
+	if v := c.Query("level"); v != "" {
+		parsed, err := zapcore.ParseLevel(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		level = parsed
+	}
+
+	limit := 1000
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	entries := s.log.Recent(level, limit)
+	if entries == nil {
+		entries = []logger.Entry{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"response": gin.H{"logs": entries},
+	})
+}
+
+func (s *Server) handleCompatReport(c *gin.Context) {
+	resp := s.compatService.GetReport()
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleExportState(c *gin.Context) {
+	resp := s.stateService.Export()
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleImportState(c *gin.Context) {
+	var req services.ImportStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.stateService.Import(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetTuning(c *gin.Context) {
+	resp := s.tuningService.Report()
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleApplyTuning(c *gin.Context) {
+	var req services.ApplyTuningRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.tuningService.Apply(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleAPIStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.apiMetrics.Summary(),
+	})
+}
+
+func (s *Server) handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	s.apiMetrics.WritePrometheus(c.Writer)
+}
+
+// features returns the configured features together with the current value
+// of each named feature flag, which may have been toggled at runtime
+func (s *Server) features() map[string]bool {
+	features := s.cfg.FeatureFlags()
+	for name, enabled := range s.featureFlags.Values() {
+		features[name] = enabled
+	}
+	return features
+}
+
+func (s *Server) handleBuildInfo(c *gin.Context) {
+	// Build info, including the core version, only changes with the flags
+	features := s.features()
+	s.responseCache.Serve(c, "build-info", fmt.Sprint(features), func() (interface{}, error) {
+		return gin.H{"response": services.CurrentBuildInfo(features)}, nil
+	})
+}
+
+func (s *Server) handleGetFeatureFlags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.featureFlags.List(),
+	})
+}
+
+func (s *Server) handleToggleFeatureFlag(c *gin.Context) {
+	var req struct {
+		Name    string `json:"name" binding:"required"`
+		Enabled bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := s.featureFlags.Toggle(req.Name, req.Enabled)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.log.Infow("Feature flag toggled", "name", state.Name, "enabled", state.Enabled)
+	c.JSON(http.StatusOK, gin.H{
+		"response": state,
+	})
+}
+
+func (s *Server) handleListRecordedRequests(c *gin.Context) {
+	recordings, err := s.recorderService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": gin.H{
+			"enabled":    s.recorderService.Enabled(),
+			"recordings": recordings,
+		},
+	})
+}
+
+func (s *Server) handleTrafficBudget(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.budgetService.Status(),
+	})
+}
+
+func (s *Server) handleWhyRestart(c *gin.Context) {
+	var hashes services.InboundHashes
+	if err := c.ShouldBindJSON(&hashes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.xrayService.ExplainRestart(&hashes),
+	})
+}
+
+func (s *Server) handleRestartHistory(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": gin.H{
+			"restarts": s.xrayService.RestartHistory(),
+		},
+	})
+}
+
+func (s *Server) handleMutationJournal(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.handlerService.Journal(),
+	})
+}
+
+func (s *Server) handleGetUsernameAliases(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.statsService.UsernameAliases(),
+	})
+}
+
+func (s *Server) handleSetUsernameAliases(c *gin.Context) {
+	var req services.SetUsernameAliasesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.statsService.SetUsernameAliases(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetBlockedIPs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.visionService.GetBlockedIPs(),
+	})
+}
+
+func (s *Server) handleGetRoutingRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.routingService.GetRoutingRules(c.Request.Context()),
+	})
+}
+
+func (s *Server) handleGetSessions(c *gin.Context) {
+	var req services.GetSessionsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.sessionService.GetSessions(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetEbpfAccounting(c *gin.Context) {
+	var req services.GetAccountingRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := s.ebpfService.GetAccounting(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleGetHooks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.hookService.Hooks(),
+	})
+}
+
+func (s *Server) handleGetPolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": s.policyService.Policies(),
+	})
+}
+
+func (s *Server) handleXrayRestart(c *gin.Context) {
+	// A running core restarts with its own config and keeps the users changed
+	// since the last start; a stopped one starts from config.json
+	req := &services.RestartRequest{ForceRestart: true}
+	if !s.xrayService.IsRunning(c.Request.Context()) {
+		config, hashes, err := s.xrayService.LocalConfig()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		req.Config, req.Hashes, req.Local = config, hashes, true
+	}
+
+	resp, err := s.xrayService.Restart(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": resp,
+	})
+}
+
+func (s *Server) handleFacts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": services.CurrentFacts(s.xrayService, s.internalService, s.features(), s.cfg.HashAlgorithm),
+	})
+}
+
+func (s *Server) handleStartExport(c *gin.Context) {
+	var req struct {
+		Kind string `json:"kind" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := s.exportService.Start(req.Kind)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": job,
+	})
+}
+
+func (s *Server) handleListExports(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"response": gin.H{
+			"exports": s.exportService.Jobs(),
+		},
+	})
+}
+
+func (s *Server) handleGetExport(c *gin.Context) {
+	job := s.exportService.Job(c.Param("id"))
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown export"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": job,
+	})
+}
+
+func (s *Server) handleDownloadExport(c *gin.Context) {
+	path, err := s.exportService.File(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.FileAttachment(path, c.Param("id"))
 }
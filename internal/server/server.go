@@ -5,15 +5,24 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"maps"
 	"net/http"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/clash-version/remnawave-node-go/internal/config"
 	"github.com/clash-version/remnawave-node-go/internal/middleware"
 	"github.com/clash-version/remnawave-node-go/internal/services"
+	"github.com/clash-version/remnawave-node-go/pkg/crypto"
+	"github.com/clash-version/remnawave-node-go/pkg/errreport"
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
+	"github.com/clash-version/remnawave-node-go/pkg/hashedset"
 	"github.com/clash-version/remnawave-node-go/pkg/logger"
+	"github.com/clash-version/remnawave-node-go/pkg/opbarrier"
 	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
 	"github.com/gin-gonic/gin"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // Server represents the HTTP server
@@ -21,14 +30,44 @@ type Server struct {
 	cfg        *config.Config
 	log        *logger.Logger
 	mainServer *http.Server
+	h3Server   *http3.Server // Optional HTTP/3 listener (NODE_HTTP3)
 	router     *gin.Engine
 
 	// Services
-	xrayService     *services.XrayService
-	handlerService  *services.HandlerService
-	statsService    *services.StatsService
-	visionService   *services.VisionService
-	internalService *services.InternalService
+	xrayService        *services.XrayService
+	handlerService     *services.HandlerService
+	statsService       *services.StatsService
+	visionService      *services.VisionService
+	internalService    *services.InternalService
+	wireGuardService   *services.WireGuardService
+	sidecarService     *services.SidecarService
+	routingService     *services.RoutingService
+	warpService        *services.WarpService
+	linkService        *services.LinkService
+	selfTestService    *services.SelfTestService
+	compatService      *services.CompatService
+	stateService       *services.StateService
+	tuningService      *services.TuningService
+	certService        *services.CertService
+	acmeService        *services.AcmeService
+	metricsPushService *services.MetricsPushService
+	heartbeatService   *services.HeartbeatService
+	clockService       *services.ClockService
+	capacityService    *services.CapacityService
+	peerSyncService    *services.PeerSyncService
+	recorderService    *services.RecorderService
+	exportService      *services.ExportService
+	budgetService      *services.BudgetService
+	sessionService     *services.SessionService
+	ebpfService        *services.EbpfAccountingService
+	hookService        *services.HookService
+	policyService      *services.PolicyService
+	healthManager      *services.HealthManager
+	apiMetrics         *middleware.APIMetrics
+	responseCache      *middleware.ResponseCache
+	jwtKey             *middleware.JWTKey
+	featureFlags       *featureflags.Set
+	tlsConfig          atomic.Pointer[tls.Config] // Current mTLS config, replaced on reload
 
 	// Embedded Xray-core
 	xrayCore *xraycore.Instance
@@ -39,49 +78,365 @@ func New(cfg *config.Config, log *logger.Logger) (*Server, error) {
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
+	// Node identity for API responses and metrics
+	services.SetNodeIdentity(services.NodeIdentity{
+		Name:     cfg.NodeName,
+		Region:   cfg.NodeRegion,
+		Provider: cfg.NodeProvider,
+		Labels:   cfg.NodeLabels,
+	})
+
+	// Optional panic reporting (Sentry or a generic endpoint)
+	reporter, err := errreport.New(&errreport.Config{
+		SentryDSN: cfg.SentryDSN,
+		URL:       cfg.ErrorReportURL,
+		Release:   services.NodeVersion(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// JWT verification key, replaceable on reload (unused in insecure dev mode)
+	var jwtKey *middleware.JWTKey
+	if !cfg.InsecureDev {
+		jwtKey, err = middleware.NewJWTKey(cfg.NodePayload.JWTPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+		}
+	}
+
+	// Feature flags, some of which can be toggled at runtime.
+	// DISABLE_HASHED_SET_CHECK is the hash_check flag's older spelling.
+	flagValues := maps.Clone(cfg.FeatureFlagValues)
+	if _, set := flagValues[services.FlagHashCheck]; cfg.DisableHashedSetCheck && !set {
+		if flagValues == nil {
+			flagValues = make(map[string]bool, 1)
+		}
+		flagValues[services.FlagHashCheck] = false
+	}
+	featureFlags, err := services.NewFeatureFlags(flagValues)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feature flags: %w", err)
+	}
+
 	// Create main router
 	router := gin.New()
-	router.Use(middleware.Recovery(log))
-	router.Use(middleware.Decompress(log)) // Handle gzip compressed request bodies
+	router.SetTrustedProxies(nil) // Client addresses come from middleware.ClientIP, which honors TRUSTED_PROXIES
+	apiMetrics := middleware.NewAPIMetrics()
+	router.Use(apiMetrics.Middleware()) // Outermost, so rejected and panicking requests are counted
+	router.Use(middleware.Recovery(log, reporter))
+	router.Use(middleware.RealIP(&middleware.TrustedProxyConfig{
+		Proxies: cfg.TrustedProxies,
+		Header:  cfg.RealIPHeader,
+	}))
+	if len(cfg.APIAllowedIPs) > 0 {
+		router.Use(middleware.IPAllowlist(cfg.APIAllowedIPs, log))
+	}
+	router.Use(middleware.Decompress(cfg.APIMaxBodyMB<<20, log)) // Handle gzip/zstd compressed request bodies
 	router.Use(middleware.Logger(log))
+	router.Use(middleware.SlowRequests(cfg.SlowRequestThreshold, log))
 
 	// Create embedded Xray-core instance
 	xrayCoreInstance := xraycore.New(&xraycore.Config{
 		Logger: log.Desugar(),
 	})
 
+	// Outbound tag used for Vision IP blocking
+	blockTag := "block"
+
+	// Key for config.json at-rest encryption, derived from SECRET_KEY (which
+	// only insecure dev mode may leave unset)
+	var configKey []byte
+	if cfg.SecretKey != "" {
+		configKey, err = crypto.DeriveAtRestKey(cfg.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive config key: %w", err)
+		}
+	}
+
 	// Create services
 	// Internal service must be created first as other services depend on it
+	hashAlgorithm, err := hashedset.ParseAlgorithm(cfg.HashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HASH_ALGORITHM: %w", err)
+	}
 	internalService := services.NewInternalService(&services.InternalConfig{
-		DisableHashCheck: cfg.DisableHashedSetCheck,
+		Flags:         featureFlags,
+		HashAlgorithm: hashAlgorithm,
+		HashTTL:       cfg.HashTTL,
+		StateDir:      cfg.StateDir,
+	}, log.Desugar())
+
+	sidecarService, err := services.NewSidecarService(&services.SidecarConfig{
+		DefinitionsPath: cfg.SidecarsConfig,
+		KeepOnShutdown:  cfg.KeepCoresOnShutdown,
+	}, log.Desugar())
+	if err != nil {
+		return nil, err
+	}
+
+	selfTestService := services.NewSelfTestService(&services.SelfTestConfig{
+		ProbeInterval: cfg.SelfTestInterval,
+	}, xrayCoreInstance, log.Desugar())
+	clockService := services.NewClockService(&services.ClockConfig{
+		Servers:  cfg.NTPServers,
+		Interval: cfg.NTPCheckInterval,
+		WarnSkew: cfg.ClockSkewWarn,
 	}, log.Desugar())
+	healthManager := services.NewHealthManager(&services.HealthConfig{
+		Interval:      cfg.HealthCheckInterval,
+		InboundHealth: selfTestService.InboundHealth,
+		Processes:     sidecarService.Pids,
+		Clock:         clockService.State,
+		FDWarnPercent: cfg.FDWarnPercent,
+	}, xrayCoreInstance, log.Desugar())
+
+	// Node-local inbound listen overrides
+	var inboundOverrides map[string]services.InboundOverride
+	if cfg.InboundOverrides != "" {
+		inboundOverrides, err = services.LoadInboundOverrides(cfg.InboundOverrides)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Node traffic budgets; an exhausted budget can hold the core stopped
+	budgetService := services.NewBudgetService(&services.BudgetConfig{
+		DailyBytes:      cfg.TrafficBudgetDailyBytes,
+		MonthlyBytes:    cfg.TrafficBudgetMonthlyBytes,
+		ResetDay:        cfg.TrafficBudgetResetDay,
+		WarnPercent:     cfg.TrafficBudgetWarnPercent,
+		CriticalPercent: cfg.TrafficBudgetCriticalPercent,
+		StopCore:        cfg.TrafficBudgetStopCore,
+		Interval:        cfg.TrafficBudgetInterval,
+		StateDir:        cfg.StateDir,
+	}, xrayCoreInstance, healthManager, log.Desugar())
+
+	// One canonical username form for the config, user mutations and lookups
+	usernames, err := services.NewUsernameNormalizer(cfg.UsernameNormalization)
+	if err != nil {
+		return nil, fmt.Errorf("invalid USERNAME_NORMALIZATION: %w", err)
+	}
+
+	// Shut by xrayService while it rebuilds the core, waited on by user mutations
+	userBarrier := opbarrier.New()
+
+	// Operator hooks run on lifecycle events
+	var hookService *services.HookService
+	if cfg.HooksConfig != "" {
+		hooks, err := services.LoadHooks(cfg.HooksConfig)
+		if err != nil {
+			return nil, err
+		}
+		hookService = services.NewHookService(&services.HookConfig{Hooks: hooks}, log.Desugar())
+	}
+
+	// Node load against the capacity limits, reported to the panel
+	capacityService := services.NewCapacityService(&services.CapacityConfig{
+		Interval:         cfg.CapacityInterval,
+		MaxCPUPercent:    cfg.CapacityMaxCPUPercent,
+		MaxMemoryPercent: cfg.CapacityMaxMemoryPercent,
+		MaxOnlineUsers:   cfg.CapacityMaxOnlineUsers,
+		MaxBandwidthMbps: cfg.CapacityMaxBandwidthMbps,
+		HardCap:          cfg.CapacityHardCap,
+	}, xrayCoreInstance, log.Desugar())
+
+	// Policies veto or rewrite user mutations and blocks
+	var policyService *services.PolicyService
+	if cfg.PolicyConfig != "" || cfg.CapacityHardCap {
+		policyConfig := &services.PolicyConfig{}
+		if cfg.CapacityHardCap {
+			policyConfig.Builtin = append(policyConfig.Builtin, services.BuiltinPolicy{Name: "capacity", Policy: capacityService})
+		}
+		if cfg.PolicyConfig != "" {
+			if policyConfig.Policies, err = services.LoadPolicies(cfg.PolicyConfig); err != nil {
+				return nil, err
+			}
+		}
+		policyService = services.NewPolicyService(policyConfig, log.Desugar())
+	}
 
 	xrayService := services.NewXrayService(&services.XrayConfig{
-		ConfigDir:             "/var/lib/remnawave-node",
-		DisableHashedSetCheck: cfg.DisableHashedSetCheck,
-	}, xrayCoreInstance, internalService, log.Desugar())
+		ConfigDir: cfg.StateDir,
+		BlockTag:  blockTag,
+		API: &services.APISettings{
+			Listen:           cfg.XrayAPIListen,
+			Tag:              cfg.XrayAPITag,
+			PolicyLevels:     cfg.XrayPolicyLevels,
+			StatsUserTraffic: cfg.XrayStatsUsers,
+			StatsUserOnline:  cfg.XrayStatsUserOnline,
+			StatsInbound:     cfg.XrayStatsInbounds,
+			StatsOutbound:    cfg.XrayStatsOutbounds,
+			MergeMode:        cfg.XrayMergePolicy,
+		},
+		EncryptConfig:    cfg.EncryptConfigAtRest,
+		ConfigKey:        configKey,
+		InboundOverrides: inboundOverrides,
+		TemplatePrefix:   cfg.ConfigTemplatePrefix,
+		StartBlocked:     budgetService.StartBlocked,
+		Flags:            featureFlags,
+		UserBarrier:      userBarrier,
+		Usernames:        usernames,
+		Hooks:            hookService,
+	}, xrayCoreInstance, internalService, healthManager, log.Desugar())
+
+	// An exhausted budget stops the core the way the stop endpoint does
+	budgetService.SetCoreStopper(func(ctx context.Context) error {
+		resp, err := xrayService.Stop(ctx)
+		if err != nil {
+			return err
+		}
+		if !resp.IsStopped {
+			return fmt.Errorf("xray did not stop")
+		}
+		return nil
+	})
 
-	handlerService := services.NewHandlerService(xrayCoreInstance, internalService, log.Desugar())
-	statsService := services.NewStatsService(xrayCoreInstance, log.Desugar())
 	visionService := services.NewVisionService(&services.VisionConfig{
-		BlockTag: "block",
+		BlockTag: blockTag,
+		Hooks:    hookService,
+		Policies: policyService,
 	}, xrayCoreInstance, log.Desugar())
+	peerSyncService := services.NewPeerSyncService(&services.PeerSyncConfig{
+		Listen:   cfg.PeerSyncListen,
+		Peers:    cfg.PeerSyncPeers,
+		Secret:   cfg.PeerSyncSecret,
+		Interval: cfg.PeerSyncInterval,
+	}, visionService, log.Desugar())
+	wireGuardService := services.NewWireGuardService(xrayCoreInstance, log.Desugar())
+	routingService := services.NewRoutingService(xrayCoreInstance, usernames, log.Desugar())
+	compatService := services.NewCompatService(log.Desugar())
+	tuningService := services.NewTuningService(log.Desugar())
+	certService := services.NewCertService(&services.CertConfig{
+		Dir: filepath.Join(cfg.StateDir, "certs"),
+	}, xrayCoreInstance, log.Desugar())
+	acmeService := services.NewAcmeService(&services.AcmeConfig{
+		Enabled:      cfg.AcmeEnabled,
+		Email:        cfg.AcmeEmail,
+		DirectoryURL: cfg.AcmeDirectoryURL,
+		HTTPPort:     cfg.AcmeHTTPPort,
+		DNSHook:      cfg.AcmeDNSHook,
+		RenewBefore:  cfg.AcmeRenewBefore,
+		StateDir:     cfg.StateDir,
+	}, xrayCoreInstance, certService, log.Desugar())
+	recorderService := services.NewRecorderService(&services.RecorderConfig{
+		Dir:   filepath.Join(cfg.StateDir, "recordings"),
+		Limit: cfg.RequestRecorderLimit,
+	}, log.Desugar())
+	stateService := services.NewStateService(xrayService, internalService, visionService, routingService, log.Desugar())
+	metricsPushService := services.NewMetricsPushService(&services.MetricsPushConfig{
+		URL:      cfg.MetricsPushURL,
+		Token:    cfg.MetricsPushToken,
+		Interval: cfg.MetricsPushInterval,
+	}, xrayCoreInstance, healthManager, log.Desugar())
+	heartbeatService := services.NewHeartbeatService(&services.HeartbeatConfig{
+		URL:      cfg.HeartbeatURL,
+		Token:    cfg.HeartbeatToken,
+		Interval: cfg.HeartbeatInterval,
+	}, xrayCoreInstance, healthManager, internalService, log.Desugar())
+	linkService := services.NewLinkService(xrayCoreInstance, usernames, log.Desugar())
+	sessionService := services.NewSessionService(xrayCoreInstance, featureFlags, log.Desugar())
+	ebpfService := services.NewEbpfAccountingService(xrayCoreInstance, featureFlags, log.Desugar())
+	healthManager.OnOnline(func() {
+		if err := ebpfService.SyncPorts(); err != nil {
+			log.Warnw("Failed to update eBPF accounting ports", "error", err)
+		}
+	})
+	warpService := services.NewWarpService(&services.WarpConfig{
+		StateDir: cfg.StateDir,
+	}, xrayCoreInstance, log.Desugar())
+	handlerService := services.NewHandlerService(xrayCoreInstance, internalService, sidecarService, featureFlags, userBarrier, usernames, hookService, policyService, log.Desugar())
+	// Mutations journaled while the core was down are applied once it is
+	// back, unless a config from the panel superseded them
+	healthManager.OnOnline(func() {
+		handlerService.ReplayJournal(context.Background())
+	})
+	xrayService.OnPanelConfig(handlerService.DiscardJournal)
+	statsService := services.NewStatsService(&services.StatsConfig{
+		CacheTTL:  cfg.StatsCacheTTL,
+		DeltaMode: cfg.StatsDeltaMode,
+		StateDir:  cfg.StateDir,
+		Usernames: usernames,
+	}, xrayCoreInstance, sidecarService, log.Desugar())
+	exportService := services.NewExportService(&services.ExportConfig{
+		Dir: filepath.Join(cfg.StateDir, "exports"),
+	}, statsService, log.Desugar())
 
 	srv := &Server{
-		cfg:             cfg,
-		log:             log,
-		router:          router,
-		xrayCore:        xrayCoreInstance,
-		xrayService:     xrayService,
-		handlerService:  handlerService,
-		statsService:    statsService,
-		visionService:   visionService,
-		internalService: internalService,
+		cfg:                cfg,
+		log:                log,
+		router:             router,
+		xrayCore:           xrayCoreInstance,
+		xrayService:        xrayService,
+		handlerService:     handlerService,
+		statsService:       statsService,
+		visionService:      visionService,
+		internalService:    internalService,
+		wireGuardService:   wireGuardService,
+		sidecarService:     sidecarService,
+		routingService:     routingService,
+		warpService:        warpService,
+		linkService:        linkService,
+		selfTestService:    selfTestService,
+		compatService:      compatService,
+		stateService:       stateService,
+		tuningService:      tuningService,
+		certService:        certService,
+		acmeService:        acmeService,
+		metricsPushService: metricsPushService,
+		heartbeatService:   heartbeatService,
+		clockService:       clockService,
+		capacityService:    capacityService,
+		peerSyncService:    peerSyncService,
+		recorderService:    recorderService,
+		exportService:      exportService,
+		budgetService:      budgetService,
+		sessionService:     sessionService,
+		ebpfService:        ebpfService,
+		hookService:        hookService,
+		policyService:      policyService,
+		healthManager:      healthManager,
+		apiMetrics:         apiMetrics,
+		responseCache:      middleware.NewResponseCache(),
+		jwtKey:             jwtKey,
+		featureFlags:       featureFlags,
 	}
 
 	// Setup routes
 	srv.setupRoutes()
 
+	// Start sidecar cores marked autostart
+	go sidecarService.StartAll()
+
+	// Periodically verify inbounds end-to-end, if enabled
+	selfTestService.StartProber()
+
+	// Periodically probe the core health
+	healthManager.Start()
+
+	// Periodically compare the clock with NTP, if enabled
+	clockService.Start()
+
+	// Sample the node load against the capacity limits, if enabled
+	capacityService.Start()
+
+	// Push traffic samples to InfluxDB/VictoriaMetrics, if enabled
+	metricsPushService.Start()
+
+	// Post status heartbeats to the panel, if enabled
+	heartbeatService.Start()
+
+	// Track node traffic against the budgets, if configured
+	budgetService.Start()
+
+	// Obtain and renew certificates of TLS inbounds, if enabled
+	acmeService.Start()
+
+	// Share Vision blocklists with fleet peers, if enabled
+	if err := peerSyncService.Start(); err != nil {
+		return nil, err
+	}
+
 	// Try to restore Xray state from config file
 	go func() {
 		// Give the server a moment to start
@@ -101,17 +456,35 @@ func (s *Server) Start() error {
 
 // startMainServer starts the main HTTPS server with mTLS
 func (s *Server) startMainServer() error {
+	if s.cfg.InsecureDev {
+		return s.startInsecureDevServer()
+	}
+
 	// Create TLS config
-	tlsConfig, err := s.createTLSConfig()
+	tlsConfig, err := createTLSConfig(s.cfg.NodePayload)
 	if err != nil {
 		return fmt.Errorf("failed to create TLS config: %w", err)
 	}
+	s.tlsConfig.Store(tlsConfig)
+
+	var handler http.Handler = s.router
+	if s.cfg.HTTP3 {
+		s.startHTTP3Server()
+		handler = s.advertiseHTTP3(handler)
+	}
 
 	addr := fmt.Sprintf(":%d", s.cfg.NodePort)
 	s.mainServer = &http.Server{
-		Addr:              addr,
-		Handler:           s.router,
-		TLSConfig:         tlsConfig,
+		Addr:    addr,
+		Handler: handler,
+		// Each handshake uses the current config, so reloaded certificates
+		// apply to new connections without restarting the listener
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.tlsConfig.Load(), nil
+			},
+		},
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       60 * time.Second,
 		WriteTimeout:      60 * time.Second,
@@ -123,15 +496,77 @@ func (s *Server) startMainServer() error {
 		"port", s.cfg.NodePort,
 		"tls", true,
 		"mtls", true,
+		"http3", s.cfg.HTTP3,
 	)
 
 	// Start with TLS
 	return s.mainServer.ListenAndServeTLS("", "")
 }
 
-// createTLSConfig creates the mTLS configuration
-func (s *Server) createTLSConfig() (*tls.Config, error) {
-	payload := s.cfg.NodePayload
+// startHTTP3Server serves the API over HTTP/3 on a UDP port next to the TCP
+// listener, with the same mTLS config. A listener that fails is logged; the
+// API stays available over TCP.
+func (s *Server) startHTTP3Server() {
+	addr := fmt.Sprintf(":%d", s.cfg.HTTP3Port)
+	s.h3Server = &http3.Server{
+		Addr:    addr,
+		Handler: s.router,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			MinVersion: tls.VersionTLS13,
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.tlsConfig.Load(), nil
+			},
+		}),
+		IdleTimeout:    120 * time.Second,
+		MaxHeaderBytes: 65536, // 64KB
+	}
+
+	s.log.Infow("Starting HTTP/3 server", "port", s.cfg.HTTP3Port)
+	go func() {
+		if err := s.h3Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.log.Errorw("HTTP/3 server failed, the API is only served over TCP", "addr", addr, "error", err)
+		}
+	}()
+}
+
+// advertiseHTTP3 adds an Alt-Svc header to responses over TCP so clients
+// that support HTTP/3 can switch to it
+func (s *Server) advertiseHTTP3(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.h3Server.SetQUICHeaders(w.Header()); err != nil {
+			s.log.Debugw("Failed to set Alt-Svc header", "error", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startInsecureDevServer serves the API over plain HTTP on localhost only,
+// without mTLS or JWT authentication (NODE_INSECURE_DEV)
+func (s *Server) startInsecureDevServer() error {
+	if s.cfg.HTTP3 {
+		s.log.Warnw("NODE_HTTP3 is ignored in insecure dev mode, HTTP/3 needs TLS")
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", s.cfg.NodePort)
+	s.mainServer = &http.Server{
+		Addr:              addr,
+		Handler:           s.router,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    65536, // 64KB
+	}
+
+	s.log.Warnw("INSECURE DEV MODE: serving the API over plain HTTP without authentication, do not use in production",
+		"addr", addr,
+	)
+
+	return s.mainServer.ListenAndServe()
+}
+
+// createTLSConfig creates the mTLS configuration from the SECRET_KEY payload
+func createTLSConfig(payload *crypto.NodePayload) (*tls.Config, error) {
 
 	// Parse server certificate
 	cert, err := tls.X509KeyPair([]byte(payload.NodeCertPem), []byte(payload.NodeKeyPem))
@@ -150,16 +585,130 @@ func (s *Server) createTLSConfig() (*tls.Config, error) {
 		ClientCAs:    caCertPool,
 		ClientAuth:   tls.RequireAndVerifyClientCert,
 		MinVersion:   tls.VersionTLS12,
+		// Served through GetConfigForClient, so net/http's default ALPN
+		// setup never sees it; without these HTTP/2 is not negotiated
+		NextProtos: []string{"h2", "http/1.1"},
 	}
 
 	return tlsConfig, nil
 }
 
-// Shutdown gracefully shuts down the server and Xray-core
+// Reload applies the settings that can change without a restart: SECRET_KEY
+// material (server certificate, client CA and JWT key, validated before any
+// is applied) and the log level. The core's log files are reopened for
+// rotation. Other settings take effect on the next restart.
+func (s *Server) Reload(cfg *config.Config) error {
+	// Insecure dev mode has no TLS or JWT to reload and can't be switched at runtime
+	if !s.cfg.InsecureDev {
+		if cfg.NodePayload == nil {
+			return fmt.Errorf("SECRET_KEY is required")
+		}
+		tlsConfig, err := createTLSConfig(cfg.NodePayload)
+		if err != nil {
+			return fmt.Errorf("failed to reload TLS config: %w", err)
+		}
+		if err := s.jwtKey.Set(cfg.NodePayload.JWTPublicKey); err != nil {
+			return fmt.Errorf("failed to reload JWT public key: %w", err)
+		}
+		s.tlsConfig.Store(tlsConfig)
+	}
+
+	s.log.SetLevel(logger.LevelFromEnv())
+
+	if err := s.xrayCore.ReopenLogs(); err != nil {
+		s.log.Warnw("Failed to reopen Xray log files", "error", err)
+	}
+
+	s.log.Infow("Configuration reloaded", "logLevel", logger.LevelFromEnv().String())
+	return nil
+}
+
+// Shutdown gracefully shuts down the server and Xray-core. The API stops
+// accepting requests and in-flight handlers (including batch user operations)
+// are drained first, so nothing is cut off mid-change; uncollected traffic is
+// then saved before the cores are stopped.
 func (s *Server) Shutdown(ctx context.Context) error {
 	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	// Stop accepting requests and wait for in-flight handlers
+	if s.mainServer != nil {
+		if err := s.mainServer.Shutdown(shutdownCtx); err != nil {
+			s.log.Errorw("Main server shutdown error", "error", err)
+		}
+	}
+	if s.h3Server != nil {
+		if err := s.h3Server.Shutdown(shutdownCtx); err != nil {
+			s.log.Errorw("HTTP/3 server shutdown error", "error", err)
+		}
+	}
+
+	// Stop background health probing
+	if s.healthManager != nil {
+		s.healthManager.Stop()
+	}
+
+	// Stop background inbound prober
+	if s.selfTestService != nil {
+		s.selfTestService.StopProber()
+	}
+
+	// Stop metrics push
+	if s.metricsPushService != nil {
+		s.metricsPushService.Stop()
+	}
+
+	// Stop heartbeats
+	if s.heartbeatService != nil {
+		s.heartbeatService.Stop()
+	}
+
+	// Stop clock checks
+	if s.clockService != nil {
+		s.clockService.Stop()
+	}
+
+	// Stop capacity sampling
+	if s.capacityService != nil {
+		s.capacityService.Stop()
+	}
+
+	// Stop ACME renewals
+	s.acmeService.Stop()
+
+	// Count the last traffic against the budgets and save the totals
+	if s.budgetService != nil {
+		s.budgetService.Stop()
+	}
+
+	// Stop running hooks; events not run yet are dropped
+	s.hookService.Stop()
+
+	// Unload the eBPF accounting filter
+	if s.ebpfService != nil {
+		s.ebpfService.Stop()
+	}
+
+	// Stop peer blocklist sync
+	if s.peerSyncService != nil {
+		s.peerSyncService.Stop(shutdownCtx)
+	}
+
+	// Save traffic the panel has not collected yet
+	if s.statsService != nil {
+		if err := s.statsService.SaveSnapshot(context.Background()); err != nil {
+			s.log.Errorw("Failed to save stats snapshot", "error", err)
+		}
+	}
+
+	// Stop sidecar cores (or leave them running, if configured)
+	if s.sidecarService != nil {
+		s.sidecarService.Shutdown()
+	}
+
+	// Let a config.json write still in the background finish
+	s.xrayService.FlushConfig()
+
 	// Stop embedded Xray-core
 	if s.xrayCore != nil {
 		if err := s.xrayCore.Stop(); err != nil {
@@ -167,13 +716,6 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		}
 	}
 
-	// Shutdown main server
-	if s.mainServer != nil {
-		if err := s.mainServer.Shutdown(shutdownCtx); err != nil {
-			s.log.Errorw("Main server shutdown error", "error", err)
-		}
-	}
-
 	return nil
 }
 
@@ -189,25 +731,10 @@ func (s *Server) restoreXrayState() error {
 
 	s.log.Info("Restoring Xray state from config file...")
 
-	// Create a dummy start request with just the config
-	// We don't have the "hashes" here, so we might lose optimization on next sync, but that's fine
-	// Or we could try to re-calculate them if we had the logic exposed.
-	// For now, just starting it is the priority.
+	// config.json is the result of generateApiConfig, so it is started as-is
+	// instead of going through Start. Inbound hashes persisted next to it are
+	// restored so the first panel sync can skip the restart.
 	ctx := context.Background()
 
-	// We need to parse it to pass valid StartRequest if we want to use Service.Start,
-	// or we can use xrayCore directly?
-	// xrayService.Start expects StartRequest structure which wraps the config.
-	// But StartRequest expects separate fields.
-	// Actually, xrayService.Start takes xrayConfig map, generates full config, and writes to file.
-	// Since we already have the FULL config file (generated), we should probably use xrayCore.Start directly
-	// OR, update xrayService to support starting from file.
-
-	// Let's use xrayCore direct start for restoration, but we need to update xrayService state (online status)
-	// The Cleanest way is to decode the config and call xrayService.Start, BUT `config.json` is the *Result* of `generateApiConfig`.
-	// So if we feed it back to `Start`, it might get wrapped again (policy/stats added again).
-	// `xrayService.GetConfig()` reads `config.json` which is the FULL config.
-
-	// Let's modify xrayService to allow "StartFromExistingConfig"
 	return s.xrayService.RestoreStart(ctx)
 }
@@ -0,0 +1,544 @@
+// Package services provides business logic for ACME certificate issuance
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+const (
+	// acmeCheckInterval is how often certificates of TLS inbounds are checked;
+	// only missing or expiring ones are issued, so checks are cheap
+	acmeCheckInterval = time.Hour
+	// acmeIssueTimeout bounds a single issuance, including challenge validation
+	acmeIssueTimeout = 5 * time.Minute
+	// acmeHookTimeout bounds one invocation of the DNS-01 hook
+	acmeHookTimeout = 2 * time.Minute
+)
+
+// AcmeService obtains and renews certificates for the domains of TLS inbounds.
+// Certificates are written to the certificateFile/keyFile paths the inbound
+// already references, and the inbound is reloaded afterwards.
+type AcmeService struct {
+	mu          sync.Mutex
+	issueMu     sync.Mutex // Serializes issuance
+	logger      *zap.Logger
+	xrayCore    *xraycore.Instance
+	certs       *CertService
+	email       string
+	directory   string
+	httpPort    int
+	dnsHook     string
+	renewBefore time.Duration
+	stateDir    string
+
+	status     map[string]*AcmeCertStatus // certificateFile -> last result
+	challenges map[string]string          // HTTP-01 path -> key authorization
+	stop       chan struct{}
+}
+
+// AcmeConfig holds Acme service configuration
+type AcmeConfig struct {
+	Enabled      bool
+	Email        string        // Account contact
+	DirectoryURL string        // ACME directory; empty uses Let's Encrypt
+	HTTPPort     int           // Port of the temporary HTTP-01 listener
+	DNSHook      string        // Executable solving DNS-01; when set, DNS-01 is used instead of HTTP-01
+	RenewBefore  time.Duration // Renew when a certificate expires within this window
+	StateDir     string        // Where the account key is kept
+}
+
+// AcmeCertStatus is the state of one managed certificate
+type AcmeCertStatus struct {
+	Domains         []string   `json:"domains"`
+	CertificateFile string     `json:"certificateFile"`
+	KeyFile         string     `json:"keyFile"`
+	Inbounds        []string   `json:"inbounds"`
+	NotAfter        *time.Time `json:"notAfter,omitempty"`
+	LastAttempt     *time.Time `json:"lastAttempt,omitempty"`
+	LastError       *string    `json:"lastError,omitempty"`
+}
+
+// AcmeStatusResponse is the response of the ACME status endpoint
+type AcmeStatusResponse struct {
+	Enabled   bool              `json:"enabled"`
+	Challenge string            `json:"challenge"` // http-01 or dns-01
+	Certs     []*AcmeCertStatus `json:"certs"`
+}
+
+// AcmeRenewRequest is the request body of the ACME renew endpoint
+type AcmeRenewRequest struct {
+	Force bool `json:"force"` // Reissue even if the certificate is not due
+}
+
+// NewAcmeService creates a new AcmeService, or returns nil if ACME is disabled
+func NewAcmeService(cfg *AcmeConfig, xrayCore *xraycore.Instance, certs *CertService, logger *zap.Logger) *AcmeService {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	directory := cfg.DirectoryURL
+	if directory == "" {
+		directory = acme.LetsEncryptURL
+	}
+	return &AcmeService{
+		logger:      logger,
+		xrayCore:    xrayCore,
+		certs:       certs,
+		email:       cfg.Email,
+		directory:   directory,
+		httpPort:    cfg.HTTPPort,
+		dnsHook:     cfg.DNSHook,
+		renewBefore: cfg.RenewBefore,
+		stateDir:    cfg.StateDir,
+		status:      make(map[string]*AcmeCertStatus),
+		challenges:  make(map[string]string),
+	}
+}
+
+// Start checks certificates in the background
+func (s *AcmeService) Start() {
+	if s == nil || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(acmeCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.Renew(context.Background(), false)
+			}
+		}
+	}(s.stop)
+
+	s.logger.Info("ACME certificate management started",
+		zap.String("directory", s.directory),
+		zap.String("challenge", s.challengeType()))
+}
+
+// Stop stops background checks
+func (s *AcmeService) Stop() {
+	if s != nil && s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// challengeType returns the challenge used for validation
+func (s *AcmeService) challengeType() string {
+	if s.dnsHook != "" {
+		return "dns-01"
+	}
+	return "http-01"
+}
+
+// Status returns the managed certificates and their last results
+func (s *AcmeService) Status() *AcmeStatusResponse {
+	if s == nil {
+		return &AcmeStatusResponse{Certs: make([]*AcmeCertStatus, 0)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certs := make([]*AcmeCertStatus, 0, len(s.status))
+	for _, status := range s.status {
+		copied := *status
+		certs = append(certs, &copied)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].CertificateFile < certs[j].CertificateFile })
+
+	return &AcmeStatusResponse{Enabled: true, Challenge: s.challengeType(), Certs: certs}
+}
+
+// Renew issues certificates that are missing, invalid, not covering their
+// domains or expiring soon (all of them when force is set), then reloads the
+// inbounds using them
+func (s *AcmeService) Renew(ctx context.Context, force bool) *AcmeStatusResponse {
+	if s == nil {
+		return s.Status()
+	}
+
+	s.issueMu.Lock()
+	defer s.issueMu.Unlock()
+
+	targets, err := s.targets()
+	if err != nil {
+		s.logger.Warn("Failed to read TLS inbounds for ACME", zap.Error(err))
+		return s.Status()
+	}
+
+	for _, target := range targets {
+		notAfter, due := s.due(target)
+		target.NotAfter = notAfter
+		if !due && !force {
+			s.record(target, nil)
+			continue
+		}
+
+		now := time.Now()
+		target.LastAttempt = &now
+		notAfter, err := s.issue(ctx, target)
+		if err == nil {
+			target.NotAfter = notAfter
+			_, err = s.certs.ReloadUsing(ctx, target.CertificateFile, target.KeyFile)
+		}
+		if err != nil {
+			s.logger.Error("ACME certificate issuance failed",
+				zap.Strings("domains", target.Domains),
+				zap.Error(err))
+		} else {
+			s.logger.Info("ACME certificate issued",
+				zap.Strings("domains", target.Domains),
+				zap.Time("notAfter", *notAfter))
+		}
+		s.record(target, err)
+	}
+
+	return s.Status()
+}
+
+// record stores the result for a target, keeping the previous attempt when none was made
+func (s *AcmeService) record(target *AcmeCertStatus, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, exists := s.status[target.CertificateFile]; exists && target.LastAttempt == nil {
+		target.LastAttempt = previous.LastAttempt
+		target.LastError = previous.LastError
+	}
+	if err != nil {
+		errMsg := err.Error()
+		target.LastError = &errMsg
+	}
+	s.status[target.CertificateFile] = target
+}
+
+// targets returns the certificates referenced by TLS inbounds with a server name,
+// one per certificate file
+func (s *AcmeService) targets() ([]*AcmeCertStatus, error) {
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return nil, nil
+	}
+
+	var config struct {
+		Inbounds []struct {
+			Tag            string `json:"tag"`
+			StreamSettings struct {
+				Security    string `json:"security"`
+				TLSSettings struct {
+					ServerName   string `json:"serverName"`
+					Certificates []struct {
+						CertificateFile string `json:"certificateFile"`
+						KeyFile         string `json:"keyFile"`
+					} `json:"certificates"`
+				} `json:"tlsSettings"`
+			} `json:"streamSettings"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	byFile := make(map[string]*AcmeCertStatus)
+	var result []*AcmeCertStatus
+	for _, inbound := range config.Inbounds {
+		tlsSettings := inbound.StreamSettings.TLSSettings
+		if inbound.StreamSettings.Security != "tls" || tlsSettings.ServerName == "" {
+			continue
+		}
+		// Wildcards can only be validated over DNS-01
+		if strings.HasPrefix(tlsSettings.ServerName, "*.") && s.dnsHook == "" {
+			continue
+		}
+
+		for _, cert := range tlsSettings.Certificates {
+			if cert.CertificateFile == "" || cert.KeyFile == "" {
+				continue
+			}
+			target, exists := byFile[cert.CertificateFile]
+			if !exists {
+				target = &AcmeCertStatus{CertificateFile: cert.CertificateFile, KeyFile: cert.KeyFile}
+				byFile[cert.CertificateFile] = target
+				result = append(result, target)
+			}
+			if !slices.Contains(target.Domains, tlsSettings.ServerName) {
+				target.Domains = append(target.Domains, tlsSettings.ServerName)
+			}
+			if inbound.Tag != "" && !slices.Contains(target.Inbounds, inbound.Tag) {
+				target.Inbounds = append(target.Inbounds, inbound.Tag)
+			}
+		}
+	}
+	return result, nil
+}
+
+// due reports whether a target needs a new certificate, with the current expiry if readable
+func (s *AcmeService) due(target *AcmeCertStatus) (*time.Time, bool) {
+	data, err := os.ReadFile(target.CertificateFile)
+	if err != nil {
+		return nil, true
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, true
+	}
+
+	notAfter := cert.NotAfter
+	for _, domain := range target.Domains {
+		if cert.VerifyHostname(strings.TrimPrefix(domain, "*.")) != nil {
+			return &notAfter, true
+		}
+	}
+	return &notAfter, time.Until(cert.NotAfter) < s.renewBefore
+}
+
+// issue obtains a certificate for the target and writes it with a new key
+func (s *AcmeService) issue(ctx context.Context, target *AcmeCertStatus) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, acmeIssueTimeout)
+	defer cancel()
+
+	accountKey, err := s.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: s.directory}
+
+	account := &acme.Account{}
+	if s.email != "" {
+		account.Contact = []string{"mailto:" + s.email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(target.Domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+
+	if s.dnsHook == "" {
+		shutdown, err := s.serveHTTPChallenges()
+		if err != nil {
+			return nil, err
+		}
+		defer shutdown()
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err := s.authorize(ctx, client, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("order not ready: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: target.Domains}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid issued certificate: %w", err)
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	for _, path := range []string{target.CertificateFile, target.KeyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.certs.store(target.CertificateFile, target.KeyFile, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+
+	notAfter := leaf.NotAfter
+	return &notAfter, nil
+}
+
+// authorize completes one authorization with the configured challenge
+func (s *AcmeService) authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == s.challengeType() {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("%s challenge not offered for %s", s.challengeType(), authz.Identifier.Value)
+	}
+
+	if s.dnsHook != "" {
+		record, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+		if err := s.runHook(ctx, "present", fqdn, record); err != nil {
+			return err
+		}
+		defer func() {
+			if err := s.runHook(context.Background(), "cleanup", fqdn, record); err != nil {
+				s.logger.Warn("DNS-01 cleanup failed", zap.String("fqdn", fqdn), zap.Error(err))
+			}
+		}()
+	} else {
+		response, err := client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+		path := client.HTTP01ChallengePath(challenge.Token)
+		s.mu.Lock()
+		s.challenges[path] = response
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			delete(s.challenges, path)
+			s.mu.Unlock()
+		}()
+	}
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept challenge: %w", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization of %s failed: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// runHook invokes the DNS-01 hook as `<hook> present|cleanup <fqdn> <value>`
+func (s *AcmeService) runHook(ctx context.Context, action, fqdn, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, acmeHookTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, s.dnsHook, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("DNS hook %s failed: %w: %s", action, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// serveHTTPChallenges listens on the HTTP-01 port until the returned func is called
+func (s *AcmeService) serveHTTPChallenges() (func(), error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(s.httpPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for HTTP-01 challenges: %w", err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			response, exists := s.challenges[r.URL.Path]
+			s.mu.Unlock()
+			if !exists {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(response))
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
+
+// accountKey loads the ACME account key, creating it on first use
+func (s *AcmeService) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(s.stateDir, "acme", "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid ACME account key in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := atomicfile.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600, false); err != nil {
+		return nil, fmt.Errorf("failed to save ACME account key: %w", err)
+	}
+	return key, nil
+}
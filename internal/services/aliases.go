@@ -0,0 +1,185 @@
+// Package services provides business logic for username aliases
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+)
+
+// aliasChainLimit bounds how many renames are followed from one username
+// (A renamed to B, then B to C). Longer chains are rejected when set.
+const aliasChainLimit = 8
+
+// SetUsernameAliasesRequest adds, replaces or removes username aliases
+type SetUsernameAliasesRequest struct {
+	// Old username -> new username; an empty new username removes the alias
+	Aliases map[string]string `json:"aliases" binding:"required"`
+}
+
+// UsernameAliasesResponse lists the username aliases in effect
+type UsernameAliasesResponse struct {
+	Aliases map[string]string `json:"aliases"`
+}
+
+// loadAliases restores the aliases saved by SetUsernameAliases
+func (s *StatsService) loadAliases() {
+	data, err := os.ReadFile(s.aliasesPath)
+	if err != nil {
+		return
+	}
+
+	var aliases map[string]string
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		s.logger.Warn("Failed to parse username aliases", zap.Error(err))
+		return
+	}
+	if aliases != nil {
+		s.aliases = aliases
+	}
+}
+
+// SetUsernameAliases applies alias changes. During a panel-driven rename the
+// core keeps counting traffic under the old username until the user is
+// re-added; with an alias from the old to the new username, that traffic is
+// reported as the new user's instead of as a separate user. Aliases are
+// kept until removed, and survive restarts when a state directory is set.
+func (s *StatsService) SetUsernameAliases(req *SetUsernameAliasesRequest) (*UsernameAliasesResponse, error) {
+	s.aliasMu.Lock()
+	defer s.aliasMu.Unlock()
+
+	aliases := make(map[string]string, len(s.aliases)+len(req.Aliases))
+	for from, to := range s.aliases {
+		aliases[from] = to
+	}
+	for from, to := range req.Aliases {
+		from, to = s.usernames.Normalize(from), s.usernames.Normalize(to)
+		if from == "" {
+			return nil, fmt.Errorf("alias with an empty old username")
+		}
+		if to == "" {
+			delete(aliases, from)
+			continue
+		}
+		if from == to {
+			return nil, fmt.Errorf("alias %q points to itself", from)
+		}
+		aliases[from] = to
+	}
+
+	for from := range aliases {
+		name := from
+		for i := 0; ; i++ {
+			next, exists := aliases[name]
+			if !exists {
+				break
+			}
+			if next == from || i == aliasChainLimit {
+				return nil, fmt.Errorf("alias %q forms a cycle or a chain longer than %d renames", from, aliasChainLimit)
+			}
+			name = next
+		}
+	}
+
+	if s.aliasesPath != "" {
+		if err := s.saveAliases(aliases); err != nil {
+			return nil, err
+		}
+	}
+	s.aliases = aliases
+	s.InvalidateCache()
+
+	s.logger.Info("Username aliases updated", zap.Int("aliases", len(aliases)))
+	return &UsernameAliasesResponse{Aliases: copyAliases(aliases)}, nil
+}
+
+// saveAliases writes the aliases to disk, removing the file when there are none
+func (s *StatsService) saveAliases(aliases map[string]string) error {
+	if len(aliases) == 0 {
+		if err := os.Remove(s.aliasesPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(s.aliasesPath, data, 0600, true); err != nil {
+		return fmt.Errorf("failed to write username aliases: %w", err)
+	}
+	return nil
+}
+
+// UsernameAliases returns the aliases in effect
+func (s *StatsService) UsernameAliases() *UsernameAliasesResponse {
+	s.aliasMu.RLock()
+	defer s.aliasMu.RUnlock()
+
+	return &UsernameAliasesResponse{Aliases: copyAliases(s.aliases)}
+}
+
+// copyAliases returns a copy of an alias map, never nil
+func copyAliases(aliases map[string]string) map[string]string {
+	result := make(map[string]string, len(aliases))
+	for from, to := range aliases {
+		result[from] = to
+	}
+	return result
+}
+
+// resolveAlias returns the username traffic counted under username is
+// reported as, following renames
+func (s *StatsService) resolveAlias(username string) string {
+	s.aliasMu.RLock()
+	defer s.aliasMu.RUnlock()
+
+	return s.resolveAliasLocked(username)
+}
+
+// resolveAliasLocked is resolveAlias with aliasMu held
+func (s *StatsService) resolveAliasLocked(username string) string {
+	for i := 0; i < aliasChainLimit; i++ {
+		next, exists := s.aliases[username]
+		if !exists {
+			break
+		}
+		username = next
+	}
+	return username
+}
+
+// withAliases returns the usernames followed by every old username that
+// resolves to one of them, so lookups by the new username include traffic
+// still counted under the old one
+func (s *StatsService) withAliases(usernames []string) []string {
+	s.aliasMu.RLock()
+	defer s.aliasMu.RUnlock()
+
+	if len(s.aliases) == 0 {
+		return usernames
+	}
+
+	wanted := make(map[string]struct{}, len(usernames))
+	for _, username := range usernames {
+		wanted[username] = struct{}{}
+	}
+
+	var old []string
+	for from := range s.aliases {
+		if _, requested := wanted[from]; requested {
+			continue
+		}
+		if _, exists := wanted[s.resolveAliasLocked(from)]; exists {
+			old = append(old, from)
+		}
+	}
+	sort.Strings(old)
+	return append(append([]string{}, usernames...), old...)
+}
@@ -0,0 +1,53 @@
+// Package services provides business logic for the backends services depend on
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/xtls/xray-core/common/protocol"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// CoreBackend is the embedded core as used by the lifecycle, user and health
+// services. *xraycore.Instance implements it; tests substitute fakes so the
+// service logic can run without a live core.
+type CoreBackend interface {
+	Version() string
+	IsRunning() bool
+	Start(ctx context.Context, configJSON []byte) error
+	Stop() error
+	Restart(ctx context.Context, configJSON []byte) error
+	GetConfig() []byte
+
+	AddUser(ctx context.Context, inboundTag string, user *protocol.MemoryUser) error
+	RemoveUser(ctx context.Context, inboundTag string, email string) error
+	GetInboundUser(ctx context.Context, inboundTag string, email string) (*protocol.MemoryUser, error)
+	GetInboundUsers(ctx context.Context, inboundTag string) ([]*protocol.MemoryUser, error)
+
+	GetSystemStats(ctx context.Context) (*xraycore.SystemStats, error)
+}
+
+// UserStore is the node's record of which users are in which inbounds and of
+// the panel's inbound hashes. *InternalService implements it.
+type UserStore interface {
+	GetXtlsConfigInbounds() []string
+	AddXtlsConfigInbound(tag string)
+	Cleanup()
+
+	AddUserToInbound(email, tag string)
+	RemoveUserFromInbound(email, tag string)
+	GetUsersInInbound(tag string) []string
+	GetUsersCountInInbound(tag string) int
+
+	ExtractUsersFromConfig(config json.RawMessage, hashes *InboundHashes) error
+	IsNeedRestartCore(hashes *InboundHashes) bool
+	ExplainRestart(hashes *InboundHashes) *RestartDecision
+	VerifyInboundHashes(hashes *InboundHashes) []string
+}
+
+var (
+	_ CoreBackend = (*xraycore.Instance)(nil)
+	_ UserStore   = (*InternalService)(nil)
+)
@@ -0,0 +1,458 @@
+// Package services provides business logic for node traffic budgets
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// Budget periods
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// Budget levels, in increasing order of severity
+const (
+	BudgetLevelOK        = "ok"
+	BudgetLevelWarning   = "warning"
+	BudgetLevelCritical  = "critical"
+	BudgetLevelExhausted = "exhausted"
+)
+
+// budgetLevelRank orders the levels so only escalations raise events
+var budgetLevelRank = map[string]int{
+	BudgetLevelOK:        0,
+	BudgetLevelWarning:   1,
+	BudgetLevelCritical:  2,
+	BudgetLevelExhausted: 3,
+}
+
+// budgetMaxEvents bounds the events kept for the status endpoint
+const budgetMaxEvents = 50
+
+// BudgetConfig holds traffic budget configuration
+type BudgetConfig struct {
+	DailyBytes      int64   // 0 disables the daily budget
+	MonthlyBytes    int64   // 0 disables the monthly budget
+	ResetDay        int     // Day of the month (1-28, UTC) the monthly period starts
+	WarnPercent     float64 // Used percentage raising a warning
+	CriticalPercent float64 // Used percentage raising a critical event
+	StopCore        bool    // Stop Xray while a budget is exhausted
+	Interval        time.Duration
+	StateDir        string // Directory where the totals are persisted
+}
+
+// BudgetPeriodStatus reports one budget period
+type BudgetPeriodStatus struct {
+	Period      string    `json:"period"`
+	Limit       int64     `json:"limit"`
+	Used        int64     `json:"used"`
+	Remaining   int64     `json:"remaining"`
+	UsedPercent float64   `json:"usedPercent"`
+	Level       string    `json:"level"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+}
+
+// BudgetEvent is a budget level reached within a period
+type BudgetEvent struct {
+	Time        time.Time `json:"time"`
+	Period      string    `json:"period"`
+	Level       string    `json:"level"`
+	Used        int64     `json:"used"`
+	Limit       int64     `json:"limit"`
+	CoreStopped bool      `json:"coreStopped"`
+}
+
+// BudgetStatus is the current state of the traffic budgets
+type BudgetStatus struct {
+	Enabled     bool                  `json:"enabled"`
+	Periods     []*BudgetPeriodStatus `json:"periods"`
+	CoreStopped bool                  `json:"coreStopped"` // Xray is held stopped until the exhausted period ends
+	Events      []*BudgetEvent        `json:"events"`
+}
+
+// budgetPeriod is the persisted usage of one period
+type budgetPeriod struct {
+	Start time.Time `json:"start"`
+	Used  int64     `json:"used"`
+	Level string    `json:"level"`
+}
+
+// BudgetService tracks node traffic (inbound plus outbound, both directions)
+// against daily and monthly budgets, the way VPS bandwidth caps count it.
+// Totals are persisted so they survive restarts. Crossing the warning and
+// critical thresholds and exhausting a budget are logged and kept as events;
+// optionally the core is stopped until the period ends.
+type BudgetService struct {
+	mu       sync.Mutex
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+	health   *HealthManager
+	cfg      BudgetConfig
+	path     string
+
+	periods     map[string]*budgetPeriod
+	previous    map[string]int64 // counter name -> cumulative value at the previous sample
+	events      []*BudgetEvent
+	coreStopped bool
+	stopper     func(ctx context.Context) error
+	stop        chan struct{}
+}
+
+// NewBudgetService creates a new BudgetService, restoring persisted totals
+func NewBudgetService(cfg *BudgetConfig, xrayCore *xraycore.Instance, health *HealthManager, logger *zap.Logger) *BudgetService {
+	s := &BudgetService{
+		logger:   logger,
+		xrayCore: xrayCore,
+		health:   health,
+		cfg:      *cfg,
+		path:     filepath.Join(cfg.StateDir, "traffic-budget.json"),
+		periods:  make(map[string]*budgetPeriod),
+		previous: make(map[string]int64),
+	}
+	if s.cfg.ResetDay < 1 {
+		s.cfg.ResetDay = 1
+	}
+
+	if !s.Enabled() {
+		return s
+	}
+
+	if data, err := os.ReadFile(s.path); err == nil {
+		if err := json.Unmarshal(data, &s.periods); err != nil {
+			logger.Warn("Failed to parse stored traffic budget", zap.Error(err))
+			s.periods = make(map[string]*budgetPeriod)
+		}
+	}
+	s.roll(time.Now())
+
+	// A budget exhausted before the restart still holds the core
+	s.coreStopped = s.cfg.StopCore && s.exhausted()
+
+	return s
+}
+
+// SetCoreStopper sets how the core is stopped once a budget is exhausted. It
+// must go through the lifecycle (XrayService.Stop) so the stop is serialized
+// with starts and user mutations.
+func (s *BudgetService) SetCoreStopper(stop func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopper = stop
+}
+
+// Enabled reports whether any budget is configured
+func (s *BudgetService) Enabled() bool {
+	return s.cfg.DailyBytes > 0 || s.cfg.MonthlyBytes > 0
+}
+
+// Start begins sampling traffic in the background, if a budget is configured
+func (s *BudgetService) Start() {
+	if !s.Enabled() || s.cfg.Interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.sample()
+			}
+		}
+	}(s.stop)
+
+	s.logger.Info("Traffic budget tracking started",
+		zap.Int64("dailyBytes", s.cfg.DailyBytes),
+		zap.Int64("monthlyBytes", s.cfg.MonthlyBytes))
+}
+
+// Stop stops sampling and saves the totals
+func (s *BudgetService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.stop = nil
+
+	s.sample()
+}
+
+// StartBlocked returns an error while the core is held stopped by an
+// exhausted budget
+func (s *BudgetService) StartBlocked() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.coreStopped {
+		return nil
+	}
+	s.roll(time.Now())
+	if !s.coreStopped {
+		return nil
+	}
+	return fmt.Errorf("traffic budget exhausted, core stays stopped until the period ends")
+}
+
+// Status returns the current usage of each budget and the recent events
+func (s *BudgetService) Status() *BudgetStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.roll(now)
+
+	status := &BudgetStatus{
+		Enabled:     s.Enabled(),
+		Periods:     []*BudgetPeriodStatus{},
+		CoreStopped: s.coreStopped,
+		Events:      append([]*BudgetEvent{}, s.events...),
+	}
+	for _, name := range []string{BudgetPeriodDaily, BudgetPeriodMonthly} {
+		limit := s.limit(name)
+		if limit <= 0 {
+			continue
+		}
+		period := s.periods[name]
+		status.Periods = append(status.Periods, &BudgetPeriodStatus{
+			Period:      name,
+			Limit:       limit,
+			Used:        period.Used,
+			Remaining:   max(limit-period.Used, 0),
+			UsedPercent: float64(period.Used) * 100 / float64(limit),
+			Level:       period.Level,
+			Start:       period.Start,
+			End:         s.periodEnd(name, period.Start),
+		})
+	}
+	return status
+}
+
+// sample adds the traffic since the previous sample and persists the totals
+func (s *BudgetService) sample() {
+	var delta int64
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		counters, err := s.xrayCore.GetCumulativeStats(context.Background(), "")
+		if err != nil {
+			s.logger.Warn("Failed to read counters for traffic budget", zap.Error(err))
+		} else {
+			delta = s.counterDelta(counters)
+		}
+	}
+
+	s.mu.Lock()
+	stopCore := s.record(delta, time.Now())
+	s.save()
+	s.mu.Unlock()
+
+	if stopCore {
+		s.stopCore()
+	}
+}
+
+// counterDelta sums the inbound and outbound traffic since the previous
+// sample. The cumulative counters are unaffected by panel resets, so one lower
+// than before belongs to a restarted core and its whole value is new traffic.
+func (s *BudgetService) counterDelta(counters map[string]int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var delta int64
+	for name, value := range counters {
+		// <kind>>>><name>>>>traffic>>><direction>
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 4 || parts[2] != "traffic" || (parts[0] != "inbound" && parts[0] != "outbound") {
+			continue
+		}
+
+		if last, exists := s.previous[name]; exists && value >= last {
+			delta += value - last
+		} else {
+			delta += value
+		}
+		s.previous[name] = value
+	}
+
+	// Counters of removed inbounds and outbounds are forgotten
+	for name := range s.previous {
+		if _, exists := counters[name]; !exists {
+			delete(s.previous, name)
+		}
+	}
+	return delta
+}
+
+// record adds traffic to every budget, raising events for each level reached.
+// It reports whether the core should be stopped. (mu held)
+func (s *BudgetService) record(delta int64, now time.Time) bool {
+	s.roll(now)
+
+	for _, name := range []string{BudgetPeriodDaily, BudgetPeriodMonthly} {
+		limit := s.limit(name)
+		if limit <= 0 {
+			continue
+		}
+		period := s.periods[name]
+		period.Used += delta
+
+		level := budgetLevel(period.Used, limit, s.cfg.WarnPercent, s.cfg.CriticalPercent)
+		if budgetLevelRank[level] <= budgetLevelRank[period.Level] {
+			continue
+		}
+		period.Level = level
+
+		event := &BudgetEvent{
+			Time:        now,
+			Period:      name,
+			Level:       level,
+			Used:        period.Used,
+			Limit:       limit,
+			CoreStopped: level == BudgetLevelExhausted && s.cfg.StopCore,
+		}
+		s.events = append(s.events, event)
+		if len(s.events) > budgetMaxEvents {
+			s.events = s.events[len(s.events)-budgetMaxEvents:]
+		}
+
+		fields := []zap.Field{
+			zap.String("period", name),
+			zap.String("level", level),
+			zap.Int64("used", period.Used),
+			zap.Int64("limit", limit),
+		}
+		if level == BudgetLevelWarning {
+			s.logger.Warn("Traffic budget threshold reached", fields...)
+		} else {
+			s.logger.Error("Traffic budget threshold reached", fields...)
+		}
+	}
+
+	if s.cfg.StopCore && !s.coreStopped && s.exhausted() {
+		s.coreStopped = true
+		return true
+	}
+	return false
+}
+
+// roll starts new periods for those that have ended, releasing the core once
+// no budget is exhausted any more (mu held)
+func (s *BudgetService) roll(now time.Time) {
+	for _, name := range []string{BudgetPeriodDaily, BudgetPeriodMonthly} {
+		if s.limit(name) <= 0 {
+			continue
+		}
+		start := s.periodStart(name, now)
+		if period, exists := s.periods[name]; exists && period.Start.Equal(start) {
+			continue
+		}
+		s.periods[name] = &budgetPeriod{Start: start, Level: BudgetLevelOK}
+	}
+
+	if s.coreStopped && !s.exhausted() {
+		s.coreStopped = false
+		s.logger.Info("Traffic budget period ended, core may be started again")
+	}
+}
+
+// exhausted reports whether any budget is used up (mu held)
+func (s *BudgetService) exhausted() bool {
+	for name, period := range s.periods {
+		if s.limit(name) > 0 && period.Level == BudgetLevelExhausted {
+			return true
+		}
+	}
+	return false
+}
+
+// limit returns the configured bytes of a period, 0 if disabled
+func (s *BudgetService) limit(name string) int64 {
+	if name == BudgetPeriodDaily {
+		return s.cfg.DailyBytes
+	}
+	return s.cfg.MonthlyBytes
+}
+
+// periodStart returns the start of the period containing now, in UTC
+func (s *BudgetService) periodStart(name string, now time.Time) time.Time {
+	now = now.UTC()
+	if name == BudgetPeriodDaily {
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	start := time.Date(now.Year(), now.Month(), s.cfg.ResetDay, 0, 0, 0, 0, time.UTC)
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// periodEnd returns the end of the period starting at start
+func (s *BudgetService) periodEnd(name string, start time.Time) time.Time {
+	if name == BudgetPeriodDaily {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// save persists the totals (mu held)
+func (s *BudgetService) save() {
+	data, err := json.Marshal(s.periods)
+	if err != nil {
+		s.logger.Warn("Failed to encode traffic budget", zap.Error(err))
+		return
+	}
+	if err := atomicfile.WriteFile(s.path, data, 0600, false); err != nil {
+		s.logger.Warn("Failed to save traffic budget", zap.Error(err))
+	}
+}
+
+// stopCore stops Xray after a budget was exhausted
+func (s *BudgetService) stopCore() {
+	s.mu.Lock()
+	stop := s.stopper
+	s.mu.Unlock()
+
+	if stop == nil || s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return
+	}
+	if err := stop(context.Background()); err != nil {
+		s.logger.Error("Failed to stop Xray after traffic budget exhaustion", zap.Error(err))
+		return
+	}
+	if s.health != nil {
+		s.health.MarkDown("traffic budget exhausted")
+	}
+	s.logger.Error("Traffic budget exhausted, Xray stopped until the period ends")
+}
+
+// budgetLevel returns the level of a budget with used of limit bytes consumed
+func budgetLevel(used, limit int64, warnPercent, criticalPercent float64) string {
+	percent := float64(used) * 100 / float64(limit)
+	switch {
+	case used >= limit:
+		return BudgetLevelExhausted
+	case criticalPercent > 0 && percent >= criticalPercent:
+		return BudgetLevelCritical
+	case warnPercent > 0 && percent >= warnPercent:
+		return BudgetLevelWarning
+	}
+	return BudgetLevelOK
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	_ "github.com/xtls/xray-core/main/distro/all"
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+func newTestBudget(t *testing.T, stopCore bool) *BudgetService {
+	t.Helper()
+
+	return NewBudgetService(&BudgetConfig{
+		DailyBytes:      1000,
+		MonthlyBytes:    10000,
+		ResetDay:        15,
+		WarnPercent:     80,
+		CriticalPercent: 95,
+		StopCore:        stopCore,
+		StateDir:        t.TempDir(),
+	}, nil, nil, zap.NewNop())
+}
+
+func TestBudget_LevelsRaiseEventsOnce(t *testing.T) {
+	s := newTestBudget(t, false)
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+
+	s.mu.Lock()
+	s.record(850, now)
+	s.record(10, now)
+	s.record(100, now)
+	stop := s.record(100, now)
+	s.mu.Unlock()
+
+	if stop {
+		t.Error("Expected the core to keep running without StopCore")
+	}
+
+	var levels []string
+	for _, event := range s.events {
+		levels = append(levels, event.Period+":"+event.Level)
+	}
+	want := []string{"daily:warning", "daily:critical", "daily:exhausted"}
+	if len(levels) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, levels)
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("Expected events %v, got %v", want, levels)
+			break
+		}
+	}
+
+	if used := s.periods[BudgetPeriodMonthly].Used; used != 1060 {
+		t.Errorf("Expected the monthly budget to count the same traffic, got %d", used)
+	}
+}
+
+func TestBudget_StopCoreUntilPeriodEnds(t *testing.T) {
+	s := newTestBudget(t, true)
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+
+	s.mu.Lock()
+	stop := s.record(1000, now)
+	s.mu.Unlock()
+	if !stop || !s.coreStopped {
+		t.Fatal("Expected an exhausted budget to stop the core")
+	}
+
+	// The next day the daily budget is fresh again
+	s.mu.Lock()
+	s.roll(now.Add(24 * time.Hour))
+	s.mu.Unlock()
+	if s.coreStopped {
+		t.Error("Expected the core to be released once the daily period ended")
+	}
+	if used := s.periods[BudgetPeriodDaily].Used; used != 0 {
+		t.Errorf("Expected a new daily period, got %d used", used)
+	}
+	if used := s.periods[BudgetPeriodMonthly].Used; used != 1000 {
+		t.Errorf("Expected the monthly period to continue, got %d used", used)
+	}
+}
+
+func TestBudget_MonthlyPeriodStartsOnResetDay(t *testing.T) {
+	s := newTestBudget(t, false)
+
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 14, 23, 0, 0, 0, time.UTC), time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2025, 12, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := s.periodStart(BudgetPeriodMonthly, tt.now); !got.Equal(tt.want) {
+			t.Errorf("periodStart(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestBudget_PersistsTotals(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &BudgetConfig{DailyBytes: 1000, StopCore: true, StateDir: dir}
+
+	s := NewBudgetService(cfg, nil, nil, zap.NewNop())
+	s.mu.Lock()
+	s.record(1000, time.Now())
+	s.save()
+	s.mu.Unlock()
+
+	restored := NewBudgetService(cfg, nil, nil, zap.NewNop())
+	if used := restored.periods[BudgetPeriodDaily].Used; used != 1000 {
+		t.Errorf("Expected the daily total to be restored, got %d", used)
+	}
+	if restored.StartBlocked() == nil {
+		t.Error("Expected an exhausted budget to keep blocking starts after a restart")
+	}
+}
+
+// startEchoServer starts a TCP server that sends back whatever it receives
+// and returns its port
+func startEchoServer(t *testing.T) int {
+	t.Helper()
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return echo.Addr().(*net.TCPAddr).Port
+}
+
+// freePort returns a local TCP port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer probe.Close()
+	return probe.Addr().(*net.TCPAddr).Port
+}
+
+// echoThrough sends n bytes to the echo server behind port, reads them back
+// and waits until counted, which reports the traffic counted so far, has
+// grown by both directions
+func echoThrough(t *testing.T, port, n int, counted func() int64) {
+	t.Helper()
+
+	before := counted()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if counted()-before >= 2*int64(n) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Traffic of %d bytes was not counted", n)
+}
+
+// startTrafficCore starts a real core with a dokodemo-door inbound "relay" in
+// front of a local echo server. The returned func pushes n bytes through it
+// and back, waiting until both inbound counters have counted them.
+func startTrafficCore(t *testing.T) (*xraycore.Instance, func(n int)) {
+	t.Helper()
+
+	echoPort := startEchoServer(t)
+	relayPort := freePort(t)
+
+	core := xraycore.New(&xraycore.Config{Logger: zap.NewNop()})
+	config := fmt.Sprintf(`{
+		"stats": {},
+		"policy": {
+			"levels": {"0": {"uplinkOnly": 0, "downlinkOnly": 0}},
+			"system": {"statsInboundUplink": true, "statsInboundDownlink": true}
+		},
+		"inbounds": [{"tag": "relay", "listen": "127.0.0.1", "port": %d, "protocol": "dokodemo-door",
+			"settings": {"address": "127.0.0.1", "port": %d, "network": "tcp"}}],
+		"outbounds": [{"tag": "DIRECT", "protocol": "freedom"}]
+	}`, relayPort, echoPort)
+	if err := core.Start(context.Background(), []byte(config)); err != nil {
+		t.Fatalf("Failed to start the core: %v", err)
+	}
+	t.Cleanup(func() { core.Stop() })
+
+	send := func(n int) {
+		t.Helper()
+		echoThrough(t, relayPort, n, func() int64 {
+			counters, _ := core.GetCumulativeStats(context.Background(), "inbound>>>relay>>>")
+			return counters["inbound>>>relay>>>traffic>>>uplink"] + counters["inbound>>>relay>>>traffic>>>downlink"]
+		})
+	}
+	return core, send
+}
+
+func TestBudget_CountsTrafficAcrossPanelResets(t *testing.T) {
+	core, send := startTrafficCore(t)
+	s := NewBudgetService(&BudgetConfig{
+		DailyBytes: 1 << 30,
+		StateDir:   t.TempDir(),
+	}, core, nil, zap.NewNop())
+
+	send(1000)
+	s.sample()
+	first := s.periods[BudgetPeriodDaily].Used
+	if first < 2000 {
+		t.Fatalf("Expected both directions of 1000 bytes to be counted, got %d", first)
+	}
+
+	// The panel resets the counters, then the same amount flows again
+	if _, err := core.GetStats(context.Background(), "inbound>>>", true); err != nil {
+		t.Fatal(err)
+	}
+	send(1000)
+	s.sample()
+	if used := s.periods[BudgetPeriodDaily].Used; used < 2*first {
+		t.Errorf("Expected traffic after a reset to be counted, got %d then %d", first, used)
+	}
+
+	// Delta-mode collection subtracts what it read
+	counters, err := core.GetStats(context.Background(), "inbound>>>", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.SubtractStats(context.Background(), counters); err != nil {
+		t.Fatal(err)
+	}
+	send(1000)
+	s.sample()
+	if used := s.periods[BudgetPeriodDaily].Used; used < 3*first {
+		t.Errorf("Expected traffic after a subtraction to be counted, got %d", used)
+	}
+}
+
+func TestBudget_StopsCoreThroughLifecycle(t *testing.T) {
+	core, _ := startTrafficCore(t)
+	s := NewBudgetService(&BudgetConfig{
+		DailyBytes: 1000,
+		StopCore:   true,
+		StateDir:   t.TempDir(),
+	}, core, nil, zap.NewNop())
+
+	stops := 0
+	s.SetCoreStopper(func(ctx context.Context) error {
+		stops++
+		return core.Stop()
+	})
+
+	s.mu.Lock()
+	stop := s.record(1000, time.Now())
+	s.mu.Unlock()
+	if !stop {
+		t.Fatal("Expected an exhausted budget to stop the core")
+	}
+	s.stopCore()
+	if stops != 1 || core.IsRunning() {
+		t.Errorf("Expected the core to be stopped through the stopper, got %d calls", stops)
+	}
+}
@@ -0,0 +1,148 @@
+// Package services provides business logic for reporting build information
+package services
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/xtls/xray-core/core"
+)
+
+// xrayCoreModule is the module path of the embedded core
+const xrayCoreModule = "github.com/xtls/xray-core"
+
+// Build details set at link time (see SetBuildDetails)
+var (
+	buildGitCommit = "unknown"
+	buildTime      = "unknown"
+)
+
+// SetBuildDetails sets the git commit and build time injected via -ldflags
+// (called during initialization)
+func SetBuildDetails(gitCommit, builtAt string) {
+	if gitCommit != "" {
+		buildGitCommit = gitCommit
+	}
+	if builtAt != "" {
+		buildTime = builtAt
+	}
+}
+
+// Protocol implementations are detected by whether their config message is
+// registered, i.e. whether the package is linked into the binary
+var (
+	inboundProtocolMessages = map[string]string{
+		"vless":            "xray.proxy.vless.inbound.Config",
+		"vmess":            "xray.proxy.vmess.inbound.Config",
+		"trojan":           "xray.proxy.trojan.ServerConfig",
+		"shadowsocks":      "xray.proxy.shadowsocks.ServerConfig",
+		"shadowsocks-2022": "xray.proxy.shadowsocks_2022.ServerConfig",
+		"dokodemo-door":    "xray.proxy.dokodemo.Config",
+		"http":             "xray.proxy.http.ServerConfig",
+		"socks":            "xray.proxy.socks.ServerConfig",
+		"wireguard":        "xray.proxy.wireguard.DeviceConfig",
+	}
+	outboundProtocolMessages = map[string]string{
+		"freedom":     "xray.proxy.freedom.Config",
+		"blackhole":   "xray.proxy.blackhole.Config",
+		"dns":         "xray.proxy.dns.Config",
+		"loopback":    "xray.proxy.loopback.Config",
+		"vless":       "xray.proxy.vless.outbound.Config",
+		"vmess":       "xray.proxy.vmess.outbound.Config",
+		"trojan":      "xray.proxy.trojan.ClientConfig",
+		"shadowsocks": "xray.proxy.shadowsocks.ClientConfig",
+		"http":        "xray.proxy.http.ClientConfig",
+		"socks":       "xray.proxy.socks.ClientConfig",
+		"wireguard":   "xray.proxy.wireguard.DeviceConfig",
+	}
+	transportMessages = map[string]string{
+		"tcp":         "xray.transport.internet.tcp.Config",
+		"kcp":         "xray.transport.internet.kcp.Config",
+		"websocket":   "xray.transport.internet.websocket.Config",
+		"httpupgrade": "xray.transport.internet.httpupgrade.Config",
+		"xhttp":       "xray.transport.internet.splithttp.Config",
+		"grpc":        "xray.transport.internet.grpc.encoding.Config",
+		"tls":         "xray.transport.internet.tls.Config",
+		"reality":     "xray.transport.internet.reality.Config",
+	}
+)
+
+// ProtocolSupport lists the protocols compiled into the binary
+type ProtocolSupport struct {
+	Inbounds   []string `json:"inbounds"`
+	Outbounds  []string `json:"outbounds"`
+	Transports []string `json:"transports"`
+}
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version         string          `json:"version"`
+	GitCommit       string          `json:"gitCommit"`
+	GitModified     bool            `json:"gitModified,omitempty"` // Built from a dirty tree
+	BuildTime       string          `json:"buildTime"`
+	GoVersion       string          `json:"goVersion"`
+	Platform        string          `json:"platform"`
+	XrayCoreVersion string          `json:"xrayCoreVersion"`
+	XrayCoreModule  string          `json:"xrayCoreModule"` // Module version from go.mod, e.g. v1.251208.0
+	Features        map[string]bool `json:"features"`
+	Protocols       ProtocolSupport `json:"protocols"`
+}
+
+// CurrentBuildInfo returns the build information with the given feature flags
+func CurrentBuildInfo(features map[string]bool) *BuildInfo {
+	info := &BuildInfo{
+		Version:         NodeVersion(),
+		GitCommit:       buildGitCommit,
+		BuildTime:       buildTime,
+		GoVersion:       runtime.Version(),
+		Platform:        runtime.GOOS + "/" + runtime.GOARCH,
+		XrayCoreVersion: core.Version(),
+		XrayCoreModule:  "unknown",
+		Features:        features,
+		Protocols: ProtocolSupport{
+			Inbounds:   linkedProtocols(inboundProtocolMessages),
+			Outbounds:  linkedProtocols(outboundProtocolMessages),
+			Transports: linkedProtocols(transportMessages),
+		},
+	}
+
+	// Fall back to the VCS stamp of `go build` when no commit was injected
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == xrayCoreModule {
+				info.XrayCoreModule = dep.Version
+				if dep.Replace != nil {
+					info.XrayCoreModule = dep.Replace.Path + "@" + dep.Replace.Version
+				}
+			}
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.GitCommit == "unknown" {
+					info.GitCommit = setting.Value
+				}
+			case "vcs.modified":
+				info.GitModified = setting.Value == "true"
+			}
+		}
+	}
+
+	return info
+}
+
+// linkedProtocols returns the sorted names whose config message is registered
+func linkedProtocols(messages map[string]string) []string {
+	names := make([]string, 0, len(messages))
+	for name, message := range messages {
+		if _, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(message)); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
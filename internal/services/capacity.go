@@ -0,0 +1,284 @@
+// Package services provides business logic for capacity-based admission
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// Resources tracked against capacity limits
+const (
+	CapacityCPU         = "cpu"
+	CapacityMemory      = "memory"
+	CapacityOnlineUsers = "onlineUsers"
+	CapacityBandwidth   = "bandwidth"
+)
+
+// CapacityConfig holds Capacity service configuration. A limit of 0 leaves
+// the resource out of the score.
+type CapacityConfig struct {
+	Interval         time.Duration // Sample interval; 0 disables
+	MaxCPUPercent    float64       // Host CPU usage at which the node is full
+	MaxMemoryPercent float64       // Memory usage (of the cgroup limit in a container) at which the node is full
+	MaxOnlineUsers   int
+	MaxBandwidthMbps float64 // Inbound traffic, both directions
+	HardCap          bool    // Reject add-user requests while the node is full
+}
+
+// CapacityLoad is the node load at the last sample, measured against the
+// capacity limits
+type CapacityLoad struct {
+	Score         int                `json:"score"` // Headroom left: 100 minus the highest utilization, 0 when full
+	Full          bool               `json:"full"`  // A resource is at or over its limit
+	CPUPercent    float64            `json:"cpuPercent"`
+	MemoryPercent float64            `json:"memoryPercent"`
+	OnlineUsers   int                `json:"onlineUsers"` // -1 if unknown
+	BandwidthMbps float64            `json:"bandwidthMbps"`
+	Utilization   map[string]float64 `json:"utilization"` // Percent of the limit, per resource with a limit and a measurement
+	SampledAt     time.Time          `json:"sampledAt"`
+}
+
+// CapacityService samples the node load against configurable limits, so the
+// panel can place users on the nodes with the most headroom. With a hard cap
+// it is also a policy rejecting new users while the node is full.
+type CapacityService struct {
+	mu       sync.RWMutex
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+	cfg      CapacityConfig
+	load     *CapacityLoad
+	stop     chan struct{}
+
+	// Previous sample, for rates
+	cpuIdle, cpuTotal uint64
+	traffic           map[string]int64
+	sampledAt         time.Time
+}
+
+// NewCapacityService creates a new CapacityService
+func NewCapacityService(cfg *CapacityConfig, xrayCore *xraycore.Instance, logger *zap.Logger) *CapacityService {
+	return &CapacityService{
+		logger:   logger,
+		xrayCore: xrayCore,
+		cfg:      *cfg,
+	}
+}
+
+// Start begins sampling in the background, if enabled
+func (s *CapacityService) Start() {
+	if s.cfg.Interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+
+		s.Sample(context.Background())
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.Sample(context.Background())
+			}
+		}
+	}(s.stop)
+}
+
+// Stop stops sampling
+func (s *CapacityService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// Load returns the load at the last sample, or nil before the first one
+func (s *CapacityService) Load() *CapacityLoad {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.load == nil {
+		return nil
+	}
+	load := *s.load
+	return &load
+}
+
+// Sample measures the load now. CPU usage and bandwidth are averaged since
+// the previous sample, so the first sample reports neither.
+func (s *CapacityService) Sample(ctx context.Context) *CapacityLoad {
+	now := time.Now()
+	load := &CapacityLoad{OnlineUsers: -1, Utilization: map[string]float64{}, SampledAt: now.UTC()}
+
+	idle, total, cpuErr := readCPUTimes()
+	memory, memErr := memoryUsagePercent()
+	var traffic map[string]int64
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		if users, err := s.xrayCore.GetOnlineUsers(ctx); err == nil {
+			load.OnlineUsers = len(users)
+		}
+		if counters, err := s.xrayCore.GetCumulativeStats(ctx, "inbound>>>"); err == nil {
+			traffic = counters
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cpuErr == nil {
+		if s.cpuTotal > 0 && total > s.cpuTotal {
+			load.CPUPercent = 100 * (1 - float64(idle-s.cpuIdle)/float64(total-s.cpuTotal))
+			s.utilize(load, CapacityCPU, load.CPUPercent, s.cfg.MaxCPUPercent)
+		}
+		s.cpuIdle, s.cpuTotal = idle, total
+	}
+	if memErr == nil {
+		load.MemoryPercent = memory
+		s.utilize(load, CapacityMemory, memory, s.cfg.MaxMemoryPercent)
+	}
+	if load.OnlineUsers >= 0 {
+		s.utilize(load, CapacityOnlineUsers, float64(load.OnlineUsers), float64(s.cfg.MaxOnlineUsers))
+	}
+	if traffic != nil {
+		if s.traffic != nil {
+			load.BandwidthMbps = float64(trafficDelta(s.traffic, traffic)) * 8 / 1e6 / now.Sub(s.sampledAt).Seconds()
+			s.utilize(load, CapacityBandwidth, load.BandwidthMbps, s.cfg.MaxBandwidthMbps)
+		}
+		s.traffic = traffic
+	}
+	s.sampledAt = now
+
+	highest := 0.0
+	for _, percent := range load.Utilization {
+		highest = math.Max(highest, percent)
+	}
+	load.Full = highest >= 100
+	load.Score = int(math.Max(0, math.Floor(100-highest)))
+
+	if load.Full && (s.load == nil || !s.load.Full) {
+		s.logger.Warn("Node is at capacity", zap.Any("utilization", load.Utilization))
+	} else if !load.Full && s.load != nil && s.load.Full {
+		s.logger.Info("Node is below capacity again", zap.Int("score", load.Score))
+	}
+	s.load = load
+
+	copied := *load
+	return &copied
+}
+
+// utilize records the utilization of a resource with a limit (mu held)
+func (s *CapacityService) utilize(load *CapacityLoad, resource string, value, limit float64) {
+	if limit > 0 {
+		load.Utilization[resource] = math.Round(10000*value/limit) / 100
+	}
+}
+
+// Check implements Policy: with a hard cap, new users are rejected while the
+// node is full, so the panel places them on another node
+func (s *CapacityService) Check(ctx context.Context, req *PolicyRequest) (*PolicyDecision, error) {
+	if !s.cfg.HardCap || req.Kind != PolicyAddUser {
+		return nil, nil
+	}
+	load := s.Load()
+	if load == nil || !load.Full {
+		return nil, nil
+	}
+	var full []string
+	for resource, percent := range load.Utilization {
+		if percent >= 100 {
+			full = append(full, fmt.Sprintf("%s %.0f%%", resource, percent))
+		}
+	}
+	return &PolicyDecision{Deny: "node at capacity (" + strings.Join(full, ", ") + ")"}, nil
+}
+
+// trafficDelta sums the counter increases since the previous sample. The
+// counters are cumulative, so one lower than before belongs to a restarted
+// core and its whole value is new traffic.
+func trafficDelta(previous, current map[string]int64) int64 {
+	var delta int64
+	for name, value := range current {
+		if last, ok := previous[name]; ok && value >= last {
+			delta += value - last
+		} else {
+			delta += value
+		}
+	}
+	return delta
+}
+
+// readCPUTimes returns the idle (including I/O wait) and total CPU time of
+// the host from /proc/stat, in clock ticks (Linux only)
+func readCPUTimes() (idle, total uint64, err error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return 0, 0, errors.New("unexpected /proc/stat format")
+	}
+	// user nice system idle iowait irq softirq steal; guest time is already in user
+	for i, field := range fields[1:min(len(fields), 9)] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return idle, total, nil
+}
+
+// memoryUsagePercent returns the memory in use as a percentage of the cgroup
+// limit in a container, or of the host memory (Linux only)
+func memoryUsagePercent() (float64, error) {
+	if limit := DetectContainerLimits().MemoryLimit; limit > 0 {
+		for _, path := range []string{"/sys/fs/cgroup/memory.current", "/sys/fs/cgroup/memory/memory.usage_in_bytes"} {
+			if used, err := readCgroupInt(path); err == nil {
+				return 100 * float64(used) / float64(limit), nil
+			}
+		}
+	}
+
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	var total, available int64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseInt(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	if total <= 0 {
+		return 0, errors.New("MemTotal not found in /proc/meminfo")
+	}
+	return 100 * float64(total-available) / float64(total), nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCapacityHardCap(t *testing.T) {
+	if _, err := os.Stat("/proc/meminfo"); err != nil {
+		t.Skip("needs /proc/meminfo")
+	}
+	ctx := context.Background()
+
+	// Any memory use is over a limit this low
+	capacity := NewCapacityService(&CapacityConfig{MaxMemoryPercent: 0.0001, HardCap: true}, nil, zap.NewNop())
+	policies := NewPolicyService(&PolicyConfig{Builtin: []BuiltinPolicy{{Name: "capacity", Policy: capacity}}}, zap.NewNop())
+
+	if err := policies.Check(ctx, &PolicyRequest{Kind: PolicyAddUser, Username: "alice"}); err != nil {
+		t.Errorf("Expected users to be admitted before the first sample, got %v", err)
+	}
+
+	load := capacity.Sample(ctx)
+	if !load.Full || load.Score != 0 || load.OnlineUsers != -1 {
+		t.Fatalf("Expected a full node without online users, got %+v", load)
+	}
+	if _, ok := load.Utilization[CapacityCPU]; ok {
+		t.Error("Expected no CPU utilization without a CPU limit")
+	}
+	if err := policies.Check(ctx, &PolicyRequest{Kind: PolicyAddUser, Username: "alice"}); err == nil {
+		t.Error("Expected users to be rejected while the node is full")
+	}
+	if err := policies.Check(ctx, &PolicyRequest{Kind: PolicyRemoveUser, Username: "alice"}); err != nil {
+		t.Errorf("Expected removals to be allowed while the node is full, got %v", err)
+	}
+	if infos := policies.Policies(); len(infos) != 1 || infos[0].Name != "capacity" || infos[0].Denied != 1 {
+		t.Errorf("Unexpected policies %+v", infos)
+	}
+
+	// Without the hard cap a full node is only reported
+	soft := NewCapacityService(&CapacityConfig{MaxMemoryPercent: 0.0001}, nil, zap.NewNop())
+	soft.Sample(ctx)
+	if decision, _ := soft.Check(ctx, &PolicyRequest{Kind: PolicyAddUser}); decision != nil {
+		t.Errorf("Expected no decision without the hard cap, got %+v", decision)
+	}
+}
+
+func TestCapacityScore(t *testing.T) {
+	if _, err := os.Stat("/proc/meminfo"); err != nil {
+		t.Skip("needs /proc/meminfo")
+	}
+
+	s := NewCapacityService(&CapacityConfig{}, nil, zap.NewNop())
+	if load := s.Sample(context.Background()); load.Score != 100 || load.Full || len(load.Utilization) != 0 {
+		t.Errorf("Expected full headroom without limits, got %+v", load)
+	}
+
+	s = NewCapacityService(&CapacityConfig{MaxMemoryPercent: 100}, nil, zap.NewNop())
+	load := s.Sample(context.Background())
+	if want := int(100 - load.Utilization[CapacityMemory]); load.Score < want-1 || load.Score > want {
+		t.Errorf("Expected score %d for memory at %.2f%%, got %d", want, load.MemoryPercent, load.Score)
+	}
+	if s.Load().Score != load.Score {
+		t.Error("Expected Load to return the last sample")
+	}
+}
+
+func TestTrafficDelta(t *testing.T) {
+	previous := map[string]int64{"inbound>>>a>>>traffic>>>uplink": 100, "inbound>>>b>>>traffic>>>uplink": 500}
+	current := map[string]int64{
+		"inbound>>>a>>>traffic>>>uplink":   150, // +50
+		"inbound>>>b>>>traffic>>>uplink":   20,  // Reset: +20
+		"inbound>>>c>>>traffic>>>downlink": 30,  // New: +30
+	}
+	if delta := trafficDelta(previous, current); delta != 100 {
+		t.Errorf("Expected 100 bytes, got %d", delta)
+	}
+}
@@ -0,0 +1,284 @@
+// Package services provides business logic for inbound TLS certificate files
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// certNamePattern restricts certificate names to safe file names
+var certNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// CertService stores TLS certificates referenced by inbounds and reloads the
+// inbounds using them when they are rotated
+type CertService struct {
+	mu       sync.Mutex
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+	dir      string
+}
+
+// CertConfig holds Cert service configuration
+type CertConfig struct {
+	Dir string // Where certificates are stored, e.g. <config dir>/certs
+}
+
+// NewCertService creates a new CertService
+func NewCertService(cfg *CertConfig, xrayCore *xraycore.Instance, logger *zap.Logger) *CertService {
+	return &CertService{
+		logger:   logger,
+		xrayCore: xrayCore,
+		dir:      cfg.Dir,
+	}
+}
+
+// CertInfo describes a stored certificate
+type CertInfo struct {
+	Name            string    `json:"name"`
+	CertificateFile string    `json:"certificateFile"` // Path to reference in inbound tlsSettings
+	KeyFile         string    `json:"keyFile"`
+	Domains         []string  `json:"domains"`
+	NotBefore       time.Time `json:"notBefore"`
+	NotAfter        time.Time `json:"notAfter"`
+}
+
+// UploadCertRequest represents a request to store or rotate a certificate
+type UploadCertRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Certificate string `json:"certificate" binding:"required"` // PEM chain, leaf first
+	Key         string `json:"key" binding:"required"`         // PEM private key
+}
+
+// UploadCertResponse represents the result of storing a certificate
+type UploadCertResponse struct {
+	Cert     *CertInfo `json:"cert"`
+	Reloaded []string  `json:"reloaded"` // Inbounds reloaded to pick up the new files
+	Error    *string   `json:"error"`    // Set when a reload failed; the files are stored regardless
+}
+
+// DeleteCertRequest represents a request to delete a stored certificate
+type DeleteCertRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// ListCertsResponse represents all stored certificates
+type ListCertsResponse struct {
+	Certs []*CertInfo `json:"certs"`
+}
+
+// paths returns the certificate and key file paths of a name
+func (s *CertService) paths(name string) (string, string, error) {
+	if !certNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf("invalid certificate name %q", name)
+	}
+	return filepath.Join(s.dir, name+".crt"), filepath.Join(s.dir, name+".key"), nil
+}
+
+// describeCert builds the info of a certificate from its PEM chain
+func describeCert(name, certFile, keyFile string, certPEM []byte) (*CertInfo, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	domains := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		domains = append(domains, ip.String())
+	}
+	if len(domains) == 0 && cert.Subject.CommonName != "" {
+		domains = []string{cert.Subject.CommonName}
+	}
+	return &CertInfo{
+		Name:            name,
+		CertificateFile: certFile,
+		KeyFile:         keyFile,
+		Domains:         domains,
+		NotBefore:       cert.NotBefore,
+		NotAfter:        cert.NotAfter,
+	}, nil
+}
+
+// List returns all stored certificates
+func (s *CertService) List() (*ListCertsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certs := make([]*CertInfo, 0)
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ListCertsResponse{Certs: certs}, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name, isCert := strings.CutSuffix(entry.Name(), ".crt")
+		if !isCert || entry.IsDir() {
+			continue
+		}
+		certFile, keyFile, err := s.paths(name)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			continue
+		}
+		info, err := describeCert(name, certFile, keyFile, data)
+		if err != nil {
+			s.logger.Warn("Skipping unreadable certificate", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		certs = append(certs, info)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Name < certs[j].Name })
+
+	return &ListCertsResponse{Certs: certs}, nil
+}
+
+// Upload validates and stores a certificate and key, then reloads the inbounds
+// that reference the files so the new certificate is served immediately
+func (s *CertService) Upload(ctx context.Context, req *UploadCertRequest) (*UploadCertResponse, error) {
+	certFile, keyFile, err := s.paths(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tls.X509KeyPair([]byte(req.Certificate), []byte(req.Key)); err != nil {
+		return nil, fmt.Errorf("certificate and key do not form a valid pair: %w", err)
+	}
+	info, err := describeCert(req.Name, certFile, keyFile, []byte(req.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store(certFile, keyFile, []byte(req.Certificate), []byte(req.Key)); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Stored certificate",
+		zap.String("name", req.Name),
+		zap.Strings("domains", info.Domains),
+		zap.Time("notAfter", info.NotAfter))
+
+	reloaded, reloadErr := s.ReloadUsing(ctx, certFile, keyFile)
+	resp := &UploadCertResponse{Cert: info, Reloaded: reloaded}
+	if reloadErr != nil {
+		errMsg := reloadErr.Error()
+		resp.Error = &errMsg
+	}
+	return resp, nil
+}
+
+// store writes a certificate (0644) and its key (0600)
+func (s *CertService) store(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	// Key first, so a certificate never points at an older key
+	if err := atomicfile.WriteFile(keyFile, keyPEM, 0600, false); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := atomicfile.WriteFile(certFile, certPEM, 0644, false); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a stored certificate. Inbounds still referencing it keep the
+// loaded copy until the next restart.
+func (s *CertService) Delete(req *DeleteCertRequest) error {
+	certFile, keyFile, err := s.paths(req.Name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	s.logger.Info("Deleted certificate", zap.String("name", req.Name))
+	return nil
+}
+
+// ReloadUsing reloads every running inbound whose TLS settings reference one of
+// the given files, returning the reloaded tags
+func (s *CertService) ReloadUsing(ctx context.Context, files ...string) ([]string, error) {
+	reloaded := make([]string, 0)
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return reloaded, nil
+	}
+
+	var config struct {
+		Inbounds []struct {
+			Tag            string `json:"tag"`
+			StreamSettings struct {
+				TLSSettings struct {
+					Certificates []struct {
+						CertificateFile string `json:"certificateFile"`
+						KeyFile         string `json:"keyFile"`
+					} `json:"certificates"`
+				} `json:"tlsSettings"`
+			} `json:"streamSettings"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return reloaded, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(files))
+	for _, file := range files {
+		wanted[filepath.Clean(file)] = true
+	}
+
+	var failed []string
+	for _, inbound := range config.Inbounds {
+		uses := false
+		for _, cert := range inbound.StreamSettings.TLSSettings.Certificates {
+			uses = uses || wanted[filepath.Clean(cert.CertificateFile)] || wanted[filepath.Clean(cert.KeyFile)]
+		}
+		if !uses || inbound.Tag == "" {
+			continue
+		}
+
+		if err := s.xrayCore.ReloadInbound(ctx, inbound.Tag); err != nil {
+			s.logger.Error("Failed to reload inbound", zap.String("tag", inbound.Tag), zap.Error(err))
+			failed = append(failed, inbound.Tag)
+			continue
+		}
+		s.logger.Info("Reloaded inbound for new certificate", zap.String("tag", inbound.Tag))
+		reloaded = append(reloaded, inbound.Tag)
+	}
+
+	if len(failed) > 0 {
+		return reloaded, fmt.Errorf("failed to reload inbounds: %s", strings.Join(failed, ", "))
+	}
+	return reloaded, nil
+}
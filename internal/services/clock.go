@@ -0,0 +1,218 @@
+// Package services provides business logic for clock synchronization checks
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ntpEpochOffset is the number of seconds between 1900 (NTP) and 1970 (Unix)
+const ntpEpochOffset = 2208988800
+
+// ntpTimeout bounds one query to one NTP server
+const ntpTimeout = 5 * time.Second
+
+// ClockState is the result of the last clock check
+type ClockState struct {
+	Server      string    `json:"server,omitempty"` // NTP server that answered
+	SkewMs      int64     `json:"skewMs"`           // Node clock minus NTP time; positive when the node is ahead
+	RoundTripMs int64     `json:"roundTripMs"`
+	CheckedAt   time.Time `json:"checkedAt"`
+	Warning     string    `json:"warning,omitempty"` // Set while the skew exceeds the threshold
+	Error       string    `json:"error,omitempty"`   // Set when no server answered
+}
+
+// ClockConfig holds Clock service configuration
+type ClockConfig struct {
+	Servers  []string      // NTP servers (host or host:port), tried in order until one answers
+	Interval time.Duration // Check interval; 0 disables
+	WarnSkew time.Duration // Skew beyond which a warning is reported
+}
+
+// ClockService periodically compares the node clock with NTP servers. REALITY
+// handshakes and JWT validation fail when the clock is off, so skew beyond
+// the threshold is logged and reported in the health state.
+type ClockService struct {
+	mu       sync.RWMutex
+	logger   *zap.Logger
+	servers  []string
+	interval time.Duration
+	warnSkew time.Duration
+	state    *ClockState
+	stop     chan struct{}
+}
+
+// NewClockService creates a new ClockService
+func NewClockService(cfg *ClockConfig, logger *zap.Logger) *ClockService {
+	return &ClockService{
+		logger:   logger,
+		servers:  cfg.Servers,
+		interval: cfg.Interval,
+		warnSkew: cfg.WarnSkew,
+	}
+}
+
+// Start begins checking the clock in the background, if configured
+func (s *ClockService) Start() {
+	if len(s.servers) == 0 || s.interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		s.Check(context.Background())
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.Check(context.Background())
+			}
+		}
+	}(s.stop)
+}
+
+// Stop stops checking the clock
+func (s *ClockService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// State returns the result of the last check, or nil before the first one
+func (s *ClockService) State() *ClockState {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.state == nil {
+		return nil
+	}
+	state := *s.state
+	return &state
+}
+
+// Check queries the NTP servers now and records the result, logging when the
+// skew starts or stops exceeding the threshold
+func (s *ClockService) Check(ctx context.Context) *ClockState {
+	state := &ClockState{CheckedAt: time.Now().UTC()}
+
+	var errs []error
+	for _, server := range s.servers {
+		offset, rtt, err := queryNTP(ctx, server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		skew := -offset
+		state.Server = server
+		state.SkewMs = skew.Milliseconds()
+		state.RoundTripMs = rtt.Milliseconds()
+		if s.warnSkew > 0 && (skew > s.warnSkew || skew < -s.warnSkew) {
+			state.Warning = fmt.Sprintf("clock is off by %s (threshold %s)", skew.Round(time.Millisecond), s.warnSkew)
+		}
+		errs = nil
+		break
+	}
+	if errs != nil {
+		state.Error = errors.Join(errs...).Error()
+	}
+
+	s.mu.Lock()
+	previous := s.state
+	s.state = state
+	s.mu.Unlock()
+
+	switch {
+	case state.Warning != "" && (previous == nil || previous.Warning == ""):
+		s.logger.Warn("Node clock is skewed; REALITY handshakes and JWT validation may fail",
+			zap.Int64("skewMs", state.SkewMs), zap.String("server", state.Server))
+	case state.Warning == "" && state.Error == "" && previous != nil && previous.Warning != "":
+		s.logger.Info("Node clock is back in sync", zap.Int64("skewMs", state.SkewMs))
+	case state.Error != "" && (previous == nil || previous.Error == ""):
+		s.logger.Warn("Clock check failed", zap.String("error", state.Error))
+	}
+
+	copied := *state
+	return &copied
+}
+
+// queryNTP sends one SNTP (RFC 4330) request and returns the offset of the
+// server clock from the local clock and the round-trip delay
+func queryNTP(ctx context.Context, server string) (offset, rtt time.Duration, err error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ntpTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Version 4, client mode; the transmit timestamp comes back as the
+	// originate timestamp, matching the response to the request
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, 0, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case n < 48:
+		return 0, 0, errors.New("short NTP response")
+	case resp[0]&0x7 != 4:
+		return 0, 0, errors.New("not an NTP server response")
+	case resp[1] == 0 || resp[1] > 15:
+		return 0, 0, fmt.Errorf("server unsynchronized (stratum %d)", resp[1])
+	case binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]):
+		return 0, 0, errors.New("NTP response does not match the request")
+	}
+
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	offset = (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	rtt = received.Sub(sent) - serverSent.Sub(serverReceived)
+	return offset, rtt, nil
+}
+
+// toNTPTime converts a time to the 64-bit NTP timestamp format
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// fromNTPTime converts a 64-bit NTP timestamp to a time
+func fromNTPTime(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeNTPServer answers SNTP requests with its clock shifted by offset
+func fakeNTPServer(t *testing.T, offset time.Duration) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			now := toNTPTime(time.Now().Add(offset))
+			resp := make([]byte, 48)
+			resp[0] = 4<<3 | 4
+			resp[1] = 2
+			copy(resp[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(resp[32:], now)
+			binary.BigEndian.PutUint64(resp[40:], now)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	now := time.Now()
+	if back := fromNTPTime(toNTPTime(now)); back.Sub(now).Abs() > time.Microsecond {
+		t.Errorf("Expected %v back, got %v", now, back)
+	}
+}
+
+func TestClockService_Check(t *testing.T) {
+	s := NewClockService(&ClockConfig{
+		Servers:  []string{"127.0.0.1:1", fakeNTPServer(t, 10*time.Second)},
+		WarnSkew: 2 * time.Second,
+	}, zap.NewNop())
+	if s.State() != nil {
+		t.Error("Expected no state before the first check")
+	}
+
+	state := s.Check(context.Background())
+	if state.Error != "" || state.SkewMs > -9900 || state.SkewMs < -10100 || state.Warning == "" {
+		t.Errorf("Expected a skew of -10s with a warning, got %+v", state)
+	}
+
+	s = NewClockService(&ClockConfig{Servers: []string{fakeNTPServer(t, 0)}, WarnSkew: 2 * time.Second}, zap.NewNop())
+	if state := s.Check(context.Background()); state.Error != "" || state.Warning != "" {
+		t.Errorf("Expected a synchronized clock, got %+v", state)
+	}
+}
@@ -0,0 +1,196 @@
+// Package services provides business logic for panel payload compatibility
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Panel payload formats
+const (
+	PayloadFormatCurrent = "current"
+	PayloadFormatLegacy  = "legacy"
+)
+
+// Endpoints with compatibility shims
+const (
+	CompatEndpointStart   = "xray/start"
+	CompatEndpointAddUser = "handler/add-user"
+)
+
+// CompatService detects which panel payload shape a request uses, converts
+// legacy shapes to the current ones and keeps counts for the compatibility
+// report, so nodes and panels can be upgraded independently
+type CompatService struct {
+	mu     sync.Mutex
+	logger *zap.Logger
+	seen   map[string]*compatCounter // endpoint -> counter
+}
+
+// compatCounter counts payload formats seen on one endpoint
+type compatCounter struct {
+	current    int64
+	legacy     int64
+	lastLegacy time.Time
+}
+
+// NewCompatService creates a new CompatService
+func NewCompatService(logger *zap.Logger) *CompatService {
+	return &CompatService{
+		logger: logger,
+		seen:   make(map[string]*compatCounter),
+	}
+}
+
+// record counts a payload of the given format
+func (s *CompatService) record(endpoint, format string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, exists := s.seen[endpoint]
+	if !exists {
+		counter = &compatCounter{}
+		s.seen[endpoint] = counter
+	}
+
+	if format == PayloadFormatLegacy {
+		if counter.legacy == 0 {
+			s.logger.Info("Panel sent legacy payload format", zap.String("endpoint", endpoint))
+		}
+		counter.legacy++
+		counter.lastLegacy = time.Now()
+		return
+	}
+	counter.current++
+}
+
+// ParseStartRequest decodes a start payload.
+// Current: { internals: {...}, xrayConfig: {...} }
+// Legacy:  the Xray config itself as the body
+func (s *CompatService) ParseStartRequest(body []byte) (*StartRequest, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+
+	var req StartRequest
+	switch {
+	case fields["xrayConfig"] != nil:
+		if internals := fields["internals"]; internals != nil {
+			if err := json.Unmarshal(internals, &req.Internals); err != nil {
+				return nil, err
+			}
+		}
+		if !isJSONObject(fields["xrayConfig"]) {
+			return nil, fmt.Errorf("xrayConfig must be an object")
+		}
+		req.XrayConfig = fields["xrayConfig"]
+		s.record(CompatEndpointStart, PayloadFormatCurrent)
+	case fields["inbounds"] != nil || fields["outbounds"] != nil:
+		// The body was decoded as an object above, and is the config itself
+		req.XrayConfig = body
+		s.record(CompatEndpointStart, PayloadFormatLegacy)
+	default:
+		return nil, fmt.Errorf("unrecognized start payload: expected xrayConfig or an Xray config")
+	}
+
+	return &req, nil
+}
+
+// ParseAddUserRequest decodes an add-user payload.
+// Current: { data: [UserData], hashData: {...} }
+// Legacy:  { data: UserData } or a bare UserData, without hashData
+func (s *CompatService) ParseAddUserRequest(body []byte) (*AddUserRequest, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+
+	var req AddUserRequest
+	data, hasData := fields["data"]
+	switch {
+	case hasData && len(data) > 0 && data[0] == '[':
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		s.record(CompatEndpointAddUser, PayloadFormatCurrent)
+		return &req, nil
+	case hasData && len(data) > 0 && data[0] == '{':
+		var user UserData
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, err
+		}
+		req.Data = []UserData{user}
+	case fields["username"] != nil && fields["tag"] != nil:
+		var user UserData
+		if err := json.Unmarshal(body, &user); err != nil {
+			return nil, err
+		}
+		req.Data = []UserData{user}
+	default:
+		return nil, fmt.Errorf("unrecognized add-user payload: expected data")
+	}
+
+	// Legacy panels send no hashData; the vless UUID is what the hashes track
+	if hashData, ok := fields["hashData"]; ok {
+		if err := json.Unmarshal(hashData, &req.HashData); err != nil {
+			return nil, err
+		}
+	}
+	if req.HashData.VlessUUID == "" {
+		for _, user := range req.Data {
+			if user.UUID != "" {
+				req.HashData.VlessUUID = user.UUID
+				break
+			}
+		}
+	}
+
+	s.record(CompatEndpointAddUser, PayloadFormatLegacy)
+	return &req, nil
+}
+
+// EndpointCompat reports the payload formats one endpoint has received
+type EndpointCompat struct {
+	Endpoint     string     `json:"endpoint"`
+	Current      int64      `json:"current"`
+	Legacy       int64      `json:"legacy"`
+	LastLegacyAt *time.Time `json:"lastLegacyAt"`
+}
+
+// CompatReportResponse represents the compatibility report
+type CompatReportResponse struct {
+	SupportedFormats []string          `json:"supportedFormats"`
+	Endpoints        []*EndpointCompat `json:"endpoints"`
+}
+
+// GetReport returns the payload formats accepted and seen since start
+func (s *CompatService) GetReport() *CompatReportResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make([]*EndpointCompat, 0, 2)
+	for _, endpoint := range []string{CompatEndpointStart, CompatEndpointAddUser} {
+		entry := &EndpointCompat{Endpoint: endpoint}
+		if counter, exists := s.seen[endpoint]; exists {
+			entry.Current = counter.current
+			entry.Legacy = counter.legacy
+			if counter.legacy > 0 {
+				lastLegacy := counter.lastLegacy
+				entry.LastLegacyAt = &lastLegacy
+			}
+		}
+		endpoints = append(endpoints, entry)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Endpoint < endpoints[j].Endpoint })
+
+	return &CompatReportResponse{
+		SupportedFormats: []string{PayloadFormatCurrent, PayloadFormatLegacy},
+		Endpoints:        endpoints,
+	}
+}
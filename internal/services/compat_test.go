@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCompat_ParseStartRequest_RejectsNonObjectConfig(t *testing.T) {
+	compat := NewCompatService(zap.NewNop())
+
+	for _, body := range []string{
+		`{"xrayConfig":null}`,
+		`{"xrayConfig":[]}`,
+		`{"xrayConfig":"{}"}`,
+		`null`,
+		`[]`,
+	} {
+		if _, err := compat.ParseStartRequest([]byte(body)); err == nil {
+			t.Errorf("Expected %s to be rejected", body)
+		}
+	}
+}
+
+func TestCompat_ParseAddUserRequest_Legacy(t *testing.T) {
+	compat := NewCompatService(zap.NewNop())
+
+	req, err := compat.ParseAddUserRequest([]byte(`{"data":{"type":"vless","tag":"VLESS","username":"alice","uuid":"` + testUUID1 + `"}}`))
+	if err != nil {
+		t.Fatalf("ParseAddUserRequest returned error: %v", err)
+	}
+	if len(req.Data) != 1 || req.Data[0].Username != "alice" {
+		t.Errorf("Expected one user alice, got %+v", req.Data)
+	}
+	if req.HashData.VlessUUID != testUUID1 {
+		t.Errorf("Expected the vless UUID to be derived from the user, got %q", req.HashData.VlessUUID)
+	}
+}
+
+func FuzzParseStartRequest(f *testing.F) {
+	f.Add([]byte(`{"internals":{"forceRestart":false,"hashes":{"emptyConfig":"e","inbounds":[]}},"xrayConfig":{"inbounds":[]}}`))
+	f.Add([]byte(`{"inbounds":[],"outbounds":[{"protocol":"freedom"}]}`))
+	f.Add([]byte(`{"xrayConfig":null}`))
+	f.Add([]byte(`{"internals":null,"xrayConfig":{}}`))
+	f.Add([]byte(`{"xrayConfig":{"inbounds":[`))
+
+	compat := NewCompatService(zap.NewNop())
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req, err := compat.ParseStartRequest(body)
+		if err != nil {
+			return
+		}
+		if req.XrayConfig == nil {
+			t.Fatalf("Accepted %q without an Xray config", body)
+		}
+	})
+}
+
+func FuzzParseAddUserRequest(f *testing.F) {
+	f.Add([]byte(`{"data":[{"type":"vless","tag":"VLESS","username":"alice","uuid":"` + testUUID1 + `"}],"hashData":{"vlessUuid":"` + testUUID1 + `"}}`))
+	f.Add([]byte(`{"data":{"type":"trojan","tag":"TROJAN","username":"bob","password":"secret"}}`))
+	f.Add([]byte(`{"type":"shadowsocks","tag":"SS","username":"carol","password":"secret"}`))
+	f.Add([]byte(`{"data":null,"hashData":null}`))
+	f.Add([]byte(`{"data":[null]}`))
+
+	compat := NewCompatService(zap.NewNop())
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req, err := compat.ParseAddUserRequest(body)
+		if err != nil {
+			return
+		}
+		if req == nil {
+			t.Fatalf("Accepted %q without a request", body)
+		}
+	})
+}
@@ -0,0 +1,338 @@
+// Package services provides business logic for handling large Xray configs
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// configDocument is an Xray config held as raw JSON per top-level section,
+// so a start never materializes the whole config as Go maps (a 100MB config
+// takes several times that as map[string]interface{}). Only the small
+// sections the node rewrites are decoded, and inbounds are split into their
+// fields: settings, with the client lists that make up most of a large
+// config, are carried through to the core untouched.
+type configDocument struct {
+	sections map[string]json.RawMessage
+	inbounds []map[string]json.RawMessage // nil when the config has no inbounds array
+}
+
+// parseConfigDocument splits a config object into its sections
+func parseConfigDocument(data []byte) (*configDocument, error) {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+	if sections == nil {
+		return nil, fmt.Errorf("xray config must be an object")
+	}
+
+	doc := &configDocument{sections: sections}
+	if raw, exists := sections["inbounds"]; exists {
+		var inbounds []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &inbounds); err == nil && inbounds != nil {
+			doc.inbounds = inbounds
+			delete(sections, "inbounds")
+		}
+	}
+	return doc, nil
+}
+
+// isJSONObject reports whether valid JSON text is an object
+func isJSONObject(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// has reports whether the config has a top-level section
+func (d *configDocument) has(key string) bool {
+	_, exists := d.sections[key]
+	return exists
+}
+
+// decodeSection decodes a top-level section into v and reports whether the
+// section is present. As the document is valid JSON, the only errors are type
+// mismatches, which leave the affected fields of v at their zero value.
+func (d *configDocument) decodeSection(key string, v interface{}) bool {
+	raw, exists := d.sections[key]
+	if !exists {
+		return false
+	}
+	_ = json.Unmarshal(raw, v)
+	return true
+}
+
+// setSection replaces a top-level section with the JSON encoding of v
+func (d *configDocument) setSection(key string, v interface{}) {
+	data, _ := json.Marshal(v) // Node-built values always marshal
+	d.sections[key] = data
+}
+
+// inboundField decodes a field of inbound i into v and reports whether the
+// field is present, with the same leniency as decodeSection
+func (d *configDocument) inboundField(i int, key string, v interface{}) bool {
+	raw, exists := d.inbounds[i][key]
+	if !exists {
+		return false
+	}
+	_ = json.Unmarshal(raw, v)
+	return true
+}
+
+// inboundTag returns the tag of inbound i, empty if it has none
+func (d *configDocument) inboundTag(i int) string {
+	var tag string
+	d.inboundField(i, "tag", &tag)
+	return tag
+}
+
+// inboundTags returns the tags of all inbounds that have one
+func (d *configDocument) inboundTags() []string {
+	var tags []string
+	for i := range d.inbounds {
+		if tag := d.inboundTag(i); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// setInboundField replaces a field of inbound i with the JSON encoding of v
+func (d *configDocument) setInboundField(i int, key string, v interface{}) {
+	if d.inbounds[i] == nil {
+		return
+	}
+	data, _ := json.Marshal(v) // Node-built values always marshal
+	d.inbounds[i][key] = data
+}
+
+// marshal encodes the document with keys sorted, as json.Marshal would a
+// map, copying the raw sections as they are instead of re-validating them
+func (d *configDocument) marshal() []byte {
+	size := 2
+	for key, raw := range d.sections {
+		size += len(key) + len(raw) + 4
+	}
+	for _, inbound := range d.inbounds {
+		for key, raw := range inbound {
+			size += len(key) + len(raw) + 4
+		}
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size+64))
+	sections := make(map[string]json.RawMessage, len(d.sections)+1)
+	for key, raw := range d.sections {
+		sections[key] = raw
+	}
+	if d.inbounds != nil {
+		sections["inbounds"] = nil // Written from the split inbounds below
+	}
+
+	writeRawObject(buf, sections, func(key string) bool {
+		if key != "inbounds" || d.inbounds == nil {
+			return false
+		}
+		buf.WriteByte('[')
+		for i, inbound := range d.inbounds {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if inbound == nil {
+				buf.WriteString("null")
+				continue
+			}
+			writeRawObject(buf, inbound, nil)
+		}
+		buf.WriteByte(']')
+		return true
+	})
+	return buf.Bytes()
+}
+
+// writeRawObject writes an object of raw values with sorted keys. A value is
+// written by writeValue instead when it returns true.
+func writeRawObject(buf *bytes.Buffer, fields map[string]json.RawMessage, writeValue func(key string) bool) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(key)
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		if writeValue == nil || !writeValue(key) {
+			buf.Write(fields[key])
+		}
+	}
+	buf.WriteByte('}')
+}
+
+// scannedInbound is the tag and client emails of an inbound, as found by
+// scanConfigInbounds
+type scannedInbound struct {
+	Tag     string
+	Emails  []string // Non-empty client emails
+	Clients int      // All clients, including those without an email
+}
+
+// scanConfigInbounds walks inbounds[].tag and inbounds[].settings.clients[]
+// with a streaming decoder. Nothing but the emails is kept and one client is
+// decoded at a time, so peak memory stays small however large the config.
+// Values of unexpected types are skipped, as missing ones would be.
+func scanConfigInbounds(r io.Reader) ([]scannedInbound, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	var inbounds []scannedInbound
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "inbounds" || tok != json.Delim('[') {
+			if err := skipValue(dec, tok); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for dec.More() {
+			inbound, err := scanConfigInbound(dec)
+			if err != nil {
+				return nil, err
+			}
+			inbounds = append(inbounds, inbound)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+	}
+	return inbounds, expectDelim(dec, '}')
+}
+
+// scanConfigInbound reads one element of the inbounds array
+func scanConfigInbound(dec *json.Decoder) (scannedInbound, error) {
+	var inbound scannedInbound
+
+	tok, err := dec.Token()
+	if err != nil {
+		return inbound, err
+	}
+	if tok != json.Delim('{') {
+		return inbound, skipValue(dec, tok)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return inbound, err
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return inbound, err
+		}
+
+		tag, isString := tok.(string)
+		switch {
+		case key == "tag" && isString:
+			inbound.Tag = tag
+		case key == "settings" && tok == json.Delim('{'):
+			if err := scanConfigClients(dec, &inbound); err != nil {
+				return inbound, err
+			}
+		default:
+			if err := skipValue(dec, tok); err != nil {
+				return inbound, err
+			}
+		}
+	}
+	return inbound, expectDelim(dec, '}')
+}
+
+// scanConfigClients reads the rest of an inbound's settings object,
+// collecting the client emails
+func scanConfigClients(dec *json.Decoder, inbound *scannedInbound) error {
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key != "clients" || tok != json.Delim('[') {
+			if err := skipValue(dec, tok); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for dec.More() {
+			var client struct {
+				Email string `json:"email"`
+			}
+			if err := dec.Decode(&client); err != nil {
+				var typeErr *json.UnmarshalTypeError
+				if !errors.As(err, &typeErr) {
+					return err
+				}
+			}
+			inbound.Clients++
+			if client.Email != "" {
+				inbound.Emails = append(inbound.Emails, client.Email)
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token and fails unless it is the given delimiter
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("invalid xray config: expected %q, got %v", delim, tok)
+	}
+	return nil
+}
+
+// skipValue skips the rest of a value whose first token was already read
+func skipValue(dec *json.Decoder, first json.Token) error {
+	if first != json.Delim('{') && first != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
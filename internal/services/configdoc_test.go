@@ -0,0 +1,154 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestConfigDocument_GenerateApiConfig(t *testing.T) {
+	t.Setenv("NODE_LISTEN", `10.0.0.1"`)
+
+	core := newFakeCore(false)
+	health := NewHealthManager(&HealthConfig{}, core, zap.NewNop())
+	s := NewXrayService(&XrayConfig{
+		ConfigDir:        t.TempDir(),
+		TemplatePrefix:   "NODE_",
+		InboundOverrides: map[string]InboundOverride{"TROJAN": {Port: 8443}},
+	}, core, nil, health, zap.NewNop())
+
+	input := []byte(`{
+		"inbounds": [
+			{"tag": "VLESS", "listen": "${NODE_LISTEN}", "port": "20000:20010", "settings": {"clients": [{"id": "` + testUUID1 + `", "email": "alice"}]}},
+			{"tag": "TROJAN", "port": 443, "settings": {"clients": []}},
+			null
+		],
+		"outbounds": [{"tag": "DIRECT", "protocol": "freedom"}],
+		"routing": {"rules": []}
+	}`)
+
+	data, warnings := expandConfigTemplate(input, s.templatePrefix)
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected template warnings: %v", warnings)
+	}
+	doc, err := parseConfigDocument(data)
+	if err != nil {
+		t.Fatalf("parseConfigDocument: %v", err)
+	}
+	if warnings := s.generateApiConfig(doc); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(doc.marshal(), &got); err != nil {
+		t.Fatalf("Generated config is not valid JSON: %v", err)
+	}
+
+	inbounds := got["inbounds"].([]interface{})
+	if len(inbounds) != 3 || inbounds[2] != nil {
+		t.Fatalf("Expected all inbounds to be kept in order, got %v", inbounds)
+	}
+	vless := inbounds[0].(map[string]interface{})
+	if vless["listen"] != `10.0.0.1"` {
+		t.Errorf("Expected the placeholder to be expanded and escaped, got %q", vless["listen"])
+	}
+	if vless["port"] != "20000-20010" {
+		t.Errorf("Expected the port range to be normalized, got %v", vless["port"])
+	}
+	clients := vless["settings"].(map[string]interface{})["clients"].([]interface{})
+	if !reflect.DeepEqual(clients[0], map[string]interface{}{"id": testUUID1, "email": "alice"}) {
+		t.Errorf("Expected clients to pass through unchanged, got %v", clients)
+	}
+	if port := inbounds[1].(map[string]interface{})["port"]; port != "8443" {
+		t.Errorf("Expected the override port, got %v", port)
+	}
+
+	for _, section := range []string{"stats", "policy", "log", "outbounds", "routing"} {
+		if _, exists := got[section]; !exists {
+			t.Errorf("Expected section %q in the generated config", section)
+		}
+	}
+}
+
+func TestLintXrayConfig(t *testing.T) {
+	doc, err := parseConfigDocument([]byte(`{
+		"inbounds": [
+			{"tag": "A", "settings": {"clients": [{"id": "x", "email": ""}, {"id": "y"}]}},
+			{"tag": "A"},
+			{"port": 443}
+		],
+		"outbounds": [{"tag": "DIRECT"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`inbound "A" has 2 user(s) with empty email, stats and removal will not work for them`,
+		`duplicate inbound tag "A"`,
+		"inbound #2 has no tag, users cannot be managed on it",
+		`no outbound with tag "BLOCK" found, IP blocking will not work`,
+	}
+	if got := lintXrayConfig(doc, "BLOCK", reservedAPITag); !reflect.DeepEqual(got, want) {
+		t.Errorf("lintXrayConfig() = %v, want %v", got, want)
+	}
+}
+
+func TestScanConfigInbounds(t *testing.T) {
+	config := `{
+		"log": {"inbounds": [{"tag": "NOT-AN-INBOUND"}]},
+		"inbounds": [
+			{"settings": {"decryption": "none", "clients": [{"id": "a", "email": "alice", "flow": ""}, {"id": "b"}, null]}, "tag": "VLESS"},
+			{"tag": "TROJAN", "settings": {"clients": [{"password": "x", "email": 5}, {"email": "bob"}]}},
+			{"tag": ["odd"], "settings": null},
+			null
+		],
+		"outbounds": [{"tag": "DIRECT", "settings": {"clients": [{"email": "nobody"}]}}]
+	}`
+
+	got, err := scanConfigInbounds(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("scanConfigInbounds: %v", err)
+	}
+
+	want := []scannedInbound{
+		{Tag: "VLESS", Emails: []string{"alice"}, Clients: 3},
+		{Tag: "TROJAN", Emails: []string{"bob"}, Clients: 2},
+		{},
+		{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanConfigInbounds() = %+v, want %+v", got, want)
+	}
+
+	for _, invalid := range []string{`[]`, `{"inbounds": [{"tag": "A"}`, `{"inbounds": [{"tag": }]}`} {
+		if _, err := scanConfigInbounds(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestEnsureBlockOutbound(t *testing.T) {
+	doc, _ := parseConfigDocument([]byte(`{"outbounds": [{"tag": "DIRECT", "protocol": "freedom"}]}`))
+	if added, warning := ensureBlockOutbound(doc, "block"); !added || warning != "" {
+		t.Fatalf("Expected the block outbound to be added, got %v %q", added, warning)
+	}
+	if added, _ := ensureBlockOutbound(doc, "block"); added {
+		t.Error("Expected an existing block outbound to be kept")
+	}
+
+	var outbounds []map[string]string
+	doc.decodeSection("outbounds", &outbounds)
+	want := []map[string]string{{"tag": "DIRECT", "protocol": "freedom"}, {"tag": "block", "protocol": "blackhole"}}
+	if !reflect.DeepEqual(outbounds, want) {
+		t.Errorf("Expected the block outbound after the default one, got %v", outbounds)
+	}
+
+	empty, _ := parseConfigDocument([]byte(`{}`))
+	if added, warning := ensureBlockOutbound(empty, "block"); added || warning == "" {
+		t.Error("Expected a config without outbounds to be left alone with a warning")
+	}
+}
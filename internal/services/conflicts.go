@@ -0,0 +1,184 @@
+// Package services provides business logic for detecting conflicting user adds
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/proxy/shadowsocks"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+	"go.uber.org/zap"
+)
+
+// User conflict kinds
+const (
+	ConflictDuplicate = "duplicate" // The same user appears twice in one request with different credentials
+	ConflictOverwrite = "overwrite" // An add replaces an existing user whose credentials differ
+)
+
+// UserConflict is a warning about a user add that replaces other credentials.
+// The add still happens (the last write wins), but the caller is told.
+type UserConflict struct {
+	Kind     string `json:"kind"`
+	Username string `json:"username"`
+	Inbound  string `json:"inbound,omitempty"`
+	Field    string `json:"field"` // Credential that differs, e.g. "uuid" or "password"
+	Message  string `json:"message"`
+}
+
+// logConflicts logs each replaced credential
+func (s *HandlerService) logConflicts(conflicts []*UserConflict) {
+	for _, c := range conflicts {
+		s.logger.Warn("Conflicting user credentials",
+			zap.String("kind", c.Kind),
+			zap.String("username", c.Username),
+			zap.String("inbound", c.Inbound),
+			zap.String("field", c.Field),
+			zap.String("detail", c.Message))
+	}
+}
+
+// existingCredential returns the credential of a user currently in an inbound
+// and the name of its field, or "" if the user is not there
+func (s *HandlerService) existingCredential(ctx context.Context, tag, username string) (string, string) {
+	user, err := s.xrayCore.GetInboundUser(ctx, tag, username)
+	if err != nil || user == nil {
+		return "", ""
+	}
+
+	switch account := user.Account.(type) {
+	case *vless.MemoryAccount:
+		return account.ID.String(), "uuid"
+	case *trojan.MemoryAccount:
+		return account.Password, "password"
+	case *shadowsocks.MemoryAccount:
+		return account.Password, "password"
+	}
+	return "", ""
+}
+
+// sameCredential compares credentials; UUIDs are compared case-insensitively
+func sameCredential(field, a, b string) bool {
+	if field == "uuid" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// addUserConflicts detects items targeting the same inbound with different
+// credentials, and items replacing an existing user with different credentials.
+// A replaced vless UUID equal to prevVlessUuid is an intended rotation.
+func (s *HandlerService) addUserConflicts(ctx context.Context, req *AddUserRequest) []*UserConflict {
+	if !s.flags.Enabled(FlagConflictWarnings) {
+		return nil
+	}
+
+	var conflicts []*UserConflict
+	seen := make(map[string]int) // tag -> first item index
+
+	for i, item := range req.Data {
+		credential, field := item.Password, "password"
+		if item.Type == "vless" {
+			credential, field = item.UUID, "uuid"
+		}
+
+		if first, exists := seen[item.Tag]; exists {
+			previous := req.Data[first]
+			previousCredential := previous.Password
+			if previous.Type == "vless" {
+				previousCredential = previous.UUID
+			}
+			if previous.Type != item.Type || !sameCredential(field, previousCredential, credential) {
+				conflicts = append(conflicts, &UserConflict{
+					Kind:     ConflictDuplicate,
+					Username: item.Username,
+					Inbound:  item.Tag,
+					Field:    field,
+					Message:  fmt.Sprintf("data[%d] and data[%d] target the same inbound with different credentials; data[%d] wins", first, i, i),
+				})
+			}
+			continue
+		}
+		seen[item.Tag] = i
+
+		existing, existingField := s.existingCredential(ctx, item.Tag, item.Username)
+		if existing == "" || existingField != field || sameCredential(field, existing, credential) {
+			continue
+		}
+		if field == "uuid" && req.HashData.PrevVlessUUID != "" && strings.EqualFold(existing, req.HashData.PrevVlessUUID) {
+			continue
+		}
+		conflicts = append(conflicts, &UserConflict{
+			Kind:     ConflictOverwrite,
+			Username: item.Username,
+			Inbound:  item.Tag,
+			Field:    field,
+			Message:  fmt.Sprintf("replaces the existing user's %s", field),
+		})
+	}
+	return conflicts
+}
+
+// addUsersConflicts detects users listed twice in a batch with different
+// credentials, and users replacing an existing user with different credentials
+func (s *HandlerService) addUsersConflicts(ctx context.Context, req *AddUsersRequest) []*UserConflict {
+	if !s.flags.Enabled(FlagConflictWarnings) {
+		return nil
+	}
+
+	var conflicts []*UserConflict
+	seen := make(map[string]int) // userId -> first index
+
+	for i, user := range req.Users {
+		data := user.UserData
+		if first, exists := seen[data.UserId]; exists {
+			previous := req.Users[first].UserData
+			for _, diff := range []struct{ field, kind, a, b string }{
+				{"vlessUuid", "uuid", previous.VlessUuid, data.VlessUuid},
+				{"trojanPassword", "password", previous.TrojanPassword, data.TrojanPassword},
+				{"ssPassword", "password", previous.SsPassword, data.SsPassword},
+			} {
+				if sameCredential(diff.kind, diff.a, diff.b) {
+					continue
+				}
+				conflicts = append(conflicts, &UserConflict{
+					Kind:     ConflictDuplicate,
+					Username: data.UserId,
+					Field:    diff.field,
+					Message:  fmt.Sprintf("users[%d] and users[%d] have different %s; users[%d] wins", first, i, diff.field, i),
+				})
+			}
+			continue
+		}
+		seen[data.UserId] = i
+
+		for _, item := range user.InboundData {
+			credential, field := "", ""
+			switch item.Type {
+			case "vless":
+				credential, field = data.VlessUuid, "uuid"
+			case "trojan":
+				credential, field = data.TrojanPassword, "password"
+			case "shadowsocks":
+				credential, field = data.SsPassword, "password"
+			default:
+				continue
+			}
+
+			existing, existingField := s.existingCredential(ctx, item.Tag, data.UserId)
+			if existing == "" || existingField != field || sameCredential(field, existing, credential) {
+				continue
+			}
+			conflicts = append(conflicts, &UserConflict{
+				Kind:     ConflictOverwrite,
+				Username: data.UserId,
+				Inbound:  item.Tag,
+				Field:    field,
+				Message:  fmt.Sprintf("replaces the existing user's %s", field),
+			})
+		}
+	}
+	return conflicts
+}
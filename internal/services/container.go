@@ -0,0 +1,132 @@
+// Package services provides business logic for container and cgroup detection
+package services
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cgroupUnlimited is the threshold above which a cgroup v1 memory limit means "no limit"
+const cgroupUnlimited = int64(1) << 60
+
+// ContainerLimits describes the container the node runs in, if any
+type ContainerLimits struct {
+	InContainer bool
+	CPUQuota    float64 // CPUs allowed by the cgroup; 0 if unlimited
+	MemoryLimit int64   // Bytes allowed by the cgroup; 0 if unlimited
+}
+
+var (
+	containerLimits     *ContainerLimits
+	containerLimitsOnce sync.Once
+)
+
+// DetectContainerLimits detects a container runtime and reads the cgroup
+// CPU and memory limits (v2, falling back to v1). Linux only; cached.
+func DetectContainerLimits() *ContainerLimits {
+	containerLimitsOnce.Do(func() {
+		limits := &ContainerLimits{}
+		if runtime.GOOS == "linux" {
+			limits.InContainer = inContainer()
+			limits.CPUQuota = cgroupCPUQuota()
+			limits.MemoryLimit = cgroupMemoryLimit()
+		}
+		containerLimits = limits
+	})
+	return containerLimits
+}
+
+// inContainer reports whether the process runs under Docker, Podman, containerd, LXC or Kubernetes
+func inContainer() bool {
+	if os.Getenv("container") != "" {
+		return true
+	}
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+
+	data, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	content := string(data)
+	for _, hint := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if strings.Contains(content, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// cgroupCPUQuota returns the CPU quota in CPUs, or 0 if unlimited
+func cgroupCPUQuota() float64 {
+	// cgroup v2: "<quota> <period>" or "max <period>"
+	if data, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil && period > 0 {
+				return quota / period
+			}
+		}
+		return 0
+	}
+
+	// cgroup v1
+	quota, err1 := readCgroupInt("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	period, err2 := readCgroupInt("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return 0
+	}
+	return float64(quota) / float64(period)
+}
+
+// cgroupMemoryLimit returns the memory limit in bytes, or 0 if unlimited
+func cgroupMemoryLimit() int64 {
+	// cgroup v2
+	if data, err := os.ReadFile("/sys/fs/cgroup/memory.max"); err == nil {
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			return 0
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return limit
+	}
+
+	// cgroup v1
+	limit, err := readCgroupInt("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+		return 0
+	}
+	return limit
+}
+
+// readCgroupInt reads a single integer cgroup file
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// effectiveCPUCores returns the CPU count, capped by a cgroup quota (rounded up)
+func effectiveCPUCores() int {
+	cores := runtime.NumCPU()
+	if quota := DetectContainerLimits().CPUQuota; quota > 0 {
+		if limited := int(math.Ceil(quota)); limited < cores {
+			return limited
+		}
+	}
+	return cores
+}
@@ -0,0 +1,192 @@
+// Package services provides business logic for eBPF traffic accounting
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/ebpfacct"
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// defaultAccountingClients is how many client addresses are listed per
+// inbound by default
+const defaultAccountingClients = 50
+
+// EbpfAccountingService counts the traffic of the inbound ports in the
+// kernel, independently of the core, as a cross-check of Xray's counters
+type EbpfAccountingService struct {
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+	enabled  bool
+
+	mu        sync.Mutex
+	accounter *ebpfacct.Accounter
+	openErr   error
+	since     time.Time
+}
+
+// NewEbpfAccountingService creates a new EbpfAccountingService. The filter
+// is loaded right away when the ebpf_accounting flag is on.
+func NewEbpfAccountingService(xrayCore *xraycore.Instance, flags *featureflags.Set, logger *zap.Logger) *EbpfAccountingService {
+	s := &EbpfAccountingService{
+		logger:   logger,
+		xrayCore: xrayCore,
+		enabled:  flags.Enabled(FlagEbpfAccounting),
+	}
+	if !s.enabled {
+		return s
+	}
+
+	s.accounter, s.openErr = ebpfacct.Open()
+	if s.openErr != nil {
+		logger.Warn("eBPF accounting unavailable", zap.Error(s.openErr))
+		return s
+	}
+	s.since = time.Now()
+	logger.Info("eBPF accounting started")
+	return s
+}
+
+// GetAccountingRequest selects the inbounds to report
+type GetAccountingRequest struct {
+	Tag   string `form:"tag"`   // Only this inbound; empty reports all
+	Limit int    `form:"limit"` // Client addresses listed per inbound; 0 uses the default
+}
+
+// InboundAccounting is the kernel-counted traffic of one inbound next to
+// the core's counters
+type InboundAccounting struct {
+	Tag          string              `json:"tag"`
+	Ports        []PortRange         `json:"ports"`
+	Uplink       int64               `json:"uplink"`
+	Downlink     int64               `json:"downlink"`
+	XrayUplink   *int64              `json:"xrayUplink"` // nil when the core has no counters for the inbound
+	XrayDownlink *int64              `json:"xrayDownlink"`
+	Warning      string              `json:"warning,omitempty"`
+	Clients      []*ebpfacct.Counter `json:"clients"` // Busiest first, up to the limit
+}
+
+// GetAccountingResponse is the kernel-counted traffic of all inbounds
+type GetAccountingResponse struct {
+	Enabled  bool                 `json:"enabled"`
+	Since    time.Time            `json:"since"` // When counting started; Xray counters restart with the core and on stats resets
+	Full     bool                 `json:"full"`  // The counter map is full and new addresses are no longer counted
+	Inbounds []*InboundAccounting `json:"inbounds"`
+}
+
+// SyncPorts watches the ports of the running core's inbounds. It is called
+// when the core comes online and before every report.
+func (s *EbpfAccountingService) SyncPorts() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accounter == nil || s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return nil
+	}
+
+	inbounds, err := runningInboundPorts(s.xrayCore)
+	if err != nil {
+		return err
+	}
+	var ports []uint16
+	for _, inbound := range inbounds {
+		for _, r := range inbound.Ranges {
+			for port := r.From; port <= r.To; port++ {
+				ports = append(ports, uint16(port))
+			}
+		}
+	}
+	return s.accounter.SetPorts(ports)
+}
+
+// GetAccounting reports the traffic counted per inbound and client address
+// since the filter was loaded. Kernel counts are IP-level and include
+// transport and TLS overhead, so they run higher than Xray's.
+func (s *EbpfAccountingService) GetAccounting(ctx context.Context, req *GetAccountingRequest) (*GetAccountingResponse, error) {
+	resp := &GetAccountingResponse{Enabled: s.enabled, Inbounds: make([]*InboundAccounting, 0)}
+	if !s.enabled {
+		return resp, nil
+	}
+	if s.openErr != nil {
+		return nil, fmt.Errorf("eBPF accounting unavailable: %w", s.openErr)
+	}
+	resp.Since = s.since
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return resp, nil
+	}
+
+	if err := s.SyncPorts(); err != nil {
+		return nil, err
+	}
+	inbounds, err := runningInboundPorts(s.xrayCore)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	accounter := s.accounter
+	s.mu.Unlock()
+	if accounter == nil {
+		return resp, nil // Stopped
+	}
+	counters, full, err := accounter.Counters()
+	if err != nil {
+		return nil, err
+	}
+	resp.Full = full
+
+	stats, err := s.xrayCore.GetStats(ctx, "inbound>>>", false)
+	if err != nil {
+		s.logger.Warn("Failed to read inbound counters", zap.Error(err))
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAccountingClients
+	}
+
+	for _, inbound := range inbounds {
+		if req.Tag != "" && inbound.Tag != req.Tag {
+			continue
+		}
+
+		result := &InboundAccounting{Tag: inbound.Tag, Ports: inbound.Ranges, Clients: make([]*ebpfacct.Counter, 0)}
+		for _, c := range counters {
+			if !portRangesContain(inbound.Ranges, int(c.Port)) {
+				continue
+			}
+			result.Uplink += int64(c.Uplink)
+			result.Downlink += int64(c.Downlink)
+			result.Clients = append(result.Clients, c)
+		}
+
+		sort.Slice(result.Clients, func(i, j int) bool {
+			a, b := result.Clients[i], result.Clients[j]
+			return a.Uplink+a.Downlink > b.Uplink+b.Downlink
+		})
+		if len(result.Clients) > limit {
+			result.Clients = result.Clients[:limit]
+		}
+
+		result.XrayUplink, result.XrayDownlink = inboundTraffic(stats, inbound.Tag)
+		result.Warning = trafficWarning(result.Uplink+result.Downlink > 0, result.Uplink+result.Downlink, result.XrayUplink, result.XrayDownlink)
+		resp.Inbounds = append(resp.Inbounds, result)
+	}
+
+	return resp, nil
+}
+
+// Stop unloads the filter
+func (s *EbpfAccountingService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accounter != nil {
+		s.accounter.Close()
+		s.accounter = nil
+	}
+}
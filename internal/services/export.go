@@ -0,0 +1,279 @@
+// Package services provides business logic for exporting traffic statistics
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+)
+
+// Stats export kinds
+const (
+	ExportUsers    = "users"
+	ExportInbounds = "inbounds"
+)
+
+// Export job states
+const (
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// defaultExportKeep is the number of exports kept when ExportConfig.Keep is 0
+const defaultExportKeep = 10
+
+// exportTimeout bounds the stats queries of one export
+const exportTimeout = 5 * time.Minute
+
+// WriteUsersCSV writes user traffic as CSV with a header row. A filter (nil
+// for none) selects the columns and drops users below its MinBytes.
+func WriteUsersCSV(w io.Writer, users []*UserTraffic, filter *UserStatsFilter) error {
+	if filter == nil {
+		filter = &UserStatsFilter{Uplink: true, Downlink: true}
+	}
+
+	cw := csv.NewWriter(w)
+	header := []string{UserStatsFieldUsername}
+	if filter.Uplink {
+		header = append(header, UserStatsFieldUplink)
+	}
+	if filter.Downlink {
+		header = append(header, UserStatsFieldDownlink)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		var selected int64
+		row := []string{user.Username}
+		if filter.Uplink {
+			selected += user.Uplink
+			row = append(row, strconv.FormatInt(user.Uplink, 10))
+		}
+		if filter.Downlink {
+			selected += user.Downlink
+			row = append(row, strconv.FormatInt(user.Downlink, 10))
+		}
+		if selected < filter.MinBytes {
+			continue
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteInboundsCSV writes inbound traffic as CSV with a header row
+func WriteInboundsCSV(w io.Writer, inbounds []*InboundStats) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"inbound", "uplink", "downlink"}); err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		if err := cw.Write([]string{inbound.Inbound, strconv.FormatInt(inbound.Uplink, 10), strconv.FormatInt(inbound.Downlink, 10)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportConfig holds Export service configuration
+type ExportConfig struct {
+	Dir  string // Directory where export files are written
+	Keep int    // Exports kept, oldest removed first; defaultExportKeep when 0
+}
+
+// ExportJob describes a bulk stats export. Its file can be downloaded once
+// the state is done.
+type ExportJob struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	Rows       int        `json:"rows"`
+	Size       int64      `json:"size"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// ExportService runs bulk stats exports in the background and keeps the last
+// files for download. Counters are read without resetting them, so exports do
+// not interfere with the panel's collection. Exports do not survive a node
+// restart; leftover files are removed at startup.
+type ExportService struct {
+	mu     sync.Mutex
+	logger *zap.Logger
+	stats  *StatsService
+	dir    string
+	keep   int
+	jobs   []*ExportJob // Oldest first
+	seq    uint64
+}
+
+// NewExportService creates a new ExportService
+func NewExportService(cfg *ExportConfig, stats *StatsService, logger *zap.Logger) *ExportService {
+	keep := cfg.Keep
+	if keep <= 0 {
+		keep = defaultExportKeep
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.csv"))
+	for _, path := range leftovers {
+		if err := os.Remove(path); err != nil {
+			logger.Warn("Failed to remove old export", zap.String("path", path), zap.Error(err))
+		}
+	}
+
+	return &ExportService{
+		logger: logger,
+		stats:  stats,
+		dir:    cfg.Dir,
+		keep:   keep,
+	}
+}
+
+// Start begins an export of the given kind and returns its job
+func (s *ExportService) Start(kind string) (*ExportJob, error) {
+	if kind != ExportUsers && kind != ExportInbounds {
+		return nil, fmt.Errorf("unknown export kind %q (expected users or inbounds)", kind)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.seq++
+	job := &ExportJob{
+		ID:        fmt.Sprintf("%s-%04d-%s.csv", now.Format("20060102T150405"), s.seq%10000, kind),
+		Kind:      kind,
+		State:     ExportRunning,
+		StartedAt: now,
+	}
+	s.jobs = append(s.jobs, job)
+	s.prune()
+
+	copied := *job
+	go s.run(&copied)
+	return &copied, nil
+}
+
+// run writes the export file and records the outcome
+func (s *ExportService) run(job *ExportJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	var buf bytes.Buffer
+	err := s.write(ctx, &buf, job)
+	if err == nil {
+		if err = os.MkdirAll(s.dir, 0700); err == nil {
+			err = atomicfile.WriteFile(filepath.Join(s.dir, job.ID), buf.Bytes(), 0600, false)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	finished := time.Now().UTC()
+	for _, stored := range s.jobs {
+		if stored.ID != job.ID {
+			continue
+		}
+		stored.FinishedAt = &finished
+		if err != nil {
+			stored.State = ExportFailed
+			stored.Error = err.Error()
+			s.logger.Warn("Stats export failed", zap.String("id", job.ID), zap.Error(err))
+			return
+		}
+		stored.State = ExportDone
+		stored.Rows = job.Rows
+		stored.Size = int64(buf.Len())
+		return
+	}
+	// Pruned while running: the file is no longer listed
+	os.Remove(filepath.Join(s.dir, job.ID))
+}
+
+// write collects the stats of a job as CSV, counting the rows
+func (s *ExportService) write(ctx context.Context, w io.Writer, job *ExportJob) error {
+	switch job.Kind {
+	case ExportUsers:
+		resp, err := s.stats.GetAllUsersStats(ctx, &GetAllUsersStatsRequest{})
+		if err != nil {
+			return err
+		}
+		job.Rows = len(resp.Users)
+		return WriteUsersCSV(w, resp.Users, nil)
+	default:
+		resp, err := s.stats.GetAllInboundsStats(ctx, &GetAllInboundsStatsRequest{})
+		if err != nil {
+			return err
+		}
+		job.Rows = len(resp.Inbounds)
+		return WriteInboundsCSV(w, resp.Inbounds)
+	}
+}
+
+// Jobs returns the kept exports, oldest first
+func (s *ExportService) Jobs() []*ExportJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]*ExportJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	return jobs
+}
+
+// Job returns an export, or nil if it is unknown
+func (s *ExportService) Job(id string) *ExportJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if job.ID == id {
+			copied := *job
+			return &copied
+		}
+	}
+	return nil
+}
+
+// File returns the path of a finished export's file
+func (s *ExportService) File(id string) (string, error) {
+	job := s.Job(id)
+	switch {
+	case job == nil:
+		return "", fmt.Errorf("unknown export %q", id)
+	case job.State != ExportDone:
+		return "", fmt.Errorf("export %s is %s", id, job.State)
+	}
+	return filepath.Join(s.dir, job.ID), nil
+}
+
+// prune forgets the oldest exports beyond the limit and removes their files (no lock)
+func (s *ExportService) prune() {
+	for len(s.jobs) > s.keep {
+		if err := os.Remove(filepath.Join(s.dir, s.jobs[0].ID)); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("Failed to remove old export", zap.String("id", s.jobs[0].ID), zap.Error(err))
+		}
+		s.jobs = s.jobs[1:]
+	}
+}
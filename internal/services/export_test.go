@@ -0,0 +1,40 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteUsersCSV(t *testing.T) {
+	users := []*UserTraffic{
+		{Username: "alice", Uplink: 5000, Downlink: 100},
+		{Username: "bob,jr", Uplink: 10, Downlink: 2000},
+	}
+
+	var all strings.Builder
+	if err := WriteUsersCSV(&all, users, nil); err != nil {
+		t.Fatalf("WriteUsersCSV returned error: %v", err)
+	}
+	if want := "username,uplink,downlink\nalice,5000,100\n\"bob,jr\",10,2000\n"; all.String() != want {
+		t.Errorf("Expected %q, got %q", want, all.String())
+	}
+
+	filter, _ := ParseUserStatsFilter("downlink", "1000")
+	var filtered strings.Builder
+	if err := WriteUsersCSV(&filtered, users, filter); err != nil {
+		t.Fatalf("WriteUsersCSV returned error: %v", err)
+	}
+	if want := "username,downlink\n\"bob,jr\",2000\n"; filtered.String() != want {
+		t.Errorf("Expected %q, got %q", want, filtered.String())
+	}
+}
+
+func TestWriteInboundsCSV(t *testing.T) {
+	var out strings.Builder
+	if err := WriteInboundsCSV(&out, []*InboundStats{{Inbound: "VLESS", Uplink: 1, Downlink: 2}}); err != nil {
+		t.Fatalf("WriteInboundsCSV returned error: %v", err)
+	}
+	if want := "inbound,uplink,downlink\nVLESS,1,2\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
@@ -0,0 +1,118 @@
+// Package services provides business logic for the node facts document
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// FactsSchemaVersion is bumped when a facts field is removed or changes
+// meaning; fields may be added without a bump
+const FactsSchemaVersion = 1
+
+// Facts describes a node for configuration-management tools (Terraform,
+// Ansible) orchestrating fleets. Every field is always present, so tools can
+// rely on the layout of a given schema version.
+type Facts struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	GeneratedAt   time.Time         `json:"generatedAt"`
+	Node          NodeInformation   `json:"node"`
+	Versions      FactsVersions     `json:"versions"`
+	Capabilities  FactsCapabilities `json:"capabilities"`
+	Capacity      FactsCapacity     `json:"capacity"`
+	Config        FactsConfig       `json:"config"`
+}
+
+// FactsVersions lists the versions of the node and its components
+type FactsVersions struct {
+	Node       string `json:"node"`
+	Xray       string `json:"xray"`
+	XrayModule string `json:"xrayModule"` // Module version from go.mod, e.g. v1.251208.0
+	Go         string `json:"go"`
+	GitCommit  string `json:"gitCommit"`
+	BuildTime  string `json:"buildTime"`
+	Platform   string `json:"platform"`
+}
+
+// FactsCapabilities lists what the node supports
+type FactsCapabilities struct {
+	Protocols     ProtocolSupport `json:"protocols"`
+	Features      []string        `json:"features"` // Enabled feature flags, sorted
+	HashAlgorithm string          `json:"hashAlgorithm"`
+}
+
+// FactsCapacity describes the resources and load of the node
+type FactsCapacity struct {
+	CPUCores     int     `json:"cpuCores"`
+	CPULimit     float64 `json:"cpuLimit"`    // CPUs allowed by a cgroup quota, 0 without one
+	MemoryBytes  int64   `json:"memoryBytes"` // Memory the node can use, 0 if unknown
+	InContainer  bool    `json:"inContainer"`
+	Inbounds     int     `json:"inbounds"`
+	TrackedUsers int     `json:"trackedUsers"`
+}
+
+// FactsConfig identifies the config the core runs
+type FactsConfig struct {
+	Running     bool           `json:"running"`
+	ConfigHash  string         `json:"configHash"` // SHA-256 of the running config, empty when stopped
+	HashCheck   bool           `json:"hashCheck"`
+	PanelHashes *InboundHashes `json:"panelHashes"` // Hashes the panel sent with the config
+}
+
+// CurrentFacts collects the facts document
+func CurrentFacts(xray *XrayService, internal *InternalService, features map[string]bool, hashAlgorithm string) *Facts {
+	build := CurrentBuildInfo(features)
+	limits := DetectContainerLimits()
+
+	enabled := make([]string, 0, len(features))
+	for name, on := range features {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+
+	hashes := internal.GetInboundHashes()
+	sort.Slice(hashes.Inbounds, func(i, j int) bool { return hashes.Inbounds[i].Tag < hashes.Inbounds[j].Tag })
+
+	facts := &Facts{
+		SchemaVersion: FactsSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Node:          currentNodeInformation(),
+		Versions: FactsVersions{
+			Node:       build.Version,
+			Xray:       build.XrayCoreVersion,
+			XrayModule: build.XrayCoreModule,
+			Go:         build.GoVersion,
+			GitCommit:  build.GitCommit,
+			BuildTime:  build.BuildTime,
+			Platform:   build.Platform,
+		},
+		Capabilities: FactsCapabilities{
+			Protocols:     build.Protocols,
+			Features:      enabled,
+			HashAlgorithm: hashAlgorithm,
+		},
+		Capacity: FactsCapacity{
+			CPUCores:     getCPUCores(),
+			CPULimit:     limits.CPUQuota,
+			MemoryBytes:  getMemoryTotalBytes(),
+			InContainer:  limits.InContainer,
+			Inbounds:     len(internal.GetXtlsConfigInbounds()),
+			TrackedUsers: internal.GetUserCount(),
+		},
+		Config: FactsConfig{
+			HashCheck:   !xray.HashCheckDisabled(),
+			PanelHashes: hashes,
+		},
+	}
+
+	if core := xray.GetXrayCore(); core.IsRunning() {
+		sum := sha256.Sum256(core.GetConfig())
+		facts.Config.Running = true
+		facts.Config.ConfigHash = hex.EncodeToString(sum[:])
+	}
+	return facts
+}
@@ -0,0 +1,59 @@
+// Package services provides business logic for file descriptor monitoring
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FDUsage is the open file descriptor count of a process versus its limit
+type FDUsage struct {
+	Process string  `json:"process"` // "node" (including the embedded core) or a sidecar name
+	Pid     int     `json:"pid"`
+	Open    int     `json:"open"`
+	Limit   int     `json:"limit"`   // Soft RLIMIT_NOFILE; 0 if unlimited
+	Percent float64 `json:"percent"` // Open as a share of the limit
+}
+
+// readFDUsage counts the open descriptors of a process from /proc (Linux only)
+func readFDUsage(process string, pid int) (*FDUsage, error) {
+	dir := "/proc/self"
+	if pid != os.Getpid() {
+		dir = "/proc/" + strconv.Itoa(pid)
+	}
+
+	entries, err := os.ReadDir(dir + "/fd")
+	if err != nil {
+		return nil, err
+	}
+	usage := &FDUsage{Process: process, Pid: pid, Open: len(entries)}
+
+	limits, err := os.ReadFile(dir + "/limits")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(limits), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		// Max open files            <soft>               <hard>               files
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("malformed limits line %q", line)
+		}
+		if fields[0] != "unlimited" {
+			usage.Limit, err = strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("malformed limits line %q", line)
+			}
+		}
+		break
+	}
+
+	if usage.Limit > 0 {
+		usage.Percent = float64(usage.Open) * 100 / float64(usage.Limit)
+	}
+	return usage, nil
+}
@@ -0,0 +1,70 @@
+// Package services provides business logic for feature flags
+package services
+
+import "github.com/clash-version/remnawave-node-go/pkg/featureflags"
+
+// Feature flag names
+const (
+	FlagConflictWarnings  = "conflict_warnings"
+	FlagMutationJournal   = "mutation_journal"
+	FlagBlockOutbound     = "block_outbound"
+	FlagConntrackSessions = "conntrack_sessions"
+	FlagEbpfAccounting    = "ebpf_accounting"
+	FlagHashCheck         = "hash_check"
+)
+
+// featureFlags lists every flag the node knows. New subsystems register a
+// flag here (off by default) so they can ship dark and be enabled per node.
+var featureFlags = []featureflags.Flag{
+	{
+		Name:        FlagConflictWarnings,
+		Description: "Detect duplicate and overwritten credentials in add-user requests and report them as warnings",
+		Default:     true,
+		Runtime:     true,
+	},
+	{
+		Name:        FlagMutationJournal,
+		Description: "Journal user mutations received while the core is down, answer them as queued and replay them once the core is back online",
+		Default:     false,
+		Runtime:     true,
+	},
+	{
+		Name:        FlagBlockOutbound,
+		Description: "Add a blackhole outbound with the Vision block tag to started configs that lack one, so IP blocking works",
+		Default:     false,
+		Runtime:     true,
+	},
+	{
+		Name:        FlagConntrackSessions,
+		Description: "List the kernel-tracked connections to each inbound port (Linux conntrack) next to the inbound's Xray counters",
+		Default:     false,
+		Runtime:     true,
+	},
+	{
+		Name:        FlagEbpfAccounting,
+		Description: "Count the traffic of the inbound ports per client address with an eBPF socket filter (Linux, experimental), as a cross-check of Xray's counters",
+		Default:     false,
+		Runtime:     false,
+	},
+	{
+		Name:        FlagHashCheck,
+		Description: "Skip restarting the core when a start request's inbound hashes match the running config",
+		Default:     true,
+		Runtime:     true,
+	},
+}
+
+// hashCheckEnabled reports whether FlagHashCheck is on. Without a flag set
+// (as in tests) it follows the flag's default.
+func hashCheckEnabled(flags *featureflags.Set) bool {
+	return flags == nil || flags.Enabled(FlagHashCheck)
+}
+
+// NewFeatureFlags creates the node's flag set with the configured values applied
+func NewFeatureFlags(values map[string]bool) (*featureflags.Set, error) {
+	flags := featureflags.New(featureFlags...)
+	if err := flags.Apply(values); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
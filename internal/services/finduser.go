@@ -0,0 +1,120 @@
+// Package services provides business logic for locating users on the node
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/shadowsocks"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+)
+
+// Find-user match kinds
+const (
+	MatchUsername     = "username"
+	MatchUUID         = "uuid"
+	MatchPasswordHash = "passwordHash"
+)
+
+// FindUserRequest is the request body of the find-user endpoint
+type FindUserRequest struct {
+	// Username, vless UUID, or hex SHA-256/SHA-224 of a trojan or shadowsocks
+	// password, so passwords never have to be pasted into support tools
+	Query string `json:"query" binding:"required"`
+}
+
+// FoundUser is an inbound currently containing the searched identity
+type FoundUser struct {
+	Inbound   string `json:"inbound"`
+	Protocol  string `json:"protocol"`
+	Username  string `json:"username"`
+	MatchedBy string `json:"matchedBy"`
+}
+
+// FindUserResponse is the response of the find-user endpoint
+type FindUserResponse struct {
+	Matches []*FoundUser `json:"matches"`
+}
+
+// FindUser returns the inbounds of the running core holding a user that
+// matches the query by username, UUID or password hash
+func (s *HandlerService) FindUser(ctx context.Context, req *FindUserRequest) (*FindUserResponse, error) {
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return nil, fmt.Errorf("Xray not running")
+	}
+
+	var config struct {
+		Inbounds []struct {
+			Tag      string `json:"tag"`
+			Protocol string `json:"protocol"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	// UUIDs and password hashes compare case-insensitively, so normalizing
+	// the whole query only affects username matches
+	query := s.usernames.Normalize(strings.TrimSpace(req.Query))
+	matches := make([]*FoundUser, 0)
+	for _, inbound := range config.Inbounds {
+		switch inbound.Protocol {
+		case "vless", "trojan", "shadowsocks":
+		default:
+			continue
+		}
+
+		users, err := s.xrayCore.GetInboundUsers(ctx, inbound.Tag)
+		if err != nil {
+			continue
+		}
+		for _, user := range users {
+			if matchedBy := matchUser(user, query); matchedBy != "" {
+				matches = append(matches, &FoundUser{
+					Inbound:   inbound.Tag,
+					Protocol:  inbound.Protocol,
+					Username:  user.Email,
+					MatchedBy: matchedBy,
+				})
+			}
+		}
+	}
+
+	return &FindUserResponse{Matches: matches}, nil
+}
+
+// matchUser returns how a user matches the query, or "" if it does not
+func matchUser(user *protocol.MemoryUser, query string) string {
+	if user.Email == query {
+		return MatchUsername
+	}
+
+	var password string
+	switch account := user.Account.(type) {
+	case *vless.MemoryAccount:
+		if strings.EqualFold(account.ID.String(), query) {
+			return MatchUUID
+		}
+		return ""
+	case *trojan.MemoryAccount:
+		password = account.Password
+	case *shadowsocks.MemoryAccount:
+		password = account.Password
+	}
+	if password == "" {
+		return ""
+	}
+
+	sum256 := sha256.Sum256([]byte(password))
+	sum224 := sha256.Sum224([]byte(password))
+	if strings.EqualFold(hex.EncodeToString(sum256[:]), query) || strings.EqualFold(hex.EncodeToString(sum224[:]), query) {
+		return MatchPasswordHash
+	}
+	return ""
+}
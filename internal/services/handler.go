@@ -4,55 +4,88 @@ package services
 import (
 	"context"
 	"fmt"
-	"sync"
+	"sort"
+	"strings"
 
 	"go.uber.org/zap"
 
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
+	"github.com/clash-version/remnawave-node-go/pkg/keylock"
+	"github.com/clash-version/remnawave-node-go/pkg/opbarrier"
+	"github.com/clash-version/remnawave-node-go/pkg/reqtiming"
 	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
 )
 
 // HandlerService manages user operations for Xray
 type HandlerService struct {
 	logger   *zap.Logger
-	xrayCore *xraycore.Instance
-	internal *InternalService
+	xrayCore CoreBackend
+	internal UserStore
+	sidecars *SidecarService // Optional; users are fanned out to sidecar cores when enabled
+	flags    *featureflags.Set
+	barrier  *opbarrier.Barrier // Shut by XrayService while the core is rebuilt
 
-	// Per-inbound mutex for fine-grained locking
-	inboundMu    sync.RWMutex
-	inboundLocks map[string]*sync.Mutex
+	// Applied to usernames of every mutation; nil leaves them as sent
+	usernames *UsernameNormalizer
+
+	// Fired with user-added and user-removed events; nil runs none
+	hooks *HookService
+
+	// Veto or transform mutations before they are applied; nil allows all
+	policies *PolicyService
+
+	// Per-inbound locks, sharded and evicted when unused
+	inboundLocks *keylock.Locker
+
+	// Mutations received while the core is down (see journalMutation)
+	journal mutationJournal
 }
 
 // NewHandlerService creates a new HandlerService
-func NewHandlerService(xrayCore *xraycore.Instance, internal *InternalService, logger *zap.Logger) *HandlerService {
+func NewHandlerService(xrayCore CoreBackend, internal UserStore, sidecars *SidecarService, flags *featureflags.Set, barrier *opbarrier.Barrier, usernames *UsernameNormalizer, hooks *HookService, policies *PolicyService, logger *zap.Logger) *HandlerService {
 	return &HandlerService{
 		logger:       logger,
 		xrayCore:     xrayCore,
 		internal:     internal,
-		inboundLocks: make(map[string]*sync.Mutex),
+		sidecars:     sidecars,
+		flags:        flags,
+		barrier:      barrier,
+		usernames:    usernames,
+		hooks:        hooks,
+		policies:     policies,
+		inboundLocks: keylock.New(keylock.DefaultShards),
 	}
 }
 
-// getInboundLock returns a mutex for a specific inbound tag
-func (s *HandlerService) getInboundLock(tag string) *sync.Mutex {
-	s.inboundMu.RLock()
-	lock, exists := s.inboundLocks[tag]
-	s.inboundMu.RUnlock()
+// lockInbound locks a specific inbound tag and returns the unlock function.
+// The wait is recorded in the request timings.
+func (s *HandlerService) lockInbound(ctx context.Context, tag string) func() {
+	defer reqtiming.Track(ctx, reqtiming.PhaseLockWait)()
+	return s.inboundLocks.Lock(tag)
+}
 
-	if exists {
-		return lock
+// enterBarrier waits for a start, restart or stop in progress to finish, so
+// the mutation sees and changes the resulting core, and returns the function
+// ending the mutation
+func (s *HandlerService) enterBarrier(ctx context.Context) (func(), error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseLockWait)()
+	leave, err := s.barrier.Enter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for xray start: %w", err)
 	}
+	return leave, nil
+}
 
-	s.inboundMu.Lock()
-	defer s.inboundMu.Unlock()
-
-	// Double check after acquiring write lock
-	if lock, exists = s.inboundLocks[tag]; exists {
-		return lock
-	}
+// sidecarsEnabled reports whether user changes must be fanned out to sidecar cores
+func (s *HandlerService) sidecarsEnabled() bool {
+	return s.sidecars != nil && s.sidecars.Enabled()
+}
 
-	lock = &sync.Mutex{}
-	s.inboundLocks[tag] = lock
-	return lock
+// withCoreResults prepends the Xray result to the sidecar results
+func withCoreResults(xraySuccess bool, xrayErr *string, sidecarResults []*CoreResult) []*CoreResult {
+	results := make([]*CoreResult, 0, len(sidecarResults)+1)
+	results = append(results, &CoreResult{Core: "xray", Success: xraySuccess, Error: xrayErr})
+	return append(results, sidecarResults...)
 }
 
 // CipherType represents Shadowsocks cipher types (matches Node.js CipherType enum)
@@ -91,13 +124,19 @@ type HashData struct {
 type AddUserRequest struct {
 	Data     []UserData `json:"data"`
 	HashData HashData   `json:"hashData"`
+
+	policyChecked bool // Policies ran; a journal replay does not run them again
 }
 
 // AddUserResponse represents the response from adding a user
 // Matches Node.js AddUserResponseModel: { success: boolean, error: null | string }
 type AddUserResponse struct {
-	Success bool    `json:"success"`
-	Error   *string `json:"error"`
+	Success     bool            `json:"success"`
+	Error       *string         `json:"error"`
+	FieldErrors []*FieldError   `json:"fieldErrors,omitempty"` // Rejected credentials; nothing was applied
+	Warnings    []*UserConflict `json:"warnings,omitempty"`    // Credentials that were replaced
+	Cores       []*CoreResult   `json:"cores,omitempty"`       // Per-core results when sidecars are enabled
+	Queued      bool            `json:"queued,omitempty"`      // Journaled while the core is down, applied once it is back
 }
 
 // Legacy UserInfo for internal use
@@ -136,7 +175,25 @@ func (s *HandlerService) removeUserFromInbound(ctx context.Context, tag, email s
 // AddUser adds user(s) to Xray (Node.js compatible format)
 // The request contains multiple UserData items (one per inbound) and hashData for tracking
 func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*AddUserResponse, error) {
+	for i := range req.Data {
+		req.Data[i].Username = s.usernames.Normalize(req.Data[i].Username)
+	}
+	if err := s.checkAddUserPolicy(ctx, req); err != nil {
+		errMsg := err.Error()
+		return &AddUserResponse{Success: false, Error: &errMsg}, nil
+	}
+
+	leave, err := s.enterBarrier(ctx)
+	if err != nil {
+		errMsg := err.Error()
+		return &AddUserResponse{Success: false, Error: &errMsg}, nil
+	}
+	defer leave()
+
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		if s.journalAddUser(req) {
+			return &AddUserResponse{Success: true, Queued: true}, nil
+		}
 		errMsg := "Xray not running"
 		return &AddUserResponse{Success: false, Error: &errMsg}, nil
 	}
@@ -146,6 +203,16 @@ func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*Add
 		return &AddUserResponse{Success: false, Error: &errMsg}, nil
 	}
 
+	// Reject bad credentials up front instead of surfacing opaque core errors
+	if verr := validateAddUser(req, s.shadowsocksMethods()); verr != nil {
+		errMsg := verr.Error()
+		return &AddUserResponse{Success: false, Error: &errMsg, FieldErrors: verr.Fields}, nil
+	}
+
+	// Read what is being replaced before the user is removed below
+	conflicts := s.addUserConflicts(ctx, req)
+	s.logConflicts(conflicts)
+
 	// Get username from first item (all items have same username)
 	username := req.Data[0].Username
 
@@ -157,8 +224,7 @@ func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*Add
 	// Step 2: Remove user from ALL known inbounds first (like Node.js does)
 	allTags := s.internal.GetXtlsConfigInbounds()
 	for _, tag := range allTags {
-		lock := s.getInboundLock(tag)
-		lock.Lock()
+		unlock := s.lockInbound(ctx, tag)
 
 		s.logger.Debug("Removing user from inbound before adding",
 			zap.String("username", username),
@@ -173,16 +239,16 @@ func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*Add
 			s.internal.RemoveUserFromInbound(req.HashData.VlessUUID, tag)
 		}
 
-		lock.Unlock()
+		unlock()
 	}
 
 	// Step 3: Add user to each inbound based on type
 	var lastError error
 	successCount := 0
+	var addedTags []string
 
 	for _, item := range req.Data {
-		lock := s.getInboundLock(item.Tag)
-		lock.Lock()
+		unlock := s.lockInbound(ctx, item.Tag)
 
 		var err error
 
@@ -211,7 +277,7 @@ func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*Add
 			}
 		default:
 			s.logger.Warn("Unknown user type", zap.String("type", item.Type))
-			lock.Unlock()
+			unlock()
 			continue
 		}
 
@@ -226,6 +292,7 @@ func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*Add
 			// Update tracking on success
 			s.internal.AddUserToInbound(req.HashData.VlessUUID, item.Tag)
 			successCount++
+			addedTags = append(addedTags, item.Tag)
 
 			s.logger.Info("Added user",
 				zap.String("username", item.Username),
@@ -233,22 +300,52 @@ func (s *HandlerService) AddUser(ctx context.Context, req *AddUserRequest) (*Add
 				zap.String("type", item.Type))
 		}
 
-		lock.Unlock()
+		unlock()
 	}
 
 	// Return success if at least one user was added
+	var resp *AddUserResponse
 	if successCount > 0 {
-		return &AddUserResponse{Success: true, Error: nil}, nil
+		resp = &AddUserResponse{Success: true, Error: nil}
+		s.hooks.Fire(&HookEvent{Event: HookUserAdded, Username: username, Usernames: []string{username}, Tags: addedTags})
+	} else if lastError != nil {
+		// All failed
+		errMsg := lastError.Error()
+		resp = &AddUserResponse{Success: false, Error: &errMsg}
+	} else {
+		errMsg := "no users were added"
+		resp = &AddUserResponse{Success: false, Error: &errMsg}
 	}
 
-	// All failed
-	if lastError != nil {
-		errMsg := lastError.Error()
-		return &AddUserResponse{Success: false, Error: &errMsg}, nil
+	resp.Warnings = conflicts
+
+	if s.sidecarsEnabled() {
+		var sidecarResults []*CoreResult
+		if user, ok := sidecarUserFromData(req.Data); ok {
+			sidecarResults = s.sidecars.UpsertUsers([]SidecarUser{user})
+		}
+		resp.Cores = withCoreResults(resp.Success, resp.Error, sidecarResults)
 	}
 
-	errMsg := "no users were added"
-	return &AddUserResponse{Success: false, Error: &errMsg}, nil
+	return resp, nil
+}
+
+// sidecarUserFromData picks the sidecar credential for a user: the trojan or
+// shadowsocks password if present, otherwise the vless uuid
+func sidecarUserFromData(data []UserData) (SidecarUser, bool) {
+	var uuid string
+	for _, item := range data {
+		if item.Password != "" {
+			return SidecarUser{Username: item.Username, Password: item.Password}, true
+		}
+		if item.UUID != "" && uuid == "" {
+			uuid = item.UUID
+		}
+	}
+	if uuid == "" {
+		return SidecarUser{}, false
+	}
+	return SidecarUser{Username: data[0].Username, Password: uuid}, true
 }
 
 // cipherTypeToMethod converts CipherType enum to method string
@@ -295,22 +392,125 @@ type UserForBatch struct {
 type AddUsersRequest struct {
 	AffectedInboundTags []string       `json:"affectedInboundTags"`
 	Users               []UserForBatch `json:"users"`
+	DryRun              bool           `json:"dryRun"` // Report planned operations without changing the core
+
+	policyChecked bool // Policies ran; a journal replay does not run them again
 }
 
 // AddUsersResponse represents the response from adding multiple users
 // Matches Node.js: { success: boolean, error: null | string }
 type AddUsersResponse struct {
-	Success bool    `json:"success"`
-	Error   *string `json:"error"`
+	Success     bool                `json:"success"`
+	Error       *string             `json:"error"`
+	FieldErrors []*FieldError       `json:"fieldErrors,omitempty"` // Rejected credentials; nothing was applied
+	Warnings    []*UserConflict     `json:"warnings,omitempty"`    // Credentials that were (or would be) replaced
+	Cores       []*CoreResult       `json:"cores,omitempty"`       // Per-core results when sidecars are enabled
+	Operations  []*PlannedOperation `json:"operations,omitempty"`  // Dry run only
+	Queued      bool                `json:"queued,omitempty"`      // Journaled while the core is down, applied once it is back
+}
+
+// Planned operation actions
+const (
+	PlannedAdd    = "add"
+	PlannedRemove = "remove"
+	PlannedSkip   = "skip"
+)
+
+// PlannedOperation is one change a batch request would make
+type PlannedOperation struct {
+	Core     string `json:"core"` // "xray" or "sidecars"
+	Username string `json:"username"`
+	Inbound  string `json:"inbound,omitempty"`
+	Action   string `json:"action"`
+	Type     string `json:"type,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// userInInbound reports whether the core currently has the user in the inbound
+func (s *HandlerService) userInInbound(ctx context.Context, tag, username string) bool {
+	user, err := s.xrayCore.GetInboundUser(ctx, tag, username)
+	return err == nil && user != nil
+}
+
+// planAddUsers lists the operations AddUsers would perform, without mutating anything
+func (s *HandlerService) planAddUsers(ctx context.Context, req *AddUsersRequest) []*PlannedOperation {
+	// Known inbounds as they would be after registering the affected tags
+	tags := s.internal.GetXtlsConfigInbounds()
+	known := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		known[tag] = true
+	}
+	for _, tag := range req.AffectedInboundTags {
+		if !known[tag] {
+			known[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	ops := make([]*PlannedOperation, 0)
+	for _, user := range req.Users {
+		username := user.UserData.UserId
+		for _, tag := range tags {
+			if s.userInInbound(ctx, tag, username) {
+				ops = append(ops, &PlannedOperation{Core: "xray", Username: username, Inbound: tag, Action: PlannedRemove})
+			}
+		}
+		for _, item := range user.InboundData {
+			op := &PlannedOperation{Core: "xray", Username: username, Inbound: item.Tag, Action: PlannedAdd, Type: item.Type}
+			switch item.Type {
+			case "trojan", "vless", "shadowsocks":
+			default:
+				op.Action = PlannedSkip
+				op.Reason = "unknown user type"
+			}
+			ops = append(ops, op)
+		}
+		if s.sidecarsEnabled() {
+			ops = append(ops, &PlannedOperation{Core: "sidecars", Username: username, Action: PlannedAdd})
+		}
+	}
+	return ops
 }
 
 // AddUsers adds multiple users to Xray (Node.js compatible format)
 func (s *HandlerService) AddUsers(ctx context.Context, req *AddUsersRequest) (*AddUsersResponse, error) {
+	for i := range req.Users {
+		req.Users[i].UserData.UserId = s.usernames.Normalize(req.Users[i].UserData.UserId)
+	}
+	if err := s.checkAddUsersPolicy(ctx, req); err != nil {
+		errMsg := err.Error()
+		return &AddUsersResponse{Success: false, Error: &errMsg}, nil
+	}
+
+	leave, err := s.enterBarrier(ctx)
+	if err != nil {
+		errMsg := err.Error()
+		return &AddUsersResponse{Success: false, Error: &errMsg}, nil
+	}
+	defer leave()
+
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		if s.journalAddUsers(req) {
+			return &AddUsersResponse{Success: true, Queued: true}, nil
+		}
 		errMsg := "Xray not running"
 		return &AddUsersResponse{Success: false, Error: &errMsg}, nil
 	}
 
+	// Reject the whole batch on bad credentials, before any core call
+	if verr := validateAddUsers(req, s.shadowsocksMethods()); verr != nil {
+		errMsg := verr.Error()
+		return &AddUsersResponse{Success: false, Error: &errMsg, FieldErrors: verr.Fields}, nil
+	}
+
+	// Read what is being replaced before users are removed below
+	conflicts := s.addUsersConflicts(ctx, req)
+
+	if req.DryRun {
+		return &AddUsersResponse{Success: true, Error: nil, Warnings: conflicts, Operations: s.planAddUsers(ctx, req)}, nil
+	}
+	s.logConflicts(conflicts)
+
 	// Add affected inbound tags to known inbounds
 	for _, tag := range req.AffectedInboundTags {
 		s.internal.AddXtlsConfigInbound(tag)
@@ -324,19 +524,17 @@ func (s *HandlerService) AddUsers(ctx context.Context, req *AddUsersRequest) (*A
 		// Step 1: Remove user from ALL known inbounds first
 		allTags := s.internal.GetXtlsConfigInbounds()
 		for _, tag := range allTags {
-			lock := s.getInboundLock(tag)
-			lock.Lock()
+			unlock := s.lockInbound(ctx, tag)
 
 			_ = s.removeUserFromInbound(ctx, tag, user.UserData.UserId)
 			s.internal.RemoveUserFromInbound(user.UserData.HashUuid, tag)
 
-			lock.Unlock()
+			unlock()
 		}
 
 		// Step 2: Add user to each inbound based on type
 		for _, item := range user.InboundData {
-			lock := s.getInboundLock(item.Tag)
-			lock.Lock()
+			unlock := s.lockInbound(ctx, item.Tag)
 
 			var err error
 
@@ -365,7 +563,7 @@ func (s *HandlerService) AddUsers(ctx context.Context, req *AddUsersRequest) (*A
 				}
 			default:
 				s.logger.Warn("Unknown user type", zap.String("type", item.Type))
-				lock.Unlock()
+				unlock()
 				continue
 			}
 
@@ -381,13 +579,39 @@ func (s *HandlerService) AddUsers(ctx context.Context, req *AddUsersRequest) (*A
 					zap.String("tag", item.Tag))
 			}
 
-			lock.Unlock()
+			unlock()
 		}
 	}
 
 	s.logger.Info("Batch add users completed", zap.Int("users", len(req.Users)))
+	s.hooks.Fire(&HookEvent{Event: HookUserAdded, Usernames: batchUsernames(req.Users), Tags: req.AffectedInboundTags})
+
+	resp := &AddUsersResponse{Success: true, Error: nil, Warnings: conflicts}
+	if s.sidecarsEnabled() {
+		users := make([]SidecarUser, 0, len(req.Users))
+		for _, user := range req.Users {
+			password := user.UserData.TrojanPassword
+			if password == "" {
+				password = user.UserData.SsPassword
+			}
+			if password == "" {
+				password = user.UserData.VlessUuid
+			}
+			users = append(users, SidecarUser{Username: user.UserData.UserId, Password: password})
+		}
+		resp.Cores = withCoreResults(true, nil, s.sidecars.UpsertUsers(users))
+	}
 
-	return &AddUsersResponse{Success: true, Error: nil}, nil
+	return resp, nil
+}
+
+// batchUsernames returns the usernames of a batch add request
+func batchUsernames(users []UserForBatch) []string {
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.UserData.UserId)
+	}
+	return usernames
 }
 
 // RemoveUserHashData represents hash data in remove request (Node.js format)
@@ -399,18 +623,38 @@ type RemoveUserHashData struct {
 type RemoveUserRequest struct {
 	Username string             `json:"username"`
 	HashData RemoveUserHashData `json:"hashData"`
+
+	policyChecked bool // Policies ran; a journal replay does not run them again
 }
 
 // RemoveUserResponse represents the response from removing a user
 // Matches Node.js RemoveUserResponseModel: { success: boolean, error: null | string }
 type RemoveUserResponse struct {
-	Success bool    `json:"success"`
-	Error   *string `json:"error"`
+	Success bool          `json:"success"`
+	Error   *string       `json:"error"`
+	Cores   []*CoreResult `json:"cores,omitempty"`  // Per-core results when sidecars are enabled
+	Queued  bool          `json:"queued,omitempty"` // Journaled while the core is down, applied once it is back
 }
 
 // RemoveUser removes a user from ALL known inbounds (Node.js compatible)
 func (s *HandlerService) RemoveUser(ctx context.Context, req *RemoveUserRequest) (*RemoveUserResponse, error) {
+	req.Username = s.usernames.Normalize(req.Username)
+	if err := s.checkRemoveUserPolicy(ctx, req); err != nil {
+		errMsg := err.Error()
+		return &RemoveUserResponse{Success: false, Error: &errMsg}, nil
+	}
+
+	leave, err := s.enterBarrier(ctx)
+	if err != nil {
+		errMsg := err.Error()
+		return &RemoveUserResponse{Success: false, Error: &errMsg}, nil
+	}
+	defer leave()
+
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		if s.journalRemoveUser(req) {
+			return &RemoveUserResponse{Success: true, Queued: true}, nil
+		}
 		errMsg := "Xray not running"
 		return &RemoveUserResponse{Success: false, Error: &errMsg}, nil
 	}
@@ -427,8 +671,7 @@ func (s *HandlerService) RemoveUser(ctx context.Context, req *RemoveUserRequest)
 
 	// Remove from all inbounds
 	for _, tag := range allTags {
-		lock := s.getInboundLock(tag)
-		lock.Lock()
+		unlock := s.lockInbound(ctx, tag)
 
 		s.logger.Debug("Removing user from inbound",
 			zap.String("username", req.Username),
@@ -442,7 +685,7 @@ func (s *HandlerService) RemoveUser(ctx context.Context, req *RemoveUserRequest)
 		}
 		s.internal.RemoveUserFromInbound(req.HashData.VlessUUID, tag)
 
-		lock.Unlock()
+		unlock()
 	}
 
 	s.logger.Info("Removed user from all inbounds",
@@ -451,15 +694,22 @@ func (s *HandlerService) RemoveUser(ctx context.Context, req *RemoveUserRequest)
 		zap.Int("failed", failCount))
 
 	// If ALL operations failed, return error (matches Node.js behavior)
+	resp := &RemoveUserResponse{Success: true, Error: nil}
 	if successCount == 0 && failCount > 0 {
 		errMsg := "all remove operations failed"
 		if lastError != nil {
 			errMsg = lastError.Error()
 		}
-		return &RemoveUserResponse{Success: false, Error: &errMsg}, nil
+		resp = &RemoveUserResponse{Success: false, Error: &errMsg}
+	} else {
+		s.hooks.Fire(&HookEvent{Event: HookUserRemoved, Username: req.Username, Usernames: []string{req.Username}, Tags: allTags})
+	}
+
+	if s.sidecarsEnabled() {
+		resp.Cores = withCoreResults(resp.Success, resp.Error, s.sidecars.RemoveUsers([]string{req.Username}))
 	}
 
-	return &RemoveUserResponse{Success: true, Error: nil}, nil
+	return resp, nil
 }
 
 // RemoveUserItem represents a user to remove in batch (Node.js format)
@@ -470,25 +720,79 @@ type RemoveUserItem struct {
 
 // RemoveUsersRequest represents a request to remove multiple users (Node.js format)
 type RemoveUsersRequest struct {
-	Users []RemoveUserItem `json:"users"`
+	Users  []RemoveUserItem `json:"users"`
+	DryRun bool             `json:"dryRun"` // Report planned operations without changing the core
+
+	policyChecked bool // Policies ran; a journal replay does not run them again
 }
 
 // RemoveUsersResponse represents the response from removing multiple users
 // Matches Node.js: { success: boolean, error: null | string }
 type RemoveUsersResponse struct {
-	Success bool    `json:"success"`
-	Error   *string `json:"error"`
+	Success    bool                `json:"success"`
+	Error      *string             `json:"error"`
+	Cores      []*CoreResult       `json:"cores,omitempty"`      // Per-core results when sidecars are enabled
+	Operations []*PlannedOperation `json:"operations,omitempty"` // Dry run only
+	Queued     bool                `json:"queued,omitempty"`     // Journaled while the core is down, applied once it is back
+}
+
+// removeUsernames returns the usernames of a batch remove request
+func removeUsernames(users []RemoveUserItem) []string {
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.UserId)
+	}
+	return usernames
+}
+
+// planRemoveUsers lists the operations RemoveUsers would perform, without mutating anything
+func (s *HandlerService) planRemoveUsers(ctx context.Context, req *RemoveUsersRequest, tags []string) []*PlannedOperation {
+	ops := make([]*PlannedOperation, 0)
+	for _, user := range req.Users {
+		for _, tag := range tags {
+			if s.userInInbound(ctx, tag, user.UserId) {
+				ops = append(ops, &PlannedOperation{Core: "xray", Username: user.UserId, Inbound: tag, Action: PlannedRemove})
+			}
+		}
+		if s.sidecarsEnabled() {
+			ops = append(ops, &PlannedOperation{Core: "sidecars", Username: user.UserId, Action: PlannedRemove})
+		}
+	}
+	return ops
 }
 
 // RemoveUsers removes multiple users from ALL known inbounds (Node.js compatible)
 func (s *HandlerService) RemoveUsers(ctx context.Context, req *RemoveUsersRequest) (*RemoveUsersResponse, error) {
+	for i := range req.Users {
+		req.Users[i].UserId = s.usernames.Normalize(req.Users[i].UserId)
+	}
+	if err := s.checkRemoveUsersPolicy(ctx, req); err != nil {
+		errMsg := err.Error()
+		return &RemoveUsersResponse{Success: false, Error: &errMsg}, nil
+	}
+
+	leave, err := s.enterBarrier(ctx)
+	if err != nil {
+		errMsg := err.Error()
+		return &RemoveUsersResponse{Success: false, Error: &errMsg}, nil
+	}
+	defer leave()
+
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		if s.journalRemoveUsers(req) {
+			return &RemoveUsersResponse{Success: true, Queued: true}, nil
+		}
 		errMsg := "Xray not running"
 		return &RemoveUsersResponse{Success: false, Error: &errMsg}, nil
 	}
 
 	// Get all known inbounds
 	allTags := s.internal.GetXtlsConfigInbounds()
+
+	if req.DryRun {
+		return &RemoveUsersResponse{Success: true, Error: nil, Operations: s.planRemoveUsers(ctx, req, allTags)}, nil
+	}
+
 	if len(allTags) == 0 {
 		return &RemoveUsersResponse{Success: true, Error: nil}, nil
 	}
@@ -504,8 +808,7 @@ func (s *HandlerService) RemoveUsers(ctx context.Context, req *RemoveUsersReques
 	for _, user := range req.Users {
 		// Remove from all known inbounds
 		for _, tag := range allTags {
-			lock := s.getInboundLock(tag)
-			lock.Lock()
+			unlock := s.lockInbound(ctx, tag)
 
 			s.logger.Debug("Removing user from inbound",
 				zap.String("userId", user.UserId),
@@ -519,7 +822,7 @@ func (s *HandlerService) RemoveUsers(ctx context.Context, req *RemoveUsersReques
 			}
 			s.internal.RemoveUserFromInbound(user.HashUuid, tag)
 
-			lock.Unlock()
+			unlock()
 		}
 	}
 
@@ -529,15 +832,22 @@ func (s *HandlerService) RemoveUsers(ctx context.Context, req *RemoveUsersReques
 		zap.Int("failed", failCount))
 
 	// If ALL operations failed, return error (matches Node.js behavior)
+	resp := &RemoveUsersResponse{Success: true, Error: nil}
 	if successCount == 0 && failCount > 0 {
 		errMsg := "all remove operations failed"
 		if lastError != nil {
 			errMsg = lastError.Error()
 		}
-		return &RemoveUsersResponse{Success: false, Error: &errMsg}, nil
+		resp = &RemoveUsersResponse{Success: false, Error: &errMsg}
+	} else {
+		s.hooks.Fire(&HookEvent{Event: HookUserRemoved, Usernames: removeUsernames(req.Users), Tags: allTags})
+	}
+
+	if s.sidecarsEnabled() {
+		resp.Cores = withCoreResults(resp.Success, resp.Error, s.sidecars.RemoveUsers(removeUsernames(req.Users)))
 	}
 
-	return &RemoveUsersResponse{Success: true, Error: nil}, nil
+	return resp, nil
 }
 
 // InboundUserInfo represents a user in an inbound (matches Node.js IInboundUser)
@@ -548,14 +858,48 @@ type InboundUserInfo struct {
 	Level    *uint32 `json:"level,omitempty"`
 }
 
+// Sort orders of an inbound user listing
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// GetInboundUsersRequest selects a page of an inbound's users. Without a
+// limit all matching users are returned.
+type GetInboundUsersRequest struct {
+	Tag    string `json:"tag"`
+	Prefix string `json:"prefix,omitempty"` // Only usernames starting with this
+	Sort   string `json:"sort,omitempty"`   // "asc" (default) or "desc" by username
+	Offset int    `json:"offset,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // 0 means no limit
+}
+
+// Validate checks the paging and sort parameters
+func (r *GetInboundUsersRequest) Validate() error {
+	if r.Offset < 0 || r.Limit < 0 {
+		return fmt.Errorf("offset and limit must not be negative")
+	}
+	if r.Sort != "" && r.Sort != SortAsc && r.Sort != SortDesc {
+		return fmt.Errorf("invalid sort %q (expected %q or %q)", r.Sort, SortAsc, SortDesc)
+	}
+	return nil
+}
+
 // GetInboundUsersResponse represents the response for getting inbound users
 type GetInboundUsersResponse struct {
-	Users []InboundUserInfo `json:"users"`
+	Users      []InboundUserInfo `json:"users"`
+	Total      int               `json:"total"`                // Users matching the prefix, across all pages
+	NextOffset *int              `json:"nextOffset,omitempty"` // Offset of the next page, if any
 }
 
-// GetInboundUsers returns the list of users in the specified inbound
+// GetInboundUsers returns the users in the specified inbound, sorted by
+// username, optionally filtered by prefix and paginated
 // Note: With embedded Xray-core, we rely on internal tracking
-func (s *HandlerService) GetInboundUsers(ctx context.Context, tag string) (*GetInboundUsersResponse, error) {
+func (s *HandlerService) GetInboundUsers(ctx context.Context, req *GetInboundUsersRequest) (*GetInboundUsersResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
 		return &GetInboundUsersResponse{
 			Users: []InboundUserInfo{},
@@ -563,17 +907,35 @@ func (s *HandlerService) GetInboundUsers(ctx context.Context, tag string) (*GetI
 	}
 
 	// Use internal service to get tracked users for this inbound
-	trackedUsers := s.internal.GetUsersInInbound(tag)
-	users := make([]InboundUserInfo, len(trackedUsers))
-	for i, username := range trackedUsers {
-		users[i] = InboundUserInfo{
+	trackedUsers := s.internal.GetUsersInInbound(req.Tag)
+	usernames := make([]string, 0, len(trackedUsers))
+	for _, username := range trackedUsers {
+		if strings.HasPrefix(username, req.Prefix) {
+			usernames = append(usernames, username)
+		}
+	}
+	if req.Sort == SortDesc {
+		sort.Sort(sort.Reverse(sort.StringSlice(usernames)))
+	} else {
+		sort.Strings(usernames)
+	}
+
+	resp := &GetInboundUsersResponse{Total: len(usernames)}
+	page := usernames[min(req.Offset, len(usernames)):]
+	if req.Limit > 0 && len(page) > req.Limit {
+		page = page[:req.Limit]
+		next := req.Offset + req.Limit
+		resp.NextOffset = &next
+	}
+
+	resp.Users = make([]InboundUserInfo, len(page))
+	for i, username := range page {
+		resp.Users[i] = InboundUserInfo{
 			Username: username,
 		}
 	}
 
-	return &GetInboundUsersResponse{
-		Users: users,
-	}, nil
+	return resp, nil
 }
 
 // GetInboundUsersCountResponse represents the response for getting inbound users count
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// fakeCore is an in-memory CoreBackend recording the calls made to it
+type fakeCore struct {
+	mu      sync.Mutex
+	running bool
+	config  []byte
+	starts  int
+	users   map[string]map[string]*protocol.MemoryUser // tag -> email -> user
+	calls   []string
+	failAdd error
+
+	// When set, Start sends on it once entered and waits to receive before
+	// starting, so tests can act while a start is in progress
+	startGate chan struct{}
+}
+
+func newFakeCore(running bool) *fakeCore {
+	return &fakeCore{running: running, users: make(map[string]map[string]*protocol.MemoryUser)}
+}
+
+func (f *fakeCore) record(format string, args ...interface{}) {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+// Calls returns the recorded calls and forgets them
+func (f *fakeCore) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeCore) Version() string { return "fake" }
+
+func (f *fakeCore) IsRunning() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running
+}
+
+func (f *fakeCore) Start(ctx context.Context, configJSON []byte) error {
+	if f.startGate != nil {
+		f.startGate <- struct{}{}
+		<-f.startGate
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("start")
+	f.running = true
+	f.config = configJSON
+	f.starts++
+	// Like a real core, the inbounds start with no runtime users
+	f.users = make(map[string]map[string]*protocol.MemoryUser)
+	return nil
+}
+
+func (f *fakeCore) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("stop")
+	f.running = false
+	return nil
+}
+
+func (f *fakeCore) Restart(ctx context.Context, configJSON []byte) error {
+	if err := f.Stop(); err != nil {
+		return err
+	}
+	return f.Start(ctx, configJSON)
+}
+
+func (f *fakeCore) GetConfig() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.config
+}
+
+func (f *fakeCore) AddUser(ctx context.Context, inboundTag string, user *protocol.MemoryUser) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("add %s %s", inboundTag, user.Email)
+	if f.failAdd != nil {
+		return f.failAdd
+	}
+	if f.users[inboundTag] == nil {
+		f.users[inboundTag] = make(map[string]*protocol.MemoryUser)
+	}
+	if _, exists := f.users[inboundTag][user.Email]; exists {
+		return fmt.Errorf("User %s already exists", user.Email)
+	}
+	f.users[inboundTag][user.Email] = user
+	return nil
+}
+
+func (f *fakeCore) RemoveUser(ctx context.Context, inboundTag string, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("remove %s %s", inboundTag, email)
+	if _, exists := f.users[inboundTag][email]; !exists {
+		return fmt.Errorf("User %s not found", email)
+	}
+	delete(f.users[inboundTag], email)
+	return nil
+}
+
+func (f *fakeCore) GetInboundUser(ctx context.Context, inboundTag string, email string) (*protocol.MemoryUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, exists := f.users[inboundTag][email]
+	if !exists {
+		return nil, fmt.Errorf("User %s not found", email)
+	}
+	return user, nil
+}
+
+func (f *fakeCore) GetInboundUsers(ctx context.Context, inboundTag string) ([]*protocol.MemoryUser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []*protocol.MemoryUser
+	for _, user := range f.users[inboundTag] {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (f *fakeCore) GetSystemStats(ctx context.Context) (*xraycore.SystemStats, error) {
+	return &xraycore.SystemStats{}, nil
+}
+
+const (
+	testUUID1 = "5f0c3b4e-2d1a-4c8b-9e7f-1a2b3c4d5e6f"
+	testUUID2 = "0b8e2a6c-7d3f-4e1a-8c5b-9f2d4e6a8b1c"
+)
+
+func newTestHandler(core *fakeCore) (*HandlerService, *InternalService) {
+	internal := NewInternalService(&InternalConfig{}, zap.NewNop())
+	flags, _ := NewFeatureFlags(nil)
+	return NewHandlerService(core, internal, nil, flags, nil, nil, nil, nil, zap.NewNop()), internal
+}
+
+func vlessUser(tag, username, uuid string) UserData {
+	return UserData{Type: "vless", Tag: tag, Username: username, UUID: uuid}
+}
+
+func TestHandler_AddUser_RemovesFromAllInboundsFirst(t *testing.T) {
+	core := newFakeCore(true)
+	handler, internal := newTestHandler(core)
+	ctx := context.Background()
+
+	// alice is in two inbounds, then moved to one of them only
+	resp, err := handler.AddUser(ctx, &AddUserRequest{
+		Data:     []UserData{vlessUser("A", "alice", testUUID1), vlessUser("B", "alice", testUUID1)},
+		HashData: HashData{VlessUUID: testUUID1},
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("First add failed: %v %+v", err, resp)
+	}
+	core.Calls()
+
+	resp, err = handler.AddUser(ctx, &AddUserRequest{
+		Data:     []UserData{vlessUser("A", "alice", testUUID2)},
+		HashData: HashData{VlessUUID: testUUID2, PrevVlessUUID: testUUID1},
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("Second add failed: %v %+v", err, resp)
+	}
+
+	calls := core.Calls()
+	if len(calls) != 3 || calls[2] != "add A alice" {
+		t.Fatalf("Expected removal from both inbounds then one add, got %v", calls)
+	}
+	removed := strings.Join(calls[:2], ",")
+	if !strings.Contains(removed, "remove A alice") || !strings.Contains(removed, "remove B alice") {
+		t.Errorf("Expected alice removed from A and B before adding, got %v", calls)
+	}
+
+	if _, err := core.GetInboundUser(ctx, "B", "alice"); err == nil {
+		t.Error("Expected alice to be gone from B")
+	}
+	if user, err := core.GetInboundUser(ctx, "A", "alice"); err != nil || !hasVlessUUID(user, testUUID2) {
+		t.Errorf("Expected alice in A with the new UUID, got %v %v", user, err)
+	}
+	if n := internal.GetUsersCountInInbound("A"); n != 1 {
+		t.Errorf("Expected 1 tracked user in A, got %d", n)
+	}
+}
+
+func TestHandler_AddUser_CoreNotRunning(t *testing.T) {
+	core := newFakeCore(false)
+	handler, _ := newTestHandler(core)
+
+	resp, err := handler.AddUser(context.Background(), &AddUserRequest{
+		Data: []UserData{vlessUser("A", "alice", testUUID1)},
+	})
+	if err != nil {
+		t.Fatalf("AddUser returned error: %v", err)
+	}
+	if resp.Success || resp.Error == nil {
+		t.Errorf("Expected failure while the core is down, got %+v", resp)
+	}
+	if calls := core.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no core calls, got %v", calls)
+	}
+}
+
+func TestHandler_JournalReplayedWhenCoreIsBack(t *testing.T) {
+	core := newFakeCore(false)
+	handler, _ := newTestHandler(core)
+	handler.flags.Toggle(FlagMutationJournal, true)
+	ctx := context.Background()
+
+	for _, username := range []string{"alice", "bob"} {
+		resp, _ := handler.AddUser(ctx, &AddUserRequest{Data: []UserData{vlessUser("A", username, testUUID1)}})
+		if !resp.Success || !resp.Queued {
+			t.Fatalf("Expected the add to be queued, got %+v", resp)
+		}
+	}
+	if resp, _ := handler.RemoveUser(ctx, &RemoveUserRequest{Username: "alice"}); !resp.Queued {
+		t.Fatalf("Expected the removal to be queued, got %+v", resp)
+	}
+	if resp, _ := handler.AddUser(ctx, &AddUserRequest{Data: []UserData{vlessUser("A", "carol", "not-a-uuid")}}); resp.Queued {
+		t.Error("Expected an invalid add not to be queued")
+	}
+	if pending := handler.Journal().Pending; len(pending) != 3 {
+		t.Fatalf("Expected 3 pending mutations, got %d", len(pending))
+	}
+
+	health := NewHealthManager(&HealthConfig{}, core, zap.NewNop())
+	replayed := make(chan struct{})
+	health.OnOnline(func() {
+		handler.ReplayJournal(ctx)
+		close(replayed)
+	})
+	core.Start(ctx, nil)
+	health.MarkOnline()
+	<-replayed
+
+	if _, err := core.GetInboundUser(ctx, "A", "alice"); err == nil {
+		t.Error("Expected alice to be removed by the replay")
+	}
+	if _, err := core.GetInboundUser(ctx, "A", "bob"); err != nil {
+		t.Errorf("Expected bob to be added by the replay: %v", err)
+	}
+	journal := handler.Journal()
+	if len(journal.Pending) != 0 || journal.LastReplay.Replayed != 3 || journal.LastReplay.Failed != 0 {
+		t.Errorf("Expected every mutation to be replayed, got %+v", journal)
+	}
+}
+
+func TestHandler_JournalDiscardedByPanelStart(t *testing.T) {
+	core := newFakeCore(false)
+	handler, _ := newTestHandler(core)
+	handler.flags.Toggle(FlagMutationJournal, true)
+	ctx := context.Background()
+
+	// alice is added while the core is down, then deleted on the panel
+	if resp, _ := handler.AddUser(ctx, &AddUserRequest{Data: []UserData{vlessUser("VLESS", "alice", testUUID1)}}); !resp.Queued {
+		t.Fatalf("Expected the add to be queued, got %+v", resp)
+	}
+
+	// The panel brings the core back with a config without alice
+	s := newTestXrayService(t, core, false)
+	s.OnPanelConfig(handler.DiscardJournal)
+	replayed := make(chan struct{})
+	s.health.OnOnline(func() {
+		handler.ReplayJournal(ctx)
+		close(replayed)
+	})
+	mustStart(t, s, startRequest("h1", false))
+	<-replayed
+
+	if _, err := core.GetInboundUser(ctx, "VLESS", "alice"); err == nil {
+		t.Error("Expected the journaled add not to be replayed over the panel config")
+	}
+	if journal := handler.Journal(); len(journal.Pending) != 0 || journal.Discarded != 1 || journal.LastReplay != nil {
+		t.Errorf("Expected the mutation to be discarded, got %+v", journal)
+	}
+}
+
+func TestHandler_AddUser_InvalidDoesNotTouchCore(t *testing.T) {
+	core := newFakeCore(true)
+	handler, _ := newTestHandler(core)
+
+	resp, _ := handler.AddUser(context.Background(), &AddUserRequest{
+		Data: []UserData{vlessUser("A", "alice", "not-a-uuid")},
+	})
+	if resp.Success || len(resp.FieldErrors) == 0 {
+		t.Errorf("Expected field errors, got %+v", resp)
+	}
+	if calls := core.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no core calls for a rejected request, got %v", calls)
+	}
+}
+
+func TestHandler_AddUser_CoreErrorReported(t *testing.T) {
+	core := newFakeCore(true)
+	core.failAdd = fmt.Errorf("inbound not found")
+	handler, _ := newTestHandler(core)
+
+	resp, _ := handler.AddUser(context.Background(), &AddUserRequest{
+		Data:     []UserData{vlessUser("A", "alice", testUUID1)},
+		HashData: HashData{VlessUUID: testUUID1},
+	})
+	if resp.Success || resp.Error == nil || !strings.Contains(*resp.Error, "inbound not found") {
+		t.Errorf("Expected the core error to be reported, got %+v", resp)
+	}
+}
+
+func TestHandler_AddUser_PolicyVetoesAndRenames(t *testing.T) {
+	core := newFakeCore(true)
+	handler, _ := newTestHandler(core)
+	handler.policies = NewPolicyService(&PolicyConfig{Policies: []PolicyDefinition{{
+		Kind:   "rules",
+		Config: json.RawMessage(`{"rename": {"pattern": "^legacy_", "replacement": ""}, "denyInbounds": ["B"]}`),
+	}}}, zap.NewNop())
+	ctx := context.Background()
+
+	resp, _ := handler.AddUser(ctx, &AddUserRequest{
+		Data:     []UserData{vlessUser("A", "alice", testUUID1), vlessUser("B", "alice", testUUID1)},
+		HashData: HashData{VlessUUID: testUUID1},
+	})
+	if resp.Success || resp.Error == nil || len(core.Calls()) != 0 {
+		t.Errorf("Expected the vetoed user not to reach the core, got %+v", resp)
+	}
+
+	resp, _ = handler.AddUser(ctx, &AddUserRequest{
+		Data:     []UserData{vlessUser("A", "legacy_alice", testUUID1)},
+		HashData: HashData{VlessUUID: testUUID1},
+	})
+	if !resp.Success {
+		t.Fatalf("Add failed: %+v", resp)
+	}
+	if _, err := core.GetInboundUser(ctx, "A", "alice"); err != nil {
+		t.Errorf("Expected the user to be added as alice, got %v", err)
+	}
+}
+
+// hasVlessUUID reports whether a vless user has the given UUID
+func hasVlessUUID(user *protocol.MemoryUser, uuid string) bool {
+	expected, err := xraycore.CreateVlessUser(user.Email, uuid, "", 0)
+	if err != nil {
+		return false
+	}
+	return user.Account.Equals(expected.Account)
+}
+
+func TestHandler_GetInboundUsers_Paginated(t *testing.T) {
+	core := newFakeCore(true)
+	handler, internal := newTestHandler(core)
+	for _, username := range []string{"carol", "alice", "bob", "alex", "dave"} {
+		internal.AddUserToInbound(username, "A")
+	}
+
+	usernames := func(resp *GetInboundUsersResponse) []string {
+		var names []string
+		for _, user := range resp.Users {
+			names = append(names, user.Username)
+		}
+		return names
+	}
+
+	resp, err := handler.GetInboundUsers(context.Background(), &GetInboundUsersRequest{Tag: "A", Limit: 2})
+	if err != nil {
+		t.Fatalf("GetInboundUsers returned error: %v", err)
+	}
+	if got := strings.Join(usernames(resp), ","); got != "alex,alice" || resp.Total != 5 || resp.NextOffset == nil || *resp.NextOffset != 2 {
+		t.Errorf("Unexpected first page %s total=%d next=%v", got, resp.Total, resp.NextOffset)
+	}
+
+	resp, _ = handler.GetInboundUsers(context.Background(), &GetInboundUsersRequest{Tag: "A", Offset: 4, Limit: 2})
+	if got := strings.Join(usernames(resp), ","); got != "dave" || resp.NextOffset != nil {
+		t.Errorf("Unexpected last page %s next=%v", got, resp.NextOffset)
+	}
+
+	resp, _ = handler.GetInboundUsers(context.Background(), &GetInboundUsersRequest{Tag: "A", Prefix: "al", Sort: SortDesc})
+	if got := strings.Join(usernames(resp), ","); got != "alice,alex" || resp.Total != 2 {
+		t.Errorf("Unexpected prefix listing %s total=%d", got, resp.Total)
+	}
+
+	if _, err := handler.GetInboundUsers(context.Background(), &GetInboundUsersRequest{Tag: "A", Sort: "random"}); err == nil {
+		t.Error("Expected an invalid sort to be rejected")
+	}
+}
+
+func TestHandler_ResyncNormalizesConfigEmails(t *testing.T) {
+	core := newFakeCore(true)
+	internal := NewInternalService(&InternalConfig{}, zap.NewNop())
+	flags, _ := NewFeatureFlags(nil)
+	usernames, _ := NewUsernameNormalizer([]string{UsernameLowercase})
+	handler := NewHandlerService(core, internal, nil, flags, nil, usernames, nil, nil, zap.NewNop())
+	ctx := context.Background()
+
+	if resp, _ := handler.AddUser(ctx, &AddUserRequest{Data: []UserData{vlessUser("VLESS", "Alice", testUUID1)}}); !resp.Success {
+		t.Fatalf("Expected add to succeed, got %v", *resp.Error)
+	}
+	core.Calls()
+
+	config := json.RawMessage(`{"inbounds": [{"tag": "VLESS", "protocol": "vless",
+		"settings": {"clients": [{"email": "Alice", "id": "` + testUUID1 + `"}]}}]}`)
+	resp, err := handler.ResyncFromConfig(ctx, config, nil, false)
+	if err != nil || !resp.Success {
+		t.Fatalf("Expected resync to succeed, got %+v (%v)", resp, err)
+	}
+	if len(resp.Operations) != 0 {
+		t.Errorf("Expected the normalized user to be left alone, got %+v", resp.Operations)
+	}
+	if calls := core.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no core changes, got %v", calls)
+	}
+	if users := internal.GetUsersInInbound("VLESS"); len(users) != 1 || users[0] != "alice" {
+		t.Errorf("Expected tracking to hold the normalized email, got %v", users)
+	}
+}
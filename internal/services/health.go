@@ -0,0 +1,292 @@
+// Package services provides business logic for core health tracking
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Core health states
+const (
+	HealthOnline   = "ONLINE"
+	HealthDegraded = "DEGRADED" // Core runs but its stats API fails or inbounds fail the probe
+	HealthDown     = "DOWN"
+)
+
+// HealthState is a snapshot of the core health
+type HealthState struct {
+	State     string    `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	Since     time.Time `json:"since"`     // When the current state was entered
+	LastCheck time.Time `json:"lastCheck"` // When the core was last probed
+
+	// Open file descriptors versus limits at the last probe (Linux only)
+	FileDescriptors []*FDUsage `json:"fileDescriptors,omitempty"`
+
+	// Node clock versus NTP at the last clock check (omitted when disabled)
+	Clock *ClockState `json:"clock,omitempty"`
+
+	// Why the last started config could not be saved to config.json; cleared
+	// by the next successful write
+	ConfigPersistError string `json:"configPersistError,omitempty"`
+}
+
+// HealthManager is the single source of core health. It probes the core on an
+// interval and is updated by lifecycle operations (start, stop, restore), so
+// Start, healthcheck and metrics all read the same state.
+type HealthManager struct {
+	mu            sync.RWMutex
+	logger        *zap.Logger
+	xrayCore      CoreBackend
+	interval      time.Duration
+	inboundHealth func() map[string]bool
+	processes     func() map[string]int
+	clock         func() *ClockState
+	fdWarnPercent float64
+	state         HealthState
+	stop          chan struct{}
+	onOnline      []func() // See OnOnline
+}
+
+// HealthConfig holds Health manager configuration
+type HealthConfig struct {
+	Interval      time.Duration          // Probe interval; 0 disables background probing
+	InboundHealth func() map[string]bool // Optional per-inbound probe results (tag -> healthy)
+	Processes     func() map[string]int  // Optional extra processes to watch (name -> pid), e.g. sidecars
+	Clock         func() *ClockState     // Optional result of the last clock check
+	FDWarnPercent float64                // Degrade when a process uses this share of its FD limit; 0 disables
+}
+
+// NewHealthManager creates a new HealthManager; the core starts out DOWN
+func NewHealthManager(cfg *HealthConfig, xrayCore CoreBackend, logger *zap.Logger) *HealthManager {
+	return &HealthManager{
+		logger:        logger,
+		xrayCore:      xrayCore,
+		interval:      cfg.Interval,
+		inboundHealth: cfg.InboundHealth,
+		processes:     cfg.Processes,
+		clock:         cfg.Clock,
+		fdWarnPercent: cfg.FDWarnPercent,
+		state: HealthState{
+			State:  HealthDown,
+			Reason: "not started",
+			Since:  time.Now(),
+		},
+	}
+}
+
+// Start begins probing the core in the background
+func (h *HealthManager) Start() {
+	if h.interval <= 0 || h.stop != nil {
+		return
+	}
+
+	h.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				h.Check(context.Background())
+			}
+		}
+	}(h.stop)
+}
+
+// Stop stops background probing
+func (h *HealthManager) Stop() {
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+}
+
+// OnOnline registers fn to run, in a goroutine of its own, whenever the core
+// comes back from DOWN, e.g. to replay work deferred while it was down
+func (h *HealthManager) OnOnline(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onOnline = append(h.onOnline, fn)
+}
+
+// State returns the current health state
+func (h *HealthManager) State() HealthState {
+	h.mu.RLock()
+	state := h.state
+	h.mu.RUnlock()
+
+	if h.clock != nil {
+		state.Clock = h.clock()
+	}
+	return state
+}
+
+// IsOnline reports whether the core is up (ONLINE or DEGRADED)
+func (h *HealthManager) IsOnline() bool {
+	return h.State().State != HealthDown
+}
+
+// MarkOnline records a successful start
+func (h *HealthManager) MarkOnline() {
+	h.set(HealthOnline, "", time.Now())
+}
+
+// MarkDown records that the core stopped or failed, with the reason
+func (h *HealthManager) MarkDown(reason string) {
+	h.set(HealthDown, reason, time.Now())
+}
+
+// Check probes the core now, updates the state and reports whether the core responds
+func (h *HealthManager) Check(ctx context.Context) bool {
+	now := time.Now()
+	fds := h.fileDescriptors()
+	h.mu.Lock()
+	h.state.FileDescriptors = fds
+	h.mu.Unlock()
+
+	if h.xrayCore == nil || !h.xrayCore.IsRunning() {
+		// Keep the reason of an explicit stop or failure
+		if h.State().State != HealthDown {
+			h.set(HealthDown, "core not running", now)
+		}
+		h.touch(now)
+		return false
+	}
+
+	if _, err := h.xrayCore.GetSystemStats(ctx); err != nil {
+		h.set(HealthDegraded, fmt.Sprintf("stats API failed: %v", err), now)
+		return false
+	}
+
+	var reasons []string
+	if failing := h.failingInbounds(); len(failing) > 0 {
+		reasons = append(reasons, "inbounds failing probe: "+strings.Join(failing, ", "))
+	}
+	if exhausted := h.fdsNearLimit(fds); len(exhausted) > 0 {
+		reasons = append(reasons, "file descriptors near limit: "+strings.Join(exhausted, ", "))
+	}
+	if len(reasons) > 0 {
+		h.set(HealthDegraded, strings.Join(reasons, "; "), now)
+		return true
+	}
+
+	h.set(HealthOnline, "", now)
+	return true
+}
+
+// failingInbounds returns the tags of inbounds failing the background probe
+func (h *HealthManager) failingInbounds() []string {
+	if h.inboundHealth == nil {
+		return nil
+	}
+
+	var failing []string
+	for tag, healthy := range h.inboundHealth() {
+		if !healthy {
+			failing = append(failing, tag)
+		}
+	}
+	sort.Strings(failing)
+	return failing
+}
+
+// fileDescriptors reads FD usage of the node process and the watched processes
+func (h *HealthManager) fileDescriptors() []*FDUsage {
+	var result []*FDUsage
+	if usage, err := readFDUsage("node", os.Getpid()); err == nil {
+		result = append(result, usage)
+	}
+	if h.processes == nil {
+		return result
+	}
+
+	processes := h.processes()
+	names := make([]string, 0, len(processes))
+	for name := range processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if usage, err := readFDUsage(name, processes[name]); err == nil {
+			result = append(result, usage)
+		}
+	}
+	return result
+}
+
+// fdsNearLimit returns the processes at or above the FD warning threshold
+func (h *HealthManager) fdsNearLimit(fds []*FDUsage) []string {
+	if h.fdWarnPercent <= 0 {
+		return nil
+	}
+
+	var exhausted []string
+	for _, usage := range fds {
+		if usage.Limit > 0 && usage.Percent >= h.fdWarnPercent {
+			exhausted = append(exhausted, fmt.Sprintf("%s %d/%d", usage.Process, usage.Open, usage.Limit))
+		}
+	}
+	return exhausted
+}
+
+// set updates the state, logging transitions
+func (h *HealthManager) set(state, reason string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state.LastCheck = now
+	if h.state.State == state && h.state.Reason == reason {
+		return
+	}
+
+	if h.state.State != state {
+		h.state.Since = now
+		fields := []zap.Field{zap.String("from", h.state.State), zap.String("to", state)}
+		if reason != "" {
+			fields = append(fields, zap.String("reason", reason))
+		}
+		if state == HealthOnline {
+			h.logger.Info("Xray health changed", fields...)
+		} else {
+			h.logger.Warn("Xray health changed", fields...)
+		}
+		if h.state.State == HealthDown {
+			for _, fn := range h.onOnline {
+				go fn()
+			}
+		}
+	}
+	h.state.State = state
+	h.state.Reason = reason
+}
+
+// SetConfigPersistError records why config.json could not be written, or
+// clears it when err is nil. The state is left alone: the core runs, it just
+// would not come back with its current config after a node restart.
+func (h *HealthManager) SetConfigPersistError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state.ConfigPersistError = ""
+	if err != nil {
+		h.state.ConfigPersistError = err.Error()
+	}
+}
+
+// touch records a probe without changing the state
+func (h *HealthManager) touch(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state.LastCheck = now
+}
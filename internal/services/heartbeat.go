@@ -0,0 +1,189 @@
+// Package services provides business logic for panel heartbeats
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// Heartbeat is the status document posted to the panel
+type Heartbeat struct {
+	Timestamp       time.Time       `json:"timestamp"`
+	NodeInformation NodeInformation `json:"nodeInformation"`
+	XrayVersion     string          `json:"xrayVersion,omitempty"`
+	XrayHealth      HealthState     `json:"xrayHealth"`
+	XrayUptime      int64           `json:"xrayUptime"`     // Seconds
+	OnlineUsers     int             `json:"onlineUsers"`    // Users with live connections (-1 if unknown)
+	TrackedUsers    int             `json:"trackedUsers"`   // Users known to the node
+	Load            []float64       `json:"load,omitempty"` // 1, 5 and 15 minute load averages (Linux only)
+	CPUCores        int             `json:"cpuCores"`
+}
+
+// HeartbeatService periodically posts a compact status document to the panel,
+// so a dead node is noticed sooner than by panel-side polling
+type HeartbeatService struct {
+	logger     *zap.Logger
+	xrayCore   *xraycore.Instance
+	health     *HealthManager
+	internal   *InternalService
+	url        string
+	token      string
+	interval   time.Duration
+	httpClient *http.Client
+
+	failing bool // Last heartbeat failed; used to log only transitions
+	stop    chan struct{}
+}
+
+// HeartbeatConfig holds Heartbeat service configuration
+type HeartbeatConfig struct {
+	URL      string // Panel endpoint receiving the heartbeat; empty disables
+	Token    string // Sent as "Authorization: Bearer <token>" when set
+	Interval time.Duration
+}
+
+// NewHeartbeatService creates a new HeartbeatService
+func NewHeartbeatService(cfg *HeartbeatConfig, xrayCore *xraycore.Instance, health *HealthManager, internal *InternalService, logger *zap.Logger) *HeartbeatService {
+	return &HeartbeatService{
+		logger:     logger,
+		xrayCore:   xrayCore,
+		health:     health,
+		internal:   internal,
+		url:        cfg.URL,
+		token:      cfg.Token,
+		interval:   cfg.Interval,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Start begins sending heartbeats in the background, if configured
+func (s *HeartbeatService) Start() {
+	if s.url == "" || s.interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		s.beat()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.beat()
+			}
+		}
+	}(s.stop)
+
+	s.logger.Info("Heartbeat started", zap.Duration("interval", s.interval))
+}
+
+// Stop stops sending heartbeats
+func (s *HeartbeatService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// Current builds the heartbeat document
+func (s *HeartbeatService) Current(ctx context.Context) *Heartbeat {
+	hb := &Heartbeat{
+		Timestamp:       time.Now().UTC(),
+		NodeInformation: currentNodeInformation(),
+		XrayHealth:      s.health.State(),
+		OnlineUsers:     -1,
+		TrackedUsers:    s.internal.GetUserCount(),
+		Load:            getLoadAverage(),
+		CPUCores:        getCPUCores(),
+	}
+
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		hb.XrayVersion = s.xrayCore.Version()
+		hb.XrayUptime = s.xrayCore.Uptime()
+		if users, err := s.xrayCore.GetOnlineUsers(ctx); err == nil {
+			hb.OnlineUsers = len(users)
+		}
+	}
+	return hb
+}
+
+// beat sends one heartbeat, logging when delivery starts or stops failing
+func (s *HeartbeatService) beat() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+
+	err := s.send(ctx, s.Current(ctx))
+	switch {
+	case err != nil && !s.failing:
+		s.logger.Warn("Heartbeat failed", zap.Error(err))
+	case err == nil && s.failing:
+		s.logger.Info("Heartbeat recovered")
+	}
+	s.failing = err != nil
+}
+
+// send posts a heartbeat to the panel
+func (s *HeartbeatService) send(ctx context.Context, hb *Heartbeat) error {
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("heartbeat endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// getLoadAverage returns the 1, 5 and 15 minute load averages from /proc/loadavg,
+// or nil where it is not available
+func getLoadAverage() []float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil
+	}
+	load := make([]float64, 0, 3)
+	for _, field := range fields[:3] {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil
+		}
+		load = append(load, v)
+	}
+	return load
+}
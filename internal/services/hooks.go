@@ -0,0 +1,446 @@
+// Package services provides business logic for lifecycle hooks
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Hook events
+const (
+	HookPreStart    = "pre-start"    // Before the core is started; runs synchronously
+	HookPostStart   = "post-start"   // After the core started and passed its health check
+	HookUserAdded   = "user-added"   // Users were added to the core
+	HookUserRemoved = "user-removed" // Users were removed from the core
+	HookIPBlocked   = "ip-blocked"   // An IP was blocked (Vision)
+)
+
+var hookEvents = []string{HookPreStart, HookPostStart, HookUserAdded, HookUserRemoved, HookIPBlocked}
+
+const (
+	// defaultHookTimeout bounds one hook run unless the hook sets its own
+	defaultHookTimeout = 10 * time.Second
+
+	// hookQueueSize is how many events may wait for the hook runner; events
+	// fired while it is full are dropped
+	hookQueueSize = 1000
+
+	// hookHistorySize is how many recent runs are kept for the hooks endpoint
+	hookHistorySize = 50
+
+	// hookOutputLimit caps the command output or response body kept with a failed run
+	hookOutputLimit = 512
+)
+
+// HookDefinition is a hook from the hooks file. A hook runs either a command
+// or an HTTP request. The command arguments, URL and body are Go templates
+// over HookEvent, e.g. "{{.IP}}" or "{{json .Usernames}}".
+type HookDefinition struct {
+	Name    string            `json:"name,omitempty"`
+	Events  []string          `json:"events"`
+	Command []string          `json:"command,omitempty"` // Program and arguments, run without a shell; the event is written to stdin as JSON
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`  // POST by default
+	Headers map[string]string `json:"headers,omitempty"` // Not templated
+	Body    string            `json:"body,omitempty"`    // The event as JSON by default
+	Timeout string            `json:"timeout,omitempty"` // e.g. "5s"; 10s by default
+}
+
+// HookEvent is what a hook is run with
+type HookEvent struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Username  string    `json:"username,omitempty"`  // Single-user events
+	Usernames []string  `json:"usernames,omitempty"` // All affected users, also for single-user events
+	Tags      []string  `json:"tags,omitempty"`      // Affected inbound tags
+	IP        string    `json:"ip,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Admin     string    `json:"admin,omitempty"`
+	Trigger   string    `json:"trigger,omitempty"` // Why the core is (re)started
+	Version   string    `json:"version,omitempty"` // Core version, post-start only
+}
+
+// HookRun is the outcome of one hook run
+type HookRun struct {
+	Hook       string    `json:"hook"`
+	Event      string    `json:"event"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// HookInfo describes a configured hook. Header values are left out, since
+// they usually carry credentials.
+type HookInfo struct {
+	Name    string   `json:"name"`
+	Events  []string `json:"events"`
+	Kind    string   `json:"kind"` // "command" or "http"
+	Headers []string `json:"headers,omitempty"`
+	Timeout string   `json:"timeout"`
+}
+
+// HooksResponse lists the configured hooks and their recent runs
+type HooksResponse struct {
+	Hooks   []*HookInfo `json:"hooks"`
+	Recent  []*HookRun  `json:"recent"` // Newest first
+	Dropped uint64      `json:"dropped"`
+}
+
+// hook is a HookDefinition with its templates parsed
+type hook struct {
+	def     HookDefinition
+	name    string
+	events  map[string]bool
+	command []*template.Template
+	url     *template.Template
+	body    *template.Template
+	timeout time.Duration
+}
+
+// hookFuncs are available in hook templates
+var hookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// LoadHooks reads hook definitions from a JSON file holding an array of hooks
+func LoadHooks(path string) ([]HookDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks: %w", err)
+	}
+
+	var defs []HookDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks: %w", err)
+	}
+	for i, def := range defs {
+		if _, err := compileHook(i, def); err != nil {
+			return nil, err
+		}
+	}
+	return defs, nil
+}
+
+// compileHook validates a definition and parses its templates. The templates
+// are executed once against an empty event, so unknown fields are reported
+// at load time rather than on the first event.
+func compileHook(i int, def HookDefinition) (*hook, error) {
+	h := &hook{def: def, name: def.Name, events: make(map[string]bool), timeout: defaultHookTimeout}
+	if h.name == "" {
+		h.name = fmt.Sprintf("hook-%d", i+1)
+	}
+	fail := func(format string, args ...interface{}) (*hook, error) {
+		return nil, fmt.Errorf("hook %q: %s", h.name, fmt.Sprintf(format, args...))
+	}
+
+	if len(def.Events) == 0 {
+		return fail("no events")
+	}
+	for _, event := range def.Events {
+		known := false
+		for _, e := range hookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return fail("unknown event %q (expected one of %s)", event, strings.Join(hookEvents, ", "))
+		}
+		h.events[event] = true
+	}
+	if (len(def.Command) == 0) == (def.URL == "") {
+		return fail("exactly one of command and url is required")
+	}
+	if def.Timeout != "" {
+		timeout, err := time.ParseDuration(def.Timeout)
+		if err != nil || timeout <= 0 {
+			return fail("invalid timeout %q", def.Timeout)
+		}
+		h.timeout = timeout
+	}
+
+	parse := func(text string) (*template.Template, error) {
+		t, err := template.New(h.name).Funcs(hookFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return t, t.Execute(io.Discard, &HookEvent{})
+	}
+	for _, arg := range def.Command {
+		t, err := parse(arg)
+		if err != nil {
+			return fail("invalid command template: %v", err)
+		}
+		h.command = append(h.command, t)
+	}
+	if def.URL != "" {
+		t, err := parse(def.URL)
+		if err != nil {
+			return fail("invalid url template: %v", err)
+		}
+		h.url = t
+	}
+	if def.Body != "" {
+		t, err := parse(def.Body)
+		if err != nil {
+			return fail("invalid body template: %v", err)
+		}
+		h.body = t
+	}
+	return h, nil
+}
+
+// HookService runs the configured hooks on lifecycle events. All methods
+// are safe on a nil service, which runs nothing.
+type HookService struct {
+	logger     *zap.Logger
+	hooks      []*hook
+	httpClient *http.Client
+
+	queue chan *HookEvent
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu      sync.Mutex
+	recent  []*HookRun
+	dropped uint64
+}
+
+// HookConfig holds hook service configuration
+type HookConfig struct {
+	Hooks []HookDefinition
+}
+
+// NewHookService creates a new HookService and starts its runner. Hooks
+// that do not compile are logged and skipped.
+func NewHookService(cfg *HookConfig, logger *zap.Logger) *HookService {
+	s := &HookService{
+		logger:     logger,
+		httpClient: &http.Client{},
+		queue:      make(chan *HookEvent, hookQueueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i, def := range cfg.Hooks {
+		h, err := compileHook(i, def)
+		if err != nil {
+			logger.Warn("Skipping invalid hook", zap.Error(err))
+			continue
+		}
+		s.hooks = append(s.hooks, h)
+	}
+	go s.run()
+	return s
+}
+
+// run executes queued events one at a time, in the order they were fired
+func (s *HookService) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case event := <-s.queue:
+			s.Run(context.Background(), event)
+		}
+	}
+}
+
+// Stop stops the runner after the hook in progress. Queued events are dropped.
+func (s *HookService) Stop() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// Fire queues an event for the hooks subscribed to it and returns at once
+func (s *HookService) Fire(event *HookEvent) {
+	if s == nil || !s.subscribed(event.Event) {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	select {
+	case s.queue <- event:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		s.logger.Warn("Hook queue full, dropping event", zap.String("event", event.Event))
+	}
+}
+
+// Run runs the hooks subscribed to an event in order and waits for them.
+// It returns the errors of the hooks that failed.
+func (s *HookService) Run(ctx context.Context, event *HookEvent) []string {
+	if s == nil || !s.subscribed(event.Event) {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	var failures []string
+	for _, h := range s.hooks {
+		if !h.events[event.Event] {
+			continue
+		}
+		started := time.Now()
+		err := s.runHook(ctx, h, event)
+
+		run := &HookRun{Hook: h.name, Event: event.Event, StartedAt: started.UTC(), DurationMs: time.Since(started).Milliseconds()}
+		if err != nil {
+			run.Error = err.Error()
+			failures = append(failures, fmt.Sprintf("hook %q: %v", h.name, err))
+			s.logger.Warn("Hook failed",
+				zap.String("hook", h.name),
+				zap.String("event", event.Event),
+				zap.Error(err))
+		}
+		s.record(run)
+	}
+	return failures
+}
+
+// subscribed reports whether any hook runs on the event
+func (s *HookService) subscribed(event string) bool {
+	for _, h := range s.hooks {
+		if h.events[event] {
+			return true
+		}
+	}
+	return false
+}
+
+// runHook runs one hook within its timeout
+func (s *HookService) runHook(ctx context.Context, h *hook, event *HookEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	render := func(t *template.Template) (string, error) {
+		var buf strings.Builder
+		err := t.Execute(&buf, event)
+		return buf.String(), err
+	}
+
+	if h.command != nil {
+		args := make([]string, len(h.command))
+		for i, t := range h.command {
+			if args[i], err = render(t); err != nil {
+				return err
+			}
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: %s", err, truncateOutput(output))
+		}
+		return nil
+	}
+
+	url, err := render(h.url)
+	if err != nil {
+		return err
+	}
+	body := payload
+	if h.body != nil {
+		rendered, err := render(h.body)
+		if err != nil {
+			return err
+		}
+		body = []byte(rendered)
+	}
+	method := h.def.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.def.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		output, _ := io.ReadAll(io.LimitReader(resp.Body, hookOutputLimit))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, truncateOutput(output))
+	}
+	return nil
+}
+
+// record keeps a run for Hooks
+func (s *HookService) record(run *HookRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = append(s.recent, run)
+	if len(s.recent) > hookHistorySize {
+		s.recent = s.recent[len(s.recent)-hookHistorySize:]
+	}
+}
+
+// Hooks lists the configured hooks and their recent runs
+func (s *HookService) Hooks() *HooksResponse {
+	resp := &HooksResponse{Hooks: make([]*HookInfo, 0), Recent: make([]*HookRun, 0)}
+	if s == nil {
+		return resp
+	}
+
+	for _, h := range s.hooks {
+		info := &HookInfo{Name: h.name, Events: h.def.Events, Kind: "command", Timeout: h.timeout.String()}
+		if h.url != nil {
+			info.Kind = "http"
+		}
+		for name := range h.def.Headers {
+			info.Headers = append(info.Headers, name)
+		}
+		sort.Strings(info.Headers)
+		resp.Hooks = append(resp.Hooks, info)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.recent) - 1; i >= 0; i-- {
+		resp.Recent = append(resp.Recent, s.recent[i])
+	}
+	resp.Dropped = s.dropped
+	return resp
+}
+
+// truncateOutput trims command output or a response body for an error message
+func truncateOutput(output []byte) string {
+	text := strings.TrimSpace(string(output))
+	if len(text) > hookOutputLimit {
+		text = text[:hookOutputLimit] + "..."
+	}
+	return text
+}
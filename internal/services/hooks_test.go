@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCompileHook(t *testing.T) {
+	for _, def := range []HookDefinition{
+		{Events: []string{HookIPBlocked}},
+		{Events: []string{"ip-unblocked"}, URL: "http://127.0.0.1"},
+		{Events: []string{HookIPBlocked}, URL: "http://127.0.0.1", Command: []string{"true"}},
+		{Events: []string{HookIPBlocked}, Command: []string{"true", "{{.Addr}}"}},
+		{Events: []string{HookIPBlocked}, Command: []string{"true"}, Timeout: "soon"},
+	} {
+		if _, err := compileHook(0, def); err == nil {
+			t.Errorf("Expected %+v to be rejected", def)
+		}
+	}
+}
+
+func TestHookService_Run(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "blocked")
+
+	var body []byte
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	s := NewHookService(&HookConfig{Hooks: []HookDefinition{
+		{Name: "firewall", Events: []string{HookIPBlocked}, Command: []string{"sh", "-c", `echo "$1" > "$2"`, "_", "{{.IP}}", out}},
+		{Name: "notify", Events: []string{HookIPBlocked, HookUserAdded}, URL: server.URL + "/{{.Event}}", Headers: map[string]string{"Authorization": "Bearer x"}},
+	}}, zap.NewNop())
+	defer s.Stop()
+
+	failures := s.Run(context.Background(), &HookEvent{Event: HookIPBlocked, IP: "198.51.100.7", Source: BlockSourcePanel})
+	if len(failures) != 0 {
+		t.Fatalf("Unexpected failures: %v", failures)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil || strings.TrimSpace(string(data)) != "198.51.100.7" {
+		t.Errorf("Expected the command to get the templated IP, got %q (%v)", data, err)
+	}
+	var event HookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.IP != "198.51.100.7" || auth != "Bearer x" {
+		t.Errorf("Expected the event as JSON with the configured header, got %s (auth %q)", body, auth)
+	}
+
+	resp := s.Hooks()
+	if len(resp.Hooks) != 2 || len(resp.Recent) != 2 || resp.Recent[0].Hook != "notify" {
+		t.Errorf("Unexpected hooks listing %+v", resp)
+	}
+
+	// A nil service runs nothing
+	var none *HookService
+	none.Fire(&HookEvent{Event: HookUserAdded})
+	if failures := none.Run(context.Background(), &HookEvent{Event: HookPreStart}); failures != nil {
+		t.Errorf("Expected no failures from a nil service, got %v", failures)
+	}
+}
@@ -0,0 +1,32 @@
+// Package services provides business logic for checking the panel's inbound hashes
+package services
+
+import "fmt"
+
+// VerifyInboundHashes compares the user counts the panel sends with its
+// inbound hashes against the node's own counts, taken from the last extracted
+// config and kept current by user mutations, and returns warnings for each
+// difference. The hash values themselves cannot be checked, as the node does
+// not know the panel's hash function; a count that differs points to drift
+// between the panel's view and the running config.
+func (s *InternalService) VerifyInboundHashes(hashes *InboundHashes) []string {
+	if hashes == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var warnings []string
+	for _, item := range hashes.Inbounds {
+		count, exists := s.configUserCounts[item.Tag]
+		if !exists {
+			warnings = append(warnings, fmt.Sprintf("inbound hashes list %q, which is not in the config", item.Tag))
+			continue
+		}
+		if item.UsersCount > 0 && item.UsersCount != count {
+			warnings = append(warnings, fmt.Sprintf("inbound %q: the panel counts %d user(s), the node has %d", item.Tag, item.UsersCount, count))
+		}
+	}
+	return warnings
+}
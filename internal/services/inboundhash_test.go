@@ -0,0 +1,49 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestVerifyInboundHashes(t *testing.T) {
+	s := NewInternalService(&InternalConfig{}, zap.NewNop())
+	config := []byte(`{"inbounds": [
+		{"tag": "VLESS", "settings": {"clients": [{"id": "` + testUUID1 + `", "email": "alice"}, {"id": "` + testUUID2 + `", "email": "bob"}]}},
+		{"tag": "TROJAN", "settings": {"clients": [{"password": "secret", "email": "carol"}]}}
+	]}`)
+	if err := s.ExtractUsersFromConfig(config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Matching counts validate cleanly, whatever the hash values
+	warnings := s.VerifyInboundHashes(&InboundHashes{Inbounds: []InboundHashItem{
+		{Tag: "VLESS", Hash: "a", UsersCount: 2},
+		{Tag: "TROJAN", Hash: "b", UsersCount: 1},
+	}})
+	if len(warnings) != 0 {
+		t.Errorf("Expected matching counts to validate, got %v", warnings)
+	}
+
+	warnings = s.VerifyInboundHashes(&InboundHashes{Inbounds: []InboundHashItem{
+		{Tag: "VLESS", Hash: "a", UsersCount: 3},
+		{Tag: "SS", Hash: "c", UsersCount: 1},
+	}})
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "counts 3 user(s), the node has 2") || !strings.Contains(warnings[1], `"SS"`) {
+		t.Errorf("Expected a count and an unknown inbound warning, got %v", warnings)
+	}
+
+	// User mutations since the start keep the counts current
+	s.AddUserToInbound("dave", "VLESS")
+	s.AddUserToInbound("dave", "VLESS")
+	warnings = s.VerifyInboundHashes(&InboundHashes{Inbounds: []InboundHashItem{{Tag: "VLESS", Hash: "d", UsersCount: 3}}})
+	if len(warnings) != 0 {
+		t.Errorf("Expected the counts to follow mutations, got %v", warnings)
+	}
+	s.RemoveUserFromInbound("alice", "VLESS")
+	warnings = s.VerifyInboundHashes(&InboundHashes{Inbounds: []InboundHashItem{{Tag: "VLESS", Hash: "f", UsersCount: 2}}})
+	if len(warnings) != 0 {
+		t.Errorf("Expected a removal to lower the count, got %v", warnings)
+	}
+}
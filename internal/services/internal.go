@@ -2,21 +2,30 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
 	"github.com/clash-version/remnawave-node-go/pkg/hashedset"
 )
 
 // InternalService manages internal node state
 type InternalService struct {
-	mu               sync.RWMutex
-	logger           *zap.Logger
-	hashedSet        *hashedset.HashedSet
-	config           json.RawMessage
-	disableHashCheck bool
+	mu            sync.RWMutex
+	logger        *zap.Logger
+	hashedSet     *hashedset.HashedSet
+	hashedSetPath string // Where hashedSet is saved; empty keeps it in memory
+	config        json.RawMessage
+	configVersion uint64            // Incremented whenever config is replaced
+	flags         *featureflags.Set // FlagHashCheck, toggled at runtime
+	hashAlgorithm hashedset.Algorithm
 
 	// User-Inbound tracking: email -> set of inbound tags
 	userInboundMap map[string]map[string]struct{}
@@ -26,22 +35,57 @@ type InternalService struct {
 	emptyConfigHash string
 	// All known inbound tags (used for removing users from all inbounds)
 	xtlsConfigInbounds map[string]struct{}
+	// Users per inbound in the last extracted config, adjusted by mutations
+	// (see VerifyInboundHashes)
+	configUserCounts map[string]int
 }
 
 // InternalConfig holds Internal service configuration
 type InternalConfig struct {
-	DisableHashCheck bool
+	Flags         *featureflags.Set
+	HashAlgorithm hashedset.Algorithm // Defaults to SHA-256
+	HashTTL       time.Duration       // Stored hashes expire after this; 0 keeps them
+	StateDir      string              // Stored hashes are saved here if set
 }
 
 // NewInternalService creates a new InternalService
 func NewInternalService(cfg *InternalConfig, logger *zap.Logger) *InternalService {
-	return &InternalService{
+	algorithm := cfg.HashAlgorithm
+	if algorithm == "" {
+		algorithm = hashedset.AlgorithmSHA256
+	}
+
+	s := &InternalService{
 		logger:             logger,
-		hashedSet:          hashedset.New(),
-		disableHashCheck:   cfg.DisableHashCheck,
+		hashedSet:          hashedset.NewWithAlgorithm(algorithm),
+		hashAlgorithm:      algorithm,
 		userInboundMap:     make(map[string]map[string]struct{}),
 		inboundHashSets:    make(map[string]*hashedset.HashedSet),
 		xtlsConfigInbounds: make(map[string]struct{}),
+		flags:              cfg.Flags,
+	}
+	s.hashedSet.SetDefaultTTL(cfg.HashTTL)
+
+	if cfg.StateDir != "" {
+		s.hashedSetPath = filepath.Join(cfg.StateDir, "hashed-set.json")
+		if err := s.hashedSet.Load(s.hashedSetPath); err != nil {
+			logger.Warn("Failed to load stored hashes", zap.Error(err))
+		}
+	}
+	return s
+}
+
+// saveHashedSet drops expired hashes and saves the rest, so they survive a
+// node restart and stale keys don't pile up
+func (s *InternalService) saveHashedSet() {
+	if removed := s.hashedSet.PurgeExpired(); removed > 0 {
+		s.logger.Debug("Purged expired hashes", zap.Int("removed", removed))
+	}
+	if s.hashedSetPath == "" {
+		return
+	}
+	if err := s.hashedSet.Save(s.hashedSetPath); err != nil {
+		s.logger.Warn("Failed to save stored hashes", zap.Error(err))
 	}
 }
 
@@ -74,7 +118,9 @@ func (s *InternalService) Cleanup() {
 	s.userInboundMap = make(map[string]map[string]struct{})
 	s.inboundHashSets = make(map[string]*hashedset.HashedSet)
 	s.xtlsConfigInbounds = make(map[string]struct{})
+	s.configUserCounts = nil
 	s.config = nil
+	s.configVersion++
 	s.emptyConfigHash = ""
 }
 
@@ -103,15 +149,28 @@ func (s *InternalService) AddUserToInbound(email, tag string) {
 	if s.userInboundMap[email] == nil {
 		s.userInboundMap[email] = make(map[string]struct{})
 	}
+	if _, exists := s.userInboundMap[email][tag]; !exists {
+		s.adjustUserCount(tag, 1)
+	}
 	s.userInboundMap[email][tag] = struct{}{}
 }
 
+// adjustUserCount follows a mutation in the config user counts (mu held)
+func (s *InternalService) adjustUserCount(tag string, delta int) {
+	if count, exists := s.configUserCounts[tag]; exists {
+		s.configUserCounts[tag] = max(count+delta, 0)
+	}
+}
+
 // RemoveUserFromInbound removes a user from an inbound tracking
 func (s *InternalService) RemoveUserFromInbound(email, tag string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if tags, exists := s.userInboundMap[email]; exists {
+		if _, tracked := tags[tag]; tracked {
+			s.adjustUserCount(tag, -1)
+		}
 		delete(tags, tag)
 		// Clean up if no more inbounds
 		if len(tags) == 0 {
@@ -133,6 +192,7 @@ func (s *InternalService) RemoveUserFromAllInbounds(email string) []string {
 	result := make([]string, 0, len(tags))
 	for tag := range tags {
 		result = append(result, tag)
+		s.adjustUserCount(tag, -1)
 	}
 	delete(s.userInboundMap, email)
 	return result
@@ -152,6 +212,59 @@ func (s *InternalService) GetUsersInInbound(tag string) []string {
 	return users
 }
 
+// GetTrackedUsers returns the user-inbound tracking as email -> sorted inbound tags
+func (s *InternalService) GetTrackedUsers() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string][]string, len(s.userInboundMap))
+	for email, tags := range s.userInboundMap {
+		list := make([]string, 0, len(tags))
+		for tag := range tags {
+			list = append(list, tag)
+		}
+		sort.Strings(list)
+		result[email] = list
+	}
+	return result
+}
+
+// ImportHashes replaces the stored inbound hashes and registers their inbounds
+func (s *InternalService) ImportHashes(hashes *InboundHashes) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emptyConfigHash = hashes.EmptyConfig
+	s.inboundHashSets = make(map[string]*hashedset.HashedSet, len(hashes.Inbounds))
+	for _, item := range hashes.Inbounds {
+		hs := hashedset.NewWithAlgorithm(s.hashAlgorithm)
+		if item.Hash != "" {
+			hs.SetHashValue("users", item.Hash)
+		}
+		s.inboundHashSets[item.Tag] = hs
+		s.xtlsConfigInbounds[item.Tag] = struct{}{}
+	}
+}
+
+// ImportTrackedUsers replaces the user-inbound tracking and registers the inbounds
+func (s *InternalService) ImportTrackedUsers(inbounds []string, users map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range inbounds {
+		s.xtlsConfigInbounds[tag] = struct{}{}
+	}
+	s.userInboundMap = make(map[string]map[string]struct{}, len(users))
+	for email, tags := range users {
+		set := make(map[string]struct{}, len(tags))
+		for _, tag := range tags {
+			set[tag] = struct{}{}
+			s.xtlsConfigInbounds[tag] = struct{}{}
+		}
+		s.userInboundMap[email] = set
+	}
+}
+
 // GetUsersCountInInbound returns the count of users in a specific inbound
 func (s *InternalService) GetUsersCountInInbound(tag string) int {
 	s.mu.RLock()
@@ -166,37 +279,31 @@ func (s *InternalService) GetUsersCountInInbound(tag string) int {
 	return count
 }
 
-// XrayInbound represents an inbound configuration
-type XrayInbound struct {
-	Tag      string `json:"tag"`
-	Settings struct {
-		Clients []struct {
-			Email string `json:"email"`
-		} `json:"clients"`
-	} `json:"settings"`
-}
-
-// XrayConfigParsed represents parsed Xray config for user extraction
-type XrayConfigParsed struct {
-	Inbounds []XrayInbound `json:"inbounds"`
-}
-
 // ExtractUsersFromConfig parses config and builds user-inbound mapping
 // Also stores the incoming hashes for later comparison
 func (s *InternalService) ExtractUsersFromConfig(config json.RawMessage, hashes *InboundHashes) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var parsed XrayConfigParsed
-	if err := json.Unmarshal(config, &parsed); err != nil {
+	// Scanned before taking the lock, so user operations aren't held up by a large config
+	inbounds, err := scanConfigInbounds(bytes.NewReader(config))
+	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Clear existing mappings
 	s.userInboundMap = make(map[string]map[string]struct{})
 	s.inboundHashSets = make(map[string]*hashedset.HashedSet)
 	s.xtlsConfigInbounds = make(map[string]struct{})
 
+	// The node's own user counts, to check the panel's against (all inbounds)
+	s.configUserCounts = make(map[string]int, len(inbounds))
+	for _, inbound := range inbounds {
+		if inbound.Tag != "" {
+			s.configUserCounts[inbound.Tag] = inbound.Clients
+		}
+	}
+
 	// Build valid tags set from incoming hashes
 	validTags := make(map[string]string) // tag -> hash
 	if hashes != nil {
@@ -206,7 +313,7 @@ func (s *InternalService) ExtractUsersFromConfig(config json.RawMessage, hashes
 		}
 	}
 
-	for _, inbound := range parsed.Inbounds {
+	for _, inbound := range inbounds {
 		if inbound.Tag == "" {
 			continue
 		}
@@ -221,7 +328,7 @@ func (s *InternalService) ExtractUsersFromConfig(config json.RawMessage, hashes
 		s.xtlsConfigInbounds[inbound.Tag] = struct{}{}
 
 		// Create hash set for this inbound and store the incoming hash
-		hs := hashedset.New()
+		hs := hashedset.NewWithAlgorithm(s.hashAlgorithm)
 		if incomingHash != "" {
 			// Store the incoming hash directly (using "users" as the key)
 			hs.SetHashValue("users", incomingHash)
@@ -229,19 +336,16 @@ func (s *InternalService) ExtractUsersFromConfig(config json.RawMessage, hashes
 		s.inboundHashSets[inbound.Tag] = hs
 
 		// Map users to this inbound
-		for _, client := range inbound.Settings.Clients {
-			if client.Email == "" {
-				continue
+		for _, email := range inbound.Emails {
+			if s.userInboundMap[email] == nil {
+				s.userInboundMap[email] = make(map[string]struct{})
 			}
-			if s.userInboundMap[client.Email] == nil {
-				s.userInboundMap[client.Email] = make(map[string]struct{})
-			}
-			s.userInboundMap[client.Email][inbound.Tag] = struct{}{}
+			s.userInboundMap[email][inbound.Tag] = struct{}{}
 		}
 
 		s.logger.Debug("Extracted inbound",
 			zap.String("tag", inbound.Tag),
-			zap.Int("users", len(inbound.Settings.Clients)),
+			zap.Int("users", inbound.Clients),
 			zap.String("hash", incomingHash))
 	}
 
@@ -281,69 +385,140 @@ func (h *InboundHashes) InboundsCount() int {
 	return len(h.Inbounds)
 }
 
+// Reasons a start restarts the core (RestartReason.Kind)
+const (
+	RestartReasonCoreNotRunning      = "coreNotRunning"
+	RestartReasonHashCheckDisabled   = "hashCheckDisabled"
+	RestartReasonNoHashes            = "noHashes"
+	RestartReasonNoStoredHash        = "noStoredHash"
+	RestartReasonEmptyConfigChanged  = "emptyConfigChanged"
+	RestartReasonInboundCountChanged = "inboundCountChanged"
+	RestartReasonInboundAdded        = "inboundAdded"
+	RestartReasonInboundHashChanged  = "inboundHashChanged"
+	RestartReasonInboundRemoved      = "inboundRemoved"
+)
+
+// RestartReason is one reason for restarting the core, with the stored and
+// incoming values where they apply
+type RestartReason struct {
+	Kind    string `json:"kind"`
+	Tag     string `json:"tag,omitempty"`
+	Current string `json:"current,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// RestartDecision is whether a start with given hashes restarts the core, and why
+type RestartDecision struct {
+	NeedRestart bool            `json:"needRestart"`
+	Reasons     []RestartReason `json:"reasons"`
+
+	// Differences between the user counts sent with the hashes and the node's
+	// own (see VerifyInboundHashes), which may explain unexpected hash changes
+	HashWarnings []string `json:"hashWarnings,omitempty"`
+}
+
 // IsNeedRestartCore checks if core restart is needed by comparing hashes
 func (s *InternalService) IsNeedRestartCore(hashes *InboundHashes) bool {
+	decision := s.ExplainRestart(hashes)
+	for _, reason := range decision.Reasons {
+		switch reason.Kind {
+		case RestartReasonNoStoredHash:
+			s.logger.Debug("No stored config hash, need restart")
+		case RestartReasonEmptyConfigChanged:
+			s.logger.Warn("Detected changes in Xray Core base configuration",
+				zap.String("current", reason.Current),
+				zap.String("new", reason.New))
+		case RestartReasonInboundCountChanged:
+			s.logger.Warn("Number of Xray Core inbounds has changed",
+				zap.String("current", reason.Current),
+				zap.String("new", reason.New))
+		case RestartReasonInboundAdded:
+			s.logger.Warn("New inbound detected", zap.String("tag", reason.Tag))
+		case RestartReasonInboundHashChanged:
+			s.logger.Warn("User configuration changed for inbound",
+				zap.String("tag", reason.Tag),
+				zap.String("current", reason.Current),
+				zap.String("new", reason.New))
+		case RestartReasonInboundRemoved:
+			s.logger.Warn("Inbound no longer exists", zap.String("tag", reason.Tag))
+		}
+	}
+
+	if !decision.NeedRestart {
+		s.logger.Info("Xray Core configuration is up-to-date - no restart required")
+	}
+	return decision.NeedRestart
+}
+
+// ExplainRestart makes the IsNeedRestartCore decision without logging,
+// listing every difference between the stored and incoming hashes rather
+// than stopping at the first
+func (s *InternalService) ExplainRestart(hashes *InboundHashes) *RestartDecision {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.disableHashCheck {
-		return true
+	decision := &RestartDecision{Reasons: []RestartReason{}}
+	restart := func(reason RestartReason) *RestartDecision {
+		decision.NeedRestart = true
+		decision.Reasons = append(decision.Reasons, reason)
+		return decision
+	}
+
+	if !hashCheckEnabled(s.flags) {
+		return restart(RestartReason{Kind: RestartReasonHashCheckDisabled})
+	}
+	if hashes == nil {
+		return restart(RestartReason{Kind: RestartReasonNoHashes})
 	}
 
 	// If no stored hash, need restart
 	if s.emptyConfigHash == "" {
-		s.logger.Debug("No stored config hash, need restart")
-		return true
+		return restart(RestartReason{Kind: RestartReasonNoStoredHash})
 	}
 
 	// Compare empty config hash
 	if s.emptyConfigHash != hashes.EmptyConfig {
-		s.logger.Warn("Detected changes in Xray Core base configuration",
-			zap.String("current", s.emptyConfigHash),
-			zap.String("new", hashes.EmptyConfig))
-		return true
+		restart(RestartReason{Kind: RestartReasonEmptyConfigChanged, Current: s.emptyConfigHash, New: hashes.EmptyConfig})
 	}
 
 	// Compare number of inbounds
 	if len(hashes.Inbounds) != len(s.inboundHashSets) {
-		s.logger.Warn("Number of Xray Core inbounds has changed",
-			zap.Int("current", len(s.inboundHashSets)),
-			zap.Int("new", len(hashes.Inbounds)))
-		return true
+		restart(RestartReason{
+			Kind:    RestartReasonInboundCountChanged,
+			Current: strconv.Itoa(len(s.inboundHashSets)),
+			New:     strconv.Itoa(len(hashes.Inbounds)),
+		})
 	}
 
 	// Compare per-inbound hashes (using array format)
+	incomingTags := make(map[string]struct{}, len(hashes.Inbounds))
 	for _, item := range hashes.Inbounds {
+		incomingTags[item.Tag] = struct{}{}
+
 		hs, exists := s.inboundHashSets[item.Tag]
 		if !exists {
-			s.logger.Warn("New inbound detected", zap.String("tag", item.Tag))
-			return true
+			restart(RestartReason{Kind: RestartReasonInboundAdded, Tag: item.Tag})
+			continue
 		}
 		currentHash, _ := hs.GetHash("users")
 		if currentHash != item.Hash {
-			s.logger.Warn("User configuration changed for inbound",
-				zap.String("tag", item.Tag),
-				zap.String("current", currentHash),
-				zap.String("new", item.Hash))
-			return true
+			restart(RestartReason{Kind: RestartReasonInboundHashChanged, Tag: item.Tag, Current: currentHash, New: item.Hash})
 		}
 	}
 
 	// Check if any existing inbounds were removed
-	// Build a set of incoming tags for quick lookup
-	incomingTags := make(map[string]struct{}, len(hashes.Inbounds))
-	for _, item := range hashes.Inbounds {
-		incomingTags[item.Tag] = struct{}{}
-	}
+	var removed []string
 	for tag := range s.inboundHashSets {
 		if _, exists := incomingTags[tag]; !exists {
-			s.logger.Warn("Inbound no longer exists", zap.String("tag", tag))
-			return true
+			removed = append(removed, tag)
 		}
 	}
+	sort.Strings(removed)
+	for _, tag := range removed {
+		restart(RestartReason{Kind: RestartReasonInboundRemoved, Tag: tag})
+	}
 
-	s.logger.Info("Xray Core configuration is up-to-date - no restart required")
-	return false
+	return decision
 }
 
 // UpdateInboundHash updates the hash for a specific inbound
@@ -353,10 +528,11 @@ func (s *InternalService) UpdateInboundHash(tag string, data json.RawMessage) (b
 
 	hs, exists := s.inboundHashSets[tag]
 	if !exists {
-		hs = hashedset.New()
+		hs = hashedset.NewWithAlgorithm(s.hashAlgorithm)
 		s.inboundHashSets[tag] = hs
 	}
 
+	// Marshaled like every other stored hash, so formatting doesn't count as a change
 	return hs.UpdateIfChanged("users", data)
 }
 
@@ -419,6 +595,17 @@ func (s *InternalService) GetConfig() *GetConfigResponse {
 	}
 }
 
+// ConfigVersion identifies the stored configuration, for caching GetConfig
+// responses: the config hash, plus a counter as the hash is not maintained
+// when hash checks are disabled
+func (s *InternalService) ConfigVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, _ := s.hashedSet.GetHash("config")
+	return hash + "/" + strconv.FormatUint(s.configVersion, 10)
+}
+
 // SetConfigRequest represents a request to store configuration
 type SetConfigRequest struct {
 	Config json.RawMessage `json:"config"`
@@ -438,16 +625,20 @@ func (s *InternalService) SetConfig(req *SetConfigRequest) *SetConfigResponse {
 
 	// Check if config has changed
 	changed := true
-	if !s.disableHashCheck {
+	if hashCheckEnabled(s.flags) {
+		// Hashed like CheckHash hashes its data, as marshaled (compacted) JSON
 		var err error
 		changed, err = s.hashedSet.UpdateIfChanged("config", req.Config)
 		if err != nil {
 			s.logger.Warn("Failed to compute config hash", zap.Error(err))
+		} else if changed {
+			s.saveHashedSet()
 		}
 	}
 
-	if changed || s.disableHashCheck {
+	if changed || !hashCheckEnabled(s.flags) {
 		s.config = req.Config
+		s.configVersion++
 		s.logger.Debug("Config updated", zap.Bool("changed", changed))
 	}
 
@@ -476,7 +667,7 @@ func (s *InternalService) CheckHash(req *CheckHashRequest) (*CheckHashResponse,
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.disableHashCheck {
+	if !hashCheckEnabled(s.flags) {
 		return &CheckHashResponse{Changed: true}, nil
 	}
 
@@ -513,6 +704,9 @@ func (s *InternalService) UpdateHash(req *UpdateHashRequest) (*UpdateHashRespons
 	if err != nil {
 		return nil, err
 	}
+	if updated {
+		s.saveHashedSet()
+	}
 
 	hash, _ := s.hashedSet.GetHash(req.Key)
 	return &UpdateHashResponse{
@@ -526,6 +720,7 @@ func (s *InternalService) ClearHashSet() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.hashedSet.Clear()
+	s.saveHashedSet()
 	s.logger.Info("Cleared hash set")
 }
 
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestInternal_SetConfigAndCheckHashAgree(t *testing.T) {
+	s := NewInternalService(&InternalConfig{}, zap.NewNop())
+	formatted := json.RawMessage("{\n  \"a\": 1,\n  \"b\": [1, 2]\n}")
+
+	if resp := s.SetConfig(&SetConfigRequest{Config: formatted}); !resp.Changed {
+		t.Fatalf("Expected the first config to count as changed, got %+v", resp)
+	}
+	for _, data := range []json.RawMessage{formatted, json.RawMessage(`{"a":1,"b":[1,2]}`)} {
+		resp, err := s.CheckHash(&CheckHashRequest{Key: "config", Data: data})
+		if err != nil || resp.Changed {
+			t.Errorf("Expected %s unchanged after SetConfig, got %+v (%v)", data, resp, err)
+		}
+	}
+	if resp := s.SetConfig(&SetConfigRequest{Config: json.RawMessage(`{"a":1,"b":[1,2]}`)}); resp.Changed {
+		t.Errorf("Expected a reformatted config to be unchanged, got %+v", resp)
+	}
+
+	if _, err := s.UpdateInboundHash("VLESS", formatted); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.UpdateInboundHash("VLESS", json.RawMessage(`{"a":1,"b":[1,2]}`)); err != nil || changed {
+		t.Errorf("Expected reformatted inbound users to be unchanged, got %v (%v)", changed, err)
+	}
+}
+
+func TestInternal_StoredHashesSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := json.RawMessage(`{"a":1}`)
+
+	s := NewInternalService(&InternalConfig{StateDir: dir}, zap.NewNop())
+	s.SetConfig(&SetConfigRequest{Config: config})
+	if _, err := s.UpdateHash(&UpdateHashRequest{Key: "routing", Data: json.RawMessage(`[1]`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewInternalService(&InternalConfig{StateDir: dir}, zap.NewNop())
+	for key, data := range map[string]json.RawMessage{"config": config, "routing": json.RawMessage(`[1]`)} {
+		resp, err := restarted.CheckHash(&CheckHashRequest{Key: key, Data: data})
+		if err != nil || resp.Changed {
+			t.Errorf("Expected the %s hash to be restored, got %+v (%v)", key, resp, err)
+		}
+	}
+
+	restarted.ClearHashSet()
+	cleared := NewInternalService(&InternalConfig{StateDir: dir}, zap.NewNop())
+	if resp, _ := cleared.CheckHash(&CheckHashRequest{Key: "config", Data: config}); !resp.Changed {
+		t.Error("Expected a cleared hash set to stay cleared after a restart")
+	}
+}
+
+func TestInternal_StoredHashesExpire(t *testing.T) {
+	dir := t.TempDir()
+	s := NewInternalService(&InternalConfig{StateDir: dir, HashTTL: 50 * time.Millisecond}, zap.NewNop())
+	if _, err := s.UpdateHash(&UpdateHashRequest{Key: "stale", Data: json.RawMessage(`1`)}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := s.CheckHash(&CheckHashRequest{Key: "stale", Data: json.RawMessage(`1`)}); resp.Changed {
+		t.Fatal("Expected a fresh hash to match")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if resp, _ := s.CheckHash(&CheckHashRequest{Key: "stale", Data: json.RawMessage(`1`)}); !resp.Changed {
+		t.Error("Expected an expired hash to count as changed")
+	}
+
+	// The next save purges it, also from disk
+	if _, err := s.UpdateHash(&UpdateHashRequest{Key: "fresh", Data: json.RawMessage(`2`)}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.hashedSet.Keys(); len(keys) != 1 || keys[0] != "fresh" {
+		t.Errorf("Expected only the fresh hash left, got %v", keys)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "hashed-set.json"))
+	if err != nil || strings.Contains(string(data), "stale") {
+		t.Errorf("Expected the expired hash purged from disk, got %s (%v)", data, err)
+	}
+}
@@ -0,0 +1,257 @@
+// Package services provides business logic for the pending-mutation journal
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// mutationJournalLimit is how many mutations are kept while the core is down.
+// Once it is full, mutations fail with "Xray not running" as without the
+// journal and the panel has to retry them.
+const mutationJournalLimit = 500
+
+// Journaled mutation kinds
+const (
+	MutationAddUser     = "addUser"
+	MutationAddUsers    = "addUsers"
+	MutationRemoveUser  = "removeUser"
+	MutationRemoveUsers = "removeUsers"
+)
+
+// JournaledMutation is a user mutation received while the core was down
+type JournaledMutation struct {
+	Kind     string    `json:"kind"`
+	Received time.Time `json:"received"`
+	Users    []string  `json:"users"`
+
+	// replay applies the mutation, reporting whether it was journaled again
+	// and its error
+	replay func(ctx context.Context) (queued bool, errMsg *string)
+}
+
+// JournalReplay summarizes the last replay of the journal
+type JournalReplay struct {
+	Time     time.Time `json:"time"`
+	Replayed int       `json:"replayed"`
+	Failed   int       `json:"failed"`
+	Requeued int       `json:"requeued"` // Found the core down again
+	Dropped  int       `json:"dropped"`  // Superseded by a panel config during the replay
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+// MutationJournal is the journal's pending mutations and last replay
+type MutationJournal struct {
+	Enabled    bool                 `json:"enabled"`
+	Pending    []*JournaledMutation `json:"pending"`
+	LastReplay *JournalReplay       `json:"lastReplay"`
+	Discarded  int                  `json:"discarded"` // Dropped unreplayed because a panel config superseded them
+}
+
+// mutationJournal holds mutations in the order they were received
+type mutationJournal struct {
+	mu         sync.Mutex
+	pending    []*JournaledMutation
+	lastReplay *JournalReplay
+	discarded  int
+	superseded time.Time  // Mutations received before this are not replayed
+	replaying  sync.Mutex // One replay at a time, so mutations keep their order
+}
+
+// journalMutation records a mutation received while the core is down, to be
+// replayed once it is back, and reports whether it was recorded. Nothing is
+// recorded while the feature flag is off or the journal is full.
+func (s *HandlerService) journalMutation(kind string, users []string, replay func(ctx context.Context) (bool, *string)) bool {
+	if !s.flags.Enabled(FlagMutationJournal) {
+		return false
+	}
+
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+
+	if len(s.journal.pending) >= mutationJournalLimit {
+		s.logger.Warn("Mutation journal is full, rejecting mutation",
+			zap.String("kind", kind),
+			zap.Int("pending", len(s.journal.pending)))
+		return false
+	}
+	s.journal.pending = append(s.journal.pending, &JournaledMutation{
+		Kind:     kind,
+		Received: time.Now(),
+		Users:    users,
+		replay:   replay,
+	})
+	s.logger.Info("Core is down, mutation journaled",
+		zap.String("kind", kind),
+		zap.Int("pending", len(s.journal.pending)))
+	return true
+}
+
+// ReplayJournal applies the journaled mutations in the order they were
+// received. A mutation that finds the core down again is journaled anew,
+// after the ones still pending.
+func (s *HandlerService) ReplayJournal(ctx context.Context) {
+	s.journal.replaying.Lock()
+	defer s.journal.replaying.Unlock()
+
+	s.journal.mu.Lock()
+	pending := s.journal.pending
+	s.journal.pending = nil
+	s.journal.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	result := &JournalReplay{Time: time.Now()}
+	for _, mutation := range pending {
+		if s.journalSuperseded(mutation) {
+			result.Dropped++
+			continue
+		}
+		queued, errMsg := mutation.replay(ctx)
+		switch {
+		case queued:
+			result.Requeued++
+		case errMsg != nil:
+			result.Failed++
+			result.Errors = append(result.Errors, mutation.Kind+": "+*errMsg)
+		default:
+			result.Replayed++
+		}
+	}
+
+	s.journal.mu.Lock()
+	s.journal.lastReplay = result
+	s.journal.mu.Unlock()
+
+	s.logger.Info("Mutation journal replayed",
+		zap.Int("replayed", result.Replayed),
+		zap.Int("failed", result.Failed),
+		zap.Int("requeued", result.Requeued),
+		zap.Int("dropped", result.Dropped))
+}
+
+// DiscardJournal drops the pending mutations when a config from the panel is
+// applied. The config reflects the panel's state after those mutations, so
+// replaying them could re-add a user the panel has since deleted, or undo a
+// later re-add. It is called with user mutations held back, so every pending
+// mutation was received before the config.
+func (s *HandlerService) DiscardJournal() {
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+
+	s.journal.superseded = time.Now()
+	if len(s.journal.pending) == 0 {
+		return
+	}
+	s.logger.Info("Panel config applied, discarding journaled mutations",
+		zap.Int("pending", len(s.journal.pending)))
+	s.journal.discarded += len(s.journal.pending)
+	s.journal.pending = nil
+}
+
+// journalSuperseded reports whether a panel config was applied after the
+// mutation was received, while a replay was already under way
+func (s *HandlerService) journalSuperseded(mutation *JournaledMutation) bool {
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+	return !mutation.Received.After(s.journal.superseded)
+}
+
+// Journal returns the pending mutations and the last replay
+func (s *HandlerService) Journal() *MutationJournal {
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+
+	return &MutationJournal{
+		Enabled:    s.flags.Enabled(FlagMutationJournal),
+		Pending:    append([]*JournaledMutation{}, s.journal.pending...),
+		LastReplay: s.journal.lastReplay,
+		Discarded:  s.journal.discarded,
+	}
+}
+
+// replayOutcome converts the result of a replayed mutation
+func replayOutcome(queued bool, errMsg *string, err error) (bool, *string) {
+	if err != nil {
+		msg := err.Error()
+		return false, &msg
+	}
+	return queued, errMsg
+}
+
+// journalAddUser journals a valid AddUser request, see journalMutation
+func (s *HandlerService) journalAddUser(req *AddUserRequest) bool {
+	if len(req.Data) == 0 || validateAddUser(req, s.shadowsocksMethods()) != nil {
+		return false
+	}
+
+	users := make([]string, 0, 1)
+	seen := make(map[string]struct{})
+	for _, item := range req.Data {
+		if _, exists := seen[item.Username]; !exists {
+			seen[item.Username] = struct{}{}
+			users = append(users, item.Username)
+		}
+	}
+	return s.journalMutation(MutationAddUser, users, func(ctx context.Context) (bool, *string) {
+		resp, err := s.AddUser(ctx, req)
+		if err != nil {
+			return replayOutcome(false, nil, err)
+		}
+		return replayOutcome(resp.Queued, resp.Error, nil)
+	})
+}
+
+// journalAddUsers journals a valid AddUsers request, see journalMutation
+func (s *HandlerService) journalAddUsers(req *AddUsersRequest) bool {
+	if req.DryRun || validateAddUsers(req, s.shadowsocksMethods()) != nil {
+		return false
+	}
+
+	users := make([]string, 0, len(req.Users))
+	for _, user := range req.Users {
+		users = append(users, user.UserData.UserId)
+	}
+	return s.journalMutation(MutationAddUsers, users, func(ctx context.Context) (bool, *string) {
+		resp, err := s.AddUsers(ctx, req)
+		if err != nil {
+			return replayOutcome(false, nil, err)
+		}
+		return replayOutcome(resp.Queued, resp.Error, nil)
+	})
+}
+
+// journalRemoveUser journals a RemoveUser request, see journalMutation
+func (s *HandlerService) journalRemoveUser(req *RemoveUserRequest) bool {
+	return s.journalMutation(MutationRemoveUser, []string{req.Username}, func(ctx context.Context) (bool, *string) {
+		resp, err := s.RemoveUser(ctx, req)
+		if err != nil {
+			return replayOutcome(false, nil, err)
+		}
+		return replayOutcome(resp.Queued, resp.Error, nil)
+	})
+}
+
+// journalRemoveUsers journals a RemoveUsers request, see journalMutation
+func (s *HandlerService) journalRemoveUsers(req *RemoveUsersRequest) bool {
+	if req.DryRun {
+		return false
+	}
+
+	users := make([]string, 0, len(req.Users))
+	for _, user := range req.Users {
+		users = append(users, user.UserId)
+	}
+	return s.journalMutation(MutationRemoveUsers, users, func(ctx context.Context) (bool, *string) {
+		resp, err := s.RemoveUsers(ctx, req)
+		if err != nil {
+			return replayOutcome(false, nil, err)
+		}
+		return replayOutcome(resp.Queued, resp.Error, nil)
+	})
+}
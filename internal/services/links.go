@@ -0,0 +1,238 @@
+// Package services provides business logic for client link generation
+package services
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/xtls/xray-core/proxy/shadowsocks"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// LinkService builds client share links from the running config, for
+// node-level connectivity debugging without the panel
+type LinkService struct {
+	logger    *zap.Logger
+	xrayCore  *xraycore.Instance
+	usernames *UsernameNormalizer // Applied to looked up usernames
+}
+
+// NewLinkService creates a new LinkService
+func NewLinkService(xrayCore *xraycore.Instance, usernames *UsernameNormalizer, logger *zap.Logger) *LinkService {
+	return &LinkService{
+		logger:    logger,
+		xrayCore:  xrayCore,
+		usernames: usernames,
+	}
+}
+
+// GetUserLinksRequest represents a request for a user's client links
+type GetUserLinksRequest struct {
+	Username string `json:"username" binding:"required"`
+	Host     string `json:"host"` // Address clients connect to; defaults to the API host
+}
+
+// UserLink is a client link for one inbound
+type UserLink struct {
+	Inbound  string `json:"inbound"`
+	Protocol string `json:"protocol"`
+	Link     string `json:"link"`
+}
+
+// GetUserLinksResponse represents a user's client links
+type GetUserLinksResponse struct {
+	Links []*UserLink `json:"links"`
+}
+
+// linkInbound is the subset of an inbound needed to build links
+type linkInbound struct {
+	Tag            string                 `json:"tag"`
+	Protocol       string                 `json:"protocol"`
+	Port           interface{}            `json:"port"`
+	StreamSettings map[string]interface{} `json:"streamSettings"`
+}
+
+// GetUserLinks returns vless://, trojan:// and ss:// links for every inbound
+// of the running core the user is in, using the credentials held by the core
+func (s *LinkService) GetUserLinks(ctx context.Context, req *GetUserLinksRequest) (*GetUserLinksResponse, error) {
+	links := make([]*UserLink, 0)
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return &GetUserLinksResponse{Links: links}, nil
+	}
+	if req.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+
+	var config struct {
+		Inbounds []linkInbound `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	for _, inbound := range config.Inbounds {
+		if inbound.Tag == "" {
+			continue
+		}
+		switch inbound.Protocol {
+		case "vless", "trojan", "shadowsocks":
+		default:
+			continue
+		}
+
+		user, err := s.xrayCore.GetInboundUser(ctx, inbound.Tag, s.usernames.Normalize(req.Username))
+		if err != nil || user == nil {
+			continue
+		}
+
+		// Share links carry one port; port-hopping inbounds use the first of their range
+		port := fmt.Sprint(inbound.Port)
+		if ranges, err := parsePortSpec(inbound.Port); err == nil && len(ranges) > 0 {
+			port = strconv.Itoa(ranges[0].From)
+		}
+		address := net.JoinHostPort(req.Host, port)
+		var link string
+		switch account := user.Account.(type) {
+		case *vless.MemoryAccount:
+			query := streamQuery(inbound.StreamSettings)
+			query.Set("encryption", "none")
+			if account.Flow != "" {
+				query.Set("flow", account.Flow)
+			}
+			link = fmt.Sprintf("vless://%s@%s?%s#%s", account.ID.String(), address, query.Encode(), url.PathEscape(inbound.Tag))
+		case *trojan.MemoryAccount:
+			query := streamQuery(inbound.StreamSettings)
+			link = fmt.Sprintf("trojan://%s@%s?%s#%s", url.PathEscape(account.Password), address, query.Encode(), url.PathEscape(inbound.Tag))
+		case *shadowsocks.MemoryAccount:
+			userInfo := base64.RawURLEncoding.EncodeToString([]byte(cipherTypeToMethod(CipherType(account.CipherType)) + ":" + account.Password))
+			link = fmt.Sprintf("ss://%s@%s#%s", userInfo, address, url.PathEscape(inbound.Tag))
+		default:
+			continue
+		}
+
+		links = append(links, &UserLink{
+			Inbound:  inbound.Tag,
+			Protocol: inbound.Protocol,
+			Link:     link,
+		})
+	}
+
+	return &GetUserLinksResponse{Links: links}, nil
+}
+
+// streamQuery converts streamSettings into share-link query parameters
+func streamQuery(stream map[string]interface{}) url.Values {
+	query := url.Values{}
+
+	network, _ := stream["network"].(string)
+	if network == "" || network == "raw" {
+		network = "tcp"
+	}
+	query.Set("type", network)
+
+	security, _ := stream["security"].(string)
+	if security == "" {
+		security = "none"
+	}
+	query.Set("security", security)
+
+	switch security {
+	case "tls":
+		tls, _ := stream["tlsSettings"].(map[string]interface{})
+		if sni, _ := tls["serverName"].(string); sni != "" {
+			query.Set("sni", sni)
+		}
+		if alpn, ok := tls["alpn"].([]interface{}); ok && len(alpn) > 0 {
+			values := make([]string, 0, len(alpn))
+			for _, a := range alpn {
+				if v, ok := a.(string); ok {
+					values = append(values, v)
+				}
+			}
+			query.Set("alpn", strings.Join(values, ","))
+		}
+		query.Set("fp", "chrome")
+	case "reality":
+		reality, _ := stream["realitySettings"].(map[string]interface{})
+		if names, ok := reality["serverNames"].([]interface{}); ok && len(names) > 0 {
+			if sni, ok := names[0].(string); ok {
+				query.Set("sni", sni)
+			}
+		}
+		if ids, ok := reality["shortIds"].([]interface{}); ok && len(ids) > 0 {
+			if sid, ok := ids[0].(string); ok {
+				query.Set("sid", sid)
+			}
+		}
+		if privateKey, _ := reality["privateKey"].(string); privateKey != "" {
+			if pbk, err := realityPublicKey(privateKey); err == nil {
+				query.Set("pbk", pbk)
+			}
+		}
+		query.Set("fp", "chrome")
+	}
+
+	// Transport-specific parameters
+	settingsKey := map[string]string{
+		"ws":          "wsSettings",
+		"grpc":        "grpcSettings",
+		"httpupgrade": "httpupgradeSettings",
+		"xhttp":       "xhttpSettings",
+		"splithttp":   "splithttpSettings",
+	}[network]
+	if settingsKey != "" {
+		settings, _ := stream[settingsKey].(map[string]interface{})
+		if path, _ := settings["path"].(string); path != "" {
+			query.Set("path", path)
+		}
+		if host, _ := settings["host"].(string); host != "" {
+			query.Set("host", host)
+		} else if headers, ok := settings["headers"].(map[string]interface{}); ok {
+			if host, _ := headers["Host"].(string); host != "" {
+				query.Set("host", host)
+			}
+		}
+		if serviceName, _ := settings["serviceName"].(string); serviceName != "" {
+			query.Set("serviceName", serviceName)
+		}
+		if mode, _ := settings["mode"].(string); mode != "" {
+			query.Set("mode", mode)
+		}
+	}
+
+	return query
+}
+
+// realityPublicKey derives the REALITY public key from the server private key
+func realityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// DefaultLinkHost returns the host part of the API request host, used when
+// no explicit host is requested
+func DefaultLinkHost(requestHost string) string {
+	if host, _, err := net.SplitHostPort(requestHost); err == nil {
+		return host
+	}
+	return requestHost
+}
@@ -0,0 +1,87 @@
+// Package services provides lint checks for incoming Xray configurations
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// reservedAPITag is the default tag reserved for the Xray API (matches Node.js)
+const reservedAPITag = "REMNAWAVE_API"
+
+// lintXrayConfig inspects the panel-provided Xray config for common
+// misconfigurations and returns human-readable warnings. It never modifies
+// the config and never blocks the start.
+func lintXrayConfig(doc *configDocument, blockTag, apiTag string) []string {
+	var warnings []string
+
+	// Duplicate inbound tags and users with empty emails
+	seenInbounds := make(map[string]struct{}, len(doc.inbounds))
+	for i, inbound := range doc.inbounds {
+		if inbound == nil {
+			continue
+		}
+
+		tag := doc.inboundTag(i)
+		if tag == "" {
+			warnings = append(warnings, fmt.Sprintf("inbound #%d has no tag, users cannot be managed on it", i))
+		} else if _, exists := seenInbounds[tag]; exists {
+			warnings = append(warnings, fmt.Sprintf("duplicate inbound tag %q", tag))
+		} else {
+			seenInbounds[tag] = struct{}{}
+		}
+
+		if tag == apiTag {
+			warnings = append(warnings, fmt.Sprintf("inbound tag %q collides with the reserved API tag", tag))
+		}
+
+		// Only the emails are decoded, not the whole client list
+		var settings struct {
+			Clients []struct {
+				Email string `json:"email"`
+			} `json:"clients"`
+		}
+		doc.inboundField(i, "settings", &settings)
+		emptyEmails := 0
+		for _, client := range settings.Clients {
+			if client.Email == "" {
+				emptyEmails++
+			}
+		}
+		if emptyEmails > 0 {
+			warnings = append(warnings, fmt.Sprintf("inbound %q has %d user(s) with empty email, stats and removal will not work for them", tag, emptyEmails))
+		}
+	}
+
+	// Block outbound used by Vision and API tag collisions on outbounds
+	var outbounds []json.RawMessage
+	doc.decodeSection("outbounds", &outbounds)
+	hasBlockOutbound := false
+	for _, raw := range outbounds {
+		var outbound struct {
+			Tag string `json:"tag"`
+		}
+		if err := json.Unmarshal(raw, &outbound); err != nil {
+			continue
+		}
+		if outbound.Tag == blockTag {
+			hasBlockOutbound = true
+		}
+		if outbound.Tag == apiTag {
+			warnings = append(warnings, fmt.Sprintf("outbound tag %q collides with the reserved API tag", outbound.Tag))
+		}
+	}
+	if blockTag != "" && !hasBlockOutbound {
+		warnings = append(warnings, fmt.Sprintf("no outbound with tag %q found, IP blocking will not work", blockTag))
+	}
+
+	// User-supplied api section using the reserved tag
+	var api struct {
+		Tag string `json:"tag"`
+	}
+	if doc.decodeSection("api", &api) && api.Tag == apiTag {
+		warnings = append(warnings, fmt.Sprintf("api section uses the reserved tag %q", api.Tag))
+	}
+
+	return warnings
+}
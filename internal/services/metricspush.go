@@ -0,0 +1,303 @@
+// Package services provides business logic for pushing traffic metrics
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+const (
+	// metricsPushBatchSize is the maximum number of lines sent per write request
+	metricsPushBatchSize = 5000
+	// metricsPushMaxPending bounds buffered lines while the endpoint is unreachable;
+	// the oldest samples are dropped beyond it
+	metricsPushMaxPending = 100000
+	// metricsPushAttempts is how many times a batch is sent before it is kept for the next interval
+	metricsPushAttempts = 3
+)
+
+// MetricsPushService periodically writes per-user and per-inbound traffic
+// samples to InfluxDB or VictoriaMetrics using the InfluxDB line protocol.
+// Samples are the traffic accrued during each interval, computed from the
+// cumulative counters, which panel collection neither disturbs nor lowers.
+type MetricsPushService struct {
+	mu         sync.Mutex
+	logger     *zap.Logger
+	xrayCore   *xraycore.Instance
+	health     *HealthManager // Optional; current core health is pushed with each sample
+	url        string
+	token      string
+	interval   time.Duration
+	tags       string // Escaped node identity tags, e.g. "node=a,region=eu"
+	httpClient *http.Client
+
+	previous map[string]int64 // counter name -> cumulative value at the previous sample
+	pending  []string         // lines not yet accepted by the endpoint
+	stop     chan struct{}
+}
+
+// MetricsPushConfig holds MetricsPush service configuration
+type MetricsPushConfig struct {
+	URL      string // Write endpoint, e.g. InfluxDB /api/v2/write?org=..&bucket=.. or VictoriaMetrics /write; empty disables
+	Token    string // Sent as "Authorization: Token <token>" when set
+	Interval time.Duration
+}
+
+// NewMetricsPushService creates a new MetricsPushService
+func NewMetricsPushService(cfg *MetricsPushConfig, xrayCore *xraycore.Instance, health *HealthManager, logger *zap.Logger) *MetricsPushService {
+	return &MetricsPushService{
+		logger:     logger,
+		xrayCore:   xrayCore,
+		health:     health,
+		url:        cfg.URL,
+		token:      cfg.Token,
+		interval:   cfg.Interval,
+		tags:       identityLineTags(CurrentNodeIdentity()),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		previous:   make(map[string]int64),
+	}
+}
+
+// Start begins pushing samples in the background, if configured
+func (s *MetricsPushService) Start() {
+	if s.url == "" || s.interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.collect()
+				s.flush(stop)
+			}
+		}
+	}(s.stop)
+
+	s.logger.Info("Metrics push started", zap.Duration("interval", s.interval))
+}
+
+// Stop stops pushing samples
+func (s *MetricsPushService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// collect reads the traffic counters and queues one line per user and inbound,
+// plus the core health
+func (s *MetricsPushService) collect() {
+	if s.health != nil {
+		state := s.health.State()
+		online := 0
+		if state.State != HealthDown {
+			online = 1
+		}
+		s.mu.Lock()
+		s.pending = append(s.pending, fmt.Sprintf("remnawave_node_health,%s state=%q,online=%di %d",
+			s.tags, state.State, online, time.Now().UnixNano()))
+		s.mu.Unlock()
+	}
+
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return
+	}
+
+	counters, err := s.xrayCore.GetCumulativeStats(context.Background(), "")
+	if err != nil {
+		s.logger.Warn("Failed to read counters for metrics push", zap.Error(err))
+		return
+	}
+
+	type sample struct{ uplink, downlink int64 }
+	users := make(map[string]*sample)
+	inbounds := make(map[string]*sample)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, value := range counters {
+		// <kind>>>><name>>>>traffic>>><direction>
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 4 || parts[2] != "traffic" {
+			continue
+		}
+
+		var target map[string]*sample
+		switch parts[0] {
+		case "user":
+			target = users
+		case "inbound":
+			target = inbounds
+		default:
+			continue
+		}
+
+		// A cumulative counter only drops when the core restarted
+		delta := value
+		if last, exists := s.previous[name]; exists && value >= last {
+			delta = value - last
+		}
+		s.previous[name] = value
+
+		entry, exists := target[parts[1]]
+		if !exists {
+			entry = &sample{}
+			target[parts[1]] = entry
+		}
+		if parts[3] == "uplink" {
+			entry.uplink += delta
+		} else {
+			entry.downlink += delta
+		}
+	}
+
+	// Counters of removed users and inbounds are forgotten
+	for name := range s.previous {
+		if _, exists := counters[name]; !exists {
+			delete(s.previous, name)
+		}
+	}
+
+	timestamp := time.Now().UnixNano()
+	addLines := func(measurement, tag string, samples map[string]*sample) {
+		keys := make([]string, 0, len(samples))
+		for key, entry := range samples {
+			if entry.uplink == 0 && entry.downlink == 0 {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := samples[key]
+			s.pending = append(s.pending, fmt.Sprintf("%s,%s,%s=%s uplink=%di,downlink=%di %d",
+				measurement, s.tags, tag, escapeLineTag(key), entry.uplink, entry.downlink, timestamp))
+		}
+	}
+	addLines("remnawave_user_traffic", "user", users)
+	addLines("remnawave_inbound_traffic", "inbound", inbounds)
+
+	if dropped := len(s.pending) - metricsPushMaxPending; dropped > 0 {
+		s.pending = s.pending[dropped:]
+		s.logger.Warn("Metrics push buffer full, dropped oldest samples", zap.Int("dropped", dropped))
+	}
+}
+
+// flush sends pending lines in batches. A batch that still fails after
+// retries is kept, with everything after it, for the next interval.
+func (s *MetricsPushService) flush(stop chan struct{}) {
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		if n > metricsPushBatchSize {
+			n = metricsPushBatchSize
+		}
+		batch := append([]string(nil), s.pending[:n]...)
+		s.mu.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+
+		var err error
+		for attempt := 0; attempt < metricsPushAttempts; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Duration(attempt) * time.Second):
+				}
+			}
+			if err = s.write(batch); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.logger.Warn("Metrics push failed, will retry next interval",
+				zap.Int("pending", len(batch)),
+				zap.Error(err))
+			return
+		}
+
+		s.mu.Lock()
+		s.pending = s.pending[len(batch):]
+		s.mu.Unlock()
+	}
+}
+
+// write sends one batch of lines to the write endpoint
+func (s *MetricsPushService) write(lines []string) error {
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("write endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// identityLineTags renders the node identity as line protocol tags. The node
+// tag is the configured name or the hostname; labels become label_<key> tags.
+func identityLineTags(identity NodeIdentity) string {
+	node := identity.Name
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+
+	tags := []string{"node=" + escapeLineTag(node)}
+	if identity.Region != "" {
+		tags = append(tags, "region="+escapeLineTag(identity.Region))
+	}
+	if identity.Provider != "" {
+		tags = append(tags, "provider="+escapeLineTag(identity.Provider))
+	}
+	keys := make([]string, 0, len(identity.Labels))
+	for key := range identity.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if identity.Labels[key] == "" {
+			continue // Empty tag values are invalid in the line protocol
+		}
+		tags = append(tags, "label_"+escapeLineTag(key)+"="+escapeLineTag(identity.Labels[key]))
+	}
+	return strings.Join(tags, ",")
+}
+
+// escapeLineTag escapes a tag key or value for the InfluxDB line protocol
+func escapeLineTag(value string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(value)
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestMetricsPush_SamplesTrafficAcrossPanelResets(t *testing.T) {
+	core, send := startTrafficCore(t)
+	s := NewMetricsPushService(&MetricsPushConfig{}, core, nil, zap.NewNop())
+
+	send(1000)
+	s.collect()
+
+	// The panel resets the counters, then the same amount flows again
+	if _, err := core.GetStats(context.Background(), "inbound>>>", true); err != nil {
+		t.Fatal(err)
+	}
+	send(1000)
+	s.collect()
+
+	var samples []string
+	for _, line := range s.pending {
+		if strings.HasPrefix(line, "remnawave_inbound_traffic,") {
+			samples = append(samples, line)
+		}
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Expected one inbound sample per interval, got %q", samples)
+	}
+	for _, line := range samples {
+		if !strings.Contains(line, "inbound=relay uplink=1000i,downlink=1000i") {
+			t.Errorf("Expected 1000 bytes each way in every interval, got %q", line)
+		}
+	}
+}
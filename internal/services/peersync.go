@@ -0,0 +1,281 @@
+// Package services provides business logic for sharing blocked IPs across the fleet
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/crypto"
+)
+
+const (
+	// peerSyncPath is where peers post their blocklists
+	peerSyncPath = "/peer/blocklist"
+	// peerSyncMaxSkew bounds accepted message age, limiting replays
+	peerSyncMaxSkew = 5 * time.Minute
+	// peerSyncMaxBody bounds the size of a posted blocklist
+	peerSyncMaxBody = 4 << 20
+
+	peerSyncTimestampHeader = "X-Peer-Timestamp"
+	peerSyncSignatureHeader = "X-Peer-Signature"
+)
+
+// PeerBlocklist is the signed document nodes exchange
+type PeerBlocklist struct {
+	Node   string    `json:"node"` // Sender name, unique within the fleet
+	IPs    []string  `json:"ips"`  // All IPs blocked locally on the sender
+	SentAt time.Time `json:"sentAt"`
+}
+
+// PeerSyncService shares Vision blocklists between nodes. Each node posts the
+// IPs it blocked locally to every peer on an interval; received lists are
+// applied as peer blocks and lifted once no peer reports them anymore.
+// Messages are signed with a shared secret.
+type PeerSyncService struct {
+	mu         sync.Mutex
+	logger     *zap.Logger
+	vision     *VisionService
+	node       string
+	listen     string
+	peers      []string
+	secret     []byte
+	interval   time.Duration
+	httpClient *http.Client
+	server     *http.Server
+
+	learned map[string]map[string]struct{} // peer node -> IPs it reported
+	stop    chan struct{}
+}
+
+// PeerSyncConfig holds PeerSync service configuration
+type PeerSyncConfig struct {
+	Listen   string   // Address of the peer listener, e.g. ":3100"; empty only sends
+	Peers    []string // Base URLs of peer listeners, e.g. "http://10.0.0.2:3100"
+	Secret   string   // Shared signing secret; empty disables peer sync
+	Interval time.Duration
+}
+
+// NewPeerSyncService creates a new PeerSyncService
+func NewPeerSyncService(cfg *PeerSyncConfig, vision *VisionService, logger *zap.Logger) *PeerSyncService {
+	node := CurrentNodeIdentity().Name
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+
+	return &PeerSyncService{
+		logger:     logger,
+		vision:     vision,
+		node:       node,
+		listen:     cfg.Listen,
+		peers:      cfg.Peers,
+		secret:     []byte(cfg.Secret),
+		interval:   cfg.Interval,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		learned:    make(map[string]map[string]struct{}),
+	}
+}
+
+// Enabled reports whether peer sync is configured
+func (s *PeerSyncService) Enabled() bool {
+	return len(s.secret) > 0 && (s.listen != "" || len(s.peers) > 0)
+}
+
+// Start starts the peer listener and the send loop, if configured
+func (s *PeerSyncService) Start() error {
+	if !s.Enabled() || s.stop != nil {
+		return nil
+	}
+
+	if s.listen != "" {
+		ln, err := net.Listen("tcp", s.listen)
+		if err != nil {
+			return fmt.Errorf("peer sync listener: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(peerSyncPath, s.handleBlocklist)
+		s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("Peer sync listener failed", zap.Error(err))
+			}
+		}()
+	}
+
+	s.stop = make(chan struct{})
+	if len(s.peers) > 0 && s.interval > 0 {
+		go func(stop chan struct{}) {
+			ticker := time.NewTicker(s.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					s.broadcast()
+				}
+			}
+		}(s.stop)
+	}
+
+	s.logger.Info("Peer sync started",
+		zap.String("node", s.node),
+		zap.String("listen", s.listen),
+		zap.Int("peers", len(s.peers)))
+	return nil
+}
+
+// Stop stops the send loop and the peer listener
+func (s *PeerSyncService) Stop(ctx context.Context) {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	if s.server != nil {
+		_ = s.server.Shutdown(ctx)
+		s.server = nil
+	}
+}
+
+// broadcast sends the local blocklist to every peer
+func (s *PeerSyncService) broadcast() {
+	ips := s.vision.LocalBlockedIPs()
+	sort.Strings(ips)
+	body, err := json.Marshal(&PeerBlocklist{Node: s.node, IPs: ips, SentAt: time.Now().UTC()})
+	if err != nil {
+		return
+	}
+
+	for _, peer := range s.peers {
+		if err := s.send(peer, body); err != nil {
+			s.logger.Warn("Failed to send blocklist to peer", zap.String("peer", peer), zap.Error(err))
+		}
+	}
+}
+
+// send posts a signed blocklist to one peer
+func (s *PeerSyncService) send(peer string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, peer+peerSyncPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(peerSyncTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(peerSyncSignatureHeader, crypto.SignMessage(s.secret, ts, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleBlocklist verifies and applies a blocklist posted by a peer
+func (s *PeerSyncService) handleBlocklist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, peerSyncMaxBody))
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	err = crypto.VerifyMessage(s.secret, r.Header.Get(peerSyncTimestampHeader), r.Header.Get(peerSyncSignatureHeader),
+		body, peerSyncMaxSkew, time.Now())
+	if err != nil {
+		s.logger.Warn("Rejected peer blocklist", zap.String("remote", r.RemoteAddr), zap.Error(err))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var list PeerBlocklist
+	if err := json.Unmarshal(body, &list); err != nil || list.Node == "" {
+		http.Error(w, "invalid blocklist", http.StatusBadRequest)
+		return
+	}
+	if list.Node == s.node {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.apply(r.Context(), &list)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apply replaces what a peer reported: new IPs are blocked, and IPs no peer
+// reports anymore are unblocked unless they were blocked locally
+func (s *PeerSyncService) apply(ctx context.Context, list *PeerBlocklist) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]struct{}, len(list.IPs))
+	for _, ip := range list.IPs {
+		if _, err := netip.ParseAddr(ip); err != nil {
+			if _, err := netip.ParsePrefix(ip); err != nil {
+				continue
+			}
+		}
+		current[ip] = struct{}{}
+	}
+
+	previous := s.learned[list.Node]
+	s.learned[list.Node] = current
+
+	for ip := range current {
+		if _, known := previous[ip]; known {
+			continue
+		}
+		if err := s.vision.BlockPeerIP(ctx, ip); err != nil {
+			delete(current, ip) // Retried with the next message
+			s.logger.Warn("Failed to apply peer block",
+				zap.String("peer", list.Node),
+				zap.String("ip", ip),
+				zap.Error(err))
+		}
+	}
+
+	for ip := range previous {
+		if _, still := current[ip]; still || s.reportedByAnyPeer(ip) {
+			continue
+		}
+		if err := s.vision.UnblockPeerIP(ctx, ip); err != nil {
+			s.logger.Warn("Failed to lift peer block",
+				zap.String("peer", list.Node),
+				zap.String("ip", ip),
+				zap.Error(err))
+		}
+	}
+}
+
+// reportedByAnyPeer reports whether any peer currently reports the IP (s.mu held)
+func (s *PeerSyncService) reportedByAnyPeer(ip string) bool {
+	for _, ips := range s.learned {
+		if _, ok := ips[ip]; ok {
+			return true
+		}
+	}
+	return false
+}
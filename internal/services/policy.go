@@ -0,0 +1,542 @@
+// Package services provides business logic for mutation policies
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Policy request kinds
+const (
+	PolicyAddUser    = "add-user"
+	PolicyRemoveUser = "remove-user"
+	PolicyBlockIP    = "block-ip"
+	PolicyUnblockIP  = "unblock-ip"
+)
+
+var policyRequestKinds = map[string]bool{PolicyAddUser: true, PolicyRemoveUser: true, PolicyBlockIP: true, PolicyUnblockIP: true}
+
+// defaultPolicyTimeout bounds one call of an exec policy
+const defaultPolicyTimeout = 2 * time.Second
+
+// PolicyRequest is a user mutation or block decision as policies see it. One
+// request is made per user, also for batch mutations.
+type PolicyRequest struct {
+	Kind     string   `json:"kind"`
+	Username string   `json:"username,omitempty"` // User mutations and blocks
+	Tags     []string `json:"tags,omitempty"`     // Target inbounds of add-user
+	IP       string   `json:"ip,omitempty"`       // Blocks
+	Reason   string   `json:"reason,omitempty"`   // Blocks
+	Source   string   `json:"source,omitempty"`   // Blocks
+	Admin    string   `json:"admin,omitempty"`    // Blocks
+}
+
+// subject returns what a request is about, for logs
+func (r *PolicyRequest) subject() string {
+	if r.IP != "" {
+		return r.IP
+	}
+	return r.Username
+}
+
+// PolicyDecision is a policy's answer. The zero value allows the request
+// unchanged.
+type PolicyDecision struct {
+	Deny     string `json:"deny,omitempty"`     // Non-empty vetoes the request, with this reason
+	Username string `json:"username,omitempty"` // Replaces the username of a user mutation
+	Reason   string `json:"reason,omitempty"`   // Replaces the reason of a block
+}
+
+// Policy vetoes or transforms user mutations and block decisions before
+// they reach the core
+type Policy interface {
+	Check(ctx context.Context, req *PolicyRequest) (*PolicyDecision, error)
+}
+
+// PolicyFactory builds a policy from its config in the policies file
+type PolicyFactory func(config json.RawMessage) (Policy, error)
+
+var (
+	policyKindsMu sync.RWMutex
+	policyKinds   = map[string]PolicyFactory{
+		"rules": newRulesPolicy,
+		"exec":  newExecPolicy,
+	}
+)
+
+// RegisterPolicyKind makes a compiled-in policy available to the policies
+// file under kind. It is meant to be called from the init function of a
+// package imported by the node's main package.
+func RegisterPolicyKind(kind string, factory PolicyFactory) {
+	policyKindsMu.Lock()
+	defer policyKindsMu.Unlock()
+	if _, exists := policyKinds[kind]; exists {
+		panic(fmt.Sprintf("policy kind %q registered twice", kind))
+	}
+	policyKinds[kind] = factory
+}
+
+// PolicyDefinition is a policy from the policies file
+type PolicyDefinition struct {
+	Name   string          `json:"name"`
+	Kind   string          `json:"kind"` // rules, exec or a compiled-in kind
+	Config json.RawMessage `json:"config"`
+}
+
+// LoadPolicies reads policy definitions from a JSON file holding an array of
+// policies, applied in order
+func LoadPolicies(path string) ([]PolicyDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policies: %w", err)
+	}
+
+	var defs []PolicyDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse policies: %w", err)
+	}
+	for i, def := range defs {
+		if _, err := buildPolicy(i, def); err != nil {
+			return nil, err
+		}
+	}
+	return defs, nil
+}
+
+// namedPolicy is a built policy with its counters
+type namedPolicy struct {
+	name   string
+	kind   string
+	policy Policy
+
+	mu          sync.Mutex
+	checked     int64
+	denied      int64
+	transformed int64
+	failed      int64
+	lastDenial  string
+}
+
+// buildPolicy builds the policy of a definition
+func buildPolicy(i int, def PolicyDefinition) (*namedPolicy, error) {
+	name := def.Name
+	if name == "" {
+		name = fmt.Sprintf("policy-%d", i+1)
+	}
+
+	policyKindsMu.RLock()
+	factory, ok := policyKinds[def.Kind]
+	policyKindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("policy %q: unknown kind %q", name, def.Kind)
+	}
+
+	policy, err := factory(def.Config)
+	if err != nil {
+		return nil, fmt.Errorf("policy %q: %w", name, err)
+	}
+	return &namedPolicy{name: name, kind: def.Kind, policy: policy}, nil
+}
+
+// PolicyService applies the configured policies in order. All methods are
+// safe on a nil service, which allows everything.
+type PolicyService struct {
+	logger   *zap.Logger
+	policies []*namedPolicy
+}
+
+// PolicyConfig holds policy service configuration
+type PolicyConfig struct {
+	Policies []PolicyDefinition
+	Builtin  []BuiltinPolicy // Node policies, applied before the configured ones
+}
+
+// BuiltinPolicy is a policy the node itself enforces, such as the capacity
+// hard cap
+type BuiltinPolicy struct {
+	Name   string
+	Policy Policy
+}
+
+// NewPolicyService creates a new PolicyService. Definitions are expected to
+// be validated by LoadPolicies; any that do not build are logged and skipped.
+func NewPolicyService(cfg *PolicyConfig, logger *zap.Logger) *PolicyService {
+	s := &PolicyService{logger: logger}
+	for _, builtin := range cfg.Builtin {
+		s.policies = append(s.policies, &namedPolicy{name: builtin.Name, kind: "builtin", policy: builtin.Policy})
+	}
+	for i, def := range cfg.Policies {
+		p, err := buildPolicy(i, def)
+		if err != nil {
+			logger.Warn("Skipping invalid policy", zap.Error(err))
+			continue
+		}
+		s.policies = append(s.policies, p)
+	}
+	return s
+}
+
+// Check runs the policies in order, applying their transformations to req,
+// and returns an error for the first veto. A policy that fails is logged and
+// skipped; exec policies turn their own failures into vetoes when configured
+// to fail closed.
+func (s *PolicyService) Check(ctx context.Context, req *PolicyRequest) error {
+	if s == nil {
+		return nil
+	}
+	for _, p := range s.policies {
+		decision, err := p.policy.Check(ctx, req)
+
+		p.mu.Lock()
+		p.checked++
+		switch {
+		case err != nil:
+			p.failed++
+		case decision == nil:
+		case decision.Deny != "":
+			p.denied++
+			p.lastDenial = fmt.Sprintf("%s %s: %s", req.Kind, req.subject(), decision.Deny)
+		case decision.Username != "" && decision.Username != req.Username,
+			decision.Reason != "" && decision.Reason != req.Reason:
+			p.transformed++
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			s.logger.Warn("Policy failed", zap.String("policy", p.name), zap.String("kind", req.Kind), zap.Error(err))
+			continue
+		}
+		if decision == nil {
+			continue
+		}
+		if decision.Deny != "" {
+			s.logger.Info("Policy denied request",
+				zap.String("policy", p.name),
+				zap.String("kind", req.Kind),
+				zap.String("username", req.Username),
+				zap.String("ip", req.IP),
+				zap.String("reason", decision.Deny))
+			return fmt.Errorf("denied by policy %q: %s", p.name, decision.Deny)
+		}
+		if decision.Username != "" && (req.Kind == PolicyAddUser || req.Kind == PolicyRemoveUser) {
+			req.Username = decision.Username
+		}
+		if decision.Reason != "" && req.Kind == PolicyBlockIP {
+			req.Reason = decision.Reason
+		}
+	}
+	return nil
+}
+
+// PolicyInfo is a configured policy with its counters
+type PolicyInfo struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Checked     int64  `json:"checked"`
+	Denied      int64  `json:"denied"`
+	Transformed int64  `json:"transformed"`
+	Failed      int64  `json:"failed"`
+	LastDenial  string `json:"lastDenial,omitempty"`
+}
+
+// Policies lists the configured policies in the order they are applied
+func (s *PolicyService) Policies() []*PolicyInfo {
+	infos := make([]*PolicyInfo, 0)
+	if s == nil {
+		return infos
+	}
+	for _, p := range s.policies {
+		p.mu.Lock()
+		infos = append(infos, &PolicyInfo{
+			Name:        p.name,
+			Kind:        p.kind,
+			Checked:     p.checked,
+			Denied:      p.denied,
+			Transformed: p.transformed,
+			Failed:      p.failed,
+			LastDenial:  p.lastDenial,
+		})
+		p.mu.Unlock()
+	}
+	return infos
+}
+
+// rulesPolicy is the built-in declarative policy
+type rulesPolicy struct {
+	usernamePattern *regexp.Regexp
+	renameFrom      *regexp.Regexp
+	renameTo        string
+	denyInbounds    map[string]bool
+	protectedIPs    []netip.Prefix
+}
+
+// rulesPolicyConfig is the config of a rules policy
+type rulesPolicyConfig struct {
+	UsernamePattern string `json:"usernamePattern"` // Added usernames must match (after renaming)
+	Rename          *struct {
+		Pattern     string `json:"pattern"`
+		Replacement string `json:"replacement"` // $1 etc. refer to groups of the pattern
+	} `json:"rename"` // Rewrites usernames of added and removed users
+	DenyInbounds []string `json:"denyInbounds"` // Users may not be added to these inbounds
+	ProtectedIPs []string `json:"protectedIps"` // IPs and CIDRs that are never blocked
+}
+
+// newRulesPolicy builds a rules policy
+func newRulesPolicy(config json.RawMessage) (Policy, error) {
+	var cfg rulesPolicyConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+
+	p := &rulesPolicy{denyInbounds: make(map[string]bool)}
+	var err error
+	if cfg.UsernamePattern != "" {
+		if p.usernamePattern, err = regexp.Compile(cfg.UsernamePattern); err != nil {
+			return nil, fmt.Errorf("invalid usernamePattern: %w", err)
+		}
+	}
+	if cfg.Rename != nil {
+		if p.renameFrom, err = regexp.Compile(cfg.Rename.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid rename pattern: %w", err)
+		}
+		p.renameTo = cfg.Rename.Replacement
+	}
+	for _, tag := range cfg.DenyInbounds {
+		p.denyInbounds[tag] = true
+	}
+	for _, entry := range cfg.ProtectedIPs {
+		prefix, err := parseIPOrPrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protectedIps entry %q", entry)
+		}
+		p.protectedIPs = append(p.protectedIPs, prefix)
+	}
+	return p, nil
+}
+
+// Check applies the rules
+func (p *rulesPolicy) Check(ctx context.Context, req *PolicyRequest) (*PolicyDecision, error) {
+	decision := &PolicyDecision{}
+	switch req.Kind {
+	case PolicyAddUser, PolicyRemoveUser:
+		username := req.Username
+		if p.renameFrom != nil {
+			username = p.renameFrom.ReplaceAllString(username, p.renameTo)
+			decision.Username = username
+		}
+		if req.Kind != PolicyAddUser {
+			break
+		}
+		if p.usernamePattern != nil && !p.usernamePattern.MatchString(username) {
+			decision.Deny = fmt.Sprintf("username %q does not match %s", username, p.usernamePattern)
+			break
+		}
+		for _, tag := range req.Tags {
+			if p.denyInbounds[tag] {
+				decision.Deny = fmt.Sprintf("users may not be added to inbound %q", tag)
+				break
+			}
+		}
+	case PolicyBlockIP:
+		target, err := parseIPOrPrefix(req.IP)
+		if err != nil {
+			break // Left to the core to reject
+		}
+		for _, protected := range p.protectedIPs {
+			if protected.Overlaps(target) {
+				decision.Deny = fmt.Sprintf("%s is protected (%s)", req.IP, protected)
+				break
+			}
+		}
+	}
+	return decision, nil
+}
+
+// parseIPOrPrefix parses an IP as a single-address prefix, or a CIDR
+func parseIPOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// execPolicy delegates decisions to an external program, which gets the
+// request as JSON on stdin and prints a PolicyDecision as JSON
+type execPolicy struct {
+	command    []string
+	timeout    time.Duration
+	failClosed bool
+	kinds      map[string]bool
+}
+
+// execPolicyConfig is the config of an exec policy
+type execPolicyConfig struct {
+	Command    []string `json:"command"`
+	Timeout    string   `json:"timeout"`    // 2s by default
+	FailClosed bool     `json:"failClosed"` // Deny when the program fails, instead of allowing
+	Kinds      []string `json:"kinds"`      // Request kinds sent to the program; all by default
+}
+
+// newExecPolicy builds an exec policy
+func newExecPolicy(config json.RawMessage) (Policy, error) {
+	var cfg execPolicyConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid exec config: %w", err)
+	}
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+
+	p := &execPolicy{command: cfg.Command, timeout: defaultPolicyTimeout, failClosed: cfg.FailClosed}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", cfg.Timeout)
+		}
+		p.timeout = timeout
+	}
+	if len(cfg.Kinds) > 0 {
+		p.kinds = make(map[string]bool)
+		for _, kind := range cfg.Kinds {
+			if !policyRequestKinds[kind] {
+				return nil, fmt.Errorf("unknown kind %q", kind)
+			}
+			p.kinds[kind] = true
+		}
+	}
+	return p, nil
+}
+
+// Check runs the program
+func (p *execPolicy) Check(ctx context.Context, req *PolicyRequest) (*PolicyDecision, error) {
+	if p.kinds != nil && !p.kinds[req.Kind] {
+		return nil, nil
+	}
+	decision, err := p.run(ctx, req)
+	if err != nil && p.failClosed {
+		return &PolicyDecision{Deny: "policy program failed: " + err.Error()}, nil
+	}
+	return decision, err
+}
+
+// run runs the program within the timeout and decodes its decision
+func (p *execPolicy) run(ctx context.Context, req *PolicyRequest) (*PolicyDecision, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, truncateOutput(stderr.Bytes()))
+	}
+
+	decision := &PolicyDecision{}
+	if output := bytes.TrimSpace(stdout.Bytes()); len(output) > 0 {
+		if err := json.Unmarshal(output, decision); err != nil {
+			return nil, fmt.Errorf("invalid decision %q: %w", truncateOutput(output), err)
+		}
+	}
+	return decision, nil
+}
+
+// checkAddUserPolicy applies the policies to an AddUser request, renaming
+// the user in every item when a policy transforms the username
+func (s *HandlerService) checkAddUserPolicy(ctx context.Context, req *AddUserRequest) error {
+	if s.policies == nil || req.policyChecked || len(req.Data) == 0 {
+		return nil
+	}
+	preq := &PolicyRequest{Kind: PolicyAddUser, Username: req.Data[0].Username}
+	for _, item := range req.Data {
+		preq.Tags = append(preq.Tags, item.Tag)
+	}
+	if err := s.policies.Check(ctx, preq); err != nil {
+		return err
+	}
+	for i := range req.Data {
+		req.Data[i].Username = preq.Username
+	}
+	req.policyChecked = true
+	return nil
+}
+
+// checkAddUsersPolicy applies the policies to each user of an AddUsers
+// request. A veto of any user rejects the whole batch.
+func (s *HandlerService) checkAddUsersPolicy(ctx context.Context, req *AddUsersRequest) error {
+	if s.policies == nil || req.policyChecked {
+		return nil
+	}
+	usernames := make([]string, len(req.Users))
+	for i, user := range req.Users {
+		preq := &PolicyRequest{Kind: PolicyAddUser, Username: user.UserData.UserId}
+		for _, item := range user.InboundData {
+			preq.Tags = append(preq.Tags, item.Tag)
+		}
+		if err := s.policies.Check(ctx, preq); err != nil {
+			return err
+		}
+		usernames[i] = preq.Username
+	}
+	for i := range req.Users {
+		req.Users[i].UserData.UserId = usernames[i]
+	}
+	req.policyChecked = true
+	return nil
+}
+
+// checkRemoveUserPolicy applies the policies to a RemoveUser request
+func (s *HandlerService) checkRemoveUserPolicy(ctx context.Context, req *RemoveUserRequest) error {
+	if s.policies == nil || req.policyChecked {
+		return nil
+	}
+	preq := &PolicyRequest{Kind: PolicyRemoveUser, Username: req.Username}
+	if err := s.policies.Check(ctx, preq); err != nil {
+		return err
+	}
+	req.Username = preq.Username
+	req.policyChecked = true
+	return nil
+}
+
+// checkRemoveUsersPolicy applies the policies to each user of a RemoveUsers
+// request. A veto of any user rejects the whole batch.
+func (s *HandlerService) checkRemoveUsersPolicy(ctx context.Context, req *RemoveUsersRequest) error {
+	if s.policies == nil || req.policyChecked {
+		return nil
+	}
+	usernames := make([]string, len(req.Users))
+	for i, user := range req.Users {
+		preq := &PolicyRequest{Kind: PolicyRemoveUser, Username: user.UserId}
+		if err := s.policies.Check(ctx, preq); err != nil {
+			return err
+		}
+		usernames[i] = preq.Username
+	}
+	for i := range req.Users {
+		req.Users[i].UserId = usernames[i]
+	}
+	req.policyChecked = true
+	return nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRulesPolicy(t *testing.T) {
+	s := NewPolicyService(&PolicyConfig{Policies: []PolicyDefinition{{
+		Name: "rules",
+		Kind: "rules",
+		Config: json.RawMessage(`{
+			"rename": {"pattern": "^legacy_(.*)$", "replacement": "$1"},
+			"usernamePattern": "^[a-z0-9]+$",
+			"denyInbounds": ["admin-only"],
+			"protectedIps": ["10.0.0.0/8", "2001:db8::1"]
+		}`),
+	}}}, zap.NewNop())
+	ctx := context.Background()
+
+	req := &PolicyRequest{Kind: PolicyAddUser, Username: "legacy_alice", Tags: []string{"vless"}}
+	if err := s.Check(ctx, req); err != nil || req.Username != "alice" {
+		t.Errorf("Expected the user to be renamed to alice, got %q (%v)", req.Username, err)
+	}
+	if err := s.Check(ctx, &PolicyRequest{Kind: PolicyAddUser, Username: "Bob"}); err == nil {
+		t.Error("Expected a username not matching the pattern to be denied")
+	}
+	if err := s.Check(ctx, &PolicyRequest{Kind: PolicyAddUser, Username: "bob", Tags: []string{"vless", "admin-only"}}); err == nil {
+		t.Error("Expected a user on a denied inbound to be denied")
+	}
+	if err := s.Check(ctx, &PolicyRequest{Kind: PolicyRemoveUser, Username: "Bob"}); err != nil {
+		t.Errorf("Expected removals to skip the username pattern, got %v", err)
+	}
+
+	for ip, protected := range map[string]bool{"10.1.2.3": true, "10.0.0.0/16": true, "2001:db8::1": true, "198.51.100.7": false} {
+		err := s.Check(ctx, &PolicyRequest{Kind: PolicyBlockIP, IP: ip})
+		if protected != (err != nil) {
+			t.Errorf("Block of %s: expected protected=%v, got %v", ip, protected, err)
+		}
+	}
+
+	infos := s.Policies()
+	if len(infos) != 1 || infos[0].Checked != 8 || infos[0].Denied != 5 || infos[0].Transformed != 1 {
+		t.Errorf("Unexpected counters %+v", infos[0])
+	}
+}
+
+func TestExecPolicy(t *testing.T) {
+	script := `read -r req
+case "$req" in
+*'"ip":"203.0.113.9"'*) echo '{"deny":"peer of record"}' ;;
+*'"kind":"block-ip"'*) echo '{"reason":"rewritten"}' ;;
+*) echo '{}' ;;
+esac`
+	config, _ := json.Marshal(map[string]any{"command": []string{"sh", "-c", script}, "kinds": []string{PolicyBlockIP}})
+	s := NewPolicyService(&PolicyConfig{Policies: []PolicyDefinition{{Name: "script", Kind: "exec", Config: config}}}, zap.NewNop())
+	ctx := context.Background()
+
+	req := &PolicyRequest{Kind: PolicyBlockIP, IP: "198.51.100.7", Reason: "abuse"}
+	if err := s.Check(ctx, req); err != nil || req.Reason != "rewritten" {
+		t.Errorf("Expected the reason to be rewritten, got %q (%v)", req.Reason, err)
+	}
+	if err := s.Check(ctx, &PolicyRequest{Kind: PolicyBlockIP, IP: "203.0.113.9"}); err == nil || !strings.Contains(err.Error(), "peer of record") {
+		t.Errorf("Expected the program's denial, got %v", err)
+	}
+	if err := s.Check(ctx, &PolicyRequest{Kind: PolicyAddUser, Username: "alice"}); err != nil {
+		t.Errorf("Expected kinds not sent to the program to be allowed, got %v", err)
+	}
+
+	failing, _ := json.Marshal(map[string]any{"command": []string{"sh", "-c", "exit 3"}, "failClosed": true})
+	closed := NewPolicyService(&PolicyConfig{Policies: []PolicyDefinition{{Kind: "exec", Config: failing}}}, zap.NewNop())
+	if err := closed.Check(ctx, &PolicyRequest{Kind: PolicyRemoveUser, Username: "alice"}); err == nil {
+		t.Error("Expected a failing fail-closed policy to deny")
+	}
+
+	// A nil service allows everything
+	var none *PolicyService
+	if err := none.Check(ctx, &PolicyRequest{Kind: PolicyBlockIP, IP: "10.0.0.1"}); err != nil || len(none.Policies()) != 0 {
+		t.Errorf("Expected a nil service to allow, got %v", err)
+	}
+}
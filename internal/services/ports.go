@@ -0,0 +1,211 @@
+// Package services provides business logic for inbound port ranges (port hopping)
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// parsePortSpec parses an inbound port as configured: a number, an Xray port
+// list string ("443", "20000-30000", "80,443,8000-8100"), the "20000:30000"
+// form used by hopping clients, or a JSON array of any of these
+func parsePortSpec(spec interface{}) ([]PortRange, error) {
+	switch v := spec.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return parsePortString(strconv.FormatFloat(v, 'f', -1, 64))
+	case int:
+		return parsePortString(strconv.Itoa(v))
+	case string:
+		return parsePortString(v)
+	case []interface{}:
+		var ranges []PortRange
+		for _, item := range v {
+			r, err := parsePortSpec(item)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r...)
+		}
+		return ranges, nil
+	default:
+		return nil, fmt.Errorf("unsupported port value %v", spec)
+	}
+}
+
+// parsePortString parses a comma-separated list of ports and ranges
+func parsePortString(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.HasPrefix(part, "env:") {
+			return nil, fmt.Errorf("port %q is resolved by the core", part)
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			from, to, isRange = strings.Cut(part, ":")
+		}
+		if !isRange {
+			to = from
+		}
+		lo, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := parsePort(to)
+		if err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		ranges = append(ranges, PortRange{From: lo, To: hi})
+	}
+	return ranges, nil
+}
+
+// parsePort parses a single port number
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// formatPortRanges renders ranges in the Xray port list syntax
+func formatPortRanges(ranges []PortRange) string {
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		if r.From == r.To {
+			parts = append(parts, strconv.Itoa(r.From))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r.From, r.To))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// portRangesContain reports whether any range contains the port
+func portRangesContain(ranges []PortRange, port int) bool {
+	for _, r := range ranges {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// portRangesCount returns the number of ports covered by the ranges
+func portRangesCount(ranges []PortRange) int {
+	count := 0
+	for _, r := range ranges {
+		count += r.To - r.From + 1
+	}
+	return count
+}
+
+// normalizeInboundPorts rewrites port-hopping inbounds declared as arrays or
+// with "from:to" ranges into the Xray port list syntax, so the core listens on
+// the whole range as one inbound (one tag for stats and hashes). Inbounds
+// with a single port or an already valid list are left untouched.
+func normalizeInboundPorts(doc *configDocument) []string {
+	var warnings []string
+	for i := range doc.inbounds {
+		var port interface{}
+		if !doc.inboundField(i, "port", &port) {
+			continue
+		}
+
+		_, isArray := port.([]interface{})
+		str, isString := port.(string)
+		if !isArray && !(isString && strings.Contains(str, ":") && !strings.HasPrefix(str, "env:")) {
+			continue
+		}
+
+		ranges, err := parsePortSpec(port)
+		if err != nil || len(ranges) == 0 {
+			warnings = append(warnings, fmt.Sprintf("inbound %q: cannot parse port %v", doc.inboundTag(i), port))
+			continue
+		}
+		doc.setInboundField(i, "port", formatPortRanges(ranges))
+	}
+	return warnings
+}
+
+// InboundOverride rewrites where a panel-pushed inbound listens on this node
+type InboundOverride struct {
+	Listen string      `json:"listen,omitempty"` // Bind address, e.g. a specific interface IP
+	Port   interface{} `json:"port,omitempty"`   // Port or port range, as in an inbound
+}
+
+// LoadInboundOverrides reads node-local inbound overrides (tag -> override) from a JSON file
+func LoadInboundOverrides(path string) (map[string]InboundOverride, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inbound overrides: %w", err)
+	}
+
+	var overrides map[string]InboundOverride
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse inbound overrides: %w", err)
+	}
+	for tag, o := range overrides {
+		if o.Listen != "" && net.ParseIP(o.Listen) == nil && !strings.HasPrefix(o.Listen, "/") && !strings.HasPrefix(o.Listen, "@") {
+			return nil, fmt.Errorf("inbound override %q: invalid listen address %q", tag, o.Listen)
+		}
+		if o.Port != nil {
+			if _, err := parsePortSpec(o.Port); err != nil {
+				return nil, fmt.Errorf("inbound override %q: %w", tag, err)
+			}
+		}
+	}
+	return overrides, nil
+}
+
+// applyInboundOverrides replaces the listen addresses and ports of inbounds
+// with the node-local overrides and returns warnings for unmatched tags
+func applyInboundOverrides(doc *configDocument, overrides map[string]InboundOverride) []string {
+	matched := make(map[string]bool, len(overrides))
+	for i := range doc.inbounds {
+		tag := doc.inboundTag(i)
+		override, ok := overrides[tag]
+		if !ok {
+			continue
+		}
+		matched[tag] = true
+
+		if override.Listen != "" {
+			doc.setInboundField(i, "listen", override.Listen)
+		}
+		if override.Port != nil {
+			ranges, _ := parsePortSpec(override.Port)
+			doc.setInboundField(i, "port", formatPortRanges(ranges))
+		}
+	}
+
+	var warnings []string
+	for tag := range overrides {
+		if !matched[tag] {
+			warnings = append(warnings, fmt.Sprintf("inbound override for %q matches no inbound", tag))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,278 @@
+// Package services provides business logic for recording panel requests
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/uuid"
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+)
+
+// credentialKeys are the JSON keys, lowercased, whose string values are masked
+// in recordings: user credentials in panel payloads and Xray configs. Keys
+// ending in one of credentialKeySuffixes are masked too.
+var credentialKeys = map[string]bool{
+	"id":           true, // vless/vmess client id
+	"pass":         true,
+	"auth":         true,
+	"token":        true,
+	"secret":       true,
+	"secretkey":    true,
+	"privatekey":   true,
+	"presharedkey": true,
+	"seed":         true,
+}
+
+// credentialKeySuffixes cover keys such as vlessUuid, trojanPassword and ssPassword
+var credentialKeySuffixes = []string{"uuid", "password"}
+
+// isCredentialKey reports whether a JSON key holds a credential
+func isCredentialKey(key string) bool {
+	key = strings.ToLower(key)
+	if credentialKeys[key] {
+		return true
+	}
+	for _, suffix := range credentialKeySuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecorderConfig holds request recorder configuration
+type RecorderConfig struct {
+	Dir   string // Directory where recordings are stored
+	Limit int    // Recordings kept, oldest removed first; 0 disables
+}
+
+// RecordedRequest is one recording as stored on disk. Body is the request as
+// sent by the panel with credentials masked, ready to be POSTed to Path.
+type RecordedRequest struct {
+	Path       string          `json:"path"`
+	RecordedAt time.Time       `json:"recordedAt"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// RecordingInfo describes a stored recording
+type RecordingInfo struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	RecordedAt time.Time `json:"recordedAt"`
+	Size       int64     `json:"size"`
+}
+
+// RecorderService keeps sanitized copies of the last requests the panel sent,
+// so sync bugs seen in production can be replayed against a dev node.
+// Credentials are replaced with HMACs under a key generated at startup: the
+// same credential masks to the same value throughout one run, so a user still
+// matches across start and add-user recordings, and masked UUIDs stay UUIDs.
+type RecorderService struct {
+	mu     sync.Mutex
+	logger *zap.Logger
+	dir    string
+	limit  int
+	key    []byte
+	seq    uint64
+}
+
+// NewRecorderService creates a new RecorderService
+func NewRecorderService(cfg *RecorderConfig, logger *zap.Logger) *RecorderService {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		logger.Error("Failed to generate recorder mask key, recorder disabled", zap.Error(err))
+		return &RecorderService{logger: logger}
+	}
+
+	return &RecorderService{
+		logger: logger,
+		dir:    cfg.Dir,
+		limit:  cfg.Limit,
+		key:    key,
+	}
+}
+
+// Enabled reports whether requests are recorded
+func (s *RecorderService) Enabled() bool {
+	return s.limit > 0
+}
+
+// Record stores a sanitized copy of a request body sent to path and removes
+// the oldest recordings beyond the limit. Failures are logged, never returned,
+// as recording must not affect the request.
+func (s *RecorderService) Record(path string, body []byte) {
+	if !s.Enabled() {
+		return
+	}
+
+	sanitized, err := s.sanitize(body)
+	if err != nil {
+		s.logger.Debug("Not recording request with a non-JSON body", zap.String("path", path), zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.seq++
+	name := fmt.Sprintf("%s-%06d-%s.json", now.Format("20060102T150405.000"), s.seq%1000000, recordingSlug(path))
+
+	data, err := json.MarshalIndent(&RecordedRequest{Path: path, RecordedAt: now, Body: sanitized}, "", "  ")
+	if err != nil {
+		s.logger.Warn("Failed to encode recording", zap.Error(err))
+		return
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		s.logger.Warn("Failed to create recordings directory", zap.String("dir", s.dir), zap.Error(err))
+		return
+	}
+	if err := atomicfile.WriteFile(filepath.Join(s.dir, name), data, 0600, false); err != nil {
+		s.logger.Warn("Failed to write recording", zap.String("name", name), zap.Error(err))
+		return
+	}
+
+	s.prune()
+}
+
+// List returns the stored recordings, oldest first
+func (s *RecorderService) List() ([]*RecordingInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.recordingNames()
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := make([]*RecordingInfo, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			continue
+		}
+		var recorded RecordedRequest
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			continue
+		}
+		recordings = append(recordings, &RecordingInfo{
+			Name:       name,
+			Path:       recorded.Path,
+			RecordedAt: recorded.RecordedAt,
+			Size:       int64(len(data)),
+		})
+	}
+	return recordings, nil
+}
+
+// recordingNames returns the recording file names, oldest first (no lock)
+func (s *RecorderService) recordingNames() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names) // Names start with the UTC time
+	return names, nil
+}
+
+// prune removes the oldest recordings beyond the limit (no lock)
+func (s *RecorderService) prune() {
+	names, err := s.recordingNames()
+	if err != nil {
+		s.logger.Warn("Failed to list recordings", zap.Error(err))
+		return
+	}
+
+	for len(names) > s.limit {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("Failed to remove old recording", zap.String("name", names[0]), zap.Error(err))
+		}
+		names = names[1:]
+	}
+}
+
+// sanitize returns the JSON body with credential values masked
+func (s *RecorderService) sanitize(body []byte) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(s.maskCredentials(value))
+}
+
+// maskCredentials walks a decoded JSON value, masking credential strings
+func (s *RecorderService) maskCredentials(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if str, ok := field.(string); ok && str != "" && isCredentialKey(key) {
+				v[key] = s.mask(str)
+				continue
+			}
+			v[key] = s.maskCredentials(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = s.maskCredentials(item)
+		}
+	}
+	return value
+}
+
+// mask replaces a credential with its HMAC, keeping UUIDs in UUID form and
+// shadowsocks 2022 keys as base64 keys of the same size so masked payloads
+// still pass validation on replay
+func (s *RecorderService) mask(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	sum := mac.Sum(nil)
+
+	// Only canonical UUIDs; ParseString also maps short strings to UUIDs
+	if _, err := uuid.ParseString(value); err == nil && len(value) == 36 {
+		var masked uuid.UUID
+		copy(masked[:], sum)
+		masked[6] = (masked[6] & 0x0f) | 0x40 // version 4
+		masked[8] = (masked[8] & 0x3f) | 0x80 // RFC 4122 variant
+		return masked.String()
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && (len(key) == 16 || len(key) == 32) {
+		return base64.StdEncoding.EncodeToString(sum[:len(key)])
+	}
+	return "masked-" + hex.EncodeToString(sum[:12])
+}
+
+// recordingSlug turns a request path into a file name part,
+// e.g. /node/handler/add-users -> handler-add-users
+func recordingSlug(path string) string {
+	path = strings.TrimPrefix(path, "/node/")
+	return strings.ReplaceAll(strings.Trim(path, "/"), "/", "-")
+}
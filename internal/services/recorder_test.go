@@ -0,0 +1,137 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRecorder_MasksCredentials(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorderService(&RecorderConfig{Dir: dir, Limit: 5}, zap.NewNop())
+
+	body := `{"data":[{"type":"vless","tag":"VLESS","username":"alice","uuid":"` + testUUID1 + `"},` +
+		`{"type":"trojan","tag":"TROJAN","username":"alice","password":"hunter2"}],` +
+		`"hashData":{"vlessUuid":"` + testUUID1 + `"}}`
+	recorder.Record("/node/handler/add-users", []byte(body))
+
+	recordings, err := recorder.List()
+	if err != nil || len(recordings) != 1 {
+		t.Fatalf("Expected one recording, got %v %v", recordings, err)
+	}
+	if !strings.HasSuffix(recordings[0].Name, "-handler-add-users.json") {
+		t.Errorf("Unexpected recording name %s", recordings[0].Name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, recordings[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), testUUID1) || strings.Contains(string(data), "hunter2") {
+		t.Fatalf("Credentials leaked into the recording:\n%s", data)
+	}
+
+	var recorded RecordedRequest
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	var req AddUserRequest
+	if err := json.Unmarshal(recorded.Body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Data[0].Username != "alice" || req.Data[0].Tag != "VLESS" {
+		t.Errorf("Expected non-credential fields to be kept, got %+v", req.Data[0])
+	}
+	if req.Data[0].UUID != req.HashData.VlessUUID {
+		t.Errorf("Expected the same UUID to mask to the same value, got %s and %s", req.Data[0].UUID, req.HashData.VlessUUID)
+	}
+	if verr := validateAddUser(&req, nil); verr != nil {
+		t.Errorf("Expected masked credentials to stay valid: %v", verr)
+	}
+}
+
+func TestRecorder_MasksBatchCredentials(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorderService(&RecorderConfig{Dir: dir, Limit: 5}, zap.NewNop())
+
+	body := `{"affectedInboundTags":["TROJAN","SS"],"users":[{` +
+		`"inboundData":[{"type":"trojan","tag":"TROJAN"},{"type":"shadowsocks","tag":"SS"}],` +
+		`"userData":{"userId":"alice","hashUuid":"` + testUUID2 + `","vlessUuid":"` + testUUID1 + `",` +
+		`"trojanPassword":"trojan-secret","ssPassword":"ss-secret"}}]}`
+	recorder.Record("/node/handler/add-users", []byte(body))
+
+	recordings, err := recorder.List()
+	if err != nil || len(recordings) != 1 {
+		t.Fatalf("Expected one recording, got %v %v", recordings, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, recordings[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{testUUID1, testUUID2, "trojan-secret", "ss-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Credential %s leaked into the recording:\n%s", secret, data)
+		}
+	}
+
+	var recorded RecordedRequest
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	var req AddUsersRequest
+	if err := json.Unmarshal(recorded.Body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if user := req.Users[0].UserData; user.UserId != "alice" || user.TrojanPassword == "" || user.SsPassword == "" {
+		t.Errorf("Expected the user kept with masked passwords, got %+v", user)
+	}
+}
+
+func TestRecorder_MasksSS2022KeysAsKeys(t *testing.T) {
+	recorder := NewRecorderService(&RecorderConfig{Dir: t.TempDir(), Limit: 5}, zap.NewNop())
+
+	for _, key := range []string{testSS128Key, testSS256Key} {
+		masked := recorder.mask(key)
+		decoded, err := base64.StdEncoding.DecodeString(masked)
+		original, _ := base64.StdEncoding.DecodeString(key)
+		if masked == key || err != nil || len(decoded) != len(original) {
+			t.Errorf("Expected %s masked as another %d-byte key, got %q", key, len(original), masked)
+		}
+	}
+	if masked := recorder.mask("ss-secret"); !strings.HasPrefix(masked, "masked-") {
+		t.Errorf("Expected a passphrase masked as text, got %q", masked)
+	}
+}
+
+func TestRecorder_KeepsLimit(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorderService(&RecorderConfig{Dir: dir, Limit: 2}, zap.NewNop())
+
+	for _, username := range []string{"alice", "bob", "carol"} {
+		recorder.Record("/node/handler/add-user", []byte(`{"data":{"username":"`+username+`"}}`))
+	}
+
+	recordings, err := recorder.List()
+	if err != nil || len(recordings) != 2 {
+		t.Fatalf("Expected two recordings, got %v %v", recordings, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, recordings[0].Name))
+	if !strings.Contains(string(data), "bob") {
+		t.Errorf("Expected the oldest recording to be removed, got %s", data)
+	}
+}
+
+func TestRecorder_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorderService(&RecorderConfig{Dir: dir}, zap.NewNop())
+
+	recorder.Record("/node/xray/start", []byte(`{"xrayConfig":{}}`))
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected nothing recorded while disabled, got %d files", len(entries))
+	}
+}
@@ -0,0 +1,120 @@
+// Package services provides business logic for core restart history
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// restartHistoryLimit is how many restarts are kept, oldest dropped first
+const restartHistoryLimit = 100
+
+// Restart triggers (RestartRecord.Trigger)
+const (
+	RestartTriggerInitial           = "initial"           // First start, or a start after Stop
+	RestartTriggerForce             = "force"             // The panel forced a restart
+	RestartTriggerHashChange        = "hashChange"        // Inbound hashes differ from the running config
+	RestartTriggerHashCheckDisabled = "hashCheckDisabled" // Every start restarts the core
+	RestartTriggerCrash             = "crash"             // The core stopped without being stopped
+	RestartTriggerUnhealthy         = "unhealthy"         // The core runs but failed its health check
+	RestartTriggerRestart           = "restart"           // Restart was called
+	RestartTriggerRestore           = "restore"           // Started from the local config on boot
+)
+
+// RestartRecord is one core (re)start, successful or not
+type RestartRecord struct {
+	Time       time.Time `json:"time"`
+	Trigger    string    `json:"trigger"`
+	Detail     string    `json:"detail,omitempty"`
+	DurationMs int64     `json:"durationMs"` // Core start and health check
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	Version    string    `json:"version,omitempty"`
+}
+
+// restartHistory is a bounded, newest-last list of restarts
+type restartHistory struct {
+	mu      sync.Mutex
+	records []RestartRecord
+}
+
+// add appends a record, dropping the oldest beyond restartHistoryLimit
+func (h *restartHistory) add(record RestartRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.records) >= restartHistoryLimit {
+		copy(h.records, h.records[1:])
+		h.records = h.records[:len(h.records)-1]
+	}
+	h.records = append(h.records, record)
+}
+
+// list returns a copy of the records, newest first
+func (h *restartHistory) list() []RestartRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := make([]RestartRecord, len(h.records))
+	for i, record := range h.records {
+		records[len(h.records)-1-i] = record
+	}
+	return records
+}
+
+// RestartHistory returns the recorded core restarts, newest first
+func (s *XrayService) RestartHistory() []RestartRecord {
+	return s.restarts.list()
+}
+
+// recordRestart records a core start begun at started. errMsg is empty when
+// the core started and passed its health check.
+func (s *XrayService) recordRestart(trigger, detail string, started time.Time, errMsg string) {
+	record := RestartRecord{
+		Time:       started,
+		Trigger:    trigger,
+		Detail:     detail,
+		DurationMs: time.Since(started).Milliseconds(),
+		Success:    errMsg == "",
+		Error:      errMsg,
+	}
+	if record.Success {
+		record.Version = s.GetVersion()
+	}
+	s.restarts.add(record)
+}
+
+// startTrigger classifies why Start is (re)starting the core. It must be
+// called before the new config's users are extracted, as that replaces the
+// hashes a hash change is explained against.
+func (s *XrayService) startTrigger(req *StartRequest, healthFailed bool) (trigger, detail string) {
+	switch {
+	case req.Internals.ForceRestart:
+		return RestartTriggerForce, ""
+	case !s.xrayCore.IsRunning():
+		if s.isConfigured.Load() {
+			return RestartTriggerCrash, ""
+		}
+		return RestartTriggerInitial, ""
+	case healthFailed:
+		return RestartTriggerUnhealthy, ""
+	case s.HashCheckDisabled():
+		return RestartTriggerHashCheckDisabled, ""
+	case req.Internals.SkipHashCheck:
+		return RestartTriggerHashCheckDisabled, "skipHashCheck"
+	case s.internal == nil:
+		return RestartTriggerHashChange, RestartReasonNoStoredHash
+	}
+
+	decision := s.internal.ExplainRestart(req.Internals.Hashes)
+	reasons := make([]string, 0, len(decision.Reasons))
+	for _, reason := range decision.Reasons {
+		if reason.Tag != "" {
+			reasons = append(reasons, reason.Kind+" "+reason.Tag)
+		} else {
+			reasons = append(reasons, reason.Kind)
+		}
+	}
+	return RestartTriggerHashChange, strings.Join(reasons, ", ")
+}
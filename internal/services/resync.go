@@ -0,0 +1,246 @@
+// Package services provides business logic for resyncing users from the local config
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// PlannedUpdate replaces a user whose credentials differ from the config
+const PlannedUpdate = "update"
+
+// ResyncFromConfigRequest represents a request to resync users from config.json
+type ResyncFromConfigRequest struct {
+	DryRun bool `json:"dryRun"` // Report planned operations without changing the core
+}
+
+// ResyncFromConfigResponse represents the result of a resync
+type ResyncFromConfigResponse struct {
+	Success    bool                `json:"success"`
+	Error      *string             `json:"error"`
+	Added      int                 `json:"added"`
+	Updated    int                 `json:"updated"`
+	Removed    int                 `json:"removed"`
+	Failed     int                 `json:"failed"`
+	Operations []*PlannedOperation `json:"operations"`
+}
+
+// configInbound is the part of an inbound needed to rebuild its users
+type configInbound struct {
+	Tag      string `json:"tag"`
+	Protocol string `json:"protocol"`
+	Settings struct {
+		Method  string         `json:"method"`
+		Clients []configClient `json:"clients"`
+	} `json:"settings"`
+}
+
+// configClient is a user as written in an inbound's clients list
+type configClient struct {
+	Email    string `json:"email"`
+	ID       string `json:"id"`
+	Flow     string `json:"flow"`
+	Password string `json:"password"`
+	Method   string `json:"method"`
+	Level    uint32 `json:"level"`
+}
+
+// memoryUser builds the core user for a config client of the given inbound
+func (c *configClient) memoryUser(inbound *configInbound) (*protocol.MemoryUser, error) {
+	switch inbound.Protocol {
+	case "vless":
+		return xraycore.CreateVlessUser(c.Email, c.ID, c.Flow, c.Level)
+	case "trojan":
+		return xraycore.CreateTrojanUser(c.Email, c.Password, c.Level)
+	case "shadowsocks":
+		method := c.Method
+		if method == "" {
+			method = inbound.Settings.Method
+		}
+		cipherType, err := xraycore.CipherTypeFromMethod(method)
+		if err != nil {
+			return nil, err
+		}
+		return xraycore.CreateShadowsocksUser(c.Email, c.Password, cipherType, c.Level)
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", inbound.Protocol)
+	}
+}
+
+// ResyncFromConfig reconciles the running core's users with the given config
+// (normally config.json on disk) and rebuilds the user-inbound tracking from it.
+// Users missing from the core are added, users with different credentials are
+// replaced and users not in the config are removed. Only the embedded core is
+// changed; sidecar cores are resynced by the next full config push.
+func (s *HandlerService) ResyncFromConfig(ctx context.Context, config json.RawMessage, hashes *InboundHashes, dryRun bool) (*ResyncFromConfigResponse, error) {
+	leave, err := s.enterBarrier(ctx)
+	if err != nil {
+		errMsg := err.Error()
+		return &ResyncFromConfigResponse{Success: false, Error: &errMsg, Operations: []*PlannedOperation{}}, nil
+	}
+	defer leave()
+
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		errMsg := "Xray not running"
+		return &ResyncFromConfigResponse{Success: false, Error: &errMsg, Operations: []*PlannedOperation{}}, nil
+	}
+
+	// Emails in the form the core got them at start and mutations use
+	doc, err := parseConfigDocument(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	normalizeClientEmails(doc, s.usernames)
+	config = doc.marshal()
+
+	var parsed struct {
+		Inbounds []configInbound `json:"inbounds"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	resp := &ResyncFromConfigResponse{Success: true, Operations: make([]*PlannedOperation, 0)}
+	for i := range parsed.Inbounds {
+		inbound := &parsed.Inbounds[i]
+		if inbound.Tag == "" {
+			continue
+		}
+		switch inbound.Protocol {
+		case "vless", "trojan", "shadowsocks":
+		default:
+			continue
+		}
+		if inbound.Protocol == "shadowsocks" && strings.HasPrefix(inbound.Settings.Method, "2022-") {
+			resp.Operations = append(resp.Operations, &PlannedOperation{
+				Core: "xray", Inbound: inbound.Tag, Action: PlannedSkip, Type: inbound.Protocol,
+				Reason: "shadowsocks 2022 users cannot be managed at runtime",
+			})
+			continue
+		}
+
+		s.resyncInbound(ctx, inbound, dryRun, resp)
+	}
+
+	if resp.Failed > 0 {
+		errMsg := fmt.Sprintf("%d operations failed", resp.Failed)
+		resp.Success = false
+		resp.Error = &errMsg
+	}
+
+	if !dryRun {
+		if err := s.internal.ExtractUsersFromConfig(config, hashes); err != nil {
+			return nil, fmt.Errorf("failed to rebuild user tracking: %w", err)
+		}
+		s.logger.Info("Resynced users from local config",
+			zap.Int("added", resp.Added),
+			zap.Int("updated", resp.Updated),
+			zap.Int("removed", resp.Removed),
+			zap.Int("failed", resp.Failed))
+	}
+
+	return resp, nil
+}
+
+// resyncInbound reconciles the users of one inbound, recording each operation
+func (s *HandlerService) resyncInbound(ctx context.Context, inbound *configInbound, dryRun bool, resp *ResyncFromConfigResponse) {
+	unlock := s.lockInbound(ctx, inbound.Tag)
+	defer unlock()
+
+	record := func(username, action string, err error) {
+		op := &PlannedOperation{Core: "xray", Username: username, Inbound: inbound.Tag, Action: action, Type: inbound.Protocol}
+		if err != nil {
+			op.Reason = err.Error()
+			resp.Failed++
+			s.logger.Warn("Failed to resync user",
+				zap.String("user", username),
+				zap.String("tag", inbound.Tag),
+				zap.String("action", action),
+				zap.Error(err))
+		}
+		resp.Operations = append(resp.Operations, op)
+	}
+
+	current, err := s.xrayCore.GetInboundUsers(ctx, inbound.Tag)
+	if err != nil {
+		record("", PlannedSkip, err)
+		return
+	}
+	existing := make(map[string]*protocol.MemoryUser, len(current))
+	for _, user := range current {
+		existing[user.Email] = user
+	}
+
+	wanted := make(map[string]struct{}, len(inbound.Settings.Clients))
+	for i := range inbound.Settings.Clients {
+		client := &inbound.Settings.Clients[i]
+		if client.Email == "" {
+			continue
+		}
+		wanted[client.Email] = struct{}{}
+
+		user, err := client.memoryUser(inbound)
+		if err != nil {
+			record(client.Email, PlannedSkip, err)
+			continue
+		}
+
+		action := PlannedAdd
+		if old, ok := existing[client.Email]; ok {
+			if old.Account != nil && old.Account.Equals(user.Account) {
+				continue
+			}
+			action = PlannedUpdate
+		}
+		if dryRun {
+			record(client.Email, action, nil)
+			continue
+		}
+
+		if action == PlannedUpdate {
+			if err := s.xrayCore.RemoveUser(ctx, inbound.Tag, client.Email); err != nil {
+				record(client.Email, action, err)
+				continue
+			}
+		}
+		if err := s.xrayCore.AddUser(ctx, inbound.Tag, user); err != nil {
+			record(client.Email, action, err)
+			continue
+		}
+		record(client.Email, action, nil)
+		if action == PlannedAdd {
+			resp.Added++
+		} else {
+			resp.Updated++
+		}
+	}
+
+	// Users the config no longer has, in a stable order
+	var extra []string
+	for email := range existing {
+		if _, ok := wanted[email]; !ok {
+			extra = append(extra, email)
+		}
+	}
+	sort.Strings(extra)
+	for _, email := range extra {
+		if dryRun {
+			record(email, PlannedRemove, nil)
+			continue
+		}
+		if err := s.xrayCore.RemoveUser(ctx, inbound.Tag, email); err != nil {
+			record(email, PlannedRemove, err)
+			continue
+		}
+		record(email, PlannedRemove, nil)
+		resp.Removed++
+	}
+}
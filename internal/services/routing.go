@@ -0,0 +1,205 @@
+// Package services provides business logic for per-user outbound routing
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// RoutingService pins users to specific outbounds via email-based router rules.
+// Like Vision rules, pins live in the running core and are lost on restart.
+type RoutingService struct {
+	mu       sync.Mutex
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+	pins     map[string]string // username -> outbound tag
+
+	// Applied to pinned usernames, so rules match the emails in the core
+	usernames *UsernameNormalizer
+}
+
+// NewRoutingService creates a new RoutingService
+func NewRoutingService(xrayCore *xraycore.Instance, usernames *UsernameNormalizer, logger *zap.Logger) *RoutingService {
+	return &RoutingService{
+		logger:    logger,
+		xrayCore:  xrayCore,
+		pins:      make(map[string]string),
+		usernames: usernames,
+	}
+}
+
+// userRuleTagPrefix starts the router rule tags of pinned users
+const userRuleTagPrefix = "user-route-"
+
+// userRuleTag returns the router rule tag for a pinned user
+func userRuleTag(username string) string {
+	hash := md5.Sum([]byte(username))
+	return userRuleTagPrefix + hex.EncodeToString(hash[:])
+}
+
+// PinUserRequest represents a request to route a user through an outbound
+type PinUserRequest struct {
+	Username    string `json:"username" binding:"required"`
+	OutboundTag string `json:"outboundTag" binding:"required"`
+}
+
+// PinUserResponse represents the result of a pin/unpin operation
+type PinUserResponse struct {
+	Success bool    `json:"success"`
+	Error   *string `json:"error"`
+}
+
+// PinUser routes all traffic of a user through the given outbound,
+// replacing any previous pin for that user
+func (s *RoutingService) PinUser(ctx context.Context, req *PinUserRequest) (*PinUserResponse, error) {
+	req.Username = s.usernames.Normalize(req.Username)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		errMsg := "Xray not running"
+		return &PinUserResponse{Success: false, Error: &errMsg}, nil
+	}
+
+	if current, exists := s.pins[req.Username]; exists && current == req.OutboundTag {
+		return &PinUserResponse{Success: true, Error: nil}, nil
+	}
+
+	ruleTag := userRuleTag(req.Username)
+	if _, exists := s.pins[req.Username]; exists {
+		_ = s.xrayCore.RemoveRoutingRule(ctx, ruleTag)
+		delete(s.pins, req.Username)
+	}
+
+	if err := s.xrayCore.AddUserRoutingRule(ctx, ruleTag, []string{req.Username}, req.OutboundTag); err != nil {
+		s.logger.Error("Failed to pin user to outbound",
+			zap.String("username", req.Username),
+			zap.String("outbound", req.OutboundTag),
+			zap.Error(err))
+		errMsg := err.Error()
+		return &PinUserResponse{Success: false, Error: &errMsg}, nil
+	}
+
+	s.pins[req.Username] = req.OutboundTag
+	s.logger.Info("Pinned user to outbound",
+		zap.String("username", req.Username),
+		zap.String("outbound", req.OutboundTag))
+
+	return &PinUserResponse{Success: true, Error: nil}, nil
+}
+
+// UnpinUserRequest represents a request to remove a user's outbound pin
+type UnpinUserRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// UnpinUser removes a user's outbound pin
+func (s *RoutingService) UnpinUser(ctx context.Context, req *UnpinUserRequest) (*PinUserResponse, error) {
+	req.Username = s.usernames.Normalize(req.Username)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.pins[req.Username]; !exists {
+		return &PinUserResponse{Success: true, Error: nil}, nil
+	}
+
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		if err := s.xrayCore.RemoveRoutingRule(ctx, userRuleTag(req.Username)); err != nil {
+			s.logger.Error("Failed to unpin user",
+				zap.String("username", req.Username),
+				zap.Error(err))
+			errMsg := err.Error()
+			return &PinUserResponse{Success: false, Error: &errMsg}, nil
+		}
+	}
+
+	delete(s.pins, req.Username)
+	s.logger.Info("Unpinned user", zap.String("username", req.Username))
+
+	return &PinUserResponse{Success: true, Error: nil}, nil
+}
+
+// PinnedUser represents a user pinned to an outbound
+type PinnedUser struct {
+	Username    string `json:"username"`
+	OutboundTag string `json:"outboundTag"`
+}
+
+// GetPinnedUsersResponse represents all pinned users
+type GetPinnedUsersResponse struct {
+	Users []*PinnedUser `json:"users"`
+}
+
+// GetPinnedUsers returns all pinned users
+func (s *RoutingService) GetPinnedUsers() *GetPinnedUsersResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*PinnedUser, 0, len(s.pins))
+	for username, tag := range s.pins {
+		users = append(users, &PinnedUser{Username: username, OutboundTag: tag})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	return &GetPinnedUsersResponse{Users: users}
+}
+
+// Runtime rule owners
+const (
+	RuleOwnerVision    = "vision"
+	RuleOwnerUserRoute = "userRoute"
+	RuleOwnerWarp      = "warp"
+	RuleOwnerOther     = "other"
+)
+
+// ActiveRoutingRule is a rule added to the running core's router, with the
+// feature that added it
+type ActiveRoutingRule struct {
+	*xraycore.RoutingRule
+	Owner string `json:"owner"`
+}
+
+// GetRoutingRulesResponse lists the rules added to the running core
+type GetRoutingRulesResponse struct {
+	Rules []*ActiveRoutingRule `json:"rules"`
+}
+
+// GetRoutingRules returns the Vision blocks, user pins and other rules added
+// to the running core's router, so what is actually active can be checked
+// against what the panel believes it installed. Rules from the config
+// itself are not included.
+func (s *RoutingService) GetRoutingRules(ctx context.Context) *GetRoutingRulesResponse {
+	rules := make([]*ActiveRoutingRule, 0)
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return &GetRoutingRulesResponse{Rules: rules}
+	}
+
+	for _, rule := range s.xrayCore.RoutingRules(ctx) {
+		rules = append(rules, &ActiveRoutingRule{RoutingRule: rule, Owner: ruleOwner(rule)})
+	}
+	return &GetRoutingRulesResponse{Rules: rules}
+}
+
+// ruleOwner returns the feature that added a runtime rule, from its tag
+func ruleOwner(rule *xraycore.RoutingRule) string {
+	switch {
+	case strings.HasPrefix(rule.RuleTag, userRuleTagPrefix):
+		return RuleOwnerUserRoute
+	case rule.RuleTag == warpRuleTag:
+		return RuleOwnerWarp
+	case rule.Matchers["source"] != nil:
+		return RuleOwnerVision
+	default:
+		return RuleOwnerOther
+	}
+}
@@ -0,0 +1,309 @@
+// Package services provides business logic for inbound self-testing
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet/reality"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// selfTestTimeout bounds each inbound check
+const selfTestTimeout = 5 * time.Second
+
+// SelfTestService checks each inbound of the running core by connecting to its
+// listener over loopback and completing the transport handshake (TCP, TLS or
+// REALITY), catching broken certificates and REALITY settings
+type SelfTestService struct {
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+
+	// Background prober
+	probeInterval time.Duration
+	stopProber    chan struct{}
+	healthMu      sync.RWMutex
+	health        map[string]bool // inbound tag -> last probe succeeded
+}
+
+// SelfTestConfig holds SelfTest service configuration
+type SelfTestConfig struct {
+	ProbeInterval time.Duration // 0 disables the background prober
+}
+
+// NewSelfTestService creates a new SelfTestService
+func NewSelfTestService(cfg *SelfTestConfig, xrayCore *xraycore.Instance, logger *zap.Logger) *SelfTestService {
+	return &SelfTestService{
+		logger:        logger,
+		xrayCore:      xrayCore,
+		probeInterval: cfg.ProbeInterval,
+	}
+}
+
+// StartProber runs the self-test periodically in the background, if enabled
+func (s *SelfTestService) StartProber() {
+	if s.probeInterval <= 0 || s.stopProber != nil {
+		return
+	}
+
+	s.stopProber = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.probeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.probe()
+			}
+		}
+	}(s.stopProber)
+
+	s.logger.Info("Inbound prober started", zap.Duration("interval", s.probeInterval))
+}
+
+// StopProber stops the background prober
+func (s *SelfTestService) StopProber() {
+	if s.stopProber != nil {
+		close(s.stopProber)
+		s.stopProber = nil
+	}
+}
+
+// probe runs one self-test pass and records per-inbound health, logging transitions
+func (s *SelfTestService) probe() {
+	resp, err := s.Run(context.Background())
+	if err != nil {
+		s.logger.Warn("Inbound probe failed", zap.Error(err))
+		return
+	}
+
+	health := make(map[string]bool, len(resp.Inbounds))
+	for _, result := range resp.Inbounds {
+		health[result.Tag] = result.Success
+	}
+
+	s.healthMu.Lock()
+	previous := s.health
+	s.health = health
+	s.healthMu.Unlock()
+
+	for tag, healthy := range health {
+		wasHealthy, known := previous[tag]
+		switch {
+		case !healthy && (!known || wasHealthy):
+			s.logger.Warn("Inbound became unhealthy", zap.String("tag", tag))
+		case healthy && known && !wasHealthy:
+			s.logger.Info("Inbound recovered", zap.String("tag", tag))
+		}
+	}
+}
+
+// InboundHealth returns the per-inbound result of the last probe, or nil if
+// the prober is disabled or has not run yet
+func (s *SelfTestService) InboundHealth() map[string]bool {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+
+	if s.health == nil {
+		return nil
+	}
+	health := make(map[string]bool, len(s.health))
+	for tag, healthy := range s.health {
+		health[tag] = healthy
+	}
+	return health
+}
+
+// InboundTestResult is the outcome of checking one inbound
+type InboundTestResult struct {
+	Tag        string     `json:"tag"`
+	Protocol   string     `json:"protocol"`
+	Security   string     `json:"security"`
+	Success    bool       `json:"success"`
+	Skipped    bool       `json:"skipped,omitempty"`
+	Error      *string    `json:"error"`
+	LatencyMs  int64      `json:"latencyMs"`
+	CertExpiry *time.Time `json:"certExpiry,omitempty"` // TLS only
+}
+
+// SelfTestResponse represents the results of a self-test run
+type SelfTestResponse struct {
+	Inbounds []*InboundTestResult `json:"inbounds"`
+}
+
+// selfTestInbound is the subset of an inbound needed for a check
+type selfTestInbound struct {
+	Tag            string      `json:"tag"`
+	Protocol       string      `json:"protocol"`
+	Listen         string      `json:"listen"`
+	Port           interface{} `json:"port"`
+	StreamSettings struct {
+		Network     string `json:"network"`
+		Security    string `json:"security"`
+		TLSSettings struct {
+			ServerName string `json:"serverName"`
+		} `json:"tlsSettings"`
+		RealitySettings struct {
+			ServerNames []string `json:"serverNames"`
+			ShortIds    []string `json:"shortIds"`
+			PrivateKey  string   `json:"privateKey"`
+		} `json:"realitySettings"`
+	} `json:"streamSettings"`
+}
+
+// Run checks every client-facing inbound of the running core
+func (s *SelfTestService) Run(ctx context.Context) (*SelfTestResponse, error) {
+	results := make([]*InboundTestResult, 0)
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return &SelfTestResponse{Inbounds: results}, nil
+	}
+
+	var config struct {
+		Inbounds []selfTestInbound `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	for _, inbound := range config.Inbounds {
+		switch inbound.Protocol {
+		case "vless", "vmess", "trojan", "shadowsocks":
+		default:
+			continue // API, dokodemo and other internal inbounds
+		}
+		results = append(results, s.testInbound(ctx, &inbound))
+	}
+
+	return &SelfTestResponse{Inbounds: results}, nil
+}
+
+// testInbound runs the transport handshake against one inbound
+func (s *SelfTestService) testInbound(ctx context.Context, inbound *selfTestInbound) *InboundTestResult {
+	security := inbound.StreamSettings.Security
+	if security == "" {
+		security = "none"
+	}
+	result := &InboundTestResult{
+		Tag:      inbound.Tag,
+		Protocol: inbound.Protocol,
+		Security: security,
+	}
+	fail := func(err error) *InboundTestResult {
+		errMsg := err.Error()
+		result.Error = &errMsg
+		s.logger.Warn("Inbound self-test failed",
+			zap.String("tag", inbound.Tag),
+			zap.Error(err))
+		return result
+	}
+
+	switch inbound.StreamSettings.Network {
+	case "kcp", "mkcp", "quic":
+		// UDP transports have no loopback handshake to check
+		result.Skipped = true
+		result.Success = true
+		return result
+	}
+
+	// Port-hopping inbounds are probed on the first port of their range
+	ranges, err := parsePortSpec(inbound.Port)
+	if err != nil || len(ranges) == 0 {
+		result.Skipped = true
+		result.Success = true
+		return result
+	}
+
+	port := ranges[0].From
+
+	host := "127.0.0.1"
+	if ip := net.ParseIP(inbound.Listen); ip != nil && !ip.IsUnspecified() {
+		host = ip.String()
+	}
+	address := net.JoinHostPort(host, fmt.Sprint(port))
+
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(selfTestTimeout))
+
+	switch security {
+	case "tls":
+		serverName := inbound.StreamSettings.TLSSettings.ServerName
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: serverName,
+			// Without a server name there is nothing to verify the certificate against
+			InsecureSkipVerify: serverName == "",
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fail(err)
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			expiry := certs[0].NotAfter
+			result.CertExpiry = &expiry
+		}
+	case "reality":
+		realityConfig, err := realityClientConfig(inbound)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := reality.UClient(conn, realityConfig, ctx, xnet.TCPDestination(xnet.ParseAddress(host), xnet.Port(port))); err != nil {
+			return fail(err)
+		}
+	}
+
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.Success = true
+	return result
+}
+
+// realityClientConfig builds a REALITY client config matching an inbound's server settings
+func realityClientConfig(inbound *selfTestInbound) (*reality.Config, error) {
+	settings := inbound.StreamSettings.RealitySettings
+	if len(settings.ServerNames) == 0 {
+		return nil, fmt.Errorf("REALITY inbound has no serverNames")
+	}
+
+	publicKey, err := realityPublicKey(settings.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REALITY privateKey: %w", err)
+	}
+	publicKeyBytes, _ := base64.RawURLEncoding.DecodeString(publicKey)
+
+	var shortID []byte
+	if len(settings.ShortIds) > 0 {
+		shortID, err = hex.DecodeString(settings.ShortIds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid REALITY shortId: %w", err)
+		}
+	}
+
+	return &reality.Config{
+		Fingerprint: "chrome",
+		ServerName:  settings.ServerNames[0],
+		PublicKey:   publicKeyBytes,
+		ShortId:     shortID,
+		SpiderX:     "/",
+		SpiderY:     make([]int64, 10),
+	}, nil
+}
@@ -0,0 +1,234 @@
+// Package services provides business logic for kernel-level session views
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/conntrack"
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// defaultSessionLimit is how many sessions are listed per inbound by default
+const defaultSessionLimit = 100
+
+// SessionService shows the connections the kernel tracks to each inbound
+// port next to the core's counters for that inbound, for diagnosing traffic
+// that reaches the node but is not counted by Xray
+type SessionService struct {
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+	flags    *featureflags.Set
+}
+
+// NewSessionService creates a new SessionService
+func NewSessionService(xrayCore *xraycore.Instance, flags *featureflags.Set, logger *zap.Logger) *SessionService {
+	return &SessionService{
+		logger:   logger,
+		xrayCore: xrayCore,
+		flags:    flags,
+	}
+}
+
+// GetSessionsRequest selects the sessions to list
+type GetSessionsRequest struct {
+	Tag   string `form:"tag"`   // Only this inbound; empty lists all
+	Limit int    `form:"limit"` // Sessions listed per inbound; 0 uses the default
+}
+
+// KernelSession is a connection to an inbound port as tracked by the kernel
+type KernelSession struct {
+	Protocol   string `json:"protocol"`
+	State      string `json:"state,omitempty"`
+	SourceIP   string `json:"sourceIp"`
+	SourcePort uint16 `json:"sourcePort"`
+	Port       uint16 `json:"port"`
+	DurationS  *int64 `json:"durationSeconds,omitempty"` // Needs nf_conntrack_timestamp
+	Uplink     *int64 `json:"uplink,omitempty"`          // Bytes from the client; needs nf_conntrack_acct
+	Downlink   *int64 `json:"downlink,omitempty"`        // Bytes to the client; needs nf_conntrack_acct
+}
+
+// InboundSessions is the kernel's view of one inbound next to the core's
+type InboundSessions struct {
+	Tag            string           `json:"tag"`
+	Ports          []PortRange      `json:"ports"`
+	Sessions       int              `json:"sessions"`
+	KernelUplink   int64            `json:"kernelUplink"`
+	KernelDownlink int64            `json:"kernelDownlink"`
+	XrayUplink     *int64           `json:"xrayUplink"` // nil when the core has no counters for the inbound
+	XrayDownlink   *int64           `json:"xrayDownlink"`
+	Warning        string           `json:"warning,omitempty"`
+	List           []*KernelSession `json:"list"` // Longest-running first, up to the limit
+}
+
+// GetSessionsResponse is the kernel's view of all inbounds
+type GetSessionsResponse struct {
+	Enabled  bool               `json:"enabled"`
+	Counted  bool               `json:"counted"` // Kernel byte counters available (nf_conntrack_acct)
+	Timed    bool               `json:"timed"`   // Session durations available (nf_conntrack_timestamp)
+	Inbounds []*InboundSessions `json:"inbounds"`
+}
+
+// inboundPorts is a running inbound and the ports it listens on
+type inboundPorts struct {
+	Tag    string
+	Ranges []PortRange
+}
+
+// runningInboundPorts returns the inbounds of the running config that listen
+// on ports. Unix sockets and ports the core resolves itself are left out.
+func runningInboundPorts(xrayCore *xraycore.Instance) ([]inboundPorts, error) {
+	var config struct {
+		Inbounds []struct {
+			Tag  string      `json:"tag"`
+			Port interface{} `json:"port"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	var inbounds []inboundPorts
+	for _, inbound := range config.Inbounds {
+		ranges, err := parsePortSpec(inbound.Port)
+		if inbound.Tag == "" || err != nil || len(ranges) == 0 {
+			continue
+		}
+		inbounds = append(inbounds, inboundPorts{Tag: inbound.Tag, Ranges: ranges})
+	}
+	return inbounds, nil
+}
+
+// inboundTraffic returns an inbound's counters from the result of
+// GetStats(ctx, "inbound>>>", false), nil when the core keeps none for it
+func inboundTraffic(counters map[string]int64, tag string) (uplink, downlink *int64) {
+	up, hasUplink := counters["inbound>>>"+tag+">>>traffic>>>uplink"]
+	down, hasDownlink := counters["inbound>>>"+tag+">>>traffic>>>downlink"]
+	if !hasUplink && !hasDownlink {
+		return nil, nil
+	}
+	return &up, &down
+}
+
+// GetSessions lists the kernel-tracked connections to the running core's
+// inbound ports. Kernel byte counts include protocol and TLS overhead, so
+// they run higher than Xray's; an inbound with kernel traffic and no Xray
+// traffic at all is flagged.
+func (s *SessionService) GetSessions(ctx context.Context, req *GetSessionsRequest) (*GetSessionsResponse, error) {
+	resp := &GetSessionsResponse{Enabled: s.flags.Enabled(FlagConntrackSessions), Inbounds: make([]*InboundSessions, 0)}
+	if !resp.Enabled || s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return resp, nil
+	}
+
+	inbounds, err := runningInboundPorts(s.xrayCore)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := conntrack.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conntrack table: %w", err)
+	}
+
+	counters, err := s.xrayCore.GetStats(ctx, "inbound>>>", false)
+	if err != nil {
+		s.logger.Warn("Failed to read inbound counters", zap.Error(err))
+	}
+
+	// Connections the node opens to remote ports are tracked too; only those
+	// addressed to the node (or DNATed to it, where the reply comes from it)
+	// reach an inbound
+	local := localAddrs()
+	toNode := func(entry *conntrack.Entry) bool {
+		return local[entry.Orig.Dst.Unmap()] || local[entry.Reply.Src.Unmap()]
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultSessionLimit
+	}
+
+	for _, inbound := range inbounds {
+		if req.Tag != "" && inbound.Tag != req.Tag {
+			continue
+		}
+
+		result := &InboundSessions{Tag: inbound.Tag, Ports: inbound.Ranges, List: make([]*KernelSession, 0)}
+		for _, entry := range entries {
+			if !portRangesContain(inbound.Ranges, int(entry.Orig.DstPort)) || !toNode(entry) {
+				continue
+			}
+			session := &KernelSession{
+				Protocol:   entry.Protocol,
+				State:      entry.State,
+				SourceIP:   entry.Orig.Src.String(),
+				SourcePort: entry.Orig.SrcPort,
+				Port:       entry.Orig.DstPort,
+			}
+			if entry.AgeKnown {
+				resp.Timed = true
+				seconds := int64(entry.Age.Seconds())
+				session.DurationS = &seconds
+			}
+			if entry.Counted {
+				resp.Counted = true
+				uplink, downlink := int64(entry.Orig.Bytes), int64(entry.Reply.Bytes)
+				session.Uplink, session.Downlink = &uplink, &downlink
+				result.KernelUplink += uplink
+				result.KernelDownlink += downlink
+			}
+			result.Sessions++
+			result.List = append(result.List, session)
+		}
+
+		sort.SliceStable(result.List, func(i, j int) bool {
+			a, b := result.List[i].DurationS, result.List[j].DurationS
+			return a != nil && (b == nil || *a > *b)
+		})
+		if len(result.List) > limit {
+			result.List = result.List[:limit]
+		}
+
+		result.XrayUplink, result.XrayDownlink = inboundTraffic(counters, inbound.Tag)
+		result.Warning = trafficWarning(result.Sessions > 0, result.KernelUplink+result.KernelDownlink, result.XrayUplink, result.XrayDownlink)
+
+		resp.Inbounds = append(resp.Inbounds, result)
+	}
+
+	return resp, nil
+}
+
+// localAddrs returns the addresses of the node's interfaces
+func localAddrs() map[netip.Addr]bool {
+	addrs := make(map[netip.Addr]bool)
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return addrs
+	}
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			if addr, ok := netip.AddrFromSlice(ipNet.IP); ok {
+				addrs[addr.Unmap()] = true
+			}
+		}
+	}
+	return addrs
+}
+
+// trafficWarning compares the kernel's view of an inbound with the core's
+func trafficWarning(active bool, kernelBytes int64, xrayUplink, xrayDownlink *int64) string {
+	switch {
+	case active && xrayUplink == nil:
+		return "no Xray counters for this inbound, enable inbound stats to compare"
+	case kernelBytes > 0 && xrayUplink != nil && *xrayUplink+*xrayDownlink == 0:
+		return "kernel sees traffic but Xray counted none"
+	}
+	return ""
+}
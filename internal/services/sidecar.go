@@ -0,0 +1,852 @@
+// Package services provides business logic for sidecar proxy cores
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+)
+
+// Sidecar core types
+const (
+	SidecarHysteria2 = "hysteria2"
+	SidecarTUIC      = "tuic"
+	SidecarSingBox   = "sing-box"
+)
+
+// sidecarRestartDelay is how long the supervisor waits before restarting a crashed core
+const sidecarRestartDelay = 5 * time.Second
+
+// sidecarApplyDelay batches user changes: a running sidecar is restarted once
+// this long after the first change, applying every change made meanwhile
+const sidecarApplyDelay = time.Second
+
+// SidecarDefinition describes an additional proxy core supervised next to Xray
+type SidecarDefinition struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`       // hysteria2, tuic or sing-box
+	Binary     string   `json:"binary"`     // Path to the core executable
+	Args       []string `json:"args"`       // "{{config}}" is replaced with ConfigPath
+	Template   string   `json:"template"`   // text/template rendered into ConfigPath
+	ConfigPath string   `json:"configPath"` // Rendered config location
+	Autostart  bool     `json:"autostart"`
+
+	// Traffic stats API (hysteria2 trafficStats), optional
+	StatsURL    string `json:"statsUrl,omitempty"`
+	StatsSecret string `json:"statsSecret,omitempty"`
+}
+
+// SidecarUser is a credential rendered into sidecar configs
+type SidecarUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// sidecarTemplateData is passed to sidecar config templates
+type sidecarTemplateData struct {
+	Name  string
+	Users []SidecarUser
+}
+
+// sidecar is the runtime state of one supervised core
+type sidecar struct {
+	def      SidecarDefinition
+	tmpl     *template.Template
+	users    map[string]SidecarUser // username -> credential
+	cmd      *exec.Cmd
+	adopted  *os.Process // left running by a previous agent; not a child, so polled instead of waited on
+	running  bool
+	stopping bool
+	exited   chan struct{}
+	lastErr  string
+	applying *time.Timer // Pending restart applying user changes (see scheduleApply)
+}
+
+// SidecarService supervises additional proxy cores (hysteria2, tuic, sing-box)
+type SidecarService struct {
+	mu             sync.Mutex
+	logger         *zap.Logger
+	sidecars       map[string]*sidecar
+	keepOnShutdown bool
+}
+
+// SidecarConfig holds Sidecar service configuration
+type SidecarConfig struct {
+	DefinitionsPath string // JSON array of SidecarDefinition; empty disables sidecars
+	KeepOnShutdown  bool   // Leave sidecars running on shutdown and adopt them on the next start
+}
+
+// NewSidecarService creates a new SidecarService and loads sidecar definitions
+func NewSidecarService(cfg *SidecarConfig, logger *zap.Logger) (*SidecarService, error) {
+	s := &SidecarService{
+		logger:         logger,
+		sidecars:       make(map[string]*sidecar),
+		keepOnShutdown: cfg.KeepOnShutdown,
+	}
+
+	if cfg.DefinitionsPath == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(cfg.DefinitionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sidecar definitions: %w", err)
+	}
+
+	var defs []SidecarDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse sidecar definitions: %w", err)
+	}
+
+	for _, def := range defs {
+		if err := s.register(def); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// register validates a definition and adds it to the service
+func (s *SidecarService) register(def SidecarDefinition) error {
+	switch def.Type {
+	case SidecarHysteria2, SidecarTUIC, SidecarSingBox:
+	default:
+		return fmt.Errorf("sidecar %q: unsupported type %q", def.Name, def.Type)
+	}
+	if def.Name == "" || def.Binary == "" || def.ConfigPath == "" {
+		return fmt.Errorf("sidecar %q: name, binary and configPath are required", def.Name)
+	}
+	if _, exists := s.sidecars[def.Name]; exists {
+		return fmt.Errorf("duplicate sidecar %q", def.Name)
+	}
+
+	var tmpl *template.Template
+	if def.Template != "" {
+		var err error
+		tmpl, err = template.New(filepath.Base(def.Template)).Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).ParseFiles(def.Template)
+		if err != nil {
+			return fmt.Errorf("sidecar %q: failed to parse template: %w", def.Name, err)
+		}
+	}
+
+	s.sidecars[def.Name] = &sidecar{
+		def:   def,
+		tmpl:  tmpl,
+		users: make(map[string]SidecarUser),
+	}
+	return nil
+}
+
+// Enabled returns true if any sidecar is configured
+func (s *SidecarService) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sidecars) > 0
+}
+
+// KeepsRunning reports whether sidecars are left running on shutdown, keeping
+// their traffic counters for the next agent to adopt
+func (s *SidecarService) KeepsRunning() bool {
+	return s.keepOnShutdown
+}
+
+// renderConfig writes the sidecar config from its template (caller must hold the lock)
+func (s *SidecarService) renderConfig(sc *sidecar) error {
+	if sc.tmpl == nil {
+		return nil
+	}
+
+	users := make([]SidecarUser, 0, len(sc.users))
+	for _, u := range sc.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	var buf bytes.Buffer
+	if err := sc.tmpl.Execute(&buf, sidecarTemplateData{Name: sc.def.Name, Users: users}); err != nil {
+		return fmt.Errorf("failed to render config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sc.def.ConfigPath), 0755); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(sc.def.ConfigPath, buf.Bytes(), 0600, false)
+}
+
+// sidecarArgs returns the arguments a sidecar is started with
+func sidecarArgs(def SidecarDefinition) []string {
+	args := make([]string, len(def.Args))
+	for i, arg := range def.Args {
+		if arg == "{{config}}" {
+			arg = def.ConfigPath
+		}
+		args[i] = arg
+	}
+	return args
+}
+
+// spawn starts the sidecar process and its supervisor goroutine (caller must hold the lock)
+func (s *SidecarService) spawn(sc *sidecar) error {
+	cmd := exec.Command(sc.def.Binary, sidecarArgs(sc.def)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		sc.lastErr = err.Error()
+		return fmt.Errorf("failed to start sidecar %q: %w", sc.def.Name, err)
+	}
+
+	sc.cmd = cmd
+	sc.adopted = nil
+	sc.running = true
+	sc.stopping = false
+	sc.lastErr = ""
+	sc.exited = make(chan struct{})
+
+	if err := writeSidecarPid(sc, cmd.Process.Pid); err != nil {
+		s.logger.Warn("Failed to write sidecar pid file", zap.String("name", sc.def.Name), zap.Error(err))
+	}
+
+	go s.supervise(sc, cmd, sc.exited)
+
+	s.logger.Info("Sidecar started",
+		zap.String("name", sc.def.Name),
+		zap.String("type", sc.def.Type),
+		zap.Int("pid", cmd.Process.Pid))
+	return nil
+}
+
+// supervise waits for the process to exit and restarts it unless it was stopped
+func (s *SidecarService) supervise(sc *sidecar, cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+	close(exited)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sc.cmd != cmd {
+		return
+	}
+	sc.running = false
+	removeSidecarPid(sc)
+	if sc.stopping {
+		return
+	}
+
+	if err != nil {
+		sc.lastErr = err.Error()
+	}
+	s.logger.Warn("Sidecar exited unexpectedly, restarting",
+		zap.String("name", sc.def.Name),
+		zap.Error(err))
+
+	time.AfterFunc(sidecarRestartDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sc.cmd != cmd || sc.stopping || sc.running {
+			return
+		}
+		if err := s.spawn(sc); err != nil {
+			s.logger.Error("Failed to restart sidecar", zap.String("name", sc.def.Name), zap.Error(err))
+		}
+	})
+}
+
+// terminate stops the sidecar process and waits for it to exit (caller must hold the lock;
+// the lock is released while waiting)
+func (s *SidecarService) terminate(sc *sidecar) {
+	if sc.running && sc.adopted != nil {
+		s.terminateAdopted(sc)
+		return
+	}
+	if !sc.running || sc.cmd == nil {
+		sc.stopping = true
+		return
+	}
+
+	sc.stopping = true
+	cmd, exited := sc.cmd, sc.exited
+	_ = cmd.Process.Signal(os.Interrupt)
+
+	s.mu.Unlock()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+	s.mu.Lock()
+}
+
+// Start renders the config and starts a sidecar
+func (s *SidecarService) Start(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, exists := s.sidecars[name]
+	if !exists {
+		return fmt.Errorf("unknown sidecar %q", name)
+	}
+	if sc.running {
+		return nil
+	}
+
+	if proc := findSidecarProcess(sc); proc != nil {
+		s.adopt(sc, proc)
+		return nil
+	}
+
+	if err := s.renderConfig(sc); err != nil {
+		sc.lastErr = err.Error()
+		return fmt.Errorf("sidecar %q: %w", name, err)
+	}
+	return s.spawn(sc)
+}
+
+// sidecarPidPath returns where the pid of a sidecar is recorded for adoption
+func sidecarPidPath(sc *sidecar) string {
+	return sc.def.ConfigPath + ".pid"
+}
+
+// writeSidecarPid records the pid of a started sidecar
+func writeSidecarPid(sc *sidecar, pid int) error {
+	return atomicfile.WriteFile(sidecarPidPath(sc), []byte(strconv.Itoa(pid)), 0644, false)
+}
+
+// removeSidecarPid removes the pid file of a sidecar that is no longer running
+func removeSidecarPid(sc *sidecar) {
+	_ = os.Remove(sidecarPidPath(sc))
+}
+
+// findSidecarProcess returns the sidecar process left running by a previous
+// agent, if its pid file points to a live process running the sidecar. After
+// a reboot or pid reuse the pid may belong to an unrelated process, which
+// must never be adopted and later signaled, so the pid file is discarded.
+func findSidecarProcess(sc *sidecar) *os.Process {
+	data, err := os.ReadFile(sidecarPidPath(sc))
+	if err != nil {
+		return nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return nil
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+	if !processAlive(proc) || !isSidecarProcess(sc.def, pid) {
+		removeSidecarPid(sc)
+		return nil
+	}
+	return proc
+}
+
+// isSidecarProcess reports whether a process runs the sidecar binary with
+// the sidecar's arguments, from /proc/<pid>/exe and cmdline. Without /proc
+// (other platforms) nothing matches, so sidecars are started fresh there.
+func isSidecarProcess(def SidecarDefinition, pid int) bool {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	exe, err := os.Readlink(filepath.Join(procDir, "exe"))
+	if err != nil {
+		return false
+	}
+	// The binary may have been replaced by an upgrade since it was started
+	exe = strings.TrimSuffix(exe, " (deleted)")
+
+	binary, err := exec.LookPath(def.Binary)
+	if err != nil {
+		return false
+	}
+	if binary, err = filepath.Abs(binary); err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+	if exe != binary {
+		return false
+	}
+
+	cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline"))
+	if err != nil {
+		return false
+	}
+	argv := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	if len(argv) == 0 {
+		return false
+	}
+	return slices.Equal(argv[1:], sidecarArgs(def))
+}
+
+// processAlive reports whether a process still exists. Platforms without
+// signal 0 (Windows) always report false, so sidecars are started fresh there.
+func processAlive(proc *os.Process) bool {
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// adopt takes over a sidecar left running by a previous agent (caller must hold the lock)
+func (s *SidecarService) adopt(sc *sidecar, proc *os.Process) {
+	sc.cmd = nil
+	sc.adopted = proc
+	sc.running = true
+	sc.stopping = false
+	sc.lastErr = ""
+	sc.exited = make(chan struct{})
+
+	go s.watchAdopted(sc, proc, sc.exited)
+
+	s.logger.Info("Adopted running sidecar",
+		zap.String("name", sc.def.Name),
+		zap.String("type", sc.def.Type),
+		zap.Int("pid", proc.Pid))
+}
+
+// watchAdopted polls an adopted process and starts a fresh one if it dies,
+// like supervise does for children
+func (s *SidecarService) watchAdopted(sc *sidecar, proc *os.Process, exited chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// A reused pid is not the sidecar any more
+		if processAlive(proc) && isSidecarProcess(sc.def, proc.Pid) {
+			continue
+		}
+		close(exited)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if sc.adopted != proc {
+			return
+		}
+		sc.adopted = nil
+		sc.running = false
+		removeSidecarPid(sc)
+		if sc.stopping {
+			return
+		}
+
+		s.logger.Warn("Adopted sidecar exited, restarting", zap.String("name", sc.def.Name))
+		if err := s.renderConfig(sc); err != nil {
+			sc.lastErr = err.Error()
+			s.logger.Error("Failed to restart sidecar", zap.String("name", sc.def.Name), zap.Error(err))
+			return
+		}
+		if err := s.spawn(sc); err != nil {
+			s.logger.Error("Failed to restart sidecar", zap.String("name", sc.def.Name), zap.Error(err))
+		}
+		return
+	}
+}
+
+// terminateAdopted stops an adopted sidecar and waits for it to exit (caller must
+// hold the lock; the lock is released while waiting)
+func (s *SidecarService) terminateAdopted(sc *sidecar) {
+	sc.stopping = true
+	proc, exited := sc.adopted, sc.exited
+	_ = proc.Signal(os.Interrupt)
+
+	s.mu.Unlock()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		if isSidecarProcess(sc.def, proc.Pid) {
+			_ = proc.Kill()
+		}
+		<-exited
+	}
+	s.mu.Lock()
+}
+
+// Stop stops a sidecar
+func (s *SidecarService) Stop(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, exists := s.sidecars[name]
+	if !exists {
+		return fmt.Errorf("unknown sidecar %q", name)
+	}
+	s.terminate(sc)
+	s.logger.Info("Sidecar stopped", zap.String("name", name))
+	return nil
+}
+
+// restart re-renders the config and restarts a running sidecar so it picks up changes
+// (caller must hold the lock)
+func (s *SidecarService) restart(sc *sidecar) error {
+	if sc.applying != nil {
+		sc.applying.Stop()
+		sc.applying = nil
+	}
+	if err := s.renderConfig(sc); err != nil {
+		return err
+	}
+	if !sc.running {
+		return nil
+	}
+	s.terminate(sc)
+	return s.spawn(sc)
+}
+
+// StartAll starts all sidecars marked autostart
+func (s *SidecarService) StartAll() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.sidecars))
+	for name, sc := range s.sidecars {
+		if sc.def.Autostart {
+			names = append(names, name)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, name := range names {
+		if err := s.Start(name); err != nil {
+			s.logger.Error("Failed to autostart sidecar", zap.String("name", name), zap.Error(err))
+		}
+	}
+}
+
+// StopAll stops all sidecars
+func (s *SidecarService) StopAll() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.sidecars))
+	for name := range s.sidecars {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	for _, name := range names {
+		_ = s.Stop(name)
+	}
+}
+
+// Shutdown stops all sidecars, or leaves them running for the next agent to
+// adopt when configured to keep cores on shutdown
+func (s *SidecarService) Shutdown() {
+	if !s.keepOnShutdown {
+		s.StopAll()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.sidecars {
+		// Keeps the supervisors from restarting anything while the agent exits
+		sc.stopping = true
+	}
+	s.logger.Info("Leaving sidecars running for the next start")
+}
+
+// SetUsers replaces the users of all sidecars and restarts running ones to apply them
+func (s *SidecarService) SetUsers(users []SidecarUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for _, sc := range s.sidecars {
+		sc.users = make(map[string]SidecarUser, len(users))
+		for _, u := range users {
+			sc.users[u.Username] = u
+		}
+		if err := s.restart(sc); err != nil {
+			sc.lastErr = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("sidecar %q: %w", sc.def.Name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// CoreResult is the outcome of a user operation on one core
+type CoreResult struct {
+	Core    string  `json:"core"`
+	Success bool    `json:"success"`
+	Error   *string `json:"error"`
+}
+
+// newCoreResult builds a CoreResult from an error
+func newCoreResult(core string, err error) *CoreResult {
+	if err != nil {
+		errMsg := err.Error()
+		return &CoreResult{Core: core, Success: false, Error: &errMsg}
+	}
+	return &CoreResult{Core: core, Success: true}
+}
+
+// applyUsers runs fn on every sidecar's user set and renders the configs,
+// returning a result per sidecar. Running sidecars are restarted in the
+// background once per batch of changes (see scheduleApply).
+func (s *SidecarService) applyUsers(fn func(users map[string]SidecarUser)) []*CoreResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.sidecars))
+	for name := range s.sidecars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]*CoreResult, 0, len(names))
+	for _, name := range names {
+		sc := s.sidecars[name]
+		fn(sc.users)
+		err := s.renderConfig(sc)
+		if err != nil {
+			sc.lastErr = err.Error()
+			s.logger.Warn("Failed to apply users to sidecar", zap.String("name", name), zap.Error(err))
+		} else if sc.running {
+			s.scheduleApply(sc)
+		}
+		results = append(results, newCoreResult(name, err))
+	}
+	return results
+}
+
+// scheduleApply restarts a sidecar after sidecarApplyDelay unless a restart is
+// already pending, so a burst of user changes costs one restart (caller must
+// hold the lock)
+func (s *SidecarService) scheduleApply(sc *sidecar) {
+	if sc.applying != nil {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(sidecarApplyDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sc.applying != timer {
+			return
+		}
+		sc.applying = nil
+		if !sc.running || sc.stopping {
+			return
+		}
+		if err := s.restart(sc); err != nil {
+			sc.lastErr = err.Error()
+			s.logger.Warn("Failed to apply users to sidecar", zap.String("name", sc.def.Name), zap.Error(err))
+		}
+	})
+	sc.applying = timer
+}
+
+// UpsertUsers adds or updates users on all sidecars
+func (s *SidecarService) UpsertUsers(users []SidecarUser) []*CoreResult {
+	return s.applyUsers(func(current map[string]SidecarUser) {
+		for _, u := range users {
+			current[u.Username] = u
+		}
+	})
+}
+
+// RemoveUsers removes users from all sidecars
+func (s *SidecarService) RemoveUsers(usernames []string) []*CoreResult {
+	return s.applyUsers(func(current map[string]SidecarUser) {
+		for _, username := range usernames {
+			delete(current, username)
+		}
+	})
+}
+
+// SidecarUsersFromConfig extracts sidecar credentials from an Xray config so a
+// full config push also resyncs sidecars. The trojan/shadowsocks password is
+// preferred, falling back to the vless id.
+func SidecarUsersFromConfig(config json.RawMessage) []SidecarUser {
+	// Type mismatches leave fields empty, those clients are skipped below
+	var parsed struct {
+		Inbounds []struct {
+			Settings struct {
+				Clients []struct {
+					Email    string `json:"email"`
+					Password string `json:"password"`
+					ID       string `json:"id"`
+				} `json:"clients"`
+			} `json:"settings"`
+		} `json:"inbounds"`
+	}
+	_ = json.Unmarshal(config, &parsed)
+
+	seen := make(map[string]bool)
+	var users []SidecarUser
+	for _, inbound := range parsed.Inbounds {
+		for _, client := range inbound.Settings.Clients {
+			if client.Email == "" || seen[client.Email] {
+				continue
+			}
+			password := client.Password
+			if password == "" {
+				password = client.ID
+			}
+			if password == "" {
+				continue
+			}
+			seen[client.Email] = true
+			users = append(users, SidecarUser{Username: client.Email, Password: password})
+		}
+	}
+	return users
+}
+
+// SidecarStatus represents the state of a sidecar
+type SidecarStatus struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	IsRunning bool   `json:"isRunning"`
+	Pid       int    `json:"pid,omitempty"`
+	Users     int    `json:"users"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// GetSidecarsStatusResponse represents the state of all sidecars
+type GetSidecarsStatusResponse struct {
+	Sidecars []*SidecarStatus `json:"sidecars"`
+}
+
+// GetStatus returns the state of all sidecars
+func (s *SidecarService) GetStatus(ctx context.Context) *GetSidecarsStatusResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*SidecarStatus, 0, len(s.sidecars))
+	for _, sc := range s.sidecars {
+		status := &SidecarStatus{
+			Name:      sc.def.Name,
+			Type:      sc.def.Type,
+			IsRunning: sc.running,
+			Users:     len(sc.users),
+			LastError: sc.lastErr,
+		}
+		if sc.running && sc.cmd != nil && sc.cmd.Process != nil {
+			status.Pid = sc.cmd.Process.Pid
+		} else if sc.running && sc.adopted != nil {
+			status.Pid = sc.adopted.Pid
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return &GetSidecarsStatusResponse{Sidecars: result}
+}
+
+// Pids returns the pids of running sidecars by name
+func (s *SidecarService) Pids() map[string]int {
+	pids := make(map[string]int)
+	for _, status := range s.GetStatus(context.Background()).Sidecars {
+		if status.Pid > 0 {
+			pids[status.Name] = status.Pid
+		}
+	}
+	return pids
+}
+
+// sidecarStatsClient is used for sidecar traffic stats APIs (loopback only)
+var sidecarStatsClient = &http.Client{Timeout: 5 * time.Second}
+
+// GetTraffic collects per-user traffic from all running sidecars that expose a
+// stats API, summed per username. Sidecars without one (tuic) are skipped.
+func (s *SidecarService) GetTraffic(ctx context.Context, reset bool) (map[string]*UserTraffic, error) {
+	s.mu.Lock()
+	defs := make([]SidecarDefinition, 0, len(s.sidecars))
+	for _, sc := range s.sidecars {
+		if sc.running && sc.def.StatsURL != "" {
+			defs = append(defs, sc.def)
+		}
+	}
+	s.mu.Unlock()
+
+	result := make(map[string]*UserTraffic)
+	var firstErr error
+	for _, def := range defs {
+		traffic, err := fetchHysteriaTraffic(ctx, def, reset)
+		if err != nil {
+			s.logger.Warn("Failed to get sidecar traffic", zap.String("name", def.Name), zap.Error(err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("sidecar %q: %w", def.Name, err)
+			}
+			continue
+		}
+		for username, t := range traffic {
+			total, exists := result[username]
+			if !exists {
+				total = &UserTraffic{Username: username}
+				result[username] = total
+			}
+			total.Uplink += t.Uplink
+			total.Downlink += t.Downlink
+		}
+	}
+
+	return result, firstErr
+}
+
+// fetchHysteriaTraffic reads the hysteria2-style trafficStats API:
+// GET /traffic[?clear=1] -> {"user": {"tx": bytes, "rx": bytes}}
+// sing-box's clash API is not per-user, so only this format is supported.
+func fetchHysteriaTraffic(ctx context.Context, def SidecarDefinition, reset bool) (map[string]*UserTraffic, error) {
+	url := def.StatsURL + "/traffic"
+	if reset {
+		url += "?clear=1"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if def.StatsSecret != "" {
+		req.Header.Set("Authorization", def.StatsSecret)
+	}
+
+	resp, err := sidecarStatsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stats API returned %s", resp.Status)
+	}
+
+	var raw map[string]struct {
+		Tx int64 `json:"tx"`
+		Rx int64 `json:"rx"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse stats: %w", err)
+	}
+
+	result := make(map[string]*UserTraffic, len(raw))
+	for username, t := range raw {
+		result[username] = &UserTraffic{Username: username, Uplink: t.Tx, Downlink: t.Rx}
+	}
+	return result, nil
+}
+
+// SidecarRequest identifies a sidecar
+type SidecarRequest struct {
+	Name string `json:"name" binding:"required"`
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSidecar_AdoptsOnlyTheSidecarProcess(t *testing.T) {
+	if _, err := os.Stat("/proc/self/exe"); err != nil {
+		t.Skip("needs /proc")
+	}
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("needs sleep")
+	}
+
+	sc := &sidecar{def: SidecarDefinition{
+		Name:       "hy2",
+		Binary:     sleep,
+		Args:       []string{"30"},
+		ConfigPath: filepath.Join(t.TempDir(), "hy2.yaml"),
+	}}
+
+	// A live process that is not the sidecar, e.g. after a reboot or pid reuse
+	if err := writeSidecarPid(sc, os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	if proc := findSidecarProcess(sc); proc != nil {
+		t.Fatalf("Expected an unrelated process not to be adopted, got pid %d", proc.Pid)
+	}
+	if _, err := os.Stat(sidecarPidPath(sc)); !os.IsNotExist(err) {
+		t.Error("Expected the stale pid file to be discarded")
+	}
+
+	cmd := exec.Command(sleep, sidecarArgs(sc.def)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	if err := os.WriteFile(sidecarPidPath(sc), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if proc := findSidecarProcess(sc); proc == nil || proc.Pid != cmd.Process.Pid {
+		t.Errorf("Expected the sidecar process to be adopted, got %v", proc)
+	}
+
+	// Same binary, different arguments
+	sc.def.Args = []string{"60"}
+	if proc := findSidecarProcess(sc); proc != nil {
+		t.Errorf("Expected a process with other arguments not to be adopted, got pid %d", proc.Pid)
+	}
+}
+
+func TestSidecar_BatchesUserChangesIntoOneRestart(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("needs sleep")
+	}
+
+	dir := t.TempDir()
+	template := filepath.Join(dir, "hy2.tmpl")
+	if err := os.WriteFile(template, []byte("{{range .Users}}{{.Username}}\n{{end}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defs, _ := json.Marshal([]SidecarDefinition{{
+		Name:       "hy2",
+		Type:       SidecarHysteria2,
+		Binary:     sleep,
+		Args:       []string{"30"},
+		Template:   template,
+		ConfigPath: filepath.Join(dir, "hy2.yaml"),
+	}})
+	defsPath := filepath.Join(dir, "sidecars.json")
+	if err := os.WriteFile(defsPath, defs, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSidecarService(&SidecarConfig{DefinitionsPath: defsPath}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start("hy2"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.StopAll)
+	pid := s.Pids()["hy2"]
+
+	s.UpsertUsers([]SidecarUser{{Username: "alice", Password: "a"}})
+	s.UpsertUsers([]SidecarUser{{Username: "bob", Password: "b"}})
+	s.RemoveUsers([]string{"alice"})
+
+	// The config is rendered right away, the restart follows once
+	if data, _ := os.ReadFile(filepath.Join(dir, "hy2.yaml")); string(data) != "bob\n" {
+		t.Errorf("Expected the rendered config to list bob only, got %q", data)
+	}
+	if got := s.Pids()["hy2"]; got != pid {
+		t.Fatalf("Expected no restart before the batch delay, pid %d became %d", pid, got)
+	}
+
+	deadline := time.Now().Add(sidecarApplyDelay + 5*time.Second)
+	for s.Pids()["hy2"] == pid && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	restarted := s.Pids()["hy2"]
+	if restarted == pid || restarted == 0 {
+		t.Fatalf("Expected the sidecar to be restarted once, pid is %d", restarted)
+	}
+
+	time.Sleep(sidecarApplyDelay + 200*time.Millisecond)
+	if got := s.Pids()["hy2"]; got != restarted {
+		t.Errorf("Expected a single restart for the batch, pid %d became %d", restarted, got)
+	}
+}
@@ -0,0 +1,219 @@
+// Package services provides business logic for exporting node state
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Snapshot sections that can be imported selectively
+const (
+	StateSectionHashes     = "hashes"
+	StateSectionUsers      = "users"
+	StateSectionBlockedIPs = "blockedIps"
+	StateSectionUserRoutes = "userRoutes"
+)
+
+// stateSections lists all sections in the order they are applied
+var stateSections = []string{StateSectionHashes, StateSectionUsers, StateSectionBlockedIPs, StateSectionUserRoutes}
+
+// NodeStateFormatVersion is bumped when the snapshot layout changes incompatibly
+const NodeStateFormatVersion = 1
+
+// NodeState is a snapshot of the runtime state a node holds beyond config.json,
+// for support tickets and for seeding a replacement node
+type NodeState struct {
+	FormatVersion int                 `json:"formatVersion"`
+	NodeVersion   string              `json:"nodeVersion"`
+	XrayVersion   string              `json:"xrayVersion"`
+	ExportedAt    time.Time           `json:"exportedAt"`
+	Hashes        *InboundHashes      `json:"hashes"`
+	Inbounds      []string            `json:"inbounds"` // Known inbound tags
+	Users         map[string][]string `json:"users"`    // Tracked users: email -> inbound tags
+	BlockedIPs    []string            `json:"blockedIps"`
+	UserRoutes    []*PinnedUser       `json:"userRoutes"` // Users pinned to outbounds
+}
+
+// StateService collects node state from the other services
+type StateService struct {
+	logger   *zap.Logger
+	xray     *XrayService
+	internal *InternalService
+	vision   *VisionService
+	routing  *RoutingService
+}
+
+// NewStateService creates a new StateService
+func NewStateService(xray *XrayService, internal *InternalService, vision *VisionService, routing *RoutingService, logger *zap.Logger) *StateService {
+	return &StateService{
+		logger:   logger,
+		xray:     xray,
+		internal: internal,
+		vision:   vision,
+		routing:  routing,
+	}
+}
+
+// Export returns the current node state
+func (s *StateService) Export() *NodeState {
+	hashes := s.internal.GetInboundHashes()
+	sort.Slice(hashes.Inbounds, func(i, j int) bool { return hashes.Inbounds[i].Tag < hashes.Inbounds[j].Tag })
+
+	inbounds := s.internal.GetXtlsConfigInbounds()
+	sort.Strings(inbounds)
+
+	blocked := s.vision.GetBlockedIPs().IPs
+	sort.Strings(blocked)
+
+	return &NodeState{
+		FormatVersion: NodeStateFormatVersion,
+		NodeVersion:   NodeVersion(),
+		XrayVersion:   s.xray.GetVersion(),
+		ExportedAt:    time.Now().UTC(),
+		Hashes:        hashes,
+		Inbounds:      inbounds,
+		Users:         s.internal.GetTrackedUsers(),
+		BlockedIPs:    blocked,
+		UserRoutes:    s.routing.GetPinnedUsers().Users,
+	}
+}
+
+// ImportStateRequest represents a request to apply a snapshot
+type ImportStateRequest struct {
+	State    *NodeState `json:"state" binding:"required"`
+	Sections []string   `json:"sections"` // Sections to apply; empty applies all
+}
+
+// ImportStateResponse represents the result of applying a snapshot
+type ImportStateResponse struct {
+	Success bool           `json:"success"`
+	Error   *string        `json:"error"`
+	Applied map[string]int `json:"applied"` // Section -> items applied
+	Failed  []string       `json:"failed"`  // Items that could not be applied
+}
+
+// validate checks a snapshot and returns the sections to apply
+func (req *ImportStateRequest) validate() ([]string, error) {
+	state := req.State
+	if state.FormatVersion < 1 || state.FormatVersion > NodeStateFormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", state.FormatVersion)
+	}
+
+	sections := req.Sections
+	if len(sections) == 0 {
+		sections = stateSections
+	}
+	for _, section := range sections {
+		known := false
+		for _, name := range stateSections {
+			known = known || name == section
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown section %q, expected one of: %s", section, strings.Join(stateSections, ", "))
+		}
+	}
+
+	if state.Hashes != nil {
+		for _, item := range state.Hashes.Inbounds {
+			if item.Tag == "" {
+				return nil, fmt.Errorf("hashes: inbound without tag")
+			}
+		}
+	}
+	for email := range state.Users {
+		if email == "" {
+			return nil, fmt.Errorf("users: empty email")
+		}
+	}
+	for _, ip := range state.BlockedIPs {
+		if _, err := netip.ParseAddr(ip); err != nil {
+			if _, err := netip.ParsePrefix(ip); err != nil {
+				return nil, fmt.Errorf("blockedIps: invalid address %q", ip)
+			}
+		}
+	}
+	for _, route := range state.UserRoutes {
+		if route == nil || route.Username == "" || route.OutboundTag == "" {
+			return nil, fmt.Errorf("userRoutes: username and outboundTag are required")
+		}
+	}
+
+	return sections, nil
+}
+
+// Import validates a snapshot and applies the requested sections. Hashes and
+// tracked users replace the current ones; blocked IPs and user routes are added
+// to the running core, keeping existing entries.
+func (s *StateService) Import(ctx context.Context, req *ImportStateRequest) (*ImportStateResponse, error) {
+	sections, err := req.validate()
+	if err != nil {
+		errMsg := err.Error()
+		return &ImportStateResponse{Success: false, Error: &errMsg, Applied: map[string]int{}, Failed: []string{}}, nil
+	}
+
+	state := req.State
+	resp := &ImportStateResponse{Success: true, Applied: make(map[string]int), Failed: make([]string, 0)}
+	for _, section := range stateSections {
+		selected := false
+		for _, name := range sections {
+			selected = selected || name == section
+		}
+		if !selected {
+			continue
+		}
+
+		switch section {
+		case StateSectionHashes:
+			if state.Hashes != nil {
+				s.internal.ImportHashes(state.Hashes)
+				resp.Applied[section] = len(state.Hashes.Inbounds)
+			}
+		case StateSectionUsers:
+			s.internal.ImportTrackedUsers(state.Inbounds, state.Users)
+			resp.Applied[section] = len(state.Users)
+		case StateSectionBlockedIPs:
+			for _, ip := range state.BlockedIPs {
+				result, err := s.vision.BlockIP(ctx, &BlockIPRequest{IP: ip, Reason: "imported from node state"})
+				if err == nil && result.Error != nil {
+					err = fmt.Errorf("%s", *result.Error)
+				}
+				if err != nil {
+					resp.Failed = append(resp.Failed, fmt.Sprintf("%s %s: %v", section, ip, err))
+					continue
+				}
+				resp.Applied[section]++
+			}
+		case StateSectionUserRoutes:
+			for _, route := range state.UserRoutes {
+				result, err := s.routing.PinUser(ctx, &PinUserRequest{Username: route.Username, OutboundTag: route.OutboundTag})
+				if err == nil && result.Error != nil {
+					err = fmt.Errorf("%s", *result.Error)
+				}
+				if err != nil {
+					resp.Failed = append(resp.Failed, fmt.Sprintf("%s %s: %v", section, route.Username, err))
+					continue
+				}
+				resp.Applied[section]++
+			}
+		}
+	}
+
+	if len(resp.Failed) > 0 {
+		errMsg := fmt.Sprintf("%d items failed", len(resp.Failed))
+		resp.Success = false
+		resp.Error = &errMsg
+	}
+
+	s.logger.Info("Imported node state",
+		zap.Strings("sections", sections),
+		zap.Any("applied", resp.Applied),
+		zap.Int("failed", len(resp.Failed)))
+
+	return resp, nil
+}
@@ -3,13 +3,21 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
 	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
 )
 
@@ -18,14 +26,379 @@ type StatsService struct {
 	mu       sync.RWMutex
 	logger   *zap.Logger
 	xrayCore *xraycore.Instance
+	sidecars *SidecarService // Optional; sidecar traffic is merged into user stats
+
+	// Applied to emails looked up by stats requests; nil leaves them as sent
+	usernames *UsernameNormalizer
+
+	// Old username -> new username, see SetUsernameAliases
+	aliasMu     sync.RWMutex
+	aliases     map[string]string
+	aliasesPath string
+
+	// Short-lived cache of non-resetting queries
+	cacheTTL time.Duration
+	cache    map[string]statsCacheEntry
+
+	// Delta mode: reset requests report the difference since the previous
+	// fetch instead of zeroing counters in the core
+	deltaMode    bool
+	deltaMu      sync.Mutex
+	lastReported map[string]int64 // counter name -> value at last reset request
+
+	// Start time of the core instance the cache and baselines were read from
+	coreStartMu sync.Mutex
+	coreStarted time.Time
+
+	// Users no inbound has, by when they were first found so (see pruneRemovedUsers)
+	orphanMu      sync.Mutex
+	orphanedSince map[string]time.Time
+
+	// Pending two-phase collection (begin-collection / commit-collection)
+	collectionMu sync.Mutex
+	collection   *pendingCollection
+
+	// Traffic not yet collected when the node last shut down; reported with
+	// the next reset request
+	snapshotPath string
+	carriedMu    sync.Mutex
+	carried      map[string]*UserTraffic
+}
+
+// collectionTTL bounds how long an uncommitted collection stays valid
+const collectionTTL = 5 * time.Minute
+
+// pendingCollection is a snapshot handed to the panel but not yet acknowledged
+type pendingCollection struct {
+	id        string
+	values    map[string]int64 // counter name -> value reported
+	createdAt time.Time
+}
+
+// StatsConfig holds Stats service configuration
+type StatsConfig struct {
+	CacheTTL  time.Duration // 0 disables caching
+	DeltaMode bool
+	StateDir  string              // Directory for the shutdown stats snapshot; empty disables it
+	Usernames *UsernameNormalizer // Optional; must be the one user mutations use
+}
+
+// statsCacheEntry is a cached query result
+type statsCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
 }
 
 // NewStatsService creates a new StatsService
-func NewStatsService(xrayCore *xraycore.Instance, logger *zap.Logger) *StatsService {
-	return &StatsService{
-		logger:   logger,
-		xrayCore: xrayCore,
+func NewStatsService(cfg *StatsConfig, xrayCore *xraycore.Instance, sidecars *SidecarService, logger *zap.Logger) *StatsService {
+	s := &StatsService{
+		logger:        logger,
+		xrayCore:      xrayCore,
+		sidecars:      sidecars,
+		usernames:     cfg.Usernames,
+		cacheTTL:      cfg.CacheTTL,
+		cache:         make(map[string]statsCacheEntry),
+		deltaMode:     cfg.DeltaMode,
+		lastReported:  make(map[string]int64),
+		orphanedSince: make(map[string]time.Time),
+		carried:       make(map[string]*UserTraffic),
+		aliases:       make(map[string]string),
+	}
+
+	if cfg.StateDir != "" {
+		s.snapshotPath = filepath.Join(cfg.StateDir, "stats-snapshot.json")
+		s.loadSnapshot()
+		s.aliasesPath = filepath.Join(cfg.StateDir, "username-aliases.json")
+		s.loadAliases()
+	}
+
+	return s
+}
+
+// loadSnapshot restores traffic saved by SaveSnapshot on the previous shutdown
+func (s *StatsService) loadSnapshot() {
+	data, err := os.ReadFile(s.snapshotPath)
+	if err != nil {
+		return
+	}
+
+	var users []*UserTraffic
+	if err := json.Unmarshal(data, &users); err != nil {
+		s.logger.Warn("Failed to parse stats snapshot", zap.Error(err))
+		return
+	}
+	for _, user := range users {
+		s.carried[user.Username] = user
+	}
+
+	s.logger.Info("Restored uncollected traffic from stats snapshot", zap.Int("users", len(users)))
+}
+
+// SaveSnapshot writes traffic not yet collected by the panel to disk, so it is
+// reported after a restart instead of being lost with the core counters
+func (s *StatsService) SaveSnapshot(ctx context.Context) error {
+	if s.snapshotPath == "" {
+		return nil
+	}
+
+	totals := make(map[string]*UserTraffic)
+	addTraffic := func(username string, uplink, downlink int64) {
+		username = s.resolveAlias(username)
+		total, exists := totals[username]
+		if !exists {
+			total = &UserTraffic{Username: username}
+			totals[username] = total
+		}
+		total.Uplink += uplink
+		total.Downlink += downlink
+	}
+
+	s.addCarried(addTraffic, false)
+
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		allStats, err := s.xrayCore.GetAllUserStats(ctx, false)
+		if err != nil {
+			return err
+		}
+		for _, stat := range allStats {
+			addTraffic(stat.Email,
+				s.unreported(userCounterName(stat.Email, "uplink"), stat.Uplink),
+				s.unreported(userCounterName(stat.Email, "downlink"), stat.Downlink))
+		}
+	}
+
+	// Sidecars left running keep their counters; the next agent adopts them
+	// and reads that traffic live, so carrying it as well would count it twice
+	if s.sidecarsEnabled() && !s.sidecars.KeepsRunning() {
+		sidecarStats, _ := s.sidecars.GetTraffic(ctx, false)
+		for username, t := range sidecarStats {
+			addTraffic(username,
+				s.unreported("sidecar>>>"+userCounterName(username, "uplink"), t.Uplink),
+				s.unreported("sidecar>>>"+userCounterName(username, "downlink"), t.Downlink))
+		}
+	}
+
+	users := make([]*UserTraffic, 0, len(totals))
+	for _, total := range totals {
+		if total.Uplink == 0 && total.Downlink == 0 {
+			continue
+		}
+		users = append(users, total)
+	}
+
+	if len(users) == 0 {
+		if err := os.Remove(s.snapshotPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(s.snapshotPath, data, 0600, true); err != nil {
+		return fmt.Errorf("failed to write stats snapshot: %w", err)
+	}
+
+	s.logger.Info("Saved uncollected traffic to stats snapshot", zap.Int("users", len(users)))
+	return nil
+}
+
+// addCarried merges traffic restored from the snapshot into a result. A reset
+// request hands it out once and removes the snapshot.
+func (s *StatsService) addCarried(add func(username string, uplink, downlink int64), reset bool) {
+	s.carriedMu.Lock()
+	defer s.carriedMu.Unlock()
+
+	if len(s.carried) == 0 {
+		return
+	}
+	for _, user := range s.carried {
+		add(user.Username, user.Uplink, user.Downlink)
+	}
+
+	if reset {
+		s.carried = make(map[string]*UserTraffic)
+		if err := os.Remove(s.snapshotPath); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("Failed to remove stats snapshot", zap.Error(err))
+		}
+	}
+}
+
+// removedUserGrace is how long the counters of a user removed from its last
+// inbound are kept. A user moved between inbounds is removed and added back
+// within one request, and a removed user's open connections keep counting
+// for a moment; dropping a counter still in use would lose that traffic.
+const removedUserGrace = time.Minute
+
+// checkCoreRestart forgets what was read from a previous core instance. Every
+// start creates a new stats manager, so counters of inbounds and users that
+// are gone from the config disappear with it, but cached results and delta
+// baselines would keep reporting them (and a baseline would be subtracted
+// from a new counter that started from zero).
+func (s *StatsService) checkCoreRestart() {
+	if s.xrayCore == nil {
+		return
+	}
+
+	started := s.xrayCore.StartTime()
+	s.coreStartMu.Lock()
+	defer s.coreStartMu.Unlock()
+	if started.Equal(s.coreStarted) {
+		return
 	}
+	s.coreStarted = started
+
+	s.InvalidateCache()
+	s.deltaMu.Lock()
+	for name := range s.lastReported {
+		if !strings.HasPrefix(name, "sidecar>>>") { // Sidecar cores restart independently
+			delete(s.lastReported, name)
+		}
+	}
+	s.deltaMu.Unlock()
+}
+
+// pruneRemovedUsers drops the core counters of users removed from their last inbound,
+// once their traffic has been reported and removedUserGrace has passed since
+// they were first found without an inbound, so they stop showing up in stats.
+// Called after every reset request.
+func (s *StatsService) pruneRemovedUsers(ctx context.Context) {
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return
+	}
+
+	orphaned, err := s.xrayCore.OrphanedUserStats(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to read counters of removed users", zap.Error(err))
+		return
+	}
+
+	s.orphanMu.Lock()
+	defer s.orphanMu.Unlock()
+
+	now := time.Now()
+	since := make(map[string]time.Time, len(orphaned))
+	var names []string
+	for _, stat := range orphaned {
+		uplink, downlink := userCounterName(stat.Email, "uplink"), userCounterName(stat.Email, "downlink")
+		first, known := s.orphanedSince[stat.Email]
+		if !known {
+			first = now
+		}
+		if now.Sub(first) < removedUserGrace || s.unreported(uplink, stat.Uplink) != 0 || s.unreported(downlink, stat.Downlink) != 0 {
+			since[stat.Email] = first // Dropped after a later collection
+			continue
+		}
+		names = append(names, uplink, downlink)
+	}
+	// Users added back, or whose counters are dropped below, are forgotten
+	s.orphanedSince = since
+
+	if len(names) == 0 {
+		return
+	}
+	if err := s.xrayCore.UnregisterStats(ctx, names); err != nil {
+		s.logger.Warn("Failed to drop counters of removed users", zap.Error(err))
+		return
+	}
+	s.deltaMu.Lock()
+	for _, name := range names {
+		delete(s.lastReported, name)
+	}
+	s.deltaMu.Unlock()
+
+	s.logger.Debug("Dropped counters of removed users", zap.Int("users", len(names)/2))
+}
+
+// getCached returns a cached value if present and fresh
+func (s *StatsService) getCached(key string) (interface{}, bool) {
+	if s.cacheTTL <= 0 {
+		return nil, false
+	}
+	s.checkCoreRestart()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.cache[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// setCached stores a query result for the cache TTL
+func (s *StatsService) setCached(key string, value interface{}) {
+	if s.cacheTTL <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[key] = statsCacheEntry{value: value, expiresAt: time.Now().Add(s.cacheTTL)}
+}
+
+// coreReset reports whether a reset request should zero counters in the core.
+// In delta mode counters are never zeroed; the delta is computed instead.
+func (s *StatsService) coreReset(reset bool) bool {
+	return reset && !s.deltaMode
+}
+
+// toDelta converts a counter value read for a reset request into the traffic
+// accrued since the previous one. Outside delta mode the value is returned as is,
+// since the core already zeroed the counter.
+func (s *StatsService) toDelta(reset bool, name string, value int64) int64 {
+	if !reset || !s.deltaMode {
+		return value
+	}
+	s.checkCoreRestart()
+
+	s.deltaMu.Lock()
+	defer s.deltaMu.Unlock()
+
+	last := s.lastReported[name]
+	s.lastReported[name] = value
+	if value < last {
+		// Counter restarted (core restart or user re-added)
+		return value
+	}
+	return value - last
+}
+
+// unreported returns the part of a counter not yet handed out by a reset
+// request, without recording it as reported
+func (s *StatsService) unreported(name string, value int64) int64 {
+	if !s.deltaMode {
+		return value
+	}
+	s.checkCoreRestart()
+
+	s.deltaMu.Lock()
+	defer s.deltaMu.Unlock()
+
+	if last := s.lastReported[name]; value >= last {
+		return value - last
+	}
+	return value
+}
+
+// sidecarsEnabled reports whether sidecar traffic must be merged into user stats
+func (s *StatsService) sidecarsEnabled() bool {
+	return s.sidecars != nil && s.sidecars.Enabled()
+}
+
+// userCounterName returns the core counter name for a user traffic direction
+func userCounterName(email, direction string) string {
+	return "user>>>" + email + ">>>traffic>>>" + direction
+}
+
+// InvalidateCache drops all cached results (called whenever counters are reset)
+func (s *StatsService) InvalidateCache() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = make(map[string]statsCacheEntry)
 }
 
 // UserTraffic represents traffic data for a user
@@ -36,6 +409,78 @@ type UserTraffic struct {
 	Downlink int64  `json:"downlink"`
 }
 
+// UserTraffic fields selectable with a UserStatsFilter
+const (
+	UserStatsFieldUsername = "username"
+	UserStatsFieldUplink   = "uplink"
+	UserStatsFieldDownlink = "downlink"
+)
+
+// UserStatsFilter trims user traffic lists on large nodes where most users are
+// idle: only the selected fields are returned (username always is), and users
+// whose selected traffic sums to less than MinBytes are left out
+type UserStatsFilter struct {
+	Uplink   bool
+	Downlink bool
+	MinBytes int64
+}
+
+// ParseUserStatsFilter parses the fields (comma-separated) and minBytes query
+// parameters. It returns nil if both are empty, meaning no filtering.
+func ParseUserStatsFilter(fields, minBytes string) (*UserStatsFilter, error) {
+	if fields == "" && minBytes == "" {
+		return nil, nil
+	}
+
+	filter := &UserStatsFilter{Uplink: true, Downlink: true}
+	if fields != "" {
+		filter.Uplink, filter.Downlink = false, false
+		for _, field := range strings.Split(fields, ",") {
+			switch strings.TrimSpace(field) {
+			case UserStatsFieldUsername:
+			case UserStatsFieldUplink:
+				filter.Uplink = true
+			case UserStatsFieldDownlink:
+				filter.Downlink = true
+			default:
+				return nil, fmt.Errorf("unknown field %q (expected username, uplink or downlink)", field)
+			}
+		}
+	}
+
+	if minBytes != "" {
+		value, err := strconv.ParseInt(minBytes, 10, 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid minBytes %q", minBytes)
+		}
+		filter.MinBytes = value
+	}
+
+	return filter, nil
+}
+
+// Apply returns the users passing the filter with only the selected fields
+func (f *UserStatsFilter) Apply(users []*UserTraffic) []map[string]interface{} {
+	filtered := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		var selected int64
+		entry := map[string]interface{}{UserStatsFieldUsername: user.Username}
+		if f.Uplink {
+			selected += user.Uplink
+			entry[UserStatsFieldUplink] = user.Uplink
+		}
+		if f.Downlink {
+			selected += user.Downlink
+			entry[UserStatsFieldDownlink] = user.Downlink
+		}
+		if selected < f.MinBytes {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
 // GetUserStatsRequest represents a request to get user stats
 type GetUserStatsRequest struct {
 	Email string `json:"email"`
@@ -51,23 +496,29 @@ type GetUserStatsResponse struct {
 
 // GetUserStats gets traffic statistics for a specific user
 func (s *StatsService) GetUserStats(ctx context.Context, req *GetUserStatsRequest) (*GetUserStatsResponse, error) {
+	req.Email = s.usernames.Normalize(req.Email)
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
 		return nil, nil
 	}
 
-	userStats, err := s.xrayCore.GetUserStats(ctx, req.Email, req.Reset)
-	if err != nil {
-		s.logger.Warn("Failed to get user stats",
-			zap.String("email", req.Email),
-			zap.Error(err))
-		return nil, err
+	if req.Reset {
+		defer s.InvalidateCache()
 	}
 
-	return &GetUserStatsResponse{
-		Email:    userStats.Email,
-		Uplink:   userStats.Uplink,
-		Downlink: userStats.Downlink,
-	}, nil
+	// Includes traffic still counted under the user's old usernames
+	resp := &GetUserStatsResponse{Email: req.Email}
+	for _, email := range s.withAliases([]string{req.Email}) {
+		userStats, err := s.xrayCore.GetUserStats(ctx, email, s.coreReset(req.Reset))
+		if err != nil {
+			s.logger.Warn("Failed to get user stats",
+				zap.String("email", email),
+				zap.Error(err))
+			return nil, err
+		}
+		resp.Uplink += s.toDelta(req.Reset, userCounterName(userStats.Email, "uplink"), userStats.Uplink)
+		resp.Downlink += s.toDelta(req.Reset, userCounterName(userStats.Email, "downlink"), userStats.Downlink)
+	}
+	return resp, nil
 }
 
 // GetAllUsersStatsRequest represents a request to get all users stats
@@ -83,30 +534,72 @@ type GetAllUsersStatsResponse struct {
 // GetAllUsersStats gets traffic statistics for all users
 // Always filters out users with zero traffic (matches Node.js behavior)
 func (s *StatsService) GetAllUsersStats(ctx context.Context, req *GetAllUsersStatsRequest) (*GetAllUsersStatsResponse, error) {
-	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+	xrayRunning := s.xrayCore != nil && s.xrayCore.IsRunning()
+	if !xrayRunning && !s.sidecarsEnabled() {
 		return &GetAllUsersStatsResponse{Users: []*UserTraffic{}}, nil
 	}
 
-	allStats, err := s.xrayCore.GetAllUserStats(ctx, req.Reset)
-	if err != nil {
-		s.logger.Warn("Failed to get all user stats", zap.Error(err))
-		return nil, err
+	if req.Reset {
+		defer s.InvalidateCache()
+	} else if cached, ok := s.getCached("users"); ok {
+		return cached.(*GetAllUsersStatsResponse), nil
 	}
 
-	users := make([]*UserTraffic, 0, len(allStats))
-	for _, stat := range allStats {
+	// Traffic is summed per username across Xray and sidecar cores, with
+	// traffic of renamed users reported under their new username
+	totals := make(map[string]*UserTraffic)
+	addTraffic := func(username string, uplink, downlink int64) {
+		username = s.resolveAlias(username)
+		total, exists := totals[username]
+		if !exists {
+			total = &UserTraffic{Username: username}
+			totals[username] = total
+		}
+		total.Uplink += uplink
+		total.Downlink += downlink
+	}
+
+	if xrayRunning {
+		allStats, err := s.xrayCore.GetAllUserStats(ctx, s.coreReset(req.Reset))
+		if err != nil {
+			s.logger.Warn("Failed to get all user stats", zap.Error(err))
+			return nil, err
+		}
+		for _, stat := range allStats {
+			addTraffic(stat.Email,
+				s.toDelta(req.Reset, userCounterName(stat.Email, "uplink"), stat.Uplink),
+				s.toDelta(req.Reset, userCounterName(stat.Email, "downlink"), stat.Downlink))
+		}
+	}
+
+	if s.sidecarsEnabled() {
+		// A failing sidecar is logged by GetTraffic; the other cores are still reported
+		sidecarStats, _ := s.sidecars.GetTraffic(ctx, s.coreReset(req.Reset))
+		for username, t := range sidecarStats {
+			addTraffic(username,
+				s.toDelta(req.Reset, "sidecar>>>"+userCounterName(username, "uplink"), t.Uplink),
+				s.toDelta(req.Reset, "sidecar>>>"+userCounterName(username, "downlink"), t.Downlink))
+		}
+	}
+
+	s.addCarried(addTraffic, req.Reset)
+
+	users := make([]*UserTraffic, 0, len(totals))
+	for _, total := range totals {
 		// Always filter out users with zero traffic (matches Node.js)
-		if stat.Uplink == 0 && stat.Downlink == 0 {
+		if total.Uplink == 0 && total.Downlink == 0 {
 			continue
 		}
-		users = append(users, &UserTraffic{
-			Username: stat.Email,
-			Uplink:   stat.Uplink,
-			Downlink: stat.Downlink,
-		})
+		users = append(users, total)
 	}
 
-	return &GetAllUsersStatsResponse{Users: users}, nil
+	resp := &GetAllUsersStatsResponse{Users: users}
+	if req.Reset {
+		s.pruneRemovedUsers(ctx)
+	} else {
+		s.setCached("users", resp)
+	}
+	return resp, nil
 }
 
 // SystemStatsResponse represents system statistics
@@ -146,6 +639,10 @@ func (s *StatsService) GetSystemStats(ctx context.Context) (*SystemStatsResponse
 		}, nil
 	}
 
+	if cached, ok := s.getCached("system"); ok {
+		return cached.(*SystemStatsResponse), nil
+	}
+
 	// Get Xray's internal system stats
 	sysStats, err := s.xrayCore.GetSystemStats(ctx)
 	if err != nil {
@@ -167,7 +664,7 @@ func (s *StatsService) GetSystemStats(ctx context.Context) (*SystemStatsResponse
 		}, nil
 	}
 
-	return &SystemStatsResponse{
+	resp := &SystemStatsResponse{
 		NumGoroutine: int(sysStats.NumGoroutine),
 		NumGC:        int(sysStats.NumGC),
 		Alloc:        int64(sysStats.Alloc),
@@ -178,7 +675,9 @@ func (s *StatsService) GetSystemStats(ctx context.Context) (*SystemStatsResponse
 		LiveObjects:  int64(sysStats.LiveObjects),
 		PauseTotalNs: 0, // Not available from embedded stats
 		Uptime:       int64(sysStats.Uptime),
-	}, nil
+	}
+	s.setCached("system", resp)
+	return resp, nil
 }
 
 // GetUsersStatsAndResetRequest represents request to get and reset stats
@@ -193,26 +692,35 @@ type GetUsersStatsAndResetResponse struct {
 
 // GetUsersStatsAndReset gets traffic for specific users and resets counters
 func (s *StatsService) GetUsersStatsAndReset(ctx context.Context, req *GetUsersStatsAndResetRequest) (*GetUsersStatsAndResetResponse, error) {
+	s.usernames.NormalizeAll(req.Emails)
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
 		return &GetUsersStatsAndResetResponse{Users: []*UserTraffic{}}, nil
 	}
 
-	users := make([]*UserTraffic, 0, len(req.Emails))
-	for _, email := range req.Emails {
-		userStats, err := s.xrayCore.GetUserStats(ctx, email, true)
-		if err != nil {
-			s.logger.Debug("Failed to get stats for user",
-				zap.String("email", email),
-				zap.Error(err))
-			continue
+	defer s.InvalidateCache()
+
+	allStats, err := s.xrayCore.GetUsersStats(ctx, s.withAliases(req.Emails), s.coreReset(true))
+	if err != nil {
+		s.logger.Warn("Failed to get users stats", zap.Error(err))
+		return nil, err
+	}
+
+	// Old usernames are merged into the user they were renamed to
+	users := make([]*UserTraffic, 0, len(allStats))
+	byUsername := make(map[string]*UserTraffic, len(allStats))
+	for _, stat := range allStats {
+		username := s.resolveAlias(stat.Email)
+		user, exists := byUsername[username]
+		if !exists {
+			user = &UserTraffic{Username: username}
+			byUsername[username] = user
+			users = append(users, user)
 		}
-		users = append(users, &UserTraffic{
-			Username: userStats.Email,
-			Uplink:   userStats.Uplink,
-			Downlink: userStats.Downlink,
-		})
+		user.Uplink += s.toDelta(true, userCounterName(stat.Email, "uplink"), stat.Uplink)
+		user.Downlink += s.toDelta(true, userCounterName(stat.Email, "downlink"), stat.Downlink)
 	}
 
+	s.pruneRemovedUsers(ctx)
 	return &GetUsersStatsAndResetResponse{Users: users}, nil
 }
 
@@ -229,6 +737,7 @@ type GetUserOnlineStatusResponse struct {
 
 // GetUserOnlineStatus checks if a user is currently online
 func (s *StatsService) GetUserOnlineStatus(ctx context.Context, req *GetUserOnlineStatusRequest) (*GetUserOnlineStatusResponse, error) {
+	req.Email = s.usernames.Normalize(req.Email)
 	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
 		return &GetUserOnlineStatusResponse{IsOnline: false}, nil
 	}
@@ -270,8 +779,12 @@ func (s *StatsService) GetInboundStats(ctx context.Context, req *GetInboundStats
 		return &GetInboundStatsResponse{Inbound: req.Tag}, nil
 	}
 
+	if req.Reset {
+		defer s.InvalidateCache()
+	}
+
 	pattern := "inbound>>>" + req.Tag + ">>>traffic>>>"
-	stats, err := s.xrayCore.GetStats(ctx, pattern, req.Reset)
+	stats, err := s.xrayCore.GetStats(ctx, pattern, s.coreReset(req.Reset))
 	if err != nil {
 		s.logger.Warn("Failed to get inbound stats",
 			zap.String("tag", req.Tag),
@@ -281,6 +794,7 @@ func (s *StatsService) GetInboundStats(ctx context.Context, req *GetInboundStats
 
 	var uplink, downlink int64
 	for name, value := range stats {
+		value = s.toDelta(req.Reset, name, value)
 		if strings.HasSuffix(name, "uplink") {
 			uplink = value
 		} else if strings.HasSuffix(name, "downlink") {
@@ -321,8 +835,12 @@ func (s *StatsService) GetOutboundStats(ctx context.Context, req *GetOutboundSta
 		return &GetOutboundStatsResponse{Outbound: req.Tag}, nil
 	}
 
+	if req.Reset {
+		defer s.InvalidateCache()
+	}
+
 	pattern := "outbound>>>" + req.Tag + ">>>traffic>>>"
-	stats, err := s.xrayCore.GetStats(ctx, pattern, req.Reset)
+	stats, err := s.xrayCore.GetStats(ctx, pattern, s.coreReset(req.Reset))
 	if err != nil {
 		s.logger.Warn("Failed to get outbound stats",
 			zap.String("tag", req.Tag),
@@ -332,6 +850,7 @@ func (s *StatsService) GetOutboundStats(ctx context.Context, req *GetOutboundSta
 
 	var uplink, downlink int64
 	for name, value := range stats {
+		value = s.toDelta(req.Reset, name, value)
 		if strings.HasSuffix(name, "uplink") {
 			uplink = value
 		} else if strings.HasSuffix(name, "downlink") {
@@ -362,8 +881,14 @@ func (s *StatsService) GetAllInboundsStats(ctx context.Context, req *GetAllInbou
 		return &GetAllInboundsStatsResponse{Inbounds: []*InboundStats{}}, nil
 	}
 
+	if req.Reset {
+		defer s.InvalidateCache()
+	} else if cached, ok := s.getCached("inbounds"); ok {
+		return cached.(*GetAllInboundsStatsResponse), nil
+	}
+
 	// Get all stats with inbound prefix
-	stats, err := s.xrayCore.GetStats(ctx, "inbound>>>", req.Reset)
+	stats, err := s.xrayCore.GetStats(ctx, "inbound>>>", s.coreReset(req.Reset))
 	if err != nil {
 		s.logger.Warn("Failed to get all inbounds stats", zap.Error(err))
 		return nil, err
@@ -372,6 +897,8 @@ func (s *StatsService) GetAllInboundsStats(ctx context.Context, req *GetAllInbou
 	// Parse and aggregate by inbound tag
 	inboundMap := make(map[string]*InboundStats)
 	for name, value := range stats {
+		value = s.toDelta(req.Reset, name, value)
+
 		// Format: inbound>>>tag>>>traffic>>>uplink/downlink
 		parts := strings.Split(name, ">>>")
 		if len(parts) < 4 {
@@ -396,7 +923,11 @@ func (s *StatsService) GetAllInboundsStats(ctx context.Context, req *GetAllInbou
 		result = append(result, inbound)
 	}
 
-	return &GetAllInboundsStatsResponse{Inbounds: result}, nil
+	resp := &GetAllInboundsStatsResponse{Inbounds: result}
+	if !req.Reset {
+		s.setCached("inbounds", resp)
+	}
+	return resp, nil
 }
 
 // GetAllOutboundsStatsRequest represents request to get all outbounds stats
@@ -415,8 +946,14 @@ func (s *StatsService) GetAllOutboundsStats(ctx context.Context, req *GetAllOutb
 		return &GetAllOutboundsStatsResponse{Outbounds: []*OutboundStats{}}, nil
 	}
 
+	if req.Reset {
+		defer s.InvalidateCache()
+	} else if cached, ok := s.getCached("outbounds"); ok {
+		return cached.(*GetAllOutboundsStatsResponse), nil
+	}
+
 	// Get all stats with outbound prefix
-	stats, err := s.xrayCore.GetStats(ctx, "outbound>>>", req.Reset)
+	stats, err := s.xrayCore.GetStats(ctx, "outbound>>>", s.coreReset(req.Reset))
 	if err != nil {
 		s.logger.Warn("Failed to get all outbounds stats", zap.Error(err))
 		return nil, err
@@ -425,6 +962,8 @@ func (s *StatsService) GetAllOutboundsStats(ctx context.Context, req *GetAllOutb
 	// Parse and aggregate by outbound tag
 	outboundMap := make(map[string]*OutboundStats)
 	for name, value := range stats {
+		value = s.toDelta(req.Reset, name, value)
+
 		// Format: outbound>>>tag>>>traffic>>>uplink/downlink
 		parts := strings.Split(name, ">>>")
 		if len(parts) < 4 {
@@ -449,7 +988,11 @@ func (s *StatsService) GetAllOutboundsStats(ctx context.Context, req *GetAllOutb
 		result = append(result, outbound)
 	}
 
-	return &GetAllOutboundsStatsResponse{Outbounds: result}, nil
+	resp := &GetAllOutboundsStatsResponse{Outbounds: result}
+	if !req.Reset {
+		s.setCached("outbounds", resp)
+	}
+	return resp, nil
 }
 
 // GetCombinedStatsRequest represents request to get combined stats
@@ -480,3 +1023,116 @@ func (s *StatsService) GetCombinedStats(ctx context.Context, req *GetCombinedSta
 		Outbounds: outboundsResp.Outbounds,
 	}, nil
 }
+
+// BeginCollectionResponse represents a stats snapshot awaiting acknowledgement
+type BeginCollectionResponse struct {
+	CollectionID string         `json:"collectionId"`
+	Users        []*UserTraffic `json:"users"`
+}
+
+// BeginCollection reads all user counters without resetting them and remembers
+// the snapshot. Counters are only decremented once the panel calls
+// CommitCollection, so a panel failure mid-poll loses no traffic.
+// Starting a new collection discards any uncommitted previous one, since both
+// would cover the same traffic.
+func (s *StatsService) BeginCollection(ctx context.Context) (*BeginCollectionResponse, error) {
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return &BeginCollectionResponse{Users: []*UserTraffic{}}, nil
+	}
+
+	allStats, err := s.xrayCore.GetAllUserStats(ctx, false)
+	if err != nil {
+		s.logger.Warn("Failed to get all user stats", zap.Error(err))
+		return nil, err
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate collection id: %w", err)
+	}
+
+	collection := &pendingCollection{
+		id:        hex.EncodeToString(idBytes),
+		values:    make(map[string]int64, len(allStats)*2),
+		createdAt: time.Now(),
+	}
+
+	// Counters are committed per core email, traffic is reported per user
+	users := make([]*UserTraffic, 0, len(allStats))
+	byUsername := make(map[string]*UserTraffic, len(allStats))
+	for _, stat := range allStats {
+		if stat.Uplink == 0 && stat.Downlink == 0 {
+			continue
+		}
+		collection.values[userCounterName(stat.Email, "uplink")] = stat.Uplink
+		collection.values[userCounterName(stat.Email, "downlink")] = stat.Downlink
+
+		username := s.resolveAlias(stat.Email)
+		user, exists := byUsername[username]
+		if !exists {
+			user = &UserTraffic{Username: username}
+			byUsername[username] = user
+			users = append(users, user)
+		}
+		user.Uplink += stat.Uplink
+		user.Downlink += stat.Downlink
+	}
+
+	s.collectionMu.Lock()
+	s.collection = collection
+	s.collectionMu.Unlock()
+
+	return &BeginCollectionResponse{CollectionID: collection.id, Users: users}, nil
+}
+
+// CommitCollectionRequest acknowledges a collection
+type CommitCollectionRequest struct {
+	CollectionID string `json:"collectionId" binding:"required"`
+}
+
+// CommitCollectionResponse represents the result of a commit
+type CommitCollectionResponse struct {
+	Committed bool `json:"committed"`
+}
+
+// CommitCollection subtracts the acknowledged snapshot from the core counters.
+// Unknown, superseded or expired collection ids are rejected.
+func (s *StatsService) CommitCollection(ctx context.Context, req *CommitCollectionRequest) (*CommitCollectionResponse, error) {
+	s.collectionMu.Lock()
+	collection := s.collection
+	if collection == nil || collection.id != req.CollectionID {
+		s.collectionMu.Unlock()
+		return nil, fmt.Errorf("unknown or superseded collection %q", req.CollectionID)
+	}
+	s.collection = nil
+	s.collectionMu.Unlock()
+
+	if time.Since(collection.createdAt) > collectionTTL {
+		return nil, fmt.Errorf("collection %q expired", req.CollectionID)
+	}
+
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return &CommitCollectionResponse{Committed: false}, nil
+	}
+
+	defer s.InvalidateCache()
+
+	if err := s.xrayCore.SubtractStats(ctx, collection.values); err != nil {
+		s.logger.Warn("Failed to commit stats collection", zap.Error(err))
+		return nil, err
+	}
+
+	// Keep delta-mode baselines consistent with the lowered counters
+	if s.deltaMode {
+		s.deltaMu.Lock()
+		for name, value := range collection.values {
+			if last, exists := s.lastReported[name]; exists {
+				s.lastReported[name] = max(last-value, 0)
+			}
+		}
+		s.deltaMu.Unlock()
+	}
+
+	s.pruneRemovedUsers(ctx)
+	return &CommitCollectionResponse{Committed: true}, nil
+}
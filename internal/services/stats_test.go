@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+func TestParseUserStatsFilter(t *testing.T) {
+	if filter, err := ParseUserStatsFilter("", ""); filter != nil || err != nil {
+		t.Errorf("Expected no filter without parameters, got %+v %v", filter, err)
+	}
+
+	filter, err := ParseUserStatsFilter("username,downlink", "1024")
+	if err != nil {
+		t.Fatalf("ParseUserStatsFilter returned error: %v", err)
+	}
+	if filter.Uplink || !filter.Downlink || filter.MinBytes != 1024 {
+		t.Errorf("Unexpected filter %+v", filter)
+	}
+
+	for _, tt := range []struct{ fields, minBytes string }{
+		{"username,email", ""},
+		{"", "-1"},
+		{"", "1k"},
+	} {
+		if _, err := ParseUserStatsFilter(tt.fields, tt.minBytes); err == nil {
+			t.Errorf("Expected fields=%q minBytes=%q to be rejected", tt.fields, tt.minBytes)
+		}
+	}
+}
+
+func TestUserStatsFilter_Apply(t *testing.T) {
+	users := []*UserTraffic{
+		{Username: "alice", Uplink: 5000, Downlink: 100},
+		{Username: "bob", Uplink: 10, Downlink: 2000},
+	}
+
+	filter, _ := ParseUserStatsFilter("downlink", "1000")
+	got := filter.Apply(users)
+	want := []map[string]interface{}{{"username": "bob", "downlink": int64(2000)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Without fields the threshold applies to uplink+downlink
+	filter, _ = ParseUserStatsFilter("", "2010")
+	if got := filter.Apply(users); len(got) != 2 {
+		t.Errorf("Expected both users above the total threshold, got %v", got)
+	}
+}
+
+func TestStats_UsernameAliases(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStatsService(&StatsConfig{StateDir: dir}, nil, nil, zap.NewNop())
+
+	if _, err := s.SetUsernameAliases(&SetUsernameAliasesRequest{Aliases: map[string]string{"alice": "alice2", "alice2": "alice3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.resolveAlias("alice"); got != "alice3" {
+		t.Errorf("Expected renames to be followed, got %q", got)
+	}
+	if got := s.withAliases([]string{"alice3", "bob"}); !reflect.DeepEqual(got, []string{"alice3", "bob", "alice", "alice2"}) {
+		t.Errorf("Expected old usernames to be looked up too, got %v", got)
+	}
+
+	if _, err := s.SetUsernameAliases(&SetUsernameAliasesRequest{Aliases: map[string]string{"alice3": "alice"}}); err == nil {
+		t.Error("Expected a cycle to be rejected")
+	}
+
+	// Aliases survive a restart, and an empty new username removes one
+	restarted := NewStatsService(&StatsConfig{StateDir: dir}, nil, nil, zap.NewNop())
+	resp, err := restarted.SetUsernameAliases(&SetUsernameAliasesRequest{Aliases: map[string]string{"alice2": ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Aliases, map[string]string{"alice": "alice2"}) {
+		t.Errorf("Unexpected aliases after removal: %v", resp.Aliases)
+	}
+}
+
+func TestStats_SnapshotSkipsSidecarsLeftRunning(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"alice": {"tx": 100, "rx": 200}}`))
+	}))
+	defer api.Close()
+
+	for _, keep := range []bool{false, true} {
+		sidecars := &SidecarService{
+			logger:         zap.NewNop(),
+			sidecars:       map[string]*sidecar{"hy2": {def: SidecarDefinition{Name: "hy2", StatsURL: api.URL}, running: true}},
+			keepOnShutdown: keep,
+		}
+		dir := t.TempDir()
+		s := NewStatsService(&StatsConfig{StateDir: dir}, nil, sidecars, zap.NewNop())
+		if err := s.SaveSnapshot(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// A sidecar left running is adopted with its counters intact
+		_, err := os.Stat(filepath.Join(dir, "stats-snapshot.json"))
+		if saved := err == nil; saved == keep {
+			t.Errorf("Expected the snapshot to carry sidecar traffic only when sidecars stop (keep=%v, saved=%v)", keep, saved)
+		}
+	}
+}
+
+// startUserTrafficCore starts a real core with a VLESS inbound "VLESS" that
+// has the user alice, and a dokodemo-door inbound that reaches a local echo
+// server through it as alice. The returned func pushes n bytes through both
+// and back, waiting until alice's counters have counted them.
+func startUserTrafficCore(t *testing.T) (*xraycore.Instance, func(n int)) {
+	t.Helper()
+
+	echoPort := startEchoServer(t)
+	vlessPort, relayPort := freePort(t), freePort(t)
+
+	core := xraycore.New(&xraycore.Config{Logger: zap.NewNop()})
+	config := fmt.Sprintf(`{
+		"stats": {},
+		"policy": {"levels": {"0": {"uplinkOnly": 0, "downlinkOnly": 0, "statsUserUplink": true, "statsUserDownlink": true}}},
+		"inbounds": [
+			{"tag": "VLESS", "listen": "127.0.0.1", "port": %[1]d, "protocol": "vless",
+				"settings": {"clients": [{"id": %[4]q, "email": "alice"}], "decryption": "none"}},
+			{"tag": "relay", "listen": "127.0.0.1", "port": %[2]d, "protocol": "dokodemo-door",
+				"settings": {"address": "127.0.0.1", "port": %[3]d, "network": "tcp"}}
+		],
+		"outbounds": [
+			{"tag": "DIRECT", "protocol": "freedom"},
+			{"tag": "as-alice", "protocol": "vless",
+				"settings": {"vnext": [{"address": "127.0.0.1", "port": %[1]d, "users": [{"id": %[4]q, "encryption": "none"}]}]}}
+		],
+		"routing": {"rules": [{"type": "field", "inboundTag": ["relay"], "outboundTag": "as-alice"}]}
+	}`, vlessPort, relayPort, echoPort, testUUID1)
+	if err := core.Start(context.Background(), []byte(config)); err != nil {
+		t.Fatalf("Failed to start the core: %v", err)
+	}
+	t.Cleanup(func() { core.Stop() })
+
+	send := func(n int) {
+		t.Helper()
+		echoThrough(t, relayPort, n, func() int64 {
+			counters, _ := core.GetCumulativeStats(context.Background(), "user>>>alice>>>")
+			return counters[userCounterName("alice", "uplink")] + counters[userCounterName("alice", "downlink")]
+		})
+	}
+	return core, send
+}
+
+// hasUserCounters reports whether the core still has counters for email
+func hasUserCounters(t *testing.T, core *xraycore.Instance, email string) bool {
+	t.Helper()
+
+	counters, err := core.GetCumulativeStats(context.Background(), "user>>>"+email+">>>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(counters) > 0
+}
+
+// backdateOrphan makes a removed user's grace period run out
+func backdateOrphan(s *StatsService, email string) {
+	s.orphanMu.Lock()
+	defer s.orphanMu.Unlock()
+	s.orphanedSince[email] = time.Now().Add(-removedUserGrace)
+}
+
+func TestStats_PrunesRemovedUsersAfterGrace(t *testing.T) {
+	core, send := startUserTrafficCore(t)
+	s := NewStatsService(&StatsConfig{}, core, nil, zap.NewNop())
+	ctx := context.Background()
+
+	send(1000)
+	if err := core.RemoveUser(ctx, "VLESS", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything is reported, but the user was only just found removed
+	resp, err := s.GetAllUsersStats(ctx, &GetAllUsersStatsRequest{Reset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Username != "alice" || resp.Users[0].Uplink < 1000 || resp.Users[0].Downlink < 1000 {
+		t.Fatalf("Expected alice's traffic to be reported, got %+v", resp.Users)
+	}
+	if !hasUserCounters(t, core, "alice") {
+		t.Fatal("Expected the counters to be kept within the grace period")
+	}
+
+	backdateOrphan(s, "alice")
+	s.pruneRemovedUsers(ctx)
+	if hasUserCounters(t, core, "alice") {
+		t.Error("Expected the counters to be dropped after the grace period")
+	}
+	if _, tracked := s.orphanedSince["alice"]; tracked {
+		t.Error("Expected a dropped user to be forgotten")
+	}
+}
+
+func TestStats_PrunesRemovedUsersOnceReported(t *testing.T) {
+	core, send := startUserTrafficCore(t)
+	s := NewStatsService(&StatsConfig{}, core, nil, zap.NewNop())
+	ctx := context.Background()
+
+	send(1000)
+	if err := core.RemoveUser(ctx, "VLESS", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.pruneRemovedUsers(ctx)
+	backdateOrphan(s, "alice")
+	s.pruneRemovedUsers(ctx)
+	if !hasUserCounters(t, core, "alice") {
+		t.Fatal("Expected the counters to be kept until their traffic is reported")
+	}
+
+	// The reset request reports the traffic, then drops the counters
+	resp, err := s.GetAllUsersStats(ctx, &GetAllUsersStatsRequest{Reset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Uplink < 1000 || resp.Users[0].Downlink < 1000 {
+		t.Fatalf("Expected alice's traffic to be reported, got %+v", resp.Users)
+	}
+	if hasUserCounters(t, core, "alice") {
+		t.Error("Expected the counters to be dropped once reported")
+	}
+}
+
+func TestStats_CoreRestartClearsBaselines(t *testing.T) {
+	core, send := startUserTrafficCore(t)
+	s := NewStatsService(&StatsConfig{DeltaMode: true}, core, nil, zap.NewNop())
+	ctx := context.Background()
+	uplink, sidecarUplink := userCounterName("alice", "uplink"), "sidecar>>>"+userCounterName("alice", "uplink")
+
+	send(1000)
+	if _, err := s.GetAllUsersStats(ctx, &GetAllUsersStatsRequest{Reset: true}); err != nil {
+		t.Fatal(err)
+	}
+	s.deltaMu.Lock()
+	baseline := s.lastReported[uplink]
+	s.lastReported[sidecarUplink] = 1
+	s.deltaMu.Unlock()
+	if baseline < 1000 {
+		t.Fatalf("Expected the reset to record alice's uplink as baseline, got %d", baseline)
+	}
+
+	if err := core.Restart(ctx, core.GetConfig()); err != nil {
+		t.Fatal(err)
+	}
+	s.checkCoreRestart()
+	s.deltaMu.Lock()
+	_, kept := s.lastReported[uplink]
+	_, sidecarKept := s.lastReported[sidecarUplink]
+	s.deltaMu.Unlock()
+	if kept || !sidecarKept {
+		t.Errorf("Expected only the core's baselines to be cleared (core kept %v, sidecar kept %v)", kept, sidecarKept)
+	}
+
+	// The new core counts from zero, so all of its traffic is reported
+	send(2000)
+	resp, err := s.GetAllUsersStats(ctx, &GetAllUsersStatsRequest{Reset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Uplink < 2000 {
+		t.Errorf("Expected the traffic since the restart in full, got %+v", resp.Users)
+	}
+}
@@ -0,0 +1,66 @@
+// Package services provides business logic for config templating
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// templatePlaceholder matches ${NAME} and ${NAME:-default}
+var templatePlaceholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandConfigTemplate returns the JSON text of a config with ${NAME}
+// placeholders replaced by environment variables, and a warning listing
+// placeholders that were left unexpanded. Only variables starting with prefix
+// are expanded, so panel templates cannot read node secrets; other
+// placeholders and unset variables without a default are left as-is. The
+// text is not decoded: placeholders can only occur inside JSON strings, so
+// values are inserted JSON-escaped, and defaults are already escaped.
+func expandConfigTemplate(data []byte, prefix string) ([]byte, []string) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil
+	}
+
+	unresolved := make(map[string]struct{})
+	expanded := templatePlaceholder.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := templatePlaceholder.FindSubmatch(match)
+		name, def := string(groups[1]), groups[2]
+		if !strings.HasPrefix(name, prefix) {
+			unresolved[name] = struct{}{}
+			return match
+		}
+		if env, ok := os.LookupEnv(name); ok {
+			return escapeJSONString(env)
+		}
+		if bytes.Contains(match, []byte(":-")) {
+			return def
+		}
+		unresolved[name] = struct{}{}
+		return match
+	})
+	if len(unresolved) == 0 {
+		return expanded, nil
+	}
+
+	names := make([]string, 0, len(unresolved))
+	for name := range unresolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return expanded, []string{fmt.Sprintf("config placeholders not expanded (unset or not prefixed %s): %s", prefix, strings.Join(names, ", "))}
+}
+
+// escapeJSONString returns s escaped for use inside a JSON string
+func escapeJSONString(s string) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s) // Strings always encode
+	encoded := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return encoded[1 : len(encoded)-1]
+}
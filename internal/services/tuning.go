@@ -0,0 +1,215 @@
+// Package services provides business logic for kernel network tuning
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+)
+
+// sysctlPersistPath is where an applied profile is saved to survive reboots
+const sysctlPersistPath = "/etc/sysctl.d/99-remnawave-node.conf"
+
+// sysctlRecommendation is one tuned value. Exact values must match; minimum
+// values are compared numerically against the last field (e.g. the max of tcp_rmem).
+type sysctlRecommendation struct {
+	key     string
+	value   string
+	minimum bool
+}
+
+// tunedProfile lists the sysctls commonly tuned on proxy nodes
+var tunedProfile = []sysctlRecommendation{
+	{key: "net.core.default_qdisc", value: "fq"},
+	{key: "net.ipv4.tcp_congestion_control", value: "bbr"},
+	{key: "net.core.somaxconn", value: "32768", minimum: true},
+	{key: "net.ipv4.tcp_max_syn_backlog", value: "16384", minimum: true},
+	{key: "net.core.rmem_max", value: "33554432", minimum: true},
+	{key: "net.core.wmem_max", value: "33554432", minimum: true},
+	{key: "net.ipv4.tcp_rmem", value: "4096 131072 33554432", minimum: true},
+	{key: "net.ipv4.tcp_wmem", value: "4096 65536 33554432", minimum: true},
+	{key: "net.ipv4.tcp_fastopen", value: "3"},
+	{key: "net.netfilter.nf_conntrack_max", value: "1048576", minimum: true},
+}
+
+// SysctlCheck is the state of one tuned sysctl
+type SysctlCheck struct {
+	Key         string `json:"key"`
+	Current     string `json:"current,omitempty"`
+	Recommended string `json:"recommended"`
+	OK          bool   `json:"ok"`
+	Available   bool   `json:"available"` // False when the kernel lacks the sysctl (e.g. conntrack not loaded)
+	Error       string `json:"error,omitempty"`
+}
+
+// TuningReport lists tuned sysctls and their deviations
+type TuningReport struct {
+	Supported  bool           `json:"supported"` // Linux only
+	Deviations int            `json:"deviations"`
+	Checks     []*SysctlCheck `json:"checks"`
+}
+
+// ApplyTuningRequest represents a request to apply the tuned profile
+type ApplyTuningRequest struct {
+	Keys    []string `json:"keys"`    // Subset of sysctls to apply; empty applies all deviations
+	Persist bool     `json:"persist"` // Also write the values to /etc/sysctl.d
+}
+
+// TuningService inspects and applies network sysctls
+type TuningService struct {
+	logger      *zap.Logger
+	procSys     string // Root of the sysctl tree, /proc/sys
+	persistPath string // Where applied values are saved, sysctlPersistPath
+}
+
+// NewTuningService creates a new TuningService
+func NewTuningService(logger *zap.Logger) *TuningService {
+	return &TuningService{
+		logger:      logger,
+		procSys:     "/proc/sys",
+		persistPath: sysctlPersistPath,
+	}
+}
+
+// sysctlPath returns the /proc/sys file of a sysctl key
+func (s *TuningService) sysctlPath(key string) string {
+	return filepath.Join(s.procSys, strings.ReplaceAll(key, ".", "/"))
+}
+
+// check reads one sysctl and compares it with the recommendation
+func (s *TuningService) check(rec sysctlRecommendation) *SysctlCheck {
+	result := &SysctlCheck{Key: rec.key, Recommended: rec.value}
+
+	data, err := os.ReadFile(s.sysctlPath(rec.key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	result.Available = true
+	result.Current = strings.Join(strings.Fields(string(data)), " ")
+
+	if !rec.minimum {
+		result.OK = result.Current == rec.value
+		return result
+	}
+	current, err1 := lastField(result.Current)
+	wanted, err2 := lastField(rec.value)
+	result.OK = err1 == nil && err2 == nil && current >= wanted
+	return result
+}
+
+// lastField parses the last whitespace-separated number of a sysctl value
+func lastField(value string) (int64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty value")
+	}
+	return strconv.ParseInt(fields[len(fields)-1], 10, 64)
+}
+
+// Report inspects the tuned sysctls
+func (s *TuningService) Report() *TuningReport {
+	report := &TuningReport{Supported: runtime.GOOS == "linux", Checks: make([]*SysctlCheck, 0, len(tunedProfile))}
+	if !report.Supported {
+		return report
+	}
+
+	for _, rec := range tunedProfile {
+		c := s.check(rec)
+		if c.Available && !c.OK {
+			report.Deviations++
+		}
+		report.Checks = append(report.Checks, c)
+	}
+	return report
+}
+
+// Apply writes the recommended values of deviating sysctls (optionally only
+// the requested keys) and returns the resulting report
+func (s *TuningService) Apply(req *ApplyTuningRequest) (*TuningReport, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("sysctl tuning is only supported on Linux")
+	}
+
+	selected := make(map[string]bool, len(req.Keys))
+	for _, key := range req.Keys {
+		known := false
+		for _, rec := range tunedProfile {
+			known = known || rec.key == key
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown sysctl %q", key)
+		}
+		selected[key] = true
+	}
+
+	applyErrors := make(map[string]string)
+	applied := make(map[string]string)
+	for _, rec := range tunedProfile {
+		if len(selected) > 0 && !selected[rec.key] {
+			continue
+		}
+		c := s.check(rec)
+		if !c.Available || c.OK {
+			continue
+		}
+		if err := os.WriteFile(s.sysctlPath(rec.key), []byte(rec.value), 0644); err != nil {
+			applyErrors[rec.key] = err.Error()
+			s.logger.Warn("Failed to apply sysctl", zap.String("key", rec.key), zap.Error(err))
+			continue
+		}
+		s.logger.Info("Applied sysctl",
+			zap.String("key", rec.key),
+			zap.String("from", c.Current),
+			zap.String("to", rec.value))
+		applied[rec.key] = rec.value
+	}
+
+	if req.Persist && len(applied) > 0 {
+		if err := s.persist(applied); err != nil {
+			return nil, fmt.Errorf("failed to persist sysctls: %w", err)
+		}
+	}
+
+	report := s.Report()
+	for _, c := range report.Checks {
+		if msg, failed := applyErrors[c.Key]; failed {
+			c.Error = msg
+		}
+	}
+	return report, nil
+}
+
+// persist saves the applied values, keeping those saved by earlier applies.
+// Only values the node raised are written, so a host setting higher than
+// the profile is never lowered at the next boot.
+func (s *TuningService) persist(applied map[string]string) error {
+	values := make(map[string]string)
+	if data, err := os.ReadFile(s.persistPath); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if key, value, ok := strings.Cut(line, "="); ok && !strings.HasPrefix(line, "#") {
+				values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	for key, value := range applied {
+		values[key] = value
+	}
+
+	lines := []string{"# Written by remnawave-node tuning"}
+	for _, rec := range tunedProfile {
+		if value, ok := values[rec.key]; ok {
+			lines = append(lines, fmt.Sprintf("%s = %s", rec.key, value))
+		}
+	}
+	return atomicfile.WriteFile(s.persistPath, []byte(strings.Join(lines, "\n")+"\n"), 0644, false)
+}
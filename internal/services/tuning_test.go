@@ -0,0 +1,50 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestTuning_PersistsOnlyRaisedValues(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sysctl tuning is Linux only")
+	}
+
+	dir := t.TempDir()
+	s := NewTuningService(zap.NewNop())
+	s.procSys = filepath.Join(dir, "sys")
+	s.persistPath = filepath.Join(dir, "99-remnawave-node.conf")
+
+	write := func(key, value string) {
+		path := s.sysctlPath(key)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("net.core.somaxconn", "65535") // The host already sets more
+	write("net.core.rmem_max", "212992")
+	write("net.core.wmem_max", "212992")
+
+	if _, err := s.Apply(&ApplyTuningRequest{Keys: []string{"net.core.somaxconn", "net.core.rmem_max"}, Persist: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Apply(&ApplyTuningRequest{Keys: []string{"net.core.wmem_max"}, Persist: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(s.persistPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Written by remnawave-node tuning\nnet.core.rmem_max = 33554432\nnet.core.wmem_max = 33554432\n"
+	if string(data) != want {
+		t.Errorf("Expected only raised values, kept across applies, got %q", data)
+	}
+}
@@ -0,0 +1,136 @@
+// Package services provides business logic for username normalization
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Username normalization modes
+const (
+	UsernameLowercase = "lowercase"
+	UsernameTrim      = "trim"
+)
+
+// emailProtocols are the inbound protocols whose clients are identified by
+// their email. Credentials (passwords, UUIDs, socks/http accounts) are never
+// normalized, only the identifier stats and user management are keyed by.
+var emailProtocols = map[string]bool{
+	"vless":       true,
+	"vmess":       true,
+	"trojan":      true,
+	"shadowsocks": true,
+}
+
+// UsernameNormalizer rewrites usernames (Xray emails) to one canonical form.
+// Xray compares emails case-sensitively, so a panel sending "Alice" to
+// add-user and "alice" to a stats lookup would silently miss the user. The
+// same normalizer is applied to the started config, user mutations and
+// stats lookups, so every path agrees on the stored name. A nil normalizer
+// leaves names unchanged.
+type UsernameNormalizer struct {
+	lowercase bool
+	trim      bool
+}
+
+// NewUsernameNormalizer creates a normalizer applying the given modes, nil
+// when there are none
+func NewUsernameNormalizer(modes []string) (*UsernameNormalizer, error) {
+	if len(modes) == 0 {
+		return nil, nil
+	}
+
+	n := &UsernameNormalizer{}
+	for _, mode := range modes {
+		switch strings.ToLower(mode) {
+		case UsernameLowercase:
+			n.lowercase = true
+		case UsernameTrim:
+			n.trim = true
+		default:
+			return nil, fmt.Errorf("unknown username normalization %q (expected %s or %s)", mode, UsernameLowercase, UsernameTrim)
+		}
+	}
+	return n, nil
+}
+
+// Normalize returns the canonical form of a username
+func (n *UsernameNormalizer) Normalize(name string) string {
+	if n == nil {
+		return name
+	}
+	if n.trim {
+		name = strings.TrimSpace(name)
+	}
+	if n.lowercase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// NormalizeAll normalizes usernames in place
+func (n *UsernameNormalizer) NormalizeAll(names []string) {
+	if n == nil {
+		return
+	}
+	for i := range names {
+		names[i] = n.Normalize(names[i])
+	}
+}
+
+// normalizeClientEmails rewrites the client emails of inbounds whose protocol
+// identifies users by email, and returns warnings for clients of one inbound
+// that end up with the same email. Only inbounds with a changed email are
+// re-encoded; the rest of the settings are kept as they are.
+func normalizeClientEmails(doc *configDocument, n *UsernameNormalizer) []string {
+	if n == nil {
+		return nil
+	}
+
+	var warnings []string
+	for i := range doc.inbounds {
+		var protocol string
+		if !doc.inboundField(i, "protocol", &protocol) || !emailProtocols[protocol] {
+			continue
+		}
+		var settings map[string]json.RawMessage
+		if !doc.inboundField(i, "settings", &settings) || settings == nil {
+			continue
+		}
+		var clients []map[string]json.RawMessage
+		if err := json.Unmarshal(settings["clients"], &clients); err != nil || len(clients) == 0 {
+			continue
+		}
+
+		changed := false
+		seen := make(map[string]bool, len(clients))
+		var collisions []string
+		for _, client := range clients {
+			var email string
+			if err := json.Unmarshal(client["email"], &email); err != nil || email == "" {
+				continue
+			}
+			normalized := n.Normalize(email)
+			if normalized != email {
+				client["email"], _ = json.Marshal(normalized) // Strings always marshal
+				changed = true
+			}
+			if seen[normalized] {
+				collisions = append(collisions, normalized)
+			}
+			seen[normalized] = true
+		}
+		if len(collisions) > 0 {
+			warnings = append(warnings, fmt.Sprintf("inbound %q: clients share a normalized username: %s", doc.inboundTag(i), strings.Join(collisions, ", ")))
+		}
+		if !changed {
+			continue
+		}
+
+		data, _ := json.Marshal(clients) // Raw values are valid JSON
+		settings["clients"] = data
+		doc.setInboundField(i, "settings", settings)
+	}
+	return warnings
+}
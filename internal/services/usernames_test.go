@@ -0,0 +1,62 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUsernameNormalizer(t *testing.T) {
+	n, err := NewUsernameNormalizer([]string{"lowercase", "TRIM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Normalize("  Alice@Example.com "); got != "alice@example.com" {
+		t.Errorf("Expected a trimmed lowercase name, got %q", got)
+	}
+
+	var none *UsernameNormalizer
+	if got := none.Normalize(" Alice "); got != " Alice " {
+		t.Errorf("Expected a nil normalizer to keep the name, got %q", got)
+	}
+
+	if _, err := NewUsernameNormalizer([]string{"uppercase"}); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+}
+
+func TestNormalizeClientEmails(t *testing.T) {
+	n, _ := NewUsernameNormalizer([]string{UsernameLowercase})
+	doc, err := parseConfigDocument([]byte(`{"inbounds": [
+		{"tag": "VLESS", "protocol": "vless", "settings": {"decryption": "none", "clients": [
+			{"id": "` + testUUID1 + `", "email": "Alice", "level": 0},
+			{"id": "` + testUUID2 + `", "email": "alice"}
+		]}},
+		{"tag": "SOCKS", "protocol": "socks", "settings": {"accounts": [{"user": "Bob", "pass": "Secret"}], "clients": [{"email": "Bob"}]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := normalizeClientEmails(doc, n)
+	if len(warnings) != 1 {
+		t.Errorf("Expected a warning for the colliding clients, got %v", warnings)
+	}
+
+	var vless struct {
+		Decryption string `json:"decryption"`
+		Clients    []struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		} `json:"clients"`
+	}
+	doc.inboundField(0, "settings", &vless)
+	if vless.Decryption != "none" || vless.Clients[0].Email != "alice" || vless.Clients[0].ID != testUUID1 {
+		t.Errorf("Expected only the email to be normalized, got %+v", vless)
+	}
+
+	var socks json.RawMessage
+	doc.inboundField(1, "settings", &socks)
+	if string(socks) != `{"accounts": [{"user": "Bob", "pass": "Secret"}], "clients": [{"email": "Bob"}]}` {
+		t.Errorf("Expected protocols without email users to be left as sent, got %s", socks)
+	}
+}
@@ -0,0 +1,226 @@
+// Package services provides business logic for validating user credentials
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// uuidPattern matches an RFC 4122 UUID in its canonical textual form, with the
+// RFC 4122 variant bits set
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12}$`)
+
+// validVlessFlows are the flows Xray accepts for vless users
+var validVlessFlows = map[string]bool{
+	"":                        true,
+	"xtls-rprx-vision":        true,
+	"xtls-rprx-vision-udp443": true,
+}
+
+// ss2022KeyLengths are the key sizes of the shadowsocks 2022 methods, whose
+// passwords are base64 keys rather than passphrases
+var ss2022KeyLengths = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+// FieldError is a validation failure of one request field
+type FieldError struct {
+	Field   string `json:"field"` // e.g. "data[0].uuid" or "users[2].userData.vlessUuid"
+	Message string `json:"message"`
+}
+
+// ValidationError collects all field errors of a request
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+// Error lists the failing fields
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return "invalid user data: " + strings.Join(parts, "; ")
+}
+
+// add records a field error
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// orNil returns the error if any field failed, nil otherwise
+func (e *ValidationError) orNil() *ValidationError {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateUUID checks a vless UUID
+func validateUUID(v *ValidationError, field, uuid string) {
+	switch {
+	case uuid == "":
+		v.add(field, "is required")
+	case !uuidPattern.MatchString(uuid):
+		v.add(field, "%q is not an RFC 4122 UUID", uuid)
+	}
+}
+
+// validateFlow checks a vless flow
+func validateFlow(v *ValidationError, field, flow string) {
+	if !validVlessFlows[flow] {
+		v.add(field, "unsupported flow %q (expected \"\", \"xtls-rprx-vision\" or \"xtls-rprx-vision-udp443\")", flow)
+	}
+}
+
+// validateTrojanPassword checks a trojan password. It is sent in the clear
+// inside the TLS stream and embedded in share links, so only printable ASCII
+// without spaces is accepted.
+func validateTrojanPassword(v *ValidationError, field, password string) {
+	if password == "" {
+		v.add(field, "is required")
+		return
+	}
+	for i, r := range password {
+		if r < 0x21 || r > 0x7e {
+			v.add(field, "invalid character %q at position %d (printable ASCII without spaces only)", r, i)
+			return
+		}
+	}
+}
+
+// validateShadowsocks checks a shadowsocks cipher and password. On a
+// shadowsocks 2022 inbound (method) the password must be a base64 key of the
+// method's size; the AEAD ciphers derive their key from the password, so any
+// non-empty password works; "none" ignores it.
+func validateShadowsocks(v *ValidationError, cipherField, passwordField string, cipher CipherType, method, password string) {
+	if size, ok := ss2022KeyLengths[method]; ok {
+		key, err := base64.StdEncoding.DecodeString(password)
+		switch {
+		case password == "":
+			v.add(passwordField, "is required for method %s", method)
+		case err != nil:
+			v.add(passwordField, "is not a base64 key (required by method %s)", method)
+		case len(key) != size:
+			v.add(passwordField, "is a %d-byte key, method %s needs %d bytes", len(key), method, size)
+		}
+		return
+	}
+
+	switch cipher {
+	case CipherTypeUnknown, CipherTypeAES128GCM, CipherTypeAES256GCM, CipherTypeCHACHA20POLY1305, CipherTypeXCHACHA20POLY1305:
+		if password == "" {
+			v.add(passwordField, "is required for cipher %s", cipherTypeToMethod(cipher))
+		}
+	case CipherTypeNone:
+	default:
+		v.add(cipherField, "unsupported cipher type %d", cipher)
+	}
+}
+
+// shadowsocksMethods returns the method of each shadowsocks inbound of the
+// running config by tag, or nil if the config can't be read
+func (s *HandlerService) shadowsocksMethods() map[string]string {
+	if s.xrayCore == nil {
+		return nil
+	}
+
+	var config struct {
+		Inbounds []struct {
+			Tag      string `json:"tag"`
+			Protocol string `json:"protocol"`
+			Settings struct {
+				Method string `json:"method"`
+			} `json:"settings"`
+		} `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil
+	}
+
+	methods := make(map[string]string)
+	for _, inbound := range config.Inbounds {
+		if inbound.Protocol == "shadowsocks" {
+			methods[inbound.Tag] = inbound.Settings.Method
+		}
+	}
+	return methods
+}
+
+// validateAddUser checks every item of an add-user request before any core
+// call; methods are the shadowsocks inbound methods by tag
+func validateAddUser(req *AddUserRequest, methods map[string]string) *ValidationError {
+	v := &ValidationError{}
+	for i, item := range req.Data {
+		field := func(name string) string { return fmt.Sprintf("data[%d].%s", i, name) }
+
+		if item.Username == "" {
+			v.add(field("username"), "is required")
+		}
+		if item.Tag == "" {
+			v.add(field("tag"), "is required")
+		}
+		switch item.Type {
+		case "vless":
+			validateUUID(v, field("uuid"), item.UUID)
+			validateFlow(v, field("flow"), item.Flow)
+		case "trojan":
+			validateTrojanPassword(v, field("password"), item.Password)
+		case "shadowsocks":
+			validateShadowsocks(v, field("cipherType"), field("password"), item.CipherType, methods[item.Tag], item.Password)
+		}
+	}
+	return v.orNil()
+}
+
+// validateAddUsers checks every user of a batch add request before any core
+// call; credentials are only required for the types the user is added with,
+// and methods are the shadowsocks inbound methods by tag
+func validateAddUsers(req *AddUsersRequest, methods map[string]string) *ValidationError {
+	v := &ValidationError{}
+	for i, user := range req.Users {
+		field := func(name string) string { return fmt.Sprintf("users[%d].%s", i, name) }
+
+		if user.UserData.UserId == "" {
+			v.add(field("userData.userId"), "is required")
+		}
+
+		types := make(map[string]bool)
+		ssMethods := make(map[string]bool)
+		for j, item := range user.InboundData {
+			if item.Tag == "" {
+				v.add(field(fmt.Sprintf("inboundData[%d].tag", j)), "is required")
+			}
+			if item.Type == "vless" {
+				validateFlow(v, field(fmt.Sprintf("inboundData[%d].flow", j)), item.Flow)
+			}
+			if item.Type == "shadowsocks" {
+				ssMethods[methods[item.Tag]] = true
+			}
+			types[item.Type] = true
+		}
+
+		if types["vless"] {
+			validateUUID(v, field("userData.vlessUuid"), user.UserData.VlessUuid)
+		}
+		if types["trojan"] {
+			validateTrojanPassword(v, field("userData.trojanPassword"), user.UserData.TrojanPassword)
+		}
+		// One password serves every shadowsocks inbound, so check it once per method
+		sorted := make([]string, 0, len(ssMethods))
+		for method := range ssMethods {
+			sorted = append(sorted, method)
+		}
+		sort.Strings(sorted)
+		for _, method := range sorted {
+			validateShadowsocks(v, "", field("userData.ssPassword"), CipherTypeCHACHA20POLY1305, method, user.UserData.SsPassword)
+		}
+	}
+	return v.orNil()
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const (
+	testSS128Key = "AAECAwQFBgcICQoLDA0ODw=="                     // 16 bytes
+	testSS256Key = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=" // 32 bytes
+)
+
+var testSSMethods = map[string]string{
+	"SS":        "chacha20-poly1305",
+	"SS2022":    "2022-blake3-aes-128-gcm",
+	"SS2022256": "2022-blake3-aes-256-gcm",
+	"SS2022CC":  "2022-blake3-chacha20-poly1305",
+}
+
+func TestValidateAddUser(t *testing.T) {
+	tests := []struct {
+		name  string
+		item  UserData
+		field string // failing field, "" if valid
+	}{
+		{"vless", vlessUser("VLESS", "alice", testUUID1), ""},
+		{"vless uppercase uuid", vlessUser("VLESS", "alice", strings.ToUpper(testUUID1)), ""},
+		{"vless bad uuid", vlessUser("VLESS", "alice", "not-a-uuid"), "data[0].uuid"},
+		{"vless wrong variant", vlessUser("VLESS", "alice", "5f0c3b4e-2d1a-4c8b-1e7f-1a2b3c4d5e6f"), "data[0].uuid"},
+		{"vless missing uuid", vlessUser("VLESS", "alice", ""), "data[0].uuid"},
+		{"vless vision", UserData{Type: "vless", Tag: "VLESS", Username: "alice", UUID: testUUID1, Flow: "xtls-rprx-vision"}, ""},
+		{"vless bad flow", UserData{Type: "vless", Tag: "VLESS", Username: "alice", UUID: testUUID1, Flow: "xtls-rprx-direct"}, "data[0].flow"},
+		{"missing username", vlessUser("VLESS", "", testUUID1), "data[0].username"},
+		{"missing tag", vlessUser("", "alice", testUUID1), "data[0].tag"},
+		{"trojan", UserData{Type: "trojan", Tag: "TROJAN", Username: "alice", Password: "hunter2!"}, ""},
+		{"trojan space", UserData{Type: "trojan", Tag: "TROJAN", Username: "alice", Password: "hunter 2"}, "data[0].password"},
+		{"trojan non-ascii", UserData{Type: "trojan", Tag: "TROJAN", Username: "alice", Password: "pässword"}, "data[0].password"},
+		{"trojan missing password", UserData{Type: "trojan", Tag: "TROJAN", Username: "alice"}, "data[0].password"},
+		{"ss", UserData{Type: "shadowsocks", Tag: "SS", Username: "alice", Password: "secret", CipherType: CipherTypeAES256GCM}, ""},
+		{"ss missing password", UserData{Type: "shadowsocks", Tag: "SS", Username: "alice", CipherType: CipherTypeAES256GCM}, "data[0].password"},
+		{"ss none", UserData{Type: "shadowsocks", Tag: "SS", Username: "alice", CipherType: CipherTypeNone}, ""},
+		{"ss bad cipher", UserData{Type: "shadowsocks", Tag: "SS", Username: "alice", Password: "secret", CipherType: 42}, "data[0].cipherType"},
+		{"ss2022 aes-128", UserData{Type: "shadowsocks", Tag: "SS2022", Username: "alice", Password: testSS128Key}, ""},
+		{"ss2022 aes-128 long key", UserData{Type: "shadowsocks", Tag: "SS2022", Username: "alice", Password: testSS256Key}, "data[0].password"},
+		{"ss2022 aes-256", UserData{Type: "shadowsocks", Tag: "SS2022256", Username: "alice", Password: testSS256Key}, ""},
+		{"ss2022 aes-256 short key", UserData{Type: "shadowsocks", Tag: "SS2022256", Username: "alice", Password: testSS128Key}, "data[0].password"},
+		{"ss2022 chacha20", UserData{Type: "shadowsocks", Tag: "SS2022CC", Username: "alice", Password: testSS256Key}, ""},
+		{"ss2022 chacha20 short key", UserData{Type: "shadowsocks", Tag: "SS2022CC", Username: "alice", Password: testSS128Key}, "data[0].password"},
+		{"ss2022 passphrase", UserData{Type: "shadowsocks", Tag: "SS2022", Username: "alice", Password: "secret"}, "data[0].password"},
+		{"ss2022 missing password", UserData{Type: "shadowsocks", Tag: "SS2022", Username: "alice"}, "data[0].password"},
+	}
+	for _, tt := range tests {
+		verr := validateAddUser(&AddUserRequest{Data: []UserData{tt.item}}, testSSMethods)
+		switch {
+		case tt.field == "" && verr != nil:
+			t.Errorf("%s: expected valid, got %v", tt.name, verr)
+		case tt.field != "" && (verr == nil || verr.Fields[0].Field != tt.field):
+			t.Errorf("%s: expected an error for %s, got %v", tt.name, tt.field, verr)
+		}
+	}
+}
+
+func TestValidateAddUsers(t *testing.T) {
+	user := func(password string, tags ...string) UserForBatch {
+		u := UserForBatch{UserData: UserDataForBatch{UserId: "alice", SsPassword: password}}
+		for _, tag := range tags {
+			u.InboundData = append(u.InboundData, InboundData{Type: "shadowsocks", Tag: tag})
+		}
+		return u
+	}
+
+	tests := []struct {
+		name   string
+		user   UserForBatch
+		errors int
+	}{
+		{"passphrase", user("secret", "SS"), 0},
+		{"ss2022 key", user(testSS128Key, "SS2022"), 0},
+		{"ss2022 key on both sizes", user(testSS128Key, "SS2022", "SS2022256"), 1},
+		{"passphrase on ss2022", user("secret", "SS", "SS2022"), 1},
+		{"same method twice", user("secret", "SS2022", "SS2022"), 1},
+		{"missing password", user("", "SS", "SS2022256"), 2},
+	}
+	for _, tt := range tests {
+		verr := validateAddUsers(&AddUsersRequest{Users: []UserForBatch{tt.user}}, testSSMethods)
+		var fields []*FieldError
+		if verr != nil {
+			fields = verr.Fields
+		}
+		if len(fields) != tt.errors {
+			t.Errorf("%s: expected %d errors, got %v", tt.name, tt.errors, verr)
+		}
+		for _, f := range fields {
+			if f.Field != "users[0].userData.ssPassword" {
+				t.Errorf("%s: unexpected field %s", tt.name, f.Field)
+			}
+		}
+	}
+}
+
+func TestHandler_AddUser_ChecksSS2022KeyOfInbound(t *testing.T) {
+	core := newFakeCore(true)
+	core.config = []byte(`{"inbounds": [{"tag": "SS2022", "protocol": "shadowsocks", "settings": {"method": "2022-blake3-aes-256-gcm"}}]}`)
+	handler, _ := newTestHandler(core)
+
+	resp, _ := handler.AddUser(context.Background(), &AddUserRequest{
+		Data: []UserData{{Type: "shadowsocks", Tag: "SS2022", Username: "alice", Password: testSS128Key}},
+	})
+	if resp.Success || len(resp.FieldErrors) != 1 || !strings.Contains(resp.FieldErrors[0].Message, "needs 32 bytes") {
+		t.Errorf("Expected the key size to be rejected, got %+v", resp)
+	}
+	if calls := core.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no core calls for a rejected request, got %v", calls)
+	}
+}
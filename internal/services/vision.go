@@ -5,7 +5,10 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -17,13 +20,50 @@ type VisionService struct {
 	mu         sync.RWMutex
 	logger     *zap.Logger
 	xrayCore   *xraycore.Instance
-	blockedIPs map[string]string // IP -> ruleTag (MD5 hash)
+	blockedIPs map[string]*BlockEntry // IP -> block
 	blockTag   string
+	hooks      *HookService   // Fired with ip-blocked events; nil runs none
+	policies   *PolicyService // Veto or rewrite blocks; nil allows all
+}
+
+// Block sources
+const (
+	BlockSourcePanel         = "panel"
+	BlockSourceAuto          = "auto"
+	BlockSourceAbuseDetector = "abuse-detector"
+	BlockSourcePeer          = "peer" // Learned from fleet peers, never accepted in requests
+)
+
+// BlockEntry is a blocked IP with who blocked it and why
+type BlockEntry struct {
+	IP        string    `json:"ip"`
+	Username  string    `json:"username,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source"`
+	Admin     string    `json:"admin,omitempty"` // Requesting panel admin
+	BlockedAt time.Time `json:"blockedAt"`
+
+	ruleTag string // Router rule tag (MD5 hash of the IP)
+}
+
+// blockSource returns the source of a block or unblock request, panel when
+// not given
+func blockSource(source string) (string, error) {
+	switch source {
+	case "":
+		return BlockSourcePanel, nil
+	case BlockSourcePanel, BlockSourceAuto, BlockSourceAbuseDetector:
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown source %q (expected %s, %s or %s)", source, BlockSourcePanel, BlockSourceAuto, BlockSourceAbuseDetector)
+	}
 }
 
 // VisionConfig holds Vision service configuration
 type VisionConfig struct {
-	BlockTag string // The outbound tag for blocked traffic (e.g., "block" or "BLOCK")
+	BlockTag string         // The outbound tag for blocked traffic (e.g., "block" or "BLOCK")
+	Hooks    *HookService   // Optional; runs ip-blocked hooks
+	Policies *PolicyService // Optional; checked before blocks and unblocks
 }
 
 // NewVisionService creates a new VisionService
@@ -35,8 +75,10 @@ func NewVisionService(cfg *VisionConfig, xrayCore *xraycore.Instance, logger *za
 	return &VisionService{
 		logger:     logger,
 		xrayCore:   xrayCore,
-		blockedIPs: make(map[string]string),
+		blockedIPs: make(map[string]*BlockEntry),
 		blockTag:   blockTag,
+		hooks:      cfg.Hooks,
+		policies:   cfg.Policies,
 	}
 }
 
@@ -50,6 +92,11 @@ func (s *VisionService) getIPHash(ip string) string {
 type BlockIPRequest struct {
 	IP       string `json:"ip"`
 	Username string `json:"username"` // For logging/tracking, not used in blocking logic
+
+	// Audit metadata stored with the block
+	Reason string `json:"reason,omitempty"`
+	Source string `json:"source,omitempty"` // panel (default), auto or abuse-detector
+	Admin  string `json:"admin,omitempty"`
 }
 
 // BlockIPResponse represents the response from blocking an IP
@@ -61,11 +108,35 @@ type BlockIPResponse struct {
 
 // BlockIP blocks an IP address
 func (s *VisionService) BlockIP(ctx context.Context, req *BlockIPRequest) (*BlockIPResponse, error) {
+	source, err := blockSource(req.Source)
+	if err != nil {
+		errMsg := err.Error()
+		return &BlockIPResponse{Success: false, Error: &errMsg}, nil
+	}
+	preq := &PolicyRequest{Kind: PolicyBlockIP, IP: req.IP, Username: req.Username, Reason: req.Reason, Source: source, Admin: req.Admin}
+	if err := s.policies.Check(ctx, preq); err != nil {
+		errMsg := err.Error()
+		return &BlockIPResponse{Success: false, Error: &errMsg}, nil
+	}
+	req.Reason = preq.Reason
+	entry := &BlockEntry{
+		IP:        req.IP,
+		Username:  req.Username,
+		Reason:    req.Reason,
+		Source:    source,
+		Admin:     req.Admin,
+		BlockedAt: time.Now().UTC(),
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check if already blocked
-	if _, exists := s.blockedIPs[req.IP]; exists {
+	// Check if already blocked; a block learned from peers becomes a local one
+	if existing, exists := s.blockedIPs[req.IP]; exists {
+		if existing.Source == BlockSourcePeer {
+			entry.ruleTag = existing.ruleTag
+			s.blockedIPs[req.IP] = entry
+		}
 		return &BlockIPResponse{
 			Success: true,
 			Error:   nil,
@@ -87,10 +158,16 @@ func (s *VisionService) BlockIP(ctx context.Context, req *BlockIPRequest) (*Bloc
 		}
 	}
 
-	s.blockedIPs[req.IP] = ruleTag
+	entry.ruleTag = ruleTag
+	s.blockedIPs[req.IP] = entry
 	s.logger.Info("Blocked IP",
 		zap.String("ip", req.IP),
-		zap.String("ruleTag", ruleTag))
+		zap.String("ruleTag", ruleTag),
+		zap.String("username", req.Username),
+		zap.String("reason", req.Reason),
+		zap.String("source", source),
+		zap.String("admin", req.Admin))
+	s.hooks.Fire(&HookEvent{Event: HookIPBlocked, IP: req.IP, Username: req.Username, Reason: req.Reason, Source: source, Admin: req.Admin})
 
 	return &BlockIPResponse{Success: true, Error: nil}, nil
 }
@@ -100,6 +177,11 @@ func (s *VisionService) BlockIP(ctx context.Context, req *BlockIPRequest) (*Bloc
 type UnblockIPRequest struct {
 	IP       string `json:"ip"`
 	Username string `json:"username"` // For logging/tracking, not used in unblocking logic
+
+	// Audit metadata, logged with the unblock
+	Reason string `json:"reason,omitempty"`
+	Source string `json:"source,omitempty"` // panel (default), auto or abuse-detector
+	Admin  string `json:"admin,omitempty"`
 }
 
 // UnblockIPResponse represents the response from unblocking an IP
@@ -111,11 +193,22 @@ type UnblockIPResponse struct {
 
 // UnblockIP unblocks an IP address
 func (s *VisionService) UnblockIP(ctx context.Context, req *UnblockIPRequest) (*UnblockIPResponse, error) {
+	source, err := blockSource(req.Source)
+	if err != nil {
+		errMsg := err.Error()
+		return &UnblockIPResponse{Success: false, Error: &errMsg}, nil
+	}
+	preq := &PolicyRequest{Kind: PolicyUnblockIP, IP: req.IP, Username: req.Username, Reason: req.Reason, Source: source, Admin: req.Admin}
+	if err := s.policies.Check(ctx, preq); err != nil {
+		errMsg := err.Error()
+		return &UnblockIPResponse{Success: false, Error: &errMsg}, nil
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Check if not blocked
-	ruleTag, exists := s.blockedIPs[req.IP]
+	entry, exists := s.blockedIPs[req.IP]
 	if !exists {
 		return &UnblockIPResponse{
 			Success: true,
@@ -125,10 +218,10 @@ func (s *VisionService) UnblockIP(ctx context.Context, req *UnblockIPRequest) (*
 
 	// Remove rule via embedded Xray router
 	if s.xrayCore != nil && s.xrayCore.IsRunning() {
-		if err := s.xrayCore.RemoveRoutingRule(ctx, ruleTag); err != nil {
+		if err := s.xrayCore.RemoveRoutingRule(ctx, entry.ruleTag); err != nil {
 			s.logger.Error("Failed to remove block rule",
 				zap.String("ip", req.IP),
-				zap.String("ruleTag", ruleTag),
+				zap.String("ruleTag", entry.ruleTag),
 				zap.Error(err))
 			errMsg := err.Error()
 			return &UnblockIPResponse{Success: false, Error: &errMsg}, nil
@@ -138,14 +231,20 @@ func (s *VisionService) UnblockIP(ctx context.Context, req *UnblockIPRequest) (*
 	delete(s.blockedIPs, req.IP)
 	s.logger.Info("Unblocked IP",
 		zap.String("ip", req.IP),
-		zap.String("ruleTag", ruleTag))
+		zap.String("ruleTag", entry.ruleTag),
+		zap.String("reason", req.Reason),
+		zap.String("source", source),
+		zap.String("admin", req.Admin),
+		zap.String("blockReason", entry.Reason),
+		zap.String("blockSource", entry.Source))
 
 	return &UnblockIPResponse{Success: true, Error: nil}, nil
 }
 
 // GetBlockedIPsResponse represents the list of blocked IPs
 type GetBlockedIPsResponse struct {
-	IPs []string `json:"ips"`
+	IPs     []string      `json:"ips"`
+	Entries []*BlockEntry `json:"entries"` // Sorted by IP, with block metadata
 }
 
 // GetBlockedIPs returns all blocked IPs
@@ -154,11 +253,16 @@ func (s *VisionService) GetBlockedIPs() *GetBlockedIPsResponse {
 	defer s.mu.RUnlock()
 
 	ips := make([]string, 0, len(s.blockedIPs))
-	for ip := range s.blockedIPs {
+	entries := make([]*BlockEntry, 0, len(s.blockedIPs))
+	for ip, entry := range s.blockedIPs {
 		ips = append(ips, ip)
+		copied := *entry
+		entries = append(entries, &copied)
 	}
+	sort.Strings(ips)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
 
-	return &GetBlockedIPsResponse{IPs: ips}
+	return &GetBlockedIPsResponse{IPs: ips, Entries: entries}
 }
 
 // ClearBlockedIPs clears all blocked IPs
@@ -166,19 +270,85 @@ func (s *VisionService) ClearBlockedIPs(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for ip, ruleTag := range s.blockedIPs {
+	for ip, entry := range s.blockedIPs {
 		if s.xrayCore != nil && s.xrayCore.IsRunning() {
-			if err := s.xrayCore.RemoveRoutingRule(ctx, ruleTag); err != nil {
+			if err := s.xrayCore.RemoveRoutingRule(ctx, entry.ruleTag); err != nil {
 				s.logger.Warn("Failed to remove block rule during clear",
 					zap.String("ip", ip),
-					zap.String("ruleTag", ruleTag),
+					zap.String("ruleTag", entry.ruleTag),
 					zap.Error(err))
 			}
 		}
 	}
 
-	s.blockedIPs = make(map[string]string)
+	s.blockedIPs = make(map[string]*BlockEntry)
 	s.logger.Info("Cleared all blocked IPs")
 
 	return nil
 }
+
+// LocalBlockedIPs returns the IPs blocked on this node, excluding those only learned from peers
+func (s *VisionService) LocalBlockedIPs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ips := make([]string, 0, len(s.blockedIPs))
+	for ip, entry := range s.blockedIPs {
+		if entry.Source != BlockSourcePeer {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// BlockPeerIP blocks an IP reported by a fleet peer. IPs already blocked are left as they are.
+func (s *VisionService) BlockPeerIP(ctx context.Context, ip string) error {
+	if err := s.policies.Check(ctx, &PolicyRequest{Kind: PolicyBlockIP, IP: ip, Source: BlockSourcePeer}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.blockedIPs[ip]; exists {
+		return nil
+	}
+
+	ruleTag := s.getIPHash(ip)
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		if err := s.xrayCore.AddRoutingRule(ctx, ruleTag, ip, s.blockTag); err != nil {
+			return err
+		}
+	}
+
+	s.blockedIPs[ip] = &BlockEntry{
+		IP:        ip,
+		Source:    BlockSourcePeer,
+		BlockedAt: time.Now().UTC(),
+		ruleTag:   ruleTag,
+	}
+	s.logger.Info("Blocked IP from peer", zap.String("ip", ip))
+	s.hooks.Fire(&HookEvent{Event: HookIPBlocked, IP: ip, Source: BlockSourcePeer})
+	return nil
+}
+
+// UnblockPeerIP removes a block learned from peers. Local blocks are kept.
+func (s *VisionService) UnblockPeerIP(ctx context.Context, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.blockedIPs[ip]
+	if !exists || entry.Source != BlockSourcePeer {
+		return nil
+	}
+
+	if s.xrayCore != nil && s.xrayCore.IsRunning() {
+		if err := s.xrayCore.RemoveRoutingRule(ctx, entry.ruleTag); err != nil {
+			return err
+		}
+	}
+
+	delete(s.blockedIPs, ip)
+	s.logger.Info("Unblocked IP from peer", zap.String("ip", ip))
+	return nil
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestVision_BlockMetadata(t *testing.T) {
+	s := NewVisionService(&VisionConfig{}, nil, zap.NewNop())
+	ctx := context.Background()
+
+	if err := s.BlockPeerIP(ctx, "203.0.113.7"); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := s.BlockIP(ctx, &BlockIPRequest{IP: "203.0.113.7", Username: "alice", Reason: "torrent", Source: BlockSourceAbuseDetector, Admin: "ops"})
+	if !resp.Success {
+		t.Fatalf("Expected block to succeed, got %v", *resp.Error)
+	}
+
+	entries := s.GetBlockedIPs().Entries
+	if len(entries) != 1 {
+		t.Fatalf("Expected one block, got %d", len(entries))
+	}
+	if e := entries[0]; e.Source != BlockSourceAbuseDetector || e.Reason != "torrent" || e.Admin != "ops" || e.Username != "alice" {
+		t.Errorf("Expected the peer block to take the local metadata, got %+v", e)
+	}
+	if len(s.LocalBlockedIPs()) != 1 {
+		t.Error("Expected the block to be local")
+	}
+
+	resp, _ = s.BlockIP(ctx, &BlockIPRequest{IP: "198.51.100.1", Source: BlockSourcePeer})
+	if resp.Success {
+		t.Error("Expected requests to be refused the peer source")
+	}
+}
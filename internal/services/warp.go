@@ -0,0 +1,333 @@
+// Package services provides business logic for Cloudflare WARP chaining
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+const (
+	// warpRegisterURL is the Cloudflare WARP client registration API
+	warpRegisterURL = "https://api.cloudflareclient.com/v0a2158/reg"
+	// warpDefaultEndpoint is used when the registration response has no endpoint host
+	warpDefaultEndpoint = "engage.cloudflareclient.com:2408"
+	// warpRuleTag tags the routing rule sending selected traffic to WARP
+	warpRuleTag = "warp-route"
+	// DefaultWarpOutboundTag is the outbound tag used when none is requested
+	DefaultWarpOutboundTag = "warp"
+)
+
+// WarpAccount is a registered WARP device, persisted so registration happens once
+type WarpAccount struct {
+	ID            string `json:"id"`
+	Token         string `json:"token"`
+	PrivateKey    string `json:"privateKey"`
+	PeerPublicKey string `json:"peerPublicKey"`
+	Endpoint      string `json:"endpoint"`
+	AddressV4     string `json:"addressV4"`
+	AddressV6     string `json:"addressV6"`
+	ClientID      string `json:"clientId"` // base64, decodes to the 3 reserved bytes
+}
+
+// WarpService registers a WARP device and chains selected traffic through it
+type WarpService struct {
+	mu          sync.Mutex
+	logger      *zap.Logger
+	xrayCore    *xraycore.Instance
+	accountPath string
+	account     *WarpAccount
+	outboundTag string // non-empty while WARP routing is active in the core
+	httpClient  *http.Client
+}
+
+// WarpConfig holds WARP service configuration
+type WarpConfig struct {
+	StateDir string // Directory where the registered account is stored
+}
+
+// NewWarpService creates a new WarpService, loading a previously registered account
+func NewWarpService(cfg *WarpConfig, xrayCore *xraycore.Instance, logger *zap.Logger) *WarpService {
+	s := &WarpService{
+		logger:      logger,
+		xrayCore:    xrayCore,
+		accountPath: filepath.Join(cfg.StateDir, "warp.json"),
+		httpClient:  &http.Client{Timeout: 15 * time.Second},
+	}
+
+	if data, err := os.ReadFile(s.accountPath); err == nil {
+		var account WarpAccount
+		if err := json.Unmarshal(data, &account); err != nil {
+			logger.Warn("Failed to parse stored WARP account", zap.Error(err))
+		} else {
+			s.account = &account
+		}
+	}
+
+	return s
+}
+
+// warpRegisterResponse is the subset of the registration response we need
+type warpRegisterResponse struct {
+	ID     string `json:"id"`
+	Token  string `json:"token"`
+	Config struct {
+		ClientID string `json:"client_id"`
+		Peers    []struct {
+			PublicKey string `json:"public_key"`
+			Endpoint  struct {
+				Host string `json:"host"`
+			} `json:"endpoint"`
+		} `json:"peers"`
+		Interface struct {
+			Addresses struct {
+				V4 string `json:"v4"`
+				V6 string `json:"v6"`
+			} `json:"addresses"`
+		} `json:"interface"`
+	} `json:"config"`
+}
+
+// register creates a new WARP device with a locally generated key (caller must hold the lock)
+func (s *WarpService) register(ctx context.Context) (*WarpAccount, error) {
+	privateKey, publicKey, err := generateWireGuardKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"key":        publicKey,
+		"install_id": "",
+		"fcm_token":  "",
+		"tos":        time.Now().UTC().Format(time.RFC3339),
+		"type":       "Android",
+		"model":      "PC",
+		"locale":     "en_US",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, warpRegisterURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("CF-Client-Version", "a-6.11-2223")
+	req.Header.Set("User-Agent", "okhttp/3.12.1")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("WARP registration failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WARP registration returned %s", resp.Status)
+	}
+
+	var reg warpRegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&reg); err != nil {
+		return nil, fmt.Errorf("failed to parse WARP registration: %w", err)
+	}
+	if len(reg.Config.Peers) == 0 {
+		return nil, fmt.Errorf("WARP registration returned no peers")
+	}
+
+	endpoint := reg.Config.Peers[0].Endpoint.Host
+	if endpoint == "" {
+		endpoint = warpDefaultEndpoint
+	}
+
+	account := &WarpAccount{
+		ID:            reg.ID,
+		Token:         reg.Token,
+		PrivateKey:    privateKey,
+		PeerPublicKey: reg.Config.Peers[0].PublicKey,
+		Endpoint:      endpoint,
+		AddressV4:     reg.Config.Interface.Addresses.V4,
+		AddressV6:     reg.Config.Interface.Addresses.V6,
+		ClientID:      reg.Config.ClientID,
+	}
+
+	data, err := json.Marshal(account)
+	if err != nil {
+		return nil, err
+	}
+	if err := atomicfile.WriteFile(s.accountPath, data, 0600, false); err != nil {
+		return nil, fmt.Errorf("failed to store WARP account: %w", err)
+	}
+
+	s.logger.Info("Registered WARP device", zap.String("id", account.ID))
+	return account, nil
+}
+
+// buildOutbound returns the Xray wireguard outbound for the account
+func (a *WarpAccount) buildOutbound(tag string) ([]byte, error) {
+	var addresses []string
+	if a.AddressV4 != "" {
+		addresses = append(addresses, a.AddressV4+"/32")
+	}
+	if a.AddressV6 != "" {
+		addresses = append(addresses, a.AddressV6+"/128")
+	}
+
+	settings := map[string]interface{}{
+		"secretKey": a.PrivateKey,
+		"address":   addresses,
+		"peers": []map[string]interface{}{
+			{
+				"publicKey":  a.PeerPublicKey,
+				"endpoint":   a.Endpoint,
+				"allowedIPs": []string{"0.0.0.0/0", "::/0"},
+			},
+		},
+		"mtu": 1280,
+	}
+	if a.ClientID != "" {
+		settings["reserved"] = a.ClientID // []byte field, accepts base64
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"tag":      tag,
+		"protocol": "wireguard",
+		"settings": settings,
+	})
+}
+
+// EnableWarpRequest represents a request to route traffic through WARP
+type EnableWarpRequest struct {
+	OutboundTag string   `json:"outboundTag"`
+	Domains     []string `json:"domains"` // Xray domain matchers, e.g. "geosite:openai", "domain:example.com"
+	IPs         []string `json:"ips"`     // Xray IP matchers, e.g. "geoip:netflix", CIDRs
+}
+
+// WarpStatusResponse represents the WARP state
+type WarpStatusResponse struct {
+	Registered  bool   `json:"registered"`
+	Active      bool   `json:"active"`
+	OutboundTag string `json:"outboundTag,omitempty"`
+	AccountID   string `json:"accountId,omitempty"`
+}
+
+// status builds the status response (caller must hold the lock)
+func (s *WarpService) status() *WarpStatusResponse {
+	resp := &WarpStatusResponse{
+		Registered:  s.account != nil,
+		Active:      s.outboundTag != "",
+		OutboundTag: s.outboundTag,
+	}
+	if s.account != nil {
+		resp.AccountID = s.account.ID
+	}
+	return resp
+}
+
+// GetStatus returns the WARP state
+func (s *WarpService) GetStatus() *WarpStatusResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status()
+}
+
+// Enable registers a WARP device if needed, adds the WARP outbound to the running
+// core and routes the selected domains/IPs through it. Like Vision rules this
+// lives in the running core and must be re-applied after a restart.
+func (s *WarpService) Enable(ctx context.Context, req *EnableWarpRequest) (*WarpStatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return nil, fmt.Errorf("Xray not running")
+	}
+	if len(req.Domains) == 0 && len(req.IPs) == 0 {
+		return nil, fmt.Errorf("no domains or ips to route through WARP")
+	}
+
+	tag := req.OutboundTag
+	if tag == "" {
+		tag = DefaultWarpOutboundTag
+	}
+
+	if s.account == nil {
+		account, err := s.register(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.account = account
+	}
+
+	s.disable(ctx)
+
+	outboundJSON, err := s.account.buildOutbound(tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.xrayCore.AddOutbound(ctx, outboundJSON); err != nil {
+		return nil, fmt.Errorf("failed to add WARP outbound: %w", err)
+	}
+
+	rule := map[string]interface{}{
+		"ruleTag":     warpRuleTag,
+		"outboundTag": tag,
+	}
+	if len(req.Domains) > 0 {
+		rule["domain"] = req.Domains
+	}
+	if len(req.IPs) > 0 {
+		rule["ip"] = req.IPs
+	}
+	ruleJSON, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.xrayCore.AddRoutingRuleJSON(ctx, ruleJSON); err != nil {
+		_ = s.xrayCore.RemoveOutbound(ctx, tag)
+		return nil, fmt.Errorf("failed to add WARP routing rule: %w", err)
+	}
+
+	s.outboundTag = tag
+	s.logger.Info("WARP routing enabled",
+		zap.String("outbound", tag),
+		zap.Strings("domains", req.Domains),
+		zap.Strings("ips", req.IPs))
+
+	return s.status(), nil
+}
+
+// disable removes the WARP rule and outbound from the core (caller must hold the lock)
+func (s *WarpService) disable(ctx context.Context) {
+	if s.outboundTag == "" || s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		s.outboundTag = ""
+		return
+	}
+
+	if err := s.xrayCore.RemoveRoutingRule(ctx, warpRuleTag); err != nil {
+		s.logger.Warn("Failed to remove WARP routing rule", zap.Error(err))
+	}
+	if err := s.xrayCore.RemoveOutbound(ctx, s.outboundTag); err != nil {
+		s.logger.Warn("Failed to remove WARP outbound", zap.Error(err))
+	}
+	s.outboundTag = ""
+}
+
+// Disable stops routing traffic through WARP; the registration is kept
+func (s *WarpService) Disable(ctx context.Context) *WarpStatusResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.disable(ctx)
+	s.logger.Info("WARP routing disabled")
+	return s.status()
+}
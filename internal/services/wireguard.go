@@ -0,0 +1,133 @@
+// Package services provides business logic for WireGuard provisioning
+package services
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+// WireGuardService provisions keys and reports peers of WireGuard inbounds.
+// The embedded core does not expose its WireGuard device, so peers are
+// changed through the panel config (xray start), not at runtime.
+type WireGuardService struct {
+	logger   *zap.Logger
+	xrayCore *xraycore.Instance
+}
+
+// NewWireGuardService creates a new WireGuardService
+func NewWireGuardService(xrayCore *xraycore.Instance, logger *zap.Logger) *WireGuardService {
+	return &WireGuardService{
+		logger:   logger,
+		xrayCore: xrayCore,
+	}
+}
+
+// GenerateKeysResponse represents a new WireGuard key set (base64, as used by wg and Xray)
+type GenerateKeysResponse struct {
+	PrivateKey   string `json:"privateKey"`
+	PublicKey    string `json:"publicKey"`
+	PreSharedKey string `json:"preSharedKey"`
+}
+
+// GenerateKeys creates a Curve25519 key pair and a preshared key
+func (s *WireGuardService) GenerateKeys(ctx context.Context) (*GenerateKeysResponse, error) {
+	privateKey, publicKey, err := generateWireGuardKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	preSharedKey := make([]byte, 32)
+	if _, err := rand.Read(preSharedKey); err != nil {
+		return nil, fmt.Errorf("failed to generate preshared key: %w", err)
+	}
+
+	return &GenerateKeysResponse{
+		PrivateKey:   privateKey,
+		PublicKey:    publicKey,
+		PreSharedKey: base64.StdEncoding.EncodeToString(preSharedKey),
+	}, nil
+}
+
+// generateWireGuardKeyPair returns a base64 Curve25519 private/public key pair
+func generateWireGuardKeyPair() (string, string, error) {
+	privateKey := make([]byte, 32)
+	if _, err := rand.Read(privateKey); err != nil {
+		return "", "", fmt.Errorf("failed to generate private key: %w", err)
+	}
+	// Clamp like `wg genkey`
+	privateKey[0] &= 248
+	privateKey[31] = (privateKey[31] & 127) | 64
+
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to derive public key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(privateKey),
+		base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// WireGuardPeer represents a peer configured on a WireGuard inbound
+type WireGuardPeer struct {
+	Inbound    string   `json:"inbound"`
+	PublicKey  string   `json:"publicKey"`
+	AllowedIPs []string `json:"allowedIPs"`
+	KeepAlive  int      `json:"keepAlive,omitempty"`
+}
+
+// GetPeersResponse represents the peers loaded in the running core
+type GetPeersResponse struct {
+	Peers []*WireGuardPeer `json:"peers"`
+}
+
+// wireGuardInbound is the subset of a WireGuard inbound needed to list peers
+type wireGuardInbound struct {
+	Tag      string `json:"tag"`
+	Protocol string `json:"protocol"`
+	Settings struct {
+		Peers []struct {
+			PublicKey  string   `json:"publicKey"`
+			AllowedIPs []string `json:"allowedIPs"`
+			KeepAlive  int      `json:"keepAlive"`
+		} `json:"peers"`
+	} `json:"settings"`
+}
+
+// GetPeers lists peers of all WireGuard inbounds in the running config
+func (s *WireGuardService) GetPeers(ctx context.Context) (*GetPeersResponse, error) {
+	peers := make([]*WireGuardPeer, 0)
+	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
+		return &GetPeersResponse{Peers: peers}, nil
+	}
+
+	var config struct {
+		Inbounds []wireGuardInbound `json:"inbounds"`
+	}
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	for _, inbound := range config.Inbounds {
+		if inbound.Protocol != "wireguard" {
+			continue
+		}
+		for _, peer := range inbound.Settings.Peers {
+			peers = append(peers, &WireGuardPeer{
+				Inbound:    inbound.Tag,
+				PublicKey:  peer.PublicKey,
+				AllowedIPs: peer.AllowedIPs,
+				KeepAlive:  peer.KeepAlive,
+			})
+		}
+	}
+
+	return &GetPeersResponse{Peers: peers}, nil
+}
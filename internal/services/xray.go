@@ -6,17 +6,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/xtls/xray-core/common/protocol"
 	"go.uber.org/zap"
 
-	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+	"github.com/clash-version/remnawave-node-go/pkg/crypto"
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
+	"github.com/clash-version/remnawave-node-go/pkg/opbarrier"
+	"github.com/clash-version/remnawave-node-go/pkg/reqtiming"
 )
 
 // ErrXrayAlreadyProcessing indicates Xray is already being started/restarted
@@ -24,55 +31,147 @@ var ErrXrayAlreadyProcessing = errors.New("Xray is already being processed")
 
 // XrayService manages Xray-core process and configuration
 type XrayService struct {
-	mu           sync.RWMutex
+	// lifecycleMu serializes start/restart/stop/restore. Status reads never take
+	// it, so they don't block behind a start in progress.
+	lifecycleMu  sync.Mutex
 	logger       *zap.Logger
-	xrayCore     *xraycore.Instance
-	internal     *InternalService
+	xrayCore     CoreBackend
+	internal     UserStore
 	configDir    string
-	isConfigured bool
+	isConfigured atomic.Bool
 
 	// Concurrency protection
 	isStartProcessing atomic.Bool
 
-	// Online status tracking
-	isXrayOnline bool
+	// Core health (single source for start, healthcheck and metrics)
+	health *HealthManager
 
-	// Disable hash check (skip restart optimization)
-	disableHashedSetCheck bool
+	// Outbound tag used by Vision for blocking (checked by config lint)
+	blockTag string
+
+	// Stats, policy and API settings injected into generated config
+	api APISettings
+
+	// At-rest encryption of config.json
+	encryptConfig bool
+	configKey     []byte
+
+	// Node-local inbound listen overrides, by tag
+	inboundOverrides map[string]InboundOverride
+
+	// Environment variable prefix for ${VAR} config placeholders; empty disables templating
+	templatePrefix string
+
+	// Applied to client emails of the started config; nil leaves them as sent
+	usernames *UsernameNormalizer
+
+	// Optional check run before the core is started
+	startBlocked func() error
+
+	// Lifecycle hooks; nil runs none
+	hooks *HookService
+
+	flags *featureflags.Set
+
+	// Shared with HandlerService, see shutUserMutations
+	userBarrier *opbarrier.Barrier
+
+	// Background writes of config.json (see persistConfig)
+	persistMu      sync.Mutex
+	persistIdle    *sync.Cond // Signaled when persisting goes false
+	persisting     bool
+	pendingPersist *pendingConfig
+
+	// Recent core restarts (see RestartHistory)
+	restarts restartHistory
+
+	// Run when a panel config is applied (see OnPanelConfig)
+	onPanelConfig []func()
 }
 
 // XrayConfig holds Xray service configuration
 type XrayConfig struct {
-	ConfigDir             string
-	DisableHashedSetCheck bool // If true, skip hash-based restart optimization
+	ConfigDir        string
+	BlockTag         string // Vision block outbound tag, used by config lint
+	API              *APISettings
+	EncryptConfig    bool   // Encrypt config.json on disk
+	ConfigKey        []byte // AES-256 key for config.json, always used to decrypt existing files
+	InboundOverrides map[string]InboundOverride
+	TemplatePrefix   string       // Only ${VAR} placeholders with this prefix are expanded; empty disables
+	StartBlocked     func() error // Optional; a non-nil error refuses to start the core, e.g. traffic budget exhausted
+	Flags            *featureflags.Set
+	UserBarrier      *opbarrier.Barrier  // Optional; shut while the core is rebuilt so user mutations wait for it
+	Usernames        *UsernameNormalizer // Optional; normalizes client emails of started configs
+	Hooks            *HookService        // Optional; runs pre-start and post-start hooks
 }
 
 // NewXrayService creates a new XrayService
-func NewXrayService(cfg *XrayConfig, xrayCore *xraycore.Instance, internal *InternalService, logger *zap.Logger) *XrayService {
-	return &XrayService{
-		logger:                logger,
-		xrayCore:              xrayCore,
-		internal:              internal,
-		configDir:             cfg.ConfigDir,
-		isXrayOnline:          false,
-		disableHashedSetCheck: cfg.DisableHashedSetCheck,
+func NewXrayService(cfg *XrayConfig, xrayCore CoreBackend, internal UserStore, health *HealthManager, logger *zap.Logger) *XrayService {
+	api := DefaultAPISettings()
+	if cfg.API != nil {
+		api = *cfg.API
+	}
+	if api.Tag == "" {
+		api.Tag = reservedAPITag
+	}
+
+	s := &XrayService{
+		logger:           logger,
+		xrayCore:         xrayCore,
+		internal:         internal,
+		health:           health,
+		configDir:        cfg.ConfigDir,
+		blockTag:         cfg.BlockTag,
+		api:              api,
+		encryptConfig:    cfg.EncryptConfig,
+		configKey:        cfg.ConfigKey,
+		inboundOverrides: cfg.InboundOverrides,
+		templatePrefix:   cfg.TemplatePrefix,
+		startBlocked:     cfg.StartBlocked,
+		flags:            cfg.Flags,
+		userBarrier:      cfg.UserBarrier,
+		usernames:        cfg.Usernames,
+		hooks:            cfg.Hooks,
 	}
+	s.persistIdle = sync.NewCond(&s.persistMu)
+	return s
+}
+
+// OnPanelConfig registers fn to run when Start or Restart applies a config
+// from the panel, while user mutations are held back. It must be called
+// before the service handles requests.
+func (s *XrayService) OnPanelConfig(fn func()) {
+	s.onPanelConfig = append(s.onPanelConfig, fn)
 }
 
 // GetXrayCore returns the underlying Xray-core instance
-func (s *XrayService) GetXrayCore() *xraycore.Instance {
+func (s *XrayService) GetXrayCore() CoreBackend {
 	return s.xrayCore
 }
 
-// checkXrayHealth checks if Xray is responding
-func (s *XrayService) checkXrayHealth(ctx context.Context) bool {
-	if s.xrayCore == nil || !s.xrayCore.IsRunning() {
-		return false
+// checkStartBlocked returns why the core may not be started, if it may not
+func (s *XrayService) checkStartBlocked() error {
+	if s.startBlocked == nil {
+		return nil
+	}
+	if err := s.startBlocked(); err != nil {
+		s.logger.Warn("Refusing to start Xray", zap.Error(err))
+		return err
 	}
+	return nil
+}
 
-	// Try to get system stats to verify it's working
-	_, err := s.xrayCore.GetSystemStats(ctx)
-	return err == nil
+// shutUserMutations waits for in-flight user mutations and holds new ones
+// back until the returned function is called, so none is applied to a core
+// being replaced, or recorded in the user tracking being rebuilt, and lost
+func (s *XrayService) shutUserMutations(ctx context.Context) func() {
+	defer reqtiming.Track(ctx, reqtiming.PhaseLockWait)()
+	return s.userBarrier.Shut()
+}
+
+// checkXrayHealth checks if Xray is responding, updating the health state
+func (s *XrayService) checkXrayHealth(ctx context.Context) bool {
+	return s.health.Check(ctx)
 }
 
 // XrayConfigData represents the Xray configuration file structure
@@ -86,38 +185,110 @@ type XrayConfigData struct {
 	Policy    interface{}   `json:"policy,omitempty"`
 }
 
-// Default policy configuration (matches Node.js XRAY_DEFAULT_POLICY_MODEL)
-var defaultPolicyConfig = map[string]interface{}{
-	"levels": map[string]interface{}{
-		"0": map[string]interface{}{
-			"statsUserUplink":   true,
-			"statsUserDownlink": true,
-			"statsUserOnline":   true,
+// APISettings controls the stats, policy and API sections injected into the Xray config
+type APISettings struct {
+	Listen           string // Xray gRPC API listen address (e.g. "127.0.0.1:61000"), empty disables it
+	Tag              string // Tag of the Xray API section
+	PolicyLevels     []int  // Policy levels that get user stats enabled
+	StatsUserTraffic bool
+	StatsUserOnline  bool
+	StatsInbound     bool
+	StatsOutbound    bool
+	MergeMode        bool // Preserve panel-provided stats/policy sections, only inject missing keys
+}
+
+// DefaultAPISettings returns settings matching Node.js XRAY_DEFAULT_POLICY_MODEL
+func DefaultAPISettings() APISettings {
+	return APISettings{
+		Tag:              reservedAPITag,
+		PolicyLevels:     []int{0},
+		StatsUserTraffic: true,
+		StatsUserOnline:  true,
+		StatsInbound:     true,
+		StatsOutbound:    true,
+	}
+}
+
+// buildPolicyConfig builds the policy section from API settings
+func (a *APISettings) buildPolicyConfig() map[string]interface{} {
+	levels := make(map[string]interface{}, len(a.PolicyLevels))
+	for _, level := range a.PolicyLevels {
+		levels[strconv.Itoa(level)] = map[string]interface{}{
+			"statsUserUplink":   a.StatsUserTraffic,
+			"statsUserDownlink": a.StatsUserTraffic,
+			"statsUserOnline":   a.StatsUserOnline,
+		}
+	}
+
+	return map[string]interface{}{
+		"levels": levels,
+		"system": map[string]interface{}{
+			"statsInboundDownlink":  a.StatsInbound,
+			"statsInboundUplink":    a.StatsInbound,
+			"statsOutboundDownlink": a.StatsOutbound,
+			"statsOutboundUplink":   a.StatsOutbound,
 		},
-	},
-	"system": map[string]interface{}{
-		"statsInboundDownlink":  true,
-		"statsInboundUplink":    true,
-		"statsOutboundDownlink": true,
-		"statsOutboundUplink":   true,
-	},
-}
-
-// generateApiConfig adds Stats and Policy configurations to the Xray config
-// Note: We don't need API/gRPC config since we're using embedded Xray-core
-func generateApiConfig(config map[string]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-
-	// Copy all existing config
-	for k, v := range config {
-		result[k] = v
 	}
+}
 
-	// Add stats configuration (empty object)
-	result["stats"] = map[string]interface{}{}
+// generateApiConfig adds Stats, Policy and (optionally) API configurations to the Xray config,
+// rewriting the document in place. The embedded core does not need the gRPC API; it is only
+// injected when a listen address is configured, e.g. for external tooling. Returns warnings
+// about collisions.
+func (s *XrayService) generateApiConfig(doc *configDocument) []string {
+	// Port-hopping inbounds listen on a whole range as one inbound
+	warnings := normalizeInboundPorts(doc)
+
+	// Node-local listen address/port overrides
+	if len(s.inboundOverrides) > 0 {
+		warnings = append(warnings, applyInboundOverrides(doc, s.inboundOverrides)...)
+	}
+
+	// Client emails in the form user mutations and stats lookups use
+	warnings = append(warnings, normalizeClientEmails(doc, s.usernames)...)
+
+	// Vision blocks route to the block outbound, which the panel may not send
+	if s.flags.Enabled(FlagBlockOutbound) {
+		added, warning := ensureBlockOutbound(doc, s.blockTag)
+		if added {
+			s.logger.Info("Added blackhole outbound for IP blocking", zap.String("tag", s.blockTag))
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	if s.api.MergeMode {
+		// Keep panel-provided sections, only fill in what is missing
+		if !doc.has("stats") {
+			doc.setSection("stats", map[string]interface{}{})
+		}
+		var userPolicy map[string]interface{}
+		doc.decodeSection("policy", &userPolicy)
+		doc.setSection("policy", mergeMissing(userPolicy, s.api.buildPolicyConfig()))
+	} else {
+		// Add stats configuration (empty object)
+		doc.setSection("stats", map[string]interface{}{})
 
-	// Build and add policy configuration (required for user stats)
-	result["policy"] = defaultPolicyConfig
+		// Build and add policy configuration (required for user stats)
+		doc.setSection("policy", s.api.buildPolicyConfig())
+	}
+
+	// Add API configuration unless the panel config already brings its own
+	if s.api.Listen != "" {
+		if doc.has("api") {
+			warnings = append(warnings, "config already contains an api section, embedded API settings were not applied")
+		} else if port := apiListenPort(s.api.Listen); port != "" && inboundUsesPort(doc, port) {
+			warnings = append(warnings, fmt.Sprintf("an inbound already listens on API port %s, embedded API was not enabled", port))
+		} else {
+			// ReflectionService lets standard tooling (grpcurl) discover the API
+			doc.setSection("api", map[string]interface{}{
+				"tag":      s.api.Tag,
+				"listen":   s.api.Listen,
+				"services": []string{"HandlerService", "StatsService", "RoutingService", "LoggerService", "ReflectionService"},
+			})
+		}
+	}
 
 	// Only enable debug logging if NODE_ENV is development
 	logLevel := "warning"
@@ -125,26 +296,115 @@ func generateApiConfig(config map[string]interface{}) map[string]interface{} {
 		logLevel = "debug"
 	}
 
-	result["log"] = map[string]interface{}{
+	doc.setSection("log", map[string]interface{}{
 		"loglevel": logLevel,
 		"access":   "",
 		"error":    "",
+	})
+
+	return warnings
+}
+
+// ensureBlockOutbound appends a blackhole outbound with the block tag to a
+// config that has no outbound with that tag, and reports whether it did. It
+// is appended, never prepended, as the first outbound is the default route;
+// a config without outbounds is left alone for the same reason.
+func ensureBlockOutbound(doc *configDocument, blockTag string) (bool, string) {
+	if blockTag == "" {
+		return false, ""
+	}
+
+	var outbounds []json.RawMessage
+	doc.decodeSection("outbounds", &outbounds)
+	if len(outbounds) == 0 {
+		return false, fmt.Sprintf("config has no outbounds, blackhole outbound %q for IP blocking was not added", blockTag)
+	}
+	for _, raw := range outbounds {
+		var outbound struct {
+			Tag string `json:"tag"`
+		}
+		if json.Unmarshal(raw, &outbound) == nil && outbound.Tag == blockTag {
+			return false, ""
+		}
+	}
+
+	block, _ := json.Marshal(map[string]interface{}{ // Node-built values always marshal
+		"tag":      blockTag,
+		"protocol": "blackhole",
+	})
+	doc.setSection("outbounds", append(outbounds, block))
+	return true, ""
+}
+
+// mergeMissing returns a deep copy of dst with keys from src added where dst lacks them.
+// Existing values in dst always win; nested objects are merged recursively.
+func mergeMissing(dst, src map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		result[k] = v
+	}
+
+	for k, srcValue := range src {
+		dstValue, exists := result[k]
+		if !exists {
+			result[k] = srcValue
+			continue
+		}
+		dstMap, dstIsMap := dstValue.(map[string]interface{})
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		if dstIsMap && srcIsMap {
+			result[k] = mergeMissing(dstMap, srcMap)
+		}
 	}
 
 	return result
 }
 
+// apiListenPort returns the port part of an API listen address
+func apiListenPort(listen string) string {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// inboundUsesPort reports whether any inbound in the config listens on the given port,
+// including port ranges
+func inboundUsesPort(doc *configDocument, port string) bool {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+
+	for i := range doc.inbounds {
+		var inboundPort interface{}
+		if !doc.inboundField(i, "port", &inboundPort) {
+			continue
+		}
+		ranges, err := parsePortSpec(inboundPort)
+		if err == nil && portRangesContain(ranges, n) {
+			return true
+		}
+	}
+	return false
+}
+
 // StartRequestInternals represents the internals part of start request (Node.js format)
 type StartRequestInternals struct {
 	ForceRestart bool           `json:"forceRestart"`
 	Hashes       *InboundHashes `json:"hashes"`
+	// SkipHashCheck restarts the core for this request as if the hash check
+	// were disabled, without changing the node's setting
+	SkipHashCheck bool `json:"skipHashCheck,omitempty"`
 }
 
 // StartRequest represents a request to start Xray (Node.js compatible format)
 // Format: { internals: { forceRestart, hashes }, xrayConfig: {...} }
+// The config is kept as raw JSON, it can be very large (see configDocument).
 type StartRequest struct {
-	Internals  StartRequestInternals  `json:"internals"`
-	XrayConfig map[string]interface{} `json:"xrayConfig"`
+	Internals  StartRequestInternals `json:"internals"`
+	XrayConfig json.RawMessage       `json:"xrayConfig"`
 }
 
 // SystemInformation represents system info in response
@@ -152,11 +412,20 @@ type SystemInformation struct {
 	CPUCores    int    `json:"cpuCores"`
 	CPUModel    string `json:"cpuModel"`
 	MemoryTotal string `json:"memoryTotal"`
+
+	// Container limits, when they are lower than the host resources
+	InContainer bool    `json:"inContainer,omitempty"`
+	CPULimit    float64 `json:"cpuLimit,omitempty"`    // CPUs allowed by the cgroup quota
+	MemoryLimit int64   `json:"memoryLimit,omitempty"` // Bytes allowed by the cgroup
 }
 
 // NodeInformation represents node version info
 type NodeInformation struct {
-	Version string `json:"version"`
+	Version  string            `json:"version"`
+	Name     string            `json:"name,omitempty"`
+	Region   string            `json:"region,omitempty"`
+	Provider string            `json:"provider,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
 }
 
 // StartResponseData represents the response data for start request (Node.js format)
@@ -166,6 +435,7 @@ type StartResponseData struct {
 	Error             *string            `json:"error"`
 	SystemInformation *SystemInformation `json:"systemInformation"`
 	NodeInformation   NodeInformation    `json:"nodeInformation"`
+	Warnings          []string           `json:"warnings,omitempty"`
 }
 
 // StartResponse represents a response to start request (Node.js compatible format)
@@ -179,6 +449,22 @@ type NodeHealthCheckResponseData struct {
 	XrayInternalStatusCached bool    `json:"xrayInternalStatusCached"`
 	XrayVersion              *string `json:"xrayVersion"`
 	NodeVersion              string  `json:"nodeVersion"`
+
+	// Per-inbound result of the last background probe (omitted when the prober is disabled)
+	InboundsHealth map[string]bool `json:"inboundsHealth,omitempty"`
+
+	// Core health state with reason and timestamps
+	XrayHealth HealthState `json:"xrayHealth"`
+
+	// Node version and identity
+	NodeInformation NodeInformation `json:"nodeInformation"`
+
+	// Node clock when the response was built, for spotting skew from the panel
+	NodeTime time.Time `json:"nodeTime"`
+
+	// Headroom left under the capacity limits, 0-100 (omitted when capacity tracking is disabled)
+	CapacityScore *int          `json:"capacityScore,omitempty"`
+	Capacity      *CapacityLoad `json:"capacity,omitempty"`
 }
 
 // NodeHealthCheckResponse represents a response to health check request
@@ -194,9 +480,52 @@ func SetNodeVersion(version string) {
 	nodeVersion = version
 }
 
+// NodeVersion returns the node version
+func NodeVersion() string {
+	return nodeVersion
+}
+
+// NodeIdentity is the configured node metadata
+type NodeIdentity struct {
+	Name     string
+	Region   string
+	Provider string
+	Labels   map[string]string
+}
+
+// nodeIdentity is the configured node metadata
+var nodeIdentity NodeIdentity
+
+// SetNodeIdentity sets the node metadata (called during initialization)
+func SetNodeIdentity(identity NodeIdentity) {
+	nodeIdentity = identity
+}
+
+// CurrentNodeIdentity returns the node metadata
+func CurrentNodeIdentity() NodeIdentity {
+	return nodeIdentity
+}
+
+// currentNodeInformation returns the node version and identity for API responses
+func currentNodeInformation() NodeInformation {
+	return NodeInformation{
+		Version:  nodeVersion,
+		Name:     nodeIdentity.Name,
+		Region:   nodeIdentity.Region,
+		Provider: nodeIdentity.Provider,
+		Labels:   nodeIdentity.Labels,
+	}
+}
+
 // Start starts the Xray process with the given configuration
 func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartResponse, error) {
 	startTime := time.Now()
+	var warnings []string
+
+	// Written in the background, so a failure is only known to later requests
+	if persistErr := s.health.State().ConfigPersistError; persistErr != "" {
+		warnings = append(warnings, "the last started config was not saved to disk: "+persistErr)
+	}
 
 	// Helper to create error response
 	errorResponse := func(errMsg string) *StartResponse {
@@ -206,7 +535,8 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 				Version:           nil,
 				Error:             &errMsg,
 				SystemInformation: nil,
-				NodeInformation:   NodeInformation{Version: nodeVersion},
+				NodeInformation:   currentNodeInformation(),
+				Warnings:          warnings,
 			},
 		}
 	}
@@ -219,11 +549,39 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 				Version:           &version,
 				Error:             nil,
 				SystemInformation: s.getSystemInformation(),
-				NodeInformation:   NodeInformation{Version: nodeVersion},
+				NodeInformation:   currentNodeInformation(),
+				Warnings:          warnings,
 			},
 		}
 	}
 
+	// Expand ${VAR} placeholders from the node environment
+	configData := []byte(req.XrayConfig)
+	if s.templatePrefix != "" {
+		var templateWarnings []string
+		configData, templateWarnings = expandConfigTemplate(configData, s.templatePrefix)
+		for _, w := range templateWarnings {
+			s.logger.Warn("Xray config template", zap.String("warning", w))
+		}
+		warnings = append(warnings, templateWarnings...)
+	}
+
+	doc, err := parseConfigDocument(configData)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("invalid xray config: %v", err)), nil
+	}
+
+	// Lint incoming config so misconfigurations are visible to the panel
+	lintBlockTag := s.blockTag
+	if s.flags.Enabled(FlagBlockOutbound) {
+		lintBlockTag = "" // Added by generateApiConfig when missing
+	}
+	lintWarnings := lintXrayConfig(doc, lintBlockTag, s.api.Tag)
+	for _, w := range lintWarnings {
+		s.logger.Warn("Xray config lint", zap.String("warning", w))
+	}
+	warnings = append(warnings, lintWarnings...)
+
 	// Check for concurrent processing
 	if !s.isStartProcessing.CompareAndSwap(false, true) {
 		errMsg := "Request already in progress"
@@ -231,11 +589,15 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 	}
 	defer s.isStartProcessing.Store(false)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	doneWaiting := reqtiming.Track(ctx, reqtiming.PhaseLockWait)
+	s.lifecycleMu.Lock()
+	doneWaiting()
+	defer s.lifecycleMu.Unlock()
 
 	// If Xray is online, hashed set check is enabled, and not force restart, check if restart is needed
-	if s.isXrayOnline && !s.disableHashedSetCheck && !req.Internals.ForceRestart && req.Internals.Hashes != nil && s.internal != nil {
+	healthFailed := false
+	skipHashCheck := s.HashCheckDisabled() || req.Internals.SkipHashCheck
+	if s.health.IsOnline() && !skipHashCheck && !req.Internals.ForceRestart && req.Internals.Hashes != nil && s.internal != nil {
 		// First verify Xray is actually healthy
 		if s.checkXrayHealth(ctx) {
 			// Check if config changed
@@ -243,20 +605,12 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 			if !needRestart {
 				s.logger.Info("No changes detected, skipping restart",
 					zap.Duration("checkTime", time.Since(startTime)))
-				version := s.GetVersion()
-				return &StartResponse{
-					Response: StartResponseData{
-						IsStarted:         true,
-						Version:           &version,
-						Error:             nil,
-						SystemInformation: s.getSystemInformation(),
-						NodeInformation:   NodeInformation{Version: nodeVersion},
-					},
-				}, nil
+				return successResponse(s.GetVersion()), nil
 			}
 		} else {
 			// Health check failed, need to restart
-			s.isXrayOnline = false
+			healthFailed = true
+			s.health.MarkDown("health check failed")
 			s.logger.Warn("Xray Core health check failed, restarting...")
 		}
 	}
@@ -265,46 +619,42 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 	if req.Internals.ForceRestart {
 		s.logger.Warn("Force restart requested")
 	}
+	if req.Internals.SkipHashCheck {
+		s.logger.Warn("Hash check skipped by request")
+	}
 
-	// Check if restart is needed (hash comparison) - for first start
-	if !req.Internals.ForceRestart && !s.isXrayOnline && req.Internals.Hashes != nil && s.internal != nil {
+	// Check if restart is needed (hash comparison) - for first start. A core
+	// that just failed its health check or isn't running is always restarted.
+	if !req.Internals.ForceRestart && !skipHashCheck && !healthFailed && !s.health.IsOnline() && s.xrayCore.IsRunning() && req.Internals.Hashes != nil && s.internal != nil {
 		needRestart := s.internal.IsNeedRestartCore(req.Internals.Hashes)
 		if !needRestart {
 			s.logger.Info("No changes detected, skipping restart",
 				zap.Duration("checkTime", time.Since(startTime)))
-			version := s.GetVersion()
-			return &StartResponse{
-				Response: StartResponseData{
-					IsStarted:         true,
-					Version:           &version,
-					Error:             nil,
-					SystemInformation: s.getSystemInformation(),
-					NodeInformation:   NodeInformation{Version: nodeVersion},
-				},
-			}, nil
+			return successResponse(s.GetVersion()), nil
 		}
 	}
 
-	// Generate full config with Stats and Policy
-	fullConfig := generateApiConfig(req.XrayConfig)
+	// Classified before the new config replaces the stored hashes
+	trigger, triggerDetail := s.startTrigger(req, healthFailed)
 
-	// Convert fullConfig to JSON bytes
-	configBytes, err := json.Marshal(fullConfig)
-	if err != nil {
-		return errorResponse(fmt.Sprintf("failed to marshal config: %v", err)), nil
+	// Generate full config with Stats and Policy
+	apiWarnings := s.generateApiConfig(doc)
+	for _, w := range apiWarnings {
+		s.logger.Warn("Xray API config", zap.String("warning", w))
 	}
+	warnings = append(warnings, apiWarnings...)
 
-	// Write config to file for reference
-	configPath := filepath.Join(s.configDir, "config.json")
-	if err := os.MkdirAll(s.configDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create config directory: %w", err)
-	}
+	// The one full copy of the config: persisted, tracked and started from
+	configBytes := doc.marshal()
 
-	if err := os.WriteFile(configPath, configBytes, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write config file: %w", err)
+	reopen := s.shutUserMutations(ctx)
+	defer reopen()
+	for _, fn := range s.onPanelConfig {
+		fn()
 	}
 
-	s.logger.Info("Written Xray config", zap.String("path", configPath))
+	// Written in the background; the core is started from the bytes in hand
+	s.persistConfig(configBytes, req.Internals.Hashes)
 
 	// Extract users from config for tracking (pass hashes to store them)
 	if s.internal != nil {
@@ -313,9 +663,20 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 		}
 	}
 
+	// The config is kept, so a blocked core starts with it once unblocked
+	if err := s.checkStartBlocked(); err != nil {
+		return errorResponse(err.Error()), nil
+	}
+
+	// Hook failures are reported but never keep the core from starting
+	hookWarnings := s.hooks.Run(ctx, &HookEvent{Event: HookPreStart, Tags: doc.inboundTags(), Trigger: trigger})
+	warnings = append(warnings, hookWarnings...)
+
 	// Start the embedded Xray-core
+	coreStart := time.Now()
 	if err := s.xrayCore.Start(ctx, configBytes); err != nil {
-		s.isXrayOnline = false
+		s.health.MarkDown("start failed: " + err.Error())
+		s.recordRestart(trigger, triggerDetail, coreStart, err.Error())
 		s.logger.Error("Failed to start Xray",
 			zap.Error(err),
 			zap.Duration("elapsed", time.Since(startTime)))
@@ -325,17 +686,21 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 	// Verify Xray is actually responding
 	isStarted := s.checkXrayHealth(ctx)
 	if !isStarted {
-		s.isXrayOnline = false
+		s.health.MarkDown("started but health check failed")
+		s.recordRestart(trigger, triggerDetail, coreStart, "health check failed")
 		s.logger.Error("Xray failed to start - health check failed",
 			zap.Duration("elapsed", time.Since(startTime)))
 		return errorResponse("Xray started but health check failed"), nil
 	}
 
+	s.recordRestart(trigger, triggerDetail, coreStart, "")
+
 	// Get version after start
 	version := s.GetVersion()
 
-	s.isConfigured = true
-	s.isXrayOnline = true
+	s.isConfigured.Store(true)
+	s.health.MarkOnline()
+	s.hooks.Fire(&HookEvent{Event: HookPostStart, Tags: doc.inboundTags(), Trigger: trigger, Version: version})
 	s.logger.Info("Xray started successfully",
 		zap.String("version", version),
 		zap.Duration("elapsed", time.Since(startTime)))
@@ -345,10 +710,14 @@ func (s *XrayService) Start(ctx context.Context, req *StartRequest) (*StartRespo
 
 // getSystemInformation returns system information for the response
 func (s *XrayService) getSystemInformation() *SystemInformation {
+	limits := DetectContainerLimits()
 	return &SystemInformation{
 		CPUCores:    getCPUCores(),
 		CPUModel:    getCPUModel(),
 		MemoryTotal: getMemoryTotal(),
+		InContainer: limits.InContainer,
+		CPULimit:    limits.CPUQuota,
+		MemoryLimit: limits.MemoryLimit,
 	}
 }
 
@@ -359,16 +728,24 @@ type StopResponse struct {
 
 // Stop stops the Xray process
 func (s *XrayService) Stop(ctx context.Context) (*StopResponse, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	doneWaiting := reqtiming.Track(ctx, reqtiming.PhaseLockWait)
+	s.lifecycleMu.Lock()
+	doneWaiting()
+	defer s.lifecycleMu.Unlock()
+
+	reopen := s.shutUserMutations(ctx)
+	defer reopen()
 
-	if err := s.xrayCore.Stop(); err != nil {
+	doneStopping := reqtiming.Track(ctx, reqtiming.PhaseCore)
+	err := s.xrayCore.Stop()
+	doneStopping()
+	if err != nil {
 		s.logger.Error("Failed to stop Xray", zap.Error(err))
 		return &StopResponse{IsStopped: false}, nil
 	}
 
-	s.isConfigured = false
-	s.isXrayOnline = false
+	s.isConfigured.Store(false)
+	s.health.MarkDown("stopped")
 
 	// Cleanup internal state
 	if s.internal != nil {
@@ -383,14 +760,19 @@ type RestartRequest struct {
 	Config       json.RawMessage `json:"config,omitempty"`
 	Hashes       *InboundHashes  `json:"hashes,omitempty"`
 	ForceRestart bool            `json:"forceRestart,omitempty"`
+
+	// Config is the node's own config.json rather than one from the panel,
+	// so the mutation journal is kept for replay
+	Local bool `json:"-"`
 }
 
 // RestartResponse represents a response to restart request
 type RestartResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
-	Version string `json:"version,omitempty"`
-	Skipped bool   `json:"skipped,omitempty"`
+	Success  bool     `json:"success"`
+	Message  string   `json:"message,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	Skipped  bool     `json:"skipped,omitempty"`
+	Warnings []string `json:"warnings,omitempty"` // Failed pre-start hooks
 }
 
 // Restart restarts the Xray process, optionally with new config
@@ -407,11 +789,11 @@ func (s *XrayService) Restart(ctx context.Context, req *RestartRequest) (*Restar
 	}
 	defer s.isStartProcessing.Store(false)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
 
 	// If Xray is online and not force restart, check if restart is needed
-	if s.isXrayOnline && !req.ForceRestart && req.Hashes != nil && s.internal != nil {
+	if s.health.IsOnline() && !req.ForceRestart && req.Hashes != nil && s.internal != nil {
 		if s.checkXrayHealth(ctx) {
 			needRestart := s.internal.IsNeedRestartCore(req.Hashes)
 			if !needRestart {
@@ -425,7 +807,7 @@ func (s *XrayService) Restart(ctx context.Context, req *RestartRequest) (*Restar
 				}, nil
 			}
 		} else {
-			s.isXrayOnline = false
+			s.health.MarkDown("health check failed")
 			s.logger.Warn("Xray Core health check failed, restarting...")
 		}
 	}
@@ -434,14 +816,19 @@ func (s *XrayService) Restart(ctx context.Context, req *RestartRequest) (*Restar
 		s.logger.Warn("Force restart requested")
 	}
 
+	reopen := s.shutUserMutations(ctx)
+	defer reopen()
+
 	// If new config provided, write it and use it
 	configBytes := req.Config
+	var carried map[string][]*protocol.MemoryUser
 	if len(configBytes) > 0 {
-		configPath := filepath.Join(s.configDir, "config.json")
-		if err := os.WriteFile(configPath, configBytes, 0644); err != nil {
-			return nil, fmt.Errorf("failed to write config file: %w", err)
+		if !req.Local {
+			for _, fn := range s.onPanelConfig {
+				fn()
+			}
 		}
-		s.logger.Info("Updated Xray config", zap.String("path", configPath))
+		s.persistConfig(configBytes, req.Hashes)
 
 		// Extract users from config for tracking (pass hashes to store them)
 		if s.internal != nil {
@@ -450,13 +837,31 @@ func (s *XrayService) Restart(ctx context.Context, req *RestartRequest) (*Restar
 			}
 		}
 	} else {
-		// Use existing config
+		// Use existing config, keeping the users changed since it was started
 		configBytes = s.xrayCore.GetConfig()
+		carried = s.runtimeUsers(ctx)
+	}
+
+	if err := s.checkStartBlocked(); err != nil {
+		return &RestartResponse{
+			Success: false,
+			Message: err.Error(),
+			Version: s.GetVersion(),
+		}, nil
 	}
 
+	// Hook failures are reported but never keep the core from restarting
+	var tags []string
+	if doc, err := parseConfigDocument(configBytes); err == nil {
+		tags = doc.inboundTags()
+	}
+	warnings := s.hooks.Run(ctx, &HookEvent{Event: HookPreStart, Tags: tags, Trigger: RestartTriggerRestart})
+
 	// Restart the embedded Xray-core
+	coreStart := time.Now()
 	if err := s.xrayCore.Restart(ctx, configBytes); err != nil {
-		s.isXrayOnline = false
+		s.health.MarkDown("restart failed: " + err.Error())
+		s.recordRestart(RestartTriggerRestart, "", coreStart, err.Error())
 		return &RestartResponse{
 			Success: false,
 			Message: err.Error(),
@@ -467,7 +872,8 @@ func (s *XrayService) Restart(ctx context.Context, req *RestartRequest) (*Restar
 	// Verify health
 	isStarted := s.checkXrayHealth(ctx)
 	if !isStarted {
-		s.isXrayOnline = false
+		s.health.MarkDown("restarted but health check failed")
+		s.recordRestart(RestartTriggerRestart, "", coreStart, "health check failed")
 		s.logger.Error("Xray restart failed - health check failed")
 		return &RestartResponse{
 			Success: false,
@@ -476,21 +882,114 @@ func (s *XrayService) Restart(ctx context.Context, req *RestartRequest) (*Restar
 		}, nil
 	}
 
+	s.recordRestart(RestartTriggerRestart, "", coreStart, "")
 	version := s.GetVersion()
 
-	s.isConfigured = true
-	s.isXrayOnline = true
+	s.restoreUsers(ctx, carried)
+
+	s.isConfigured.Store(true)
+	s.health.MarkOnline()
+	s.hooks.Fire(&HookEvent{Event: HookPostStart, Tags: tags, Trigger: RestartTriggerRestart, Version: version})
 	s.logger.Info("Xray restarted successfully",
 		zap.String("version", version),
 		zap.Duration("elapsed", time.Since(startTime)))
 
 	return &RestartResponse{
-		Success: true,
-		Message: "Xray restarted successfully",
-		Version: version,
+		Success:  true,
+		Message:  "Xray restarted successfully",
+		Version:  version,
+		Warnings: warnings,
 	}, nil
 }
 
+// runtimeUsers returns the users of every tracked inbound of the running core,
+// which include those added and removed since the core was started
+func (s *XrayService) runtimeUsers(ctx context.Context) map[string][]*protocol.MemoryUser {
+	if s.internal == nil || !s.xrayCore.IsRunning() {
+		return nil
+	}
+
+	users := make(map[string][]*protocol.MemoryUser)
+	for _, tag := range s.internal.GetXtlsConfigInbounds() {
+		inboundUsers, err := s.xrayCore.GetInboundUsers(ctx, tag)
+		if err != nil {
+			s.logger.Warn("Failed to read inbound users before restart", zap.String("tag", tag), zap.Error(err))
+			continue
+		}
+		users[tag] = inboundUsers
+	}
+	return users
+}
+
+// restoreUsers makes the users of each inbound those captured by runtimeUsers
+// before a restart, which rebuilt the inbounds from the config
+func (s *XrayService) restoreUsers(ctx context.Context, users map[string][]*protocol.MemoryUser) {
+	added, removed := 0, 0
+	for tag, wanted := range users {
+		current, err := s.xrayCore.GetInboundUsers(ctx, tag)
+		if err != nil {
+			s.logger.Warn("Failed to restore inbound users after restart", zap.String("tag", tag), zap.Error(err))
+			continue
+		}
+
+		existing := make(map[string]struct{}, len(current))
+		for _, user := range current {
+			existing[user.Email] = struct{}{}
+		}
+		keep := make(map[string]struct{}, len(wanted))
+		for _, user := range wanted {
+			keep[user.Email] = struct{}{}
+			if _, exists := existing[user.Email]; exists {
+				continue
+			}
+			if err := s.xrayCore.AddUser(ctx, tag, user); err != nil {
+				s.logger.Warn("Failed to restore user after restart", zap.String("user", user.Email), zap.String("tag", tag), zap.Error(err))
+				continue
+			}
+			added++
+		}
+		for _, user := range current {
+			if _, exists := keep[user.Email]; exists {
+				continue
+			}
+			if err := s.xrayCore.RemoveUser(ctx, tag, user.Email); err != nil {
+				s.logger.Warn("Failed to remove user after restart", zap.String("user", user.Email), zap.String("tag", tag), zap.Error(err))
+				continue
+			}
+			removed++
+		}
+	}
+
+	if added > 0 || removed > 0 {
+		s.logger.Info("Restored users changed since the last start", zap.Int("added", added), zap.Int("removed", removed))
+	}
+}
+
+// ExplainRestart returns the decision Start would make for the given hashes
+// without a forced restart, and why, for debugging unnecessary restarts.
+// Nothing is started, and the core's health is not checked.
+func (s *XrayService) ExplainRestart(hashes *InboundHashes) *RestartDecision {
+	if !s.xrayCore.IsRunning() {
+		return &RestartDecision{NeedRestart: true, Reasons: []RestartReason{{Kind: RestartReasonCoreNotRunning}}}
+	}
+	if s.HashCheckDisabled() {
+		return &RestartDecision{NeedRestart: true, Reasons: []RestartReason{{Kind: RestartReasonHashCheckDisabled}}}
+	}
+	if s.internal == nil {
+		return &RestartDecision{NeedRestart: true, Reasons: []RestartReason{{Kind: RestartReasonNoStoredHash}}}
+	}
+
+	decision := s.internal.ExplainRestart(hashes)
+	decision.HashWarnings = s.internal.VerifyInboundHashes(hashes)
+	return decision
+}
+
+// HashCheckDisabled reports whether the hash-based restart optimization is
+// off (FlagHashCheck, which can be toggled at runtime)
+func (s *XrayService) HashCheckDisabled() bool {
+	return !hashCheckEnabled(s.flags)
+}
+
 // GetStatusResponse represents the status of Xray (Node.js compatible)
 type GetStatusResponse struct {
 	IsRunning bool    `json:"isRunning"`
@@ -515,10 +1014,110 @@ func (s *XrayService) GetStatus(ctx context.Context) (*GetStatusResponse, error)
 	}, nil
 }
 
+// InboundInfo describes an inbound loaded in the running core
+type InboundInfo struct {
+	Tag       string      `json:"tag"`
+	Protocol  string      `json:"protocol"`
+	Listen    string      `json:"listen,omitempty"`
+	Port      interface{} `json:"port,omitempty"`      // number or range string, as configured
+	Ports     []PortRange `json:"ports,omitempty"`     // Parsed listen ranges
+	PortCount int         `json:"portCount,omitempty"` // Ports covered, >1 for port hopping
+	Network   string      `json:"network,omitempty"`
+	Security  string      `json:"security,omitempty"`
+	UserCount int         `json:"userCount"`
+}
+
+// OutboundInfo describes an outbound loaded in the running core
+type OutboundInfo struct {
+	Tag         string `json:"tag"`
+	Protocol    string `json:"protocol"`
+	SendThrough string `json:"sendThrough,omitempty"`
+}
+
+// GetInboundsResponse represents the inbounds of the running core
+type GetInboundsResponse struct {
+	Inbounds []*InboundInfo `json:"inbounds"`
+}
+
+// GetOutboundsResponse represents the outbounds of the running core
+type GetOutboundsResponse struct {
+	Outbounds []*OutboundInfo `json:"outbounds"`
+}
+
+// runningConfigSection returns one top-level section of the config the core
+// was started with (not the file on disk, which may be newer)
+func (s *XrayService) runningConfigSection(section string) ([]map[string]interface{}, error) {
+	if !s.xrayCore.IsRunning() {
+		return nil, nil
+	}
+
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(s.xrayCore.GetConfig(), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse running config: %w", err)
+	}
+
+	var items []map[string]interface{}
+	if raw, exists := config[section]; exists {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", section, err)
+		}
+	}
+	return items, nil
+}
+
+// GetInbounds lists the inbounds the running core loaded, with tracked user counts
+func (s *XrayService) GetInbounds(ctx context.Context) (*GetInboundsResponse, error) {
+	items, err := s.runningConfigSection("inbounds")
+	if err != nil {
+		return nil, err
+	}
+
+	inbounds := make([]*InboundInfo, 0, len(items))
+	for _, item := range items {
+		info := &InboundInfo{Port: item["port"]}
+		if ranges, err := parsePortSpec(item["port"]); err == nil && len(ranges) > 0 {
+			info.Ports = ranges
+			info.PortCount = portRangesCount(ranges)
+		}
+		info.Tag, _ = item["tag"].(string)
+		info.Protocol, _ = item["protocol"].(string)
+		info.Listen, _ = item["listen"].(string)
+		if stream, ok := item["streamSettings"].(map[string]interface{}); ok {
+			info.Network, _ = stream["network"].(string)
+			info.Security, _ = stream["security"].(string)
+		}
+		if s.internal != nil && info.Tag != "" {
+			info.UserCount = s.internal.GetUsersCountInInbound(info.Tag)
+		}
+		inbounds = append(inbounds, info)
+	}
+
+	return &GetInboundsResponse{Inbounds: inbounds}, nil
+}
+
+// GetOutbounds lists the outbounds the running core loaded
+func (s *XrayService) GetOutbounds(ctx context.Context) (*GetOutboundsResponse, error) {
+	items, err := s.runningConfigSection("outbounds")
+	if err != nil {
+		return nil, err
+	}
+
+	outbounds := make([]*OutboundInfo, 0, len(items))
+	for _, item := range items {
+		info := &OutboundInfo{}
+		info.Tag, _ = item["tag"].(string)
+		info.Protocol, _ = item["protocol"].(string)
+		info.SendThrough, _ = item["sendThrough"].(string)
+		outbounds = append(outbounds, info)
+	}
+
+	return &GetOutboundsResponse{Outbounds: outbounds}, nil
+}
+
 // RestoreStart attempts to start Xray from the existing config file on disk
 func (s *XrayService) RestoreStart(ctx context.Context) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
 
 	if s.xrayCore.IsRunning() {
 		return nil
@@ -534,32 +1133,48 @@ func (s *XrayService) RestoreStart(ctx context.Context) error {
 
 	s.logger.Info("Attempting to restore Xray from local config...")
 
+	reopen := s.shutUserMutations(ctx)
+	defer reopen()
+
 	// Extract users from config to restore internal state
 	if s.internal != nil {
-		// We pass nil for hashes as we can't recover them easily,
-		// but at least user mapping will be restored for removal login
-		// Note: passing nil hashes might reset tracking, so be careful.
-		// However, ExtractUsersFromConfig clears existing state anyway.
-		if err := s.internal.ExtractUsersFromConfig(configBytes, nil); err != nil {
+		// Restore hashes persisted with the config so the first panel sync
+		// can skip the restart. Missing hashes just force that restart.
+		hashes, err := s.loadHashes()
+		if err != nil {
+			s.logger.Warn("Failed to load persisted inbound hashes", zap.Error(err))
+		}
+		if err := s.internal.ExtractUsersFromConfig(configBytes, hashes); err != nil {
 			s.logger.Warn("Failed to restore users from config", zap.Error(err))
 		}
 	}
 
+	if err := s.checkStartBlocked(); err != nil {
+		return err
+	}
+
+	s.hooks.Run(ctx, &HookEvent{Event: HookPreStart, Trigger: RestartTriggerRestore})
+
 	// Start Xray
+	coreStart := time.Now()
 	if err := s.xrayCore.Start(ctx, configBytes); err != nil {
-		s.isXrayOnline = false
+		s.health.MarkDown("restore failed: " + err.Error())
+		s.recordRestart(RestartTriggerRestore, "", coreStart, err.Error())
 		return fmt.Errorf("restore failed: %w", err)
 	}
 
 	// Verify health
 	if !s.checkXrayHealth(ctx) {
-		s.isXrayOnline = false
+		s.health.MarkDown("restored but health check failed")
+		s.recordRestart(RestartTriggerRestore, "", coreStart, "health check failed")
 		return fmt.Errorf("restored Xray health check failed")
 	}
 
+	s.recordRestart(RestartTriggerRestore, "", coreStart, "")
 	version := s.GetVersion()
-	s.isConfigured = true
-	s.isXrayOnline = true
+	s.isConfigured.Store(true)
+	s.health.MarkOnline()
+	s.hooks.Fire(&HookEvent{Event: HookPostStart, Trigger: RestartTriggerRestore, Version: version})
 
 	s.logger.Info("Xray restored successfully from local config",
 		zap.String("version", version))
@@ -574,9 +1189,7 @@ func (s *XrayService) IsRunning(ctx context.Context) bool {
 
 // GetNodeHealthCheck returns the node health check response (Node.js compatible)
 func (s *XrayService) GetNodeHealthCheck(ctx context.Context) *NodeHealthCheckResponse {
-	s.mu.RLock()
-	isXrayOnline := s.isXrayOnline
-	s.mu.RUnlock()
+	health := s.health.State()
 
 	var xrayVersion *string
 	if v := s.GetVersion(); v != "" && v != "unknown" {
@@ -586,31 +1199,215 @@ func (s *XrayService) GetNodeHealthCheck(ctx context.Context) *NodeHealthCheckRe
 	return &NodeHealthCheckResponse{
 		Response: NodeHealthCheckResponseData{
 			IsAlive:                  true,
-			XrayInternalStatusCached: isXrayOnline,
+			XrayInternalStatusCached: health.State != HealthDown,
 			XrayVersion:              xrayVersion,
 			NodeVersion:              nodeVersion,
+			XrayHealth:               health,
+			NodeInformation:          currentNodeInformation(),
+			NodeTime:                 time.Now().UTC(),
 		},
 	}
 }
 
 // IsConfigured returns true if Xray has been configured
 func (s *XrayService) IsConfigured() bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.isConfigured
+	return s.isConfigured.Load()
 }
 
 // GetConfig returns the current Xray configuration
+// Falls back to the previous version if config.json is corrupt; encrypted files are decrypted transparently
 func (s *XrayService) GetConfig() (json.RawMessage, error) {
+	s.FlushConfig()
+
 	configPath := filepath.Join(s.configDir, "config.json")
-	data, err := os.ReadFile(configPath)
+	data, err := atomicfile.ReadFileWithBackup(configPath, func(b []byte) bool {
+		plain, err := s.decodeConfig(b)
+		return err == nil && json.Valid(plain)
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
-	return data, nil
+	return s.decodeConfig(data)
+}
+
+// LocalConfig returns the config.json on disk with the inbound hashes persisted next to it
+func (s *XrayService) LocalConfig() (json.RawMessage, *InboundHashes, error) {
+	configBytes, err := s.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(configBytes) == 0 {
+		return nil, nil, fmt.Errorf("no config file found")
+	}
+
+	hashes, err := s.loadHashes()
+	if err != nil {
+		s.logger.Warn("Failed to load persisted inbound hashes", zap.Error(err))
+	}
+	return configBytes, hashes, nil
+}
+
+// pendingConfig is a config waiting to be written by persistConfig
+type pendingConfig struct {
+	config []byte
+	hashes *InboundHashes
+}
+
+// persistConfig writes config.json and the inbound hashes in the background,
+// keeping disk I/O (and encryption) out of the start critical section.
+// Writes are serialized and only the latest pending config is written, so a
+// burst of starts costs one write and the file never goes backwards.
+func (s *XrayService) persistConfig(configBytes []byte, hashes *InboundHashes) {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	s.pendingPersist = &pendingConfig{config: configBytes, hashes: hashes}
+	if !s.persisting {
+		s.persisting = true
+		go s.persistLoop()
+	}
+}
+
+// persistLoop writes pending configs until none is left
+func (s *XrayService) persistLoop() {
+	for {
+		s.persistMu.Lock()
+		next := s.pendingPersist
+		s.pendingPersist = nil
+		if next == nil {
+			s.persisting = false
+			s.persistIdle.Broadcast()
+			s.persistMu.Unlock()
+			return
+		}
+		s.persistMu.Unlock()
+
+		if err := s.writeConfig(next.config); err != nil {
+			s.logger.Error("Failed to persist Xray config", zap.Error(err))
+			s.health.SetConfigPersistError(err)
+			continue
+		}
+		s.health.SetConfigPersistError(nil)
+		// Persist hashes so the skip-restart optimization survives a node reboot
+		if err := s.saveHashes(next.hashes); err != nil {
+			s.logger.Warn("Failed to persist inbound hashes", zap.Error(err))
+		}
+	}
+}
+
+// FlushConfig waits until the last started config has been written to disk
+func (s *XrayService) FlushConfig() {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	for s.persisting {
+		s.persistIdle.Wait()
+	}
+}
+
+// writeConfig writes config.json, encrypted if enabled
+func (s *XrayService) writeConfig(configBytes []byte) error {
+	if err := os.MkdirAll(s.configDir, 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	diskBytes, err := s.encodeConfig(configBytes)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt config: %w", err)
+	}
+
+	configPath := filepath.Join(s.configDir, "config.json")
+	keepBackup := true
+	if s.encryptConfig {
+		if err := s.writeEncryptedBackup(configPath); err != nil {
+			return fmt.Errorf("failed to write config backup: %w", err)
+		}
+		keepBackup = false
+	}
+	if err := atomicfile.WriteFile(configPath, diskBytes, 0600, keepBackup); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	s.logger.Info("Written Xray config", zap.String("path", configPath))
+	return nil
+}
+
+// writeEncryptedBackup keeps the previous config.json as its backup, encrypting
+// it if it was written before encryption was enabled, so no plaintext copy of
+// the config is left behind
+func (s *XrayService) writeEncryptedBackup(configPath string) error {
+	backupPath := configPath + atomicfile.BackupSuffix
+
+	previous, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		// No new backup; encrypt a plaintext one left over from before
+		if previous, err = os.ReadFile(backupPath); err != nil || crypto.IsEncryptedAtRest(previous) {
+			return nil
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if !crypto.IsEncryptedAtRest(previous) {
+		if previous, err = crypto.EncryptAtRest(s.configKey, previous); err != nil {
+			return err
+		}
+	}
+	return atomicfile.WriteFile(backupPath, previous, 0600, false)
+}
+
+// encodeConfig prepares config bytes for writing to disk, encrypting them if enabled
+func (s *XrayService) encodeConfig(configBytes []byte) ([]byte, error) {
+	if !s.encryptConfig {
+		return configBytes, nil
+	}
+	return crypto.EncryptAtRest(s.configKey, configBytes)
+}
+
+// decodeConfig reverses encodeConfig; plaintext files are returned unchanged
+func (s *XrayService) decodeConfig(data []byte) ([]byte, error) {
+	if !crypto.IsEncryptedAtRest(data) {
+		return data, nil
+	}
+	return crypto.DecryptAtRest(s.configKey, data)
+}
+
+// saveHashes writes the inbound hashes next to config.json.
+// A nil hashes value removes any stale file.
+func (s *XrayService) saveHashes(hashes *InboundHashes) error {
+	hashesPath := filepath.Join(s.configDir, "hashes.json")
+	if hashes == nil {
+		if err := os.Remove(hashesPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(hashesPath, data, 0644, false)
+}
+
+// loadHashes reads the inbound hashes persisted by saveHashes
+func (s *XrayService) loadHashes() (*InboundHashes, error) {
+	data, err := os.ReadFile(filepath.Join(s.configDir, "hashes.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var hashes InboundHashes
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, err
+	}
+	return &hashes, nil
 }
 
 // GetVersion returns the Xray version from embedded core
@@ -620,7 +1417,7 @@ func (s *XrayService) GetVersion() string {
 
 // System information helper functions
 func getCPUCores() int {
-	return runtime.NumCPU()
+	return effectiveCPUCores()
 }
 
 func getCPUModel() string {
@@ -643,8 +1440,20 @@ func getCPUModel() string {
 }
 
 func getMemoryTotal() string {
+	if total := getMemoryTotalBytes(); total > 0 {
+		return strconv.FormatInt(total/1024, 10) + " kB"
+	}
+
+	// Fallback to Go runtime stats
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
+	return fmt.Sprintf("%d MB", memStats.Sys/1024/1024)
+}
+
+// getMemoryTotalBytes returns the memory the node can use, or 0 if unknown
+func getMemoryTotalBytes() int64 {
+	// A cgroup memory limit below the host memory is what the node can use
+	limit := DetectContainerLimits().MemoryLimit
 
 	// Try to get system total memory from /proc/meminfo on Linux
 	data, err := os.ReadFile("/proc/meminfo")
@@ -654,12 +1463,15 @@ func getMemoryTotal() string {
 			if strings.HasPrefix(line, "MemTotal:") {
 				parts := strings.Fields(line)
 				if len(parts) >= 2 {
-					return parts[1] + " kB"
+					if total, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+						if limit > 0 && limit/1024 < total {
+							return limit
+						}
+						return total * 1024
+					}
 				}
 			}
 		}
 	}
-
-	// Fallback to Go runtime stats
-	return fmt.Sprintf("%d MB", memStats.Sys/1024/1024)
+	return limit
 }
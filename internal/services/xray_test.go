@@ -0,0 +1,449 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+	"github.com/clash-version/remnawave-node-go/pkg/crypto"
+	"github.com/clash-version/remnawave-node-go/pkg/opbarrier"
+	"github.com/clash-version/remnawave-node-go/pkg/xraycore"
+)
+
+func newTestXrayService(t *testing.T, core *fakeCore, disableHashCheck bool) *XrayService {
+	t.Helper()
+
+	flags, err := NewFeatureFlags(map[string]bool{FlagHashCheck: !disableHashCheck})
+	if err != nil {
+		t.Fatalf("NewFeatureFlags failed: %v", err)
+	}
+	internal := NewInternalService(&InternalConfig{Flags: flags}, zap.NewNop())
+	health := NewHealthManager(&HealthConfig{}, core, zap.NewNop())
+	s := NewXrayService(&XrayConfig{
+		ConfigDir: t.TempDir(),
+		Flags:     flags,
+	}, core, internal, health, zap.NewNop())
+	// Background config writes must finish before the temp dir is removed
+	t.Cleanup(s.FlushConfig)
+	return s
+}
+
+func startRequest(inboundHash string, force bool) *StartRequest {
+	return &StartRequest{
+		Internals: StartRequestInternals{
+			ForceRestart: force,
+			Hashes: &InboundHashes{
+				EmptyConfig: "empty",
+				Inbounds:    []InboundHashItem{{Tag: "VLESS", Hash: inboundHash}},
+			},
+		},
+		XrayConfig: json.RawMessage(`{
+			"inbounds": [{"tag": "VLESS", "port": 443, "protocol": "vless", "settings": {"clients": []}}],
+			"outbounds": [{"tag": "DIRECT", "protocol": "freedom"}]
+		}`),
+	}
+}
+
+func mustStart(t *testing.T, s *XrayService, req *StartRequest) {
+	t.Helper()
+
+	resp, err := s.Start(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if !resp.Response.IsStarted {
+		t.Fatalf("Expected start to succeed, got %v", *resp.Response.Error)
+	}
+}
+
+func TestXray_Start_SkipsRestartWhenHashesUnchanged(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	mustStart(t, s, startRequest("h1", false))
+	mustStart(t, s, startRequest("h1", false))
+	if core.starts != 1 {
+		t.Errorf("Expected the unchanged config to skip the restart, got %d starts", core.starts)
+	}
+
+	mustStart(t, s, startRequest("h2", false))
+	if core.starts != 2 {
+		t.Errorf("Expected a changed inbound hash to restart, got %d starts", core.starts)
+	}
+}
+
+func TestXray_Start_ForceRestart(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	mustStart(t, s, startRequest("h1", false))
+	mustStart(t, s, startRequest("h1", true))
+	if core.starts != 2 {
+		t.Errorf("Expected forceRestart to restart, got %d starts", core.starts)
+	}
+}
+
+func TestXray_Start_HashCheckDisabled(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, true)
+
+	mustStart(t, s, startRequest("h1", false))
+	mustStart(t, s, startRequest("h1", false))
+	if core.starts != 2 {
+		t.Errorf("Expected every start to restart with the hash check disabled, got %d starts", core.starts)
+	}
+}
+
+func TestXray_Start_HashCheckToggledAtRuntime(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	mustStart(t, s, startRequest("h1", false))
+	req := startRequest("h1", false)
+	req.Internals.SkipHashCheck = true
+	mustStart(t, s, req)
+	if core.starts != 2 || s.HashCheckDisabled() {
+		t.Errorf("Expected skipHashCheck to restart once without changing the setting, got %d starts", core.starts)
+	}
+
+	if _, err := s.flags.Toggle(FlagHashCheck, false); err != nil {
+		t.Fatalf("Toggle failed: %v", err)
+	}
+	mustStart(t, s, startRequest("h1", false))
+	if core.starts != 3 {
+		t.Errorf("Expected a disabled hash check to restart, got %d starts", core.starts)
+	}
+
+	if _, err := s.flags.Toggle(FlagHashCheck, true); err != nil {
+		t.Fatalf("Toggle failed: %v", err)
+	}
+	mustStart(t, s, startRequest("h1", false))
+	if core.starts != 3 {
+		t.Errorf("Expected a re-enabled hash check to skip the restart, got %d starts", core.starts)
+	}
+}
+
+func TestXray_Start_RestartsUnhealthyCore(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	mustStart(t, s, startRequest("h1", false))
+
+	// The core died behind the node's back
+	core.mu.Lock()
+	core.running = false
+	core.mu.Unlock()
+
+	mustStart(t, s, startRequest("h1", false))
+	if core.starts != 2 {
+		t.Errorf("Expected a dead core to be restarted despite unchanged hashes, got %d starts", core.starts)
+	}
+}
+
+func TestXray_Start_Blocked(t *testing.T) {
+	core := newFakeCore(false)
+	internal := NewInternalService(&InternalConfig{}, zap.NewNop())
+	health := NewHealthManager(&HealthConfig{}, core, zap.NewNop())
+	s := NewXrayService(&XrayConfig{
+		ConfigDir:    t.TempDir(),
+		StartBlocked: func() error { return fmt.Errorf("traffic budget exhausted") },
+	}, core, internal, health, zap.NewNop())
+	t.Cleanup(s.FlushConfig)
+
+	resp, err := s.Start(context.Background(), startRequest("h1", false))
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if resp.Response.IsStarted || core.starts != 0 {
+		t.Errorf("Expected a blocked start to leave the core stopped, got %+v with %d starts", resp.Response, core.starts)
+	}
+}
+
+func TestXray_Start_PersistsConfigInBackground(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	mustStart(t, s, startRequest("h1", false))
+	mustStart(t, s, startRequest("h2", false))
+
+	// GetConfig waits for pending writes, so it sees the last started config
+	onDisk, err := s.GetConfig()
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if string(onDisk) != string(core.GetConfig()) {
+		t.Errorf("Expected config.json to match the running config, got %s", onDisk)
+	}
+
+	hashes, err := s.loadHashes()
+	if err != nil || hashes == nil || hashes.Inbounds[0].Hash != "h2" {
+		t.Errorf("Expected the hashes of the last start to be persisted, got %+v (%v)", hashes, err)
+	}
+}
+
+func TestXray_ExplainRestart(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	hashes := startRequest("h1", false).Internals.Hashes
+	if decision := s.ExplainRestart(hashes); !decision.NeedRestart || decision.Reasons[0].Kind != RestartReasonCoreNotRunning {
+		t.Errorf("Expected a stopped core to need a start, got %+v", decision)
+	}
+
+	mustStart(t, s, startRequest("h1", false))
+	if decision := s.ExplainRestart(hashes); decision.NeedRestart || len(decision.Reasons) != 0 {
+		t.Errorf("Expected unchanged hashes not to restart, got %+v", decision)
+	}
+
+	changed := &InboundHashes{
+		EmptyConfig: "empty2",
+		Inbounds:    []InboundHashItem{{Tag: "VLESS", Hash: "h2"}, {Tag: "TROJAN", Hash: "h3"}},
+	}
+	decision := s.ExplainRestart(changed)
+	var kinds []string
+	for _, reason := range decision.Reasons {
+		kinds = append(kinds, reason.Kind+":"+reason.Tag)
+	}
+	want := []string{
+		RestartReasonEmptyConfigChanged + ":",
+		RestartReasonInboundCountChanged + ":",
+		RestartReasonInboundHashChanged + ":VLESS",
+		RestartReasonInboundAdded + ":TROJAN",
+	}
+	if !decision.NeedRestart || fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Errorf("Expected reasons %v, got %v", want, kinds)
+	}
+	if s.internal.IsNeedRestartCore(changed) != decision.NeedRestart {
+		t.Error("Expected IsNeedRestartCore to make the same decision")
+	}
+}
+
+func TestXray_RestartHistory(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	mustStart(t, s, startRequest("h1", false))
+	mustStart(t, s, startRequest("h1", false)) // Skipped, not a restart
+	mustStart(t, s, startRequest("h2", false))
+	mustStart(t, s, startRequest("h2", true))
+	core.Stop() // The core dies under the node
+	mustStart(t, s, startRequest("h2", false))
+
+	var got []string
+	for _, record := range s.RestartHistory() {
+		if !record.Success || record.Version != "fake" {
+			t.Errorf("Expected a successful restart with the core version, got %+v", record)
+		}
+		got = append(got, record.Trigger+":"+record.Detail)
+	}
+	want := []string{
+		RestartTriggerCrash + ":",
+		RestartTriggerForce + ":",
+		RestartTriggerHashChange + ":" + RestartReasonInboundHashChanged + " VLESS",
+		RestartTriggerInitial + ":",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected restarts %v, got %v", want, got)
+	}
+
+	for i := 0; i < restartHistoryLimit+5; i++ {
+		s.restarts.add(RestartRecord{Trigger: RestartTriggerRestart})
+	}
+	if records := s.RestartHistory(); len(records) != restartHistoryLimit {
+		t.Errorf("Expected the history to be bounded to %d, got %d", restartHistoryLimit, len(records))
+	}
+}
+
+func TestXray_Start_UserMutationsWaitForStart(t *testing.T) {
+	core := newFakeCore(false)
+	core.startGate = make(chan struct{})
+	barrier := opbarrier.New()
+	internal := NewInternalService(&InternalConfig{}, zap.NewNop())
+	s := NewXrayService(&XrayConfig{
+		ConfigDir:   t.TempDir(),
+		UserBarrier: barrier,
+	}, core, internal, NewHealthManager(&HealthConfig{}, core, zap.NewNop()), zap.NewNop())
+	t.Cleanup(s.FlushConfig)
+	handler := NewHandlerService(core, internal, nil, nil, barrier, nil, nil, nil, zap.NewNop())
+
+	started := make(chan *StartResponse)
+	go func() {
+		resp, _ := s.Start(context.Background(), startRequest("h1", false))
+		started <- resp
+	}()
+	<-core.startGate // The start is rebuilding the core
+
+	added := make(chan *AddUserResponse)
+	go func() {
+		resp, _ := handler.AddUser(context.Background(), &AddUserRequest{
+			Data: []UserData{vlessUser("VLESS", "alice", testUUID1)},
+		})
+		added <- resp
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("Expected the add to wait for the start in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	core.startGate <- struct{}{}
+	if resp := <-started; !resp.Response.IsStarted {
+		t.Fatalf("Expected the start to succeed, got %v", *resp.Response.Error)
+	}
+	if resp := <-added; !resp.Success {
+		t.Fatalf("Expected the add to apply to the started core, got %v", *resp.Error)
+	}
+	if n := internal.GetUsersCountInInbound("VLESS"); n != 1 {
+		t.Errorf("Expected the added user to be tracked after the start, got %d", n)
+	}
+}
+
+func TestXray_RestartKeepsRuntimeUsers(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+	ctx := context.Background()
+	mustStart(t, s, startRequest("hash", false))
+
+	panelConfigs := 0
+	s.OnPanelConfig(func() { panelConfigs++ })
+
+	// Added after the start, so not in the config the core restarts with
+	user, err := xraycore.CreateVlessUser("alice", testUUID1, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.AddUser(ctx, "VLESS", user); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := s.Restart(ctx, &RestartRequest{ForceRestart: true})
+	if err != nil || !resp.Success {
+		t.Fatalf("Expected the restart to succeed, got %+v (%v)", resp, err)
+	}
+	if _, err := core.GetInboundUser(ctx, "VLESS", "alice"); err != nil {
+		t.Errorf("Expected the runtime user to survive the restart, got %v", err)
+	}
+
+	// The node's own config.json is no panel push, so the journal is kept
+	resp, err = s.Restart(ctx, &RestartRequest{Config: startRequest("hash", false).XrayConfig, ForceRestart: true, Local: true})
+	if err != nil || !resp.Success {
+		t.Fatalf("Expected the local restart to succeed, got %+v (%v)", resp, err)
+	}
+	if panelConfigs != 0 {
+		t.Errorf("Expected a local restart not to count as a panel config, got %d", panelConfigs)
+	}
+}
+
+func TestXray_ReportsConfigPersistFailures(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+
+	// A file where the config directory should be makes every write fail
+	blocker := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.configDir = filepath.Join(blocker, "node")
+
+	mustStart(t, s, startRequest("h1", true))
+	s.FlushConfig()
+	if s.health.State().ConfigPersistError == "" {
+		t.Fatal("Expected the failed write to show in the health state")
+	}
+
+	resp, err := s.Start(context.Background(), startRequest("h1", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Response.Warnings) != 1 || !strings.Contains(resp.Response.Warnings[0], "not saved") {
+		t.Errorf("Expected the next start to warn about the failed write, got %v", resp.Response.Warnings)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	mustStart(t, s, startRequest("h1", true))
+	s.FlushConfig()
+	if persistErr := s.health.State().ConfigPersistError; persistErr != "" {
+		t.Errorf("Expected a successful write to clear the error, got %q", persistErr)
+	}
+}
+
+func TestXray_EncryptsConfigBackup(t *testing.T) {
+	s := newTestXrayService(t, newFakeCore(false), false)
+	key, err := crypto.DeriveAtRestKey("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A config written before encryption was enabled
+	configPath := filepath.Join(s.configDir, "config.json")
+	if err := s.writeConfig([]byte(`{"plain": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.encryptConfig, s.configKey = true, key
+	for _, config := range []string{`{"encrypted": 1}`, `{"encrypted": 2}`} {
+		if err := s.writeConfig([]byte(config)); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{configPath, configPath + atomicfile.BackupSuffix} {
+			if data, err := os.ReadFile(path); err != nil || !crypto.IsEncryptedAtRest(data) {
+				t.Errorf("Expected %s encrypted after writing %s, got %q (%v)", filepath.Base(path), config, data, err)
+			}
+		}
+	}
+
+	// The encrypted backup still serves as the fallback for a corrupt config
+	if err := os.WriteFile(configPath, []byte("corrupt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := s.GetConfig()
+	if err != nil || string(config) != `{"encrypted": 1}` {
+		t.Errorf("Expected the backup to be decrypted, got %s (%v)", config, err)
+	}
+}
+
+func TestXray_RestartRunsHooks(t *testing.T) {
+	core := newFakeCore(false)
+	s := newTestXrayService(t, core, false)
+	mustStart(t, s, startRequest("hash", false))
+
+	out := filepath.Join(t.TempDir(), "events")
+	s.hooks = NewHookService(&HookConfig{Hooks: []HookDefinition{
+		{Name: "record", Events: []string{HookPreStart, HookPostStart}, Command: []string{"sh", "-c", `echo "$1 $2 $3" >> "$4"`, "_", "{{.Event}}", "{{.Trigger}}", "{{join .Tags \",\"}}", out}},
+		{Name: "broken", Events: []string{HookPreStart}, Command: []string{"false"}},
+	}}, zap.NewNop())
+	defer s.hooks.Stop()
+
+	resp, err := s.Restart(context.Background(), &RestartRequest{ForceRestart: true})
+	if err != nil || !resp.Success {
+		t.Fatalf("Expected the restart to succeed despite the failing hook, got %+v (%v)", resp, err)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "broken") {
+		t.Errorf("Expected the failed pre-start hook as a warning, got %v", resp.Warnings)
+	}
+
+	// Post-start hooks are fired in the background
+	want := "pre-start restart VLESS\npost-start restart VLESS\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(out)
+		if string(data) == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the restart to run both hooks, got %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
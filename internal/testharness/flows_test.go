@@ -0,0 +1,469 @@
+package testharness
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clash-version/remnawave-node-go/internal/services"
+	"github.com/clash-version/remnawave-node-go/pkg/client"
+)
+
+const testUUID = "5f0c3b4e-2d1a-4c8b-9e7f-1a2b3c4d5e6f"
+
+// startCore starts the core with a single VLESS inbound and checks it runs
+func startCore(t *testing.T, node *Node, tag string) {
+	t.Helper()
+
+	var start services.StartResponseData
+	node.Call(http.MethodPost, "/node/xray/start", MinimalXrayConfig(tag, FreePort(t)), &start)
+	if !start.IsStarted {
+		msg := "<nil>"
+		if start.Error != nil {
+			msg = *start.Error
+		}
+		t.Fatalf("Expected core to start, got error %s", msg)
+	}
+
+	var status struct {
+		IsRunning bool `json:"isRunning"`
+	}
+	node.Call(http.MethodGet, "/node/xray/status", nil, &status)
+	if !status.IsRunning {
+		t.Fatal("Expected core to be running after start")
+	}
+}
+
+func TestE2E_RequiresAuthentication(t *testing.T) {
+	node := Start(t, nil)
+
+	resp, err := node.Client().Get(node.URL + "/node/xray/status")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", resp.StatusCode)
+	}
+}
+
+func TestE2E_NegotiatesHTTP2(t *testing.T) {
+	node := Start(t, nil)
+
+	transport := node.Client().Transport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	resp, err := (&http.Client{Transport: transport}).Get(node.URL + "/node/xray/status")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2 to be negotiated, got %s", resp.Proto)
+	}
+}
+
+func TestE2E_AddAndRemoveUser(t *testing.T) {
+	node := Start(t, nil)
+	startCore(t, node, "VLESS_E2E")
+
+	var added services.AddUserResponse
+	node.Call(http.MethodPost, "/node/handler/add-user", services.AddUserRequest{
+		Data: []services.UserData{
+			{Type: "vless", Tag: "VLESS_E2E", Username: "alice", UUID: testUUID},
+		},
+		HashData: services.HashData{VlessUUID: testUUID},
+	}, &added)
+	if !added.Success {
+		t.Fatalf("Expected add-user to succeed, got %+v", added)
+	}
+
+	var count services.GetInboundUsersCountResponse
+	node.Call(http.MethodPost, "/node/handler/get-inbound-users-count", map[string]string{"tag": "VLESS_E2E"}, &count)
+	if count.Count != 1 {
+		t.Errorf("Expected 1 user in inbound, got %d", count.Count)
+	}
+
+	var removed services.RemoveUserResponse
+	node.Call(http.MethodPost, "/node/handler/remove-user", services.RemoveUserRequest{
+		Username: "alice",
+		HashData: services.RemoveUserHashData{VlessUUID: testUUID},
+	}, &removed)
+	if !removed.Success {
+		t.Fatalf("Expected remove-user to succeed, got %+v", removed)
+	}
+
+	node.Call(http.MethodPost, "/node/handler/get-inbound-users-count", map[string]string{"tag": "VLESS_E2E"}, &count)
+	if count.Count != 0 {
+		t.Errorf("Expected no users after removal, got %d", count.Count)
+	}
+}
+
+func TestE2E_InvalidUserRejected(t *testing.T) {
+	node := Start(t, nil)
+	startCore(t, node, "VLESS_E2E")
+
+	var added services.AddUserResponse
+	node.Call(http.MethodPost, "/node/handler/add-user", services.AddUserRequest{
+		Data: []services.UserData{
+			{Type: "vless", Tag: "VLESS_E2E", Username: "bob", UUID: "not-a-uuid"},
+		},
+	}, &added)
+	if added.Success || len(added.FieldErrors) == 0 {
+		t.Errorf("Expected field errors for an invalid UUID, got %+v", added)
+	}
+}
+
+// startEchoServer starts a TCP server that sends back whatever it receives
+// and returns its port
+func startEchoServer(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestE2E_Stats(t *testing.T) {
+	node := Start(t, nil)
+	vlessPort, relayPort, echoPort := FreePort(t), FreePort(t), startEchoServer(t)
+
+	// A dokodemo-door inbound "relay" reaches the echo server as alice,
+	// through the node's own VLESS inbound
+	start := MinimalXrayConfig("VLESS_E2E", vlessPort)
+	xray := start["xrayConfig"].(map[string]interface{})
+	xray["inbounds"] = append(xray["inbounds"].([]map[string]interface{}), map[string]interface{}{
+		"tag": "relay", "listen": "127.0.0.1", "port": relayPort, "protocol": "dokodemo-door",
+		"settings": map[string]interface{}{"address": "127.0.0.1", "port": echoPort, "network": "tcp"},
+	})
+	xray["outbounds"] = append(xray["outbounds"].([]map[string]interface{}), map[string]interface{}{
+		"tag": "as-alice", "protocol": "vless",
+		"settings": map[string]interface{}{"vnext": []interface{}{map[string]interface{}{
+			"address": "127.0.0.1", "port": vlessPort,
+			"users": []interface{}{map[string]interface{}{"id": testUUID, "encryption": "none"}},
+		}}},
+	})
+	xray["routing"] = map[string]interface{}{"rules": []interface{}{
+		map[string]interface{}{"type": "field", "inboundTag": []string{"relay"}, "outboundTag": "as-alice"},
+	}}
+	var started services.StartResponseData
+	node.Call(http.MethodPost, "/node/xray/start", start, &started)
+	if !started.IsStarted {
+		t.Fatalf("Expected core to start, got %+v", started)
+	}
+
+	var added services.AddUserResponse
+	node.Call(http.MethodPost, "/node/handler/add-user", services.AddUserRequest{
+		Data:     []services.UserData{{Type: "vless", Tag: "VLESS_E2E", Username: "alice", UUID: testUUID}},
+		HashData: services.HashData{VlessUUID: testUUID},
+	}, &added)
+	if !added.Success {
+		t.Fatalf("Expected add-user to succeed, got %+v", added)
+	}
+
+	const n = 4096
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", relayPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// Counters are updated as the core relays, so wait for both directions
+	counted := func() (string, bool) {
+		var users services.GetAllUsersStatsResponse
+		node.Call(http.MethodPost, "/node/stats/get-users-stats", map[string]bool{"reset": false}, &users)
+		var inbounds services.GetAllInboundsStatsResponse
+		node.Call(http.MethodPost, "/node/stats/get-all-inbounds-stats", map[string]bool{"reset": false}, &inbounds)
+
+		traffic := make(map[string][2]int64)
+		for _, user := range users.Users {
+			traffic["user "+user.Username] = [2]int64{user.Uplink, user.Downlink}
+		}
+		for _, inbound := range inbounds.Inbounds {
+			traffic["inbound "+inbound.Inbound] = [2]int64{inbound.Uplink, inbound.Downlink}
+		}
+		for _, name := range []string{"user alice", "inbound relay"} {
+			if traffic[name][0] < n || traffic[name][1] < n {
+				return fmt.Sprintf("%s counted %v", name, traffic[name]), false
+			}
+		}
+		// xray splices the VLESS inbound's responses from freedom past its
+		// downlink counter, so only its uplink is reliable here
+		if traffic["inbound VLESS_E2E"][0] < n {
+			return fmt.Sprintf("inbound VLESS_E2E counted %v", traffic["inbound VLESS_E2E"]), false
+		}
+		return "", true
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		missing, ok := counted()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d bytes each way, but %s", n, missing)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A reset reports the traffic once
+	var reset services.GetAllUsersStatsResponse
+	node.Call(http.MethodPost, "/node/stats/get-users-stats", map[string]bool{"reset": true}, &reset)
+	if len(reset.Users) != 1 || reset.Users[0].Uplink < n {
+		t.Errorf("Expected the reset to report alice's traffic, got %+v", reset.Users)
+	}
+	node.Call(http.MethodPost, "/node/stats/get-users-stats", map[string]bool{"reset": false}, &reset)
+	if len(reset.Users) != 0 {
+		t.Errorf("Expected no traffic after the reset, got %+v", reset.Users)
+	}
+
+	var system map[string]interface{}
+	node.Call(http.MethodGet, "/node/stats/get-system-stats", nil, &system)
+	if len(system) == 0 {
+		t.Error("Expected system stats")
+	}
+}
+
+func TestE2E_BlockAndUnblockIP(t *testing.T) {
+	node := Start(t, nil)
+	startCore(t, node, "VLESS_E2E")
+
+	var blocked services.BlockIPResponse
+	node.Call(http.MethodPost, "/node/vision/block-ip", services.BlockIPRequest{IP: "203.0.113.7", Username: "alice"}, &blocked)
+	if !blocked.Success {
+		t.Fatalf("Expected block-ip to succeed, got error %v", *blocked.Error)
+	}
+
+	var rules services.GetRoutingRulesResponse
+	node.Call(http.MethodGet, "/node/routing/rules", nil, &rules)
+	if len(rules.Rules) != 1 || rules.Rules[0].Owner != services.RuleOwnerVision || !rules.Rules[0].Installed {
+		t.Fatalf("Expected the installed block rule to be listed, got %+v", rules.Rules)
+	}
+
+	var unblocked services.UnblockIPResponse
+	node.Call(http.MethodPost, "/node/vision/unblock-ip", services.UnblockIPRequest{IP: "203.0.113.7"}, &unblocked)
+	if !unblocked.Success {
+		t.Fatalf("Expected unblock-ip to succeed, got error %v", *unblocked.Error)
+	}
+
+	node.Call(http.MethodGet, "/node/routing/rules", nil, &rules)
+	if len(rules.Rules) != 0 {
+		t.Errorf("Expected no rules after unblocking, got %+v", rules.Rules)
+	}
+
+	// The core keeps routing after the block rule was added and removed
+	var status struct {
+		IsRunning bool `json:"isRunning"`
+	}
+	node.Call(http.MethodGet, "/node/xray/status", nil, &status)
+	if !status.IsRunning {
+		t.Error("Expected core to keep running")
+	}
+}
+
+func TestE2E_ClientSDK(t *testing.T) {
+	node := Start(t, nil)
+	startCore(t, node, "VLESS_E2E")
+	sdk := node.SDK()
+	ctx := context.Background()
+
+	status, err := sdk.Status(ctx)
+	if err != nil || !status.IsRunning {
+		t.Fatalf("Expected the core to be running, got %+v (%v)", status, err)
+	}
+
+	added, err := sdk.AddUser(ctx, &services.AddUserRequest{
+		Data:     []services.UserData{{Type: "vless", Tag: "VLESS_E2E", Username: "alice", UUID: testUUID}},
+		HashData: services.HashData{VlessUUID: testUUID},
+	})
+	if err != nil || !added.Success {
+		t.Fatalf("Expected add-user to succeed, got %+v (%v)", added, err)
+	}
+	found, err := sdk.FindUser(ctx, &services.FindUserRequest{Query: "alice"})
+	if err != nil || len(found.Matches) != 1 || found.Matches[0].Inbound != "VLESS_E2E" {
+		t.Errorf("Expected alice in VLESS_E2E, got %+v (%v)", found, err)
+	}
+
+	config, err := sdk.Config(ctx)
+	if err != nil || len(config.Config) == 0 {
+		t.Errorf("Expected the stored config, got %+v (%v)", config, err)
+	}
+
+	// Errors reported by the node come back as APIError
+	_, err = sdk.InboundUsers(ctx, &services.GetInboundUsersRequest{Tag: "VLESS_E2E", Limit: -1})
+	if !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected a 400 APIError, got %v", err)
+	}
+}
+
+func TestE2E_Restart(t *testing.T) {
+	node := Start(t, nil)
+	startCore(t, node, "VLESS_E2E")
+	sdk := node.SDK()
+	ctx := context.Background()
+
+	added, err := sdk.AddUser(ctx, &services.AddUserRequest{
+		Data:     []services.UserData{{Type: "vless", Tag: "VLESS_E2E", Username: "alice", UUID: testUUID}},
+		HashData: services.HashData{VlessUUID: testUUID},
+	})
+	if err != nil || !added.Success {
+		t.Fatalf("Expected add-user to succeed, got %+v (%v)", added, err)
+	}
+
+	restarted, err := sdk.Restart(ctx)
+	if err != nil || !restarted.Success {
+		t.Fatalf("Expected the restart to succeed, got %+v (%v)", restarted, err)
+	}
+
+	// Users added since the last start are put back
+	found, err := sdk.FindUser(ctx, &services.FindUserRequest{Query: "alice"})
+	if err != nil || len(found.Matches) != 1 {
+		t.Errorf("Expected alice to survive the restart, got %+v (%v)", found, err)
+	}
+	status, err := sdk.Status(ctx)
+	if err != nil || !status.IsRunning {
+		t.Errorf("Expected the core to be running, got %+v (%v)", status, err)
+	}
+}
+
+func TestE2E_Facts(t *testing.T) {
+	node := Start(t, nil)
+	sdk := node.SDK()
+	ctx := context.Background()
+
+	facts, err := sdk.Facts(ctx)
+	if err != nil || facts.SchemaVersion != services.FactsSchemaVersion || facts.Config.Running || facts.Config.ConfigHash != "" {
+		t.Fatalf("Expected facts of a stopped node, got %+v (%v)", facts, err)
+	}
+
+	startCore(t, node, "VLESS_E2E")
+	facts, err = sdk.Facts(ctx)
+	if err != nil || !facts.Config.Running || len(facts.Config.ConfigHash) != 64 || facts.Capacity.Inbounds != 1 {
+		t.Fatalf("Expected facts of a running node, got %+v (%v)", facts, err)
+	}
+	if len(facts.Capabilities.Protocols.Inbounds) == 0 || facts.Versions.Xray == "" {
+		t.Errorf("Expected versions and protocols, got %+v", facts)
+	}
+
+	// Runtime flag toggles show up in the features
+	if !slices.Contains(facts.Capabilities.Features, services.FlagConflictWarnings) {
+		t.Errorf("Expected %s among the features, got %v", services.FlagConflictWarnings, facts.Capabilities.Features)
+	}
+	if _, err := sdk.SetFeatureFlag(ctx, services.FlagConflictWarnings, false); err != nil {
+		t.Fatal(err)
+	}
+	facts, err = sdk.Facts(ctx)
+	if err != nil || slices.Contains(facts.Capabilities.Features, services.FlagConflictWarnings) {
+		t.Errorf("Expected the toggled flag to be left out, got %v (%v)", facts.Capabilities.Features, err)
+	}
+}
+
+func TestE2E_StatsExport(t *testing.T) {
+	node := Start(t, nil)
+	startCore(t, node, "VLESS_E2E")
+	sdk := node.SDK()
+	ctx := context.Background()
+
+	data, err := sdk.Do(ctx, http.MethodPost, "/node/stats/get-all-inbounds-stats", url.Values{"format": {"csv"}}, map[string]bool{"reset": false})
+	if err != nil || !strings.HasPrefix(string(data), "inbound,uplink,downlink\n") {
+		t.Errorf("Expected inbound stats as CSV, got %q (%v)", data, err)
+	}
+	if _, err := sdk.UsersStatsCSV(ctx, false); err != nil {
+		t.Errorf("Expected user stats as CSV, got %v", err)
+	}
+	_, err = sdk.Do(ctx, http.MethodPost, "/node/stats/get-users-stats", url.Values{"format": {"xml"}}, nil)
+	if !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected an unknown format to be rejected, got %v", err)
+	}
+	_, err = sdk.Do(ctx, http.MethodPost, "/node/stats/get-users-stats", url.Values{"fields": {"username"}}, map[string]bool{"reset": true})
+	if !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected fields to be rejected on a resetting request, got %v", err)
+	}
+
+	job, err := sdk.StartExport(ctx, services.ExportInbounds)
+	if err != nil {
+		t.Fatalf("Expected the export to start, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job.State == services.ExportRunning && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		if job, err = sdk.Export(ctx, job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.State != services.ExportDone {
+		t.Fatalf("Expected the export to finish, got %+v", job)
+	}
+
+	data, err = sdk.DownloadExport(ctx, job.ID)
+	if err != nil || !strings.HasPrefix(string(data), "inbound,uplink,downlink\n") || int64(len(data)) != job.Size {
+		t.Errorf("Expected the exported CSV, got %q (%v)", data, err)
+	}
+}
+
+func TestE2E_CapacityHardCap(t *testing.T) {
+	if _, err := os.Stat("/proc/meminfo"); err != nil {
+		t.Skip("needs /proc/meminfo")
+	}
+	// Any memory use is over a limit this low
+	node := Start(t, map[string]string{
+		"CAPACITY_INTERVAL":           "50ms",
+		"CAPACITY_MAX_MEMORY_PERCENT": "0.0001",
+		"CAPACITY_HARD_CAP":           "true",
+	})
+	startCore(t, node, "VLESS_E2E")
+	sdk := node.SDK()
+	ctx := context.Background()
+
+	var health *services.NodeHealthCheckResponseData
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var err error
+		if health, err = sdk.HealthCheck(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if health.CapacityScore != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if health.CapacityScore == nil || *health.CapacityScore != 0 || !health.Capacity.Full {
+		t.Fatalf("Expected a full node with capacityScore 0, got %+v", health)
+	}
+
+	added, err := sdk.AddUser(ctx, &services.AddUserRequest{
+		Data:     []services.UserData{{Type: "vless", Tag: "VLESS_E2E", Username: "alice", UUID: testUUID}},
+		HashData: services.HashData{VlessUUID: testUUID},
+	})
+	if err != nil || added.Success {
+		t.Errorf("Expected add-user to be rejected at capacity, got %+v (%v)", added, err)
+	}
+}
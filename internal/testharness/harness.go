@@ -0,0 +1,386 @@
+// Package testharness boots the full node (API server and embedded Xray core)
+// for end-to-end tests. It generates a SECRET_KEY with a throwaway CA, server
+// and client certificates and a JWT signing key, and talks to the node over
+// mTLS with signed tokens, exactly like a panel would.
+package testharness
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/clash-version/remnawave-node-go/internal/config"
+	"github.com/clash-version/remnawave-node-go/internal/server"
+	"github.com/clash-version/remnawave-node-go/pkg/client"
+	"github.com/clash-version/remnawave-node-go/pkg/crypto"
+	"github.com/clash-version/remnawave-node-go/pkg/logger"
+	"github.com/golang-jwt/jwt/v5"
+
+	_ "github.com/xtls/xray-core/main/distro/all"
+)
+
+// Node is a running node under test
+type Node struct {
+	URL    string // Base URL of the API, e.g. https://127.0.0.1:40123
+	Config *config.Config
+
+	t      testing.TB
+	server *server.Server
+	client *http.Client
+	jwtKey *rsa.PrivateKey
+}
+
+// Start boots a node with a generated SECRET_KEY on a free port, with its state
+// in a temporary directory. Extra environment variables (e.g. feature flags)
+// are applied before the configuration is loaded. The node is shut down when
+// the test ends. Tests using it can't run in parallel, as the configuration is
+// read from the environment.
+func Start(t testing.TB, env map[string]string) *Node {
+	t.Helper()
+
+	secrets, err := generateSecrets()
+	if err != nil {
+		t.Fatalf("Failed to generate secrets: %v", err)
+	}
+
+	port := FreePort(t)
+	t.Setenv("SECRET_KEY", secrets.secretKey)
+	t.Setenv("NODE_PORT", strconv.Itoa(port))
+	t.Setenv("NODE_STATE_DIR", t.TempDir())
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("NTP_CHECK_INTERVAL", "0") // No NTP queries from tests
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	srv, err := server.New(cfg, logger.New())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Start()
+	}()
+
+	n := &Node{
+		URL:    fmt.Sprintf("https://127.0.0.1:%d", port),
+		Config: cfg,
+		t:      t,
+		server: srv,
+		jwtKey: secrets.jwtKey,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      secrets.caPool,
+					Certificates: []tls.Certificate{secrets.clientCert},
+					ServerName:   "127.0.0.1",
+				},
+			},
+		},
+	}
+	t.Cleanup(n.stop)
+
+	// Wait for the listener
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		select {
+		case err := <-serveErr:
+			t.Fatalf("Server failed to start: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not start listening on port %d", port)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return n
+}
+
+// stop shuts the node down, including the core
+func (n *Node) stop() {
+	// The test context is already cancelled when cleanups run
+	if err := n.server.Shutdown(context.Background()); err != nil {
+		n.t.Errorf("Server shutdown failed: %v", err)
+	}
+}
+
+// Token returns a JWT signed with the node's panel key
+func (n *Node) Token() string {
+	n.t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(n.jwtKey)
+	if err != nil {
+		n.t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// Do sends an authenticated request with a JSON body (nil for none) and
+// returns the status code and raw response body
+func (n *Node) Do(method, path string, body interface{}) (int, []byte) {
+	n.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			n.t.Fatalf("Failed to marshal request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, n.URL+path, reader)
+	if err != nil {
+		n.t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+n.Token())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		n.t.Fatalf("Failed to read response of %s %s: %v", method, path, err)
+	}
+	return resp.StatusCode, data
+}
+
+// Call sends an authenticated request, fails the test unless it returns
+// 200 OK, and decodes the `response` field of the body into out (if non-nil)
+func (n *Node) Call(method, path string, body, out interface{}) {
+	n.t.Helper()
+
+	status, data := n.Do(method, path, body)
+	if status != http.StatusOK {
+		n.t.Fatalf("%s %s returned %d: %s", method, path, status, data)
+	}
+	if out == nil {
+		return
+	}
+
+	var envelope struct {
+		Response json.RawMessage `json:"response"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		n.t.Fatalf("Failed to decode response of %s %s: %v", method, path, err)
+	}
+	if err := json.Unmarshal(envelope.Response, out); err != nil {
+		n.t.Fatalf("Failed to decode response of %s %s: %v (%s)", method, path, err, envelope.Response)
+	}
+}
+
+// Client returns an HTTP client presenting the panel's client certificate,
+// for requests that need custom headers (e.g. without a token)
+func (n *Node) Client() *http.Client {
+	return n.client
+}
+
+// SDK returns a client SDK instance authenticated like the panel
+func (n *Node) SDK() *client.Client {
+	n.t.Helper()
+
+	c, err := client.New(&client.Config{
+		URL:         n.URL,
+		TLSConfig:   n.client.Transport.(*http.Transport).TLSClientConfig,
+		TokenSource: client.SignedTokens(n.jwtKey, time.Hour),
+	})
+	if err != nil {
+		n.t.Fatalf("Failed to create client: %v", err)
+	}
+	return c
+}
+
+// FreePort returns a TCP port that was free at the time of the call
+func FreePort(t testing.TB) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// MinimalXrayConfig returns a start request with a single VLESS inbound on the
+// given port, a freedom outbound and the blackhole "block" outbound used for
+// Vision blocking, in the format the panel sends
+func MinimalXrayConfig(inboundTag string, port int) map[string]interface{} {
+	return map[string]interface{}{
+		"internals": map[string]interface{}{
+			"forceRestart": false,
+			"hashes": map[string]interface{}{
+				"emptyConfig": "e2e-empty-config",
+				"inbounds": []map[string]interface{}{
+					{"tag": inboundTag, "hash": "e2e-" + inboundTag, "usersCount": 0},
+				},
+			},
+		},
+		"xrayConfig": map[string]interface{}{
+			"log": map[string]interface{}{"loglevel": "none"},
+			"inbounds": []map[string]interface{}{
+				{
+					"tag":      inboundTag,
+					"listen":   "127.0.0.1",
+					"port":     port,
+					"protocol": "vless",
+					"settings": map[string]interface{}{
+						"clients":    []interface{}{},
+						"decryption": "none",
+					},
+				},
+			},
+			"outbounds": []map[string]interface{}{
+				{"tag": "DIRECT", "protocol": "freedom"},
+				{"tag": "block", "protocol": "blackhole"},
+			},
+			"routing": map[string]interface{}{
+				"rules": []interface{}{},
+			},
+		},
+	}
+}
+
+// secrets are the generated panel/node credentials
+type secrets struct {
+	secretKey  string
+	caPool     *x509.CertPool
+	clientCert tls.Certificate
+	jwtKey     *rsa.PrivateKey
+}
+
+// generateSecrets creates a CA, a node certificate for 127.0.0.1, a client
+// certificate and a JWT key pair, and encodes them as a SECRET_KEY
+func generateSecrets() (*secrets, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "e2e CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeCertPEM, nodeKeyPEM, err := issueCert(caCert, caKey, 2, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	clientCertPEM, clientKeyPEM, err := issueCert(caCert, caKey, 3, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	jwtPublicDER, err := x509.MarshalPKIXPublicKey(&jwtKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	payload, err := json.Marshal(&crypto.NodePayload{
+		CACertPem:    string(caPEM),
+		NodeCertPem:  string(nodeCertPEM),
+		NodeKeyPem:   string(nodeKeyPEM),
+		JWTPublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: jwtPublicDER})),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	return &secrets{
+		secretKey:  base64.StdEncoding.EncodeToString(payload),
+		caPool:     caPool,
+		clientCert: clientCert,
+		jwtKey:     jwtKey,
+	}, nil
+}
+
+// issueCert creates a certificate for 127.0.0.1 signed by the CA
+func issueCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
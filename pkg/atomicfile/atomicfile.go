@@ -0,0 +1,108 @@
+// Package atomicfile provides crash-safe file writes
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BackupSuffix is appended to the path of the previous file version
+const BackupSuffix = ".bak"
+
+// WriteFile writes data to a temp file in the same directory, fsyncs it and
+// atomically renames it over path. A crash at any point leaves either the old
+// or the new content in place, never a partial file.
+// If keepBackup is set, the previous content is preserved in path + BackupSuffix.
+func WriteFile(path string, data []byte, perm os.FileMode, keepBackup bool) error {
+	dir := filepath.Dir(path)
+
+	if keepBackup {
+		old, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read previous file: %w", err)
+		}
+		if err == nil {
+			if err := writeAndRename(dir, path+BackupSuffix, old, perm); err != nil {
+				return fmt.Errorf("failed to write backup: %w", err)
+			}
+		}
+	}
+
+	if err := writeAndRename(dir, path, data, perm); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// ReadFileWithBackup reads path and falls back to the backup copy if the
+// primary file is missing or fails validation
+func ReadFileWithBackup(path string, valid func([]byte) bool) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && (valid == nil || valid(data)) {
+		return data, nil
+	}
+
+	backup, backupErr := os.ReadFile(path + BackupSuffix)
+	if backupErr == nil && (valid == nil || valid(backup)) {
+		return backup, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("file %s is corrupt and no valid backup exists", path)
+}
+
+// writeAndRename writes data to a temp file in dir, fsyncs and renames it to path
+func writeAndRename(dir, path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	// Remove temp file on any failure
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	success = true
+	return nil
+}
+
+// syncDir fsyncs a directory so the rename itself is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Some platforms (e.g. Windows) don't support syncing directories
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,90 @@
+package atomicfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile_CreatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := WriteFile(path, []byte(`{"a":1}`), 0600, true); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != `{"a":1}` {
+		t.Errorf("Unexpected content: %s", data)
+	}
+
+	// No backup for the first write
+	if _, err := os.Stat(path + BackupSuffix); !os.IsNotExist(err) {
+		t.Error("Expected no backup after first write")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestWriteFile_KeepsBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := WriteFile(path, []byte("v1"), 0644, true); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := WriteFile(path, []byte("v2"), 0644, true); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	backup, err := os.ReadFile(path + BackupSuffix)
+	if err != nil {
+		t.Fatalf("Expected backup file: %v", err)
+	}
+	if string(backup) != "v1" {
+		t.Errorf("Expected backup v1, got %s", backup)
+	}
+
+	// No temp files left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(entries))
+	}
+}
+
+func TestReadFileWithBackup_FallsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := WriteFile(path, []byte(`{"ok":true}`), 0644, true); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := WriteFile(path, []byte(`{"ok":`), 0644, true); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	data, err := ReadFileWithBackup(path, json.Valid)
+	if err != nil {
+		t.Fatalf("ReadFileWithBackup failed: %v", err)
+	}
+	if string(data) != `{"ok":true}` {
+		t.Errorf("Expected backup content, got %s", data)
+	}
+}
+
+func TestReadFileWithBackup_Missing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+
+	_, err := ReadFileWithBackup(path, nil)
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+// Package client is a Go client for the node API, for panels and tools that
+// manage nodes. It sets up mTLS and JWT authentication, retries requests the
+// node refused under load and decodes responses into the node's own request
+// and response types.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Defaults for Config fields left empty
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultRetries   = 2
+	DefaultRetryWait = 500 * time.Millisecond
+)
+
+// TokenSource returns the JWT sent with a request
+type TokenSource func(ctx context.Context) (string, error)
+
+// Config holds client configuration
+type Config struct {
+	URL string // Base URL of the node, e.g. https://node.example.com:3000
+
+	// mTLS; the system roots verify the node when CACertPEM is empty
+	CACertPEM     string
+	ClientCertPEM string
+	ClientKeyPEM  string
+	TLSConfig     *tls.Config // Optional; replaces the PEM settings above
+
+	// JWT authentication; TokenSource takes precedence over Token
+	Token       string
+	TokenSource TokenSource
+
+	Timeout   time.Duration // Per attempt; DefaultTimeout when zero
+	Retries   int           // Extra attempts after a retryable failure; DefaultRetries when zero, none when negative
+	RetryWait time.Duration // Wait before the first retry, doubled for each one after; DefaultRetryWait when zero
+
+	HTTPClient *http.Client // Optional; replaces the client built from the TLS settings
+}
+
+// Client calls the API of one node. It is safe for concurrent use.
+type Client struct {
+	baseURL   string
+	http      *http.Client
+	token     TokenSource
+	retries   int
+	retryWait time.Duration
+}
+
+// New creates a new Client
+func New(cfg *Config) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("URL is required")
+	}
+	base, err := url.Parse(cfg.URL)
+	if err != nil || base.Host == "" || (base.Scheme != "https" && base.Scheme != "http") {
+		return nil, fmt.Errorf("invalid URL %q", cfg.URL)
+	}
+
+	c := &Client{
+		baseURL:   strings.TrimRight(cfg.URL, "/"),
+		token:     cfg.TokenSource,
+		retries:   cfg.Retries,
+		retryWait: cfg.RetryWait,
+		http:      cfg.HTTPClient,
+	}
+	if c.token == nil && cfg.Token != "" {
+		token := cfg.Token
+		c.token = func(context.Context) (string, error) { return token, nil }
+	}
+	if c.retries == 0 {
+		c.retries = DefaultRetries
+	}
+	if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.retryWait <= 0 {
+		c.retryWait = DefaultRetryWait
+	}
+
+	if c.http == nil {
+		tlsConfig, err := buildTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		c.http = &http.Client{Transport: transport, Timeout: timeout}
+	}
+	return c, nil
+}
+
+// buildTLSConfig creates the mTLS configuration from the PEM settings
+func buildTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.TLSConfig != nil {
+		return cfg.TLSConfig.Clone(), nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACertPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.CACertPEM)) {
+			return nil, errors.New("failed to parse CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCertPEM != "" || cfg.ClientKeyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(cfg.ClientCertPEM), []byte(cfg.ClientKeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// SignedTokens returns a TokenSource signing tokens with the panel's JWT
+// private key. A token is reused until less than a quarter of ttl is left.
+func SignedTokens(key *rsa.PrivateKey, ttl time.Duration) TokenSource {
+	var (
+		mu      sync.Mutex
+		token   string
+		expires time.Time
+	)
+	return func(context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		if token != "" && now.Before(expires.Add(-ttl/4)) {
+			return token, nil
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iat": now.Unix(),
+			"exp": now.Add(ttl).Unix(),
+		}).SignedString(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign token: %w", err)
+		}
+		token, expires = signed, now.Add(ttl)
+		return token, nil
+	}
+}
+
+// APIError is a response other than 200 OK
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string // The error reported by the node, or the raw body
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsStatus reports whether err is an APIError with the given status code
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// retryable reports whether a status means the node refused the request
+// before handling it, so it can be sent again
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// Do sends a request with a JSON body (nil for none) and returns the raw body
+// of a 200 OK response. Requests the node refused under load (429, 503) are
+// retried; GET requests are also retried after network errors.
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body interface{}) ([]byte, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		data, retryAfter, err := c.send(ctx, method, path, target, payload)
+		if err == nil || attempt >= c.retries {
+			return data, err
+		}
+
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &apiErr):
+			if !retryable(apiErr.StatusCode) {
+				return nil, err
+			}
+		case method != http.MethodGet || ctx.Err() != nil:
+			return nil, err
+		}
+
+		delay := wait
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		wait *= 2
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes one attempt, returning the Retry-After delay of a refused request
+func (c *Client) send(ctx context.Context, method, path, target string, payload []byte) ([]byte, time.Duration, error) {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return data, 0, nil
+	}
+
+	apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		apiErr.Message = body.Error
+	}
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return nil, retryAfter, apiErr
+}
+
+// call sends a request and decodes the `response` field of the body into out
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	data, err := c.Do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		Response json.RawMessage `json:"response"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", path, err)
+	}
+	if err := json.Unmarshal(envelope.Response, out); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_RetriesRefusedRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"busy"}`))
+			return
+		}
+		w.Write([]byte(`{"response":{"isRunning":true}}`))
+	}))
+	defer server.Close()
+
+	c, err := New(&Config{URL: server.URL, Token: "token", RetryWait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Status(context.Background())
+	if err != nil || !status.IsRunning || calls.Load() != 3 {
+		t.Errorf("Expected success on the third attempt, got %+v (%v) after %d calls", status, err, calls.Load())
+	}
+
+	// Out of retries, the node's error is returned
+	calls.Store(-10)
+	_, err = c.Status(context.Background())
+	if !IsStatus(err, http.StatusServiceUnavailable) || err.(*APIError).Message != "busy" {
+		t.Errorf("Expected the 503 with the node's message, got %v", err)
+	}
+}
+
+func TestClient_DoesNotRetryOtherErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Xray not running"}`))
+	}))
+	defer server.Close()
+
+	c, err := New(&Config{URL: server.URL, RetryWait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.BlockIP(context.Background(), nil)
+	if !IsStatus(err, http.StatusInternalServerError) || calls.Load() != 1 {
+		t.Errorf("Expected a single attempt returning the 500, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestNew_RejectsInvalidConfig(t *testing.T) {
+	for _, cfg := range []*Config{
+		{},
+		{URL: "node:3000"},
+		{URL: "https://node:3000", CACertPEM: "not a certificate"},
+		{URL: "https://node:3000", ClientCertPEM: "not a certificate"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
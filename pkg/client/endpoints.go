@@ -0,0 +1,756 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/clash-version/remnawave-node-go/internal/middleware"
+	"github.com/clash-version/remnawave-node-go/internal/services"
+	"github.com/clash-version/remnawave-node-go/pkg/featureflags"
+	"github.com/clash-version/remnawave-node-go/pkg/logger"
+)
+
+// === Xray ===
+
+// Start pushes a config and (re)starts the core when needed
+func (c *Client) Start(ctx context.Context, req *services.StartRequest) (*services.StartResponseData, error) {
+	var resp services.StartResponseData
+	if err := c.call(ctx, http.MethodPost, "/node/xray/start", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Stop stops the core
+func (c *Client) Stop(ctx context.Context) (*services.StopResponse, error) {
+	var resp services.StopResponse
+	if err := c.call(ctx, http.MethodGet, "/node/xray/stop", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Status returns whether the core is running and its version
+func (c *Client) Status(ctx context.Context) (*services.GetStatusResponse, error) {
+	var resp services.GetStatusResponse
+	if err := c.call(ctx, http.MethodGet, "/node/xray/status", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// HealthCheck returns the node's health
+func (c *Client) HealthCheck(ctx context.Context) (*services.NodeHealthCheckResponseData, error) {
+	var resp services.NodeHealthCheckResponseData
+	if err := c.call(ctx, http.MethodGet, "/node/xray/healthcheck", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Inbounds lists the inbounds of the running config
+func (c *Client) Inbounds(ctx context.Context) (*services.GetInboundsResponse, error) {
+	var resp services.GetInboundsResponse
+	if err := c.call(ctx, http.MethodGet, "/node/xray/get-inbounds", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Outbounds lists the outbounds of the running config
+func (c *Client) Outbounds(ctx context.Context) (*services.GetOutboundsResponse, error) {
+	var resp services.GetOutboundsResponse
+	if err := c.call(ctx, http.MethodGet, "/node/xray/get-outbounds", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UserLinks returns share links of a user
+func (c *Client) UserLinks(ctx context.Context, req *services.GetUserLinksRequest) (*services.GetUserLinksResponse, error) {
+	var resp services.GetUserLinksResponse
+	if err := c.call(ctx, http.MethodPost, "/node/xray/get-user-links", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SelfTest probes the node's inbounds
+func (c *Client) SelfTest(ctx context.Context) (*services.SelfTestResponse, error) {
+	var resp services.SelfTestResponse
+	if err := c.call(ctx, http.MethodGet, "/node/xray/self-test", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WhyRestart explains whether pushing a config with these hashes would restart the core
+func (c *Client) WhyRestart(ctx context.Context, hashes *services.InboundHashes) (*services.RestartDecision, error) {
+	var resp services.RestartDecision
+	if err := c.call(ctx, http.MethodPost, "/node/xray/why-restart", nil, hashes, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Restart restarts the core with the config it last started with, keeping
+// the users added and removed since
+func (c *Client) Restart(ctx context.Context) (*services.RestartResponse, error) {
+	var resp services.RestartResponse
+	if err := c.call(ctx, http.MethodPost, "/node/xray/restart", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RestartHistory lists the latest core restarts
+func (c *Client) RestartHistory(ctx context.Context) ([]services.RestartRecord, error) {
+	var resp struct {
+		Restarts []services.RestartRecord `json:"restarts"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/node/xray/restart-history", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Restarts, nil
+}
+
+// === Stats ===
+
+// UserOnlineStatus returns whether a user is online
+func (c *Client) UserOnlineStatus(ctx context.Context, username string) (*services.GetUserOnlineStatusResponse, error) {
+	var resp services.GetUserOnlineStatusResponse
+	body := map[string]string{"username": username}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-user-online-status", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UsersStats returns the traffic of all users, resetting the counters if reset is set
+func (c *Client) UsersStats(ctx context.Context, reset bool) (*services.GetAllUsersStatsResponse, error) {
+	var resp services.GetAllUsersStatsResponse
+	body := &services.GetAllUsersStatsRequest{Reset: reset}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-users-stats", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UsersStatsAndReset returns and resets the traffic of the given users
+func (c *Client) UsersStatsAndReset(ctx context.Context, emails []string) (*services.GetUsersStatsAndResetResponse, error) {
+	var resp services.GetUsersStatsAndResetResponse
+	body := &services.GetUsersStatsAndResetRequest{Emails: emails}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-users-stats-and-reset", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SystemStats returns the core's runtime stats
+func (c *Client) SystemStats(ctx context.Context) (*services.SystemStatsResponse, error) {
+	var resp services.SystemStatsResponse
+	if err := c.call(ctx, http.MethodGet, "/node/stats/get-system-stats", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// InboundStats returns the traffic of one inbound
+func (c *Client) InboundStats(ctx context.Context, tag string, reset bool) (*services.GetInboundStatsResponse, error) {
+	var resp services.GetInboundStatsResponse
+	body := &services.GetInboundStatsRequest{Tag: tag, Reset: reset}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-inbound-stats", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OutboundStats returns the traffic of one outbound
+func (c *Client) OutboundStats(ctx context.Context, tag string, reset bool) (*services.GetOutboundStatsResponse, error) {
+	var resp services.GetOutboundStatsResponse
+	body := &services.GetOutboundStatsRequest{Tag: tag, Reset: reset}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-outbound-stats", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AllInboundsStats returns the traffic of all inbounds
+func (c *Client) AllInboundsStats(ctx context.Context, reset bool) (*services.GetAllInboundsStatsResponse, error) {
+	var resp services.GetAllInboundsStatsResponse
+	body := &services.GetAllInboundsStatsRequest{Reset: reset}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-all-inbounds-stats", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AllOutboundsStats returns the traffic of all outbounds
+func (c *Client) AllOutboundsStats(ctx context.Context, reset bool) (*services.GetAllOutboundsStatsResponse, error) {
+	var resp services.GetAllOutboundsStatsResponse
+	body := &services.GetAllOutboundsStatsRequest{Reset: reset}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-all-outbounds-stats", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CombinedStats returns the traffic of all inbounds and outbounds
+func (c *Client) CombinedStats(ctx context.Context, reset bool) (*services.GetCombinedStatsResponse, error) {
+	var resp services.GetCombinedStatsResponse
+	body := &services.GetCombinedStatsRequest{Reset: reset}
+	if err := c.call(ctx, http.MethodPost, "/node/stats/get-combined-stats", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BeginCollection starts a two-phase stats collection
+func (c *Client) BeginCollection(ctx context.Context) (*services.BeginCollectionResponse, error) {
+	var resp services.BeginCollectionResponse
+	if err := c.call(ctx, http.MethodPost, "/node/stats/begin-collection", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CommitCollection confirms a collection, so its traffic is not reported again
+func (c *Client) CommitCollection(ctx context.Context, req *services.CommitCollectionRequest) (*services.CommitCollectionResponse, error) {
+	var resp services.CommitCollectionResponse
+	if err := c.call(ctx, http.MethodPost, "/node/stats/commit-collection", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UsernameAliases returns the username aliases used in stats
+func (c *Client) UsernameAliases(ctx context.Context) (*services.UsernameAliasesResponse, error) {
+	var resp services.UsernameAliasesResponse
+	if err := c.call(ctx, http.MethodGet, "/node/stats/username-aliases", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetUsernameAliases replaces the username aliases used in stats
+func (c *Client) SetUsernameAliases(ctx context.Context, req *services.SetUsernameAliasesRequest) (*services.UsernameAliasesResponse, error) {
+	var resp services.UsernameAliasesResponse
+	if err := c.call(ctx, http.MethodPost, "/node/stats/username-aliases", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UsersStatsCSV returns the traffic of users with traffic as CSV
+func (c *Client) UsersStatsCSV(ctx context.Context, reset bool) ([]byte, error) {
+	return c.Do(ctx, http.MethodPost, "/node/stats/get-users-stats", url.Values{"format": {"csv"}}, map[string]bool{"reset": reset})
+}
+
+// StartExport starts a bulk stats export (services.ExportUsers or services.ExportInbounds)
+func (c *Client) StartExport(ctx context.Context, kind string) (*services.ExportJob, error) {
+	var resp services.ExportJob
+	if err := c.call(ctx, http.MethodPost, "/node/stats/exports", nil, map[string]string{"kind": kind}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Export returns the state of a bulk stats export
+func (c *Client) Export(ctx context.Context, id string) (*services.ExportJob, error) {
+	var resp services.ExportJob
+	if err := c.call(ctx, http.MethodGet, "/node/stats/exports/"+url.PathEscape(id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DownloadExport returns the CSV file of a finished export
+func (c *Client) DownloadExport(ctx context.Context, id string) ([]byte, error) {
+	return c.Do(ctx, http.MethodGet, "/node/stats/exports/"+url.PathEscape(id)+"/download", nil, nil)
+}
+
+// === Handler ===
+
+// AddUser adds a user to one or more inbounds
+func (c *Client) AddUser(ctx context.Context, req *services.AddUserRequest) (*services.AddUserResponse, error) {
+	var resp services.AddUserResponse
+	if err := c.call(ctx, http.MethodPost, "/node/handler/add-user", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddUsers adds a batch of users
+func (c *Client) AddUsers(ctx context.Context, req *services.AddUsersRequest) (*services.AddUsersResponse, error) {
+	var resp services.AddUsersResponse
+	if err := c.call(ctx, http.MethodPost, "/node/handler/add-users", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RemoveUser removes a user from all inbounds
+func (c *Client) RemoveUser(ctx context.Context, req *services.RemoveUserRequest) (*services.RemoveUserResponse, error) {
+	var resp services.RemoveUserResponse
+	if err := c.call(ctx, http.MethodPost, "/node/handler/remove-user", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RemoveUsers removes a batch of users
+func (c *Client) RemoveUsers(ctx context.Context, req *services.RemoveUsersRequest) (*services.RemoveUsersResponse, error) {
+	var resp services.RemoveUsersResponse
+	if err := c.call(ctx, http.MethodPost, "/node/handler/remove-users", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// InboundUsersCount returns how many users an inbound has
+func (c *Client) InboundUsersCount(ctx context.Context, tag string) (*services.GetInboundUsersCountResponse, error) {
+	var resp services.GetInboundUsersCountResponse
+	body := map[string]string{"tag": tag}
+	if err := c.call(ctx, http.MethodPost, "/node/handler/get-inbound-users-count", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// InboundUsers lists the users of an inbound
+func (c *Client) InboundUsers(ctx context.Context, req *services.GetInboundUsersRequest) (*services.GetInboundUsersResponse, error) {
+	var resp services.GetInboundUsersResponse
+	if err := c.call(ctx, http.MethodPost, "/node/handler/get-inbound-users", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ResyncFromConfig brings the core's users in line with the stored config
+func (c *Client) ResyncFromConfig(ctx context.Context, dryRun bool) (*services.ResyncFromConfigResponse, error) {
+	var resp services.ResyncFromConfigResponse
+	body := &services.ResyncFromConfigRequest{DryRun: dryRun}
+	if err := c.call(ctx, http.MethodPost, "/node/handler/resync-from-config", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FindUser finds the inbounds a user is in
+func (c *Client) FindUser(ctx context.Context, req *services.FindUserRequest) (*services.FindUserResponse, error) {
+	var resp services.FindUserResponse
+	if err := c.call(ctx, http.MethodPost, "/node/handler/find-user", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === Vision ===
+
+// BlockIP blocks an IP address
+func (c *Client) BlockIP(ctx context.Context, req *services.BlockIPRequest) (*services.BlockIPResponse, error) {
+	var resp services.BlockIPResponse
+	if err := c.call(ctx, http.MethodPost, "/node/vision/block-ip", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UnblockIP unblocks an IP address
+func (c *Client) UnblockIP(ctx context.Context, req *services.UnblockIPRequest) (*services.UnblockIPResponse, error) {
+	var resp services.UnblockIPResponse
+	if err := c.call(ctx, http.MethodPost, "/node/vision/unblock-ip", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BlockedIPs lists the blocked IPs
+func (c *Client) BlockedIPs(ctx context.Context) (*services.GetBlockedIPsResponse, error) {
+	var resp services.GetBlockedIPsResponse
+	if err := c.call(ctx, http.MethodGet, "/node/vision/blocked-ips", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === WireGuard ===
+
+// GenerateWireGuardKeys generates a WireGuard key pair
+func (c *Client) GenerateWireGuardKeys(ctx context.Context) (*services.GenerateKeysResponse, error) {
+	var resp services.GenerateKeysResponse
+	if err := c.call(ctx, http.MethodPost, "/node/wireguard/generate-keys", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WireGuardPeers lists the WireGuard peers
+func (c *Client) WireGuardPeers(ctx context.Context) (*services.GetPeersResponse, error) {
+	var resp services.GetPeersResponse
+	if err := c.call(ctx, http.MethodGet, "/node/wireguard/get-peers", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === Sidecars ===
+
+// StartSidecar starts a sidecar core and returns the status of all sidecars
+func (c *Client) StartSidecar(ctx context.Context, name string) (*services.GetSidecarsStatusResponse, error) {
+	var resp services.GetSidecarsStatusResponse
+	body := &services.SidecarRequest{Name: name}
+	if err := c.call(ctx, http.MethodPost, "/node/sidecar/start", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StopSidecar stops a sidecar core and returns the status of all sidecars
+func (c *Client) StopSidecar(ctx context.Context, name string) (*services.GetSidecarsStatusResponse, error) {
+	var resp services.GetSidecarsStatusResponse
+	body := &services.SidecarRequest{Name: name}
+	if err := c.call(ctx, http.MethodPost, "/node/sidecar/stop", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Sidecars returns the status of the sidecar cores
+func (c *Client) Sidecars(ctx context.Context) (*services.GetSidecarsStatusResponse, error) {
+	var resp services.GetSidecarsStatusResponse
+	if err := c.call(ctx, http.MethodGet, "/node/sidecar/status", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === Routing ===
+
+// PinUser pins a user's traffic to an outbound
+func (c *Client) PinUser(ctx context.Context, req *services.PinUserRequest) (*services.PinUserResponse, error) {
+	var resp services.PinUserResponse
+	if err := c.call(ctx, http.MethodPost, "/node/routing/pin-user", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UnpinUser removes a user's pin
+func (c *Client) UnpinUser(ctx context.Context, req *services.UnpinUserRequest) (*services.PinUserResponse, error) {
+	var resp services.PinUserResponse
+	if err := c.call(ctx, http.MethodPost, "/node/routing/unpin-user", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PinnedUsers lists the pinned users
+func (c *Client) PinnedUsers(ctx context.Context) (*services.GetPinnedUsersResponse, error) {
+	var resp services.GetPinnedUsersResponse
+	if err := c.call(ctx, http.MethodGet, "/node/routing/get-pinned-users", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RoutingRules lists the router rules of the running core
+func (c *Client) RoutingRules(ctx context.Context) (*services.GetRoutingRulesResponse, error) {
+	var resp services.GetRoutingRulesResponse
+	if err := c.call(ctx, http.MethodGet, "/node/routing/rules", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === WARP ===
+
+// EnableWarp enables the WARP outbound
+func (c *Client) EnableWarp(ctx context.Context, req *services.EnableWarpRequest) (*services.WarpStatusResponse, error) {
+	var resp services.WarpStatusResponse
+	if err := c.call(ctx, http.MethodPost, "/node/warp/enable", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DisableWarp disables the WARP outbound
+func (c *Client) DisableWarp(ctx context.Context) (*services.WarpStatusResponse, error) {
+	var resp services.WarpStatusResponse
+	if err := c.call(ctx, http.MethodGet, "/node/warp/disable", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WarpStatus returns the WARP status
+func (c *Client) WarpStatus(ctx context.Context) (*services.WarpStatusResponse, error) {
+	var resp services.WarpStatusResponse
+	if err := c.call(ctx, http.MethodGet, "/node/warp/status", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === Certificates ===
+
+// Certs lists the stored certificates
+func (c *Client) Certs(ctx context.Context) (*services.ListCertsResponse, error) {
+	var resp services.ListCertsResponse
+	if err := c.call(ctx, http.MethodGet, "/node/certs/list", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UploadCert stores a certificate
+func (c *Client) UploadCert(ctx context.Context, req *services.UploadCertRequest) (*services.UploadCertResponse, error) {
+	var resp services.UploadCertResponse
+	if err := c.call(ctx, http.MethodPost, "/node/certs/upload", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteCert deletes a stored certificate
+func (c *Client) DeleteCert(ctx context.Context, req *services.DeleteCertRequest) error {
+	var resp struct {
+		Success bool `json:"success"`
+	}
+	return c.call(ctx, http.MethodPost, "/node/certs/delete", nil, req, &resp)
+}
+
+// AcmeStatus returns the ACME certificate status
+func (c *Client) AcmeStatus(ctx context.Context) (*services.AcmeStatusResponse, error) {
+	var resp services.AcmeStatusResponse
+	if err := c.call(ctx, http.MethodGet, "/node/certs/acme", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RenewAcme renews the ACME certificate when due, or always with force
+func (c *Client) RenewAcme(ctx context.Context, force bool) (*services.AcmeStatusResponse, error) {
+	var resp services.AcmeStatusResponse
+	body := &services.AcmeRenewRequest{Force: force}
+	if err := c.call(ctx, http.MethodPost, "/node/certs/acme/renew", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// === Internal ===
+
+// Config returns the stored core config
+func (c *Client) Config(ctx context.Context) (*services.GetConfigResponse, error) {
+	data, err := c.Do(ctx, http.MethodGet, "/node/internal/get-config", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp services.GetConfigResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response of /node/internal/get-config: %w", err)
+	}
+	return &resp, nil
+}
+
+// CompatReport returns how many legacy requests the node received
+func (c *Client) CompatReport(ctx context.Context) (*services.CompatReportResponse, error) {
+	var resp services.CompatReportResponse
+	if err := c.call(ctx, http.MethodGet, "/node/internal/compat-report", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RecentLogs returns the latest log entries at or above level (empty for
+// all), up to limit (0 for the node's default)
+func (c *Client) RecentLogs(ctx context.Context, level string, limit int) ([]logger.Entry, error) {
+	query := url.Values{}
+	if level != "" {
+		query.Set("level", level)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var resp struct {
+		Logs []logger.Entry `json:"logs"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/node/internal/recent-logs", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Logs, nil
+}
+
+// ExportState returns the node's state for migration to another node
+func (c *Client) ExportState(ctx context.Context) (*services.NodeState, error) {
+	var resp services.NodeState
+	if err := c.call(ctx, http.MethodGet, "/node/internal/export-state", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ImportState applies a state exported from another node
+func (c *Client) ImportState(ctx context.Context, req *services.ImportStateRequest) (*services.ImportStateResponse, error) {
+	var resp services.ImportStateResponse
+	if err := c.call(ctx, http.MethodPost, "/node/internal/import-state", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Tuning returns the host tuning report
+func (c *Client) Tuning(ctx context.Context) (*services.TuningReport, error) {
+	var resp services.TuningReport
+	if err := c.call(ctx, http.MethodGet, "/node/internal/tuning", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ApplyTuning applies recommended host tuning
+func (c *Client) ApplyTuning(ctx context.Context, req *services.ApplyTuningRequest) (*services.TuningReport, error) {
+	var resp services.TuningReport
+	if err := c.call(ctx, http.MethodPost, "/node/internal/tuning", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// APIStats returns request counts and latencies per route
+func (c *Client) APIStats(ctx context.Context) (*middleware.APIStatsSummary, error) {
+	var resp middleware.APIStatsSummary
+	if err := c.call(ctx, http.MethodGet, "/node/internal/api-stats", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Metrics returns the API metrics in the Prometheus text format
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	data, err := c.Do(ctx, http.MethodGet, "/node/internal/metrics", nil, nil)
+	return string(data), err
+}
+
+// BuildInfo returns the node's version and build details
+func (c *Client) BuildInfo(ctx context.Context) (*services.BuildInfo, error) {
+	var resp services.BuildInfo
+	if err := c.call(ctx, http.MethodGet, "/node/internal/build-info", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Facts returns the machine-readable node description
+func (c *Client) Facts(ctx context.Context) (*services.Facts, error) {
+	var resp services.Facts
+	if err := c.call(ctx, http.MethodGet, "/node/internal/facts", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FeatureFlags lists the feature flags
+func (c *Client) FeatureFlags(ctx context.Context) ([]featureflags.State, error) {
+	var resp []featureflags.State
+	if err := c.call(ctx, http.MethodGet, "/node/internal/feature-flags", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SetFeatureFlag toggles a runtime feature flag
+func (c *Client) SetFeatureFlag(ctx context.Context, name string, enabled bool) (*featureflags.State, error) {
+	var resp featureflags.State
+	body := map[string]interface{}{"name": name, "enabled": enabled}
+	if err := c.call(ctx, http.MethodPost, "/node/internal/feature-flags", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RecordedRequests is the request recorder's state
+type RecordedRequests struct {
+	Enabled    bool                      `json:"enabled"`
+	Recordings []*services.RecordingInfo `json:"recordings"`
+}
+
+// RecordedRequests lists the recorded requests
+func (c *Client) RecordedRequests(ctx context.Context) (*RecordedRequests, error) {
+	var resp RecordedRequests
+	if err := c.call(ctx, http.MethodGet, "/node/internal/recorded-requests", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// TrafficBudget returns the traffic budget status
+func (c *Client) TrafficBudget(ctx context.Context) (*services.BudgetStatus, error) {
+	var resp services.BudgetStatus
+	if err := c.call(ctx, http.MethodGet, "/node/internal/traffic-budget", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// MutationJournal returns the journaled user mutations
+func (c *Client) MutationJournal(ctx context.Context) (*services.MutationJournal, error) {
+	var resp services.MutationJournal
+	if err := c.call(ctx, http.MethodGet, "/node/internal/mutation-journal", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Sessions lists kernel conntrack sessions per inbound
+func (c *Client) Sessions(ctx context.Context, req *services.GetSessionsRequest) (*services.GetSessionsResponse, error) {
+	var resp services.GetSessionsResponse
+	if err := c.call(ctx, http.MethodGet, "/node/internal/sessions", tagLimitQuery(req.Tag, req.Limit), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// EbpfAccounting returns the kernel-counted traffic per inbound
+func (c *Client) EbpfAccounting(ctx context.Context, req *services.GetAccountingRequest) (*services.GetAccountingResponse, error) {
+	var resp services.GetAccountingResponse
+	if err := c.call(ctx, http.MethodGet, "/node/internal/ebpf-accounting", tagLimitQuery(req.Tag, req.Limit), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Hooks lists the operator hooks and their latest runs
+func (c *Client) Hooks(ctx context.Context) (*services.HooksResponse, error) {
+	var resp services.HooksResponse
+	if err := c.call(ctx, http.MethodGet, "/node/internal/hooks", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Policies lists the mutation policies with their counters
+func (c *Client) Policies(ctx context.Context) ([]*services.PolicyInfo, error) {
+	var resp []*services.PolicyInfo
+	if err := c.call(ctx, http.MethodGet, "/node/internal/policies", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// tagLimitQuery encodes the tag and limit filters of the per-inbound listings
+func tagLimitQuery(tag string, limit int) url.Values {
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query
+}
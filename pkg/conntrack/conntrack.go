@@ -0,0 +1,160 @@
+// Package conntrack reads the kernel connection tracking table from
+// /proc/net/nf_conntrack (Linux only)
+package conntrack
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of the connection tracking table, newest kernels first
+var tablePaths = []string{"/proc/net/nf_conntrack", "/proc/net/ip_conntrack"}
+
+// ErrUnavailable is returned when the kernel exposes no conntrack table, e.g.
+// outside Linux, without the nf_conntrack module, or in a network namespace
+// that does not track connections
+var ErrUnavailable = errors.New("conntrack table not available")
+
+// Direction is one side of a tracked connection as the kernel saw it
+type Direction struct {
+	Src     netip.Addr
+	Dst     netip.Addr
+	SrcPort uint16
+	DstPort uint16
+	Packets uint64
+	Bytes   uint64
+}
+
+// Entry is a tracked connection. Orig is the direction of the first packet
+// (client to server for inbound connections), Reply the way back.
+type Entry struct {
+	Protocol string        // tcp, udp, ...
+	State    string        // TCP state, empty for stateless protocols
+	Timeout  time.Duration // Until the entry expires without traffic
+	Orig     Direction
+	Reply    Direction
+
+	// Packet and byte counters are only kept with net.netfilter.nf_conntrack_acct=1
+	Counted bool
+
+	// Age is only known with net.netfilter.nf_conntrack_timestamp=1
+	Age      time.Duration
+	AgeKnown bool
+}
+
+// Read returns the entries of the kernel's conntrack table
+func Read() ([]*Entry, error) {
+	for _, path := range tablePaths {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		defer f.Close()
+		return Parse(f)
+	}
+	return nil, ErrUnavailable
+}
+
+// Parse reads entries in the /proc/net/nf_conntrack format:
+//
+//	ipv4 2 tcp 6 431999 ESTABLISHED src=... dst=... sport=... dport=... packets=... bytes=... src=... ... [ASSURED] mark=0 use=1
+//
+// The first src/dst/sport/dport/packets/bytes group is the original
+// direction, the second the reply. Lines that cannot be parsed are skipped.
+func Parse(r io.Reader) ([]*Entry, error) {
+	var entries []*Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	for scanner.Scan() {
+		if entry, err := parseLine(scanner.Text()); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseLine parses one table line
+func parseLine(line string) (*Entry, error) {
+	fields := strings.Fields(line)
+	// ip_conntrack lines have no leading family fields
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "ipv") {
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("short line")
+		}
+		fields = fields[2:]
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("short line")
+	}
+
+	entry := &Entry{Protocol: fields[0]}
+	timeout, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout %q", fields[2])
+	}
+	entry.Timeout = time.Duration(timeout) * time.Second
+
+	dir := &entry.Orig
+	seenSrc := false
+	for _, field := range fields[3:] {
+		key, value, isPair := strings.Cut(field, "=")
+		if !isPair {
+			if !strings.HasPrefix(field, "[") && entry.State == "" && !seenSrc {
+				entry.State = field
+			}
+			continue
+		}
+
+		switch key {
+		case "src":
+			if seenSrc {
+				dir = &entry.Reply
+			}
+			seenSrc = true
+			dir.Src, err = netip.ParseAddr(value)
+		case "dst":
+			dir.Dst, err = netip.ParseAddr(value)
+		case "sport":
+			dir.SrcPort, err = parseUint16(value)
+		case "dport":
+			dir.DstPort, err = parseUint16(value)
+		case "packets":
+			dir.Packets, err = strconv.ParseUint(value, 10, 64)
+			entry.Counted = true
+		case "bytes":
+			dir.Bytes, err = strconv.ParseUint(value, 10, 64)
+			entry.Counted = true
+		case "delta-time":
+			var seconds uint64
+			seconds, err = strconv.ParseUint(value, 10, 64)
+			entry.Age = time.Duration(seconds) * time.Second
+			entry.AgeKnown = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", key, value)
+		}
+	}
+	if !entry.Orig.Src.IsValid() || !entry.Orig.Dst.IsValid() {
+		return nil, fmt.Errorf("no addresses")
+	}
+	return entry, nil
+}
+
+// parseUint16 parses a port number
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,43 @@
+package conntrack
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleTable = `ipv4     2 tcp      6 431999 ESTABLISHED src=198.51.100.7 dst=10.0.0.1 sport=51234 dport=443 packets=10 bytes=1234 src=10.0.0.1 dst=198.51.100.7 sport=443 dport=51234 packets=8 bytes=5678 [ASSURED] mark=0 delta-time=120 use=1
+ipv4     2 udp      17 29 src=198.51.100.8 dst=10.0.0.1 sport=40000 dport=8443 [UNREPLIED] src=10.0.0.1 dst=198.51.100.8 sport=8443 dport=40000 mark=0 use=1
+ipv6     10 tcp      6 60 SYN_SENT src=2001:db8::1 dst=2001:db8::2 sport=1 dport=2 src=2001:db8::2 dst=2001:db8::1 sport=2 dport=1 mark=0 use=1
+garbage line
+`
+
+func TestParse(t *testing.T) {
+	entries, err := Parse(strings.NewReader(sampleTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	tcp := entries[0]
+	if tcp.Protocol != "tcp" || tcp.State != "ESTABLISHED" || tcp.Timeout != 431999*time.Second {
+		t.Errorf("Unexpected tcp entry %+v", tcp)
+	}
+	if tcp.Orig.Src.String() != "198.51.100.7" || tcp.Orig.DstPort != 443 || tcp.Orig.Bytes != 1234 || tcp.Reply.Bytes != 5678 {
+		t.Errorf("Unexpected directions %+v %+v", tcp.Orig, tcp.Reply)
+	}
+	if !tcp.Counted || !tcp.AgeKnown || tcp.Age != 120*time.Second {
+		t.Errorf("Expected counters and age, got %+v", tcp)
+	}
+
+	udp := entries[1]
+	if udp.State != "" || udp.Counted || udp.AgeKnown || udp.Orig.DstPort != 8443 || udp.Reply.SrcPort != 8443 {
+		t.Errorf("Unexpected udp entry %+v", udp)
+	}
+
+	if entries[2].Orig.Src.String() != "2001:db8::1" || entries[2].State != "SYN_SENT" {
+		t.Errorf("Unexpected ipv6 entry %+v", entries[2])
+	}
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// atRestMagic prefixes data encrypted by EncryptAtRest so it can be told apart from plaintext
+var atRestMagic = []byte("RWNENC1\n")
+
+// DeriveAtRestKey derives a 256-bit AES key for on-disk encryption from the SECRET_KEY
+func DeriveAtRestKey(secretKey string) ([]byte, error) {
+	if secretKey == "" {
+		return nil, errors.New("SECRET_KEY is not set")
+	}
+	return hkdf.Key(sha256.New, []byte(secretKey), nil, "remnawave-node config at-rest", 32)
+}
+
+// IsEncryptedAtRest reports whether data was produced by EncryptAtRest
+func IsEncryptedAtRest(data []byte) bool {
+	return bytes.HasPrefix(data, atRestMagic)
+}
+
+// EncryptAtRest encrypts data with AES-256-GCM
+// Output format: magic | nonce | ciphertext+tag
+func EncryptAtRest(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(atRestMagic)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, atRestMagic...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, atRestMagic), nil
+}
+
+// DecryptAtRest decrypts data produced by EncryptAtRest.
+// Data without the at-rest prefix is returned unchanged, so plaintext files keep working.
+func DecryptAtRest(key, data []byte) ([]byte, error) {
+	if !IsEncryptedAtRest(data) {
+		return data, nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body := data[len(atRestMagic):]
+	if len(body) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	nonce, ciphertext := body[:gcm.NonceSize()], body[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, atRestMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data (wrong SECRET_KEY?): %w", err)
+	}
+	return plaintext, nil
+}
+
+// newGCM creates an AES-GCM AEAD from a 256-bit key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key length %d, expected 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
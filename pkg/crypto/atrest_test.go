@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAtRest_RoundTrip(t *testing.T) {
+	key, err := DeriveAtRestKey("test-secret")
+	if err != nil {
+		t.Fatalf("DeriveAtRestKey failed: %v", err)
+	}
+
+	plaintext := []byte(`{"inbounds":[{"settings":{"clients":[{"id":"uuid"}]}}]}`)
+	encrypted, err := EncryptAtRest(key, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptAtRest failed: %v", err)
+	}
+
+	if !IsEncryptedAtRest(encrypted) {
+		t.Error("Expected encrypted data to carry the at-rest prefix")
+	}
+	if bytes.Contains(encrypted, []byte("uuid")) {
+		t.Error("Encrypted data contains plaintext")
+	}
+
+	decrypted, err := DecryptAtRest(key, encrypted)
+	if err != nil {
+		t.Fatalf("DecryptAtRest failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Round trip mismatch: got %s", decrypted)
+	}
+}
+
+func TestAtRest_PlaintextPassthrough(t *testing.T) {
+	key, _ := DeriveAtRestKey("test-secret")
+
+	plaintext := []byte(`{"log":{}}`)
+	result, err := DecryptAtRest(key, plaintext)
+	if err != nil {
+		t.Fatalf("DecryptAtRest failed: %v", err)
+	}
+	if !bytes.Equal(result, plaintext) {
+		t.Error("Expected plaintext to be returned unchanged")
+	}
+}
+
+func TestAtRest_WrongKey(t *testing.T) {
+	key1, _ := DeriveAtRestKey("secret-1")
+	key2, _ := DeriveAtRestKey("secret-2")
+
+	encrypted, err := EncryptAtRest(key1, []byte("data"))
+	if err != nil {
+		t.Fatalf("EncryptAtRest failed: %v", err)
+	}
+
+	if _, err := DecryptAtRest(key2, encrypted); err == nil {
+		t.Error("Expected error when decrypting with wrong key")
+	}
+}
+
+func TestDeriveAtRestKey_Empty(t *testing.T) {
+	if _, err := DeriveAtRestKey(""); err == nil {
+		t.Error("Expected error for empty secret")
+	}
+}
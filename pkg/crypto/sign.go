@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// SignMessage returns the hex HMAC-SHA256 of a timestamped message
+func SignMessage(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyMessage checks a signature made by SignMessage and that the timestamp
+// (unix seconds) is within maxSkew of now, which bounds replays
+func VerifyMessage(secret []byte, timestamp, signature string, body []byte, maxSkew time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return errors.New("timestamp outside allowed window")
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	want, _ := hex.DecodeString(SignMessage(secret, ts, body))
+	if !hmac.Equal(got, want) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignMessage_Verify(t *testing.T) {
+	secret := []byte("fleet-secret")
+	body := []byte(`{"ips":["1.2.3.4"]}`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	sig := SignMessage(secret, now.Unix(), body)
+	if err := VerifyMessage(secret, ts, sig, body, time.Minute, now); err != nil {
+		t.Fatalf("Expected valid signature, got %v", err)
+	}
+
+	if err := VerifyMessage([]byte("other"), ts, sig, body, time.Minute, now); err == nil {
+		t.Error("Expected mismatch with a different secret")
+	}
+	if err := VerifyMessage(secret, ts, sig, []byte(`{"ips":[]}`), time.Minute, now); err == nil {
+		t.Error("Expected mismatch with a modified body")
+	}
+}
+
+func TestVerifyMessage_RejectsStaleTimestamp(t *testing.T) {
+	secret := []byte("fleet-secret")
+	body := []byte("x")
+	signedAt := time.Now().Add(-10 * time.Minute)
+
+	sig := SignMessage(secret, signedAt.Unix(), body)
+	err := VerifyMessage(secret, strconv.FormatInt(signedAt.Unix(), 10), sig, body, 5*time.Minute, time.Now())
+	if err == nil {
+		t.Error("Expected stale timestamp to be rejected")
+	}
+}
@@ -0,0 +1,381 @@
+// Package ebpfacct counts the bytes and packets to and from a set of local
+// ports with an eBPF socket filter (Linux only, experimental)
+//
+// The filter is attached to a packet socket that sees every packet the host
+// sends and receives on any interface. For each IPv4/IPv6 TCP or UDP packet
+// on a watched port it adds the IP length to a kernel map keyed by port,
+// protocol, direction and remote address, and then drops the packet copy, so
+// nothing is ever queued to user space. The maps are read on demand.
+package ebpfacct
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// ErrUnsupported is returned outside Linux
+var ErrUnsupported = errors.New("eBPF accounting is only supported on Linux")
+
+// MaxEntries is the capacity of the counter map. Packets of new
+// port/address pairs are not counted once it is full.
+const MaxEntries = 65536
+
+// Counter is the traffic of one remote address on one local port
+type Counter struct {
+	Port            uint16     `json:"port"`
+	Protocol        string     `json:"protocol"`
+	Addr            netip.Addr `json:"addr"`
+	Uplink          uint64     `json:"uplink"`   // Bytes received from the address
+	Downlink        uint64     `json:"downlink"` // Bytes sent to the address
+	UplinkPackets   uint64     `json:"uplinkPackets"`
+	DownlinkPackets uint64     `json:"downlinkPackets"`
+}
+
+// Map key layout, shared with the program:
+//
+//	0  u16 local port
+//	2  u8  IP protocol
+//	3  u8  direction (0 received, 1 sent)
+//	4  u32 padding
+//	8  4 x u32 remote address, each word in host order (IPv4 mapped to IPv6)
+//
+// Values are a u64 byte count followed by a u64 packet count.
+const (
+	keySize   = 24
+	valueSize = 16
+)
+
+// Instruction encoding (include/uapi/linux/bpf.h)
+const (
+	clsLD    = 0x00
+	clsLDX   = 0x01
+	clsST    = 0x02
+	clsSTX   = 0x03
+	clsJMP   = 0x05
+	clsALU64 = 0x07
+
+	sizeW  = 0x00
+	sizeH  = 0x08
+	sizeB  = 0x10
+	sizeDW = 0x18
+
+	modeIMM    = 0x00
+	modeABS    = 0x20
+	modeIND    = 0x40
+	modeMEM    = 0x60
+	modeATOMIC = 0xc0
+
+	srcK = 0x00
+	srcX = 0x08
+
+	aluADD = 0x00
+	aluAND = 0x50
+	aluLSH = 0x60
+	aluRSH = 0x70
+	aluMOV = 0xb0
+
+	jmpJA   = 0x00
+	jmpJEQ  = 0x10
+	jmpJNE  = 0x50
+	jmpCALL = 0x80
+	jmpEXIT = 0x90
+
+	pseudoMapFD = 1
+
+	fnMapLookupElem = 1
+	fnMapUpdateElem = 2
+
+	flagNoExist = 1
+
+	// Packet loads relative to the network header (SKF_NET_OFF)
+	netOff = -0x100000
+
+	// __sk_buff fields
+	skbLen     = 0
+	skbPktType = 4
+
+	packetOutgoing = 4
+
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// Registers. LD_ABS/LD_IND take the context from r6, clobber r1-r5 and
+// return in r0; calls clobber r1-r5.
+const (
+	r0 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7 // direction
+	r8 // packet length
+	r9 // header offset
+	r10
+)
+
+// insn is one instruction. A jump to a label is resolved by assemble.
+type insn struct {
+	code  uint8
+	dst   uint8
+	src   uint8
+	off   int16
+	imm   int32
+	label string // Set on the first instruction after a label
+	jump  string // Target label of a jump
+}
+
+func mov(dst uint8, imm int32) insn { return insn{code: clsALU64 | aluMOV | srcK, dst: dst, imm: imm} }
+func movReg(dst, src uint8) insn    { return insn{code: clsALU64 | aluMOV | srcX, dst: dst, src: src} }
+func alu(op, dst uint8, imm int32) insn {
+	return insn{code: clsALU64 | op | srcK, dst: dst, imm: imm}
+}
+func ldAbs(size uint8, off int32) insn { return insn{code: clsLD | modeABS | size, imm: netOff + off} }
+func ldInd(size, src uint8, off int32) insn {
+	return insn{code: clsLD | modeIND | size, src: src, imm: netOff + off}
+}
+func ldx(size, dst, src uint8, off int16) insn {
+	return insn{code: clsLDX | modeMEM | size, dst: dst, src: src, off: off}
+}
+func st(size uint8, off int16, imm int32) insn {
+	return insn{code: clsST | modeMEM | size, dst: r10, off: off, imm: imm}
+}
+func stx(size, dst, src uint8, off int16) insn {
+	return insn{code: clsSTX | modeMEM | size, dst: dst, src: src, off: off}
+}
+func xadd(dst, src uint8, off int16) insn {
+	return insn{code: clsSTX | modeATOMIC | sizeDW, dst: dst, src: src, off: off}
+}
+func jeq(dst uint8, imm int32, label string) insn {
+	return insn{code: clsJMP | jmpJEQ | srcK, dst: dst, imm: imm, jump: label}
+}
+func jne(dst uint8, imm int32, label string) insn {
+	return insn{code: clsJMP | jmpJNE | srcK, dst: dst, imm: imm, jump: label}
+}
+func ja(label string) insn         { return insn{code: clsJMP | jmpJA, jump: label} }
+func call(fn int32) insn           { return insn{code: clsJMP | jmpCALL, imm: fn} }
+func exit() insn                   { return insn{code: clsJMP | jmpEXIT} }
+func at(label string, i insn) insn { i.label = label; return i }
+
+// ldMap loads a map file descriptor; it takes two instruction slots
+func ldMap(dst uint8, fd int) []insn {
+	return []insn{{code: clsLD | modeIMM | sizeDW, dst: dst, src: pseudoMapFD, imm: int32(fd)}, {}}
+}
+
+// Stack slots relative to r10
+const (
+	stackKey     = -24 // keySize bytes
+	stackValue   = -40 // valueSize bytes
+	stackPortKey = -44 // u32 key into the port map
+)
+
+// program returns the filter for the given port and counter maps
+func program(portsFD, countersFD int) []insn {
+	var p []insn
+	add := func(i ...insn) { p = append(p, i...) }
+
+	add(
+		movReg(r6, r1),
+		ldx(sizeW, r8, r6, skbLen),
+		ldx(sizeW, r7, r6, skbPktType),
+		jeq(r7, packetOutgoing, "sent"),
+		mov(r7, 0),
+		ja("key"),
+		at("sent", mov(r7, 1)),
+		at("key", st(sizeDW, stackKey, 0)),
+		st(sizeDW, stackKey+8, 0),
+		st(sizeDW, stackKey+16, 0),
+		stx(sizeB, r10, r7, stackKey+3),
+		ldAbs(sizeB, 0),
+		alu(aluRSH, r0, 4),
+		jeq(r0, 4, "ipv4"),
+		jeq(r0, 6, "ipv6"),
+		ja("done"),
+	)
+
+	// IPv4: skip non-first fragments, which carry no ports
+	add(
+		at("ipv4", ldAbs(sizeH, 6)),
+		alu(aluAND, r0, 0x1fff),
+		jne(r0, 0, "done"),
+		ldAbs(sizeB, 9),
+		jeq(r0, protoTCP, "ipv4proto"),
+		jeq(r0, protoUDP, "ipv4proto"),
+		ja("done"),
+		at("ipv4proto", stx(sizeB, r10, r0, stackKey+2)),
+		ldAbs(sizeB, 0),
+		alu(aluAND, r0, 0x0f),
+		alu(aluLSH, r0, 2),
+		movReg(r9, r0),
+		st(sizeW, stackKey+16, 0xffff),
+		jeq(r7, 1, "ipv4dst"),
+		ldAbs(sizeW, 12),
+		ja("ipv4addr"),
+		at("ipv4dst", ldAbs(sizeW, 16)),
+		at("ipv4addr", stx(sizeW, r10, r0, stackKey+20)),
+		jeq(r7, 1, "ipv4sport"),
+		ldInd(sizeH, r9, 2),
+		ja("port"),
+		at("ipv4sport", ldInd(sizeH, r9, 0)),
+		ja("port"),
+	)
+
+	// IPv6: only packets without extension headers
+	add(
+		at("ipv6", ldAbs(sizeB, 6)),
+		jeq(r0, protoTCP, "ipv6proto"),
+		jeq(r0, protoUDP, "ipv6proto"),
+		ja("done"),
+		at("ipv6proto", stx(sizeB, r10, r0, stackKey+2)),
+		mov(r9, 8),
+		jne(r7, 1, "ipv6addr"),
+		mov(r9, 24),
+		at("ipv6addr", ldInd(sizeW, r9, 0)),
+		stx(sizeW, r10, r0, stackKey+8),
+		ldInd(sizeW, r9, 4),
+		stx(sizeW, r10, r0, stackKey+12),
+		ldInd(sizeW, r9, 8),
+		stx(sizeW, r10, r0, stackKey+16),
+		ldInd(sizeW, r9, 12),
+		stx(sizeW, r10, r0, stackKey+20),
+		jeq(r7, 1, "ipv6sport"),
+		ldAbs(sizeH, 42),
+		ja("port"),
+		at("ipv6sport", ldAbs(sizeH, 40)),
+	)
+
+	// Count the packet if its local port is watched
+	add(at("port", stx(sizeH, r10, r0, stackKey)), stx(sizeW, r10, r0, stackPortKey))
+	add(ldMap(r1, portsFD)...)
+	add(
+		movReg(r2, r10),
+		alu(aluADD, r2, stackPortKey),
+		call(fnMapLookupElem),
+		jeq(r0, 0, "done"),
+		ldx(sizeW, r1, r0, 0),
+		jeq(r1, 0, "done"),
+	)
+	add(ldMap(r1, countersFD)...)
+	add(
+		movReg(r2, r10),
+		alu(aluADD, r2, stackKey),
+		call(fnMapLookupElem),
+		jeq(r0, 0, "insert"),
+		xadd(r0, r8, 0),
+		mov(r1, 1),
+		xadd(r0, r1, 8),
+		ja("done"),
+		at("insert", stx(sizeDW, r10, r8, stackValue)),
+		st(sizeDW, stackValue+8, 1),
+	)
+	add(ldMap(r1, countersFD)...)
+	add(
+		movReg(r2, r10),
+		alu(aluADD, r2, stackKey),
+		movReg(r3, r10),
+		alu(aluADD, r3, stackValue),
+		mov(r4, flagNoExist),
+		call(fnMapUpdateElem),
+		at("done", mov(r0, 0)),
+		exit(),
+	)
+	return p
+}
+
+// assemble resolves jump labels and encodes the program
+func assemble(p []insn) ([]byte, error) {
+	labels := make(map[string]int)
+	for i, in := range p {
+		if in.label != "" {
+			if _, dup := labels[in.label]; dup {
+				return nil, errors.New("duplicate label " + in.label)
+			}
+			labels[in.label] = i
+		}
+	}
+
+	out := make([]byte, 0, len(p)*8)
+	for i, in := range p {
+		if in.jump != "" {
+			target, ok := labels[in.jump]
+			if !ok {
+				return nil, errors.New("unknown label " + in.jump)
+			}
+			in.off = int16(target - i - 1)
+		}
+		var b [8]byte
+		b[0] = in.code
+		b[1] = in.src<<4 | in.dst
+		binary.LittleEndian.PutUint16(b[2:], uint16(in.off))
+		binary.LittleEndian.PutUint32(b[4:], uint32(in.imm))
+		out = append(out, b[:]...)
+	}
+	return out, nil
+}
+
+// counterKey is a decoded map key
+type counterKey struct {
+	port  uint16
+	proto uint8
+	sent  bool
+	addr  netip.Addr
+}
+
+// decodeKey decodes a map key written by the program
+func decodeKey(b []byte) counterKey {
+	var addr [16]byte
+	for i := 0; i < 4; i++ {
+		binary.BigEndian.PutUint32(addr[i*4:], binary.NativeEndian.Uint32(b[8+i*4:]))
+	}
+	return counterKey{
+		port:  binary.NativeEndian.Uint16(b[0:]),
+		proto: b[2],
+		sent:  b[3] == 1,
+		addr:  netip.AddrFrom16(addr).Unmap(),
+	}
+}
+
+// merge folds the received and sent counters of each port and address
+// into one Counter
+func merge(keys []counterKey, values [][valueSize]byte) []*Counter {
+	type id struct {
+		port  uint16
+		proto uint8
+		addr  netip.Addr
+	}
+	byID := make(map[id]*Counter)
+	var out []*Counter
+	for i, key := range keys {
+		k := id{key.port, key.proto, key.addr}
+		c, ok := byID[k]
+		if !ok {
+			c = &Counter{Port: key.port, Protocol: protocolName(key.proto), Addr: key.addr}
+			byID[k] = c
+			out = append(out, c)
+		}
+		bytes := binary.NativeEndian.Uint64(values[i][0:])
+		packets := binary.NativeEndian.Uint64(values[i][8:])
+		if key.sent {
+			c.Downlink += bytes
+			c.DownlinkPackets += packets
+		} else {
+			c.Uplink += bytes
+			c.UplinkPackets += packets
+		}
+	}
+	return out
+}
+
+// protocolName returns the name of an IP protocol number
+func protocolName(proto uint8) string {
+	switch proto {
+	case protoTCP:
+		return "tcp"
+	case protoUDP:
+		return "udp"
+	}
+	return "unknown"
+}
@@ -0,0 +1,239 @@
+package ebpfacct
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Accounter owns the loaded filter, its maps and the socket it is attached to
+type Accounter struct {
+	mu         sync.Mutex
+	portsFD    int
+	countersFD int
+	progFD     int
+	sockFD     int
+	watched    map[uint16]bool
+}
+
+// Open loads the filter and starts counting. No port is watched until
+// SetPorts is called. It needs CAP_BPF (or CAP_SYS_ADMIN) and CAP_NET_RAW.
+func Open() (*Accounter, error) {
+	a := &Accounter{portsFD: -1, countersFD: -1, progFD: -1, sockFD: -1, watched: make(map[uint16]bool)}
+
+	var err error
+	if a.portsFD, err = createMap(unix.BPF_MAP_TYPE_ARRAY, 4, 4, 65536); err != nil {
+		return nil, fmt.Errorf("failed to create port map: %w", err)
+	}
+	if a.countersFD, err = createMap(unix.BPF_MAP_TYPE_HASH, keySize, valueSize, MaxEntries); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to create counter map: %w", err)
+	}
+
+	code, err := assemble(program(a.portsFD, a.countersFD))
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	if a.progFD, err = loadProgram(code); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to load filter: %w", err)
+	}
+
+	if a.sockFD, err = unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL))); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	if err := unix.SetsockoptInt(a.sockFD, unix.SOL_SOCKET, unix.SO_ATTACH_BPF, a.progFD); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to attach filter: %w", err)
+	}
+	return a, nil
+}
+
+// SetPorts replaces the watched ports. Counters of ports no longer watched
+// are kept until Close.
+func (a *Accounter) SetPorts(ports []uint16) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	want := make(map[uint16]bool, len(ports))
+	for _, port := range ports {
+		want[port] = true
+	}
+	for port := range a.watched {
+		if !want[port] {
+			if err := setPort(a.portsFD, port, 0); err != nil {
+				return err
+			}
+			delete(a.watched, port)
+		}
+	}
+	for port := range want {
+		if !a.watched[port] {
+			if err := setPort(a.portsFD, port, 1); err != nil {
+				return err
+			}
+			a.watched[port] = true
+		}
+	}
+	return nil
+}
+
+// Counters returns the traffic counted since Open, and whether the counter
+// map is full
+func (a *Accounter) Counters() ([]*Counter, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var (
+		keys   []counterKey
+		values [][valueSize]byte
+		key    [keySize]byte
+		next   [keySize]byte
+	)
+	cur := unsafe.Pointer(nil) // nil starts the walk at the first key
+	for {
+		err := mapOp(unix.BPF_MAP_GET_NEXT_KEY, a.countersFD, cur, unsafe.Pointer(&next[0]), 0)
+		if errors.Is(err, unix.ENOENT) {
+			break
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to walk counter map: %w", err)
+		}
+		key = next
+		cur = unsafe.Pointer(&key[0])
+
+		var value [valueSize]byte
+		if err := mapOp(unix.BPF_MAP_LOOKUP_ELEM, a.countersFD, cur, unsafe.Pointer(&value[0]), 0); err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				continue
+			}
+			return nil, false, fmt.Errorf("failed to read counter: %w", err)
+		}
+		keys = append(keys, decodeKey(key[:]))
+		values = append(values, value)
+	}
+	return merge(keys, values), len(keys) >= MaxEntries, nil
+}
+
+// Close detaches the filter and frees its maps
+func (a *Accounter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, fd := range []*int{&a.sockFD, &a.progFD, &a.countersFD, &a.portsFD} {
+		if *fd >= 0 {
+			unix.Close(*fd)
+			*fd = -1
+		}
+	}
+	return nil
+}
+
+// bpf issues a bpf(2) command
+func bpf(cmd uintptr, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, cmd, uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// createMap creates a map and returns its file descriptor
+func createMap(mapType, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+		mapFlags   uint32
+	}{mapType, keySize, valueSize, maxEntries, 0}
+	return bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// loadProgram loads a socket filter. When the verifier refuses it, the load
+// is repeated with a log so the error says why.
+func loadProgram(code []byte) (int, error) {
+	license := []byte("GPL\x00")
+	load := func(logBuf []byte) (int, error) {
+		attr := struct {
+			progType    uint32
+			insnCnt     uint32
+			insns       uint64
+			license     uint64
+			logLevel    uint32
+			logSize     uint32
+			logBuf      uint64
+			kernVersion uint32
+			_           uint32
+		}{
+			progType: unix.BPF_PROG_TYPE_SOCKET_FILTER,
+			insnCnt:  uint32(len(code) / 8),
+			insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+			license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		}
+		if logBuf != nil {
+			attr.logLevel = 1
+			attr.logSize = uint32(len(logBuf))
+			attr.logBuf = uint64(uintptr(unsafe.Pointer(&logBuf[0])))
+		}
+		fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+		runtime.KeepAlive(code)
+		runtime.KeepAlive(license)
+		runtime.KeepAlive(logBuf)
+		return fd, err
+	}
+
+	fd, err := load(nil)
+	if err == nil || !errors.Is(err, unix.EACCES) && !errors.Is(err, unix.EINVAL) {
+		return fd, err
+	}
+	logBuf := make([]byte, 64*1024)
+	if _, retryErr := load(logBuf); retryErr != nil {
+		if n := clen(logBuf); n > 0 {
+			return -1, fmt.Errorf("%w: %s", err, logBuf[:n])
+		}
+	}
+	return -1, err
+}
+
+// mapOp issues a map element command
+func mapOp(cmd uintptr, fd int, key, value unsafe.Pointer, flags uint64) error {
+	attr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{mapFD: uint32(fd), key: uint64(uintptr(key)), value: uint64(uintptr(value)), flags: flags}
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// setPort marks a port as watched (1) or not (0)
+func setPort(fd int, port uint16, watched uint32) error {
+	key := uint32(port)
+	if err := mapOp(unix.BPF_MAP_UPDATE_ELEM, fd, unsafe.Pointer(&key), unsafe.Pointer(&watched), 0); err != nil {
+		return fmt.Errorf("failed to update port %d: %w", port, err)
+	}
+	return nil
+}
+
+// htons converts a short to network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// clen returns the length of a NUL-terminated buffer
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
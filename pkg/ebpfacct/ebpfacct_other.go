@@ -0,0 +1,26 @@
+//go:build !linux
+
+package ebpfacct
+
+// Accounter is not available outside Linux
+type Accounter struct{}
+
+// Open returns ErrUnsupported outside Linux
+func Open() (*Accounter, error) {
+	return nil, ErrUnsupported
+}
+
+// SetPorts returns ErrUnsupported outside Linux
+func (a *Accounter) SetPorts(ports []uint16) error {
+	return ErrUnsupported
+}
+
+// Counters returns ErrUnsupported outside Linux
+func (a *Accounter) Counters() ([]*Counter, bool, error) {
+	return nil, false, ErrUnsupported
+}
+
+// Close does nothing outside Linux
+func (a *Accounter) Close() error {
+	return nil
+}
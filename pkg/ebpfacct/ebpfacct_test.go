@@ -0,0 +1,65 @@
+package ebpfacct
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	code, err := assemble(program(3, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code)%8 != 0 || len(code) == 0 {
+		t.Fatalf("Unexpected program length %d", len(code))
+	}
+
+	// The program ends with r0 = 0; exit
+	last := code[len(code)-8:]
+	if last[0] != clsJMP|jmpEXIT {
+		t.Errorf("Expected the program to end with exit, got opcode %#x", last[0])
+	}
+
+	if _, err := assemble([]insn{ja("missing")}); err == nil {
+		t.Error("Expected an unknown label to be rejected")
+	}
+}
+
+func TestDecodeAndMerge(t *testing.T) {
+	key := func(port uint16, sent bool, words [4]uint32) counterKey {
+		b := make([]byte, keySize)
+		binary.NativeEndian.PutUint16(b[0:], port)
+		b[2] = protoTCP
+		if sent {
+			b[3] = 1
+		}
+		for i, w := range words {
+			binary.NativeEndian.PutUint32(b[8+i*4:], w)
+		}
+		return decodeKey(b)
+	}
+	value := func(bytes, packets uint64) [valueSize]byte {
+		var v [valueSize]byte
+		binary.NativeEndian.PutUint64(v[0:], bytes)
+		binary.NativeEndian.PutUint64(v[8:], packets)
+		return v
+	}
+
+	// 198.51.100.7 as the program stores it: IPv4-mapped, words in host order
+	v4 := [4]uint32{0, 0, 0xffff, 0xc6336407}
+	counters := merge(
+		[]counterKey{key(443, false, v4), key(443, true, v4)},
+		[][valueSize]byte{value(1000, 10), value(5000, 8)},
+	)
+	if len(counters) != 1 {
+		t.Fatalf("Expected both directions merged, got %d counters", len(counters))
+	}
+	c := counters[0]
+	if c.Addr != netip.MustParseAddr("198.51.100.7") || c.Port != 443 || c.Protocol != "tcp" {
+		t.Errorf("Unexpected counter %+v", c)
+	}
+	if c.Uplink != 1000 || c.Downlink != 5000 || c.UplinkPackets != 10 || c.DownlinkPackets != 8 {
+		t.Errorf("Unexpected traffic %+v", c)
+	}
+}
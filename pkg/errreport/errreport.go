@@ -0,0 +1,222 @@
+// Package errreport sends panic reports to Sentry or to a generic JSON endpoint.
+package errreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config holds reporter configuration
+type Config struct {
+	SentryDSN string // https://<key>@<host>/<project>
+	URL       string // Generic endpoint receiving Event as JSON
+	Release   string // Node version
+}
+
+// Frame is one stack frame, innermost first
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Event is a captured panic, as sent to the generic endpoint
+type Event struct {
+	ID        string            `json:"id"`
+	Timestamp time.Time         `json:"timestamp"`
+	Message   string            `json:"message"`
+	Frames    []Frame           `json:"frames"`
+	Context   map[string]string `json:"context,omitempty"` // e.g. request method and path
+	Node      map[string]string `json:"node"`
+}
+
+// Reporter sends events. A nil Reporter is valid and reports nothing.
+type Reporter struct {
+	client    *http.Client
+	url       string
+	sentry    bool
+	sentryKey string
+	release   string
+	hostname  string
+}
+
+// New creates a reporter, or returns nil if neither a DSN nor a URL is configured
+func New(cfg *Config) (*Reporter, error) {
+	hostname, _ := os.Hostname()
+	r := &Reporter{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		release:  cfg.Release,
+		hostname: hostname,
+	}
+
+	switch {
+	case cfg.SentryDSN != "":
+		storeURL, key, err := parseDSN(cfg.SentryDSN)
+		if err != nil {
+			return nil, err
+		}
+		r.url = storeURL
+		r.sentry = true
+		r.sentryKey = key
+	case cfg.URL != "":
+		r.url = cfg.URL
+	default:
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+// parseDSN returns the store endpoint and public key of a Sentry DSN
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+
+	path := strings.Trim(u.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if idx >= 0 {
+		prefix, project = "/"+path[:idx], path[idx+1:]
+	}
+	if project == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: missing project id")
+	}
+
+	return fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project), u.User.Username(), nil
+}
+
+// Capture builds an event for a recovered panic, with the stack of the caller
+func Capture(recovered interface{}, context map[string]string) *Event {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs) // skip runtime.Callers, Capture and the deferred recover func
+	frames := runtime.CallersFrames(pcs[:n])
+
+	event := &Event{
+		ID:        newEventID(),
+		Timestamp: time.Now().UTC(),
+		Message:   fmt.Sprint(recovered),
+		Context:   context,
+	}
+	for {
+		frame, more := frames.Next()
+		// Drop runtime.gopanic and friends above the panicking function
+		if len(event.Frames) == 0 && strings.HasPrefix(frame.Function, "runtime.") && more {
+			continue
+		}
+		event.Frames = append(event.Frames, Frame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+		})
+		if !more {
+			break
+		}
+	}
+	return event
+}
+
+// newEventID returns a random 32-char hex id (the Sentry event id format)
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Send delivers an event in the background; delivery errors are dropped
+func (r *Reporter) Send(event *Event) {
+	if r == nil || event == nil {
+		return
+	}
+
+	event.Node = map[string]string{
+		"hostname": r.hostname,
+		"version":  r.release,
+	}
+	go func() {
+		_ = r.send(event)
+	}()
+}
+
+// send delivers an event synchronously
+func (r *Reporter) send(event *Event) error {
+	var body []byte
+	var err error
+	if r.sentry {
+		body, err = json.Marshal(r.sentryEvent(event))
+	} else {
+		body, err = json.Marshal(event)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.sentry {
+		req.Header.Set("X-Sentry-Auth", fmt.Sprintf(
+			"Sentry sentry_version=7, sentry_client=remnawave-node-go/%s, sentry_key=%s", r.release, r.sentryKey))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error report endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sentryEvent converts an event to the Sentry store payload
+func (r *Reporter) sentryEvent(event *Event) map[string]interface{} {
+	// Sentry expects frames oldest first
+	frames := make([]map[string]interface{}, 0, len(event.Frames))
+	for i := len(event.Frames) - 1; i >= 0; i-- {
+		f := event.Frames[i]
+		frames = append(frames, map[string]interface{}{
+			"function": f.Function,
+			"abs_path": f.File,
+			"lineno":   f.Line,
+		})
+	}
+
+	return map[string]interface{}{
+		"event_id":    event.ID,
+		"timestamp":   event.Timestamp.Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      "panic",
+		"server_name": r.hostname,
+		"release":     r.release,
+		"message":     event.Message,
+		"tags":        event.Context,
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{
+				{
+					"type":       "panic",
+					"value":      event.Message,
+					"stacktrace": map[string]interface{}{"frames": frames},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package errreport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew_Disabled(t *testing.T) {
+	r, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if r != nil {
+		t.Error("Expected nil reporter without DSN or URL")
+	}
+
+	// A nil reporter must be safe to use
+	r.Send(&Event{})
+}
+
+func TestParseDSN(t *testing.T) {
+	storeURL, key, err := parseDSN("https://abc123@o1.ingest.sentry.io/42")
+	if err != nil {
+		t.Fatalf("parseDSN failed: %v", err)
+	}
+	if storeURL != "https://o1.ingest.sentry.io/api/42/store/" {
+		t.Errorf("Unexpected store URL: %s", storeURL)
+	}
+	if key != "abc123" {
+		t.Errorf("Unexpected key: %s", key)
+	}
+
+	storeURL, _, err = parseDSN("https://abc123@sentry.example.com/sub/7")
+	if err != nil {
+		t.Fatalf("parseDSN failed: %v", err)
+	}
+	if storeURL != "https://sentry.example.com/sub/api/7/store/" {
+		t.Errorf("Unexpected store URL with path prefix: %s", storeURL)
+	}
+
+	if _, _, err := parseDSN("https://sentry.example.com/7"); err == nil {
+		t.Error("Expected error for DSN without key")
+	}
+}
+
+func TestCapture_RecordsStack(t *testing.T) {
+	var event *Event
+	func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				event = Capture(rec, map[string]string{"path": "/node/xray/start"})
+			}
+		}()
+		panic("boom")
+	}()
+
+	if event.Message != "boom" {
+		t.Errorf("Unexpected message: %s", event.Message)
+	}
+	if len(event.ID) != 32 {
+		t.Errorf("Expected 32-char event id, got %q", event.ID)
+	}
+	if len(event.Frames) == 0 || !strings.Contains(event.Frames[0].Function, "TestCapture_RecordsStack") {
+		t.Errorf("Expected innermost frame in the panicking function, got %+v", event.Frames)
+	}
+}
+
+func TestSend_GenericEndpoint(t *testing.T) {
+	received := make(chan *Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event Event
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("Decode failed: %v", err)
+		}
+		received <- &event
+	}))
+	defer srv.Close()
+
+	r, err := New(&Config{URL: srv.URL, Release: "1.2.3"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	event := &Event{ID: "id", Message: "boom"}
+	event.Node = map[string]string{"version": r.release}
+	if err := r.send(event); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	got := <-received
+	if got.Message != "boom" || got.Node["version"] != "1.2.3" {
+		t.Errorf("Unexpected event: %+v", got)
+	}
+}
+
+func TestSend_Sentry(t *testing.T) {
+	var auth string
+	var payload map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/9/store/" {
+			t.Errorf("Unexpected path: %s", req.URL.Path)
+		}
+		auth = req.Header.Get("X-Sentry-Auth")
+		_ = json.NewDecoder(req.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "://", "://key@", 1) + "/9"
+	r, err := New(&Config{SentryDSN: dsn, Release: "1.2.3"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	event := &Event{ID: "id", Message: "boom", Frames: []Frame{{Function: "inner"}, {Function: "outer"}}}
+	if err := r.send(event); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	if !strings.Contains(auth, "sentry_key=key") {
+		t.Errorf("Unexpected auth header: %s", auth)
+	}
+	frames := payload["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	if frames[0].(map[string]interface{})["function"] != "outer" {
+		t.Errorf("Expected frames oldest first, got %v", frames)
+	}
+}
@@ -0,0 +1,143 @@
+// Package featureflags provides named on/off switches so new subsystems can
+// be shipped disabled and enabled per node
+package featureflags
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownFlag is returned for a flag that was not registered
+	ErrUnknownFlag = errors.New("unknown feature flag")
+	// ErrNotRuntime is returned when toggling a flag that is only read at startup
+	ErrNotRuntime = errors.New("feature flag can only be changed in the configuration")
+)
+
+// Sources of a flag's current value
+const (
+	SourceDefault = "default"
+	SourceConfig  = "config"
+	SourceRuntime = "runtime"
+)
+
+// Flag defines a feature flag
+type Flag struct {
+	Name        string
+	Description string
+	Default     bool
+	// Runtime flags are checked on every use and may be toggled while the
+	// node runs; other flags are read once when their subsystem starts
+	Runtime bool
+}
+
+// State is the current value of a flag
+type State struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Default     bool   `json:"default"`
+	Runtime     bool   `json:"runtime"`
+	Source      string `json:"source"`
+}
+
+// Set holds the registered flags and their values. It is safe for concurrent use.
+type Set struct {
+	mu    sync.RWMutex
+	flags map[string]*State
+}
+
+// New creates a Set with the given flags at their defaults
+func New(flags ...Flag) *Set {
+	s := &Set{flags: make(map[string]*State, len(flags))}
+	for _, f := range flags {
+		s.flags[f.Name] = &State{
+			Name:        f.Name,
+			Description: f.Description,
+			Enabled:     f.Default,
+			Default:     f.Default,
+			Runtime:     f.Runtime,
+			Source:      SourceDefault,
+		}
+	}
+	return s
+}
+
+// Apply sets flags from the configuration. Unknown names are rejected so
+// typos don't go unnoticed; nothing is changed in that case.
+func (s *Set) Apply(values map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range values {
+		if _, exists := s.flags[name]; !exists {
+			return fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+		}
+	}
+	for name, enabled := range values {
+		flag := s.flags[name]
+		flag.Enabled = enabled
+		flag.Source = SourceConfig
+	}
+	return nil
+}
+
+// Enabled reports whether a flag is on. Unknown flags and a nil Set are off.
+func (s *Set) Enabled(name string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	flag, exists := s.flags[name]
+	return exists && flag.Enabled
+}
+
+// Toggle changes a runtime flag and returns its new state
+func (s *Set) Toggle(name string, enabled bool) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flag, exists := s.flags[name]
+	if !exists {
+		return State{}, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+	if !flag.Runtime {
+		return State{}, fmt.Errorf("%w: %s", ErrNotRuntime, name)
+	}
+
+	flag.Enabled = enabled
+	flag.Source = SourceRuntime
+	return *flag, nil
+}
+
+// List returns the state of all flags sorted by name
+func (s *Set) List() []State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]State, 0, len(s.flags))
+	for _, flag := range s.flags {
+		result = append(result, *flag)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Values returns whether each flag is enabled
+func (s *Set) Values() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]bool, len(s.flags))
+	for name, flag := range s.flags {
+		result[name] = flag.Enabled
+	}
+	return result
+}
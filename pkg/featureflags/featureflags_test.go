@@ -0,0 +1,85 @@
+package featureflags
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestSet() *Set {
+	return New(
+		Flag{Name: "safe", Default: false, Runtime: true},
+		Flag{Name: "boot", Default: true},
+	)
+}
+
+func TestSet_Defaults(t *testing.T) {
+	s := newTestSet()
+
+	if s.Enabled("safe") {
+		t.Error("Expected safe to be off by default")
+	}
+	if !s.Enabled("boot") {
+		t.Error("Expected boot to be on by default")
+	}
+	if s.Enabled("missing") {
+		t.Error("Expected unknown flag to be off")
+	}
+
+	var nilSet *Set
+	if nilSet.Enabled("safe") {
+		t.Error("Expected nil set to report flags off")
+	}
+}
+
+func TestSet_Apply(t *testing.T) {
+	s := newTestSet()
+
+	if err := s.Apply(map[string]bool{"safe": true, "typo": true}); !errors.Is(err, ErrUnknownFlag) {
+		t.Fatalf("Expected ErrUnknownFlag, got %v", err)
+	}
+	if s.Enabled("safe") {
+		t.Error("Expected a rejected Apply to change nothing")
+	}
+
+	if err := s.Apply(map[string]bool{"safe": true, "boot": false}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !s.Enabled("safe") || s.Enabled("boot") {
+		t.Errorf("Expected applied values, got %v", s.Values())
+	}
+	for _, state := range s.List() {
+		if state.Source != SourceConfig {
+			t.Errorf("Expected %s from config, got %s", state.Name, state.Source)
+		}
+	}
+}
+
+func TestSet_Toggle(t *testing.T) {
+	s := newTestSet()
+
+	state, err := s.Toggle("safe", true)
+	if err != nil {
+		t.Fatalf("Toggle failed: %v", err)
+	}
+	if !state.Enabled || state.Source != SourceRuntime || !s.Enabled("safe") {
+		t.Errorf("Expected safe enabled at runtime, got %+v", state)
+	}
+
+	if _, err := s.Toggle("boot", false); !errors.Is(err, ErrNotRuntime) {
+		t.Errorf("Expected ErrNotRuntime, got %v", err)
+	}
+	if !s.Enabled("boot") {
+		t.Error("Expected boot to stay on")
+	}
+
+	if _, err := s.Toggle("missing", true); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("Expected ErrUnknownFlag, got %v", err)
+	}
+}
+
+func TestSet_ListSorted(t *testing.T) {
+	list := newTestSet().List()
+	if len(list) != 2 || list[0].Name != "boot" || list[1].Name != "safe" {
+		t.Errorf("Expected flags sorted by name, got %+v", list)
+	}
+}
@@ -5,39 +5,131 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
 	"sync"
+	"time"
+
+	"lukechampine.com/blake3"
+
+	"github.com/clash-version/remnawave-node-go/pkg/atomicfile"
+)
+
+// Algorithm selects the hash function used by a HashedSet
+type Algorithm string
+
+const (
+	// AlgorithmSHA256 is the default, compatible with previous releases
+	AlgorithmSHA256 Algorithm = "sha256"
+	// AlgorithmBLAKE3 is considerably faster on multi-megabyte inputs
+	AlgorithmBLAKE3 Algorithm = "blake3"
 )
 
+// ParseAlgorithm converts a name to an Algorithm; empty means SHA-256
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch Algorithm(name) {
+	case "", AlgorithmSHA256:
+		return AlgorithmSHA256, nil
+	case AlgorithmBLAKE3:
+		return AlgorithmBLAKE3, nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", name)
+	}
+}
+
+// newHash returns a fresh hash.Hash for the algorithm
+func (a Algorithm) newHash() hash.Hash {
+	if a == AlgorithmBLAKE3 {
+		return blake3.New(32, nil)
+	}
+	return sha256.New()
+}
+
+// entry is a stored hash with an optional expiry
+type entry struct {
+	Hash      string    `json:"hash"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// expired reports whether the entry has a TTL that has passed
+func (e entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
 // HashedSet stores hashes of configuration objects for change detection
 type HashedSet struct {
-	mu     sync.RWMutex
-	hashes map[string]string // key -> hash
+	mu         sync.RWMutex
+	hashes     map[string]entry // key -> hash
+	defaultTTL time.Duration    // 0 means entries never expire
+	algorithm  Algorithm
 }
 
-// New creates a new HashedSet
+// New creates a new HashedSet using SHA-256
 func New() *HashedSet {
+	return NewWithAlgorithm(AlgorithmSHA256)
+}
+
+// NewWithAlgorithm creates a new HashedSet using the given hash algorithm
+func NewWithAlgorithm(algorithm Algorithm) *HashedSet {
 	return &HashedSet{
-		hashes: make(map[string]string),
+		hashes:    make(map[string]entry),
+		algorithm: algorithm,
+	}
+}
+
+// SetDefaultTTL sets the TTL applied to entries stored afterwards.
+// A zero TTL disables expiry.
+func (s *HashedSet) SetDefaultTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultTTL = ttl
+}
+
+// newEntry creates an entry using the given TTL (caller must hold the lock)
+func newEntry(hash string, ttl time.Duration) entry {
+	e := entry{Hash: hash}
+	if ttl > 0 {
+		e.ExpiresAt = time.Now().Add(ttl)
+	}
+	return e
+}
+
+// lookup returns a non-expired entry (caller must hold the lock)
+func (s *HashedSet) lookup(key string) (entry, bool) {
+	e, exists := s.hashes[key]
+	if !exists || e.expired(time.Now()) {
+		return entry{}, false
 	}
+	return e, true
 }
 
 // SetHash sets the hash for a key
 func (s *HashedSet) SetHash(key string, data any) error {
-	hash, err := computeHash(data)
+	s.mu.RLock()
+	ttl := s.defaultTTL
+	s.mu.RUnlock()
+	return s.SetHashWithTTL(key, data, ttl)
+}
+
+// SetHashWithTTL sets the hash for a key that expires after ttl
+func (s *HashedSet) SetHashWithTTL(key string, data any, ttl time.Duration) error {
+	hash, err := s.computeHash(data)
 	if err != nil {
 		return err
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.hashes[key] = hash
+	s.hashes[key] = newEntry(hash, ttl)
 	return nil
 }
 
 // HasChanged checks if the data has changed from the stored hash
 // Returns true if changed or if key doesn't exist
 func (s *HashedSet) HasChanged(key string, data any) (bool, error) {
-	hash, err := computeHash(data)
+	hash, err := s.computeHash(data)
 	if err != nil {
 		return false, err
 	}
@@ -45,18 +137,18 @@ func (s *HashedSet) HasChanged(key string, data any) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	storedHash, exists := s.hashes[key]
+	stored, exists := s.lookup(key)
 	if !exists {
 		return true, nil
 	}
 
-	return storedHash != hash, nil
+	return stored.Hash != hash, nil
 }
 
 // UpdateIfChanged updates the hash if the data has changed
 // Returns true if the hash was updated (data changed)
 func (s *HashedSet) UpdateIfChanged(key string, data any) (bool, error) {
-	hash, err := computeHash(data)
+	hash, err := s.computeHash(data)
 	if err != nil {
 		return false, err
 	}
@@ -64,15 +156,46 @@ func (s *HashedSet) UpdateIfChanged(key string, data any) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	storedHash, exists := s.hashes[key]
-	if !exists || storedHash != hash {
-		s.hashes[key] = hash
+	stored, exists := s.lookup(key)
+	if !exists || stored.Hash != hash {
+		s.hashes[key] = newEntry(hash, s.defaultTTL)
 		return true, nil
 	}
 
 	return false, nil
 }
 
+// UpdateIfChangedBytes is like UpdateIfChanged for data already held as bytes
+func (s *HashedSet) UpdateIfChangedBytes(key string, b []byte) bool {
+	hash := s.HashBytes(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.lookup(key)
+	if !exists || stored.Hash != hash {
+		s.hashes[key] = newEntry(hash, s.defaultTTL)
+		return true
+	}
+	return false
+}
+
+// HashBytes hashes raw bytes with the set's algorithm
+func (s *HashedSet) HashBytes(b []byte) string {
+	h := s.algorithm.newHash()
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashReader hashes a stream with the set's algorithm without buffering it
+func (s *HashedSet) HashReader(r io.Reader) (string, error) {
+	h := s.algorithm.newHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // Delete removes a key from the set
 func (s *HashedSet) Delete(key string) {
 	s.mu.Lock()
@@ -84,22 +207,29 @@ func (s *HashedSet) Delete(key string) {
 func (s *HashedSet) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.hashes = make(map[string]string)
+	s.hashes = make(map[string]entry)
 }
 
 // GetHash returns the stored hash for a key
 func (s *HashedSet) GetHash(key string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	hash, exists := s.hashes[key]
-	return hash, exists
+	e, exists := s.lookup(key)
+	return e.Hash, exists
 }
 
 // SetHashValue directly sets a hash value for a key (without computing)
 func (s *HashedSet) SetHashValue(key, hash string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.hashes[key] = hash
+	s.hashes[key] = newEntry(hash, s.defaultTTL)
+}
+
+// SetHashValueWithTTL directly sets a hash value for a key that expires after ttl
+func (s *HashedSet) SetHashValueWithTTL(key, hash string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes[key] = newEntry(hash, ttl)
 }
 
 // Keys returns all keys in the set
@@ -107,8 +237,12 @@ func (s *HashedSet) Keys() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	now := time.Now()
 	keys := make([]string, 0, len(s.hashes))
-	for k := range s.hashes {
+	for k, e := range s.hashes {
+		if e.expired(now) {
+			continue
+		}
 		keys = append(keys, k)
 	}
 	return keys
@@ -118,18 +252,121 @@ func (s *HashedSet) Keys() []string {
 func (s *HashedSet) Size() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.hashes)
+
+	now := time.Now()
+	size := 0
+	for _, e := range s.hashes {
+		if !e.expired(now) {
+			size++
+		}
+	}
+	return size
+}
+
+// PurgeExpired removes expired entries and returns how many were removed
+func (s *HashedSet) PurgeExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for k, e := range s.hashes {
+		if e.expired(now) {
+			delete(s.hashes, k)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Save writes all non-expired entries to a file atomically
+func (s *HashedSet) Save(path string) error {
+	s.mu.RLock()
+	now := time.Now()
+	snapshot := make(map[string]entry, len(s.hashes))
+	for k, e := range s.hashes {
+		if !e.expired(now) {
+			snapshot[k] = e
+		}
+	}
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hashed set: %w", err)
+	}
+	return atomicfile.WriteFile(path, data, 0600, false)
 }
 
-// computeHash computes SHA256 hash of JSON-serialized data
-func computeHash(data any) (string, error) {
-	jsonBytes, err := json.Marshal(data)
+// Load replaces the set contents with entries from a file written by Save.
+// A missing file leaves the set empty and is not an error.
+func (s *HashedSet) Load(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			s.Clear()
+			return nil
+		}
+		return fmt.Errorf("failed to read hashed set: %w", err)
+	}
+
+	loaded := make(map[string]entry)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse hashed set: %w", err)
+	}
+
+	// Drop entries that expired while persisted
+	now := time.Now()
+	for k, e := range loaded {
+		if e.expired(now) {
+			delete(loaded, k)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes = loaded
+	return nil
+}
+
+// computeHash hashes JSON-serialized data, streaming the encoder output
+// into the hash instead of building the marshaled bytes first. The digest
+// matches hashing json.Marshal output, as stored and compared hashes expect.
+func (s *HashedSet) computeHash(data any) (string, error) {
+	h := s.algorithm.newHash()
+	if err := json.NewEncoder(&trimFinalNewline{w: h}).Encode(data); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// trimFinalNewline passes writes through, holding back a trailing newline
+// until more data follows, so the newline json.Encoder appends is dropped
+type trimFinalNewline struct {
+	w       io.Writer
+	pending bool // A newline was held back from the previous write
+}
 
-	hash := sha256.Sum256(jsonBytes)
-	return hex.EncodeToString(hash[:]), nil
+// Write writes p, except a trailing newline
+func (t *trimFinalNewline) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	if t.pending {
+		if _, err := t.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+		t.pending = false
+	}
+	if p[n-1] == '\n' {
+		t.pending = true
+		p = p[:n-1]
+	}
+	if _, err := t.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
 // ComputeHashString computes hash of a raw string
@@ -1,7 +1,10 @@
 package hashedset
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHashedSet_SetAndGet(t *testing.T) {
@@ -169,3 +172,180 @@ func TestComputeHashString(t *testing.T) {
 		t.Errorf("Expected SHA256 hash length 64, got %d", len(hash1))
 	}
 }
+
+func TestHashedSet_TTLExpiry(t *testing.T) {
+	hs := New()
+
+	hs.SetHashValueWithTTL("short", "abc", 10*time.Millisecond)
+	hs.SetHashValue("forever", "def")
+
+	if _, exists := hs.GetHash("short"); !exists {
+		t.Fatal("Expected short-lived hash to exist before expiry")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, exists := hs.GetHash("short"); exists {
+		t.Error("Expected short-lived hash to be expired")
+	}
+	if hs.Size() != 1 {
+		t.Errorf("Expected size 1 after expiry, got %d", hs.Size())
+	}
+
+	// Expired key is reported as changed
+	changed, err := hs.HasChanged("short", "anything")
+	if err != nil {
+		t.Fatalf("HasChanged failed: %v", err)
+	}
+	if !changed {
+		t.Error("Expected changed=true for expired key")
+	}
+
+	if removed := hs.PurgeExpired(); removed != 1 {
+		t.Errorf("Expected 1 purged entry, got %d", removed)
+	}
+}
+
+func TestHashedSet_DefaultTTL(t *testing.T) {
+	hs := New()
+	hs.SetDefaultTTL(10 * time.Millisecond)
+
+	if _, err := hs.UpdateIfChanged("key", "data"); err != nil {
+		t.Fatalf("UpdateIfChanged failed: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	updated, err := hs.UpdateIfChanged("key", "data")
+	if err != nil {
+		t.Fatalf("UpdateIfChanged failed: %v", err)
+	}
+	if !updated {
+		t.Error("Expected updated=true after default TTL expired")
+	}
+}
+
+func TestHashedSet_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+
+	hs := New()
+	hs.SetHash("key1", "data1")
+	hs.SetHashValueWithTTL("key2", "abc", time.Hour)
+	hs.SetHashValueWithTTL("expired", "def", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if err := hs.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded := New()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if loaded.Size() != 2 {
+		t.Errorf("Expected 2 loaded entries, got %d", loaded.Size())
+	}
+
+	changed, err := loaded.HasChanged("key1", "data1")
+	if err != nil {
+		t.Fatalf("HasChanged failed: %v", err)
+	}
+	if changed {
+		t.Error("Expected loaded hash to match original data")
+	}
+
+	if hash, _ := loaded.GetHash("key2"); hash != "abc" {
+		t.Errorf("Expected key2 hash abc, got %q", hash)
+	}
+}
+
+func TestHashedSet_LoadMissingFile(t *testing.T) {
+	hs := New()
+	hs.SetHashValue("key", "abc")
+
+	if err := hs.Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if hs.Size() != 0 {
+		t.Errorf("Expected empty set after loading missing file, got %d", hs.Size())
+	}
+}
+
+func TestHashedSet_BLAKE3(t *testing.T) {
+	sha := New()
+	b3 := NewWithAlgorithm(AlgorithmBLAKE3)
+
+	data := []byte(`{"users":["a","b"]}`)
+	if sha.HashBytes(data) == b3.HashBytes(data) {
+		t.Error("Expected different algorithms to produce different hashes")
+	}
+	if len(b3.HashBytes(data)) != 64 {
+		t.Errorf("Expected BLAKE3-256 hash length 64, got %d", len(b3.HashBytes(data)))
+	}
+
+	changed, err := b3.UpdateIfChanged("key", map[string]int{"a": 1})
+	if err != nil || !changed {
+		t.Fatalf("Expected first update to change, got %v, %v", changed, err)
+	}
+	changed, err = b3.UpdateIfChanged("key", map[string]int{"a": 1})
+	if err != nil || changed {
+		t.Errorf("Expected same data to be unchanged, got %v, %v", changed, err)
+	}
+}
+
+func TestHashedSet_BytesAPI(t *testing.T) {
+	hs := New()
+	data := []byte(`{"clients":[1,2,3]}`)
+
+	if !hs.UpdateIfChangedBytes("users", data) {
+		t.Error("Expected first update to change")
+	}
+	if hs.UpdateIfChangedBytes("users", data) {
+		t.Error("Expected same bytes to be unchanged")
+	}
+	if !hs.UpdateIfChangedBytes("users", []byte(`{"clients":[1,2]}`)) {
+		t.Error("Expected different bytes to be changed")
+	}
+
+	streamed, err := hs.HashReader(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("HashReader failed: %v", err)
+	}
+	if streamed != hs.HashBytes(data) {
+		t.Error("Expected HashReader and HashBytes to agree")
+	}
+}
+
+func TestHashedSet_DigestMatchesMarshal(t *testing.T) {
+	// Hashes persisted and compared by earlier versions were SHA-256 of the
+	// json.Marshal output, without the newline json.Encoder appends
+	data := struct {
+		Clients []string `json:"clients"`
+		Port    int      `json:"port"`
+	}{Clients: []string{"alice", "bob"}, Port: 443}
+	const pinned = "c93204ed67a76dd933e605be872842f695629eb474e903665e88234b5648d6f1"
+
+	hs := New()
+	if err := hs.SetHash("inbound", data); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := hs.GetHash("inbound"); hash != pinned {
+		t.Errorf("Expected digest %s, got %s", pinned, hash)
+	}
+	if changed, err := hs.HasChanged("inbound", data); err != nil || changed {
+		t.Errorf("Expected the pinned digest to match the same data, got %v (%v)", changed, err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]Algorithm{"": AlgorithmSHA256, "sha256": AlgorithmSHA256, "blake3": AlgorithmBLAKE3} {
+		got, err := ParseAlgorithm(name)
+		if err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseAlgorithm("md5"); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
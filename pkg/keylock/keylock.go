@@ -0,0 +1,87 @@
+// Package keylock provides per-key mutexes that are created on demand and
+// evicted once no goroutine holds or waits for them
+package keylock
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultShards is the shard count used when New is given a non-positive value
+const DefaultShards = 32
+
+// refLock is a mutex with a count of goroutines holding or waiting for it
+type refLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// shard guards a subset of the keys so unrelated keys don't contend on one map lock
+type shard struct {
+	mu    sync.Mutex
+	locks map[string]*refLock
+}
+
+// Locker is a sharded registry of per-key locks
+type Locker struct {
+	shards []*shard
+}
+
+// New creates a Locker with the given number of shards
+func New(shards int) *Locker {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+
+	l := &Locker{shards: make([]*shard, shards)}
+	for i := range l.shards {
+		l.shards[i] = &shard{locks: make(map[string]*refLock)}
+	}
+	return l
+}
+
+// getShard returns the shard responsible for key
+func (l *Locker) getShard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return l.shards[h.Sum32()%uint32(len(l.shards))]
+}
+
+// Lock acquires the lock for key and returns the function that releases it.
+// The lock entry is removed from the registry when its last user unlocks.
+func (l *Locker) Lock(key string) (unlock func()) {
+	sh := l.getShard(key)
+
+	sh.mu.Lock()
+	lock, exists := sh.locks[key]
+	if !exists {
+		lock = &refLock{}
+		sh.locks[key] = lock
+	}
+	lock.refs++
+	sh.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		sh.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(sh.locks, key)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Len returns the number of keys currently held or waited for
+func (l *Locker) Len() int {
+	total := 0
+	for _, sh := range l.shards {
+		sh.mu.Lock()
+		total += len(sh.locks)
+		sh.mu.Unlock()
+	}
+	return total
+}
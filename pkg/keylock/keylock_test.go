@@ -0,0 +1,87 @@
+package keylock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocker_MutualExclusion(t *testing.T) {
+	l := New(4)
+
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := l.Lock("inbound")
+			counter++
+			unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counter != 100 {
+		t.Errorf("Expected counter 100, got %d", counter)
+	}
+}
+
+func TestLocker_EvictsUnusedKeys(t *testing.T) {
+	l := New(4)
+
+	unlock1 := l.Lock("a")
+	unlock2 := l.Lock("b")
+	if l.Len() != 2 {
+		t.Errorf("Expected 2 held keys, got %d", l.Len())
+	}
+
+	unlock1()
+	unlock2()
+	if l.Len() != 0 {
+		t.Errorf("Expected 0 keys after unlock, got %d", l.Len())
+	}
+}
+
+func TestLocker_IndependentKeys(t *testing.T) {
+	l := New(1) // Same shard for every key
+
+	unlockA := l.Lock("a")
+	defer unlockA()
+
+	done := make(chan struct{})
+	go func() {
+		unlock := l.Lock("b")
+		unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lock on a different key blocked")
+	}
+}
+
+func TestLocker_WaiterKeepsEntry(t *testing.T) {
+	l := New(4)
+
+	unlock := l.Lock("a")
+	acquired := make(chan func())
+	go func() {
+		acquired <- l.Lock("a")
+	}()
+
+	// Give the waiter time to register
+	time.Sleep(10 * time.Millisecond)
+	unlock()
+
+	unlock2 := <-acquired
+	if l.Len() != 1 {
+		t.Errorf("Expected entry to survive while held by waiter, got %d", l.Len())
+	}
+	unlock2()
+	if l.Len() != 0 {
+		t.Errorf("Expected 0 keys, got %d", l.Len())
+	}
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"os"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
@@ -11,15 +12,17 @@ import (
 // Logger wraps zap.SugaredLogger
 type Logger struct {
 	*zap.SugaredLogger
+	ring  *Ring // Recent log lines; nil when disabled
+	level zap.AtomicLevel
 }
 
+// defaultRingSize is how many recent log lines are kept in memory
+const defaultRingSize = 5000
+
 // New creates a new logger instance
 func New() *Logger {
-	// Determine log level from environment
-	level := zapcore.InfoLevel
-	if os.Getenv("NODE_ENV") == "development" {
-		level = zapcore.DebugLevel
-	}
+	// Determine log level from environment; it can be changed later with SetLevel
+	level := zap.NewAtomicLevelAt(LevelFromEnv())
 
 	// Custom encoder config for pretty output
 	encoderConfig := zapcore.EncoderConfig{
@@ -47,11 +50,53 @@ func New() *Logger {
 		level,
 	)
 
+	// Also keep recent lines in memory for the recent-logs endpoint
+	ringSize := defaultRingSize
+	if v, err := strconv.Atoi(os.Getenv("LOG_BUFFER_LINES")); err == nil && v >= 0 {
+		ringSize = v
+	}
+	var ring *Ring
+	if ringSize > 0 {
+		ring = NewRing(ringSize)
+		core = zapcore.NewTee(core, &ringCore{LevelEnabler: level, ring: ring})
+	}
+
+	// Credentials are redacted before any output, at every level
+	core = &redactCore{Core: core}
+
 	// Create logger
 	// Only add stack traces for DPanic level and above (panics), not for regular errors
 	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.DPanicLevel))
 
-	return &Logger{logger.Sugar()}
+	return &Logger{SugaredLogger: logger.Sugar(), ring: ring, level: level}
+}
+
+// LevelFromEnv returns LOG_LEVEL if it is a valid level, otherwise debug when
+// NODE_ENV is "development" and info in all other cases
+func LevelFromEnv() zapcore.Level {
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if level, err := zapcore.ParseLevel(v); err == nil {
+			return level
+		}
+	}
+	if os.Getenv("NODE_ENV") == "development" {
+		return zapcore.DebugLevel
+	}
+	return zapcore.InfoLevel
+}
+
+// SetLevel changes the minimum level of this logger and all loggers derived from it
+func (l *Logger) SetLevel(level zapcore.Level) {
+	l.level.SetLevel(level)
+}
+
+// Recent returns recent log lines at or above minLevel, oldest first
+// (nil when the buffer is disabled)
+func (l *Logger) Recent(minLevel zapcore.Level, limit int) []Entry {
+	if l.ring == nil {
+		return nil
+	}
+	return l.ring.Recent(minLevel, limit)
 }
 
 // customTimeEncoder formats time as YYYY-MM-DD HH:mm:ss.SSS
@@ -61,10 +106,10 @@ func customTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 
 // WithFields returns a logger with additional fields
 func (l *Logger) WithFields(fields ...interface{}) *Logger {
-	return &Logger{l.SugaredLogger.With(fields...)}
+	return &Logger{SugaredLogger: l.SugaredLogger.With(fields...), ring: l.ring, level: l.level}
 }
 
 // Named returns a named logger
 func (l *Logger) Named(name string) *Logger {
-	return &Logger{l.SugaredLogger.Named(name)}
+	return &Logger{SugaredLogger: l.SugaredLogger.Named(name), ring: l.ring, level: l.level}
 }
@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLevelFromEnv(t *testing.T) {
+	tests := []struct {
+		logLevel string
+		nodeEnv  string
+		want     zapcore.Level
+	}{
+		{"", "", zapcore.InfoLevel},
+		{"", "development", zapcore.DebugLevel},
+		{"warn", "development", zapcore.WarnLevel},
+		{"error", "", zapcore.ErrorLevel},
+		{"bogus", "", zapcore.InfoLevel},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("LOG_LEVEL", tt.logLevel)
+		t.Setenv("NODE_ENV", tt.nodeEnv)
+		if got := LevelFromEnv(); got != tt.want {
+			t.Errorf("LOG_LEVEL=%q NODE_ENV=%q: expected %s, got %s", tt.logLevel, tt.nodeEnv, tt.want, got)
+		}
+	}
+}
+
+func TestLogger_SetLevel(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("LOG_BUFFER_LINES", "10")
+	log := New()
+	named := log.Named("child")
+
+	named.Debug("hidden")
+	log.SetLevel(zapcore.DebugLevel)
+	named.Debug("shown")
+
+	entries := log.Recent(zapcore.DebugLevel, 0)
+	if len(entries) != 1 || entries[0].Message != "shown" {
+		t.Errorf("Expected only the entry logged after SetLevel, got %+v", entries)
+	}
+}
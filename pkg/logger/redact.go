@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// pemPattern matches PEM blocks, including ones cut off by truncation
+	pemPattern = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----(?s:.*?)(?:-----END [A-Z0-9 ]+-----|$)`)
+
+	// uuidPattern matches UUIDs, which are vless/vmess credentials
+	uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+	// credentialJSONPattern matches credential members of JSON documents by
+	// key suffix, so trojanPassword, ssPassword, vlessUuid and privateKey match
+	credentialJSONPattern = regexp.MustCompile(`(?i)("(?:pass|auth|[A-Za-z_]*(?:password|passwd|secret|key|token|uuid|psk|seed))"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+	// credentialParamPattern matches credentials in query strings and key=value text
+	credentialParamPattern = regexp.MustCompile(`(?i)\b([A-Za-z_]*(?:password|passwd|secret_key|secretkey|token|psk))=[^&\s"]+`)
+)
+
+// Redacted placeholders
+const (
+	redactedPEM   = "[REDACTED PEM]"
+	redactedUUID  = "[REDACTED UUID]"
+	redactedValue = "[REDACTED]"
+)
+
+// Redact removes credentials from s: PEM blocks (certificates and keys),
+// UUIDs, and password/key/token values in JSON or key=value form. It is
+// applied to every log message and field, and can be used directly on text
+// leaving the process by other routes, such as error reports.
+func Redact(s string) string {
+	s = pemPattern.ReplaceAllString(s, redactedPEM)
+	s = uuidPattern.ReplaceAllString(s, redactedUUID)
+	s = credentialJSONPattern.ReplaceAllString(s, `$1"`+redactedValue+`"`)
+	s = credentialParamPattern.ReplaceAllString(s, "$1="+redactedValue)
+	return s
+}
+
+// redactCore is a zapcore.Core redacting messages and fields before they
+// reach the wrapped core, so no log level or output ever sees credentials
+type redactCore struct {
+	zapcore.Core
+}
+
+// With redacts structured context before adding it to the core
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(redactFields(fields))}
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (c *redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write redacts the entry and writes it to the wrapped core
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns fields with the text of string-like values redacted
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = Redact(f.String)
+		case zapcore.ByteStringType:
+			f = zap.String(f.Key, Redact(string(f.Interface.([]byte))))
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok && err != nil {
+				f = zap.String(f.Key, Redact(err.Error()))
+			}
+		case zapcore.StringerType:
+			if stringer, ok := f.Interface.(fmt.Stringer); ok && stringer != nil {
+				f = zap.String(f.Key, Redact(stringer.String()))
+			}
+		case zapcore.ReflectType:
+			// Values logged with zap.Any are kept as their JSON text
+			if data, err := json.Marshal(f.Interface); err == nil {
+				f = zap.String(f.Key, Redact(string(data)))
+			} else {
+				f = zap.String(f.Key, Redact(fmt.Sprintf("%+v", f.Interface)))
+			}
+		}
+		redacted[i] = f
+	}
+	return redacted
+}
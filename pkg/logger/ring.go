@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Entry is a log line kept in the ring buffer
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Logger  string                 `json:"logger,omitempty"`
+	Caller  string                 `json:"caller,omitempty"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+
+	level zapcore.Level
+}
+
+// Ring keeps the most recent log entries in memory
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewRing creates a ring buffer holding up to size entries
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]Entry, size)}
+}
+
+// add stores an entry, overwriting the oldest when full
+func (r *Ring) add(entry Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns up to limit of the newest entries at or above minLevel,
+// oldest first. A limit of 0 returns all matching entries.
+func (r *Ring) Recent(minLevel zapcore.Level, limit int) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]Entry, 0)
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+
+	// Walk newest to oldest, then reverse
+	for i := 0; i < count; i++ {
+		idx := (r.next - 1 - i + len(r.entries)) % len(r.entries)
+		entry := r.entries[idx]
+		if entry.level < minLevel {
+			continue
+		}
+		result = append(result, entry)
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// ringCore is a zapcore.Core writing into a Ring
+type ringCore struct {
+	zapcore.LevelEnabler
+	ring   *Ring
+	fields []zapcore.Field
+}
+
+// With adds structured context to the core
+func (c *ringCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &ringCore{LevelEnabler: c.LevelEnabler, ring: c.ring}
+	clone.fields = append(append(clone.fields, c.fields...), fields...)
+	return clone
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (c *ringCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write stores the entry in the ring
+func (c *ringCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	e := Entry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Logger:  entry.LoggerName,
+		Message: entry.Message,
+		level:   entry.Level,
+	}
+	if entry.Caller.Defined {
+		e.Caller = entry.Caller.TrimmedPath()
+	}
+	if len(enc.Fields) > 0 {
+		e.Fields = enc.Fields
+	}
+
+	c.ring.add(e)
+	return nil
+}
+
+// Sync is a no-op; entries are kept in memory
+func (c *ringCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newRingLogger(size int) (*zap.Logger, *Ring) {
+	ring := NewRing(size)
+	return zap.New(&ringCore{LevelEnabler: zapcore.DebugLevel, ring: ring}), ring
+}
+
+func TestRing_KeepsNewestEntries(t *testing.T) {
+	log, ring := newRingLogger(3)
+	for _, msg := range []string{"a", "b", "c", "d", "e"} {
+		log.Info(msg)
+	}
+
+	entries := ring.Recent(zapcore.DebugLevel, 0)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	for i, want := range []string{"c", "d", "e"} {
+		if entries[i].Message != want {
+			t.Errorf("Entry %d: expected %q, got %q", i, want, entries[i].Message)
+		}
+	}
+}
+
+func TestRing_FiltersByLevelAndLimit(t *testing.T) {
+	log, ring := newRingLogger(10)
+	log.Info("info")
+	log.Warn("warn1")
+	log.Error("error")
+	log.Warn("warn2")
+
+	entries := ring.Recent(zapcore.WarnLevel, 0)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries at warn or above, got %d", len(entries))
+	}
+
+	entries = ring.Recent(zapcore.WarnLevel, 2)
+	if len(entries) != 2 || entries[0].Message != "error" || entries[1].Message != "warn2" {
+		t.Errorf("Expected the 2 newest entries oldest first, got %+v", entries)
+	}
+}
+
+func TestRing_RecordsFields(t *testing.T) {
+	log, ring := newRingLogger(10)
+	log.With(zap.String("tag", "vless")).Warn("inbound down", zap.Int("port", 443))
+
+	entries := ring.Recent(zapcore.DebugLevel, 0)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Fields["tag"] != "vless" || entries[0].Fields["port"] != int64(443) {
+		t.Errorf("Unexpected fields: %v", entries[0].Fields)
+	}
+	if entries[0].Level != "warn" {
+		t.Errorf("Expected level warn, got %s", entries[0].Level)
+	}
+}
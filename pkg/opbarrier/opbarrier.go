@@ -0,0 +1,100 @@
+// Package opbarrier provides a barrier between short operations and an
+// exclusive section that must not interleave with them, such as user
+// mutations and a rebuild of the core they apply to
+package opbarrier
+
+import (
+	"context"
+	"sync"
+)
+
+// Barrier lets any number of operations run at once until it is shut. Shut
+// waits for the operations inside to finish and holds new ones back until the
+// barrier is reopened. A nil Barrier never blocks.
+type Barrier struct {
+	mu      sync.Mutex
+	ops     int           // Operations inside the barrier
+	gate    chan struct{} // Non-nil while shut; closed when reopened
+	drained chan struct{} // Closed when ops reaches zero while shut
+}
+
+// New creates an open Barrier
+func New() *Barrier {
+	return &Barrier{}
+}
+
+// Enter waits until the barrier is open and returns the function that ends
+// the operation. It fails only if ctx is done first.
+func (b *Barrier) Enter(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	for {
+		b.mu.Lock()
+		gate := b.gate
+		if gate == nil {
+			b.ops++
+			b.mu.Unlock()
+			return b.leave, nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// leave ends an operation
+func (b *Barrier) leave() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ops--
+	if b.ops == 0 && b.drained != nil {
+		close(b.drained)
+		b.drained = nil
+	}
+}
+
+// Shut holds new operations back, waits for the ones inside to finish and
+// returns the function that reopens the barrier. A second Shut waits for the
+// first to reopen it.
+func (b *Barrier) Shut() func() {
+	if b == nil {
+		return func() {}
+	}
+
+	b.mu.Lock()
+	for b.gate != nil {
+		gate := b.gate
+		b.mu.Unlock()
+		<-gate
+		b.mu.Lock()
+	}
+	gate := make(chan struct{})
+	b.gate = gate
+	var drained chan struct{}
+	if b.ops > 0 {
+		drained = make(chan struct{})
+		b.drained = drained
+	}
+	b.mu.Unlock()
+
+	if drained != nil {
+		<-drained
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.gate = nil
+			b.mu.Unlock()
+			close(gate)
+		})
+	}
+}
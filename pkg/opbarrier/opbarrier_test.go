@@ -0,0 +1,85 @@
+package opbarrier
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBarrier_ShutWaitsForOperations(t *testing.T) {
+	b := New()
+
+	leave, err := b.Enter(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shut := make(chan func())
+	go func() {
+		shut <- b.Shut()
+	}()
+
+	select {
+	case <-shut:
+		t.Fatal("Expected Shut to wait for the operation inside")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	leave()
+	select {
+	case reopen := <-shut:
+		reopen()
+	case <-time.After(time.Second):
+		t.Fatal("Expected Shut to return once the operation ended")
+	}
+}
+
+func TestBarrier_EnterWaitsWhileShut(t *testing.T) {
+	b := New()
+	reopen := b.Shut()
+
+	entered := make(chan struct{})
+	go func() {
+		leave, err := b.Enter(context.Background())
+		if err == nil {
+			leave()
+		}
+		close(entered)
+	}()
+
+	select {
+	case <-entered:
+		t.Fatal("Expected Enter to wait while the barrier is shut")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	reopen()
+	reopen() // Reopening twice is harmless
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Enter to proceed once the barrier reopened")
+	}
+}
+
+func TestBarrier_EnterCanceled(t *testing.T) {
+	b := New()
+	reopen := b.Shut()
+	defer reopen()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.Enter(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
+
+func TestBarrier_Nil(t *testing.T) {
+	var b *Barrier
+	b.Shut()()
+	leave, err := b.Enter(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leave()
+}
@@ -0,0 +1,100 @@
+// Package reqtiming accumulates how long a request spends in named phases
+// (lock waits, core calls, ...) so slow requests can be broken down
+package reqtiming
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Common phase names
+const (
+	PhaseLockWait      = "lockWait"
+	PhaseCore          = "core"
+	PhaseSerialization = "serialization"
+)
+
+// Timings holds the accumulated duration and call count of each phase.
+// It is safe for concurrent use, since handlers may fan out.
+type Timings struct {
+	mu     sync.Mutex
+	phases map[string]*Phase
+}
+
+// Phase is the accumulated time spent in one phase
+type Phase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+	Calls    int           `json:"calls"`
+}
+
+type contextKey struct{}
+
+// WithTimings returns a context carrying a new Timings
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{phases: make(map[string]*Phase)}
+	return context.WithValue(ctx, contextKey{}, t), t
+}
+
+// FromContext returns the Timings of the context, or nil
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+// Add records a duration spent in a phase. A nil Timings ignores it.
+func (t *Timings) Add(phase string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, exists := t.phases[phase]
+	if !exists {
+		p = &Phase{Name: phase}
+		t.phases[phase] = p
+	}
+	p.Duration += d
+	p.Calls++
+}
+
+// Track starts timing a phase of the request in ctx and returns the function
+// ending it, for use as `defer reqtiming.Track(ctx, reqtiming.PhaseCore)()`.
+// It is a no-op when the context carries no Timings.
+func Track(ctx context.Context, phase string) func() {
+	t := FromContext(ctx)
+	if t == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		t.Add(phase, time.Since(start))
+	}
+}
+
+// Phases returns the recorded phases, longest first
+func (t *Timings) Phases() []Phase {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]Phase, 0, len(t.phases))
+	for _, p := range t.phases {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Duration != result[j].Duration {
+			return result[i].Duration > result[j].Duration
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
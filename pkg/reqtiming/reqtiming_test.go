@@ -0,0 +1,59 @@
+package reqtiming
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrack_AccumulatesPhases(t *testing.T) {
+	ctx, timings := WithTimings(context.Background())
+
+	timings.Add(PhaseCore, 30*time.Millisecond)
+	timings.Add(PhaseCore, 20*time.Millisecond)
+	timings.Add(PhaseLockWait, 10*time.Millisecond)
+
+	done := Track(ctx, PhaseSerialization)
+	done()
+
+	phases := timings.Phases()
+	if len(phases) != 3 {
+		t.Fatalf("Expected 3 phases, got %d", len(phases))
+	}
+	if phases[0].Name != PhaseCore || phases[0].Duration != 50*time.Millisecond || phases[0].Calls != 2 {
+		t.Errorf("Expected core 50ms over 2 calls first, got %+v", phases[0])
+	}
+	if phases[1].Name != PhaseLockWait {
+		t.Errorf("Expected lockWait second, got %s", phases[1].Name)
+	}
+}
+
+func TestTrack_NoTimingsInContext(t *testing.T) {
+	// Must not panic without timings
+	Track(context.Background(), PhaseCore)()
+
+	var timings *Timings
+	timings.Add(PhaseCore, time.Second)
+	if phases := timings.Phases(); phases != nil {
+		t.Errorf("Expected no phases, got %v", phases)
+	}
+}
+
+func TestTrack_Concurrent(t *testing.T) {
+	ctx, timings := WithTimings(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Track(ctx, PhaseCore)()
+		}()
+	}
+	wg.Wait()
+
+	if phases := timings.Phases(); len(phases) != 1 || phases[0].Calls != 50 {
+		t.Errorf("Expected 50 core calls, got %+v", phases)
+	}
+}
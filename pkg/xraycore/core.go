@@ -5,24 +5,31 @@ package xraycore
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/clash-version/remnawave-node-go/pkg/reqtiming"
+
 	// Xray-core imports
 	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
 	"github.com/xtls/xray-core/infra/conf/serial"
 
 	// Services for direct API access
+	applog "github.com/xtls/xray-core/app/log"
 	routerConfig "github.com/xtls/xray-core/app/router"
 	appstats "github.com/xtls/xray-core/app/stats"
 	"github.com/xtls/xray-core/common/protocol"
 	cserial "github.com/xtls/xray-core/common/serial"
 	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/features/outbound"
 	"github.com/xtls/xray-core/features/routing"
 	"github.com/xtls/xray-core/features/stats"
 	"github.com/xtls/xray-core/proxy"
@@ -33,13 +40,29 @@ import (
 
 // Instance represents an embedded Xray-core instance
 type Instance struct {
-	mu        sync.RWMutex
-	logger    *zap.Logger
-	instance  *core.Instance
-	config    []byte // Current config JSON
-	version   string
-	running   bool
-	startTime time.Time
+	// lifecycleMu serializes Start/Stop; mu only guards field access so
+	// readers never wait for a slow core startup
+	lifecycleMu sync.Mutex
+	mu          sync.RWMutex
+	logger      *zap.Logger
+	instance    *core.Instance
+	config      []byte // Current config JSON
+	version     string
+	running     bool
+	startTime   time.Time
+
+	// Emails removed with RemoveUser since the instance started (see OrphanedUserStats)
+	removedMu    sync.Mutex
+	removedUsers map[string]struct{}
+
+	// Rules added at runtime, by tag (see RoutingRules)
+	rulesMu sync.Mutex
+	rules   map[string]*RoutingRule
+
+	// Traffic taken out of counters by resets and SubtractStats since the
+	// instance started, by counter name (see GetCumulativeStats)
+	drainedMu sync.Mutex
+	drained   map[string]int64
 }
 
 // Config for creating a new Instance
@@ -67,18 +90,29 @@ func (x *Instance) IsRunning() bool {
 	return x.running && x.instance != nil
 }
 
-// Start starts Xray with the given JSON configuration
-func (x *Instance) Start(ctx context.Context, configJSON []byte) error {
+// detach removes the current instance from x and returns it for closing
+func (x *Instance) detach() *core.Instance {
 	x.mu.Lock()
 	defer x.mu.Unlock()
 
+	old := x.instance
+	x.instance = nil
+	x.running = false
+	return old
+}
+
+// Start starts Xray with the given JSON configuration
+func (x *Instance) Start(ctx context.Context, configJSON []byte) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.lifecycleMu.Lock()
+	defer x.lifecycleMu.Unlock()
+
 	// Stop existing instance if running
-	if x.instance != nil {
-		if err := x.instance.Close(); err != nil {
+	if old := x.detach(); old != nil {
+		if err := old.Close(); err != nil {
 			x.logger.Warn("Error closing existing Xray instance", zap.Error(err))
 		}
-		x.instance = nil
-		x.running = false
 	}
 
 	x.logger.Info("Starting Xray-core", zap.String("version", x.version))
@@ -105,10 +139,21 @@ func (x *Instance) Start(ctx context.Context, configJSON []byte) error {
 		return fmt.Errorf("failed to start Xray instance: %w", err)
 	}
 
+	x.mu.Lock()
 	x.instance = instance
 	x.config = configJSON
 	x.running = true
 	x.startTime = time.Now()
+	x.mu.Unlock()
+
+	x.removedMu.Lock()
+	x.removedUsers = make(map[string]struct{})
+	x.removedMu.Unlock()
+	x.forgetRules()
+
+	x.drainedMu.Lock()
+	x.drained = make(map[string]int64)
+	x.drainedMu.Unlock()
 
 	x.logger.Info("Xray-core started successfully")
 	return nil
@@ -116,22 +161,29 @@ func (x *Instance) Start(ctx context.Context, configJSON []byte) error {
 
 // Stop stops the Xray instance
 func (x *Instance) Stop() error {
-	x.mu.Lock()
-	defer x.mu.Unlock()
+	x.lifecycleMu.Lock()
+	defer x.lifecycleMu.Unlock()
 
-	if x.instance == nil {
+	x.mu.RLock()
+	current := x.instance
+	x.mu.RUnlock()
+
+	if current == nil {
 		return nil
 	}
 
 	x.logger.Info("Stopping Xray-core")
 
-	if err := x.instance.Close(); err != nil {
+	if err := current.Close(); err != nil {
 		return fmt.Errorf("failed to stop Xray instance: %w", err)
 	}
 
+	x.mu.Lock()
 	x.instance = nil
 	x.running = false
 	x.config = nil
+	x.mu.Unlock()
+	x.forgetRules()
 
 	x.logger.Info("Xray-core stopped")
 	return nil
@@ -142,6 +194,29 @@ func (x *Instance) Restart(ctx context.Context, configJSON []byte) error {
 	return x.Start(ctx, configJSON)
 }
 
+// ReopenLogs closes and reopens the core's access and error log files, e.g.
+// after they were rotated
+func (x *Instance) ReopenLogs() error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return nil
+	}
+
+	logger, ok := x.instance.GetFeature((*applog.Instance)(nil)).(*applog.Instance)
+	if !ok {
+		return fmt.Errorf("log feature not found")
+	}
+	if err := logger.Close(); err != nil {
+		return fmt.Errorf("failed to close core logs: %w", err)
+	}
+	if err := logger.Start(); err != nil {
+		return fmt.Errorf("failed to reopen core logs: %w", err)
+	}
+	return nil
+}
+
 // ============= Handler Service (User Management) =============
 
 // getInboundProxy gets the inbound proxy from a handler
@@ -167,6 +242,8 @@ func (x *Instance) getInboundProxy(ctx context.Context, inboundTag string) (prox
 
 // AddUser adds a user to an inbound using MemoryUser
 func (x *Instance) AddUser(ctx context.Context, inboundTag string, user *protocol.MemoryUser) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -184,11 +261,19 @@ func (x *Instance) AddUser(ctx context.Context, inboundTag string, user *protoco
 		return fmt.Errorf("inbound does not support user management")
 	}
 
-	return um.AddUser(ctx, user)
+	if err := um.AddUser(ctx, user); err != nil {
+		return err
+	}
+	x.removedMu.Lock()
+	delete(x.removedUsers, user.Email)
+	x.removedMu.Unlock()
+	return nil
 }
 
 // RemoveUser removes a user from an inbound
 func (x *Instance) RemoveUser(ctx context.Context, inboundTag string, email string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -206,13 +291,181 @@ func (x *Instance) RemoveUser(ctx context.Context, inboundTag string, email stri
 		return fmt.Errorf("inbound does not support user management")
 	}
 
-	return um.RemoveUser(ctx, email)
+	if err := um.RemoveUser(ctx, email); err != nil {
+		return err
+	}
+	x.removedMu.Lock()
+	if x.removedUsers != nil {
+		x.removedUsers[email] = struct{}{}
+	}
+	x.removedMu.Unlock()
+	return nil
+}
+
+// GetInboundUser returns a user of an inbound by email, or nil if not present
+func (x *Instance) GetInboundUser(ctx context.Context, inboundTag string, email string) (*protocol.MemoryUser, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return nil, fmt.Errorf("Xray instance not running")
+	}
+
+	inboundProxy, err := x.getInboundProxy(ctx, inboundTag)
+	if err != nil {
+		return nil, err
+	}
+
+	um, ok := inboundProxy.(proxy.UserManager)
+	if !ok {
+		return nil, fmt.Errorf("inbound does not support user management")
+	}
+
+	return um.GetUser(ctx, email), nil
+}
+
+// GetInboundUsers returns all users of an inbound
+func (x *Instance) GetInboundUsers(ctx context.Context, inboundTag string) ([]*protocol.MemoryUser, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return nil, fmt.Errorf("Xray instance not running")
+	}
+
+	inboundProxy, err := x.getInboundProxy(ctx, inboundTag)
+	if err != nil {
+		return nil, err
+	}
+
+	um, ok := inboundProxy.(proxy.UserManager)
+	if !ok {
+		return nil, fmt.Errorf("inbound does not support user management")
+	}
+
+	return um.GetUsers(ctx), nil
+}
+
+// ReloadInbound rebuilds an inbound from the running config, e.g. to pick up
+// rotated certificate files. The users it had at runtime are carried over, so
+// users added or removed since the start stay that way. If the rebuilt inbound
+// fails to start, the previous one is put back.
+func (x *Instance) ReloadInbound(ctx context.Context, inboundTag string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	var parsed struct {
+		Inbounds []json.RawMessage `json:"inbounds"`
+	}
+	if err := json.Unmarshal(x.config, &parsed); err != nil {
+		return fmt.Errorf("failed to parse running config: %w", err)
+	}
+	var detour *conf.InboundDetourConfig
+	for _, raw := range parsed.Inbounds {
+		var candidate conf.InboundDetourConfig
+		if err := json.Unmarshal(raw, &candidate); err != nil {
+			return fmt.Errorf("invalid inbound: %w", err)
+		}
+		if candidate.Tag == inboundTag {
+			detour = &candidate
+			break
+		}
+	}
+	if detour == nil {
+		return fmt.Errorf("inbound %q not found in running config", inboundTag)
+	}
+	handlerConfig, err := detour.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build inbound: %w", err)
+	}
+
+	// The runtime users, which may differ from the config's, are put back
+	// exactly after the rebuild
+	var users []*protocol.MemoryUser
+	captured := false
+	if inboundProxy, err := x.getInboundProxy(ctx, inboundTag); err == nil {
+		if um, ok := inboundProxy.(proxy.UserManager); ok {
+			users = um.GetUsers(ctx)
+			captured = true
+		}
+	}
+
+	ihm, ok := x.instance.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	if !ok {
+		return fmt.Errorf("inbound manager not found")
+	}
+	previous, err := ihm.GetHandler(ctx, inboundTag)
+	if err != nil {
+		return fmt.Errorf("failed to get inbound: %w", err)
+	}
+	if err := ihm.RemoveHandler(ctx, inboundTag); err != nil {
+		return fmt.Errorf("failed to remove inbound: %w", err)
+	}
+	if err := core.AddInboundHandler(x.instance, handlerConfig); err != nil {
+		// A handler that failed to start stays registered under the tag
+		_ = ihm.RemoveHandler(ctx, inboundTag)
+		if restoreErr := ihm.AddHandler(ctx, previous); restoreErr != nil {
+			return fmt.Errorf("failed to add inbound: %w (restoring the previous one failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("failed to add inbound, kept the previous one: %w", err)
+	}
+
+	if !captured {
+		return nil
+	}
+	inboundProxy, err := x.getInboundProxy(ctx, inboundTag)
+	if err != nil {
+		return err
+	}
+	um, ok := inboundProxy.(proxy.UserManager)
+	if !ok {
+		return nil
+	}
+
+	keep := make(map[string]struct{}, len(users))
+	for _, user := range users {
+		keep[user.Email] = struct{}{}
+		if um.GetUser(ctx, user.Email) != nil {
+			continue
+		}
+		if err := um.AddUser(ctx, user); err != nil {
+			x.logger.Warn("Failed to restore user after inbound reload",
+				zap.String("tag", inboundTag),
+				zap.String("email", user.Email),
+				zap.Error(err))
+		}
+	}
+	// Config users removed at runtime stay removed
+	for _, user := range um.GetUsers(ctx) {
+		if _, exists := keep[user.Email]; exists {
+			continue
+		}
+		if err := um.RemoveUser(ctx, user.Email); err != nil {
+			x.logger.Warn("Failed to remove user after inbound reload",
+				zap.String("tag", inboundTag),
+				zap.String("email", user.Email),
+				zap.Error(err))
+		}
+	}
+	return nil
 }
 
 // ============= Stats Service =============
 
 // GetStats gets stats by pattern
 func (x *Instance) GetStats(ctx context.Context, pattern string, reset bool) (map[string]int64, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -236,7 +489,7 @@ func (x *Instance) GetStats(ctx context.Context, pattern string, reset bool) (ma
 	manager.VisitCounters(func(name string, counter stats.Counter) bool {
 		if pattern == "" || matchPattern(name, pattern) {
 			if reset {
-				result[name] = counter.Set(0)
+				result[name] = x.drain(name, counter)
 			} else {
 				result[name] = counter.Value()
 			}
@@ -247,8 +500,65 @@ func (x *Instance) GetStats(ctx context.Context, pattern string, reset bool) (ma
 	return result, nil
 }
 
+// GetCumulativeStats gets stats by pattern like GetStats, adding back the
+// traffic that resets and SubtractStats took out of each counter, so values
+// only grow while the instance runs. Consumers tracking traffic on their own
+// (budgets, metrics) use it to stay unaffected by panel resets.
+func (x *Instance) GetCumulativeStats(ctx context.Context, pattern string) (map[string]int64, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return nil, fmt.Errorf("Xray instance not running")
+	}
+
+	manager, ok := x.instance.GetFeature(stats.ManagerType()).(*appstats.Manager)
+	if !ok {
+		return nil, fmt.Errorf("stats manager does not support VisitCounters")
+	}
+
+	// Held while visiting so a concurrent reset is counted exactly once
+	x.drainedMu.Lock()
+	defer x.drainedMu.Unlock()
+
+	result := make(map[string]int64)
+	manager.VisitCounters(func(name string, counter stats.Counter) bool {
+		if pattern == "" || matchPattern(name, pattern) {
+			result[name] = counter.Value() + x.drained[name]
+		}
+		return true
+	})
+
+	return result, nil
+}
+
+// drain resets a counter, remembering its value for GetCumulativeStats
+func (x *Instance) drain(name string, counter stats.Counter) int64 {
+	x.drainedMu.Lock()
+	defer x.drainedMu.Unlock()
+
+	value := counter.Set(0)
+	x.addDrained(name, value)
+	return value
+}
+
+// addDrained records traffic taken out of a counter (drainedMu held)
+func (x *Instance) addDrained(name string, value int64) {
+	if value <= 0 {
+		return
+	}
+	if x.drained == nil {
+		x.drained = make(map[string]int64)
+	}
+	x.drained[name] += value
+}
+
 // GetSystemStats returns Xray system statistics
 func (x *Instance) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -306,10 +616,52 @@ func (x *Instance) GetUserOnlineStatus(ctx context.Context, email string) (bool,
 	return false, nil
 }
 
+// GetOnlineUsers returns the users with live connections, from the per-user
+// online maps (requires statsUserOnline in the policy)
+func (x *Instance) GetOnlineUsers(ctx context.Context) ([]string, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return nil, fmt.Errorf("Xray instance not running")
+	}
+
+	statsFeature := x.instance.GetFeature(stats.ManagerType())
+	if statsFeature == nil {
+		return nil, fmt.Errorf("stats feature not found")
+	}
+	manager, ok := statsFeature.(*appstats.Manager)
+	if !ok {
+		return nil, fmt.Errorf("stats manager does not support VisitCounters")
+	}
+
+	// Online maps cannot be listed, so look them up by the users' traffic counters.
+	// Lookups happen after the visit, which holds the manager lock.
+	seen := make(map[string]struct{})
+	manager.VisitCounters(func(name string, _ stats.Counter) bool {
+		if parts := strings.Split(name, ">>>"); len(parts) == 4 && parts[0] == "user" {
+			seen[parts[1]] = struct{}{}
+		}
+		return true
+	})
+
+	users := make([]string, 0)
+	for email := range seen {
+		if om := manager.GetOnlineMap("user>>>" + email + ">>>online"); om != nil && om.Count() > 0 {
+			users = append(users, email)
+		}
+	}
+	return users, nil
+}
+
 // ============= Router Service (IP Blocking) =============
 
 // AddRoutingRule adds a routing rule to block an IP
 func (x *Instance) AddRoutingRule(ctx context.Context, ruleTag string, targetIP string, outboundTag string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -327,24 +679,142 @@ func (x *Instance) AddRoutingRule(ctx context.Context, ruleTag string, targetIP
 		return fmt.Errorf("feature is not a Router")
 	}
 
-	// Create routing rule using xray-core's RoutingRule proto message
-	// Import the router package and use its RoutingRule type
-	rule := &routerConfig.RoutingRule{
-		RuleTag: ruleTag,
-		TargetTag: &routerConfig.RoutingRule_Tag{
-			Tag: outboundTag,
+	// The router silently falls back to the default outbound for unknown
+	// tags, so the IP would be reported blocked while its traffic still flows
+	if ohm, ok := x.instance.GetFeature(outbound.ManagerType()).(outbound.Manager); ok {
+		if ohm.GetHandler(outboundTag) == nil {
+			return fmt.Errorf("outbound %q not found", outboundTag)
+		}
+	}
+
+	// Router.AddRule expects a full router Config; shouldAppend keeps existing rules
+	config := &routerConfig.Config{
+		Rule: []*routerConfig.RoutingRule{
+			{
+				RuleTag: ruleTag,
+				TargetTag: &routerConfig.RoutingRule_Tag{
+					Tag: outboundTag,
+				},
+				SourceGeoip: []*routerConfig.GeoIP{
+					{
+						Cidr: []*routerConfig.CIDR{
+							parseCIDR(targetIP),
+						},
+					},
+				},
+			},
 		},
-		SourceGeoip: []*routerConfig.GeoIP{
+	}
+
+	if err := r.AddRule(cserial.ToTypedMessage(config), true); err != nil {
+		return err
+	}
+	x.mirrorRule(&RoutingRule{
+		RuleTag:     ruleTag,
+		OutboundTag: outboundTag,
+		Matchers:    map[string]interface{}{"source": []string{targetIP}},
+	})
+	return nil
+}
+
+// AddUserRoutingRule routes all traffic of the given user emails to an outbound.
+// The rule is appended after the configured rules, so it only applies to traffic
+// no earlier rule matched.
+func (x *Instance) AddUserRoutingRule(ctx context.Context, ruleTag string, emails []string, outboundTag string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	routerFeature := x.instance.GetFeature(routing.RouterType())
+	if routerFeature == nil {
+		return fmt.Errorf("router feature not found")
+	}
+
+	r, ok := routerFeature.(routing.Router)
+	if !ok {
+		return fmt.Errorf("feature is not a Router")
+	}
+
+	// The router silently falls back to the default outbound for unknown tags
+	if ohm, ok := x.instance.GetFeature(outbound.ManagerType()).(outbound.Manager); ok {
+		if ohm.GetHandler(outboundTag) == nil {
+			return fmt.Errorf("outbound %q not found", outboundTag)
+		}
+	}
+
+	// Router.AddRule expects a full router Config; shouldAppend keeps existing rules
+	config := &routerConfig.Config{
+		Rule: []*routerConfig.RoutingRule{
 			{
-				Cidr: []*routerConfig.CIDR{
-					parseCIDR(targetIP),
+				RuleTag: ruleTag,
+				TargetTag: &routerConfig.RoutingRule_Tag{
+					Tag: outboundTag,
 				},
+				UserEmail: emails,
 			},
 		},
 	}
 
-	ruleMsg := cserial.ToTypedMessage(rule)
-	return r.AddRule(ruleMsg, false)
+	if err := r.AddRule(cserial.ToTypedMessage(config), true); err != nil {
+		return err
+	}
+	x.mirrorRule(&RoutingRule{
+		RuleTag:     ruleTag,
+		OutboundTag: outboundTag,
+		Matchers:    map[string]interface{}{"user": append([]string{}, emails...)},
+	})
+	return nil
+}
+
+// AddRoutingRuleJSON adds a routing rule given in Xray JSON config format.
+// The rule must carry a ruleTag so it can be removed later; it is appended
+// after the configured rules.
+func (x *Instance) AddRoutingRuleJSON(ctx context.Context, ruleJSON []byte) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	r, ok := x.instance.GetFeature(routing.RouterType()).(routing.Router)
+	if !ok {
+		return fmt.Errorf("router feature not found")
+	}
+
+	rule, err := conf.ParseRule(ruleJSON)
+	if err != nil {
+		return fmt.Errorf("invalid routing rule: %w", err)
+	}
+	if rule.RuleTag == "" {
+		return fmt.Errorf("routing rule has no ruleTag")
+	}
+
+	config := &routerConfig.Config{Rule: []*routerConfig.RoutingRule{rule}}
+	if err := r.AddRule(cserial.ToTypedMessage(config), true); err != nil {
+		return err
+	}
+
+	// Matchers as given, without the fields that name the rule and its target
+	var matchers map[string]interface{}
+	_ = json.Unmarshal(ruleJSON, &matchers) // Parsed above
+	for _, key := range []string{"ruleTag", "outboundTag", "balancerTag", "type"} {
+		delete(matchers, key)
+	}
+	x.mirrorRule(&RoutingRule{
+		RuleTag:     rule.RuleTag,
+		OutboundTag: rule.GetTag(),
+		BalancerTag: rule.GetBalancingTag(),
+		Matchers:    matchers,
+	})
+	return nil
 }
 
 // parseCIDR parses an IP or CIDR string into a CIDR proto message
@@ -391,6 +861,8 @@ func parseIPToBytes(ip string) []byte {
 
 // RemoveRoutingRule removes a routing rule by tag
 func (x *Instance) RemoveRoutingRule(ctx context.Context, ruleTag string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -408,7 +880,119 @@ func (x *Instance) RemoveRoutingRule(ctx context.Context, ruleTag string) error
 		return fmt.Errorf("feature is not a Router")
 	}
 
-	return r.RemoveRule(ruleTag)
+	if err := r.RemoveRule(ruleTag); err != nil {
+		return err
+	}
+	x.rulesMu.Lock()
+	delete(x.rules, ruleTag)
+	x.rulesMu.Unlock()
+	return nil
+}
+
+// RoutingRule is a router rule added at runtime. The router cannot list its
+// rules, so the Instance mirrors the ones added through it.
+type RoutingRule struct {
+	RuleTag     string                 `json:"ruleTag"`
+	OutboundTag string                 `json:"outboundTag,omitempty"`
+	BalancerTag string                 `json:"balancerTag,omitempty"`
+	Matchers    map[string]interface{} `json:"matchers"` // In Xray rule syntax, e.g. "source" or "user"
+	AddedAt     time.Time              `json:"addedAt"`
+	Installed   bool                   `json:"installed"` // Confirmed present in the router when listed
+}
+
+// mirrorRule records a rule added to the router, replacing one with its tag
+func (x *Instance) mirrorRule(rule *RoutingRule) {
+	rule.AddedAt = time.Now()
+
+	x.rulesMu.Lock()
+	defer x.rulesMu.Unlock()
+	if x.rules == nil {
+		x.rules = make(map[string]*RoutingRule)
+	}
+	x.rules[rule.RuleTag] = rule
+}
+
+// forgetRules drops the mirrored rules, which go with the router they were added to
+func (x *Instance) forgetRules() {
+	x.rulesMu.Lock()
+	x.rules = nil
+	x.rulesMu.Unlock()
+}
+
+// RoutingRules returns the rules added at runtime, oldest first. Each rule is
+// checked against the router, so rules removed behind the Instance's back
+// are reported as not installed.
+func (x *Instance) RoutingRules(ctx context.Context) []*RoutingRule {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var checker interface{ RuleExists(tag string) bool }
+	if x.instance != nil {
+		checker, _ = x.instance.GetFeature(routing.RouterType()).(interface{ RuleExists(tag string) bool })
+	}
+
+	x.rulesMu.Lock()
+	defer x.rulesMu.Unlock()
+
+	rules := make([]*RoutingRule, 0, len(x.rules))
+	for _, rule := range x.rules {
+		copied := *rule
+		copied.Installed = checker == nil || checker.RuleExists(rule.RuleTag)
+		rules = append(rules, &copied)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].AddedAt.Equal(rules[j].AddedAt) {
+			return rules[i].AddedAt.Before(rules[j].AddedAt)
+		}
+		return rules[i].RuleTag < rules[j].RuleTag
+	})
+	return rules
+}
+
+// ============= Outbound Service =============
+
+// AddOutbound adds an outbound handler given in Xray JSON config format
+func (x *Instance) AddOutbound(ctx context.Context, outboundJSON []byte) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	var detour conf.OutboundDetourConfig
+	if err := json.Unmarshal(outboundJSON, &detour); err != nil {
+		return fmt.Errorf("invalid outbound: %w", err)
+	}
+	handlerConfig, err := detour.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build outbound: %w", err)
+	}
+
+	return core.AddOutboundHandler(x.instance, handlerConfig)
+}
+
+// RemoveOutbound removes an outbound handler by tag
+func (x *Instance) RemoveOutbound(ctx context.Context, tag string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	ohm, ok := x.instance.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if !ok {
+		return fmt.Errorf("outbound manager not found")
+	}
+
+	return ohm.RemoveHandler(ctx, tag)
 }
 
 // ============= Helper Functions =============
@@ -485,6 +1069,24 @@ func CipherTypeFromInt(t int) shadowsocks.CipherType {
 	}
 }
 
+// CipherTypeFromMethod converts a config method name to shadowsocks.CipherType
+func CipherTypeFromMethod(method string) (shadowsocks.CipherType, error) {
+	switch strings.ToLower(method) {
+	case "aes-128-gcm", "aead_aes_128_gcm":
+		return shadowsocks.CipherType_AES_128_GCM, nil
+	case "aes-256-gcm", "aead_aes_256_gcm":
+		return shadowsocks.CipherType_AES_256_GCM, nil
+	case "chacha20-poly1305", "chacha20-ietf-poly1305", "aead_chacha20_poly1305":
+		return shadowsocks.CipherType_CHACHA20_POLY1305, nil
+	case "xchacha20-poly1305", "xchacha20-ietf-poly1305", "aead_xchacha20_poly1305":
+		return shadowsocks.CipherType_XCHACHA20_POLY1305, nil
+	case "none", "plain":
+		return shadowsocks.CipherType_NONE, nil
+	default:
+		return shadowsocks.CipherType_UNKNOWN, fmt.Errorf("unsupported shadowsocks method: %s", method)
+	}
+}
+
 // UserStats represents user traffic statistics
 type UserStats struct {
 	Email    string `json:"email"`
@@ -494,6 +1096,8 @@ type UserStats struct {
 
 // GetUserStats gets traffic statistics for a specific user
 func (x *Instance) GetUserStats(ctx context.Context, email string, reset bool) (*UserStats, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -513,7 +1117,7 @@ func (x *Instance) GetUserStats(ctx context.Context, email string, reset bool) (
 	uplinkName := fmt.Sprintf("user>>>%s>>>traffic>>>uplink", email)
 	if counter := manager.GetCounter(uplinkName); counter != nil {
 		if reset {
-			result.Uplink = counter.Set(0)
+			result.Uplink = x.drain(uplinkName, counter)
 		} else {
 			result.Uplink = counter.Value()
 		}
@@ -523,7 +1127,7 @@ func (x *Instance) GetUserStats(ctx context.Context, email string, reset bool) (
 	downlinkName := fmt.Sprintf("user>>>%s>>>traffic>>>downlink", email)
 	if counter := manager.GetCounter(downlinkName); counter != nil {
 		if reset {
-			result.Downlink = counter.Set(0)
+			result.Downlink = x.drain(downlinkName, counter)
 		} else {
 			result.Downlink = counter.Value()
 		}
@@ -534,6 +1138,125 @@ func (x *Instance) GetUserStats(ctx context.Context, email string, reset bool) (
 
 // GetAllUserStats gets traffic statistics for all users
 func (x *Instance) GetAllUserStats(ctx context.Context, reset bool) ([]*UserStats, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	return x.collectUserStats(nil, reset)
+}
+
+// GetUsersStats gets traffic statistics for the given users in a single pass
+// over the counters. Users without counters are reported with zero traffic.
+func (x *Instance) GetUsersStats(ctx context.Context, emails []string, reset bool) ([]*UserStats, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	filter := make(map[string]struct{}, len(emails))
+	for _, email := range emails {
+		filter[email] = struct{}{}
+	}
+
+	found, err := x.collectUserStats(filter, reset)
+	if err != nil {
+		return nil, err
+	}
+
+	byEmail := make(map[string]*UserStats, len(found))
+	for _, s := range found {
+		byEmail[s.Email] = s
+	}
+
+	result := make([]*UserStats, 0, len(filter))
+	for email := range filter {
+		if s, exists := byEmail[email]; exists {
+			result = append(result, s)
+		} else {
+			result = append(result, &UserStats{Email: email})
+		}
+	}
+
+	return result, nil
+}
+
+// OrphanedUserStats returns the traffic counters of users removed with
+// RemoveUser that no inbound has anymore. The stats manager keeps a counter
+// until it is unregistered, so these are still reported with the other users.
+// Users the node never removed are left alone, as inbounds without user
+// management may still count traffic for them.
+func (x *Instance) OrphanedUserStats(ctx context.Context) ([]*UserStats, error) {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.removedMu.Lock()
+	removed := make(map[string]struct{}, len(x.removedUsers))
+	for email := range x.removedUsers {
+		removed[email] = struct{}{}
+	}
+	x.removedMu.Unlock()
+	if len(removed) == 0 {
+		return []*UserStats{}, nil
+	}
+
+	live, err := x.inboundUserEmails(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found, err := x.collectUserStats(removed, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*UserStats, 0, len(found))
+	counted := make(map[string]struct{}, len(found))
+	for _, s := range found {
+		counted[s.Email] = struct{}{}
+		if _, exists := live[s.Email]; !exists {
+			result = append(result, s)
+		}
+	}
+
+	// Removed users without counters have nothing left to drop
+	x.removedMu.Lock()
+	for email := range removed {
+		if _, exists := counted[email]; !exists {
+			delete(x.removedUsers, email)
+		}
+	}
+	x.removedMu.Unlock()
+
+	return result, nil
+}
+
+// inboundUserEmails returns the emails of the users of every inbound
+func (x *Instance) inboundUserEmails(ctx context.Context) (map[string]struct{}, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return nil, fmt.Errorf("Xray instance not running")
+	}
+
+	handler := x.instance.GetFeature(inbound.ManagerType())
+	if handler == nil {
+		return nil, fmt.Errorf("inbound handler manager not found")
+	}
+
+	emails := make(map[string]struct{})
+	for _, inboundHandler := range handler.(inbound.Manager).ListHandlers(ctx) {
+		gi, ok := inboundHandler.(proxy.GetInbound)
+		if !ok {
+			continue
+		}
+		um, ok := gi.GetInbound().(proxy.UserManager)
+		if !ok {
+			continue
+		}
+		for _, user := range um.GetUsers(ctx) {
+			emails[user.Email] = struct{}{}
+		}
+	}
+	return emails, nil
+}
+
+// collectUserStats visits user traffic counters once, optionally restricted to
+// a set of emails (nil means all users)
+func (x *Instance) collectUserStats(filter map[string]struct{}, reset bool) ([]*UserStats, error) {
 	x.mu.RLock()
 	defer x.mu.RUnlock()
 
@@ -567,13 +1290,19 @@ func (x *Instance) GetAllUserStats(ctx context.Context, reset bool) ([]*UserStat
 		email := parts[1]
 		direction := parts[3]
 
+		if filter != nil {
+			if _, wanted := filter[email]; !wanted {
+				return true
+			}
+		}
+
 		if _, exists := userTraffic[email]; !exists {
 			userTraffic[email] = &UserStats{Email: email}
 		}
 
 		var value int64
 		if reset {
-			value = counter.Set(0)
+			value = x.drain(name, counter)
 		} else {
 			value = counter.Value()
 		}
@@ -595,6 +1324,76 @@ func (x *Instance) GetAllUserStats(ctx context.Context, reset bool) ([]*UserStat
 	return result, nil
 }
 
+// SubtractStats subtracts previously collected values from the named counters,
+// preserving traffic that accrued since they were read. Counters that dropped
+// below the subtracted value (e.g. after a core restart) are clamped to zero.
+func (x *Instance) SubtractStats(ctx context.Context, values map[string]int64) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	statsFeature := x.instance.GetFeature(stats.ManagerType())
+	if statsFeature == nil {
+		return fmt.Errorf("stats feature not found")
+	}
+
+	x.drainedMu.Lock()
+	defer x.drainedMu.Unlock()
+
+	manager := statsFeature.(stats.Manager)
+	for name, value := range values {
+		counter := manager.GetCounter(name)
+		if counter == nil || value == 0 {
+			continue
+		}
+		if left := counter.Add(-value); left < 0 {
+			counter.Set(0)
+			x.addDrained(name, value+left)
+		} else {
+			x.addDrained(name, value)
+		}
+	}
+
+	return nil
+}
+
+// UnregisterStats removes the named counters from the stats manager, e.g. those
+// of users and inbounds that are gone. Counters still held by a live inbound
+// stop being updated once removed, so only counters nothing uses may be passed.
+func (x *Instance) UnregisterStats(ctx context.Context, names []string) error {
+	defer reqtiming.Track(ctx, reqtiming.PhaseCore)()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.instance == nil {
+		return fmt.Errorf("Xray instance not running")
+	}
+
+	statsFeature := x.instance.GetFeature(stats.ManagerType())
+	if statsFeature == nil {
+		return fmt.Errorf("stats feature not found")
+	}
+
+	x.drainedMu.Lock()
+	defer x.drainedMu.Unlock()
+
+	manager := statsFeature.(stats.Manager)
+	for _, name := range names {
+		if err := manager.UnregisterCounter(name); err != nil {
+			return fmt.Errorf("failed to unregister counter %s: %w", name, err)
+		}
+		delete(x.drained, name)
+	}
+
+	return nil
+}
+
 // GetConfig returns the current configuration JSON
 func (x *Instance) GetConfig() []byte {
 	x.mu.RLock()
@@ -602,6 +1401,15 @@ func (x *Instance) GetConfig() []byte {
 	return x.config
 }
 
+// StartTime returns when the current instance was started, zero if it never
+// was. Each start creates a new stats manager, so counters read before a
+// different start time belong to a previous instance.
+func (x *Instance) StartTime() time.Time {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.startTime
+}
+
 // Uptime returns seconds since start
 func (x *Instance) Uptime() int64 {
 	x.mu.RLock()
@@ -0,0 +1,214 @@
+package xraycore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/features/stats"
+	_ "github.com/xtls/xray-core/main/distro/all"
+	"go.uber.org/zap"
+)
+
+// vlessConfig returns a config with one VLESS inbound on port
+func vlessConfig(port int) string {
+	return fmt.Sprintf(`{
+		"inbounds": [{"tag": "VLESS", "listen": "127.0.0.1", "port": %d, "protocol": "vless",
+			"settings": {"clients": [], "decryption": "none"}}],
+		"outbounds": [{"tag": "DIRECT", "protocol": "freedom"}]
+	}`, port)
+}
+
+func TestReloadInbound_RestoresPreviousOnFailure(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	x := New(&Config{Logger: zap.NewNop()})
+	ctx := context.Background()
+	if err := x.Start(ctx, []byte(vlessConfig(port))); err != nil {
+		t.Fatal(err)
+	}
+	defer x.Stop()
+
+	user, err := CreateVlessUser("alice", "b831381d-6324-4d53-ad4f-8cda48b30811", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.AddUser(ctx, "VLESS", user); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rebuilt inbound cannot bind its port, which something else holds
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	x.mu.Lock()
+	x.config = []byte(vlessConfig(busy.Addr().(*net.TCPAddr).Port))
+	x.mu.Unlock()
+
+	err = x.ReloadInbound(ctx, "VLESS")
+	if err == nil || !strings.Contains(err.Error(), "kept the previous one") {
+		t.Fatalf("Expected the reload to fail and keep the previous inbound, got %v", err)
+	}
+
+	users, err := x.GetInboundUsers(ctx, "VLESS")
+	if err != nil || len(users) != 1 || users[0].Email != "alice" {
+		t.Errorf("Expected the previous inbound with its users, got %v (%v)", users, err)
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Expected the previous inbound to listen again, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestReloadInbound_KeepsRuntimeUserSet(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	x := New(&Config{Logger: zap.NewNop()})
+	ctx := context.Background()
+	config := strings.Replace(vlessConfig(port), `"clients": []`, `"clients": [
+		{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "kept"},
+		{"id": "0b8e2a6c-7d3f-4e1a-8c5b-9f2d4e6a8b1c", "email": "revoked"}]`, 1)
+	if err := x.Start(ctx, []byte(config)); err != nil {
+		t.Fatal(err)
+	}
+	defer x.Stop()
+
+	emails := func() []string {
+		users, err := x.GetInboundUsers(ctx, "VLESS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Email)
+		}
+		return names
+	}
+
+	if err := x.RemoveUser(ctx, "VLESS", "revoked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ReloadInbound(ctx, "VLESS"); err != nil {
+		t.Fatal(err)
+	}
+	if names := emails(); len(names) != 1 || names[0] != "kept" {
+		t.Errorf("Expected only kept after the reload, got %v", names)
+	}
+
+	// With every user removed at runtime none of the config users come back
+	if err := x.RemoveUser(ctx, "VLESS", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.ReloadInbound(ctx, "VLESS"); err != nil {
+		t.Fatal(err)
+	}
+	if names := emails(); len(names) != 0 {
+		t.Errorf("Expected no users after the reload, got %v", names)
+	}
+}
+
+func TestOrphanedUserStats(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	x := New(&Config{Logger: zap.NewNop()})
+	ctx := context.Background()
+	config := strings.Replace(vlessConfig(port), "{", `{"stats": {},`, 1)
+	if err := x.Start(ctx, []byte(config)); err != nil {
+		t.Fatal(err)
+	}
+	defer x.Stop()
+
+	// Counters are registered by the first connection; set them up directly
+	x.mu.RLock()
+	manager := x.instance.GetFeature(stats.ManagerType()).(stats.Manager)
+	x.mu.RUnlock()
+	for i, email := range []string{"alice", "bob", "carol"} {
+		user, err := CreateVlessUser(email, fmt.Sprintf("b831381d-6324-4d53-ad4f-8cda48b3081%d", i), "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := x.AddUser(ctx, "VLESS", user); err != nil {
+			t.Fatal(err)
+		}
+		for _, direction := range []string{"uplink", "downlink"} {
+			counter, err := manager.RegisterCounter("user>>>" + email + ">>>traffic>>>" + direction)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counter.Set(100)
+		}
+	}
+
+	// Bob is removed and added back, so only alice lost every inbound
+	if err := x.RemoveUser(ctx, "VLESS", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.RemoveUser(ctx, "VLESS", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := CreateVlessUser("bob", "b831381d-6324-4d53-ad4f-8cda48b30811", "", 0)
+	if err := x.AddUser(ctx, "VLESS", bob); err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned, err := x.OrphanedUserStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphaned) != 1 || orphaned[0].Email != "alice" || orphaned[0].Uplink != 100 || orphaned[0].Downlink != 100 {
+		t.Fatalf("Expected alice's counters as orphaned, got %+v", orphaned)
+	}
+
+	// Counters drained by a reset are dropped together with the counter
+	if _, err := x.GetUserStats(ctx, "alice", true); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"user>>>alice>>>traffic>>>uplink", "user>>>alice>>>traffic>>>downlink"}
+	if err := x.UnregisterStats(ctx, names); err != nil {
+		t.Fatal(err)
+	}
+	counters, err := x.GetCumulativeStats(ctx, "user>>>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := counters[names[0]]; exists || counters["user>>>carol>>>traffic>>>uplink"] != 100 {
+		t.Errorf("Expected only alice's counters to be gone, got %v", counters)
+	}
+	x.drainedMu.Lock()
+	drained := x.drained[names[0]]
+	x.drainedMu.Unlock()
+	if drained != 0 {
+		t.Errorf("Expected the drained amount to go with the counter, got %d", drained)
+	}
+
+	// With its counters gone, alice has nothing left to report or drop
+	if orphaned, err := x.OrphanedUserStats(ctx); err != nil || len(orphaned) != 0 {
+		t.Errorf("Expected no orphaned users after unregistering, got %+v (%v)", orphaned, err)
+	}
+	x.removedMu.Lock()
+	_, remembered := x.removedUsers["alice"]
+	x.removedMu.Unlock()
+	if remembered {
+		t.Error("Expected alice to be forgotten once the counters were dropped")
+	}
+}